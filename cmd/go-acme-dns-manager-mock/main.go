@@ -5,12 +5,17 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/oetiker/go-acme-dns-manager/pkg/acmetest"
 	"github.com/oetiker/go-acme-dns-manager/pkg/app"
 	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
 	"github.com/oetiker/go-acme-dns-manager/pkg/manager/test_helpers"
 	"github.com/oetiker/go-acme-dns-manager/pkg/manager/test_mocks"
 )
@@ -19,46 +24,68 @@ import (
 var version = "mock-version"
 
 // main creates and runs the mock version of go-acme-dns-manager
-// This binary always runs in mock mode with internal mock servers
+// This binary always runs in mock mode with internal mock servers, or with
+// -pebble against a local Pebble ACME server and an in-memory acme-dns
 func main() {
-	fmt.Println("🧪 Starting go-acme-dns-manager MOCK VERSION")
-	fmt.Println("📡 All ACME operations will be mocked - no real network calls!")
-
-	// Start mock servers
-	fmt.Println("🚀 Starting mock ACME-DNS server...")
-	mockAcmeDns := test_mocks.NewMockAcmeDnsServer()
-	defer func() {
-		fmt.Println("🛑 Shutting down mock ACME-DNS server...")
-		mockAcmeDns.Close()
-	}()
-
-	fmt.Println("🚀 Starting mock ACME server...")
-	mockAcme := test_mocks.NewMockAcmeServer()
-	defer func() {
-		fmt.Println("🛑 Shutting down mock ACME server...")
-		mockAcme.Close()
-	}()
-
-	fmt.Printf("📍 Mock ACME-DNS server running at: %s\n", mockAcmeDns.GetURL())
-	fmt.Printf("📍 Mock ACME server running at: %s/directory\n", mockAcme.GetURL())
-
-	// Replace the default Lego runner with mock implementation
-	fmt.Println("🔧 Configuring mock certificate operations...")
-	app.DefaultLegoRunner = test_helpers.MockLegoRun
-
 	// Create application with dependency injection
 	application := app.NewApplication(version)
 
 	// Setup command line flags
 	application.SetupFlags()
+	pebbleMode := flag.Bool("pebble", false, "Mock only: issue real certificates from a local Pebble ACME server (pebble binary in PATH or PEBBLE_BIN) and an in-memory acme-dns server")
+	scenario := flag.String("scenario", "", "Mock only: inject a deterministic fault into every certificate request: "+strings.Join(test_helpers.Scenarios, ", "))
 
 	// Parse flags and populate configuration
 	application.ParseFlags()
 
-	// Override server URLs to use our mock servers
-	if err := application.OverrideServersForMock(mockAcme.GetURL()+"/directory", mockAcmeDns.GetURL()); err != nil {
-		fmt.Fprintf(os.Stderr, "❌ Failed to configure mock servers: %v\n", err)
-		os.Exit(1)
+	fmt.Println("🧪 Starting go-acme-dns-manager MOCK VERSION")
+	if *pebbleMode && *scenario != "" {
+		fmt.Fprintln(os.Stderr, "❌ -scenario only works with the mock servers, not with -pebble")
+		os.Exit(app.ExitConfigError)
+	}
+	if *pebbleMode {
+		env := startPebbleEnv(application)
+		defer func() {
+			fmt.Println("🛑 Shutting down Pebble and the in-memory ACME-DNS server...")
+			env.Close()
+		}()
+	} else {
+		fmt.Println("📡 All ACME operations will be mocked - no real network calls!")
+
+		// Start mock servers
+		fmt.Println("🚀 Starting mock ACME-DNS server...")
+		mockAcmeDns := test_mocks.NewMockAcmeDnsServer()
+		defer func() {
+			fmt.Println("🛑 Shutting down mock ACME-DNS server...")
+			mockAcmeDns.Close()
+		}()
+
+		fmt.Println("🚀 Starting mock ACME server...")
+		mockAcme := test_mocks.NewMockAcmeServer()
+		defer func() {
+			fmt.Println("🛑 Shutting down mock ACME server...")
+			mockAcme.Close()
+		}()
+
+		fmt.Printf("📍 Mock ACME-DNS server running at: %s\n", mockAcmeDns.GetURL())
+		fmt.Printf("📍 Mock ACME server running at: %s/directory\n", mockAcme.GetURL())
+
+		// Replace the default Lego runner with mock implementation
+		fmt.Println("🔧 Configuring mock certificate operations...")
+		app.DefaultLegoRunner = test_helpers.MockLegoRunContext
+		if *scenario != "" {
+			dnsServer := startScenario(application, *scenario)
+			defer func() {
+				fmt.Println("🛑 Shutting down in-memory DNS server...")
+				dnsServer.Close()
+			}()
+		}
+
+		// Override server URLs to use our mock servers
+		if err := application.OverrideServersForMock(mockAcme.GetURL()+"/directory", mockAcmeDns.GetURL()); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Failed to configure mock servers: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
 	// Create context for cancellation/timeout support
@@ -72,8 +99,13 @@ func main() {
 
 	// Run the application with enhanced error handling and graceful shutdown
 	if err := application.Run(ctx); err != nil {
-		handleApplicationError(err)
-		os.Exit(1)
+		// The -check plugin output and the DNS instructions already
+		// describe the problem
+		var checkErr *app.CheckError
+		if !errors.As(err, &checkErr) && !errors.Is(err, manager.ErrDNSSetupNeeded) {
+			handleApplicationError(err)
+		}
+		os.Exit(app.ExitCode(err))
 	}
 
 	fmt.Println("✅ Mock application completed successfully!")
@@ -82,6 +114,67 @@ func main() {
 	application.WaitForShutdown()
 }
 
+// startPebbleEnv starts Pebble and the in-memory acme-dns server and points
+// the configuration at them. The _acme-challenge CNAME records of all
+// acme-dns accounts are published as soon as the accounts are registered,
+// run with -wait-for-dns to get past the DNS setup of new accounts.
+func startPebbleEnv(application *app.Application) *acmetest.Env {
+	fmt.Println("🚀 Starting Pebble and the in-memory ACME-DNS server...")
+	env, err := acmetest.Start(acmetest.PebbleOptions{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to start Pebble: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("📍 Pebble running at: %s\n", env.Pebble.DirectoryURL)
+	fmt.Printf("📍 ACME-DNS server running at: %s, DNS on %s\n", env.AcmeDNS.URL, env.AcmeDNS.DNSAddr)
+
+	if err := application.OverrideServersForMock(env.Pebble.DirectoryURL, env.AcmeDNS.URL); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to configure Pebble: %v\n", err)
+		env.Close()
+		os.Exit(1)
+	}
+	application.SetMockConfigHook(func(cfg *manager.Config) {
+		env.Configure(cfg)
+		if err := env.PublishCNAMEs(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Failed to read the acme-dns accounts: %v\n", err)
+		}
+	})
+	return env
+}
+
+// startScenario replaces the mock Lego runner with one failing as the
+// scenario describes. The CNAME checks are answered by an in-memory DNS
+// server publishing the records of all acme-dns accounts, so the faults are
+// reached with new certificates too; run with -wait-for-dns to get past the
+// DNS setup of new accounts.
+func startScenario(application *app.Application, scenario string) *acmetest.AcmeDNS {
+	runner, err := test_helpers.ScenarioLegoRunner(scenario, test_helpers.MockLegoRunContext)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(app.ExitConfigError)
+	}
+	fmt.Printf("💥 Scenario %s: injecting faults into certificate requests\n", scenario)
+	app.DefaultLegoRunner = app.LegoRunnerFunc(runner)
+
+	dnsServer, err := acmetest.StartAcmeDNS()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to start the in-memory DNS server: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("📍 In-memory DNS server for the CNAME checks running at: %s\n", dnsServer.DNSAddr)
+	env := &acmetest.Env{AcmeDNS: dnsServer}
+	application.SetMockConfigHook(func(cfg *manager.Config) {
+		cfg.DnsResolver = dnsServer.DNSAddr
+		if cfg.DNSPrecheck != nil {
+			cfg.DNSPrecheck.ExternalResolver = ""
+		}
+		if err := env.PublishCNAMEs(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Failed to read the acme-dns accounts: %v\n", err)
+		}
+	})
+	return dnsServer
+}
+
 // handleApplicationError provides user-friendly error messages and debugging information
 // This is identical to the production version but with mock context
 func handleApplicationError(err error) {
@@ -106,6 +199,10 @@ func handleApplicationError(err error) {
 			fmt.Fprintf(os.Stderr, "\n🔍 DNS Help (Mock Mode):\n")
 			fmt.Fprintf(os.Stderr, "   DNS operations are mocked - this shouldn't happen\n")
 			fmt.Fprintf(os.Stderr, "   Check mock DNS resolver configuration\n")
+		case common.ErrorTypeRateLimit:
+			fmt.Fprintf(os.Stderr, "\n⏳ Rate Limit Help (Mock Mode):\n")
+			fmt.Fprintf(os.Stderr, "   The affected certificates are retried on the next run\n")
+			fmt.Fprintf(os.Stderr, "   Tune the 'retry' section of the config file to wait longer\n")
 		case common.ErrorTypeValidation:
 			fmt.Fprintf(os.Stderr, "\n✅ Validation Help:\n")
 			fmt.Fprintf(os.Stderr, "   Check command line arguments and flags\n")
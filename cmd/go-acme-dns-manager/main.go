@@ -2,12 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"time"
 
 	"github.com/oetiker/go-acme-dns-manager/pkg/app"
 	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
 )
 
 // Version information (this will be replaced during build)
@@ -25,21 +26,28 @@ func main() {
 	// Parse flags and populate configuration
 	application.ParseFlags()
 
-	// Create context for cancellation/timeout support
-	ctx := context.Background()
-
-	// Add overall application timeout (30 minutes max)
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Minute)
+	// One-shot runs end after 30 minutes, a daemon limits each pass instead
+	ctx, cancel := application.RunContext(context.Background())
 	defer cancel()
 
 	// Run the application with enhanced error handling and graceful shutdown
 	if err := application.Run(ctx); err != nil {
-		handleApplicationError(err)
-		os.Exit(1)
+		// The -check plugin output and the DNS instructions already
+		// describe the problem
+		var checkErr *app.CheckError
+		if !errors.As(err, &checkErr) && !errors.Is(err, manager.ErrDNSSetupNeeded) {
+			handleApplicationError(err)
+		}
+		os.Exit(app.ExitCode(err))
 	}
 
 	// Wait for graceful shutdown if needed
 	application.WaitForShutdown()
+
+	// -changed-exit-code tells configuration management about changes
+	if code := application.SuccessExitCode(); code != app.ExitOK {
+		os.Exit(code)
+	}
 }
 
 // handleApplicationError provides user-friendly error messages and debugging information
@@ -64,6 +72,10 @@ func handleApplicationError(err error) {
 			fmt.Fprintf(os.Stderr, "\n🔍 DNS Help:\n")
 			fmt.Fprintf(os.Stderr, "   Use 'dig' or 'nslookup' to verify DNS records\n")
 			fmt.Fprintf(os.Stderr, "   Check CNAME record configuration\n")
+		case common.ErrorTypeRateLimit:
+			fmt.Fprintf(os.Stderr, "\n⏳ Rate Limit Help:\n")
+			fmt.Fprintf(os.Stderr, "   The affected certificates are retried on the next run\n")
+			fmt.Fprintf(os.Stderr, "   Tune the 'retry' section of the config file to wait longer\n")
 		case common.ErrorTypeValidation:
 			fmt.Fprintf(os.Stderr, "\n✅ Validation Help:\n")
 			fmt.Fprintf(os.Stderr, "   Check command line arguments and flags\n")
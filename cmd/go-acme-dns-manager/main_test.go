@@ -574,7 +574,7 @@ func TestMain_Subprocess(t *testing.T) {
 		{
 			name:       "Invalid Config Path",
 			args:       []string{"-config", "/nonexistent/config.yaml", "-auto"},
-			expectExit: 1,
+			expectExit: 4,
 			expectErr:  "Application Error",
 			timeout:    10 * time.Second,
 		},
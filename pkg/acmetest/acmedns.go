@@ -0,0 +1,282 @@
+// Package acmetest runs the servers the certificate workflow talks to, for
+// end-to-end tests without Let's Encrypt staging: an in-memory acme-dns
+// server with its own DNS server, and a Pebble ACME server validating the
+// DNS-01 challenges against it.
+//
+// Pebble is not linked in, StartPebble runs the pebble binary from PATH or
+// PEBBLE_BIN. Tests using New are skipped if it is not installed.
+package acmetest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// AcmeDNSZone is the zone the accounts of AcmeDNS live in
+const AcmeDNSZone = "acme-dns.test"
+
+// acmeDNSAccount is an account registered with AcmeDNS
+type acmeDNSAccount struct {
+	Username   string   `json:"username"`
+	Password   string   `json:"password"`
+	Subdomain  string   `json:"subdomain"`
+	FullDomain string   `json:"fulldomain"`
+	AllowFrom  []string `json:"allowfrom"`
+}
+
+// AcmeDNS is an in-memory acme-dns server. It offers the HTTP API of
+// acme-dns (register, update, health) and answers DNS queries for the TXT
+// records of its accounts and for the CNAME records added with AddCNAME.
+type AcmeDNS struct {
+	URL     string // Base URL of the HTTP API
+	DNSAddr string // host:port of the DNS server, UDP and TCP
+
+	httpServer *httptest.Server
+	udpServer  *dns.Server
+	tcpServer  *dns.Server
+
+	mu        sync.Mutex
+	accounts  map[string]acmeDNSAccount // By username
+	txt       map[string][]string       // By fulldomain, the last two values like acme-dns
+	cnames    map[string]string         // Name -> target
+	cnameFunc func(name string) (string, bool)
+}
+
+// StartAcmeDNS starts an AcmeDNS server on the loopback interface
+func StartAcmeDNS() (*AcmeDNS, error) {
+	a := &AcmeDNS{
+		accounts: make(map[string]acmeDNSAccount),
+		txt:      make(map[string][]string),
+		cnames:   make(map[string]string),
+	}
+
+	packetConn, listener, err := listenDNS()
+	if err != nil {
+		return nil, err
+	}
+	a.DNSAddr = packetConn.LocalAddr().String()
+	handler := dns.HandlerFunc(a.serveDNS)
+	a.udpServer = &dns.Server{PacketConn: packetConn, Handler: handler}
+	a.tcpServer = &dns.Server{Listener: listener, Handler: handler}
+	for _, server := range []*dns.Server{a.udpServer, a.tcpServer} {
+		go func(server *dns.Server) { _ = server.ActivateAndServe() }(server)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register", a.handleRegister)
+	mux.HandleFunc("/update", a.handleUpdate)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
+	a.httpServer = httptest.NewServer(mux)
+	a.URL = a.httpServer.URL
+	return a, nil
+}
+
+// listenDNS opens a UDP and a TCP listener on the same loopback port
+func listenDNS() (net.PacketConn, net.Listener, error) {
+	var lastErr error
+	for i := 0; i < 10; i++ {
+		packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return nil, nil, err
+		}
+		listener, err := net.Listen("tcp", packetConn.LocalAddr().String())
+		if err == nil {
+			return packetConn, listener, nil
+		}
+		_ = packetConn.Close()
+		lastErr = err
+	}
+	return nil, nil, fmt.Errorf("listening for DNS: %w", lastErr)
+}
+
+// Close stops the HTTP and DNS servers
+func (a *AcmeDNS) Close() {
+	a.httpServer.Close()
+	_ = a.udpServer.Shutdown()
+	_ = a.tcpServer.Shutdown()
+}
+
+// AddAccount makes an account known that was registered before, e.g. with
+// an AcmeDNS of an earlier run whose accounts are still in the account store
+func (a *AcmeDNS) AddAccount(username, password, fullDomain string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	subdomain, _, _ := strings.Cut(fullDomain, ".")
+	a.accounts[username] = acmeDNSAccount{
+		Username:   username,
+		Password:   password,
+		Subdomain:  subdomain,
+		FullDomain: canonicalName(fullDomain),
+	}
+}
+
+// AddCNAME publishes a CNAME record, e.g. _acme-challenge.example.com
+// pointing to the fulldomain of an account
+func (a *AcmeDNS) AddCNAME(name, target string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cnames[canonicalName(name)] = canonicalName(target)
+}
+
+// SetCNAMEFunc sets a function answering CNAME queries for names without a
+// record added by AddCNAME, e.g. to publish the _acme-challenge records of
+// all accounts in an account store as they are registered
+func (a *AcmeDNS) SetCNAMEFunc(f func(name string) (target string, ok bool)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cnameFunc = f
+}
+
+// TXT returns the TXT values currently published for a fulldomain
+func (a *AcmeDNS) TXT(fullDomain string) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.txt[canonicalName(fullDomain)]...)
+}
+
+// canonicalName lowercases a DNS name and removes the trailing dot
+func canonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// randomString returns n random hex digits
+func randomString(n int) string {
+	b := make([]byte, (n+1)/2)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)[:n]
+}
+
+// handleRegister creates an account like POST /register of acme-dns
+func (a *AcmeDNS) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var request struct {
+		AllowFrom []string `json:"allowfrom"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&request)
+
+	subdomain := randomString(32)
+	account := acmeDNSAccount{
+		Username:   randomString(32),
+		Password:   randomString(40),
+		Subdomain:  subdomain,
+		FullDomain: subdomain + "." + AcmeDNSZone,
+		AllowFrom:  request.AllowFrom,
+	}
+	if account.AllowFrom == nil {
+		account.AllowFrom = []string{}
+	}
+	a.mu.Lock()
+	a.accounts[account.Username] = account
+	a.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(account)
+}
+
+// handleUpdate sets a TXT record like POST /update of acme-dns
+func (a *AcmeDNS) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var request struct {
+		Subdomain string `json:"subdomain"`
+		TXT       string `json:"txt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "malformed_json_payload"}`, http.StatusBadRequest)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	account, ok := a.accounts[r.Header.Get("X-Api-User")]
+	if !ok || account.Password != r.Header.Get("X-Api-Key") || account.Subdomain != request.Subdomain {
+		http.Error(w, `{"error": "forbidden"}`, http.StatusUnauthorized)
+		return
+	}
+	values := append(a.txt[account.FullDomain], request.TXT)
+	if len(values) > 2 {
+		values = values[len(values)-2:]
+	}
+	a.txt[account.FullDomain] = values
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"txt": request.TXT})
+}
+
+// serveDNS answers CNAME and TXT queries, following CNAMEs within the data
+// of the server
+func (a *AcmeDNS) serveDNS(w dns.ResponseWriter, request *dns.Msg) {
+	response := new(dns.Msg)
+	response.SetReply(request)
+	response.Authoritative = true
+
+	a.mu.Lock()
+	for _, question := range request.Question {
+		answers, found := a.answer(question)
+		response.Answer = append(response.Answer, answers...)
+		if !found {
+			response.Rcode = dns.RcodeNameError
+		}
+	}
+	a.mu.Unlock()
+	_ = w.WriteMsg(response)
+}
+
+// answer returns the records for one question and whether the name exists.
+// The caller holds mu.
+func (a *AcmeDNS) answer(question dns.Question) ([]dns.RR, bool) {
+	var answers []dns.RR
+	name := canonicalName(question.Name)
+	for hops := 0; hops < 8; hops++ {
+		target, ok := a.cnames[name]
+		if !ok && a.cnameFunc != nil {
+			target, ok = a.cnameFunc(name)
+			target = canonicalName(target)
+		}
+		if !ok {
+			break
+		}
+		answers = append(answers, &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: dns.Fqdn(name), Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 0},
+			Target: dns.Fqdn(target),
+		})
+		if question.Qtype == dns.TypeCNAME {
+			return answers, true
+		}
+		name = target
+	}
+
+	values, isAccount := a.txt[name]
+	if !isAccount {
+		for _, account := range a.accounts {
+			if account.FullDomain == name {
+				isAccount = true
+				break
+			}
+		}
+	}
+	if question.Qtype == dns.TypeTXT {
+		for _, value := range values {
+			answers = append(answers, &dns.TXT{
+				Hdr: dns.RR_Header{Name: dns.Fqdn(name), Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
+				Txt: []string{value},
+			})
+		}
+	}
+	return answers, isAccount || len(answers) > 0
+}
@@ -0,0 +1,162 @@
+package acmetest
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/nrdcg/goacmedns"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certmanager"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+func TestAcmeDNS(t *testing.T) {
+	a, err := StartAcmeDNS()
+	if err != nil {
+		t.Fatalf("StartAcmeDNS failed: %v", err)
+	}
+	defer a.Close()
+
+	client, err := goacmedns.NewClient(a.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	account, err := client.RegisterAccount(ctx, nil)
+	if err != nil {
+		t.Fatalf("RegisterAccount failed: %v", err)
+	}
+	for _, value := range []string{"first", "second", "third"} {
+		if err := client.UpdateTXTRecord(ctx, account, value); err != nil {
+			t.Fatalf("UpdateTXTRecord failed: %v", err)
+		}
+	}
+	if txt := a.TXT(account.FullDomain); len(txt) != 2 || txt[0] != "second" || txt[1] != "third" {
+		t.Errorf("Expected the last two values like acme-dns, got %v", txt)
+	}
+	account.Password = "wrong"
+	if err := client.UpdateTXTRecord(ctx, account, "forged"); err == nil {
+		t.Error("Expected an update with a wrong key to fail")
+	}
+
+	// The challenge record follows the CNAME to the account
+	a.AddCNAME("_acme-challenge.Example.com.", account.FullDomain)
+	for _, network := range []string{"udp", "tcp"} {
+		msg := new(dns.Msg)
+		msg.SetQuestion("_acme-challenge.example.com.", dns.TypeTXT)
+		response, _, err := (&dns.Client{Net: network}).Exchange(msg, a.DNSAddr)
+		if err != nil {
+			t.Fatalf("%s query failed: %v", network, err)
+		}
+		if len(response.Answer) != 3 || response.Answer[0].Header().Rrtype != dns.TypeCNAME {
+			t.Errorf("Expected the CNAME and two TXT records over %s, got %v", network, response.Answer)
+		}
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("_acme-challenge.unknown.example.", dns.TypeCNAME)
+	response, _, err := new(dns.Client).Exchange(msg, a.DNSAddr)
+	if err != nil || response.Rcode != dns.RcodeNameError {
+		t.Errorf("Expected NXDOMAIN for an unknown name, got %v (%v)", response, err)
+	}
+}
+
+func TestEnv_PublishCNAMEs(t *testing.T) {
+	a, err := StartAcmeDNS()
+	if err != nil {
+		t.Fatalf("StartAcmeDNS failed: %v", err)
+	}
+	defer a.Close()
+
+	cfg := &manager.Config{CertStoragePath: t.TempDir(), DnsResolver: a.DNSAddr}
+	env := &Env{AcmeDNS: a}
+	if err := env.PublishCNAMEs(cfg); err != nil {
+		t.Fatalf("PublishCNAMEs failed: %v", err)
+	}
+
+	// Registered after publishing, the record appears right away
+	store, err := manager.NewConfigAccountStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetAccount("example.com", manager.AcmeDnsAccount{Username: "u", Password: "p", FullDomain: "abc." + AcmeDNSZone})
+	if err := store.SaveAccounts(); err != nil {
+		t.Fatal(err)
+	}
+	target, err := manager.NewPrecheckResolver(cfg).LookupCNAME(context.Background(), "_acme-challenge.example.com")
+	if err != nil || target != "abc."+AcmeDNSZone+"." {
+		t.Errorf("Expected the CNAME of the stored account, got %q (%v)", target, err)
+	}
+}
+
+func TestEnv_WriteConfig(t *testing.T) {
+	a, err := StartAcmeDNS()
+	if err != nil {
+		t.Fatalf("StartAcmeDNS failed: %v", err)
+	}
+	defer a.Close()
+	dir := t.TempDir()
+	pebble := &Pebble{DirectoryURL: "https://127.0.0.1:14000/dir", CAFile: filepath.Join(dir, "cert.pem")}
+	if err := writeTLSCertificate(pebble.CAFile, filepath.Join(dir, "key.pem")); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := (&Env{Pebble: pebble, AcmeDNS: a}).WriteConfig(dir)
+	if err != nil {
+		t.Fatalf("WriteConfig failed: %v", err)
+	}
+	cfg, err := manager.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Expected a valid configuration: %v", err)
+	}
+	if cfg.AcmeServer != pebble.DirectoryURL || cfg.AcmeDnsServer != a.URL || cfg.PrecheckResolverAddress() != a.DNSAddr || cfg.CertStoragePath != filepath.Join(dir, "storage") {
+		t.Errorf("Unexpected configuration %+v", cfg)
+	}
+}
+
+func TestStartPebble_Exits(t *testing.T) {
+	t.Setenv("PEBBLE_BIN", "/bin/false")
+	if _, err := StartPebble(PebbleOptions{}); err == nil || !strings.Contains(err.Error(), "exited during startup") {
+		t.Errorf("Expected a failing pebble to be reported, got %v", err)
+	}
+}
+
+func TestEnv_IssueCertificate(t *testing.T) {
+	env := New(t)
+	path, err := env.WriteConfig(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := certmanager.NewFromConfigFile(path)
+	if err != nil {
+		t.Fatalf("Loading the config failed: %v", err)
+	}
+	req := certmanager.Request{Name: "web", Domains: []string{"example.com", "*.example.com"}}
+	ctx := context.Background()
+
+	res, err := m.EnsureCertificate(ctx, req)
+	if !errors.Is(err, certmanager.ErrDNSSetupNeeded) {
+		t.Fatalf("Expected the new acme-dns account to need a CNAME, got %v", err)
+	}
+	for _, record := range res.DNSSetup {
+		env.AcmeDNS.AddCNAME(record.ChallengeDomain, record.TargetDomain)
+	}
+	res, err = m.EnsureCertificate(ctx, req)
+	if err != nil {
+		t.Fatalf("EnsureCertificate failed: %v\nPebble:\n%s", err, env.Pebble.Output())
+	}
+	if res.Action != manager.ActionInit {
+		t.Errorf("Expected %s, got %s", manager.ActionInit, res.Action)
+	}
+	info, err := certinfo.Load(res.Paths.Certificate)
+	if err != nil {
+		t.Fatalf("Loading the certificate failed: %v", err)
+	}
+	if missing, _ := info.CompareDomains(req.Domains); len(missing) > 0 {
+		t.Errorf("Certificate lacks %v", missing)
+	}
+}
@@ -0,0 +1,128 @@
+package acmetest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+// Env is a Pebble ACME server validating DNS-01 challenges against an
+// in-memory acme-dns server
+type Env struct {
+	Pebble  *Pebble
+	AcmeDNS *AcmeDNS
+}
+
+// Start starts AcmeDNS and a Pebble resolving the challenges through it
+func Start(opts PebbleOptions) (*Env, error) {
+	acmeDNS, err := StartAcmeDNS()
+	if err != nil {
+		return nil, fmt.Errorf("starting acme-dns: %w", err)
+	}
+	opts.DNSServer = acmeDNS.DNSAddr
+	pebble, err := StartPebble(opts)
+	if err != nil {
+		acmeDNS.Close()
+		return nil, err
+	}
+	return &Env{Pebble: pebble, AcmeDNS: acmeDNS}, nil
+}
+
+// New starts an Env for a test and stops it when the test ends. The test is
+// skipped if pebble is not installed.
+func New(t testing.TB) *Env {
+	t.Helper()
+	env, err := Start(PebbleOptions{})
+	if errors.Is(err, ErrPebbleNotFound) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatalf("acmetest: %v", err)
+	}
+	t.Cleanup(env.Close)
+	return env
+}
+
+// Close stops both servers
+func (e *Env) Close() {
+	e.Pebble.Close()
+	e.AcmeDNS.Close()
+}
+
+// Configure points a configuration at the servers of the Env: the ACME and
+// acme-dns servers, the resolver for the CNAME checks and the HTTPS
+// certificate of Pebble
+func (e *Env) Configure(cfg *manager.Config) {
+	cfg.AcmeServer = e.Pebble.DirectoryURL
+	cfg.AcmeDnsServer = e.AcmeDNS.URL
+	for suffix := range cfg.AcmeDnsServers {
+		cfg.AcmeDnsServers[suffix] = e.AcmeDNS.URL
+	}
+	if cfg.AutoDomains != nil {
+		for name, certCfg := range cfg.AutoDomains.Certs {
+			if certCfg.AcmeServer != "" {
+				certCfg.AcmeServer = e.Pebble.DirectoryURL
+				cfg.AutoDomains.Certs[name] = certCfg
+			}
+		}
+	}
+	cfg.DNSChallengeProvider = ""
+	cfg.DnsResolver = e.AcmeDNS.DNSAddr
+	if cfg.DNSPrecheck != nil {
+		cfg.DNSPrecheck.ExternalResolver = ""
+	}
+	cfg.CABundlePath = e.Pebble.CAFile
+}
+
+// WriteConfig writes a minimal configuration file for the Env to dir,
+// storing certificates below dir/storage, and returns its path
+func (e *Env) WriteConfig(dir string) (string, error) {
+	config := fmt.Sprintf(`email: "test@example.com"
+acme_server: %q
+acme_dns_server: %q
+dns_resolver: %q
+ca_bundle_path: %q
+cert_storage_path: "storage"
+`, e.Pebble.DirectoryURL, e.AcmeDNS.URL, e.AcmeDNS.DNSAddr, e.Pebble.CAFile)
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// PublishCNAMEs plays the zone owner: the _acme-challenge CNAME records of
+// all acme-dns accounts in the account store of cfg exist as soon as the
+// accounts are registered, so no DNS setup is needed. Accounts already in
+// the store, e.g. from an earlier run, are made known to AcmeDNS.
+func (e *Env) PublishCNAMEs(cfg *manager.Config) error {
+	store, err := manager.NewConfigAccountStore(cfg)
+	if err != nil {
+		return err
+	}
+	for _, account := range store.GetAllAccounts() {
+		e.AcmeDNS.AddAccount(account.Username, account.Password, account.FullDomain)
+	}
+	e.AcmeDNS.SetCNAMEFunc(func(name string) (string, bool) {
+		domain, ok := strings.CutPrefix(name, "_acme-challenge.")
+		if !ok {
+			return "", false
+		}
+		// Read again, the account may have been registered just now
+		store, err := manager.NewConfigAccountStore(cfg)
+		if err != nil {
+			return "", false
+		}
+		account, _, found := cfg.LookupAcmeDNSAccount(store, domain)
+		if !found {
+			return "", false
+		}
+		return account.FullDomain, true
+	})
+	return nil
+}
@@ -0,0 +1,273 @@
+package acmetest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrPebbleNotFound is returned by StartPebble if there is no pebble binary
+var ErrPebbleNotFound = errors.New("pebble binary not found, install github.com/letsencrypt/pebble or set PEBBLE_BIN")
+
+// PebbleStartTimeout limits how long StartPebble waits for the directory
+const PebbleStartTimeout = 15 * time.Second
+
+// PebbleOptions configures StartPebble
+type PebbleOptions struct {
+	// DNSServer is the host:port Pebble resolves the DNS-01 challenges
+	// with, usually AcmeDNS.DNSAddr
+	DNSServer string
+
+	// ListenAddress of the ACME API, default a free loopback port. A fixed
+	// address keeps the directory URL, and with it the account directory of
+	// the manager, the same across runs.
+	ListenAddress string
+}
+
+// Pebble is a running Pebble ACME test server. Everything it knows, its
+// accounts and its CA, is gone when it is closed.
+type Pebble struct {
+	DirectoryURL string // ACME directory URL
+	CAFile       string // PEM certificate of the HTTPS listener, use as ca_bundle_path
+
+	managementURL string
+	dir           string
+	cmd           *exec.Cmd
+	output        *syncBuffer
+	exited        chan struct{}
+}
+
+// pebbleBinary returns the pebble binary from PEBBLE_BIN or PATH
+func pebbleBinary() (string, error) {
+	if bin := os.Getenv("PEBBLE_BIN"); bin != "" {
+		return bin, nil
+	}
+	bin, err := exec.LookPath("pebble")
+	if err != nil {
+		return "", ErrPebbleNotFound
+	}
+	return bin, nil
+}
+
+// StartPebble runs Pebble with a fresh configuration and HTTPS certificate
+// in a temporary directory and waits until its directory responds.
+// Challenges are validated without Pebble's random delays and every order
+// needs new authorizations.
+func StartPebble(opts PebbleOptions) (*Pebble, error) {
+	bin, err := pebbleBinary()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "acmetest-pebble-")
+	if err != nil {
+		return nil, err
+	}
+	p := &Pebble{dir: dir, output: &syncBuffer{}, exited: make(chan struct{})}
+	if err := p.start(bin, opts); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Pebble) start(bin string, opts PebbleOptions) error {
+	listen := opts.ListenAddress
+	if listen == "" {
+		listen = freeAddress()
+	}
+	management := freeAddress()
+	p.DirectoryURL = "https://" + listen + "/dir"
+	p.managementURL = "https://" + management
+
+	certFile, keyFile := filepath.Join(p.dir, "cert.pem"), filepath.Join(p.dir, "key.pem")
+	if err := writeTLSCertificate(certFile, keyFile); err != nil {
+		return fmt.Errorf("creating Pebble HTTPS certificate: %w", err)
+	}
+	p.CAFile = certFile
+
+	config := map[string]any{"pebble": map[string]any{
+		"listenAddress":                  listen,
+		"managementListenAddress":        management,
+		"certificate":                    certFile,
+		"privateKey":                     keyFile,
+		"httpPort":                       5002,
+		"tlsPort":                        5001,
+		"ocspResponderURL":               "",
+		"externalAccountBindingRequired": false,
+		"profiles": map[string]any{
+			"default": map[string]any{"description": "Default profile", "validityPeriod": 7776000},
+		},
+	}}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	configFile := filepath.Join(p.dir, "pebble-config.json")
+	if err := os.WriteFile(configFile, data, 0600); err != nil {
+		return err
+	}
+
+	args := []string{"-config", configFile}
+	if opts.DNSServer != "" {
+		args = append(args, "-dnsserver", opts.DNSServer)
+	}
+	p.cmd = exec.Command(bin, args...)
+	p.cmd.Env = append(os.Environ(), "PEBBLE_VA_NOSLEEP=1", "PEBBLE_WFE_NONCEREJECT=0", "PEBBLE_AUTHZREUSE=0")
+	p.cmd.Stdout, p.cmd.Stderr = p.output, p.output
+	if err := p.cmd.Start(); err != nil {
+		return fmt.Errorf("starting %s: %w", bin, err)
+	}
+	go func() {
+		_ = p.cmd.Wait()
+		close(p.exited)
+	}()
+	return p.waitReady()
+}
+
+// waitReady polls the directory until it answers or Pebble exits
+func (p *Pebble) waitReady() error {
+	client, err := p.HTTPClient()
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(PebbleStartTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-p.exited:
+			return fmt.Errorf("pebble exited during startup:\n%s", p.output.String())
+		default:
+		}
+		if resp, err := client.Get(p.DirectoryURL); err == nil {
+			_ = resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("pebble did not answer on %s within %s:\n%s", p.DirectoryURL, PebbleStartTimeout, p.output.String())
+}
+
+// HTTPClient returns a client trusting the HTTPS certificate of Pebble
+func (p *Pebble) HTTPClient() (*http.Client, error) {
+	pemData, err := os.ReadFile(p.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(pemData)
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}, nil
+}
+
+// RootCertificate returns the PEM root certificate Pebble issues from. It
+// changes with every start.
+func (p *Pebble) RootCertificate() ([]byte, error) {
+	client, err := p.HTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Get(p.managementURL + "/roots/0")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching Pebble root: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Output returns what Pebble logged so far
+func (p *Pebble) Output() string {
+	return p.output.String()
+}
+
+// Close stops Pebble and removes its temporary directory
+func (p *Pebble) Close() {
+	if p.cmd != nil && p.cmd.Process != nil {
+		_ = p.cmd.Process.Kill()
+		<-p.exited
+	}
+	_ = os.RemoveAll(p.dir)
+}
+
+// freeAddress returns a loopback address with a port that was free a moment ago
+func freeAddress() string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "127.0.0.1:14000"
+	}
+	defer func() { _ = listener.Close() }()
+	return listener.Addr().String()
+}
+
+// writeTLSCertificate writes a self-signed certificate for 127.0.0.1 and
+// localhost and its key
+func writeTLSCertificate(certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "acmetest Pebble"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+}
+
+// syncBuffer collects the output of Pebble for error messages
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -1,10 +1,13 @@
 package app
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -14,8 +17,10 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
 	"github.com/oetiker/go-acme-dns-manager/pkg/common"
 	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+	"github.com/oetiker/go-acme-dns-manager/pkg/metrics"
 )
 
 // Config holds application configuration
@@ -25,10 +30,61 @@ type Config struct {
 	QuietMode           bool
 	PrintConfigTemplate bool
 	DebugMode           bool
+	DebugACME           bool
 	LogLevel            string
 	LogFormat           string
 	ShowVersion         bool
 	Version             string
+	MetricsDump         bool
+	MetricsFormat       string
+	Fsck                bool
+	FsckRepair          bool
+	Diff                bool
+	Optimize            bool
+	VerifyStorage       bool
+	VerifyStorageRepair bool
+	FixPerms            bool
+	ImportCertbot       string
+	Adopt               string
+	Rollback            string
+	Delete              string
+	DeleteRevoke        bool
+	DeleteAcmeDNS       bool
+	OrphanScan          bool
+	PruneAcmeDNS        bool
+	MigrateAccounts     bool
+	DryRun              bool
+	AdoptCert           string
+	AdoptKey            string
+	AdoptChain          string
+	LockTimeout         time.Duration
+	RotateAccountKey    bool
+	TestAcmeDNS         bool
+	CheckOCSP           bool
+	PendingOrders       bool
+	History             string
+	Validate            bool
+	Init                bool
+	Check               bool
+	CheckWarningDays    int
+	CheckCriticalDays   int
+	DebugBundle         string
+	RotateAcmeDNS       string
+	WaitForDNS          bool
+	WaitForDNSTimeout   time.Duration
+	WaitForDNSInterval  time.Duration
+	Daemon              bool
+	DaemonInterval      time.Duration
+	Only                []string
+	Skip                []string
+	Force               bool
+	AcmeServer          string
+	AcmeDnsServer       string
+	StoragePath         string
+	Email               string
+	Set                 []string
+	Output              string
+	ChangedExitCode     bool
 }
 
 // Application represents the main application with dependency injection
@@ -39,6 +95,16 @@ type Application struct {
 	cancelFunc context.CancelFunc
 	done       chan struct{}
 	shutdownOnce sync.Once
+
+	// debugLog captures the log output for the -debug-bundle
+	debugLog *bytes.Buffer
+
+	// output collects the -output json document, nil for text output
+	output *jsonOutput
+
+	// changed is set when the command issued or renewed a certificate or
+	// otherwise changed the storage directory, see -changed-exit-code
+	changed bool
 }
 
 // Flags encapsulates command line flag parsing
@@ -48,9 +114,60 @@ type Flags struct {
 	quietMode           *bool
 	printConfigTemplate *bool
 	debugMode           *bool
+	debugACME           *bool
 	logLevel            *string
 	logFormat           *string
 	showVersion         *bool
+	metricsDump         *bool
+	metricsFormat       *string
+	fsck                *bool
+	fsckRepair          *bool
+	diff                *bool
+	optimize            *bool
+	verifyStorage       *bool
+	verifyStorageRepair *bool
+	fixPerms            *bool
+	importCertbot       *string
+	adopt               *string
+	rollback            *string
+	delete              *string
+	deleteRevoke        *bool
+	deleteAcmeDNS       *bool
+	orphanScan          *bool
+	pruneAcmeDNS        *bool
+	migrateAccounts     *bool
+	dryRun              *bool
+	adoptCert           *string
+	adoptKey            *string
+	adoptChain          *string
+	lockTimeout         *time.Duration
+	rotateAccountKey    *bool
+	testAcmeDNS         *bool
+	checkOCSP           *bool
+	pendingOrders       *bool
+	history             *string
+	validate            *bool
+	init                *bool
+	check               *bool
+	checkWarningDays    *int
+	checkCriticalDays   *int
+	debugBundle         *string
+	rotateAcmeDNS       *string
+	waitForDNS          *bool
+	waitForDNSTimeout   *time.Duration
+	waitForDNSInterval  *time.Duration
+	daemon              *bool
+	daemonInterval      *time.Duration
+	only                *string
+	skip                *string
+	force               *bool
+	acmeServer          *string
+	acmeDnsServer       *string
+	storage             *string
+	email               *string
+	set                 stringList
+	output              *string
+	changedExitCode     *bool
 }
 
 // NewApplication creates a new application instance
@@ -69,10 +186,62 @@ func (app *Application) SetupFlags() {
 	app.flags.quietMode = flag.Bool("quiet", false, "Reduce output in auto mode (useful for cron jobs)")
 	app.flags.printConfigTemplate = flag.Bool("print-config-template", false, "Print a default configuration template to stdout and exit")
 	app.flags.debugMode = flag.Bool("debug", false, "Enable debug logging")
+	app.flags.debugACME = flag.Bool("debug-acme", false, "Log every HTTP request and response exchanged with the ACME and acme-dns servers, credentials and signed bodies redacted (implies -debug)")
 	app.flags.logLevel = flag.String("log-level", "", "Set logging level (debug|info|warn|error), overrides -debug flag if specified")
 	app.flags.logFormat = flag.String("log-format", "", "Set logging format (go|emoji|color|ascii), overrides -no-color and -no-emoji flags")
 	app.flags.showVersion = flag.Bool("version", false, "Show version information and exit")
+	app.flags.metricsDump = flag.Bool("metrics-dump", false, "Print certificate, account and storage metrics to stdout and exit")
+	app.flags.metricsFormat = flag.String("metrics-format", metrics.FormatJSON, "Output format for -metrics-dump (json|openmetrics)")
+	app.flags.fsck = flag.Bool("fsck", false, "Check the storage directory against the checksum manifest and exit")
+	app.flags.fsckRepair = flag.Bool("fsck-repair", false, "With -fsck: regenerate missing certificate metadata and update the manifest to the current state")
+	app.flags.verifyStorage = flag.Bool("verify-storage", false, "Check that every stored certificate parses, has its matching key and metadata, and that file modes are private, then exit")
+	app.flags.verifyStorageRepair = flag.Bool("verify-storage-repair", false, "With -verify-storage: regenerate missing or broken metadata and tighten file modes")
+	app.flags.fixPerms = flag.Bool("fix-perms", false, "List stored certificates, keys and accounts whose mode or owner differs from 'file_permissions' and exit, correct them with -dry-run=false")
+	app.flags.diff = flag.Bool("diff", false, "Compare the 'auto_domains' config with the last run and the stored certificates, report drift and exit")
+	app.flags.optimize = flag.Bool("optimize", false, "Print the domain lists of 'auto_domains' certificates without the names their own wildcards cover and exit")
+	app.flags.importCertbot = flag.String("import-certbot", "", "Import certificates and ACME accounts from a certbot directory (e.g. /etc/letsencrypt), print matching 'auto_domains' entries and exit")
+	app.flags.adopt = flag.String("adopt", "", "Take over an existing certificate under this name (needs -adopt-cert and -adopt-key) and exit")
+	app.flags.adoptCert = flag.String("adopt-cert", "", "With -adopt: PEM certificate file, may include the chain")
+	app.flags.adoptKey = flag.String("adopt-key", "", "With -adopt: PEM private key file")
+	app.flags.adoptChain = flag.String("adopt-chain", "", "With -adopt: optional PEM chain file")
+	app.flags.rollback = flag.String("rollback", "", "Restore the generation archived before the current one of this certificate (see keep_generations) and exit")
+	app.flags.delete = flag.String("delete", "", "Remove the files, archived generations and state of a certificate no longer in 'auto_domains' and exit")
+	app.flags.deleteRevoke = flag.Bool("delete-revoke", false, "With -delete: revoke the certificate at the CA first")
+	app.flags.deleteAcmeDNS = flag.Bool("delete-acmedns", false, "With -delete: also remove the acme-dns accounts of its domains that no other certificate uses")
+	app.flags.orphanScan = flag.Bool("orphan-scan", false, "List certificate files, archived generations, state entries and output directory copies of certificates not in 'auto_domains' and exit")
+	app.flags.pruneAcmeDNS = flag.Bool("prune-acmedns-accounts", false, "List the acme-dns accounts of domains no 'auto_domains' or stored certificate uses and exit, remove them with -dry-run=false")
+	app.flags.migrateAccounts = flag.Bool("migrate-accounts", false, "List acme-dns accounts to consolidate per base domain and exit, apply the changes with -dry-run=false")
+	app.flags.dryRun = flag.Bool("dry-run", true, "With -prune-acmedns-accounts, -migrate-accounts or -fix-perms: only list the changes")
+	app.flags.lockTimeout = flag.Duration("lock-timeout", 0, "How long to wait for another running instance to release the storage lock (0: fail immediately)")
+	app.flags.rotateAccountKey = flag.Bool("rotate-account-key", false, "Replace the ACME account key(s) using the ACME key-change operation and exit")
+	app.flags.testAcmeDNS = flag.Bool("test-acmedns", false, "Set a random TXT value for every acme-dns account, check that it resolves through the _acme-challenge CNAME and exit")
+	app.flags.pendingOrders = flag.Bool("pending-orders", false, "List the ACME orders interrupted runs left behind, which the next run of their certificate resumes or deactivates, and exit")
+	app.flags.history = flag.String("history", "", "Print the audit log records of this certificate ('*' for all records, including account registrations) and exit")
+	app.flags.checkOCSP = flag.Bool("check-ocsp", false, "Query the OCSP responder of every stored certificate, record revoked certificates for replacement by the next -auto run and exit")
+	app.flags.init = flag.Bool("init", false, "Interactively create the -config file, register the ACME account and optionally the acme-dns account of a first certificate, then exit")
+	app.flags.validate = flag.Bool("validate", false, "Check the config, the storage directory and the ACME and acme-dns servers without issuing anything, report all problems and exit")
+	app.flags.check = flag.Bool("check", false, "Check the 'auto_domains' certificates like a Nagios/Icinga plugin: print one status line with perfdata and exit 0 (OK), 1 (WARNING), 2 (CRITICAL) or 3 (UNKNOWN)")
+	app.flags.checkWarningDays = flag.Int("check-warning-days", DefaultCheckWarningDays, "With -check: WARNING when a certificate expires in fewer days")
+	app.flags.checkCriticalDays = flag.Int("check-critical-days", DefaultCheckCriticalDays, "With -check: CRITICAL when a certificate expires in fewer days")
+	app.flags.debugBundle = flag.String("debug-bundle", "", "Write a tar.gz with version, sanitized config, certificate metadata, _acme-challenge DNS lookups and debug log for a bug report to this file and exit (implies -debug)")
+	app.flags.rotateAcmeDNS = flag.String("rotate-acmedns-account", "", "Register a new acme-dns account for this domain, switch its CNAME over, wait for the change (see -wait-for-dns-timeout) and replace the old account, then exit")
+	app.flags.waitForDNS = flag.Bool("wait-for-dns", false, "After printing required DNS changes, wait for the records to appear and continue instead of exiting")
+	app.flags.waitForDNSTimeout = flag.Duration("wait-for-dns-timeout", manager.DefaultDNSWaitTimeout, "How long -wait-for-dns waits for the DNS records")
+	app.flags.waitForDNSInterval = flag.Duration("wait-for-dns-interval", manager.DefaultDNSWaitInterval, "How often -wait-for-dns checks the DNS records")
+
+	app.flags.daemon = flag.Bool("daemon", false, "Keep running and process the 'auto_domains' certificates every -daemon-interval (implies -auto)")
+	app.flags.daemonInterval = flag.Duration("daemon-interval", DefaultDaemonInterval, "How often -daemon checks the certificates")
+	app.flags.only = flag.String("only", "", "With -auto or -daemon: process only these 'auto_domains' certificates (comma-separated names)")
+	app.flags.skip = flag.String("skip", "", "With -auto or -daemon: leave out these 'auto_domains' certificates (comma-separated names)")
+	app.flags.force = flag.Bool("force", false, "Order certificates even if the issuances of the last week suggest Let's Encrypt would refuse them for a rate limit")
 
+	app.flags.acmeServer = flag.String("acme-server", "", "Use this ACME directory URL instead of the configured acme_server, including per-certificate servers (e.g. to try a config against staging)")
+	app.flags.acmeDnsServer = flag.String("acme-dns-server", "", "Use this acme-dns server instead of the configured acme_dns_server, including acme_dns_servers")
+	app.flags.storage = flag.String("storage", "", "Use this storage directory instead of the configured cert_storage_path (relative to the working directory)")
+	app.flags.email = flag.String("email", "", "Use this ACME account email instead of the configured email")
+	app.flags.changedExitCode = flag.Bool("changed-exit-code", false, "Exit with 9 instead of 0 if a certificate was issued or renewed or the command changed the storage, for Ansible and other configuration management")
+	app.flags.output = flag.String("output", OutputText, "Output format of the results (text|json); json prints one document on stdout when the command ends, the log goes to stderr")
+	flag.Var(&app.flags.set, "set", "Override a config value, e.g. -set auto_domains.grace_days=10 (dotted key, YAML value, may be repeated)")
 	flag.Usage = app.printUsage
 }
 
@@ -81,13 +250,64 @@ func (app *Application) ParseFlags() {
 	flag.Parse()
 
 	app.config.ConfigPath = *app.flags.configPath
-	app.config.AutoMode = *app.flags.autoMode
+	app.config.AutoMode = *app.flags.autoMode || *app.flags.daemon
 	app.config.QuietMode = *app.flags.quietMode
 	app.config.PrintConfigTemplate = *app.flags.printConfigTemplate
-	app.config.DebugMode = *app.flags.debugMode
+	app.config.DebugMode = *app.flags.debugMode || *app.flags.debugACME || *app.flags.debugBundle != ""
+	app.config.DebugACME = *app.flags.debugACME
 	app.config.LogLevel = *app.flags.logLevel
 	app.config.LogFormat = *app.flags.logFormat
 	app.config.ShowVersion = *app.flags.showVersion
+	app.config.MetricsDump = *app.flags.metricsDump
+	app.config.MetricsFormat = *app.flags.metricsFormat
+	app.config.Fsck = *app.flags.fsck || *app.flags.fsckRepair
+	app.config.FsckRepair = *app.flags.fsckRepair
+	app.config.Diff = *app.flags.diff
+	app.config.Optimize = *app.flags.optimize
+	app.config.VerifyStorage = *app.flags.verifyStorage || *app.flags.verifyStorageRepair
+	app.config.VerifyStorageRepair = *app.flags.verifyStorageRepair
+	app.config.FixPerms = *app.flags.fixPerms
+	app.config.ImportCertbot = *app.flags.importCertbot
+	app.config.Adopt = *app.flags.adopt
+	app.config.Rollback = *app.flags.rollback
+	app.config.Delete = *app.flags.delete
+	app.config.DeleteRevoke = *app.flags.deleteRevoke
+	app.config.DeleteAcmeDNS = *app.flags.deleteAcmeDNS
+	app.config.OrphanScan = *app.flags.orphanScan
+	app.config.PruneAcmeDNS = *app.flags.pruneAcmeDNS
+	app.config.MigrateAccounts = *app.flags.migrateAccounts
+	app.config.DryRun = *app.flags.dryRun
+	app.config.AdoptCert = *app.flags.adoptCert
+	app.config.AdoptKey = *app.flags.adoptKey
+	app.config.AdoptChain = *app.flags.adoptChain
+	app.config.LockTimeout = *app.flags.lockTimeout
+	app.config.RotateAccountKey = *app.flags.rotateAccountKey
+	app.config.TestAcmeDNS = *app.flags.testAcmeDNS
+	app.config.CheckOCSP = *app.flags.checkOCSP
+	app.config.PendingOrders = *app.flags.pendingOrders
+	app.config.History = *app.flags.history
+	app.config.Validate = *app.flags.validate
+	app.config.Init = *app.flags.init
+	app.config.Check = *app.flags.check
+	app.config.CheckWarningDays = *app.flags.checkWarningDays
+	app.config.CheckCriticalDays = *app.flags.checkCriticalDays
+	app.config.DebugBundle = *app.flags.debugBundle
+	app.config.RotateAcmeDNS = *app.flags.rotateAcmeDNS
+	app.config.WaitForDNS = *app.flags.waitForDNS
+	app.config.WaitForDNSTimeout = *app.flags.waitForDNSTimeout
+	app.config.WaitForDNSInterval = *app.flags.waitForDNSInterval
+	app.config.Daemon = *app.flags.daemon
+	app.config.DaemonInterval = *app.flags.daemonInterval
+	app.config.Only = splitNames(*app.flags.only)
+	app.config.Skip = splitNames(*app.flags.skip)
+	app.config.Force = *app.flags.force
+	app.config.AcmeServer = *app.flags.acmeServer
+	app.config.AcmeDnsServer = *app.flags.acmeDnsServer
+	app.config.StoragePath = *app.flags.storage
+	app.config.Email = *app.flags.email
+	app.config.Set = app.flags.set
+	app.config.Output = *app.flags.output
+	app.config.ChangedExitCode = *app.flags.changedExitCode
 }
 
 // printUsage prints application usage information
@@ -99,14 +319,72 @@ func (app *Application) printUsage() {
 	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml cert1@example.com,www.example.com/key_type=ec384 cert2@service.example.com\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "  Automatic Mode: Use the -auto flag (no certificate arguments allowed).\n")
 	fmt.Fprintf(os.Stderr, "                  Processes certificates defined in the 'auto_domains' section of the config file (handles init and renew).\n")
-	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -auto\n\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "  Key Types: rsa2048, rsa3072, rsa4096, ec256, ec384\n\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -auto\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "             Use -only or -skip with certificate names to process part of 'auto_domains'.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -auto -only web,mail\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  Daemon Mode: Use the -daemon flag to repeat automatic mode every -daemon-interval until stopped.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -daemon -daemon-interval 6h\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  Metrics Dump: Use the -metrics-dump flag to print metrics for monitoring agents.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -metrics-dump -metrics-format openmetrics\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  Integrity Check: Use the -fsck flag to detect modified, missing or foreign files in the storage directory.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -fsck [-fsck-repair]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  Storage Check: Use the -verify-storage flag to find unparsable, mismatched or incomplete certificates and open file modes.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -verify-storage [-verify-storage-repair]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  File Permissions: Use the -fix-perms flag to find stored files whose mode or owner differs from 'file_permissions'.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -fix-perms [-dry-run=false]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  Drift Report: Use the -diff flag to list domain, key type and ACME server changes not yet applied.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -diff\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  Wildcard Hints: Use the -optimize flag to print shorter domain lists for certificates listing names their wildcard covers.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -optimize\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  Certbot Import: Use the -import-certbot flag to take over certificates and accounts from certbot.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -import-certbot /etc/letsencrypt\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  Adopt: Use the -adopt flag to take over a certificate issued elsewhere, it is renewed from then on.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -adopt web -adopt-cert web.crt -adopt-key web.key [-adopt-chain chain.pem]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  Rollback: Use the -rollback flag to put the previous generation of a certificate back in place.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -rollback web\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  Delete: Use the -delete flag to retire a certificate removed from 'auto_domains', -orphan-scan lists what is left of such certificates.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -delete old-web [-delete-revoke] [-delete-acmedns]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  Pending Orders: Use the -pending-orders flag to list ACME orders of interrupted runs, the next run resumes or deactivates them.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -pending-orders\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  History: Use the -history flag to show who issued, renewed or revoked a certificate when, from the audit log.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -history web\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  acme-dns Account Pruning: Use the -prune-acmedns-accounts flag to list acme-dns accounts of domains no longer managed.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -prune-acmedns-accounts [-dry-run=false]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  acme-dns Account Migration: Use the -migrate-accounts flag to store one acme-dns account per base domain and its wildcard.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -migrate-accounts [-dry-run=false]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  Key Rotation: Use the -rotate-account-key flag to replace the ACME account key without losing the registration.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -rotate-account-key\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  acme-dns Self-Test: Use the -test-acmedns flag to prove the challenge path of every acme-dns account works.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -test-acmedns\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  OCSP Check: Use the -check-ocsp flag to find revoked certificates, the next -auto run replaces them.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -check-ocsp\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  Setup Wizard: Use the -init flag to create a first configuration interactively.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -init\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  Pre-flight Check: Use the -validate flag to lint the config and check storage and servers, e.g. in CI before a deploy.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -validate\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  Monitoring Check: Use the -check flag to run as Nagios/Icinga plugin for the managed certificates.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -check -check-warning-days 14 -check-critical-days 7\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  Debug Bundle: Use the -debug-bundle flag to collect the information needed for a bug report, secrets redacted.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -debug-bundle debug.tar.gz\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  acme-dns Credential Rotation: Use the -rotate-acmedns-account flag to replace leaked acme-dns credentials of a domain.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -rotate-acmedns-account example.com\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  Key Types: rsa2048, rsa3072, rsa4096, ec256, ec384, ed25519 (private CAs only)\n\n")
+	fmt.Fprintf(os.Stderr, "  Config Overrides: -acme-server, -acme-dns-server, -storage, -email and -set key=value replace config values for one run.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -auto -acme-server https://acme-staging-v02.api.letsencrypt.org/directory -storage /tmp/staging\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  JSON Output: -output json prints the results of any command except -daemon and -init as one JSON document on stdout.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -auto -output json | jq .run.certificates\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  Changed Exit Code: -changed-exit-code exits with 9 instead of 0 when something changed, so wrappers can tell changed from ok.\n")
+	fmt.Fprintf(os.Stderr, "             Example: %s -config my.yaml -auto -changed-exit-code\n\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "Flags:\n")
 	flag.PrintDefaults()
 }
 
 // HandleVersionFlag handles the version display flag
 func (app *Application) HandleVersionFlag() bool {
+	if app.config.ShowVersion && app.output != nil {
+		app.setResult(versionOutput{Version: app.config.Version, GoVersion: runtime.Version(), OS: runtime.GOOS, Arch: runtime.GOARCH})
+		return true
+	}
 	if app.config.ShowVersion {
 		fmt.Printf("go-acme-dns-manager %s\n", app.config.Version)
 		fmt.Printf("Build date: %s\n", time.Now().Format("2006-01-02"))
@@ -122,6 +400,11 @@ func (app *Application) SetupLogger() error {
 	loggerLevel := manager.LogLevelInfo // Default log level
 	var loggerFormat manager.LogFormat  // Will be initialized later
 
+	// The environment sets the level unless a flag does
+	if app.config.LogLevel == "" && !app.config.DebugMode {
+		app.config.LogLevel = os.Getenv(envPrefix + "LOG_LEVEL")
+	}
+
 	// Parse log level flag if specified
 	if app.config.LogLevel != "" {
 		switch strings.ToLower(app.config.LogLevel) {
@@ -165,6 +448,17 @@ func (app *Application) SetupLogger() error {
 		loggerFormat = manager.LogFormatDefault
 	}
 
+	// Keep stdout clean for the metrics, plugin and JSON output
+	if app.config.MetricsDump || app.config.Check || app.output != nil {
+		manager.SetLogOutput(os.Stderr)
+	}
+
+	// The debug bundle includes the log of collecting it
+	if app.config.DebugBundle != "" {
+		app.debugLog = &bytes.Buffer{}
+		manager.SetLogOutput(io.MultiWriter(os.Stderr, app.debugLog))
+	}
+
 	// Set up the logger
 	manager.SetupDefaultLogger(loggerLevel, loggerFormat)
 	app.logger = manager.GetDefaultLogger()
@@ -174,6 +468,15 @@ func (app *Application) SetupLogger() error {
 
 // HandleConfigTemplate handles the config template printing
 func (app *Application) HandleConfigTemplate() bool {
+	if app.config.PrintConfigTemplate && app.output != nil {
+		var template bytes.Buffer
+		if err := manager.GenerateDefaultConfig(&template); err != nil {
+			fmt.Fprintf(os.Stderr, "Error printing config template: %v\n", err)
+			return true
+		}
+		app.setResult(configTemplateOutput{Template: template.String()})
+		return true
+	}
 	if app.config.PrintConfigTemplate {
 		fmt.Println("# Default configuration template:")
 		err := manager.GenerateDefaultConfig(os.Stdout)
@@ -213,15 +516,16 @@ func (app *Application) LoadConfigurationWithContext(ctx context.Context) (*mana
 		return nil, common.GetContextError(ctx, "load configuration")
 	}
 
-	// Check if config file exists
-	if _, err := os.Stat(app.config.ConfigPath); os.IsNotExist(err) {
+	// Check if config file exists, the environment alone may provide the configuration
+	if _, err := os.Stat(app.config.ConfigPath); os.IsNotExist(err) && len(envOverrides()) == 0 {
 		return nil, common.NewConfigError("locate config file",
 			"Configuration file not found").
 			AddContext("config_path", app.config.ConfigPath).
 			AddContext("request_id", common.GetRequestID(ctx)).
 			AddSuggestion("Use -print-config-template to generate a template").
-			AddSuggestion("Ensure the file path is correct")
-	} else if err != nil {
+			AddSuggestion("Ensure the file path is correct").
+			AddSuggestion("Or configure through ACME_DNS_MANAGER_* environment variables")
+	} else if err != nil && !os.IsNotExist(err) {
 		return nil, common.WrapError(err, common.ErrorTypeStorage, "access config file",
 			"Failed to access configuration file").
 			AddContext("config_path", app.config.ConfigPath).
@@ -237,7 +541,7 @@ func (app *Application) LoadConfigurationWithContext(ctx context.Context) (*mana
 	app.logger.Infof("Loading configuration from %s... (request: %s)",
 		app.config.ConfigPath, common.GetRequestID(ctx))
 
-	cfg, err := manager.LoadConfig(app.config.ConfigPath)
+	cfg, err := app.loadConfig()
 	if err != nil {
 		// Check for placeholder email
 		contentBytes, readErr := os.ReadFile(app.config.ConfigPath)
@@ -263,6 +567,9 @@ func (app *Application) LoadConfigurationWithContext(ctx context.Context) (*mana
 		return nil, common.GetContextError(ctx, "load configuration")
 	}
 
+	if overrides, err := app.configOverrides(); err == nil && len(overrides) > 0 {
+		app.logger.Infof("Config values overridden by the environment or the command line: %s", overriddenKeys(overrides))
+	}
 	app.logger.Infof("Configuration loaded successfully. (request: %s)", common.GetRequestID(ctx))
 	return cfg, nil
 }
@@ -296,9 +603,27 @@ func (app *Application) ValidateModeWithArgs(args []string) error {
 			AddSuggestion("Example: cert-name@domain1,domain2 or use -auto")
 	}
 
+	if !isAutoMode && (len(app.config.Only) > 0 || len(app.config.Skip) > 0) {
+		return common.NewValidationError("validate operation mode",
+			"-only and -skip select certificates of automatic mode").
+			AddContext("manual_args_count", len(args)).
+			AddSuggestion("Add the -auto flag, or give the certificate requests as arguments without -only and -skip")
+	}
+
 	return nil
 }
 
+// splitNames splits a comma-separated list of certificate names
+func splitNames(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 // setupGracefulShutdown sets up signal handling for graceful shutdown
 func (app *Application) setupGracefulShutdown(ctx context.Context) context.Context {
 	ctx, cancel := context.WithCancel(ctx)
@@ -346,8 +671,26 @@ func (app *Application) WaitForShutdown() {
 	<-app.done
 }
 
-// Run executes the main application logic with context support
+// Run executes the main application logic with context support. With
+// -output json the results go to stdout as one OutputDocument when the
+// command ends, also if it fails.
 func (app *Application) Run(ctx context.Context) error {
+	if err := app.validateOutput(); err != nil {
+		return err
+	}
+	if app.config.Output != OutputJSON {
+		return app.run(ctx)
+	}
+	app.output = &jsonOutput{doc: OutputDocument{Command: app.commandName(), Version: app.config.Version}}
+	err := app.run(ctx)
+	if writeErr := app.writeOutput(os.Stdout, err); writeErr != nil && err == nil {
+		err = fmt.Errorf("writing JSON output: %w", writeErr)
+	}
+	return err
+}
+
+// run dispatches to the selected command
+func (app *Application) run(ctx context.Context) error {
 	// Setup graceful shutdown handling
 	ctx = app.setupGracefulShutdown(ctx)
 
@@ -373,14 +716,43 @@ func (app *Application) Run(ctx context.Context) error {
 
 	// Display version at info level (hidden in quiet mode)
 	app.logger.Infof("go-acme-dns-manager %s", app.config.Version)
+	manager.SetUserAgentVersion(app.config.Version)
 
 	app.logger.Debugf("Starting application with request ID: %s", common.GetRequestID(ctx))
 
+	// The setup wizard creates the configuration
+	if app.config.Init {
+		err := app.HandleInit(ctx, os.Stdin, os.Stdout)
+		app.Shutdown()
+		return err
+	}
+
+	// The pre-flight check reports config problems itself and takes no lock
+	if app.config.Validate {
+		err := app.HandleValidate(ctx, app.stdout())
+		app.Shutdown()
+		return err
+	}
+
+	// The monitoring check is read-only and reports config problems as UNKNOWN
+	if app.config.Check {
+		err := app.HandleCheck(app.stdout())
+		app.Shutdown()
+		return err
+	}
+
+	// The debug bundle reports config problems itself and takes no lock
+	if app.config.DebugBundle != "" {
+		err := app.HandleDebugBundle(ctx)
+		app.Shutdown()
+		return err
+	}
+
 	// Load configuration with timeout
 	configCtx, configCancel := common.WithOperationTimeout(ctx)
 	defer configCancel()
 
-	_, err := app.LoadConfigurationWithContext(configCtx)
+	cfg, err := app.LoadConfigurationWithContext(configCtx)
 	if err != nil {
 		// Check if it was a context error
 		if ctxErr := common.GetContextError(configCtx, "load configuration"); ctxErr != nil {
@@ -389,6 +761,144 @@ func (app *Application) Run(ctx context.Context) error {
 		return err
 	}
 
+	// Switch to run_as_user before any network or storage access
+	statusListener, err := app.dropPrivileges(cfg)
+	if err != nil {
+		return err
+	}
+	if statusListener != nil {
+		defer func() { _ = statusListener.Close() }()
+	}
+
+	// Metrics are read-only and must work while a cron run holds the lock
+	if app.config.MetricsDump {
+		err := app.HandleMetricsDump(app.stdout())
+		app.Shutdown()
+		return err
+	}
+
+	lock, err := app.AcquireInstanceLock(ctx, cfg.CertStoragePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			app.logger.Warnf("Error releasing lock %s: %v", lock.Path, err)
+		}
+	}()
+
+	if err := app.SyncStorage(cfg); err != nil {
+		return err
+	}
+
+	if app.config.Fsck {
+		err := app.HandleFsck(app.stdout())
+		app.Shutdown()
+		return err
+	}
+
+	if app.config.VerifyStorage {
+		err := app.HandleVerifyStorage(app.stdout())
+		app.Shutdown()
+		return err
+	}
+
+	if app.config.FixPerms {
+		err := app.HandleFixPerms(app.stdout())
+		app.Shutdown()
+		return err
+	}
+
+	if app.config.Diff {
+		err := app.HandleDiff(app.stdout())
+		app.Shutdown()
+		return err
+	}
+
+	if app.config.Optimize {
+		err := app.HandleOptimize(app.stdout())
+		app.Shutdown()
+		return err
+	}
+
+	if app.config.ImportCertbot != "" {
+		err := app.HandleImportCertbot(app.stdout(), app.config.ImportCertbot)
+		app.Shutdown()
+		return err
+	}
+
+	if app.config.Adopt != "" {
+		err := app.HandleAdopt(app.stdout())
+		app.Shutdown()
+		return err
+	}
+
+	if app.config.Rollback != "" {
+		err := app.HandleRollback(app.config.Rollback)
+		app.Shutdown()
+		return err
+	}
+
+	if app.config.Delete != "" {
+		err := app.HandleDelete(ctx, app.config.Delete)
+		app.Shutdown()
+		return err
+	}
+
+	if app.config.OrphanScan {
+		err := app.HandleOrphanScan(app.stdout())
+		app.Shutdown()
+		return err
+	}
+
+	if app.config.PruneAcmeDNS {
+		err := app.HandlePruneAcmeDNSAccounts(app.stdout())
+		app.Shutdown()
+		return err
+	}
+
+	if app.config.MigrateAccounts {
+		err := app.HandleMigrateAccounts(ctx, app.stdout(), nil)
+		app.Shutdown()
+		return err
+	}
+
+	if app.config.RotateAccountKey {
+		err := app.HandleRotateAccountKey()
+		app.Shutdown()
+		return err
+	}
+
+	if app.config.TestAcmeDNS {
+		err := app.HandleTestAcmeDNS(ctx, app.stdout())
+		app.Shutdown()
+		return err
+	}
+
+	if app.config.CheckOCSP {
+		err := app.HandleCheckOCSP(ctx, app.stdout())
+		app.Shutdown()
+		return err
+	}
+
+	if app.config.PendingOrders {
+		err := app.HandlePendingOrders(app.stdout())
+		app.Shutdown()
+		return err
+	}
+
+	if app.config.History != "" {
+		err := app.HandleHistory(app.stdout(), app.config.History)
+		app.Shutdown()
+		return err
+	}
+
+	if app.config.RotateAcmeDNS != "" {
+		err := app.HandleRotateAcmeDNSAccount(ctx, app.config.RotateAcmeDNS)
+		app.Shutdown()
+		return err
+	}
+
 	// Validate mode
 	if err := app.ValidateMode(); err != nil {
 		return err
@@ -405,12 +915,47 @@ func (app *Application) Run(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("creating certificate manager: %w", err)
 	}
+	certManager.SetQuiet(app.config.QuietMode && app.config.AutoMode)
+	certManager.SetCertFilter(app.config.Only, app.config.Skip)
+	defer func() {
+		if err := certManager.Close(); err != nil {
+			app.logger.Warnf("Error closing certificate manager: %v", err)
+		}
+	}()
+
+	var status *StatusServer
+	if managerConfig.StatusListen != "" && app.config.AutoMode {
+		status = NewStatusServer(managerConfig, app.logger)
+		if statusListener != nil {
+			status.Serve(statusListener)
+		} else if err := status.Start(managerConfig.StatusListen); err != nil {
+			return err
+		}
+		defer func() {
+			if err := status.Close(); err != nil {
+				app.logger.Warnf("Error stopping status server: %v", err)
+			}
+		}()
+	}
+
+	if app.config.Daemon {
+		return app.RunDaemon(ctx, certManager, status)
+	}
 
 	// Process certificates based on mode
 	var processingErr error
 	if app.config.AutoMode {
+		held, err := app.holdsLease(managerConfig, status)
+		if err != nil {
+			return err
+		}
+		if !held {
+			app.Shutdown()
+			return nil
+		}
 		app.logger.Info("Starting automatic certificate processing...")
 		processingErr = certManager.ProcessAutoMode(ctx)
+		status.RecordRun(certManager.Results(), processingErr)
 	} else {
 		app.logger.Info("Starting manual certificate processing...")
 		args := flag.Args()
@@ -419,16 +964,30 @@ func (app *Application) Run(ctx context.Context) error {
 		}
 		processingErr = certManager.ProcessManualMode(ctx, args)
 	}
+	app.pushMetrics(ctx, managerConfig, certManager, processingErr)
+	if app.output != nil {
+		mode := "manual"
+		if app.config.AutoMode {
+			mode = "auto"
+		}
+		app.setRunReport(newRunReport(certManager.runStarted, mode, certManager.Results(), processingErr))
+	}
+	for _, r := range certManager.Results() {
+		if r.changed() {
+			app.markChanged()
+		}
+	}
 
 	// Handle processing result
 	if processingErr != nil {
-		// Check if this is just DNS setup needed (not really an error)
 		if errors.Is(processingErr, manager.ErrDNSSetupNeeded) {
-			// DNS instructions were already shown, exit cleanly
-			// Use Warn level so it shows even in quiet mode
+			// DNS instructions were already shown. Use Warn level so it shows even in quiet mode
 			app.logger.Warn("Please configure the DNS records as shown above and run the command again.")
+		}
+		// Just DNS setup needed is not really an error, it only sets the exit code
+		if dnsSetupOnly(processingErr) {
 			app.Shutdown() // Signal that we're done so WaitForShutdown doesn't hang
-			return nil
+			return processingErr
 		}
 		mode := "auto"
 		if !app.config.AutoMode {
@@ -449,12 +1008,785 @@ func (app *Application) Run(ctx context.Context) error {
 	return nil
 }
 
+// dropPrivileges switches to run_as_user and run_as_group of cfg. The status
+// port of an automatic run is opened first, so it may be below 1024, and
+// returned; it is nil if the status server opens it later as usual.
+func (app *Application) dropPrivileges(cfg *manager.Config) (net.Listener, error) {
+	if cfg.RunAsUser == "" && cfg.RunAsGroup == "" {
+		if os.Geteuid() == 0 {
+			app.logger.Infof("Running as root, set run_as_user to switch to an unprivileged user after startup")
+		}
+		return nil, nil
+	}
+
+	var listener net.Listener
+	if cfg.StatusListen != "" && app.config.AutoMode {
+		var err error
+		if listener, err = listenStatus(cfg.StatusListen); err != nil {
+			return nil, err
+		}
+	}
+	dropped, err := manager.DropPrivileges(cfg)
+	if err != nil {
+		if listener != nil {
+			_ = listener.Close()
+		}
+		return nil, common.WrapError(err, common.ErrorTypeConfig, "drop privileges",
+			"Failed to switch to run_as_user and run_as_group").
+			AddContext("run_as_user", cfg.RunAsUser).
+			AddContext("run_as_group", cfg.RunAsGroup).
+			AddSuggestion("Start the manager as root or as the configured user, or remove run_as_user and run_as_group")
+	}
+	if dropped {
+		app.logger.Infof("Switched to uid %d and gid %d", os.Geteuid(), os.Getegid())
+	}
+	return listener, nil
+}
+
+// markChanged records that the command changed certificates or the storage
+func (app *Application) markChanged() {
+	app.changed = true
+}
+
+// Changed reports whether the last Run issued or renewed a certificate or
+// otherwise changed the storage directory. A run that failed may still have
+// changed something, e.g. renewed one certificate while another failed.
+func (app *Application) Changed() bool {
+	return app.changed
+}
+
+// SuccessExitCode returns the exit code of a Run that returned no error:
+// ExitChanged if -changed-exit-code is set and something changed, else ExitOK
+func (app *Application) SuccessExitCode() int {
+	if app.config.ChangedExitCode && app.changed {
+		return ExitChanged
+	}
+	return ExitOK
+}
+
+// AcquireInstanceLock takes the lock of the storage directory so concurrent
+// runs cannot corrupt the account store or race on certificate files. It
+// waits up to -lock-timeout for another instance to finish.
+func (app *Application) AcquireInstanceLock(ctx context.Context, storagePath string) (*manager.InstanceLock, error) {
+	if app.config.LockTimeout > 0 {
+		app.logger.Debugf("Waiting up to %s for the storage lock", app.config.LockTimeout)
+	}
+	lock, err := manager.AcquireLock(ctx, storagePath, app.config.LockTimeout)
+	if errors.Is(err, manager.ErrLockHeld) {
+		return nil, common.WrapError(err, common.ErrorTypeStorage, "acquire lock",
+			"Another instance of go-acme-dns-manager is working on this storage directory").
+			AddContext("cert_storage_path", storagePath).
+			AddContext("lock_timeout", app.config.LockTimeout.String()).
+			AddSuggestion("Wait for the other run to finish, or use -lock-timeout to wait for it")
+	} else if err != nil {
+		return nil, common.WrapError(err, common.ErrorTypeStorage, "acquire lock",
+			"Failed to lock the storage directory").
+			AddContext("cert_storage_path", storagePath)
+	}
+	app.logger.Debugf("Acquired storage lock %s", lock.Path)
+	return lock, nil
+}
+
+// SyncStorage brings the storage directory in line with a remote storage
+// backend before anything reads it. Nothing happens for the file backend.
+func (app *Application) SyncStorage(cfg *manager.Config) error {
+	report, err := manager.SyncStorage(cfg)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeStorage, "sync storage",
+			"Failed to synchronize the storage directory with the storage backend").
+			AddContext("backend", cfg.Storage.Backend).
+			AddSuggestion("Check the address and credentials in the storage section of the config file")
+	}
+	if report == nil {
+		return nil
+	}
+	for _, key := range report.Downloaded {
+		app.logger.Debugf("Fetched %s from %s storage", key, report.Backend)
+	}
+	for _, key := range report.Uploaded {
+		app.logger.Debugf("Stored %s in %s storage", key, report.Backend)
+	}
+	app.logger.Infof("Synchronized storage with %s backend: %d file(s) fetched, %d stored",
+		report.Backend, len(report.Downloaded), len(report.Uploaded))
+	return nil
+}
+
+// HandleMetricsDump collects all metrics from the storage directory and writes
+// them to w in the format selected with -metrics-format
+func (app *Application) HandleMetricsDump(w io.Writer) error {
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return fmt.Errorf("loading manager config: %w", err)
+	}
+
+	snap, err := metrics.Collect(cfg, time.Now())
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeStorage, "collect metrics",
+			"Failed to collect metrics from the storage directory").
+			AddContext("cert_storage_path", cfg.CertStoragePath)
+	}
+
+	app.setResult(snap)
+	if err := metrics.Write(w, snap, app.config.MetricsFormat); err != nil {
+		return common.WrapError(err, common.ErrorTypeValidation, "write metrics",
+			"Failed to write metrics").
+			AddContext("metrics_format", app.config.MetricsFormat).
+			AddSuggestion("Use -metrics-format json or -metrics-format openmetrics")
+	}
+	return nil
+}
+
+// HandleFsck checks the storage directory against the checksum manifest and
+// writes one line per discrepancy to w. It returns an error if discrepancies
+// remain, so cron jobs and monitoring notice them.
+func (app *Application) HandleFsck(w io.Writer) error {
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return fmt.Errorf("loading manager config: %w", err)
+	}
+
+	report, err := manager.Fsck(cfg.CertStoragePath, app.config.FsckRepair)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeStorage, "check storage",
+			"Failed to check the storage directory").
+			AddContext("cert_storage_path", cfg.CertStoragePath)
+	}
+
+	app.setResult(report)
+	if !report.ManifestExists && !app.config.FsckRepair {
+		app.logger.Warnf("No checksum manifest found in %s, all files are reported as foreign", cfg.CertStoragePath)
+	}
+	unresolved := 0
+	for _, issue := range report.Issues {
+		if issue.Repaired != "" {
+			app.markChanged()
+			_, _ = fmt.Fprintf(w, "%-8s %s (%s)\n", issue.Kind, issue.Path, issue.Repaired)
+			continue
+		}
+		unresolved++
+		_, _ = fmt.Fprintf(w, "%-8s %s\n", issue.Kind, issue.Path)
+	}
+	app.logger.Infof("Checked %d file(s) against the manifest, found %d discrepancies", report.Checked, len(report.Issues))
+
+	if unresolved > 0 {
+		appErr := common.NewStorageError("check storage",
+			fmt.Sprintf("%d file(s) in the storage directory do not match the checksum manifest", unresolved)).
+			AddContext("cert_storage_path", cfg.CertStoragePath).
+			AddSuggestion("Review the listed files and run again with -fsck-repair to accept the current state")
+		if !report.ManifestExists {
+			appErr.AddSuggestion("Run -fsck-repair once to create the manifest for an existing storage directory")
+		}
+		return appErr
+	}
+	return nil
+}
+
+// HandleVerifyStorage checks the content of the stored certificates and the
+// file modes and writes one line per problem to w. It returns an error if
+// problems remain, so cron jobs and monitoring notice them.
+func (app *Application) HandleVerifyStorage(w io.Writer) error {
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return fmt.Errorf("loading manager config: %w", err)
+	}
+
+	report, err := manager.VerifyConfigStorage(cfg, app.config.VerifyStorageRepair)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeStorage, "verify storage",
+			"Failed to check the storage directory").
+			AddContext("cert_storage_path", cfg.CertStoragePath)
+	}
+	report.ExcludeExternalKeys(cfg)
+	app.setResult(report)
+
+	for _, issue := range report.Issues {
+		if issue.Repaired != "" {
+			app.markChanged()
+			_, _ = fmt.Fprintf(w, "%-16s %s: %s (%s)\n", issue.Kind, issue.Path, issue.Detail, issue.Repaired)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "%-16s %s: %s\n", issue.Kind, issue.Path, issue.Detail)
+	}
+	app.logger.Infof("Checked %d certificate(s), found %d problem(s)", report.Checked, len(report.Issues))
+
+	if unresolved := report.Unresolved(); unresolved > 0 {
+		appErr := common.NewStorageError("verify storage",
+			fmt.Sprintf("%d problem(s) found in the storage directory", unresolved)).
+			AddContext("cert_storage_path", cfg.CertStoragePath)
+		if !app.config.VerifyStorageRepair {
+			appErr.AddSuggestion("Run again with -verify-storage-repair to fix metadata and file modes")
+		}
+		return appErr.AddSuggestion("Delete the .crt of a certificate with a missing, broken or mismatched key, the next -auto run issues it again")
+	}
+	return nil
+}
+
+// HandleFixPerms writes one line per stored file or directory whose mode or
+// owner differs from file_permissions to w and corrects them unless -dry-run
+// is set, which is the default. It returns an error if files could not be
+// corrected.
+func (app *Application) HandleFixPerms(w io.Writer) error {
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return fmt.Errorf("loading manager config: %w", err)
+	}
+
+	report, err := manager.FixPermissions(cfg, app.config.DryRun)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeStorage, "fix permissions",
+			"Failed to check the file permissions in the storage directory").
+			AddContext("cert_storage_path", cfg.CertStoragePath)
+	}
+	app.setResult(report)
+
+	for _, fix := range report.Fixes {
+		switch {
+		case fix.Fixed:
+			app.markChanged()
+			_, _ = fmt.Fprintf(w, "fixed    %s: %s\n", fix.Path, fix.Detail)
+		case fix.Error != "":
+			_, _ = fmt.Fprintf(w, "failed   %s: %s (%s)\n", fix.Path, fix.Detail, fix.Error)
+		default:
+			_, _ = fmt.Fprintf(w, "differs  %s: %s\n", fix.Path, fix.Detail)
+		}
+	}
+	switch {
+	case len(report.Fixes) == 0:
+		app.logger.Infof("All %d stored file(s) and directories have the configured permissions", report.Checked)
+	case app.config.DryRun:
+		app.logger.Infof("%d of %d stored file(s) and directories differ from the configured permissions, correct them with -dry-run=false", len(report.Fixes), report.Checked)
+	default:
+		app.logger.Infof("Corrected %d of %d stored file(s) and directories", len(report.Fixes)-report.Failed(), report.Checked)
+	}
+
+	if failed := report.Failed(); failed > 0 {
+		return common.NewStorageError("fix permissions",
+			fmt.Sprintf("%d file(s) could not be corrected", failed)).
+			AddContext("cert_storage_path", cfg.CertStoragePath).
+			AddSuggestion("Changing the owner or group needs root privileges, run -fix-perms as root")
+	}
+	return nil
+}
+
+// HandleDiff compares the auto_domains configuration with the state file and
+// the stored certificates and writes one line per difference to w. It returns
+// an error if there are differences, like diff(1).
+func (app *Application) HandleDiff(w io.Writer) error {
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return fmt.Errorf("loading manager config: %w", err)
+	}
+
+	drifts, err := manager.Diff(cfg)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeStorage, "compare state",
+			"Failed to read the state of the storage directory").
+			AddContext("cert_storage_path", cfg.CertStoragePath)
+	}
+	app.setResult(diffOutput{Drifts: append([]manager.Drift{}, drifts...)})
+	for _, drift := range drifts {
+		_, _ = fmt.Fprintln(w, drift.String())
+	}
+	if len(drifts) > 0 {
+		return common.NewConfigError("compare state",
+			fmt.Sprintf("%d difference(s) between the configuration and the issued certificates", len(drifts))).
+			AddContext("cert_storage_path", cfg.CertStoragePath).
+			AddSuggestion("Run with -auto to apply the configuration")
+	}
+	app.logger.Infof("Configuration matches the issued certificates")
+	return nil
+}
+
+// HandleOptimize writes the auto_domains entries of certificates listing
+// names their own wildcards cover, with those names removed, to w. The
+// configuration is not changed.
+func (app *Application) HandleOptimize(w io.Writer) error {
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return fmt.Errorf("loading manager config: %w", err)
+	}
+
+	hints := manager.WildcardHints(cfg)
+	app.setResult(optimizeOutput{Hints: append([]manager.WildcardHint{}, hints...)})
+	if len(hints) == 0 {
+		app.logger.Infof("No certificate lists names its wildcards cover")
+		return nil
+	}
+	_, _ = fmt.Fprintf(w, "auto_domains:\n  certs:\n")
+	for _, hint := range hints {
+		_, _ = fmt.Fprintf(w, "    # %s covers %s\n", strings.Join(hint.Wildcards, ", "), strings.Join(hint.Redundant, ", "))
+		_, _ = fmt.Fprintf(w, "    %s:\n      domains:\n", hint.CertName)
+		for _, domain := range hint.Optimized {
+			_, _ = fmt.Fprintf(w, "        - %q\n", domain)
+		}
+	}
+	app.logger.Infof("%d certificate(s) can drop names their wildcards cover; the next run after the change issues them anew", len(hints))
+	return nil
+}
+
+// HandleImportCertbot copies the certificates and ACME accounts of a certbot
+// directory into the storage directory and writes a report followed by the
+// auto_domains entries for the imported certificates to w
+func (app *Application) HandleImportCertbot(w io.Writer, certbotDir string) error {
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return fmt.Errorf("loading manager config: %w", err)
+	}
+
+	imported, err := manager.ImportCertbot(cfg, certbotDir)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeStorage, "import certbot",
+			"Failed to read the certbot directory").
+			AddContext("certbot_dir", certbotDir).
+			AddSuggestion("Point -import-certbot at the certbot configuration directory containing renewal/, live/ and accounts/")
+	}
+
+	count := 0
+	for _, c := range imported.Certificates {
+		if c.Skipped != "" {
+			app.logger.Warnf("Skipped certificate %s: %s", c.Name, c.Skipped)
+			continue
+		}
+		count++
+		app.logger.Infof("Imported certificate %s (%s)", c.Name, strings.Join(c.Domains, ", "))
+	}
+	for _, a := range imported.Accounts {
+		if a.Skipped != "" {
+			app.logger.Warnf("Skipped ACME account for %s: %s", a.AcmeServer, a.Skipped)
+			continue
+		}
+		app.logger.Infof("Imported ACME account %s for %s", a.AccountURL, a.AcmeServer)
+	}
+	app.logger.Infof("Imported %d of %d certificate(s) into %s", count, len(imported.Certificates), cfg.CertStoragePath)
+	app.setResult(autoDomainsOutput{Imported: count})
+
+	if count == 0 {
+		return nil
+	}
+	app.markChanged()
+	snippet, err := imported.AutoDomainsYAML(cfg)
+	if err != nil {
+		return err
+	}
+	app.setResult(autoDomainsOutput{Imported: count, AutoDomains: snippet})
+	_, _ = fmt.Fprintf(w, "# Add to %s to renew the imported certificates with -auto:\n%s", app.config.ConfigPath, snippet)
+	return nil
+}
+
+// HandleAdopt stores the certificate given with -adopt-cert, -adopt-key and
+// -adopt-chain under the -adopt name. If the name is not in auto_domains yet,
+// the entry to add is written to w.
+func (app *Application) HandleAdopt(w io.Writer) error {
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return fmt.Errorf("loading manager config: %w", err)
+	}
+	if app.config.AdoptCert == "" || app.config.AdoptKey == "" {
+		return common.NewValidationError("adopt certificate", "-adopt needs -adopt-cert and -adopt-key").
+			AddSuggestion("Example: -adopt web -adopt-cert web.crt -adopt-key web.key")
+	}
+
+	files := map[string][]byte{}
+	for _, path := range []string{app.config.AdoptCert, app.config.AdoptKey, app.config.AdoptChain} {
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return common.WrapError(err, common.ErrorTypeStorage, "adopt certificate",
+				"Failed to read a file to adopt").
+				AddContext("path", path)
+		}
+		files[path] = data
+	}
+
+	name := app.config.Adopt
+	adopted, err := manager.AdoptCertificate(cfg, name, files[app.config.AdoptCert], files[app.config.AdoptKey], files[app.config.AdoptChain])
+	if err != nil {
+		appErr := common.WrapError(err, common.ErrorTypeCertificate, "adopt certificate",
+			"Failed to adopt the certificate").
+			AddContext("cert_name", name)
+		if errors.Is(err, manager.ErrCertificateExists) {
+			appErr.AddSuggestion("Choose another name, the existing certificate is already managed")
+		}
+		return appErr
+	}
+	app.markChanged()
+	app.logger.Infof("Adopted certificate %s (%s), valid until %s", name, strings.Join(adopted.Domains, ", "), adopted.NotAfter.Format(time.RFC3339))
+
+	if certCfg, ok := cfg.CertConfigFor(name); ok {
+		if missing, _ := certinfo.CompareDomains(adopted.Domains, certCfg.Domains); len(missing) > 0 {
+			app.logger.Warnf("Certificate %s lacks configured domains %s, the next -auto run renews it", name, strings.Join(missing, ", "))
+		}
+		return nil
+	}
+	snippet, err := manager.AutoDomainsSnippet(map[string]manager.CertConfig{
+		name: {Domains: adopted.Domains, KeyType: adopted.KeyType},
+	})
+	if err != nil {
+		return err
+	}
+	app.setResult(autoDomainsOutput{AutoDomains: snippet})
+	_, _ = fmt.Fprintf(w, "# Add to %s to renew the adopted certificate with -auto:\n%s", app.config.ConfigPath, snippet)
+	return nil
+}
+
+// HandleRollback restores the generation archived before the current one of a certificate
+func (app *Application) HandleRollback(certName string) error {
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return fmt.Errorf("loading manager config: %w", err)
+	}
+
+	gen, err := manager.RollbackCertificate(cfg, certName)
+	if err != nil {
+		appErr := common.WrapError(err, common.ErrorTypeStorage, "rollback certificate",
+			"Failed to restore the previous certificate generation").
+			AddContext("cert_name", certName)
+		if errors.Is(err, manager.ErrNoGeneration) {
+			appErr.AddSuggestion("Generations are archived on renewal when keep_generations is above 0")
+		}
+		return appErr
+	}
+	app.markChanged()
+	app.logger.Infof("Restored certificate %s from %s (archived %s)", certName, gen.Dir, gen.Time.Format(time.RFC3339))
+	if !gen.NotAfter.IsZero() {
+		app.logger.Infof("The restored certificate expires %s", gen.NotAfter.Format(time.RFC3339))
+	}
+	if certCfg, ok := cfg.CertConfigFor(certName); ok {
+		decision, err := manager.DetermineAction(cfg, certName, certCfg.Domains, certCfg.KeyType, cfg.GetRenewalThreshold())
+		if err == nil && decision.Action != manager.ActionSkip {
+			app.logger.Warnf("The next -auto run replaces certificate %s again: %s", certName, decision.Reason)
+		}
+	}
+	return nil
+}
+
+// HandleDelete retires a certificate that was removed from auto_domains
+func (app *Application) HandleDelete(ctx context.Context, certName string) error {
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return fmt.Errorf("loading manager config: %w", err)
+	}
+	store, err := manager.NewConfigAccountStore(cfg)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeStorage, "delete certificate",
+			"Failed to load the acme-dns accounts").
+			AddContext("cert_storage_path", cfg.CertStoragePath)
+	}
+
+	opts := manager.DeleteOptions{Revoke: app.config.DeleteRevoke, PruneAcmeDNS: app.config.DeleteAcmeDNS}
+	result, err := manager.DeleteCertificate(ctx, cfg, store, certName, opts)
+	switch {
+	case errors.Is(err, manager.ErrStillConfigured):
+		return common.WrapError(err, common.ErrorTypeConfig, "delete certificate",
+			"The certificate is still configured, the next -auto run would issue it again").
+			AddContext("cert_name", certName).
+			AddSuggestion("Remove the certificate from 'auto_domains.certs' first")
+	case errors.Is(err, manager.ErrNothingStored):
+		return common.WrapError(err, common.ErrorTypeStorage, "delete certificate",
+			"No files or state of the certificate found").
+			AddContext("cert_name", certName).
+			AddContext("cert_storage_path", cfg.CertStoragePath).
+			AddSuggestion("Use -orphan-scan to list the certificates left in the storage directory")
+	case errors.Is(err, manager.ErrNoAccount):
+		return common.WrapError(err, common.ErrorTypeACME, "revoke certificate",
+			"No ACME account to revoke the certificate with, nothing was deleted").
+			AddContext("cert_name", certName).
+			AddSuggestion("Run without -delete-revoke to only remove the files")
+	case errors.Is(err, manager.ErrRevocationFailed):
+		return common.WrapError(err, common.ErrorTypeACME, "revoke certificate",
+			"Failed to revoke the certificate, nothing was deleted").
+			AddContext("cert_name", certName)
+	case err != nil:
+		return common.WrapError(err, common.ErrorTypeStorage, "delete certificate",
+			"Failed to remove the certificate completely").
+			AddContext("cert_name", certName).
+			AddSuggestion("Fix the problem and run -delete again, it continues with what is left")
+	}
+
+	app.markChanged()
+	if result.Revoked {
+		app.logger.Infof("Revoked certificate %s", certName)
+	}
+	app.logger.Infof("Removed %d file(s) of certificate %s", len(result.Files), certName)
+	for _, file := range result.Files {
+		app.logger.Debugf("    %s", file)
+	}
+	if result.StateRemoved {
+		app.logger.Infof("Removed certificate %s from the state file", certName)
+	}
+	if cfg.RunReport != "" {
+		if err := removeFromRunReport(cfg.RunReport, certName); err != nil {
+			app.logger.Warnf("Warning: removing %s from run report %s: %v", certName, cfg.RunReport, err)
+		}
+	}
+	for _, domain := range result.AcmeDNSAccounts {
+		app.logger.Infof("Removed acme-dns account of %s", domain)
+	}
+	if len(result.AcmeDNSAccounts) > 0 {
+		app.logger.Warnf("The removed accounts still exist on the acme-dns server; delete the _acme-challenge CNAME records of %s", strings.Join(result.AcmeDNSAccounts, ", "))
+	}
+	return nil
+}
+
+// HandleOrphanScan writes one line per leftover of a certificate that is not
+// in auto_domains to w
+func (app *Application) HandleOrphanScan(w io.Writer) error {
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return fmt.Errorf("loading manager config: %w", err)
+	}
+	orphans, err := manager.OrphanScan(cfg)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeStorage, "orphan scan",
+			"Failed to read the storage directory").
+			AddContext("cert_storage_path", cfg.CertStoragePath)
+	}
+	app.setResult(orphanScanOutput{Orphans: append([]manager.Orphan{}, orphans...)})
+	names := make(map[string]bool)
+	for _, orphan := range orphans {
+		names[orphan.CertName] = true
+		_, _ = fmt.Fprintf(w, "%-7s %s %s\n", orphan.Kind, orphan.CertName, orphan.Path)
+	}
+	if len(orphans) == 0 {
+		app.logger.Infof("No leftovers of unconfigured certificates found")
+		return nil
+	}
+	app.logger.Infof("%d leftover(s) of %d certificate(s) not in 'auto_domains', remove them with -delete", len(orphans), len(names))
+	return nil
+}
+
+// HandlePendingOrders writes one line per order an interrupted run left
+// behind to w
+func (app *Application) HandlePendingOrders(w io.Writer) error {
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return fmt.Errorf("loading manager config: %w", err)
+	}
+	orders, err := manager.ListPendingOrders(cfg.CertStoragePath)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeStorage, "pending orders",
+			"Failed to read the pending orders").
+			AddContext("cert_storage_path", cfg.CertStoragePath)
+	}
+	// The key of the order stays out of the output
+	result := pendingOrdersOutput{Orders: []pendingOrderOutput{}}
+	for _, order := range orders {
+		result.Orders = append(result.Orders, pendingOrderOutput{
+			CertName: order.CertName, OrderURL: order.OrderURL, AcmeServer: order.AcmeServer,
+			Domains: order.Domains, Created: order.Created,
+		})
+	}
+	app.setResult(result)
+	for _, order := range orders {
+		_, _ = fmt.Fprintf(w, "%s %s %s %s\n", order.CertName, order.Created.Local().Format(time.RFC3339), order.OrderURL, strings.Join(order.Domains, ","))
+	}
+	if len(orders) == 0 {
+		app.logger.Infof("No pending orders")
+		return nil
+	}
+	app.logger.Infof("%d pending order(s), the next run of each certificate resumes the order if the CA issued it, otherwise it deactivates its pending authorizations", len(orders))
+	return nil
+}
+
+// HandleHistory writes the audit log records of a certificate to w, all
+// records for certName "*". A broken chain fails the command after the
+// records are written.
+func (app *Application) HandleHistory(w io.Writer, certName string) error {
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return fmt.Errorf("loading manager config: %w", err)
+	}
+	log, err := manager.ReadAuditLog(cfg.CertStoragePath)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeStorage, "history",
+			"Failed to read the audit log").
+			AddContext("cert_storage_path", cfg.CertStoragePath)
+	}
+	records := log.Records
+	if certName != "*" {
+		records = log.ForCert(certName)
+	}
+	app.setResult(historyOutput{Records: append([]manager.AuditRecord{}, records...), BrokenLine: log.BrokenLine})
+	for _, record := range records {
+		subject := record.CertName
+		if subject == "" {
+			subject = record.Account
+		}
+		line := fmt.Sprintf("%s %-24s %-7s %s@%s %s", record.Time.Local().Format(time.RFC3339), record.Action, record.Result, record.User, record.Host, subject)
+		if len(record.Domains) > 0 {
+			line += " " + strings.Join(record.Domains, ",")
+		}
+		if record.OrderURL != "" {
+			line += " " + record.OrderURL
+		}
+		if record.Error != "" {
+			line += " error: " + record.Error
+		}
+		_, _ = fmt.Fprintln(w, line)
+	}
+	if log.BrokenLine != 0 {
+		return common.NewApplicationError(common.ErrorTypeStorage, "history",
+			fmt.Sprintf("The audit log was modified, the record on line %d does not follow the one before it", log.BrokenLine)).
+			AddContext("audit_log", filepath.Join(cfg.CertStoragePath, manager.AuditLogFile)).
+			AddSuggestion("Compare the log with a backup, -fsck also reports the change")
+	}
+	if len(records) == 0 {
+		app.logger.Infof("No audit log records of certificate %s", certName)
+	}
+	return nil
+}
+
+// HandlePruneAcmeDNSAccounts writes the acme-dns accounts no certificate uses
+// to w and removes them unless -dry-run is set, which is the default
+func (app *Application) HandlePruneAcmeDNSAccounts(w io.Writer) error {
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return fmt.Errorf("loading manager config: %w", err)
+	}
+	store, err := manager.NewConfigAccountStore(cfg)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeStorage, "prune acme-dns accounts",
+			"Failed to load the acme-dns accounts").
+			AddContext("cert_storage_path", cfg.CertStoragePath)
+	}
+	unused, err := manager.UnusedAcmeDNSAccounts(cfg, store)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeStorage, "prune acme-dns accounts",
+			"Failed to read the stored certificates").
+			AddContext("cert_storage_path", cfg.CertStoragePath)
+	}
+	accounts := store.GetAllAccounts()
+	result := pruneOutput{Unused: []acmeDNSAccountOutput{}, Removed: !app.config.DryRun && len(unused) > 0}
+	for _, domain := range unused {
+		result.Unused = append(result.Unused, acmeDNSAccountOutput{Domain: domain, FullDomain: accounts[domain].FullDomain})
+		_, _ = fmt.Fprintf(w, "%s %s\n", domain, accounts[domain].FullDomain)
+	}
+	app.setResult(result)
+	switch {
+	case len(unused) == 0:
+		app.logger.Infof("All %d acme-dns account(s) are in use", len(accounts))
+		return nil
+	case app.config.DryRun:
+		app.logger.Infof("%d of %d acme-dns account(s) are unused, remove them with -dry-run=false", len(unused), len(accounts))
+		return nil
+	}
+
+	if err := manager.RemoveAcmeDNSAccounts(store, unused); err != nil {
+		return common.WrapError(err, common.ErrorTypeStorage, "prune acme-dns accounts",
+			"Failed to save the acme-dns accounts").
+			AddContext("cert_storage_path", cfg.CertStoragePath)
+	}
+	app.markChanged()
+	app.logger.Infof("Removed %d unused acme-dns account(s)", len(unused))
+	app.logger.Warnf("The removed accounts still exist on the acme-dns server; delete the _acme-challenge CNAME records of the listed domains")
+	return nil
+}
+
+// HandleMigrateAccounts writes the acme-dns accounts that are not stored
+// under exactly their base domain and its wildcard to w and consolidates
+// them unless -dry-run is set, which is the default. A nil resolver selects
+// the pre-check resolver of the configuration.
+func (app *Application) HandleMigrateAccounts(ctx context.Context, w io.Writer, resolver manager.DNSResolver) error {
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return fmt.Errorf("loading manager config: %w", err)
+	}
+	store, err := manager.NewConfigAccountStore(cfg)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeStorage, "migrate accounts",
+			"Failed to load the acme-dns accounts").
+			AddContext("cert_storage_path", cfg.CertStoragePath)
+	}
+	migrations, err := manager.PlanAccountMigration(ctx, cfg, store, resolver)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeDNS, "migrate accounts",
+			"Failed to look up which account the CNAME record points to")
+	}
+	// Only the fulldomain of the kept account, its credentials stay out of the output
+	result := migrateOutput{Migrations: []migrationOutput{}, Applied: !app.config.DryRun && len(migrations) > 0}
+	for _, m := range migrations {
+		result.Migrations = append(result.Migrations, migrationOutput{
+			Domain: m.Domain, Keys: m.Keys, Keep: m.Keep.FullDomain, Dropped: m.Dropped, Live: m.Live,
+		})
+	}
+	app.setResult(result)
+	for _, m := range migrations {
+		line := fmt.Sprintf("%s %s (stored as %s)", m.Domain, m.Keep.FullDomain, strings.Join(m.Keys, ", "))
+		if len(m.Dropped) > 0 {
+			line += " drops " + strings.Join(m.Dropped, ", ")
+		}
+		_, _ = fmt.Fprintln(w, line)
+	}
+	if len(migrations) == 0 {
+		app.logger.Infof("Every acme-dns account is stored under its base domain and wildcard")
+		return nil
+	}
+	if app.config.DryRun {
+		app.logger.Infof("%d domain(s) to migrate, apply the changes with -dry-run=false", len(migrations))
+		return nil
+	}
+
+	if err := manager.ApplyAccountMigration(store, migrations); err != nil {
+		return common.WrapError(err, common.ErrorTypeStorage, "migrate accounts",
+			"Failed to save the acme-dns accounts").
+			AddContext("cert_storage_path", cfg.CertStoragePath)
+	}
+	app.markChanged()
+	app.logger.Infof("Migrated the acme-dns accounts of %d domain(s)", len(migrations))
+	for _, m := range migrations {
+		if len(m.Dropped) > 0 && !m.Live {
+			app.logger.Warnf("The _acme-challenge CNAME of %s points to none of its accounts, point it to %s", m.Domain, m.Keep.FullDomain)
+		}
+	}
+	return nil
+}
+
+// HandleRotateAccountKey rotates the account key of every configured ACME
+// server that has a registration
+func (app *Application) HandleRotateAccountKey() error {
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return fmt.Errorf("loading manager config: %w", err)
+	}
+
+	rotated := 0
+	for _, server := range cfg.AcmeServers() {
+		serverCfg := *cfg
+		serverCfg.AcmeServer = server
+		app.logger.Infof("Rotating account key for %s...", server)
+
+		result, err := manager.RotateAccountKey(&serverCfg)
+		if errors.Is(err, manager.ErrNoAccount) {
+			app.logger.Infof("No account registered with %s yet, skipping", server)
+			continue
+		}
+		if err != nil {
+			return common.WrapError(err, common.ErrorTypeACME, "rotate account key",
+				"Failed to rotate the ACME account key").
+				AddContext("acme_server", server).
+				AddSuggestion("If the CA accepted the new key, it is kept next to the old key with a .new suffix")
+		}
+		rotated++
+		app.markChanged()
+		app.logger.Infof("Rotated key of account %s, previous files backed up to %s", result.AccountURL, result.BackupDir)
+	}
+
+	if rotated == 0 {
+		return common.NewACMEError("rotate account key", "No ACME account found to rotate").
+			AddContext("cert_storage_path", cfg.CertStoragePath).
+			AddSuggestion("Accounts are registered on the first certificate request")
+	}
+	return nil
+}
+
 // LoadManagerConfig loads the manager configuration from the parsed config
 func (app *Application) LoadManagerConfig() (*manager.Config, error) {
 	app.logger.Debug("Loading manager configuration...")
 
 	// Load the configuration file
-	cfg, err := manager.LoadConfig(app.config.ConfigPath)
+	cfg, err := app.loadConfig()
 	if err != nil {
 		return nil, fmt.Errorf("loading config file: %w", err)
 	}
@@ -462,6 +1794,242 @@ func (app *Application) LoadManagerConfig() (*manager.Config, error) {
 	// Apply mock server overrides if available (only in mock builds)
 	app.applyMockOverrides(cfg)
 
+	cfg.DebugACME = app.config.DebugACME
+	cfg.Force = app.config.Force
+	if app.output != nil {
+		cfg.DNSSetupReported = app.recordDNSSetup
+	}
+
+	if app.config.WaitForDNS {
+		cfg.DNSWait = &manager.DNSWaitOptions{
+			Timeout:  app.config.WaitForDNSTimeout,
+			Interval: app.config.WaitForDNSInterval,
+		}
+	}
+
 	app.logger.Debug("Manager configuration loaded successfully")
 	return cfg, nil
 }
+
+// HandleTestAcmeDNS runs the acme-dns self-test for every registered account
+// and writes one line per domain to w. It returns an error if any domain
+// failed, so the challenge path can be checked before the first ACME order.
+func (app *Application) HandleTestAcmeDNS(ctx context.Context, w io.Writer) error {
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return fmt.Errorf("loading manager config: %w", err)
+	}
+
+	store, err := manager.NewConfigAccountStore(cfg)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeStorage, "test acme-dns",
+			"Failed to load the acme-dns accounts").
+			AddContext("cert_storage_path", cfg.CertStoragePath)
+	}
+	resolver, ok := manager.NewPrecheckResolver(cfg).(manager.TXTResolver)
+	if !ok {
+		return common.NewDNSError("test acme-dns", "The configured resolver cannot look up TXT records")
+	}
+
+	results := manager.SelfTestAcmeDNS(ctx, cfg, store, resolver)
+	tests := []selfTestOutput{}
+	for _, r := range results {
+		tests = append(tests, selfTestOutput{
+			Domain: r.Domain, ChallengeDomain: r.ChallengeDomain, FullDomain: r.FullDomain, OK: r.Err == nil,
+			Step: r.Step, Error: errorString(r.Err), DurationSeconds: r.Duration.Seconds(),
+		})
+	}
+	app.setResult(tests)
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			_, _ = fmt.Fprintf(w, "FAIL  %s  %s: %v\n", r.Domain, r.Step, r.Err)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "PASS  %s  %s -> %s (%s)\n", r.Domain, r.ChallengeDomain, r.FullDomain, r.Duration.Round(time.Millisecond))
+	}
+	if common.IsContextCanceled(ctx) {
+		return common.GetContextError(ctx, "test acme-dns")
+	}
+	app.logger.Infof("Tested %d acme-dns account(s), %d failed", len(results), failed)
+
+	if failed > 0 {
+		return common.NewDNSError("test acme-dns",
+			fmt.Sprintf("%d of %d acme-dns account(s) failed the self-test", failed, len(results))).
+			AddContext("acme_dns_server", cfg.AcmeDnsServer).
+			AddSuggestion("An update failure points to wrong credentials or an allowfrom restriction of the account").
+			AddSuggestion("A dns failure points to a missing or wrong _acme-challenge CNAME record")
+	}
+	return nil
+}
+
+// HandleCheckOCSP queries the OCSP status of every stored certificate, records
+// it in the state file and writes one line per certificate to w. It returns
+// an error if a certificate is revoked or could not be checked.
+func (app *Application) HandleCheckOCSP(ctx context.Context, w io.Writer) error {
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return fmt.Errorf("loading manager config: %w", err)
+	}
+
+	names, err := certinfo.List(cfg.CertStoragePath)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeStorage, "check OCSP",
+			"Failed to list the stored certificates").
+			AddContext("cert_storage_path", cfg.CertStoragePath)
+	}
+	revoked, failed := 0, 0
+	checks := []ocspOutput{}
+	defer func() { app.setResult(checks) }()
+	for _, name := range names {
+		result, err := manager.CheckOCSP(ctx, cfg, name)
+		if err != nil {
+			failed++
+			checks = append(checks, ocspOutput{CertName: name, Error: err.Error()})
+			_, _ = fmt.Fprintf(w, "FAIL     %s: %v\n", name, err)
+			continue
+		}
+		checks = append(checks, ocspOutput{CertName: name, Status: result.Status, RevokedAt: result.RevokedAt, Reason: result.Reason})
+		if err := manager.RecordOCSP(cfg.CertStoragePath, name, result); err != nil {
+			app.logger.Warnf("Recording OCSP status of certificate %s: %v", name, err)
+		}
+		switch result.Status {
+		case manager.OCSPStatusRevoked:
+			revoked++
+			line := fmt.Sprintf("REVOKED  %s", name)
+			if result.RevokedAt != nil {
+				line += " at " + result.RevokedAt.Format(time.RFC3339)
+			}
+			if result.Reason != "" {
+				line += " (" + result.Reason + ")"
+			}
+			_, _ = fmt.Fprintln(w, line)
+		default:
+			_, _ = fmt.Fprintf(w, "%-8s %s\n", strings.ToUpper(result.Status), name)
+		}
+	}
+	if common.IsContextCanceled(ctx) {
+		return common.GetContextError(ctx, "check OCSP")
+	}
+	app.logger.Infof("Checked %d certificate(s), %d revoked, %d failed", len(names), revoked, failed)
+
+	if revoked > 0 {
+		return common.NewCertificateError("check OCSP",
+			fmt.Sprintf("%d of %d certificate(s) are revoked", revoked, len(names))).
+			AddContext("cert_storage_path", cfg.CertStoragePath).
+			AddSuggestion("Run with -auto to replace the revoked certificates")
+	}
+	if failed > 0 {
+		return common.NewNetworkError("check OCSP",
+			fmt.Sprintf("%d of %d certificate(s) could not be checked", failed, len(names))).
+			AddContext("cert_storage_path", cfg.CertStoragePath).
+			AddSuggestion("Check that the OCSP responders named in the certificates are reachable")
+	}
+	return nil
+}
+
+// HandleValidate loads the configuration and runs the pre-flight checks,
+// writing the result grouped by config, storage, ACME and acme-dns servers
+// to w. Nothing is registered or issued. It returns an error if any check
+// failed, so CI pipelines can lint a config before deploying it.
+func (app *Application) HandleValidate(ctx context.Context, w io.Writer) error {
+	result := validateOutput{}
+	defer func() { app.setResult(result) }()
+	cfg, err := app.LoadConfigurationWithContext(ctx)
+	if err != nil {
+		result.Checks = append(result.Checks, validateCheckOutput{Group: "config", Target: app.config.ConfigPath, Error: err.Error()})
+		_, _ = fmt.Fprintf(w, "config:\n  FAIL %s: %v\n", app.config.ConfigPath, err)
+		return err
+	}
+	result.Checks = append(result.Checks, validateCheckOutput{Group: "config", Target: app.config.ConfigPath, OK: true})
+	_, _ = fmt.Fprintf(w, "config:\n  OK   %s\n", app.config.ConfigPath)
+
+	// The checks reach the same servers as a run, with the same user
+	if _, err := app.dropPrivileges(cfg); err != nil {
+		result.Checks = append(result.Checks, validateCheckOutput{Group: "config", Target: "run_as_user", Error: err.Error()})
+		_, _ = fmt.Fprintf(w, "  FAIL run_as_user: %v\n", err)
+		return err
+	}
+
+	cfg.DebugACME = app.config.DebugACME
+	checks := manager.Preflight(ctx, cfg)
+	if common.IsContextCanceled(ctx) {
+		return common.GetContextError(ctx, "validate")
+	}
+	failed := 0
+	group := ""
+	for _, check := range checks {
+		result.Checks = append(result.Checks, validateCheckOutput{
+			Group: check.Group, Target: check.Target, OK: check.Err == nil, Note: check.Note, Error: errorString(check.Err),
+		})
+		if check.Group != group {
+			group = check.Group
+			_, _ = fmt.Fprintf(w, "%s:\n", group)
+		}
+		switch {
+		case check.Err != nil:
+			failed++
+			_, _ = fmt.Fprintf(w, "  FAIL %s: %v\n", check.Target, check.Err)
+		case check.Note != "":
+			_, _ = fmt.Fprintf(w, "  OK   %s (%s)\n", check.Target, check.Note)
+		default:
+			_, _ = fmt.Fprintf(w, "  OK   %s\n", check.Target)
+		}
+	}
+
+	if failed > 0 {
+		return common.NewConfigError("validate",
+			fmt.Sprintf("%d of %d pre-flight check(s) failed", failed, len(checks)+1)).
+			AddContext("config_path", app.config.ConfigPath).
+			AddSuggestion("Fix the problems marked FAIL above before deploying the configuration")
+	}
+	app.logger.Infof("All %d pre-flight check(s) passed", len(checks)+1)
+	return nil
+}
+
+// HandleRotateAcmeDNSAccount replaces the acme-dns account of a domain. It
+// always waits for the new CNAME, up to -wait-for-dns-timeout.
+func (app *Application) HandleRotateAcmeDNSAccount(ctx context.Context, domain string) error {
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return fmt.Errorf("loading manager config: %w", err)
+	}
+	if cfg.DNSWait == nil {
+		cfg.DNSWait = &manager.DNSWaitOptions{
+			Timeout:  app.config.WaitForDNSTimeout,
+			Interval: app.config.WaitForDNSInterval,
+		}
+	}
+
+	store, err := manager.NewConfigAccountStore(cfg)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeStorage, "rotate acme-dns account",
+			"Failed to load the acme-dns accounts").
+			AddContext("cert_storage_path", cfg.CertStoragePath)
+	}
+
+	rotation, err := manager.RotateAcmeDNSAccount(ctx, cfg, store, domain, nil)
+	switch {
+	case errors.Is(err, manager.ErrNoAcmeDNSAccount):
+		return common.WrapError(err, common.ErrorTypeConfig, "rotate acme-dns account",
+			"The domain has no acme-dns account").
+			AddContext("domain", domain).
+			AddSuggestion("Accounts are registered on the first certificate request for a domain")
+	case errors.Is(err, manager.ErrDNSSetupNeeded):
+		return common.WrapError(err, common.ErrorTypeDNS, "rotate acme-dns account",
+			"The CNAME record does not point to the new acme-dns account yet").
+			AddContext("domain", domain).
+			AddContext("new_target", rotation.NewTarget).
+			AddSuggestion("Run the same command again once the record is changed, the new account is kept until then")
+	case err != nil:
+		return common.WrapError(err, common.ErrorTypeNetwork, "rotate acme-dns account",
+			"Failed to rotate the acme-dns account").
+			AddContext("domain", domain)
+	}
+
+	app.markChanged()
+	app.logger.Infof("Rotated acme-dns account of %s: %s -> %s", rotation.Domain, rotation.OldTarget, rotation.NewTarget)
+	app.logger.Warnf("The old account %s still exists on the acme-dns server, no CNAME points to it anymore; remove it there if possible", rotation.OldTarget)
+	return nil
+}
@@ -1,13 +1,26 @@
 package app
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
 )
 
 // TestApplication_ParseFlags demonstrates how the new architecture is easily testable
@@ -540,3 +553,1155 @@ func TestApplication_ParseFlags_Extended(t *testing.T) {
 		t.Error("Expected DebugMode to be true")
 	}
 }
+
+// TestApplication_HandleMetricsDump tests the one-shot metrics output
+func TestApplication_HandleMetricsDump(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := tmpDir + "/config.yaml"
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+cert_storage_path: "storage"
+auto_domains:
+  certs:
+    web:
+      domains: ["web.example.com"]
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+
+	app.config.MetricsFormat = "openmetrics"
+	var out bytes.Buffer
+	if err := app.HandleMetricsDump(&out); err != nil {
+		t.Fatalf("HandleMetricsDump failed: %v", err)
+	}
+	if !strings.Contains(out.String(), `acme_dns_manager_certificate_configured{cert="web"} 1`) {
+		t.Errorf("Expected configured certificate in output, got:\n%s", out.String())
+	}
+
+	app.config.MetricsFormat = "json"
+	out.Reset()
+	if err := app.HandleMetricsDump(&out); err != nil {
+		t.Fatalf("HandleMetricsDump failed: %v", err)
+	}
+	if !strings.Contains(out.String(), `"name": "web"`) {
+		t.Errorf("Expected JSON output for web, got:\n%s", out.String())
+	}
+
+	app.config.MetricsFormat = "xml"
+	if err := app.HandleMetricsDump(&out); err == nil {
+		t.Error("Expected error for unknown metrics format")
+	}
+}
+
+// TestApplication_HandleFsck tests reporting and repairing storage discrepancies
+func TestApplication_HandleFsck(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := tmpDir + "/config.yaml"
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+cert_storage_path: "storage"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	storage := filepath.Join(tmpDir, "storage")
+	if err := os.MkdirAll(storage, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(storage, "notes.txt"), []byte("manual"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+
+	var out bytes.Buffer
+	if err := app.HandleFsck(&out); err == nil {
+		t.Error("Expected error for a foreign file")
+	}
+	if !strings.Contains(out.String(), "foreign  notes.txt") {
+		t.Errorf("Expected foreign file in output, got:\n%s", out.String())
+	}
+
+	app.config.FsckRepair = true
+	out.Reset()
+	if err := app.HandleFsck(&out); err != nil {
+		t.Fatalf("Expected repair to succeed, got %v", err)
+	}
+	if !strings.Contains(out.String(), "added to manifest") {
+		t.Errorf("Expected repair note in output, got:\n%s", out.String())
+	}
+
+	app.config.FsckRepair = false
+	out.Reset()
+	if err := app.HandleFsck(&out); err != nil || out.Len() != 0 {
+		t.Errorf("Expected clean check after repair, got %v:\n%s", err, out.String())
+	}
+}
+
+// TestApplication_HandleVerifyStorage tests reporting and repairing certificate file problems
+func TestApplication_HandleVerifyStorage(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := tmpDir + "/config.yaml"
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+cert_storage_path: "storage"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	storage := filepath.Join(tmpDir, "storage")
+	if err := createTestCertificateFiles(storage, "web", []string{"example.com"}, 60); err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	if err := os.Remove(filepath.Join(storage, "certificates", "web.json")); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+
+	var out bytes.Buffer
+	if err := app.HandleVerifyStorage(&out); err == nil {
+		t.Error("Expected error for missing metadata")
+	}
+	if !strings.Contains(out.String(), "missing-metadata") {
+		t.Errorf("Expected missing metadata in output, got:\n%s", out.String())
+	}
+
+	app.config.VerifyStorageRepair = true
+	out.Reset()
+	if err := app.HandleVerifyStorage(&out); err != nil {
+		t.Fatalf("Expected repair to succeed, got %v:\n%s", err, out.String())
+	}
+
+	app.config.VerifyStorageRepair = false
+	out.Reset()
+	if err := app.HandleVerifyStorage(&out); err != nil || out.Len() != 0 {
+		t.Errorf("Expected clean check after repair, got %v:\n%s", err, out.String())
+	}
+}
+
+// TestApplication_HandleFixPerms tests listing and correcting file modes against file_permissions
+func TestApplication_HandleFixPerms(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not meaningful on Windows")
+	}
+	tmpDir := t.TempDir()
+	configPath := tmpDir + "/config.yaml"
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+cert_storage_path: "storage"
+file_permissions:
+  key_mode: "0640"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	storage := filepath.Join(tmpDir, "storage")
+	if err := createTestCertificateFiles(storage, "web", []string{"example.com"}, 60); err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyPath := filepath.Join(storage, "certificates", "web.key")
+	if err := os.Chmod(keyPath, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+	app.config.DryRun = true
+
+	var out bytes.Buffer
+	if err := app.HandleFixPerms(&out); err != nil {
+		t.Fatalf("HandleFixPerms failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "differs  certificates/web.key: mode 0600, should be 0640") {
+		t.Errorf("Expected the key in the output, got:\n%s", out.String())
+	}
+
+	app.config.DryRun = false
+	out.Reset()
+	if err := app.HandleFixPerms(&out); err != nil {
+		t.Fatalf("HandleFixPerms failed: %v", err)
+	}
+	if info, err := os.Stat(keyPath); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("Expected the key to be corrected to 0640, got %v, %v", info, err)
+	}
+
+	out.Reset()
+	if err := app.HandleFixPerms(&out); err != nil || strings.Contains(out.String(), "web.key") {
+		t.Errorf("Expected the key to be in order, got %v:\n%s", err, out.String())
+	}
+}
+
+// TestApplication_HandleDiff tests reporting configuration drift against the state file
+func TestApplication_HandleDiff(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := tmpDir + "/config.yaml"
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+cert_storage_path: "storage"
+auto_domains:
+  certs:
+    web:
+      domains: ["example.com", "www.example.com"]
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+
+	var out bytes.Buffer
+	if err := app.HandleDiff(&out); err == nil {
+		t.Error("Expected error for a certificate not issued yet")
+	}
+	if !strings.Contains(out.String(), "web: not issued yet") {
+		t.Errorf("Expected new certificate in output, got:\n%s", out.String())
+	}
+
+	storage := filepath.Join(tmpDir, "storage")
+	state := manager.CertState{
+		Domains:    []string{"example.com", "www.example.com"},
+		KeyType:    manager.DefaultKeyType,
+		AcmeServer: "https://acme-staging-v02.api.letsencrypt.org/directory",
+	}
+	if err := manager.UpdateCertState(storage, "web", state); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := app.HandleDiff(&out); err != nil || out.Len() != 0 {
+		t.Errorf("Expected no drift, got %v:\n%s", err, out.String())
+	}
+}
+
+func TestApplication_HandleDelete(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := tmpDir + "/config.yaml"
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+cert_storage_path: "storage"
+run_report: "report.json"
+auto_domains:
+  certs:
+    web:
+      domains: ["example.com"]
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	storage := filepath.Join(tmpDir, "storage")
+	for _, name := range []string{"web", "old"} {
+		if err := manager.UpdateCertState(storage, name, manager.CertState{LastResult: "issued"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reportPath := filepath.Join(tmpDir, "report.json")
+	report := newRunReport(time.Now(), "auto", []CertResult{{Name: "web", Outcome: OutcomeSkipped}, {Name: "old", Outcome: OutcomeSkipped}}, nil)
+	if err := writeRunReport(reportPath, report); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+
+	var out bytes.Buffer
+	if err := app.HandleOrphanScan(&out); err != nil || !strings.Contains(out.String(), "state   old ") {
+		t.Errorf("Expected the state of old as orphan, got %v:\n%s", err, out.String())
+	}
+	if err := app.HandleDelete(context.Background(), "web"); ExitCode(err) != ExitConfigError {
+		t.Errorf("Expected a config error for a configured certificate, got %v", err)
+	}
+	if err := app.HandleDelete(context.Background(), "old"); err != nil {
+		t.Fatalf("HandleDelete failed: %v", err)
+	}
+
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var updated RunReport
+	if err := json.Unmarshal(data, &updated); err != nil {
+		t.Fatal(err)
+	}
+	if len(updated.Certificates) != 1 || updated.Certificates[0].Name != "web" {
+		t.Errorf("Expected only web in the run report, got %+v", updated.Certificates)
+	}
+	out.Reset()
+	if err := app.HandleOrphanScan(&out); err != nil || out.Len() != 0 {
+		t.Errorf("Expected no orphans after the delete, got %v:\n%s", err, out.String())
+	}
+}
+
+func TestApplication_HandlePruneAcmeDNSAccounts(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := tmpDir + "/config.yaml"
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+cert_storage_path: "storage"
+auto_domains:
+  certs:
+    web:
+      domains: ["example.com"]
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	accounts := `{"example.com": {"fulldomain": "a.auth.example.org"}, "old.example.com": {"fulldomain": "b.auth.example.org"}}`
+	if err := os.MkdirAll(filepath.Join(tmpDir, "storage"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	accountsPath := filepath.Join(tmpDir, "storage", manager.AcmeDNSAccountsFile)
+	if err := os.WriteFile(accountsPath, []byte(accounts), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+	app.config.DryRun = true
+
+	var out bytes.Buffer
+	if err := app.HandlePruneAcmeDNSAccounts(&out); err != nil || out.String() != "old.example.com b.auth.example.org\n" {
+		t.Errorf("Expected old.example.com as unused, got %v:\n%s", err, out.String())
+	}
+	if data, _ := os.ReadFile(accountsPath); string(data) != accounts {
+		t.Errorf("Expected a dry run to keep the accounts, got %s", data)
+	}
+
+	app.config.DryRun = false
+	out.Reset()
+	if err := app.HandlePruneAcmeDNSAccounts(&out); err != nil {
+		t.Fatalf("HandlePruneAcmeDNSAccounts failed: %v", err)
+	}
+	data, err := os.ReadFile(accountsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "old.example.com") || !strings.Contains(string(data), "a.auth.example.org") {
+		t.Errorf("Expected only the account of example.com to remain, got %s", data)
+	}
+}
+
+func TestApplication_HandleMigrateAccounts(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := tmpDir + "/config.yaml"
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+cert_storage_path: "storage"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(tmpDir, "storage"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	accounts := `{"*.example.com": {"fulldomain": "a.auth.example.org"}}`
+	accountsPath := filepath.Join(tmpDir, "storage", manager.AcmeDNSAccountsFile)
+	if err := os.WriteFile(accountsPath, []byte(accounts), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+	app.config.DryRun = true
+
+	var out bytes.Buffer
+	if err := app.HandleMigrateAccounts(context.Background(), &out, nil); err != nil || out.String() != "example.com a.auth.example.org (stored as *.example.com)\n" {
+		t.Errorf("Expected example.com to be migrated, got %v:\n%s", err, out.String())
+	}
+	if data, _ := os.ReadFile(accountsPath); string(data) != accounts {
+		t.Errorf("Expected a dry run to keep the accounts, got %s", data)
+	}
+
+	app.config.DryRun = false
+	if err := app.HandleMigrateAccounts(context.Background(), &out, nil); err != nil {
+		t.Fatalf("HandleMigrateAccounts failed: %v", err)
+	}
+	data, err := os.ReadFile(accountsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"example.com"`) || !strings.Contains(string(data), `"*.example.com"`) {
+		t.Errorf("Expected base and wildcard key, got %s", data)
+	}
+}
+
+// TestApplication_HandleImportCertbot_MissingDir tests the error for a directory without certbot data
+func TestApplication_HandleImportCertbot_MissingDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := tmpDir + "/config.yaml"
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+cert_storage_path: "storage"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+
+	var out bytes.Buffer
+	err := app.HandleImportCertbot(&out, filepath.Join(tmpDir, "letsencrypt"))
+	if err == nil {
+		t.Fatal("Expected error for missing certbot directory")
+	}
+	appErr := common.GetApplicationError(err)
+	if appErr == nil || appErr.Context["certbot_dir"] == nil {
+		t.Errorf("Expected application error with certbot_dir context, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected no output, got:\n%s", out.String())
+	}
+}
+
+// TestApplication_HandleAdopt tests taking over a certificate and printing its auto_domains entry
+func TestApplication_HandleAdopt(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := tmpDir + "/config.yaml"
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+cert_storage_path: "storage"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	certPEM, keyPEM, err := generateTestCertificate("example.com", []string{"example.com", "www.example.com"}, time.Now().Add(-time.Hour), time.Now().Add(30*24*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to generate certificate: %v", err)
+	}
+	certFile, keyFile := filepath.Join(tmpDir, "web.crt"), filepath.Join(tmpDir, "web.key")
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+	app.config.Adopt = "web"
+
+	var out bytes.Buffer
+	if err := app.HandleAdopt(&out); err == nil {
+		t.Error("Expected error without -adopt-cert and -adopt-key")
+	}
+
+	app.config.AdoptCert = certFile
+	app.config.AdoptKey = keyFile
+	if err := app.HandleAdopt(&out); err != nil {
+		t.Fatalf("HandleAdopt failed: %v", err)
+	}
+	for _, want := range []string{"auto_domains:", "web:", "- www.example.com", "key_type: rsa2048"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in output, got:\n%s", want, out.String())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "storage", "certificates", "web.json")); err != nil {
+		t.Errorf("Expected synthesized metadata: %v", err)
+	}
+
+	if err := app.HandleAdopt(&out); err == nil {
+		t.Error("Expected error when adopting the same name twice")
+	}
+}
+
+// TestApplication_HandleRollback tests restoring an archived certificate generation
+func TestApplication_HandleRollback(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := tmpDir + "/config.yaml"
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+cert_storage_path: "storage"
+auto_domains:
+  grace_days: 30
+  certs:
+    web:
+      domains: ["example.com"]
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	app := NewApplication("test-version")
+	logger := &mockLogger{}
+	app.logger = logger
+	app.config.ConfigPath = configPath
+
+	if err := app.HandleRollback("web"); err == nil {
+		t.Error("Expected error without archived generations")
+	}
+
+	// An archived generation that expires within grace_days
+	storage := filepath.Join(tmpDir, "storage")
+	genDir := filepath.Join(storage, manager.ArchiveDirName, "web", "20250101T000000.000000Z")
+	if err := createTestCertificateFiles(genDir, "web", []string{"example.com"}, 10); err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	if err := os.Rename(filepath.Join(genDir, "certificates"), genDir+".tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(genDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(genDir+".tmp", genDir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.HandleRollback("web"); err != nil {
+		t.Fatalf("HandleRollback failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(storage, "certificates", "web.crt")); err != nil {
+		t.Errorf("Expected restored certificate: %v", err)
+	}
+	found := false
+	for _, msg := range logger.warnMessages {
+		if strings.Contains(msg, "replaces certificate web again") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected warning about the renewal window, got %v", logger.warnMessages)
+	}
+}
+
+// TestApplication_HandleHistory tests listing and checking the audit log
+func TestApplication_HandleHistory(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+cert_storage_path: "storage"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	storage := filepath.Join(tmpDir, "storage")
+	for _, record := range []manager.AuditRecord{
+		{Action: manager.AuditRegisterAccount, Result: manager.AuditSuccess, Account: "https://ca.example.com/acct/1"},
+		{Action: manager.AuditIssue, Result: manager.AuditSuccess, CertName: "web", Domains: []string{"web.example.com"}, OrderURL: "https://ca.example.com/order/1"},
+		{Action: manager.AuditIssue, Result: manager.AuditSuccess, CertName: "api", Domains: []string{"api.example.com"}},
+		{Action: manager.AuditRenew, Result: manager.AuditFailed, CertName: "web", Error: "order rejected"},
+	} {
+		if err := manager.AppendAudit(storage, record); err != nil {
+			t.Fatalf("AppendAudit failed: %v", err)
+		}
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+
+	var out bytes.Buffer
+	if err := app.HandleHistory(&out, "web"); err != nil {
+		t.Fatalf("HandleHistory failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected the 2 records of web, got:\n%s", out.String())
+	}
+	if !strings.Contains(lines[0], "issue") || !strings.Contains(lines[0], "https://ca.example.com/order/1") {
+		t.Errorf("Unexpected issue record %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "failed") || !strings.Contains(lines[1], "error: order rejected") {
+		t.Errorf("Unexpected renew record %q", lines[1])
+	}
+
+	out.Reset()
+	if err := app.HandleHistory(&out, "*"); err != nil {
+		t.Fatalf("HandleHistory failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "https://ca.example.com/acct/1") || strings.Count(out.String(), "\n") != 4 {
+		t.Errorf("Expected all records, got:\n%s", out.String())
+	}
+
+	// Dropping the api record breaks the chain at the record after it
+	path := filepath.Join(storage, manager.AuditLogFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := strings.SplitAfter(string(data), "\n")
+	if err := os.WriteFile(path, []byte(records[0]+records[1]+records[3]), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	err = app.HandleHistory(&out, "web")
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Expected the broken chain to be reported, got %v", err)
+	}
+	if strings.Count(out.String(), "\n") != 2 {
+		t.Errorf("Expected the records to be listed anyway, got:\n%s", out.String())
+	}
+}
+
+// TestApplication_HandleRotateAccountKey_NoAccount tests the error without registered accounts
+func TestApplication_HandleRotateAccountKey_NoAccount(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := tmpDir + "/config.yaml"
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+cert_storage_path: "storage"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+
+	err := app.HandleRotateAccountKey()
+	var appErr *common.ApplicationError
+	if !errors.As(err, &appErr) || appErr.Type != common.ErrorTypeACME {
+		t.Errorf("Expected ACME error without accounts, got %v", err)
+	}
+}
+
+// TestApplication_AcquireInstanceLock tests the structured error for a held lock
+func TestApplication_AcquireInstanceLock(t *testing.T) {
+	storage := t.TempDir()
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+
+	lock, err := app.AcquireInstanceLock(context.Background(), storage)
+	if err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+	defer func() { _ = lock.Release() }()
+
+	app.config.LockTimeout = 100 * time.Millisecond
+	_, err = app.AcquireInstanceLock(context.Background(), storage)
+	if !errors.Is(err, manager.ErrLockHeld) {
+		t.Fatalf("Expected ErrLockHeld, got %v", err)
+	}
+	var appErr *common.ApplicationError
+	if !errors.As(err, &appErr) || appErr.Type != common.ErrorTypeStorage || appErr.Operation != "acquire lock" {
+		t.Errorf("Expected structured storage error, got %#v", err)
+	}
+}
+
+// TestApplication_LoadManagerConfig_WaitForDNS tests that -wait-for-dns reaches the manager config
+func TestApplication_LoadManagerConfig_WaitForDNS(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := tmpDir + "/config.yaml"
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		t.Fatalf("LoadManagerConfig failed: %v", err)
+	}
+	if cfg.DNSWait != nil {
+		t.Error("Expected no DNS wait options without -wait-for-dns")
+	}
+
+	app.config.WaitForDNS = true
+	app.config.WaitForDNSTimeout = 5 * time.Minute
+	app.config.WaitForDNSInterval = 10 * time.Second
+	cfg, err = app.LoadManagerConfig()
+	if err != nil {
+		t.Fatalf("LoadManagerConfig failed: %v", err)
+	}
+	if cfg.DNSWait == nil || cfg.DNSWait.Timeout != 5*time.Minute || cfg.DNSWait.Interval != 10*time.Second {
+		t.Errorf("Unexpected DNS wait options: %+v", cfg.DNSWait)
+	}
+}
+
+// TestApplication_LoadManagerConfig_Overrides tests that command line
+// overrides replace config values, including per-certificate servers
+func TestApplication_LoadManagerConfig_Overrides(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := tmpDir + "/config.yaml"
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+acme_dns_servers:
+  example.org: "https://acme-dns.example.org"
+auto_domains:
+  certs:
+    web:
+      domains: ["example.com"]
+      acme_server: "https://acme.zerossl.com/v2/DV90"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+	app.config.AcmeServer = "https://acme-staging-v02.api.letsencrypt.org/directory"
+	app.config.AcmeDnsServer = "https://acme-dns.staging.example.com"
+	app.config.StoragePath = filepath.Join(tmpDir, "staging")
+	app.config.Email = "staging@example.com"
+	app.config.Set = []string{"auto_domains.grace_days=10", "auto_domains.certs.web.key_type=ec384"}
+
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		t.Fatalf("LoadManagerConfig failed: %v", err)
+	}
+	if cfg.Email != "staging@example.com" || cfg.CertStoragePath != filepath.Join(tmpDir, "staging") {
+		t.Errorf("Unexpected email %q or storage path %q", cfg.Email, cfg.CertStoragePath)
+	}
+	if cfg.ForCert("web").AcmeServer != app.config.AcmeServer {
+		t.Errorf("Expected the per-certificate ACME server to be overridden, got %s", cfg.ForCert("web").AcmeServer)
+	}
+	if cfg.AcmeDnsServers["example.org"] != app.config.AcmeDnsServer {
+		t.Errorf("Expected the per-suffix acme-dns server to be overridden, got %s", cfg.AcmeDnsServers["example.org"])
+	}
+	if cfg.AutoDomains.GraceDays != 10 || cfg.AutoDomains.Certs["web"].KeyType != "ec384" {
+		t.Errorf("Expected -set values, got grace_days %d, key_type %q", cfg.AutoDomains.GraceDays, cfg.AutoDomains.Certs["web"].KeyType)
+	}
+
+	// Overrides are validated like the config file
+	app.config.Set = []string{"auto_domains.grace_days=soon"}
+	if _, err := app.LoadManagerConfig(); err == nil {
+		t.Error("Expected a validation error for a non-numeric grace_days")
+	}
+	app.config.Set = []string{"grace_days"}
+	if _, err := app.LoadManagerConfig(); err == nil {
+		t.Error("Expected an error for an override without value")
+	}
+}
+
+// TestApplication_LoadConfiguration_Environment tests that ACME_DNS_MANAGER_*
+// variables override the config file and can replace it entirely
+func TestApplication_LoadConfiguration_Environment(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("ACME_DNS_MANAGER_EMAIL", "env@example.com")
+	t.Setenv("ACME_DNS_MANAGER_ACME_SERVER", "https://acme-staging-v02.api.letsencrypt.org/directory")
+	t.Setenv("ACME_DNS_MANAGER_ACME_DNS_SERVER", "https://acme-dns.example.com")
+	t.Setenv("ACME_DNS_MANAGER_STORAGE_PATH", filepath.Join(tmpDir, "storage"))
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = filepath.Join(tmpDir, "missing.yaml")
+
+	cfg, err := app.LoadConfiguration()
+	if err != nil {
+		t.Fatalf("Expected the environment to provide the configuration, got %v", err)
+	}
+	if cfg.Email != "env@example.com" || cfg.CertStoragePath != filepath.Join(tmpDir, "storage") {
+		t.Errorf("Unexpected email %q or storage path %q", cfg.Email, cfg.CertStoragePath)
+	}
+
+	// The environment overrides the file, the command line overrides both
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	configContent := `email: "file@example.com"
+acme_server: "https://acme-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.file.example.com"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	app.config.ConfigPath = configPath
+	app.config.AcmeDnsServer = "https://acme-dns.flag.example.com"
+	cfg, err = app.LoadConfiguration()
+	if err != nil {
+		t.Fatalf("LoadConfiguration failed: %v", err)
+	}
+	if cfg.Email != "env@example.com" || cfg.AcmeDnsServer != "https://acme-dns.flag.example.com" {
+		t.Errorf("Unexpected email %q or acme-dns server %q", cfg.Email, cfg.AcmeDnsServer)
+	}
+}
+
+// TestApplication_HandleValidate tests the grouped pre-flight report
+func TestApplication_HandleValidate(t *testing.T) {
+	healthy := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/directory":
+			_, _ = fmt.Fprint(w, `{"newNonce": "n", "newAccount": "a", "newOrder": "o"}`)
+		case "/health":
+			if !healthy {
+				http.NotFound(w, r)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	configContent := fmt.Sprintf(`email: "test@example.com"
+acme_server: "%s/directory"
+acme_dns_server: "%s"
+cert_storage_path: "storage/new"
+auto_domains:
+  certs:
+    web:
+      domains: ["example.com"]
+      acme_server: "%s/other"
+`, server.URL, server.URL, server.URL)
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+
+	var out bytes.Buffer
+	err := app.HandleValidate(context.Background(), &out)
+	if err == nil {
+		t.Fatal("Expected an error for the unknown per-certificate ACME directory")
+	}
+	report := out.String()
+	for _, want := range []string{
+		"config:\n  OK",
+		"storage:\n  OK   " + filepath.Join(tmpDir, "storage", "new") + " (does not exist yet",
+		"acme:\n  OK   " + server.URL + "/directory\n  FAIL " + server.URL + "/other: directory request returned 404",
+		"acme-dns:\n  OK   " + server.URL,
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("Expected %q in report:\n%s", want, report)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "storage")); !os.IsNotExist(err) {
+		t.Error("Expected the pre-flight check not to create the storage directory")
+	}
+
+	// Storage below a file and an unhealthy acme-dns server
+	healthy = false
+	if err := os.WriteFile(filepath.Join(tmpDir, "storage"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := app.HandleValidate(context.Background(), &out); err == nil {
+		t.Fatal("Expected an error")
+	}
+	report = out.String()
+	if !strings.Contains(report, "is not a directory") || !strings.Contains(report, "/health returned 404") {
+		t.Errorf("Expected storage and acme-dns failures in report:\n%s", report)
+	}
+
+	// A broken config is reported in the config group
+	app.config.ConfigPath = filepath.Join(tmpDir, "missing.yaml")
+	out.Reset()
+	if err := app.HandleValidate(context.Background(), &out); err == nil || !strings.Contains(out.String(), "config:\n  FAIL") {
+		t.Errorf("Expected a config failure, got %v:\n%s", err, out.String())
+	}
+}
+
+// TestApplication_HandleCheck tests the plugin states, output and exit codes
+func TestApplication_HandleCheck(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+cert_storage_path: "storage"
+auto_domains:
+  certs:
+    web:
+      domains: ["web.example.com"]
+    api:
+      domains: ["api.example.com"]
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	storage := filepath.Join(tmpDir, "storage")
+	if err := createTestCertificateFiles(storage, "web", []string{"web.example.com"}, 60); err != nil {
+		t.Fatal(err)
+	}
+	if err := createTestCertificateFiles(storage, "api", []string{"api.example.com"}, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+	app.config.CheckWarningDays = DefaultCheckWarningDays
+	app.config.CheckCriticalDays = DefaultCheckCriticalDays
+
+	var out bytes.Buffer
+	err := app.HandleCheck(&out)
+	if ExitCode(err) != CheckWarning {
+		t.Fatalf("Expected WARNING, got %v", err)
+	}
+	line := out.String()
+	if !strings.HasPrefix(line, "CERTIFICATES WARNING - 0 critical, 1 warning, 1 ok: api expires in ") ||
+		!strings.Contains(line, "| 'api'=") || !strings.Contains(line, ";14:;7:;; 'web'=") ||
+		strings.Count(line, "\n") != 1 {
+		t.Errorf("Unexpected plugin output: %q", line)
+	}
+
+	// A missing certificate is critical
+	if err := os.Remove(filepath.Join(storage, "certificates", "web.crt")); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := app.HandleCheck(&out); ExitCode(err) != CheckCritical || !strings.Contains(out.String(), "1 critical, 1 warning, 0 ok: web missing, api") {
+		t.Errorf("Expected CRITICAL for the missing certificate, got %v: %q", err, out.String())
+	}
+
+	// Lower thresholds turn the state OK
+	app.config.CheckWarningDays, app.config.CheckCriticalDays = 5, 2
+	if err := os.WriteFile(configPath, []byte(strings.SplitN(configContent, "    web:", 2)[0]+"    api:\n      domains: [\"api.example.com\"]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := app.HandleCheck(&out); err != nil || !strings.HasPrefix(out.String(), "CERTIFICATES OK - 0 critical, 0 warning, 1 ok |") {
+		t.Errorf("Expected OK, got %v: %q", err, out.String())
+	}
+
+	// A config that does not load is UNKNOWN
+	app.config.ConfigPath = filepath.Join(tmpDir, "missing.yaml")
+	out.Reset()
+	if err := app.HandleCheck(&out); ExitCode(err) != CheckUnknown || !strings.HasPrefix(out.String(), "CERTIFICATES UNKNOWN - ") {
+		t.Errorf("Expected UNKNOWN, got %v: %q", err, out.String())
+	}
+}
+
+// TestApplication_HandleDebugBundle tests that the bundle has all parts and no secrets
+func TestApplication_HandleDebugBundle(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+dns_resolver: "127.0.0.1:1"
+cert_storage_path: "storage"
+auto_domains:
+  certs:
+    web:
+      domains: ["web.example.com"]
+      pfx_password: "pfx-secret"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	storage := filepath.Join(tmpDir, "storage")
+	if err := createTestCertificateFiles(storage, "web", []string{"web.example.com"}, 60); err != nil {
+		t.Fatal(err)
+	}
+	store, err := manager.NewAccountStore(filepath.Join(storage, manager.AcmeDNSAccountsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetAccount("web.example.com", manager.AcmeDnsAccount{Username: "user", Password: "acmedns-secret", FullDomain: "abc.acme-dns.example.com"})
+	if err := store.SaveAccounts(); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+	app.config.DebugBundle = filepath.Join(tmpDir, "debug.tar.gz")
+	app.debugLog = bytes.NewBufferString("captured log line\n")
+	if err := app.HandleDebugBundle(context.Background()); err != nil {
+		t.Fatalf("HandleDebugBundle failed: %v", err)
+	}
+
+	files := readTarGz(t, app.config.DebugBundle)
+	for _, name := range []string{"version.txt", "config.yaml", "certificates.json", "dns.json", "debug.log"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("Expected %s in the bundle, got %v", name, files)
+		}
+	}
+	if !strings.Contains(files["version.txt"], "go-acme-dns-manager test-version") ||
+		!strings.Contains(files["certificates.json"], `"dns_names": [`) ||
+		!strings.Contains(files["dns.json"], `"expected_cname": "abc.acme-dns.example.com"`) ||
+		files["debug.log"] != "captured log line\n" {
+		t.Errorf("Unexpected bundle content: %v", files)
+	}
+	for name, content := range files {
+		for _, secret := range []string{"pfx-secret", "acmedns-secret", "PRIVATE KEY"} {
+			if strings.Contains(content, secret) {
+				t.Errorf("Expected no %q in %s:\n%s", secret, name, content)
+			}
+		}
+	}
+
+	// A broken configuration is reported in the bundle
+	if err := os.WriteFile(configPath, []byte("email: [\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.HandleDebugBundle(context.Background()); err != nil {
+		t.Fatalf("HandleDebugBundle failed for a broken config: %v", err)
+	}
+	files = readTarGz(t, app.config.DebugBundle)
+	if !strings.Contains(files["errors.txt"], "loading config") {
+		t.Errorf("Expected the config error in errors.txt, got %v", files)
+	}
+}
+
+// readTarGz returns the files of a tar.gz by base name
+func readTarGz(t *testing.T, path string) map[string]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[filepath.Base(header.Name)] = string(data)
+	}
+}
+
+// TestApplication_HandleTestAcmeDNS tests that a failing acme-dns account is
+// reported and fails the run
+func TestApplication_HandleTestAcmeDNS(t *testing.T) {
+	acmeDNS := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": "forbidden"}`, http.StatusUnauthorized)
+	}))
+	defer acmeDNS.Close()
+
+	tmpDir := t.TempDir()
+	configPath := tmpDir + "/config.yaml"
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "` + acmeDNS.URL + `"
+cert_storage_path: "storage"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+
+	// Without accounts there is nothing to test
+	var out bytes.Buffer
+	if err := app.HandleTestAcmeDNS(context.Background(), &out); err != nil {
+		t.Fatalf("HandleTestAcmeDNS without accounts failed: %v", err)
+	}
+
+	storage := filepath.Join(tmpDir, "storage")
+	if err := os.MkdirAll(storage, 0700); err != nil {
+		t.Fatal(err)
+	}
+	accounts := `{"example.com": {"username": "u", "password": "p", "fulldomain": "abc.auth.example.org", "subdomain": "abc"}}`
+	if err := os.WriteFile(filepath.Join(storage, manager.AcmeDNSAccountsFile), []byte(accounts), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := app.HandleTestAcmeDNS(context.Background(), &out)
+	var appErr *common.ApplicationError
+	if !errors.As(err, &appErr) || appErr.Type != common.ErrorTypeDNS {
+		t.Fatalf("Expected a DNS error, got %v", err)
+	}
+	if !strings.Contains(out.String(), "FAIL  example.com  update:") {
+		t.Errorf("Expected a failed update for example.com, got:\n%s", out.String())
+	}
+}
+
+// TestApplication_HandleRotateAcmeDNSAccount tests the error for a domain
+// without acme-dns account
+func TestApplication_HandleRotateAcmeDNSAccount(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := tmpDir + "/config.yaml"
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+cert_storage_path: "storage"
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+
+	err := app.HandleRotateAcmeDNSAccount(context.Background(), "example.com")
+	var appErr *common.ApplicationError
+	if !errors.As(err, &appErr) || appErr.Type != common.ErrorTypeConfig || !errors.Is(err, manager.ErrNoAcmeDNSAccount) {
+		t.Errorf("Expected a config error for a domain without account, got %v", err)
+	}
+}
+
+// TestApplication_HandleOptimize tests printing domain lists without names a wildcard covers
+func TestApplication_HandleOptimize(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	configContent := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+auto_domains:
+  certs:
+    web:
+      domains: ["example.com", "*.example.com", "www.example.com", "shop.example.com"]
+    mail:
+      domains: ["mail.example.com"]
+`
+	if err := os.WriteFile(configPath, []byte(configContent), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+
+	var out bytes.Buffer
+	if err := app.HandleOptimize(&out); err != nil {
+		t.Fatalf("HandleOptimize failed: %v", err)
+	}
+	want := `auto_domains:
+  certs:
+    # *.example.com covers www.example.com, shop.example.com
+    web:
+      domains:
+        - "example.com"
+        - "*.example.com"
+`
+	if out.String() != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, out.String())
+	}
+}
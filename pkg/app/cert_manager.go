@@ -6,17 +6,24 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
 	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+	"github.com/oetiker/go-acme-dns-manager/pkg/events"
 	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+	"github.com/oetiker/go-acme-dns-manager/pkg/metrics"
 )
 
-// LegoRunnerFunc is a function type that matches the signature of manager.RunLegoWithStore
-type LegoRunnerFunc func(cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error
+// LegoRunnerFunc is a function type that matches the signature of manager.RunLegoWithStoreContext.
+// Canceling ctx must abort the ACME exchange.
+type LegoRunnerFunc func(ctx context.Context, cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error
 
 // DefaultLegoRunner is the default implementation that calls the real ACME server
-var DefaultLegoRunner LegoRunnerFunc = manager.RunLegoWithStore
+var DefaultLegoRunner LegoRunnerFunc = manager.RunLegoWithStoreContext
 
 // CertificateManager handles certificate operations with clean separation of concerns
 type CertificateManager struct {
@@ -25,28 +32,86 @@ type CertificateManager struct {
 	accountStore interface{}
 	legoRunner   LegoRunnerFunc
 	dnsResolver  manager.DNSResolver // Optional DNS resolver for testing
-	testMode     bool                 // Skip batch pre-check in test mode
+	testMode     bool                // Skip batch pre-check in test mode
+	publisher    events.Publisher    // Optional lifecycle event publisher
+	maxParallel  int                 // Number of certificates processed concurrently
+	// continueOnError processes all certificates despite failures (auto mode)
+	continueOnError bool
+	// quiet limits the summary to runs that changed something, see logSummary
+	quiet bool
+	// only and skip select the auto_domains certificates of a run
+	only []string
+	skip []string
+	// lastResults holds the results of the last run, see Results
+	lastResults []CertResult
+	// runStarted is the start of the current run, for the run report
+	runStarted time.Time
+	// notifiers alert people to the events of a run, see notifyRun
+	notifiers []notificationTarget
+	// dnsSetup holds the records reported during the current run
+	notifyMu sync.Mutex
+	dnsSetup []manager.DNSSetupInfo
+	// statsd receives the counters and timings of the manager, nil if not configured
+	statsd *metrics.StatsdClient
 }
 
 // NewCertificateManager creates a new certificate manager
 func NewCertificateManager(config *manager.Config, logger common.LoggerInterface) (*CertificateManager, error) {
-	accountsFilePath := filepath.Join(config.CertStoragePath, "acme-dns-accounts.json")
+	accountsFilePath := filepath.Join(config.CertStoragePath, manager.AcmeDNSAccountsFile)
+	if config.AccountsLayout == manager.AccountsLayoutSharded {
+		accountsFilePath = filepath.Join(config.CertStoragePath, manager.AcmeDNSAccountsDir)
+	}
 	logger.Infof("Loading ACME DNS accounts from %s...", accountsFilePath)
 
 	// Initialize the account store
-	store, err := manager.NewAccountStore(accountsFilePath)
+	store, err := manager.NewConfigAccountStore(config)
 	if err != nil {
 		return nil, fmt.Errorf("creating account store: %w", err)
 	}
 
 	logger.Info("ACME DNS accounts loaded successfully.")
 
-	return &CertificateManager{
+	publisher, err := newEventPublisher(config)
+	if err != nil {
+		return nil, err
+	}
+
+	notifiers, err := newNotificationTargets(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var statsd *metrics.StatsdClient
+	if config.StatsdAddr != "" {
+		prefix := config.StatsdPrefix
+		if prefix == "" {
+			prefix = metrics.DefaultStatsdPrefix
+		}
+		if statsd, err = metrics.NewStatsdClient(config.StatsdAddr, prefix, config.StatsdTags); err != nil {
+			return nil, fmt.Errorf("configuring statsd: %w", err)
+		}
+		config.Stats = statsd
+	}
+
+	cm := &CertificateManager{
 		config:       config,
 		logger:       logger,
 		accountStore: store,
 		legoRunner:   DefaultLegoRunner,
-	}, nil
+		publisher:    publisher,
+		notifiers:    notifiers,
+		statsd:       statsd,
+	}
+	// Collect the records displayed during a run for the notification,
+	// wherever they are reported
+	reported := config.DNSSetupReported
+	config.DNSSetupReported = func(setupInfo []manager.DNSSetupInfo) {
+		cm.collectDNSSetup(setupInfo)
+		if reported != nil {
+			reported(setupInfo)
+		}
+	}
+	return cm, nil
 }
 
 // SetLegoRunner sets a custom Lego runner function (mainly for testing)
@@ -55,6 +120,24 @@ func (cm *CertificateManager) SetLegoRunner(runner LegoRunnerFunc) {
 	cm.testMode = true // Setting a custom runner implies test mode
 }
 
+// SetMaxParallel sets how many certificates are processed concurrently
+func (cm *CertificateManager) SetMaxParallel(n int) {
+	cm.maxParallel = n
+}
+
+// SetQuiet makes the run summary silent when all certificates were skipped
+// and brief otherwise, for cron jobs that mail any output
+func (cm *CertificateManager) SetQuiet(quiet bool) {
+	cm.quiet = quiet
+}
+
+// SetCertFilter limits automatic mode to the certificates named in only, if
+// any, without those named in skip
+func (cm *CertificateManager) SetCertFilter(only, skip []string) {
+	cm.only = only
+	cm.skip = skip
+}
+
 // SetDNSResolver sets a custom DNS resolver (mainly for testing)
 func (cm *CertificateManager) SetDNSResolver(resolver manager.DNSResolver) {
 	cm.dnsResolver = resolver
@@ -88,7 +171,23 @@ func (cm *CertificateManager) ProcessAutoMode(ctx context.Context) error {
 		return nil
 	}
 
-	requests := cm.parseAutoRequests()
+	requests, err := cm.selectRequests(cm.parseAutoRequests())
+	if err != nil {
+		return err
+	}
+	if len(requests) == 0 {
+		// Names are checked, so only -skip can leave nothing to do
+		if len(cm.only) > 0 {
+			cm.logger.Info("All certificates selected by -only are excluded by -skip. Nothing to do.")
+		} else {
+			cm.logger.Info("All certificates are excluded by -skip. Nothing to do.")
+		}
+		return nil
+	}
+	cm.continueOnError = true
+	if cm.maxParallel == 0 {
+		cm.maxParallel = cm.config.GetMaxParallel()
+	}
 	return cm.processRequests(ctx, requests)
 }
 
@@ -121,9 +220,35 @@ func (cm *CertificateManager) parseManualRequests(args []string) ([]CertRequest,
 		requestedNames[certName] = struct{}{}
 	}
 
+	if err := cm.checkManualDuplicates(requests); err != nil {
+		return nil, err
+	}
 	return requests, nil
 }
 
+// checkManualDuplicates applies auto_domains.duplicate_domains to the manual
+// requests: a domain requested twice, or also by an auto_domains certificate
+// of another name, is warned about or refused. Duplicates within
+// auto_domains alone were reported when the config was loaded.
+func (cm *CertificateManager) checkManualDuplicates(requests []CertRequest) error {
+	certs := cm.config.AutoDomainsDomains()
+	manual := make(map[string]bool)
+	for _, req := range requests {
+		certs[req.Name] = req.Domains
+		manual[req.Name] = true
+	}
+	var duplicates []manager.DuplicateDomain
+	for _, d := range manager.FindDuplicateDomains(certs) {
+		for _, name := range d.Certs {
+			if manual[name] {
+				duplicates = append(duplicates, d)
+				break
+			}
+		}
+	}
+	return cm.config.ReportDuplicateDomains(duplicates)
+}
+
 // parseAutoRequests parses automatic requests from config
 func (cm *CertificateManager) parseAutoRequests() []CertRequest {
 	var requests []CertRequest
@@ -145,6 +270,71 @@ func (cm *CertificateManager) parseAutoRequests() []CertRequest {
 	return requests
 }
 
+// selectRequests applies the -only and -skip filters. Names that are not in
+// auto_domains are an error, a typo must not turn into a run that silently
+// skips the certificate the operator wanted to renew.
+func (cm *CertificateManager) selectRequests(requests []CertRequest) ([]CertRequest, error) {
+	if len(cm.only) == 0 && len(cm.skip) == 0 {
+		return requests, nil
+	}
+	var unknown []string
+	toSet := func(names []string) map[string]bool {
+		set := make(map[string]bool)
+		for _, name := range names {
+			if _, ok := cm.config.AutoDomains.Certs[name]; !ok {
+				unknown = append(unknown, name)
+			}
+			set[name] = true
+		}
+		return set
+	}
+	only := toSet(cm.only)
+	skip := toSet(cm.skip)
+	if len(unknown) > 0 {
+		var defined []string
+		for name := range cm.config.AutoDomains.Certs {
+			defined = append(defined, name)
+		}
+		sort.Strings(defined)
+		sort.Strings(unknown)
+		return nil, common.NewValidationError("select certificates",
+			fmt.Sprintf("Not defined in 'auto_domains.certs': %s", strings.Join(unknown, ", "))).
+			AddContext("certificates", strings.Join(defined, ", ")).
+			AddSuggestion("Use the certificate names of the 'auto_domains.certs' section with -only and -skip")
+	}
+
+	var selected []CertRequest
+	for _, req := range requests {
+		if (len(only) > 0 && !only[req.Name]) || skip[req.Name] {
+			cm.logger.Debugf("Certificate %s is not selected for this run", req.Name)
+			continue
+		}
+		selected = append(selected, req)
+	}
+	return selected, nil
+}
+
+// dnsSetupRequests returns the requests with a domain whose challenge or
+// alias record is in setupInfo, so events name the certificates waiting for
+// DNS rather than the records
+func (cm *CertificateManager) dnsSetupRequests(requests []CertRequest, setupInfo []manager.DNSSetupInfo) []CertRequest {
+	missing := make(map[string]bool, len(setupInfo))
+	for _, info := range setupInfo {
+		missing[info.ChallengeDomain] = true
+	}
+	var waiting []CertRequest
+	for _, req := range requests {
+		for _, domain := range req.Domains {
+			alias := cm.config.ChallengeAliasFor(domain)
+			if missing[manager.GetChallengeSubdomain(manager.GetBaseDomain(domain))] || alias != "" && missing[alias] {
+				waiting = append(waiting, req)
+				break
+			}
+		}
+	}
+	return waiting
+}
+
 // preCheckAllRequests performs batch DNS pre-checking for all certificates that need initialization
 func (cm *CertificateManager) preCheckAllRequests(ctx context.Context, requests []CertRequest) error {
 	// Skip batch pre-check in test mode (when using a mocked Lego runner)
@@ -156,6 +346,7 @@ func (cm *CertificateManager) preCheckAllRequests(ctx context.Context, requests
 
 	// Collect all domains from certificates that need initialization
 	var allDomains []string
+	var initRequests []CertRequest
 	renewalThreshold := cm.config.GetRenewalThreshold()
 
 	for _, req := range requests {
@@ -169,6 +360,7 @@ func (cm *CertificateManager) preCheckAllRequests(ctx context.Context, requests
 		if action == "init" {
 			cm.logger.Debugf("Certificate %s needs initialization, adding domains %v to pre-check", req.Name, req.Domains)
 			allDomains = append(allDomains, req.Domains...)
+			initRequests = append(initRequests, req)
 		}
 	}
 
@@ -180,74 +372,401 @@ func (cm *CertificateManager) preCheckAllRequests(ctx context.Context, requests
 
 	cm.logger.Debugf("Performing batch DNS pre-check for %d domains from initialization-required certificates", len(allDomains))
 
-	// Use the injected DNS resolver if available (for testing), otherwise use default
-	var setupInfo []manager.DNSSetupInfo
-	var err error
-	if cm.dnsResolver != nil {
-		// Use the injected DNS resolver for testing
-		setupInfo, err = manager.PreCheckAcmeDNSWithStoreAndResolver(cm.config, cm.accountStore, allDomains, cm.dnsResolver)
-	} else {
-		// Use the default DNS resolver
-		setupInfo, err = manager.PreCheckAcmeDNSWithStore(cm.config, cm.accountStore, allDomains)
-	}
+	// Use the injected DNS resolver if available (for testing), a nil resolver selects the default
+	setupInfo, err := manager.PreCheckAcmeDNSWithStoreContext(ctx, cm.config, cm.accountStore, allDomains, cm.dnsResolver)
 	if err != nil {
 		return fmt.Errorf("batch DNS pre-check failed: %w", err)
 	}
 
-	// If any DNS setup is needed, display all instructions and exit
+	resolver := cm.dnsResolver
+	if resolver == nil {
+		resolver = manager.NewPrecheckResolver(cm.config)
+	}
+
+	// If any DNS setup is needed, let the configured DNS providers create what they
+	// can, then display instructions for the rest and exit
+	required := setupInfo
 	if setupInfo != nil {
-		manager.DisplayDNSInstructions(setupInfo)
-		return manager.ErrDNSSetupNeeded
+		executors, err := manager.NewDNSSetupExecutors(cm.config)
+		if err != nil {
+			return fmt.Errorf("configuring DNS providers: %w", err)
+		}
+		setupInfo = manager.ApplyDNSSetup(ctx, executors, setupInfo)
+	}
+	if len(setupInfo) > 0 {
+		manager.ReportDNSSetup(cm.config, setupInfo)
+		for _, req := range cm.dnsSetupRequests(initRequests, setupInfo) {
+			cm.emitEvent(ctx, events.TypeDNSSetupNeeded, req, nil)
+		}
+
+		if cm.config.DNSWait == nil {
+			return manager.ErrDNSSetupNeeded
+		}
+		// The records are needed now, not at the end of the run
+		cm.notifyDNSSetup(ctx)
+		if err := manager.WaitForDNSSetup(ctx, cm.config.DNSWait, resolver, required); err != nil {
+			return err
+		}
+	} else if err := manager.WaitForCreatedDNSRecords(ctx, cm.config, resolver, required); err != nil {
+		return err
 	}
 
 	cm.logger.Debug("Batch DNS pre-check passed, all domains are ready")
 	return nil
 }
 
-// processRequests processes a list of certificate requests
+// processRequests processes a list of certificate requests. In auto mode every
+// certificate is attempted and failures are reported together in a
+// ProcessingError; in manual mode the first failure ends the run.
 func (cm *CertificateManager) processRequests(ctx context.Context, requests []CertRequest) error {
+	cm.lastResults = nil
+	cm.runStarted = time.Now()
+	cm.takeDNSSetup()
+	// Lookups and registrations are shared by the certificates of a run only,
+	// the next run of a daemon sees the DNS as it is then
+	cm.config.DNSCache = manager.NewDNSCache()
 	cm.logger.Debugf("Performing pre-checks for %d requested certificates...", len(requests))
 
+	// Revoked certificates are found before deciding what to do with them
+	if cm.continueOnError && cm.config.AutoDomains != nil && cm.config.AutoDomains.OCSPCheck {
+		cm.checkOCSP(ctx, requests)
+	}
+
 	// First, batch pre-check all certificates that need initialization
 	if err := cm.preCheckAllRequests(ctx, requests); err != nil {
+		cm.reportRun(nil, err)
+		cm.notifyRun(ctx, nil, err)
 		return err
 	}
 
 	// Now process each certificate normally
 	renewalThreshold := cm.config.GetRenewalThreshold()
+	workers := cm.maxParallel
+	if workers > len(requests) {
+		workers = len(requests)
+	}
+	var results []CertResult
+	if workers <= 1 {
+		for _, req := range requests {
+			if common.IsContextCanceled(ctx) {
+				break
+			}
+			result := cm.processRequest(ctx, req, renewalThreshold)
+			results = append(results, result)
+			if cm.stopsRun(result) {
+				break
+			}
+		}
+	} else {
+		results = cm.processRequestsParallel(ctx, requests, renewalThreshold, workers)
+	}
+
+	err := cm.finishRun(ctx, results)
+	cm.reportRun(results, err)
+	cm.notifyRun(ctx, results, err)
+	return err
+}
+
+// reportRun writes the run report if run_report is configured. A failure to
+// write it is only logged, it must not change the result of the run.
+func (cm *CertificateManager) reportRun(results []CertResult, runErr error) {
+	if cm.config.RunReport == "" {
+		return
+	}
+	mode := "manual"
+	if cm.continueOnError {
+		mode = "auto"
+	}
+	report := newRunReport(cm.runStarted, mode, results, runErr)
+	if err := writeRunReport(cm.config.RunReport, report); err != nil {
+		cm.logger.Warnf("Warning: writing run report %s: %v", cm.config.RunReport, err)
+		return
+	}
+	cm.logger.Debugf("Wrote run report to %s", cm.config.RunReport)
+}
+
+// checkOCSP queries the OCSP status of every stored certificate and records
+// it, so DetermineAction replaces revoked certificates. Failures are only
+// logged, an unreachable responder must not stop the renewals.
+func (cm *CertificateManager) checkOCSP(ctx context.Context, requests []CertRequest) {
 	for _, req := range requests {
-		if err := cm.processRequest(ctx, req, renewalThreshold); err != nil {
-			return fmt.Errorf("processing certificate %s: %w", req.Name, err)
+		if _, err := os.Stat(certinfo.PathsFor(cm.config.CertStoragePath, req.Name).Certificate); err != nil {
+			continue
+		}
+		result, err := manager.CheckOCSP(ctx, cm.config, req.Name)
+		if err != nil {
+			cm.logger.Warnf("OCSP check of certificate %s failed: %v", req.Name, err)
+			continue
+		}
+		if result.Status == manager.OCSPStatusRevoked {
+			cm.logger.Warnf("Certificate %s is revoked according to its OCSP responder", req.Name)
+		} else {
+			cm.logger.Debugf("OCSP status of certificate %s: %s", req.Name, result.Status)
+		}
+		if err := manager.RecordOCSP(cm.config.CertStoragePath, req.Name, result); err != nil {
+			cm.logger.Warnf("Warning: recording OCSP status of certificate %s: %v", req.Name, err)
 		}
 	}
+}
+
+// Results returns the per-certificate results of the last processing run
+func (cm *CertificateManager) Results() []CertResult {
+	return cm.lastResults
+}
 
+// stopsRun reports whether a result ends the run early. Auto mode carries on
+// with the remaining certificates, manual mode stops at the first problem.
+func (cm *CertificateManager) stopsRun(result CertResult) bool {
+	return result.Err != nil && !cm.continueOnError
+}
+
+// finishRun turns the collected results into the error of the run
+func (cm *CertificateManager) finishRun(ctx context.Context, results []CertResult) error {
+	cm.lastResults = results
+	if cm.continueOnError {
+		cm.logSummary(results)
+	}
+	if common.IsContextCanceled(ctx) {
+		return common.GetContextError(ctx, "certificate processing")
+	}
+
+	if !cm.continueOnError {
+		for _, r := range results {
+			if r.Err != nil {
+				return fmt.Errorf("processing certificate %s: %w", r.Name, r.Err)
+			}
+		}
+		return nil
+	}
+
+	procErr := &ProcessingError{Results: results}
+	if len(procErr.Failures()) > 0 {
+		return procErr
+	}
+	// Nothing failed, but some certificates still wait for their DNS records
+	for _, r := range results {
+		if r.Outcome == OutcomeDNSSetup {
+			return r.Err
+		}
+	}
 	return nil
 }
 
+// processRequestsParallel processes requests with a pool of workers and
+// returns the results in the order of the requests. In manual mode the first
+// failure stops the dispatch of further certificates; certificates already in
+// flight are allowed to finish.
+func (cm *CertificateManager) processRequestsParallel(ctx context.Context, requests []CertRequest, renewalThreshold interface{}, workers int) []CertResult {
+	cm.logger.Infof("Processing %d certificates with up to %d in parallel", len(requests), workers)
+
+	type job struct {
+		index int
+		req   CertRequest
+	}
+	jobs := make(chan job)
+	results := make([]CertResult, len(requests))
+	done := make([]bool, len(requests))
+	var (
+		wg       sync.WaitGroup
+		stopOnce sync.Once
+		stop     = make(chan struct{})
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				// Each index is written by exactly one worker, wg.Wait publishes the writes
+				results[j.index] = cm.processRequest(ctx, j.req, renewalThreshold)
+				done[j.index] = true
+				if cm.stopsRun(results[j.index]) {
+					stopOnce.Do(func() { close(stop) })
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for i, req := range requests {
+		// Check for a stop first so a failure is never followed by another dispatch
+		select {
+		case <-stop:
+			break dispatch
+		case <-ctx.Done():
+			break dispatch
+		default:
+		}
+		select {
+		case jobs <- job{index: i, req: req}:
+		case <-stop:
+			break dispatch
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Requests never dispatched have no result
+	var processed []CertResult
+	for i, r := range results {
+		if done[i] {
+			processed = append(processed, r)
+		}
+	}
+	return processed
+}
+
 // processRequest processes a single certificate request
-func (cm *CertificateManager) processRequest(ctx context.Context, req CertRequest, renewalThreshold interface{}) error {
+func (cm *CertificateManager) processRequest(ctx context.Context, req CertRequest, renewalThreshold interface{}) CertResult {
 	cm.logger.Debugf("Processing certificate: %s (%v)", req.Name, req.Domains)
+	start := time.Now()
+	oldExpiry := cm.certExpiry(req.Name)
 
 	// Determine action needed (init, renew, skip)
 	action, err := cm.determineAction(req, renewalThreshold)
+	if err == nil && action != manager.ActionSkip && cm.continueOnError {
+		err = cm.waitSplay(ctx, req.Name)
+	}
+	if err == nil {
+		cm.logger.Infof("Certificate %s requires action: %s", req.Name, action)
+
+		// Execute the action
+		switch action {
+		case "init":
+			err = cm.withCertTimeout(ctx, req.Name, func(ctx context.Context) error { return cm.initCertificate(ctx, req) })
+		case "renew":
+			err = cm.withCertTimeout(ctx, req.Name, func(ctx context.Context) error { return cm.renewCertificate(ctx, req) })
+		case "skip":
+			cm.logger.Infof("Certificate %s is up to date, skipping", req.Name)
+		default:
+			err = fmt.Errorf("unknown action: %s", action)
+		}
+	}
+
+	// In auto mode the run goes on, so the error is logged here in full
+	result := resultFor(req, action, err)
+	if err == nil {
+		if deployErr := cm.deployCertificate(ctx, req.Name); deployErr != nil {
+			result.Outcome = OutcomeDeployFailed
+			result.Err = deployErr
+		}
+	}
+	result.OldExpiry = oldExpiry
+	if result.Outcome == OutcomeIssued || result.Outcome == OutcomeRenewed {
+		result.NewExpiry = cm.certExpiry(req.Name)
+	}
+	result.Duration = time.Since(start)
+	cm.recordState(req, result)
+	if cm.continueOnError {
+		switch result.Outcome {
+		case OutcomeDeferred:
+			cm.logger.Warnf("Certificate %s is rate limited, deferring it to the next run: %v", req.Name, err)
+		case OutcomeFailed:
+			cm.logger.Errorf("Certificate %s failed: %v", req.Name, err)
+		}
+	}
+	return result
+}
+
+// withCertTimeout runs the action of one certificate limited by
+// per_cert_timeout. A timeout of the certificate fails only the certificate,
+// unlike a cancellation of the run.
+func (cm *CertificateManager) withCertTimeout(ctx context.Context, certName string, action func(context.Context) error) error {
+	timeout := cm.config.PerCertTimeout
+	if timeout <= 0 {
+		return action(ctx)
+	}
+	certCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := action(certCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(certCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("certificate %s exceeded per_cert_timeout of %s: %w", certName, timeout, err)
+	}
+	return err
+}
+
+// certExpiry returns the expiry of the stored certificate, zero if there is none
+func (cm *CertificateManager) certExpiry(certName string) time.Time {
+	info, err := certinfo.Load(certinfo.PathsFor(cm.config.CertStoragePath, certName).Certificate)
 	if err != nil {
+		return time.Time{}
+	}
+	return info.NotAfter
+}
+
+// deployCertificate copies a current certificate to its deploy targets. It
+// returns an error if any target failed, targets already holding the
+// certificate are skipped.
+func (cm *CertificateManager) deployCertificate(ctx context.Context, certName string) error {
+	results, err := manager.DeployCertificate(ctx, cm.config, certName)
+	if err != nil {
+		cm.logger.Errorf("Deploying certificate %s: %v", certName, err)
 		return err
 	}
+	var errs []error
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			cm.logger.Errorf("Certificate %s: %v", certName, r.Err)
+			errs = append(errs, r.Err)
+		case r.Deployed:
+			cm.logger.Infof("Certificate %s deployed to %s", certName, r.Target)
+		default:
+			cm.logger.Debugf("Certificate %s is already deployed to %s", certName, r.Target)
+		}
+	}
+	return errors.Join(errs...)
+}
 
-	cm.logger.Infof("Certificate %s requires action: %s", req.Name, action)
+// recordState remembers how the run of the certificate went and, once it was
+// issued or renewed, what was applied, so -diff can report configuration
+// changes not yet applied
+func (cm *CertificateManager) recordState(req CertRequest, result CertResult) {
+	var certState manager.CertState
+	if result.changed() {
+		certState.Domains = req.Domains
+		certState.KeyType = manager.EffectiveKeyType(req.KeyType)
+		certState.AcmeServer = cm.config.ForCert(req.Name).AcmeServer
+	} else if state, err := manager.LoadState(cm.config.CertStoragePath); err == nil {
+		last := state.Certificates[req.Name]
+		certState.Domains, certState.KeyType, certState.AcmeServer = last.Domains, last.KeyType, last.AcmeServer
+	}
+	certState.LastResult = result.Outcome
+	certState.LastRun = time.Now().UTC()
+	if result.Err != nil {
+		certState.LastError = result.Err.Error()
+	}
+	if err := manager.UpdateCertState(cm.config.CertStoragePath, req.Name, certState); err != nil {
+		cm.logger.Warnf("Warning: recording state of certificate %s: %v", req.Name, err)
+	}
+}
 
-	// Execute the action
-	switch action {
-	case "init":
-		return cm.initCertificate(ctx, req)
-	case "renew":
-		return cm.renewCertificate(ctx, req)
-	case "skip":
-		cm.logger.Infof("Certificate %s is up to date, skipping", req.Name)
+// splaySleep waits for the renewal splay, replaced in tests
+var splaySleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// waitSplay delays the start of an issuance or renewal until the splay of
+// the certificate has passed since the start of the run, spreading the load
+// of many hosts on the CA and acme-dns server. The offsets do not add up, a
+// certificate processed after others waits only for what is left of its own.
+func (cm *CertificateManager) waitSplay(ctx context.Context, certName string) error {
+	splay := cm.config.RenewSplayFor(certName)
+	delay := time.Until(cm.runStarted.Add(splay))
+	if splay <= 0 || delay <= 0 {
 		return nil
-	default:
-		return fmt.Errorf("unknown action: %s", action)
 	}
+	cm.logger.Infof("Delaying certificate %s by %s (renew_splay %s after the start of the run)", certName, delay.Round(time.Second), splay.Round(time.Second))
+	return splaySleep(ctx, delay)
 }
 
 // determineAction determines what action is needed for a certificate
@@ -258,47 +777,48 @@ func (cm *CertificateManager) determineAction(req CertRequest, renewalThreshold
 		return "", fmt.Errorf("invalid renewal threshold type: %T", renewalThreshold)
 	}
 
-	// Check if certificate metadata exists - this determines if it's a new cert or renewal
-	certPath := filepath.Join(cm.config.CertStoragePath, "certificates", req.Name+".crt")
-	metadataPath := filepath.Join(cm.config.CertStoragePath, "certificates", req.Name+".json")
-
-	// If metadata file doesn't exist, it's a new certificate
-	if _, err := os.Stat(metadataPath); os.IsNotExist(err) {
-		cm.logger.Debugf("Certificate metadata not found at %s - initializing new certificate", metadataPath)
-		return "init", nil
-	} else if err != nil {
-		return "", fmt.Errorf("checking certificate metadata %s: %w", metadataPath, err)
+	decision, err := manager.DetermineAction(cm.config, req.Name, req.Domains, req.KeyType, threshold)
+	if err != nil {
+		return "", err
 	}
 
-	// If certificate file doesn't exist, it's a new certificate
-	if _, err := os.Stat(certPath); os.IsNotExist(err) {
-		cm.logger.Debugf("Certificate file not found at %s - initializing new certificate", certPath)
-		return "init", nil
-	} else if err != nil {
-		return "", fmt.Errorf("checking certificate file %s: %w", certPath, err)
+	switch decision.Action {
+	case manager.ActionInit:
+		cm.logger.Debugf("%s - initializing new certificate", decision.Reason)
+		// Move leftovers of an interrupted issuance out of the way before starting over
+		cm.quarantinePartial(req.Name)
+	case manager.ActionRenew:
+		if decision.CheckErr != nil {
+			cm.logger.Warnf("Error checking certificate renewal status: %v", decision.CheckErr)
+		} else {
+			cm.logger.Infof("Certificate %s needs renewal: %s", req.Name, decision.Reason)
+		}
+	default:
+		cm.logger.Infof("Certificate %s is valid and doesn't need renewal", req.Name)
 	}
+	return decision.Action, nil
+}
 
-	// Certificate exists, check if it needs renewal
-	needsRenewal, reason, err := manager.CertificateNeedsRenewal(certPath, req.Domains, threshold)
+// quarantinePartial moves the files of an incomplete certificate into the
+// 'failed' directory and reports what was moved
+func (cm *CertificateManager) quarantinePartial(certName string) {
+	result, err := manager.QuarantinePartialArtifacts(cm.config, certName)
 	if err != nil {
-		cm.logger.Warnf("Error checking certificate renewal status: %v", err)
-		// If we can't check the certificate, assume it needs renewal
-		return "renew", nil
+		cm.logger.Errorf("Failed to quarantine incomplete files of certificate %s: %v", certName, err)
+		return
 	}
-
-	if needsRenewal {
-		cm.logger.Infof("Certificate %s needs renewal: %s", req.Name, reason)
-		return "renew", nil
+	if result == nil {
+		return
+	}
+	cm.logger.Warnf("Certificate %s was incomplete, quarantined %d file(s) to %s:", certName, len(result.Files), result.Dir)
+	for _, f := range result.Files {
+		cm.logger.Warnf("    %s", filepath.Base(f))
 	}
-
-	// Certificate exists and doesn't need renewal
-	cm.logger.Infof("Certificate %s is valid and doesn't need renewal", req.Name)
-	return "skip", nil
 }
 
 // initCertificate initializes a new certificate
 func (cm *CertificateManager) initCertificate(ctx context.Context, req CertRequest) error {
-	cm.logger.Infof("Initializing certificate %s for domains %v", req.Name, req.Domains)
+	cm.logger.Infof("Initializing certificate %s for domains %s", req.Name, manager.DisplayDomains(req.Domains))
 
 	// Check if we were asked to shutdown
 	if common.IsContextCanceled(ctx) {
@@ -306,13 +826,15 @@ func (cm *CertificateManager) initCertificate(ctx context.Context, req CertReque
 	}
 
 	// Call the manager's RunLego function to obtain the certificate
-	err := cm.legoRunner(cm.config, cm.accountStore, "init", req.Name, req.Domains, req.KeyType)
+	err := cm.legoRunner(ctx, cm.config, cm.accountStore, "init", req.Name, req.Domains, req.KeyType)
+	cm.emitResult(ctx, "init", req, err)
 	if err != nil {
 		// Check if this is just DNS setup needed (not really an error)
 		if errors.Is(err, manager.ErrDNSSetupNeeded) {
 			// DNS setup instructions were already shown, this is a normal exit
 			return err // Return the error as-is to bubble up
 		}
+		cm.quarantinePartial(req.Name)
 		return fmt.Errorf("failed to initialize certificate %s: %w", req.Name, err)
 	}
 
@@ -322,7 +844,7 @@ func (cm *CertificateManager) initCertificate(ctx context.Context, req CertReque
 
 // renewCertificate renews an existing certificate
 func (cm *CertificateManager) renewCertificate(ctx context.Context, req CertRequest) error {
-	cm.logger.Infof("Renewing certificate %s for domains %v", req.Name, req.Domains)
+	cm.logger.Infof("Renewing certificate %s for domains %s", req.Name, manager.DisplayDomains(req.Domains))
 
 	// Check if we were asked to shutdown
 	if common.IsContextCanceled(ctx) {
@@ -330,7 +852,8 @@ func (cm *CertificateManager) renewCertificate(ctx context.Context, req CertRequ
 	}
 
 	// Call the manager's RunLego function to renew the certificate
-	err := cm.legoRunner(cm.config, cm.accountStore, "renew", req.Name, req.Domains, req.KeyType)
+	err := cm.legoRunner(ctx, cm.config, cm.accountStore, "renew", req.Name, req.Domains, req.KeyType)
+	cm.emitResult(ctx, "renew", req, err)
 	if err != nil {
 		// Check if this is just DNS setup needed (can happen if new domains were added)
 		if errors.Is(err, manager.ErrDNSSetupNeeded) {
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -111,7 +112,7 @@ func TestCertificateConfigChange(t *testing.T) {
 }
 
 // mockConfigChangeLegoRunner is a mock implementation for testing config changes
-func mockConfigChangeLegoRunner(cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
+func mockConfigChangeLegoRunner(ctx context.Context, cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
 	// Mock successful renewal
 	return nil
 }
@@ -0,0 +1,270 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+// syncLogger is a goroutine-safe logger for tests that process certificates in parallel
+type syncLogger struct {
+	mu     sync.Mutex
+	logger mockLogger
+}
+
+func (l *syncLogger) Debug(msg string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logger.Debug(msg, args...)
+}
+func (l *syncLogger) Info(msg string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logger.Info(msg, args...)
+}
+func (l *syncLogger) Warn(msg string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logger.Warn(msg, args...)
+}
+func (l *syncLogger) Error(msg string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logger.Error(msg, args...)
+}
+func (l *syncLogger) Debugf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logger.Debugf(format, args...)
+}
+func (l *syncLogger) Infof(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logger.Infof(format, args...)
+}
+func (l *syncLogger) Warnf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logger.Warnf(format, args...)
+}
+func (l *syncLogger) Errorf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logger.Errorf(format, args...)
+}
+func (l *syncLogger) Importantf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logger.Importantf(format, args...)
+}
+
+func createParallelTestConfig(tmpDir string, count, maxParallel int) *manager.Config {
+	cfg := createTestConfig(tmpDir)
+	cfg.AutoDomains.MaxParallel = maxParallel
+	cfg.AutoDomains.Certs = make(map[string]manager.CertConfig)
+	for i := 0; i < count; i++ {
+		cfg.AutoDomains.Certs[fmt.Sprintf("cert-%02d", i)] = manager.CertConfig{
+			Domains: []string{fmt.Sprintf("host%d.example.com", i)},
+		}
+	}
+	return cfg
+}
+
+func TestProcessAutoMode_Parallel(t *testing.T) {
+	tmpDir := t.TempDir()
+	cm, err := NewCertificateManager(createParallelTestConfig(tmpDir, 12, 4), &syncLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+
+	var running, peak int32
+	var mu sync.Mutex
+	processed := make(map[string]bool)
+	cm.SetLegoRunner(func(ctx context.Context, cfg *manager.Config, store interface{}, action, certName string, domains []string, keyType string) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		processed[certName] = true
+		mu.Unlock()
+		return mockLegoRunner(ctx, cfg, store, action, certName, domains, keyType)
+	})
+
+	if err := cm.ProcessAutoMode(context.Background()); err != nil {
+		t.Fatalf("ProcessAutoMode failed: %v", err)
+	}
+
+	if len(processed) != 12 {
+		t.Errorf("Expected 12 certificates processed, got %d", len(processed))
+	}
+	if peak < 2 || peak > 4 {
+		t.Errorf("Expected between 2 and 4 concurrent runs, peak was %d", peak)
+	}
+}
+
+func TestProcessAutoMode_ParallelContinuesOnError(t *testing.T) {
+	tmpDir := t.TempDir()
+	cm, err := NewCertificateManager(createParallelTestConfig(tmpDir, 20, 2), &syncLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+
+	var calls int32
+	boom := errors.New("acme server unavailable")
+	cm.SetLegoRunner(func(ctx context.Context, cfg *manager.Config, store interface{}, action, certName string, domains []string, keyType string) error {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(time.Millisecond)
+		return boom
+	})
+
+	err = cm.ProcessAutoMode(context.Background())
+	if !errors.Is(err, boom) {
+		t.Fatalf("Expected wrapped runner error, got %v", err)
+	}
+	if c := atomic.LoadInt32(&calls); c != 20 {
+		t.Errorf("Expected all 20 certificates to be attempted, but runner was called %d times", c)
+	}
+	if code := ExitCode(err); code != ExitTotalFailure {
+		t.Errorf("Expected exit code %d, got %d", ExitTotalFailure, code)
+	}
+}
+
+func TestProcessAutoMode_RateLimitDeferred(t *testing.T) {
+	for _, maxParallel := range []int{1, 3} {
+		tmpDir := t.TempDir()
+		cm, err := NewCertificateManager(createParallelTestConfig(tmpDir, 6, maxParallel), &syncLogger{})
+		if err != nil {
+			t.Fatalf("Failed to create certificate manager: %v", err)
+		}
+
+		cm.SetLegoRunner(func(ctx context.Context, cfg *manager.Config, store interface{}, action, certName string, domains []string, keyType string) error {
+			if certName == "cert-01" || certName == "cert-04" {
+				return fmt.Errorf("failed to obtain certificate: %w", common.NewRateLimitError("obtain certificate", "too many certificates"))
+			}
+			return mockLegoRunner(ctx, cfg, store, action, certName, domains, keyType)
+		})
+
+		err = cm.ProcessAutoMode(context.Background())
+		var procErr *ProcessingError
+		if !errors.As(err, &procErr) || !common.IsRateLimitError(err) {
+			t.Fatalf("max_parallel %d: expected processing error with rate limits, got %v", maxParallel, err)
+		}
+		outcomes := make(map[string]string)
+		for _, r := range procErr.Results {
+			outcomes[r.Name] = r.Outcome
+		}
+		if len(outcomes) != 6 || outcomes["cert-01"] != OutcomeDeferred || outcomes["cert-04"] != OutcomeDeferred || outcomes["cert-02"] != OutcomeIssued {
+			t.Errorf("max_parallel %d: unexpected outcomes %v", maxParallel, outcomes)
+		}
+		if code := ExitCode(err); code != ExitPartialFailure {
+			t.Errorf("max_parallel %d: expected exit code %d, got %d", maxParallel, ExitPartialFailure, code)
+		}
+	}
+}
+
+func TestGetMaxParallel(t *testing.T) {
+	cfg := &manager.Config{}
+	if got := cfg.GetMaxParallel(); got != 1 {
+		t.Errorf("Expected default of 1 without auto_domains, got %d", got)
+	}
+	cfg.AutoDomains = &manager.AutoDomainsConfig{MaxParallel: 8}
+	if got := cfg.GetMaxParallel(); got != 8 {
+		t.Errorf("Expected 8, got %d", got)
+	}
+}
+
+func TestProcessAutoMode_RenewSplay(t *testing.T) {
+	var mu sync.Mutex
+	var waits []time.Duration
+	origSleep := splaySleep
+	splaySleep = func(ctx context.Context, d time.Duration) error {
+		mu.Lock()
+		defer mu.Unlock()
+		waits = append(waits, d)
+		return nil
+	}
+	defer func() { splaySleep = origSleep }()
+
+	tmpDir := t.TempDir()
+	cfg := createParallelTestConfig(tmpDir, 4, 2)
+	cfg.AutoDomains.RenewSplay = 10 * time.Minute
+	cm, err := NewCertificateManager(cfg, &syncLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	cm.SetLegoRunner(mockLegoRunner)
+
+	if err := cm.ProcessAutoMode(context.Background()); err != nil {
+		t.Fatalf("ProcessAutoMode failed: %v", err)
+	}
+	// The waits are what is left of each splay since the start of the run
+	var expected []time.Duration
+	for name := range cfg.AutoDomains.Certs {
+		expected = append(expected, cfg.RenewSplayFor(name))
+	}
+	if len(waits) != 4 {
+		t.Fatalf("Expected 4 splay waits, got %v", waits)
+	}
+	slices.Sort(expected)
+	slices.Sort(waits)
+	for i, d := range waits {
+		if d > expected[i] || d < expected[i]-time.Second {
+			t.Errorf("Unexpected splay wait %v, expected just below %v", d, expected[i])
+		}
+	}
+
+	// Manual runs are interactive and start right away
+	waits = nil
+	cm, err = NewCertificateManager(cfg, &syncLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	cm.SetLegoRunner(mockLegoRunner)
+	if err := cm.ProcessManualMode(context.Background(), []string{"manual@example.org"}); err != nil {
+		t.Fatalf("ProcessManualMode failed: %v", err)
+	}
+	if len(waits) != 0 {
+		t.Errorf("Expected no splay in manual mode, got %v", waits)
+	}
+}
+
+// TestProcessAutoMode_RenewSplayFromRunStart tests that the splays of
+// certificates processed one after another do not add up
+func TestProcessAutoMode_RenewSplayFromRunStart(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := createParallelTestConfig(tmpDir, 4, 1)
+	cfg.AutoDomains.RenewSplay = time.Second
+	var sum, longest time.Duration
+	for name := range cfg.AutoDomains.Certs {
+		splay := cfg.RenewSplayFor(name)
+		sum += splay
+		longest = max(longest, splay)
+	}
+	cm, err := NewCertificateManager(cfg, &syncLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	cm.SetLegoRunner(mockLegoRunner)
+
+	start := time.Now()
+	if err := cm.ProcessAutoMode(context.Background()); err != nil {
+		t.Fatalf("ProcessAutoMode failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= sum || elapsed > longest+400*time.Millisecond {
+		t.Errorf("Expected the run to take about the longest splay %v, not the sum %v, took %v", longest, sum, elapsed)
+	}
+}
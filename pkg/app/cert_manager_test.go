@@ -7,20 +7,24 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"math/big"
 	"net"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
 	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
 )
 
 // mockLegoRunner is a mock implementation that doesn't make real ACME calls
-func mockLegoRunner(cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
+func mockLegoRunner(ctx context.Context, cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
 	// Create certificate directories
 	certsDir := filepath.Join(cfg.CertStoragePath, "certificates")
 	if err := os.MkdirAll(certsDir, 0755); err != nil {
@@ -185,6 +189,39 @@ func TestProcessManualMode_Success(t *testing.T) {
 	}
 }
 
+// TestProcessManualMode_Canceled tests that the Lego runner gets the context
+// of the run, so canceling it aborts a pending ACME exchange
+func TestProcessManualMode_Canceled(t *testing.T) {
+	cm, err := NewCertificateManager(createTestConfig(t.TempDir()), &mockLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	started := make(chan struct{})
+	cm.SetLegoRunner(func(ctx context.Context, cfg *manager.Config, store interface{}, action, certName string, domains []string, keyType string) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	done := make(chan error, 1)
+	go func() { done <- cm.ProcessManualMode(ctx, []string{"test-cert@example.com"}) }()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "canceled") {
+			t.Errorf("Expected a cancellation error, got %v", err)
+		}
+		select {
+		case <-started:
+		default:
+			t.Error("Expected the runner to be canceled while running")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the run to end after cancellation")
+	}
+}
+
 func TestProcessManualMode_ParseError(t *testing.T) {
 	tmpDir := t.TempDir()
 	config := createTestConfig(tmpDir)
@@ -268,6 +305,43 @@ func TestProcessAutoMode_Success(t *testing.T) {
 	}
 }
 
+// TestProcessAutoMode_RecordsState tests that every processed certificate lands in the state file
+func TestProcessAutoMode_RecordsState(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := createTestConfig(tmpDir)
+
+	cm, err := NewCertificateManager(config, &mockLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	cm.SetLegoRunner(mockLegoRunner)
+
+	if err := cm.ProcessAutoMode(context.Background()); err != nil {
+		t.Fatalf("ProcessAutoMode failed: %v", err)
+	}
+
+	state, err := manager.LoadState(config.CertStoragePath)
+	if err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+	for name, certCfg := range config.AutoDomains.Certs {
+		certState, ok := state.Certificates[name]
+		if !ok {
+			t.Errorf("Expected state for %s", name)
+			continue
+		}
+		if certState.LastResult != OutcomeIssued {
+			t.Errorf("Expected result %s for %s, got %s", OutcomeIssued, name, certState.LastResult)
+		}
+		if strings.Join(certState.Domains, ",") != strings.Join(certCfg.Domains, ",") {
+			t.Errorf("Expected domains %v for %s, got %v", certCfg.Domains, name, certState.Domains)
+		}
+		if certState.AcmeServer != config.AcmeServer {
+			t.Errorf("Expected ACME server %s, got %s", config.AcmeServer, certState.AcmeServer)
+		}
+	}
+}
+
 func TestProcessAutoMode_NoCertificates(t *testing.T) {
 	tmpDir := t.TempDir()
 	config := createTestConfig(tmpDir)
@@ -330,6 +404,89 @@ func TestProcessAutoMode_EmptyCerts(t *testing.T) {
 	}
 }
 
+func TestProcessAutoMode_CertFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		only, skip []string
+		want       []string
+	}{
+		{"only", []string{"cert-01", "cert-03"}, nil, []string{"cert-01", "cert-03"}},
+		{"skip", nil, []string{"cert-00", "cert-02"}, []string{"cert-01", "cert-03"}},
+		{"only and skip", []string{"cert-01", "cert-02"}, []string{"cert-02"}, []string{"cert-01"}},
+		{"all skipped", nil, []string{"cert-00", "cert-01", "cert-02", "cert-03"}, nil},
+		{"only all skipped", []string{"cert-01"}, []string{"cert-01", "cert-02"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm, err := NewCertificateManager(createParallelTestConfig(t.TempDir(), 4, 1), &mockLogger{})
+			if err != nil {
+				t.Fatalf("Failed to create certificate manager: %v", err)
+			}
+			var processed []string
+			cm.SetLegoRunner(func(ctx context.Context, cfg *manager.Config, store interface{}, action, certName string, domains []string, keyType string) error {
+				processed = append(processed, certName)
+				return mockLegoRunner(ctx, cfg, store, action, certName, domains, keyType)
+			})
+			cm.SetCertFilter(tt.only, tt.skip)
+
+			if err := cm.ProcessAutoMode(context.Background()); err != nil {
+				t.Fatalf("ProcessAutoMode failed: %v", err)
+			}
+			sort.Strings(processed)
+			if strings.Join(processed, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Expected %v to be processed, got %v", tt.want, processed)
+			}
+		})
+	}
+}
+
+func TestProcessAutoMode_CertFilterNothingLeft(t *testing.T) {
+	tests := []struct {
+		name       string
+		only, skip []string
+		want       string
+	}{
+		{"skip", nil, []string{"cert-00", "cert-01"}, "All certificates are excluded by -skip"},
+		{"only and skip", []string{"cert-00"}, []string{"cert-00"}, "All certificates selected by -only are excluded by -skip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &mockLogger{}
+			cm, err := NewCertificateManager(createParallelTestConfig(t.TempDir(), 2, 1), logger)
+			if err != nil {
+				t.Fatalf("Failed to create certificate manager: %v", err)
+			}
+			cm.SetCertFilter(tt.only, tt.skip)
+			if err := cm.ProcessAutoMode(context.Background()); err != nil {
+				t.Fatalf("ProcessAutoMode failed: %v", err)
+			}
+			if !strings.Contains(strings.Join(logger.infoMessages, "\n"), tt.want) {
+				t.Errorf("Expected %q, got %v", tt.want, logger.infoMessages)
+			}
+		})
+	}
+}
+
+func TestProcessAutoMode_CertFilterUnknown(t *testing.T) {
+	cm, err := NewCertificateManager(createParallelTestConfig(t.TempDir(), 2, 1), &mockLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	cm.SetLegoRunner(func(ctx context.Context, cfg *manager.Config, store interface{}, action, certName string, domains []string, keyType string) error {
+		t.Errorf("Expected no certificate to be processed, got %s", certName)
+		return nil
+	})
+	cm.SetCertFilter([]string{"cert-00", "cert-1"}, []string{"wbe"})
+
+	err = cm.ProcessAutoMode(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "cert-1, wbe") {
+		t.Fatalf("Expected an error naming the unknown certificates, got %v", err)
+	}
+	if appErr := common.GetApplicationError(err); appErr == nil || appErr.Context["certificates"] != "cert-00, cert-01" {
+		t.Errorf("Expected the defined certificates in the error context, got %v", appErr)
+	}
+}
+
 func TestParseManualRequests_Success(t *testing.T) {
 	tmpDir := t.TempDir()
 	config := createTestConfig(tmpDir)
@@ -513,9 +670,12 @@ func TestProcessRequest_InitAction(t *testing.T) {
 	ctx := context.Background()
 	req := CertRequest{Name: "test-cert", Domains: []string{"example.com"}, KeyType: "rsa2048"}
 
-	err = cm.processRequest(ctx, req, config.GetRenewalThreshold())
-	if err != nil {
-		t.Fatalf("processRequest failed: %v", err)
+	result := cm.processRequest(ctx, req, config.GetRenewalThreshold())
+	if result.Err != nil {
+		t.Fatalf("processRequest failed: %v", result.Err)
+	}
+	if result.Outcome != OutcomeIssued {
+		t.Errorf("Expected outcome %s, got %s", OutcomeIssued, result.Outcome)
 	}
 
 	// Verify processing and action messages
@@ -1088,3 +1248,151 @@ func BenchmarkParseAutoRequests(b *testing.B) {
 		_ = cm.parseAutoRequests()
 	}
 }
+
+func TestDetermineAction_QuarantinesPartialArtifacts(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := createTestConfig(tmpDir)
+	logger := &mockLogger{}
+	cm := &CertificateManager{config: config, logger: logger}
+
+	// A private key without certificate is left over from an interrupted issuance
+	certDir := filepath.Join(tmpDir, "certificates")
+	if err := os.MkdirAll(certDir, 0755); err != nil {
+		t.Fatalf("Failed to create certificates directory: %v", err)
+	}
+	keyPath := filepath.Join(certDir, "test-cert.key")
+	if err := os.WriteFile(keyPath, []byte("stale key"), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	req := CertRequest{Name: "test-cert", Domains: []string{"example.com"}}
+	action, err := cm.determineAction(req, config.GetRenewalThreshold())
+	if err != nil {
+		t.Fatalf("determineAction failed: %v", err)
+	}
+	if action != "init" {
+		t.Errorf("Expected action 'init', got '%s'", action)
+	}
+
+	if _, err := os.Stat(keyPath); !os.IsNotExist(err) {
+		t.Error("Expected stale key to be quarantined")
+	}
+	matches, _ := filepath.Glob(filepath.Join(tmpDir, "failed", "test-cert-*", "test-cert.key"))
+	if len(matches) != 1 {
+		t.Errorf("Expected stale key in failed/ directory, found %v", matches)
+	}
+
+	found := false
+	for _, msg := range logger.warnMessages {
+		if strings.Contains(msg, "quarantined 1 file(s)") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected quarantine to be reported, got warnings %v", logger.warnMessages)
+	}
+}
+
+func TestProcessRequest_DeployFailed(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := createTestConfig(tmpDir)
+	certCfg := config.AutoDomains.Certs["example-cert"]
+	certCfg.Deploy = &manager.DeployConfig{SSH: []manager.SSHDeployConfig{{
+		Host:     "127.0.0.1",
+		User:     "deploy",
+		KeyFile:  filepath.Join(tmpDir, "missing-key"),
+		CertPath: "/etc/ssl/example.crt",
+		KeyPath:  "/etc/ssl/example.key",
+	}}}
+	config.AutoDomains.Certs["example-cert"] = certCfg
+	if err := createTestCertificateFiles(tmpDir, "example-cert", certCfg.Domains, 90); err != nil {
+		t.Fatalf("Failed to create test certificate: %v", err)
+	}
+
+	logger := &mockLogger{}
+	cm, err := NewCertificateManager(config, logger)
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	cm.SetLegoRunner(mockLegoRunner)
+
+	req := CertRequest{Name: "example-cert", Domains: certCfg.Domains, KeyType: certCfg.KeyType}
+	result := cm.processRequest(context.Background(), req, config.GetRenewalThreshold())
+	if result.Outcome != OutcomeDeployFailed {
+		t.Fatalf("Expected outcome %s, got %s (%v)", OutcomeDeployFailed, result.Outcome, result.Err)
+	}
+	var deployErr *manager.DeployError
+	if !errors.As(result.Err, &deployErr) || deployErr.Step != manager.DeployStepConnect {
+		t.Errorf("Expected a connect error, got %v", result.Err)
+	}
+	if !result.failed() {
+		t.Error("Expected a failed deployment to count as a failure")
+	}
+
+	state, err := manager.LoadState(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := state.Certificates["example-cert"].LastResult; got != OutcomeDeployFailed {
+		t.Errorf("Expected state %s, got %s", OutcomeDeployFailed, got)
+	}
+}
+
+// TestRecordState_KeepsAppliedOnFailure tests that a failed run records its
+// error but keeps the domains and key type of the last issuance
+func TestRecordState_KeepsAppliedOnFailure(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := createTestConfig(tmpDir)
+	cm, err := NewCertificateManager(config, &mockLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+
+	issued := CertRequest{Name: "web", Domains: []string{"example.com"}, KeyType: "ec256"}
+	cm.recordState(issued, CertResult{Name: "web", Outcome: OutcomeIssued})
+	changed := CertRequest{Name: "web", Domains: []string{"example.com", "www.example.com"}, KeyType: "rsa2048"}
+	cm.recordState(changed, CertResult{Name: "web", Outcome: OutcomeFailed, Err: errors.New("boom")})
+
+	state, err := manager.LoadState(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := state.Certificates["web"]
+	if !reflect.DeepEqual(got.Domains, issued.Domains) || got.KeyType != "ec256" {
+		t.Errorf("Expected the issued domains and key type to be kept, got %v %s", got.Domains, got.KeyType)
+	}
+	if got.LastResult != OutcomeFailed || got.LastError != "boom" {
+		t.Errorf("Expected the failure to be recorded, got %s %q", got.LastResult, got.LastError)
+	}
+
+	cm.recordState(CertRequest{Name: "new", Domains: []string{"new.example.com"}}, CertResult{Name: "new", Outcome: OutcomeDNSSetup})
+	state, err = manager.LoadState(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := state.Certificates["new"]; len(got.Domains) != 0 || got.LastResult != OutcomeDNSSetup {
+		t.Errorf("Expected no applied domains while waiting for DNS setup, got %+v", got)
+	}
+}
+
+func TestParseManualRequests_DuplicateDomains(t *testing.T) {
+	config := createTestConfig(t.TempDir())
+	config.AutoDomains.DuplicateDomains = manager.DuplicateDomainsError
+	cm, err := NewCertificateManager(config, &mockLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+
+	// The auto_domains certificate of the same name is replaced, not duplicated
+	if _, err := cm.parseManualRequests([]string{"example-cert@example.com,www.example.com"}); err != nil {
+		t.Errorf("Expected a request under the auto_domains name to pass, got %v", err)
+	}
+	_, err = cm.parseManualRequests([]string{"copy@www.example.com"})
+	if err == nil || !strings.Contains(err.Error(), "www.example.com is in certificates copy, example-cert") {
+		t.Errorf("Expected a domain of another auto_domains certificate to be refused, got %v", err)
+	}
+	_, err = cm.parseManualRequests([]string{"one@api.example.org", "two@API.example.org"})
+	if err == nil || !strings.Contains(err.Error(), "api.example.org is in certificates one, two") {
+		t.Errorf("Expected a domain requested twice to be refused, got %v", err)
+	}
+}
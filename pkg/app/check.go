@@ -0,0 +1,158 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/metrics"
+)
+
+// Nagios plugin states returned by -check as exit codes
+const (
+	CheckOK       = 0
+	CheckWarning  = 1
+	CheckCritical = 2
+	CheckUnknown  = 3
+)
+
+// Default -check thresholds in days of remaining validity
+const (
+	DefaultCheckWarningDays  = 14
+	DefaultCheckCriticalDays = 7
+)
+
+// checkStateNames are the state labels of the plugin output
+var checkStateNames = map[int]string{
+	CheckOK:       "OK",
+	CheckWarning:  "WARNING",
+	CheckCritical: "CRITICAL",
+	CheckUnknown:  "UNKNOWN",
+}
+
+// CheckError is returned by HandleCheck if the state is not OK. The plugin
+// output was already written, ExitCode maps the error to State.
+type CheckError struct {
+	State   int
+	Summary string
+}
+
+// Error implements the error interface
+func (e *CheckError) Error() string {
+	return fmt.Sprintf("certificate check %s: %s", checkStateNames[e.State], e.Summary)
+}
+
+// certCheck is the state of one certificate in the check
+type certCheck struct {
+	name    string
+	state   int
+	problem string  // Why the state is not OK
+	days    float64 // Remaining validity, only set if present
+	present bool
+}
+
+// HandleCheck evaluates the certificates configured in auto_domains like a
+// Nagios/Icinga plugin: it writes one summary line with perfdata of the days
+// remaining per certificate to w and returns a *CheckError for the WARNING,
+// CRITICAL and UNKNOWN states. It only reads the storage directory.
+func (app *Application) HandleCheck(w io.Writer) error {
+	unknown := func(summary string) error {
+		app.setResult(checkOutput{State: checkStateNames[CheckUnknown], Summary: summary, Certificates: []checkCertOutput{}})
+		_, _ = fmt.Fprintf(w, "CERTIFICATES UNKNOWN - %s\n", summary)
+		return &CheckError{State: CheckUnknown, Summary: summary}
+	}
+
+	cfg, err := app.LoadManagerConfig()
+	if err != nil {
+		return unknown(err.Error())
+	}
+	snap, err := metrics.Collect(cfg, time.Now())
+	if err != nil {
+		return unknown(fmt.Sprintf("reading %s: %v", cfg.CertStoragePath, err))
+	}
+
+	warningDays, criticalDays := app.config.CheckWarningDays, app.config.CheckCriticalDays
+	var checks []certCheck
+	for _, m := range snap.Certificates {
+		if !m.Configured {
+			continue
+		}
+		checks = append(checks, evaluateCert(m, warningDays, criticalDays))
+	}
+	if len(checks) == 0 {
+		return unknown("no certificates configured in auto_domains")
+	}
+
+	state := CheckOK
+	counts := map[int]int{}
+	var problems []string
+	for _, c := range checks {
+		counts[c.state]++
+		if c.state > state {
+			state = c.state
+		}
+	}
+	// Critical problems are listed first, they are what the on-call reads
+	sort.SliceStable(checks, func(i, j int) bool { return checks[i].state > checks[j].state })
+	for _, c := range checks {
+		if c.state != CheckOK {
+			problems = append(problems, c.name+" "+c.problem)
+		}
+	}
+
+	summary := fmt.Sprintf("%d critical, %d warning, %d ok", counts[CheckCritical], counts[CheckWarning], counts[CheckOK])
+	if len(problems) > 0 {
+		summary += ": " + strings.Join(problems, ", ")
+	}
+
+	sort.Slice(checks, func(i, j int) bool { return checks[i].name < checks[j].name })
+	result := checkOutput{State: checkStateNames[state], Summary: summary}
+	var perfdata []string
+	for _, c := range checks {
+		entry := checkCertOutput{Name: c.name, State: checkStateNames[c.state], Problem: c.problem}
+		if c.present {
+			days := c.days
+			entry.DaysRemaining = &days
+			// N: is the Nagios range for alerting below N
+			perfdata = append(perfdata, fmt.Sprintf("'%s'=%.1f;%d:;%d:;;", c.name, c.days, warningDays, criticalDays))
+		}
+		result.Certificates = append(result.Certificates, entry)
+	}
+	app.setResult(result)
+	_, _ = fmt.Fprintf(w, "CERTIFICATES %s - %s", checkStateNames[state], summary)
+	if len(perfdata) > 0 {
+		_, _ = fmt.Fprintf(w, " | %s", strings.Join(perfdata, " "))
+	}
+	_, _ = fmt.Fprintln(w)
+
+	if state != CheckOK {
+		return &CheckError{State: state, Summary: summary}
+	}
+	return nil
+}
+
+// evaluateCert maps the metrics of one certificate to its check state
+func evaluateCert(m metrics.CertificateMetrics, warningDays, criticalDays int) certCheck {
+	c := certCheck{name: m.Name, state: CheckOK}
+	if !m.Present {
+		c.state, c.problem = CheckCritical, "missing"
+		return c
+	}
+	c.present = true
+	c.days = m.ExpirySeconds / (24 * 60 * 60)
+	switch {
+	case m.Expired:
+		c.state, c.problem = CheckCritical, "expired"
+	case m.OCSPStatus == "revoked":
+		c.state, c.problem = CheckCritical, "revoked"
+	case c.days < float64(criticalDays):
+		c.state, c.problem = CheckCritical, fmt.Sprintf("expires in %.1f days", c.days)
+	case c.days < float64(warningDays):
+		c.state, c.problem = CheckWarning, fmt.Sprintf("expires in %.1f days", c.days)
+	case m.FailureStreak > 0:
+		c.state, c.problem = CheckWarning, fmt.Sprintf("%d failed renewal attempt(s)", m.FailureStreak)
+	}
+	return c
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+)
+
+// DefaultDaemonInterval is the default time between two runs in daemon mode
+const DefaultDaemonInterval = 12 * time.Hour
+
+// runTimeout limits a one-shot run and every pass of the daemon
+var runTimeout = common.DefaultRunTimeout
+
+// RunContext returns the context to pass to Run. A one-shot command ends
+// after common.DefaultRunTimeout; a daemon runs until it is stopped by a
+// signal, each of its passes gets the deadline instead.
+func (app *Application) RunContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if app.config.Daemon {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, runTimeout)
+}
+
+// RunDaemon processes the auto_domains certificates every -daemon-interval
+// until ctx is canceled, each run within runTimeout. Failing runs are logged and retried with the next
+// cycle; the storage lock is held for the lifetime of the daemon. The results
+// of every run are passed to status, which may be nil. With a standby
+// section, only the cycles holding the lease process the certificates.
+//
+// Started by systemd with Type=notify, the daemon reports readiness and the
+// outcome of every run. With WatchdogSec= it pings the watchdog, unless a run
+// takes longer than the interval, so systemd restarts a hung daemon.
+func (app *Application) RunDaemon(ctx context.Context, certManager *CertificateManager, status *StatusServer) error {
+	interval := app.config.DaemonInterval
+	if interval <= 0 {
+		interval = DefaultDaemonInterval
+	}
+	app.logger.Infof("Running as daemon, checking certificates every %s", interval)
+
+	if notified, err := sdNotify(sdReady + "\n" + sdStatusFmt + "Starting the first certificate run"); err != nil {
+		app.logger.Warnf("Warning: cannot notify systemd: %v", err)
+	} else if notified {
+		app.logger.Debug("Notified systemd that the daemon is ready")
+	}
+	defer func() { _, _ = sdNotify(sdStopping) }()
+
+	watchdog := &daemonWatchdog{maxRun: interval, now: time.Now}
+	if period := sdWatchdogInterval(); period > 0 {
+		app.logger.Infof("Pinging the systemd watchdog every %s, a certificate run hanging for more than %s stops the pings", period/2, interval)
+		done := make(chan struct{})
+		defer close(done)
+		go watchdog.run(period/2, done, func() {
+			app.logger.Errorf("Certificate run has been going on for more than %s, stopping the watchdog pings so systemd restarts the daemon", interval)
+		})
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			app.logger.Info("Daemon stopped")
+			app.Shutdown()
+			return nil
+		case <-timer.C:
+		}
+
+		// A standby checks the lease every cycle, ready to take over
+		if held, err := app.holdsLease(certManager.config, status); !held {
+			state := "Standing by"
+			if err != nil {
+				app.logger.Errorf("Skipping this run, retrying in %s: %v", interval, err)
+				state = "Lease check failed"
+			}
+			_, _ = sdNotify(fmt.Sprintf("%s%s at %s, next check in %s", sdStatusFmt, state, time.Now().Format(time.RFC3339), interval))
+			timer.Reset(interval)
+			continue
+		}
+
+		watchdog.startRun()
+		runCtx, cancelRun := context.WithTimeout(ctx, runTimeout)
+		err := certManager.ProcessAutoMode(runCtx)
+		cancelRun()
+		watchdog.endRun()
+		status.RecordRun(certManager.Results(), err)
+		var state string
+		switch {
+		case ctx.Err() != nil:
+			continue
+		case dnsSetupOnly(err):
+			app.logger.Warnf("Please configure the DNS records as shown above, they are checked again in %s.", interval)
+			state = "DNS setup needed"
+		case err != nil:
+			app.logger.Errorf("Certificate run failed, retrying in %s: %v", interval, err)
+			state = "Last run failed"
+		default:
+			app.logger.Infof("Certificate run completed, next run in %s", interval)
+			state = "Last run completed"
+		}
+		_, _ = sdNotify(fmt.Sprintf("%s%s at %s, next run in %s", sdStatusFmt, state, time.Now().Format(time.RFC3339), interval))
+		timer.Reset(interval)
+	}
+}
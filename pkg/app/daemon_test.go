@@ -0,0 +1,151 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+func TestRunDaemon_RepeatsUntilCanceled(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := createTestConfig(tmpDir)
+	cm, err := NewCertificateManager(cfg, &syncLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls int32
+	cm.SetLegoRunner(func(ctx context.Context, cfg *manager.Config, store interface{}, action, certName string, domains []string, keyType string) error {
+		// Two certificates per run, stop during the third run
+		if atomic.AddInt32(&calls, 1) >= 5 {
+			cancel()
+		}
+		return mockLegoRunner(ctx, cfg, store, action, certName, domains, keyType)
+	})
+
+	app := NewApplication("test")
+	app.logger = &syncLogger{}
+	app.config.DaemonInterval = 10 * time.Millisecond
+	status := NewStatusServer(cfg, app.logger)
+
+	done := make(chan error, 1)
+	go func() { done <- app.RunDaemon(ctx, cm, status) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected clean daemon shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Daemon did not stop after cancellation")
+	}
+
+	if c := atomic.LoadInt32(&calls); c < 5 {
+		t.Errorf("Expected at least three runs, runner was called %d times", c)
+	}
+	if !status.ready {
+		t.Error("Expected the daemon to record its runs in the status server")
+	}
+}
+
+// TestRunDaemon_OutlivesRunTimeout tests that only one-shot runs and the
+// single daemon passes end after runTimeout, not the daemon itself
+func TestRunDaemon_OutlivesRunTimeout(t *testing.T) {
+	defer func(d time.Duration) { runTimeout = d }(runTimeout)
+	runTimeout = 50 * time.Millisecond
+
+	oneShotCtx, oneShotCancel := NewApplication("test").RunContext(context.Background())
+	defer oneShotCancel()
+	if _, ok := oneShotCtx.Deadline(); !ok {
+		t.Error("Expected a deadline for one-shot runs")
+	}
+
+	tmpDir := t.TempDir()
+	cfg := createTestConfig(tmpDir)
+	cm, err := NewCertificateManager(cfg, &syncLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	app := NewApplication("test")
+	app.logger = &syncLogger{}
+	app.config.Daemon = true
+	app.config.DaemonInterval = 10 * time.Millisecond
+	ctx, cancel := app.RunContext(context.Background())
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Fatal("Expected no deadline for the daemon")
+	}
+
+	start := time.Now()
+	var late, withDeadline int32
+	cm.SetLegoRunner(func(runCtx context.Context, cfg *manager.Config, store interface{}, action, certName string, domains []string, keyType string) error {
+		if deadline, ok := runCtx.Deadline(); ok && time.Until(deadline) <= runTimeout {
+			atomic.AddInt32(&withDeadline, 1)
+		}
+		// Runs well past the deadline of a one-shot run
+		if time.Since(start) > 4*runTimeout && atomic.AddInt32(&late, 1) >= 2 {
+			cancel()
+		}
+		return mockLegoRunner(runCtx, cfg, store, action, certName, domains, keyType)
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- app.RunDaemon(ctx, cm, NewStatusServer(cfg, app.logger)) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected clean daemon shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Daemon did not stop after cancellation")
+	}
+	if atomic.LoadInt32(&late) < 2 {
+		t.Error("Expected the daemon to keep running past the run timeout")
+	}
+	if atomic.LoadInt32(&withDeadline) == 0 {
+		t.Error("Expected every daemon pass to have its own deadline")
+	}
+}
+
+func TestRunDaemon_StandsByWhileLeaseIsHeld(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := createTestConfig(tmpDir)
+	cfg.Standby = &manager.StandbyConfig{LeaseFile: filepath.Join(tmpDir, "manager.lease"), Node: "acme-2"}
+	lease, _ := json.Marshal(manager.Lease{Holder: "acme-1", Renewed: time.Now(), Expires: time.Now().Add(time.Hour)})
+	if err := os.WriteFile(cfg.Standby.LeaseFile, lease, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cm, err := NewCertificateManager(cfg, &syncLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	var calls int32
+	cm.SetLegoRunner(func(ctx context.Context, cfg *manager.Config, store interface{}, action, certName string, domains []string, keyType string) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+
+	app := NewApplication("test")
+	app.logger = &syncLogger{}
+	app.config.DaemonInterval = 10 * time.Millisecond
+	status := NewStatusServer(cfg, app.logger)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := app.RunDaemon(ctx, cm, status); err != nil {
+		t.Fatalf("Expected clean daemon shutdown, got %v", err)
+	}
+	if c := atomic.LoadInt32(&calls); c != 0 {
+		t.Errorf("Expected the standby to leave the certificates alone, runner was called %d times", c)
+	}
+	if !status.ready || status.leaseHolder != "acme-1" {
+		t.Errorf("Expected the status server to report standing by for acme-1, got ready=%v holder=%q", status.ready, status.leaseHolder)
+	}
+}
@@ -0,0 +1,217 @@
+package app
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+// bundleEntry is one file of the debug bundle
+type bundleEntry struct {
+	name string
+	data []byte
+}
+
+// bundleCert describes one configured or stored certificate in the debug
+// bundle. The private key is only checked, never included.
+type bundleCert struct {
+	Name              string     `json:"name"`
+	Configured        bool       `json:"configured"`
+	ConfiguredDomains []string   `json:"configured_domains,omitempty"`
+	KeyType           string     `json:"key_type,omitempty"`
+	AcmeServer        string     `json:"acme_server,omitempty"`
+	Present           bool       `json:"present"`
+	CommonName        string     `json:"common_name,omitempty"`
+	DNSNames          []string   `json:"dns_names,omitempty"`
+	Issuer            string     `json:"issuer,omitempty"`
+	SerialNumber      string     `json:"serial_number,omitempty"`
+	KeyAlgorithm      string     `json:"key_algorithm,omitempty"`
+	NotBefore         *time.Time `json:"not_before,omitempty"`
+	NotAfter          *time.Time `json:"not_after,omitempty"`
+	HasKey            bool       `json:"has_key"`
+	KeyMatches        bool       `json:"key_matches"`
+	HasIssuer         bool       `json:"has_issuer"`
+	HasMetadata       bool       `json:"has_metadata"`
+	Error             string     `json:"error,omitempty"`
+}
+
+// HandleDebugBundle writes a tar.gz for attaching to bug reports to the
+// -debug-bundle path: version information, the sanitized configuration,
+// the metadata of every certificate, the _acme-challenge DNS lookups, the
+// state file, the last run report and the debug log of collecting all that.
+// Keys and acme-dns passwords are never included, a broken configuration is
+// reported in the bundle instead of failing it.
+func (app *Application) HandleDebugBundle(ctx context.Context) error {
+	var entries []bundleEntry
+	var problems []string
+	add := func(name string, data []byte) {
+		entries = append(entries, bundleEntry{name: name, data: data})
+	}
+	addJSON := func(name string, v any) {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+			return
+		}
+		add(name, append(data, '\n'))
+	}
+	addFile := func(name, path string) {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			return
+		} else if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+			return
+		}
+		add(name, data)
+	}
+
+	now := time.Now()
+	add("version.txt", []byte(fmt.Sprintf("go-acme-dns-manager %s\nGo version: %s\nOS/Arch: %s/%s\nCreated: %s\n",
+		app.config.Version, runtime.Version(), runtime.GOOS, runtime.GOARCH, now.UTC().Format(time.RFC3339))))
+
+	if absPath, err := filepath.Abs(app.config.ConfigPath); err == nil {
+		app.config.ConfigPath = absPath
+	}
+	overrides, err := app.configOverrides()
+	if err == nil {
+		var sanitized []byte
+		if sanitized, err = manager.SanitizedConfig(app.config.ConfigPath, overrides); err == nil {
+			add("config.yaml", sanitized)
+		}
+	}
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("config.yaml: %v", err))
+	}
+
+	app.logger.Infof("Collecting debug information for %s...", app.config.ConfigPath)
+	// The DNS lookups run as the same user as a run
+	cfg, err := app.loadConfig()
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("loading config: %v", err))
+	} else if _, err := app.dropPrivileges(cfg); err != nil {
+		problems = append(problems, fmt.Sprintf("run_as_user: %v", err))
+	} else {
+		app.applyMockOverrides(cfg)
+		certs, err := debugBundleCerts(cfg)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("certificates.json: %v", err))
+		}
+		addJSON("certificates.json", certs)
+		addFile("state.json", filepath.Join(cfg.CertStoragePath, manager.StateFile))
+		if cfg.RunReport != "" {
+			addFile("run-report.json", cfg.RunReport)
+		}
+
+		store, err := manager.NewConfigAccountStore(cfg)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("loading acme-dns accounts: %v", err))
+			store = nil
+		}
+		addJSON("dns.json", manager.LookupChallenges(ctx, cfg, store))
+	}
+
+	if len(problems) > 0 {
+		add("errors.txt", []byte(strings.Join(problems, "\n")+"\n"))
+	}
+	for _, problem := range problems {
+		app.logger.Warnf("Debug bundle: %s", problem)
+	}
+	if app.debugLog != nil {
+		add("debug.log", app.debugLog.Bytes())
+	}
+
+	if err := writeDebugBundle(app.config.DebugBundle, "go-acme-dns-manager-debug-"+now.UTC().Format("20060102T150405Z"), entries); err != nil {
+		return fmt.Errorf("writing debug bundle %s: %w", app.config.DebugBundle, err)
+	}
+	app.logger.Importantf("Wrote debug bundle %s, please check its content before attaching it to a bug report", app.config.DebugBundle)
+	return nil
+}
+
+// debugBundleCerts describes every configured or stored certificate
+func debugBundleCerts(cfg *manager.Config) ([]bundleCert, error) {
+	certs := make(map[string]*bundleCert)
+	get := func(name string) *bundleCert {
+		if c, ok := certs[name]; ok {
+			return c
+		}
+		c := &bundleCert{Name: name}
+		certs[name] = c
+		return c
+	}
+	if cfg.AutoDomains != nil {
+		for name, certCfg := range cfg.AutoDomains.Certs {
+			c := get(name)
+			c.Configured = true
+			c.ConfiguredDomains = certCfg.Domains
+			c.KeyType = certCfg.KeyType
+			c.AcmeServer = certCfg.AcmeServer
+		}
+	}
+
+	stored, err := certinfo.List(cfg.CertStoragePath)
+	for _, name := range stored {
+		c := get(name)
+		artifact, err := manager.LoadArtifact(cfg.CertStoragePath, name)
+		if err != nil {
+			c.Error = err.Error()
+		}
+		c.HasKey, c.KeyMatches = artifact.HasKey, artifact.KeyMatches
+		c.HasIssuer, c.HasMetadata = artifact.HasIssuer, artifact.HasMetadata
+		if info := artifact.Info; info != nil {
+			c.Present = true
+			c.CommonName, c.DNSNames, c.Issuer = info.CommonName, info.DNSNames, info.Issuer
+			c.SerialNumber, c.KeyAlgorithm = info.SerialNumber, info.KeyAlgorithm
+			c.NotBefore, c.NotAfter = &info.NotBefore, &info.NotAfter
+		}
+	}
+
+	result := make([]bundleCert, 0, len(certs))
+	for _, c := range certs {
+		result = append(result, *c)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, err
+}
+
+// writeDebugBundle writes the entries below dir into a tar.gz at path. The
+// file is only readable by the owner, it names all managed domains.
+func writeDebugBundle(path, dir string, entries []bundleEntry) error {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	modTime := time.Now()
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:    dir + "/" + entry.name,
+			Mode:    0600,
+			Size:    int64(len(entry.data)),
+			ModTime: modTime,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(entry.data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0600)
+}
@@ -103,6 +103,21 @@ func TestValidateModeError_StructuredErrors(t *testing.T) {
 	}
 }
 
+func TestValidateMode_CertFilterNeedsAuto(t *testing.T) {
+	app := NewApplication("test")
+	app.config.Only = []string{"web"}
+	if err := app.ValidateModeWithArgs([]string{"web@example.com"}); err == nil || !strings.Contains(err.Error(), "-only and -skip") {
+		t.Errorf("Expected -only to be rejected in manual mode, got %v", err)
+	}
+	app.config.AutoMode = true
+	if err := app.ValidateModeWithArgs(nil); err != nil {
+		t.Errorf("Expected -only to be accepted in automatic mode, got %v", err)
+	}
+	if names := splitNames(" web, ,mail "); strings.Join(names, "|") != "web|mail" {
+		t.Errorf("Expected the names web and mail, got %q", names)
+	}
+}
+
 // TestErrorMessage_Formatting tests that error messages are well-formatted
 func TestErrorMessage_Formatting(t *testing.T) {
 	app := NewApplication("test")
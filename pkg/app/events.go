@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+	"github.com/oetiker/go-acme-dns-manager/pkg/events"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+// newEventPublisher builds the event publishers configured in the 'events' section.
+// It returns nil if no publisher is configured.
+func newEventPublisher(cfg *manager.Config) (events.Publisher, error) {
+	if cfg.Events == nil {
+		return nil, nil
+	}
+
+	multi := events.NewMultiPublisher()
+
+	if nc := cfg.Events.NATS; nc != nil {
+		p, err := events.NewNATSPublisher(events.NATSOptions{
+			URL:      nc.URL,
+			Subject:  nc.Subject,
+			Username: nc.Username,
+			Password: nc.Password,
+			Token:    nc.Token,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("configuring NATS event publisher: %w", err)
+		}
+		multi.Add(p)
+	}
+
+	if kc := cfg.Events.Kafka; kc != nil {
+		p, err := events.NewKafkaPublisher(events.KafkaOptions{
+			Brokers:  kc.Brokers,
+			Topic:    kc.Topic,
+			Username: kc.Username,
+			Password: kc.Password,
+			TLS:      kc.TLS,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("configuring Kafka event publisher: %w", err)
+		}
+		multi.Add(p)
+	}
+
+	if multi.Len() == 0 {
+		return nil, nil
+	}
+	return multi, nil
+}
+
+// SetEventPublisher sets the publisher that receives certificate lifecycle events
+func (cm *CertificateManager) SetEventPublisher(publisher events.Publisher) {
+	cm.publisher = publisher
+}
+
+// emitEvent publishes a lifecycle event. Delivery failures are logged but never
+// fail certificate processing, the certificates on disk are what matters.
+func (cm *CertificateManager) emitEvent(ctx context.Context, eventType events.Type, req CertRequest, cause error) {
+	if cm.publisher == nil {
+		return
+	}
+
+	event := events.NewEvent(eventType, req.Name, req.Domains)
+	event.RequestID = common.GetRequestID(ctx)
+	if cause != nil {
+		event.Error = cause.Error()
+	}
+
+	// Use a context detached from cancellation so a failure event still gets out during shutdown
+	pubCtx, cancel := common.WithNetworkTimeout(context.WithoutCancel(ctx))
+	defer cancel()
+
+	if err := cm.publisher.Publish(pubCtx, event); err != nil {
+		cm.logger.Warnf("Failed to publish %s event for %s: %v", eventType, req.Name, err)
+	}
+}
+
+// emitResult publishes the event matching the outcome of an init or renew action
+func (cm *CertificateManager) emitResult(ctx context.Context, action string, req CertRequest, err error) {
+	switch {
+	case errors.Is(err, manager.ErrDNSSetupNeeded):
+		cm.emitEvent(ctx, events.TypeDNSSetupNeeded, req, nil)
+	case err != nil:
+		cm.emitEvent(ctx, events.TypeCertificateFailed, req, err)
+	case action == "init":
+		cm.emitEvent(ctx, events.TypeCertificateIssued, req, nil)
+	default:
+		cm.emitEvent(ctx, events.TypeCertificateRenewed, req, nil)
+	}
+}
+
+// Close releases resources held by the certificate manager
+func (cm *CertificateManager) Close() error {
+	if cm.statsd != nil {
+		_ = cm.statsd.Close()
+	}
+	if cm.publisher == nil {
+		return nil
+	}
+	return cm.publisher.Close()
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/events"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+// recordingPublisher collects events published by the certificate manager
+type recordingPublisher struct {
+	events []events.Event
+}
+
+func (r *recordingPublisher) Publish(ctx context.Context, event events.Event) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *recordingPublisher) Close() error { return nil }
+
+func TestCertificateManager_EmitsLifecycleEvents(t *testing.T) {
+	tmpDir := t.TempDir()
+	cm, err := NewCertificateManager(createTestConfig(tmpDir), &mockLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	cm.SetLegoRunner(mockLegoRunner)
+	publisher := &recordingPublisher{}
+	cm.SetEventPublisher(publisher)
+
+	if err := cm.ProcessManualMode(context.Background(), []string{"web@example.com"}); err != nil {
+		t.Fatalf("ProcessManualMode failed: %v", err)
+	}
+
+	if len(publisher.events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(publisher.events))
+	}
+	if publisher.events[0].Type != events.TypeCertificateIssued || publisher.events[0].CertName != "web" {
+		t.Errorf("Unexpected event: %+v", publisher.events[0])
+	}
+}
+
+func TestCertificateManager_EmitsFailureEvent(t *testing.T) {
+	tmpDir := t.TempDir()
+	cm, err := NewCertificateManager(createTestConfig(tmpDir), &mockLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	cm.SetLegoRunner(func(ctx context.Context, cfg *manager.Config, store interface{}, action, certName string, domains []string, keyType string) error {
+		return errors.New("rate limited")
+	})
+	publisher := &recordingPublisher{}
+	cm.SetEventPublisher(publisher)
+
+	if err := cm.ProcessManualMode(context.Background(), []string{"web@example.com"}); err == nil {
+		t.Fatal("Expected processing error")
+	}
+
+	if len(publisher.events) != 1 || publisher.events[0].Type != events.TypeCertificateFailed {
+		t.Fatalf("Expected a single failure event, got %+v", publisher.events)
+	}
+	if publisher.events[0].Error != "rate limited" {
+		t.Errorf("Expected error message in event, got %q", publisher.events[0].Error)
+	}
+}
+
+func TestDNSSetupRequests(t *testing.T) {
+	cm, err := NewCertificateManager(createTestConfig(t.TempDir()), &mockLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	requests := []CertRequest{
+		{Name: "example-cert", Domains: []string{"example.com", "www.example.com"}},
+		{Name: "wildcard-cert", Domains: []string{"*.test.com", "test.com"}},
+	}
+	setupInfo := []manager.DNSSetupInfo{{ChallengeDomain: "_acme-challenge.test.com", TargetDomain: "abc.auth.example.org"}}
+
+	waiting := cm.dnsSetupRequests(requests, setupInfo)
+	if len(waiting) != 1 || waiting[0].Name != "wildcard-cert" {
+		t.Fatalf("Expected only wildcard-cert to wait for DNS, got %+v", waiting)
+	}
+	if waiting[0].Domains[0] != "*.test.com" {
+		t.Errorf("Expected the certificate's domains in the event, got %v", waiting[0].Domains)
+	}
+}
+
+func TestNewEventPublisher(t *testing.T) {
+	cfg := createTestConfig(t.TempDir())
+	if p, err := newEventPublisher(cfg); err != nil || p != nil {
+		t.Fatalf("Expected no publisher without events config, got %v, %v", p, err)
+	}
+
+	cfg.Events = &manager.EventsConfig{
+		NATS:  &manager.NATSConfig{URL: "nats://localhost:4222", Subject: "certs"},
+		Kafka: &manager.KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "certs"},
+	}
+	p, err := newEventPublisher(cfg)
+	if err != nil {
+		t.Fatalf("newEventPublisher failed: %v", err)
+	}
+	multi, ok := p.(*events.MultiPublisher)
+	if !ok || multi.Len() != 2 {
+		t.Fatalf("Expected multi publisher with 2 entries, got %T", p)
+	}
+	_ = p.Close()
+}
@@ -0,0 +1,237 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+// ACME directories offered by the setup wizard
+const (
+	letsEncryptProduction = "https://acme-v02.api.letsencrypt.org/directory"
+	letsEncryptStaging    = "https://acme-staging-v02.api.letsencrypt.org/directory"
+)
+
+// initAnswers holds the answers given to the setup wizard
+type initAnswers struct {
+	Email         string
+	AcmeServer    string
+	AcmeDnsServer string
+	StoragePath   string
+	Domains       []string // First certificate, empty if skipped
+}
+
+// CertName returns the name of the first certificate, its first domain
+// without wildcard
+func (a *initAnswers) CertName() string {
+	if len(a.Domains) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(a.Domains[0], "*.")
+}
+
+// HandleInit asks for the basic settings on in, writes a validated config
+// file to the -config path, registers the ACME account and, if a first
+// domain was given, its acme-dns account, printing the CNAME record to
+// create. An existing config file is never overwritten.
+func (app *Application) HandleInit(ctx context.Context, in io.Reader, out io.Writer) error {
+	configPath, err := filepath.Abs(app.config.ConfigPath)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeConfig, "init",
+			"Failed to resolve absolute path for configuration file").
+			AddContext("config_path", app.config.ConfigPath)
+	}
+	if ext := strings.ToLower(filepath.Ext(configPath)); ext == ".json" || ext == ".toml" {
+		return common.NewConfigError("init", "The setup wizard writes YAML configuration files").
+			AddContext("config_path", configPath).
+			AddSuggestion("Use a -config path ending in .yaml")
+	}
+	if _, err := os.Stat(configPath); err == nil {
+		return common.NewConfigError("init", "Configuration file already exists").
+			AddContext("config_path", configPath).
+			AddSuggestion("Use -config to choose a new file, or edit the existing one")
+	}
+
+	_, _ = fmt.Fprintf(out, "Creating %s, press Enter to accept the [default].\n\n", configPath)
+	answers, err := askInitQuestions(bufio.NewScanner(in), out)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeValidation, "init", "Setup aborted").
+			AddContext("config_path", configPath)
+	}
+
+	cfg, err := writeInitConfig(configPath, answers)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeConfig, "init",
+			"Failed to write the configuration file").
+			AddContext("config_path", configPath)
+	}
+	_, _ = fmt.Fprintf(out, "\nWrote %s\n", configPath)
+
+	accountURL, _, err := manager.RegisterACMEAccount(ctx, cfg)
+	if err != nil {
+		return common.WrapError(err, common.ErrorTypeACME, "init",
+			"Failed to register the ACME account").
+			AddContext("acme_server", cfg.AcmeServer).
+			AddSuggestion("The configuration was written, the account is registered on the first -auto run")
+	}
+	_, _ = fmt.Fprintf(out, "Registered ACME account %s\n", accountURL)
+
+	if len(answers.Domains) > 0 {
+		store, err := manager.NewConfigAccountStore(cfg)
+		if err != nil {
+			return common.WrapError(err, common.ErrorTypeStorage, "init",
+				"Failed to load the acme-dns accounts").
+				AddContext("cert_storage_path", cfg.CertStoragePath)
+		}
+		setupInfo, err := manager.PreCheckAcmeDNSWithStoreContext(ctx, cfg, store, answers.Domains, nil)
+		if err != nil {
+			return common.WrapError(err, common.ErrorTypeNetwork, "init",
+				"Failed to register the acme-dns account").
+				AddContext("acme_dns_server", cfg.AcmeDnsServer).
+				AddSuggestion("The configuration was written, the account is registered on the first -auto run")
+		}
+		if len(setupInfo) > 0 {
+			manager.ReportDNSSetup(cfg, setupInfo)
+		}
+	}
+
+	_, _ = fmt.Fprintf(out, "\nNext: create the CNAME record(s) shown above, if any, then run\n")
+	_, _ = fmt.Fprintf(out, "    %s -config %s -auto\n", os.Args[0], configPath)
+	return nil
+}
+
+// askInitQuestions reads the wizard answers line by line, asking again
+// after an invalid answer
+func askInitQuestions(scanner *bufio.Scanner, out io.Writer) (*initAnswers, error) {
+	ask := func(question, defaultValue string, check func(string) (string, error)) (string, error) {
+		for {
+			if defaultValue != "" {
+				_, _ = fmt.Fprintf(out, "%s [%s]: ", question, defaultValue)
+			} else {
+				_, _ = fmt.Fprintf(out, "%s: ", question)
+			}
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return "", err
+				}
+				return "", io.ErrUnexpectedEOF
+			}
+			answer := strings.TrimSpace(scanner.Text())
+			if answer == "" {
+				answer = defaultValue
+			}
+			value, err := check(answer)
+			if err == nil {
+				return value, nil
+			}
+			_, _ = fmt.Fprintf(out, "  %v\n", err)
+		}
+	}
+
+	answers := &initAnswers{}
+	var err error
+	answers.Email, err = ask("Email address for the ACME account", "", func(s string) (string, error) {
+		address, err := mail.ParseAddress(s)
+		if err != nil || address.Address != s {
+			return "", fmt.Errorf("please enter a plain email address like admin@example.com")
+		}
+		return s, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	answers.AcmeServer, err = ask("Certificate authority: production, staging or an ACME directory URL", "staging", func(s string) (string, error) {
+		switch strings.ToLower(s) {
+		case "production", "prod", "p":
+			return letsEncryptProduction, nil
+		case "staging", "s":
+			return letsEncryptStaging, nil
+		}
+		return checkServerURL(s)
+	})
+	if err != nil {
+		return nil, err
+	}
+	answers.AcmeDnsServer, err = ask("acme-dns server URL", "https://acme-dns.oetiker.ch", checkServerURL)
+	if err != nil {
+		return nil, err
+	}
+	answers.StoragePath, err = ask("Storage directory (relative to the config file)", ".lego", func(s string) (string, error) {
+		return s, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	domains, err := ask("Domains of a first certificate, comma separated (empty to skip)", "", func(s string) (string, error) {
+		if s == "" {
+			return s, nil
+		}
+		for _, domain := range strings.Split(s, ",") {
+			if domain = strings.TrimSpace(domain); domain == "" || strings.ContainsAny(domain, " /@") {
+				return "", fmt.Errorf("%q is not a domain name", domain)
+			}
+		}
+		return s, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, domain := range strings.Split(domains, ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			answers.Domains = append(answers.Domains, domain)
+		}
+	}
+	return answers, nil
+}
+
+// checkServerURL accepts absolute http and https URLs
+func checkServerURL(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", fmt.Errorf("please enter a URL like https://acme-dns.example.com")
+	}
+	return s, nil
+}
+
+// writeInitConfig writes the configuration for the answers and loads it
+// again, so that only a valid file is left behind
+func writeInitConfig(path string, answers *initAnswers) (*manager.Config, error) {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "# Configuration for go-acme-dns-manager, created by -init\n")
+	_, _ = fmt.Fprintf(&b, "# See -print-config-template for all options\n\n")
+	_, _ = fmt.Fprintf(&b, "email: %s\n", strconv.Quote(answers.Email))
+	_, _ = fmt.Fprintf(&b, "acme_server: %s\n", strconv.Quote(answers.AcmeServer))
+	_, _ = fmt.Fprintf(&b, "acme_dns_server: %s\n", strconv.Quote(answers.AcmeDnsServer))
+	_, _ = fmt.Fprintf(&b, "cert_storage_path: %s\n", strconv.Quote(answers.StoragePath))
+	if len(answers.Domains) > 0 {
+		_, _ = fmt.Fprintf(&b, "\nauto_domains:\n  grace_days: %d\n  certs:\n", manager.DefaultGraceDays)
+		_, _ = fmt.Fprintf(&b, "    %s:\n      domains:\n", strconv.Quote(answers.CertName()))
+		for _, domain := range answers.Domains {
+			_, _ = fmt.Fprintf(&b, "        - %s\n", strconv.Quote(domain))
+		}
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(b.String()), 0600); err != nil {
+		return nil, err
+	}
+	if _, err := manager.LoadConfig(tmpPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return nil, err
+	}
+	return manager.LoadConfig(path)
+}
@@ -0,0 +1,94 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+func TestAskInitQuestions(t *testing.T) {
+	input := strings.Join([]string{
+		"not-an-email",
+		"admin@example.com",
+		"", // staging
+		"acme-dns.example.com",
+		"https://acme-dns.example.com",
+		"", // .lego
+		"example.com, *.example.com",
+	}, "\n") + "\n"
+	var out bytes.Buffer
+	answers, err := askInitQuestions(bufio.NewScanner(strings.NewReader(input)), &out)
+	if err != nil {
+		t.Fatalf("askInitQuestions failed: %v", err)
+	}
+	want := &initAnswers{
+		Email:         "admin@example.com",
+		AcmeServer:    letsEncryptStaging,
+		AcmeDnsServer: "https://acme-dns.example.com",
+		StoragePath:   ".lego",
+		Domains:       []string{"example.com", "*.example.com"},
+	}
+	if !reflect.DeepEqual(answers, want) {
+		t.Errorf("Expected %+v, got %+v", want, answers)
+	}
+	if strings.Count(out.String(), "Email address") != 2 || strings.Count(out.String(), "acme-dns server URL") != 2 {
+		t.Errorf("Expected invalid answers to be asked again:\n%s", out.String())
+	}
+
+	// Input ending early aborts the wizard
+	if _, err := askInitQuestions(bufio.NewScanner(strings.NewReader("admin@example.com\n")), &out); err == nil {
+		t.Error("Expected an error for missing answers")
+	}
+}
+
+func TestApplication_HandleInit(t *testing.T) {
+	acme := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer acme.Close()
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.ConfigPath = configPath
+
+	input := "admin@example.com\n" + acme.URL + "/directory\nhttps://acme-dns.example.com\nstorage\nwww.example.com\n"
+	var out bytes.Buffer
+	err := app.HandleInit(context.Background(), strings.NewReader(input), &out)
+	var appErr *common.ApplicationError
+	if !errors.As(err, &appErr) || appErr.Type != common.ErrorTypeACME {
+		t.Fatalf("Expected an ACME error from the unavailable server, got %v", err)
+	}
+
+	// The configuration is written and valid even if the registration fails
+	cfg, err := manager.LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("Written config does not load: %v", err)
+	}
+	if cfg.Email != "admin@example.com" || cfg.CertStoragePath != filepath.Join(filepath.Dir(configPath), "storage") {
+		t.Errorf("Unexpected email %q or storage path %q", cfg.Email, cfg.CertStoragePath)
+	}
+	if domains := cfg.AutoDomains.Certs["www.example.com"].Domains; !reflect.DeepEqual(domains, []string{"www.example.com"}) {
+		t.Errorf("Expected the first certificate, got %+v", cfg.AutoDomains.Certs)
+	}
+	if _, err := os.Stat(configPath + ".tmp"); !os.IsNotExist(err) {
+		t.Error("Expected no temporary file to be left behind")
+	}
+
+	// An existing configuration is never overwritten
+	err = app.HandleInit(context.Background(), strings.NewReader(input), &out)
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected an error for the existing config, got %v", err)
+	}
+}
@@ -18,6 +18,17 @@ type mockServers struct {
 // mockServerOverrides stores the override URLs
 var mockServerOverrides *mockServers
 
+// mockConfigHook adjusts the loaded configuration after the server overrides
+var mockConfigHook func(cfg *manager.Config)
+
+// SetMockConfigHook registers a function that adjusts every loaded
+// configuration after the server overrides, e.g. to point it at test servers
+// that need more than the URLs. This method only exists when built with the
+// testutils tag.
+func (app *Application) SetMockConfigHook(hook func(cfg *manager.Config)) {
+	mockConfigHook = hook
+}
+
 // OverrideServersForMock configures the application to use mock server URLs
 // This method only exists when built with the testutils tag
 func (app *Application) OverrideServersForMock(acmeURL, acmeDnsURL string) error {
@@ -48,5 +59,19 @@ func (app *Application) applyMockOverrides(cfg *manager.Config) {
 
 		cfg.AcmeServer = mockServerOverrides.acmeURL
 		cfg.AcmeDnsServer = mockServerOverrides.acmeDnsURL
+		for suffix := range cfg.AcmeDnsServers {
+			cfg.AcmeDnsServers[suffix] = mockServerOverrides.acmeDnsURL
+		}
+		if cfg.AutoDomains != nil {
+			for name, certCfg := range cfg.AutoDomains.Certs {
+				if certCfg.AcmeServer != "" {
+					certCfg.AcmeServer = mockServerOverrides.acmeURL
+					cfg.AutoDomains.Certs[name] = certCfg
+				}
+			}
+		}
+	}
+	if mockConfigHook != nil {
+		mockConfigHook(cfg)
 	}
 }
@@ -0,0 +1,248 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+	"github.com/oetiker/go-acme-dns-manager/pkg/events"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+	"github.com/oetiker/go-acme-dns-manager/pkg/notify"
+)
+
+// defaultNotificationTypes are the event types a channel notifies of unless
+// its 'events' setting says otherwise
+var defaultNotificationTypes = []events.Type{events.TypeCertificateFailed, events.TypeDNSSetupNeeded}
+
+// notificationTarget is a notifier with the event types it notifies of
+type notificationTarget struct {
+	notifier notify.Notifier
+	types    map[events.Type]bool
+}
+
+// newNotificationTargets builds the notifiers configured in the
+// 'notifications' section
+func newNotificationTargets(cfg *manager.Config) ([]notificationTarget, error) {
+	nc := cfg.Notifications
+	if nc == nil {
+		return nil, nil
+	}
+	var targets []notificationTarget
+	add := func(notifier notify.Notifier, types []string) {
+		var selected []events.Type
+		for _, t := range types {
+			selected = append(selected, events.Type(t))
+		}
+		targets = append(targets, newNotificationTarget(notifier, selected))
+	}
+
+	if sc := nc.SMTP; sc != nil {
+		n, err := notify.NewSMTPNotifier(notify.SMTPOptions{
+			Host:     sc.Host,
+			Port:     sc.Port,
+			Username: sc.Username,
+			Password: sc.Password,
+			From:     sc.From,
+			To:       sc.To,
+			TLS:      sc.TLS,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("configuring SMTP notifications: %w", err)
+		}
+		add(n, sc.Events)
+	}
+
+	if pc := nc.Pushover; pc != nil {
+		n, err := notify.NewPushoverNotifier(notify.PushoverOptions{
+			Token:     pc.Token,
+			User:      pc.User,
+			Priority:  pc.Priority,
+			Transport: cfg.ClientTransport(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("configuring Pushover notifications: %w", err)
+		}
+		add(n, pc.Events)
+	}
+
+	if tc := nc.Telegram; tc != nil {
+		n, err := notify.NewTelegramNotifier(notify.TelegramOptions{
+			BotToken:  tc.BotToken,
+			ChatID:    tc.ChatID,
+			Transport: cfg.ClientTransport(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("configuring Telegram notifications: %w", err)
+		}
+		add(n, tc.Events)
+	}
+
+	if tc := nc.Teams; tc != nil {
+		n, err := notify.NewTeamsNotifier(notify.TeamsOptions{WebhookURL: tc.WebhookURL, Transport: cfg.ClientTransport()})
+		if err != nil {
+			return nil, fmt.Errorf("configuring Teams notifications: %w", err)
+		}
+		add(n, tc.Events)
+	}
+
+	return targets, nil
+}
+
+// newNotificationTarget selects the event types of a notifier, the
+// defaultNotificationTypes if types is empty
+func newNotificationTarget(notifier notify.Notifier, types []events.Type) notificationTarget {
+	if len(types) == 0 {
+		types = defaultNotificationTypes
+	}
+	selected := make(map[events.Type]bool, len(types))
+	for _, t := range types {
+		selected[t] = true
+	}
+	return notificationTarget{notifier: notifier, types: selected}
+}
+
+// AddNotifier adds a notifier for the given event types, by default for
+// failed certificates and required DNS changes
+func (cm *CertificateManager) AddNotifier(notifier notify.Notifier, types ...events.Type) {
+	cm.notifiers = append(cm.notifiers, newNotificationTarget(notifier, types))
+}
+
+// collectDNSSetup keeps the records reported during a run for the
+// notification. The certificates of a parallel run may report concurrently.
+func (cm *CertificateManager) collectDNSSetup(setupInfo []manager.DNSSetupInfo) {
+	cm.notifyMu.Lock()
+	defer cm.notifyMu.Unlock()
+	cm.dnsSetup = append(cm.dnsSetup, setupInfo...)
+}
+
+// takeDNSSetup returns the collected records, each once, and forgets them
+func (cm *CertificateManager) takeDNSSetup() []manager.DNSSetupInfo {
+	cm.notifyMu.Lock()
+	defer cm.notifyMu.Unlock()
+	setupInfo := uniqueDNSSetup(cm.dnsSetup)
+	cm.dnsSetup = nil
+	return setupInfo
+}
+
+// notifyRun tells every notifier about the events of a run it selected,
+// nothing if none of them happened
+func (cm *CertificateManager) notifyRun(ctx context.Context, results []CertResult, runErr error) {
+	if len(cm.notifiers) == 0 {
+		return
+	}
+	dnsSetup := cm.takeDNSSetup()
+	// A shutdown is no failure to alert anyone about
+	if common.IsContextCanceled(ctx) {
+		return
+	}
+	for _, target := range cm.notifiers {
+		if msg, ok := runMessage(results, runErr, dnsSetup, target.types); ok {
+			cm.sendNotification(ctx, target.notifier, msg)
+		}
+	}
+}
+
+// notifyDNSSetup sends the records reported so far right away, for runs
+// that wait for them instead of ending
+func (cm *CertificateManager) notifyDNSSetup(ctx context.Context) {
+	if len(cm.notifiers) == 0 {
+		return
+	}
+	dnsSetup := cm.takeDNSSetup()
+	for _, target := range cm.notifiers {
+		if msg, ok := runMessage(nil, nil, dnsSetup, target.types); ok {
+			cm.sendNotification(ctx, target.notifier, msg)
+		}
+	}
+}
+
+// sendNotification delivers msg. Failures are only logged, like those of
+// event publishers, the certificates on disk are what matters.
+func (cm *CertificateManager) sendNotification(ctx context.Context, notifier notify.Notifier, msg notify.Message) {
+	// Use a context detached from cancellation, the notifier limits the delivery itself
+	if err := notifier.Notify(context.WithoutCancel(ctx), msg); err != nil {
+		cm.logger.Warnf("Failed to send notification %q: %v", msg.Subject, err)
+		return
+	}
+	cm.logger.Infof("Sent notification %q", msg.Subject)
+}
+
+// runMessage describes the events of a run selected by types: failed,
+// issued and renewed certificates and the required DNS records. It reports
+// false if none of them happened.
+func runMessage(results []CertResult, runErr error, dnsSetup []manager.DNSSetupInfo, types map[events.Type]bool) (notify.Message, bool) {
+	var failed, issued, renewed []CertResult
+	for _, r := range results {
+		switch {
+		case r.failed():
+			failed = append(failed, r)
+		case r.Outcome == OutcomeIssued:
+			issued = append(issued, r)
+		case r.Outcome == OutcomeRenewed:
+			renewed = append(renewed, r)
+		}
+	}
+	// The run failed before any certificate was processed
+	runFailed := len(results) == 0 && runErr != nil && !errors.Is(runErr, manager.ErrDNSSetupNeeded)
+	if !types[events.TypeCertificateFailed] {
+		failed, runFailed = nil, false
+	}
+	if !types[events.TypeCertificateIssued] {
+		issued = nil
+	}
+	if !types[events.TypeCertificateRenewed] {
+		renewed = nil
+	}
+	if !types[events.TypeDNSSetupNeeded] {
+		dnsSetup = nil
+	}
+	if len(failed) == 0 && !runFailed && len(issued) == 0 && len(renewed) == 0 && len(dnsSetup) == 0 {
+		return notify.Message{}, false
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown host"
+	}
+	var topics []string
+	var body strings.Builder
+	fmt.Fprintf(&body, "go-acme-dns-manager on %s reports:\n", host)
+	if runFailed {
+		topics = append(topics, "run failed")
+		fmt.Fprintf(&body, "\nThe run failed: %v\n", runErr)
+	}
+	if len(failed) > 0 {
+		topics = append(topics, fmt.Sprintf("%d certificate(s) failed", len(failed)))
+		body.WriteString("\nFailed certificates:\n")
+		for _, r := range failed {
+			fmt.Fprintf(&body, "  %s (%s): %v\n", r.Name, r.Outcome, r.Err)
+		}
+	}
+	for _, done := range []struct {
+		verb    string
+		results []CertResult
+	}{{"issued", issued}, {"renewed", renewed}} {
+		if len(done.results) == 0 {
+			continue
+		}
+		topics = append(topics, fmt.Sprintf("%d certificate(s) %s", len(done.results), done.verb))
+		fmt.Fprintf(&body, "\nCertificates %s:\n", done.verb)
+		for _, r := range done.results {
+			fmt.Fprintf(&body, "  %s: valid until %s\n", r.Name, r.NewExpiry.Format("2006-01-02"))
+		}
+	}
+	if len(dnsSetup) > 0 {
+		topics = append(topics, fmt.Sprintf("%d DNS record(s) to create", len(dnsSetup)))
+		body.WriteString("\nREQUIRED DNS CHANGES: create these CNAME records so the\n" +
+			"certificates using these domains can be issued.\n\n")
+		body.WriteString(manager.DNSInstructionsText(dnsSetup))
+	}
+
+	return notify.Message{
+		Subject: fmt.Sprintf("go-acme-dns-manager on %s: %s", host, strings.Join(topics, ", ")),
+		Body:    body.String(),
+	}, true
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/events"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+	"github.com/oetiker/go-acme-dns-manager/pkg/notify"
+)
+
+// recordingNotifier collects the messages sent by the certificate manager
+type recordingNotifier struct {
+	messages []notify.Message
+}
+
+func (r *recordingNotifier) Notify(ctx context.Context, msg notify.Message) error {
+	r.messages = append(r.messages, msg)
+	return nil
+}
+
+func TestRunMessage(t *testing.T) {
+	defaults := newNotificationTarget(nil, nil).types
+	if _, ok := runMessage([]CertResult{{Name: "web", Outcome: OutcomeRenewed}, {Name: "api", Outcome: OutcomeSkipped}}, nil, nil, defaults); ok {
+		t.Error("Expected no message for a run without failures or DNS changes")
+	}
+
+	results := []CertResult{
+		{Name: "web", Outcome: OutcomeRenewed},
+		{Name: "api", Outcome: OutcomeFailed, Err: errors.New("order rejected")},
+	}
+	setupInfo := []manager.DNSSetupInfo{{ChallengeDomain: "_acme-challenge.new.example.com", TargetDomain: "abc.auth.example.org"}}
+	msg, ok := runMessage(results, &ProcessingError{Results: results}, setupInfo, defaults)
+	if !ok {
+		t.Fatal("Expected a message")
+	}
+	if !strings.Contains(msg.Subject, "1 certificate(s) failed, 1 DNS record(s) to create") {
+		t.Errorf("Unexpected subject %q", msg.Subject)
+	}
+	for _, want := range []string{"api (failed): order rejected", "REQUIRED DNS CHANGES", "_acme-challenge.new.example.com. IN CNAME abc.auth.example.org."} {
+		if !strings.Contains(msg.Body, want) {
+			t.Errorf("Expected %q in the body:\n%s", want, msg.Body)
+		}
+	}
+	if strings.Contains(msg.Body, "web") {
+		t.Errorf("Expected only the failed certificates:\n%s", msg.Body)
+	}
+
+	// The run failed before any certificate, e.g. at the DNS pre-check
+	msg, ok = runMessage(nil, errors.New("batch DNS pre-check failed: timeout"), nil, defaults)
+	if !ok || !strings.Contains(msg.Subject, "run failed") || !strings.Contains(msg.Body, "batch DNS pre-check failed: timeout") {
+		t.Errorf("Expected the run failure, got %+v", msg)
+	}
+	if _, ok := runMessage(nil, manager.ErrDNSSetupNeeded, nil, defaults); ok {
+		t.Error("Expected no failure message for DNS setup alone")
+	}
+}
+
+func TestRunMessage_SelectedTypes(t *testing.T) {
+	expiry := time.Date(2027, 1, 14, 0, 0, 0, 0, time.UTC)
+	results := []CertResult{
+		{Name: "web", Outcome: OutcomeRenewed, NewExpiry: expiry},
+		{Name: "api", Outcome: OutcomeIssued, NewExpiry: expiry},
+		{Name: "mail", Outcome: OutcomeFailed, Err: errors.New("order rejected")},
+	}
+	setupInfo := []manager.DNSSetupInfo{{ChallengeDomain: "_acme-challenge.new.example.com", TargetDomain: "abc.auth.example.org"}}
+
+	// A phone only hears about renewals
+	renewals := newNotificationTarget(nil, []events.Type{events.TypeCertificateRenewed}).types
+	msg, ok := runMessage(results, &ProcessingError{Results: results}, setupInfo, renewals)
+	if !ok {
+		t.Fatal("Expected a message")
+	}
+	if !strings.HasSuffix(msg.Subject, ": 1 certificate(s) renewed") || !strings.Contains(msg.Body, "web: valid until 2027-01-14") {
+		t.Errorf("Expected the renewal, got %+v", msg)
+	}
+	for _, unwanted := range []string{"api", "mail", "CNAME"} {
+		if strings.Contains(msg.Body, unwanted) {
+			t.Errorf("Expected no %q in the body:\n%s", unwanted, msg.Body)
+		}
+	}
+
+	// The DNS team only hears about records to create
+	dnsOnly := newNotificationTarget(nil, []events.Type{events.TypeDNSSetupNeeded}).types
+	if _, ok := runMessage(results, &ProcessingError{Results: results}, nil, dnsOnly); ok {
+		t.Error("Expected no message without DNS changes")
+	}
+}
+
+func TestProcessAutoMode_NotifiesDNSSetup(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := createTestConfig(tmpDir)
+	var reported int
+	cfg.DNSSetupReported = func([]manager.DNSSetupInfo) { reported++ }
+	cm, err := NewCertificateManager(cfg, &mockLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	notifier := &recordingNotifier{}
+	cm.AddNotifier(notifier)
+	cm.SetLegoRunner(func(ctx context.Context, cfg *manager.Config, store interface{}, action, certName string, domains []string, keyType string) error {
+		if certName == "wildcard-cert" {
+			manager.ReportDNSSetup(cfg, []manager.DNSSetupInfo{{ChallengeDomain: "_acme-challenge.test.com", TargetDomain: "abc.auth.example.org"}})
+			return manager.ErrDNSSetupNeeded
+		}
+		return mockLegoRunner(ctx, cfg, store, action, certName, domains, keyType)
+	})
+
+	if err := cm.ProcessAutoMode(context.Background()); !errors.Is(err, manager.ErrDNSSetupNeeded) {
+		t.Fatalf("Expected the run to wait for DNS setup, got %v", err)
+	}
+	if reported != 1 {
+		t.Errorf("Expected the existing hook to still see the records, got %d calls", reported)
+	}
+	if len(notifier.messages) != 1 {
+		t.Fatalf("Expected 1 notification, got %d", len(notifier.messages))
+	}
+	if body := notifier.messages[0].Body; !strings.Contains(body, "_acme-challenge.test.com. IN CNAME abc.auth.example.org.") {
+		t.Errorf("Expected the CNAME record in the notification:\n%s", body)
+	}
+
+	// The next run only reports what it finds itself
+	cm.SetLegoRunner(mockLegoRunner)
+	if err := cm.ProcessAutoMode(context.Background()); err != nil {
+		t.Fatalf("ProcessAutoMode failed: %v", err)
+	}
+	if len(notifier.messages) != 1 {
+		t.Errorf("Expected no notification for a clean run, got %+v", notifier.messages[1:])
+	}
+}
@@ -0,0 +1,382 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+// Values of -output
+const (
+	OutputText = "text" // Human-readable output, the default
+	OutputJSON = "json" // One OutputDocument on stdout, logs on stderr
+)
+
+// OutputDocument is what -output json writes to stdout when the command
+// ends, whether it succeeded or not. Wrappers read the outcome from it
+// instead of parsing the text output; the log stays on stderr.
+type OutputDocument struct {
+	Command  string `json:"command"` // Flag of the command, "auto" or "manual" for certificate runs
+	Version  string `json:"version"`
+	ExitCode int    `json:"exit_code"`
+
+	// Changed is set if a certificate was issued or renewed or the command
+	// changed the storage directory, also if the command failed otherwise
+	Changed bool `json:"changed"`
+
+	// Run holds the certificate results of auto and manual mode
+	Run *RunReport `json:"run,omitempty"`
+
+	// DNSChanges are the CNAME records to create before the run can continue
+	DNSChanges []DNSZoneOutput `json:"dns_changes,omitempty"`
+
+	// Result is the command-specific result, e.g. the checks of -validate
+	Result interface{} `json:"result,omitempty"`
+
+	Error *ErrorOutput `json:"error,omitempty"`
+}
+
+// DNSZoneOutput holds the required records of one DNS zone
+type DNSZoneOutput struct {
+	Zone    string            `json:"zone"`
+	Records []DNSRecordOutput `json:"records"`
+}
+
+// DNSRecordOutput is one required CNAME record
+type DNSRecordOutput struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Target    string   `json:"target"`
+	MissingOn []string `json:"missing_on,omitempty"` // Authoritative nameservers still missing it
+}
+
+// ErrorOutput describes the error a command ended with
+type ErrorOutput struct {
+	Message     string                 `json:"message"`
+	Type        string                 `json:"type,omitempty"` // Error type of structured errors, e.g. CONFIG
+	Operation   string                 `json:"operation,omitempty"`
+	Context     map[string]interface{} `json:"context,omitempty"`
+	Suggestions []string               `json:"suggestions,omitempty"`
+}
+
+// jsonOutput collects the document of a -output json run
+type jsonOutput struct {
+	mu       sync.Mutex
+	doc      OutputDocument
+	dnsSetup []manager.DNSSetupInfo
+}
+
+// validateOutput checks -output and the commands it cannot be combined with
+func (app *Application) validateOutput() error {
+	switch app.config.Output {
+	case "", OutputText:
+		return nil
+	case OutputJSON:
+	default:
+		return common.NewValidationError("select output", fmt.Sprintf("Unknown output format %q", app.config.Output)).
+			AddSuggestion("Use -output text or -output json")
+	}
+	switch {
+	case app.config.Daemon:
+		return common.NewValidationError("select output", "-output json writes one document when a command ends, the daemon does not end").
+			AddSuggestion("Use -auto from a timer, or the /certs endpoint of status_listen to query the daemon")
+	case app.config.Init:
+		return common.NewValidationError("select output", "-init asks questions on the terminal and has no JSON output").
+			AddSuggestion("Run -init without -output json")
+	}
+	return nil
+}
+
+// stdout returns where commands write their text output, which -output
+// json discards in favour of the document
+func (app *Application) stdout() io.Writer {
+	if app.output != nil {
+		return io.Discard
+	}
+	return os.Stdout
+}
+
+// setResult records the command-specific result for -output json
+func (app *Application) setResult(result interface{}) {
+	if app.output == nil {
+		return
+	}
+	app.output.mu.Lock()
+	defer app.output.mu.Unlock()
+	app.output.doc.Result = result
+}
+
+// setRunReport records the certificate results of a run for -output json
+func (app *Application) setRunReport(report *RunReport) {
+	if app.output == nil {
+		return
+	}
+	app.output.mu.Lock()
+	defer app.output.mu.Unlock()
+	app.output.doc.Run = report
+}
+
+// recordDNSSetup collects the records manager.ReportDNSSetup displays. The
+// certificates of a parallel run may report concurrently.
+func (app *Application) recordDNSSetup(setupInfo []manager.DNSSetupInfo) {
+	app.output.mu.Lock()
+	defer app.output.mu.Unlock()
+	app.output.dnsSetup = append(app.output.dnsSetup, setupInfo...)
+}
+
+// writeOutput completes the document with the error the command ended with
+// and writes it to w
+func (app *Application) writeOutput(w io.Writer, err error) error {
+	app.output.mu.Lock()
+	defer app.output.mu.Unlock()
+	doc := app.output.doc
+	doc.ExitCode = ExitCode(err)
+	if err == nil {
+		doc.ExitCode = app.SuccessExitCode()
+	}
+	doc.Changed = app.changed
+	doc.DNSChanges = dnsChangesOutput(app.output.dnsSetup)
+	if err != nil {
+		doc.Error = errorOutput(err)
+	}
+	data, marshalErr := json.MarshalIndent(doc, "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+	_, writeErr := w.Write(append(data, '\n'))
+	return writeErr
+}
+
+// uniqueDNSSetup drops the records reported a second time, e.g. by another
+// certificate of the same run
+func uniqueDNSSetup(setupInfo []manager.DNSSetupInfo) []manager.DNSSetupInfo {
+	seen := make(map[string]bool)
+	var unique []manager.DNSSetupInfo
+	for _, info := range setupInfo {
+		if !seen[info.ChallengeDomain] {
+			seen[info.ChallengeDomain] = true
+			unique = append(unique, info)
+		}
+	}
+	return unique
+}
+
+// dnsChangesOutput groups the reported records by zone, a record reported
+// twice is listed once
+func dnsChangesOutput(setupInfo []manager.DNSSetupInfo) []DNSZoneOutput {
+	var zones []DNSZoneOutput
+	for _, zone := range manager.GroupDNSSetupByZone(uniqueDNSSetup(setupInfo)) {
+		out := DNSZoneOutput{Zone: zone.Zone}
+		for _, info := range zone.Records {
+			record := DNSRecordOutput{
+				Name:   strings.TrimSuffix(info.ChallengeDomain, "."),
+				Type:   "CNAME",
+				Target: strings.TrimSuffix(info.TargetDomain, "."),
+			}
+			record.MissingOn = info.MissingOn
+			out.Records = append(out.Records, record)
+		}
+		zones = append(zones, out)
+	}
+	return zones
+}
+
+// errorOutput describes err, with the details of structured errors
+func errorOutput(err error) *ErrorOutput {
+	out := &ErrorOutput{Message: err.Error()}
+	var appErr *common.ApplicationError
+	if errors.As(err, &appErr) {
+		out.Type = string(appErr.Type)
+		out.Operation = appErr.Operation
+		out.Context = appErr.Context
+		out.Suggestions = appErr.Suggestions
+	}
+	return out
+}
+
+// commandName returns the command Run dispatches to, in the same order
+func (app *Application) commandName() string {
+	c := app.config
+	switch {
+	case c.ShowVersion:
+		return "version"
+	case c.PrintConfigTemplate:
+		return "print-config-template"
+	case c.Validate:
+		return "validate"
+	case c.Check:
+		return "check"
+	case c.DebugBundle != "":
+		return "debug-bundle"
+	case c.MetricsDump:
+		return "metrics-dump"
+	case c.Fsck:
+		return "fsck"
+	case c.VerifyStorage:
+		return "verify-storage"
+	case c.FixPerms:
+		return "fix-perms"
+	case c.Diff:
+		return "diff"
+	case c.Optimize:
+		return "optimize"
+	case c.ImportCertbot != "":
+		return "import-certbot"
+	case c.Adopt != "":
+		return "adopt"
+	case c.Rollback != "":
+		return "rollback"
+	case c.Delete != "":
+		return "delete"
+	case c.OrphanScan:
+		return "orphan-scan"
+	case c.PruneAcmeDNS:
+		return "prune-acmedns-accounts"
+	case c.MigrateAccounts:
+		return "migrate-accounts"
+	case c.RotateAccountKey:
+		return "rotate-account-key"
+	case c.TestAcmeDNS:
+		return "test-acmedns"
+	case c.CheckOCSP:
+		return "check-ocsp"
+	case c.PendingOrders:
+		return "pending-orders"
+	case c.History != "":
+		return "history"
+	case c.RotateAcmeDNS != "":
+		return "rotate-acmedns-account"
+	case c.AutoMode:
+		return "auto"
+	}
+	return "manual"
+}
+
+// Results of the commands in the -output json document
+
+type versionOutput struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+type configTemplateOutput struct {
+	Template string `json:"template"`
+}
+
+type diffOutput struct {
+	Drifts []manager.Drift `json:"drifts"`
+}
+
+type optimizeOutput struct {
+	Hints []manager.WildcardHint `json:"hints"`
+}
+
+type autoDomainsOutput struct {
+	Imported    int    `json:"imported,omitempty"`     // Certificates imported by -import-certbot
+	AutoDomains string `json:"auto_domains,omitempty"` // YAML to add to the configuration
+}
+
+type orphanScanOutput struct {
+	Orphans []manager.Orphan `json:"orphans"`
+}
+
+type pendingOrderOutput struct {
+	CertName   string    `json:"cert_name"`
+	OrderURL   string    `json:"order_url"`
+	AcmeServer string    `json:"acme_server"`
+	Domains    []string  `json:"domains"`
+	Created    time.Time `json:"created"`
+}
+
+type pendingOrdersOutput struct {
+	Orders []pendingOrderOutput `json:"orders"`
+}
+
+type historyOutput struct {
+	Records    []manager.AuditRecord `json:"records"`
+	BrokenLine int                   `json:"broken_line,omitempty"` // First record not chained to the one before it
+}
+
+type acmeDNSAccountOutput struct {
+	Domain     string `json:"domain"`
+	FullDomain string `json:"full_domain"`
+}
+
+type pruneOutput struct {
+	Unused  []acmeDNSAccountOutput `json:"unused"`
+	Removed bool                   `json:"removed"`
+}
+
+type migrationOutput struct {
+	Domain  string   `json:"domain"`
+	Keys    []string `json:"keys"`
+	Keep    string   `json:"keep"` // fulldomain of the kept account
+	Dropped []string `json:"dropped,omitempty"`
+	Live    bool     `json:"live"`
+}
+
+type migrateOutput struct {
+	Migrations []migrationOutput `json:"migrations"`
+	Applied    bool              `json:"applied"`
+}
+
+type selfTestOutput struct {
+	Domain          string  `json:"domain"`
+	ChallengeDomain string  `json:"challenge_domain,omitempty"`
+	FullDomain      string  `json:"full_domain,omitempty"`
+	OK              bool    `json:"ok"`
+	Step            string  `json:"step,omitempty"`
+	Error           string  `json:"error,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+type ocspOutput struct {
+	CertName  string     `json:"cert_name"`
+	Status    string     `json:"status,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+type validateCheckOutput struct {
+	Group  string `json:"group"`
+	Target string `json:"target"`
+	OK     bool   `json:"ok"`
+	Note   string `json:"note,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type validateOutput struct {
+	Checks []validateCheckOutput `json:"checks"`
+}
+
+type checkCertOutput struct {
+	Name          string   `json:"name"`
+	State         string   `json:"state"`
+	Problem       string   `json:"problem,omitempty"`
+	DaysRemaining *float64 `json:"days_remaining,omitempty"`
+}
+
+type checkOutput struct {
+	State        string            `json:"state"`
+	Summary      string            `json:"summary"`
+	Certificates []checkCertOutput `json:"certificates"`
+}
+
+// errorString returns the message of err, empty for nil
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
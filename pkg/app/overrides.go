@@ -0,0 +1,122 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+// stringList collects the values of a flag given several times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// envPrefix starts the names of the environment variables overriding config values
+const envPrefix = "ACME_DNS_MANAGER_"
+
+// envOverrideKeys maps environment variables (without envPrefix) to the
+// config keys they replace
+var envOverrideKeys = []struct{ env, key string }{
+	{"EMAIL", "email"},
+	{"ACME_SERVER", "acme_server"},
+	{"ACME_DNS_SERVER", "acme_dns_server"},
+	{"STORAGE_PATH", "cert_storage_path"},
+}
+
+// envOverrides returns the config values replaced by ACME_DNS_MANAGER_*
+// environment variables, e.g. for containers without a mounted config file
+func envOverrides() []manager.ConfigOverride {
+	var overrides []manager.ConfigOverride
+	for _, entry := range envOverrideKeys {
+		if value := os.Getenv(envPrefix + entry.env); value != "" {
+			overrides = append(overrides, stringOverride(entry.key, value))
+		}
+	}
+	return overrides
+}
+
+// stringOverride replaces key with value as a string, even if value looks
+// like a number or a YAML flow
+func stringOverride(key, value string) manager.ConfigOverride {
+	return manager.ConfigOverride{Key: key, Value: strconv.Quote(value)}
+}
+
+// configOverrides returns the config values replaced by the environment and
+// on the command line. Later entries win: the environment comes first, then
+// the dedicated flags and -set last so that it can still refine them.
+func (app *Application) configOverrides() ([]manager.ConfigOverride, error) {
+	overrides := envOverrides()
+	add := func(key, value string) {
+		if value != "" {
+			overrides = append(overrides, stringOverride(key, value))
+		}
+	}
+	add("email", app.config.Email)
+	add("acme_server", app.config.AcmeServer)
+	add("acme_dns_server", app.config.AcmeDnsServer)
+	if app.config.StoragePath != "" {
+		// Relative to the working directory, not to the config file
+		storagePath, err := filepath.Abs(app.config.StoragePath)
+		if err != nil {
+			return nil, err
+		}
+		add("cert_storage_path", storagePath)
+	}
+	for _, set := range app.config.Set {
+		override, err := manager.ParseConfigOverride(set)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, override)
+	}
+	return overrides, nil
+}
+
+// loadConfig loads the manager configuration with the command line
+// overrides applied. -acme-server and -acme-dns-server replace the servers
+// set per certificate and per domain suffix as well, so that a whole
+// configuration can be tried against staging.
+func (app *Application) loadConfig() (*manager.Config, error) {
+	overrides, err := app.configOverrides()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := manager.LoadConfigWithOverrides(app.config.ConfigPath, overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	if app.config.AcmeDnsServer != "" {
+		for suffix := range cfg.AcmeDnsServers {
+			cfg.AcmeDnsServers[suffix] = cfg.AcmeDnsServer
+		}
+	}
+	if app.config.AcmeServer != "" && cfg.AutoDomains != nil {
+		for name, certCfg := range cfg.AutoDomains.Certs {
+			if certCfg.AcmeServer != "" {
+				certCfg.AcmeServer = cfg.AcmeServer
+				cfg.AutoDomains.Certs[name] = certCfg
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// overriddenKeys lists the overridden config keys for the log
+func overriddenKeys(overrides []manager.ConfigOverride) string {
+	keys := make([]string, len(overrides))
+	for i, override := range overrides {
+		keys[i] = override.Key
+	}
+	return strings.Join(keys, ", ")
+}
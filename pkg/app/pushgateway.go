@@ -0,0 +1,70 @@
+package app
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+	"github.com/oetiker/go-acme-dns-manager/pkg/metrics"
+)
+
+// runOutcomes are reported to the Pushgateway even when no certificate had
+// them, so alerts on a count do not see a missing series
+var runOutcomes = []string{
+	OutcomeIssued, OutcomeRenewed, OutcomeSkipped, OutcomeDNSSetup,
+	OutcomeDeferred, OutcomeFailed, OutcomeDeployFailed,
+}
+
+// pushMetrics sends the metrics of the finished run to the Pushgateway of
+// cfg. A failure is only logged, the certificates are processed already.
+func (app *Application) pushMetrics(ctx context.Context, cfg *manager.Config, cm *CertificateManager, runErr error) {
+	if cfg.Pushgateway == nil {
+		return
+	}
+	now := time.Now()
+	snap, err := metrics.Collect(cfg, now)
+	if err != nil {
+		app.logger.Warnf("Warning: collecting metrics for the Pushgateway: %v", err)
+	}
+	instance := cfg.Pushgateway.Instance
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	opts := metrics.PushOptions{
+		URL:       cfg.Pushgateway.URL,
+		Job:       cfg.Pushgateway.Job,
+		Instance:  instance,
+		Username:  cfg.Pushgateway.Username,
+		Password:  cfg.Pushgateway.Password,
+		Transport: cfg.ClientTransport(),
+	}
+	// An interrupted run is worth reporting too
+	if err := metrics.Push(context.WithoutCancel(ctx), opts, newPushRun(cm.runStarted, now, cm.Results(), runErr), snap); err != nil {
+		app.logger.Warnf("Warning: %v", err)
+		return
+	}
+	app.logger.Debugf("Pushed the run metrics to %s", cfg.Pushgateway.URL)
+}
+
+// newPushRun summarizes the results of a run for the Pushgateway
+func newPushRun(started, now time.Time, results []CertResult, runErr error) metrics.Run {
+	if started.IsZero() {
+		started = now // The run failed before processing started
+	}
+	run := metrics.Run{
+		Started:       started,
+		Duration:      now.Sub(started),
+		Success:       runErr == nil,
+		Outcomes:      make(map[string]int),
+		CertDurations: make(map[string]time.Duration),
+	}
+	for _, outcome := range runOutcomes {
+		run.Outcomes[outcome] = 0
+	}
+	for _, r := range results {
+		run.Outcomes[r.Outcome]++
+		run.CertDurations[r.Name] = r.Duration
+	}
+	return run
+}
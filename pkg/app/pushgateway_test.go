@@ -0,0 +1,81 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+func TestNewPushRun(t *testing.T) {
+	started := time.Date(2026, 1, 1, 3, 30, 0, 0, time.UTC)
+	results := []CertResult{
+		{Name: "web", Outcome: OutcomeRenewed, Duration: 20 * time.Second},
+		{Name: "api", Outcome: OutcomeFailed, Duration: 5 * time.Second},
+	}
+	run := newPushRun(started, started.Add(30*time.Second), results, errors.New("1 certificate failed"))
+	if run.Success || run.Duration != 30*time.Second {
+		t.Errorf("Unexpected run %+v", run)
+	}
+	if run.Outcomes[OutcomeRenewed] != 1 || run.Outcomes[OutcomeFailed] != 1 || len(run.Outcomes) != len(runOutcomes) {
+		t.Errorf("Expected a count for every outcome, got %v", run.Outcomes)
+	}
+	if run.CertDurations["web"] != 20*time.Second {
+		t.Errorf("Unexpected certificate durations %v", run.CertDurations)
+	}
+
+	// A run that failed before any certificate has no start time
+	now := time.Now()
+	if run := newPushRun(time.Time{}, now, nil, errors.New("pre-check failed")); !run.Started.Equal(now) || run.Duration != 0 {
+		t.Errorf("Expected the run to start now, got %+v", run)
+	}
+}
+
+func TestPushMetrics(t *testing.T) {
+	var path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer server.Close()
+
+	cfg := createTestConfig(t.TempDir())
+	cfg.Pushgateway = &manager.PushgatewayConfig{URL: server.URL, Job: "certs", Instance: "web1"}
+	cm, err := NewCertificateManager(cfg, &mockLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	cm.SetLegoRunner(mockLegoRunner)
+	runErr := cm.ProcessAutoMode(context.Background())
+	if runErr != nil {
+		t.Fatalf("ProcessAutoMode failed: %v", runErr)
+	}
+
+	app := NewApplication("test-version")
+	logger := &mockLogger{}
+	app.logger = logger
+	app.pushMetrics(context.Background(), cfg, cm, runErr)
+	if path != "/metrics/job/certs/instance/web1" {
+		t.Errorf("Unexpected push path %q", path)
+	}
+	if !strings.Contains(body, "acme_dns_manager_last_run_success 1\n") {
+		t.Errorf("Expected a successful run in:\n%s", body)
+	}
+	if len(logger.warnMessages) != 0 {
+		t.Errorf("Expected no warnings, got %v", logger.warnMessages)
+	}
+
+	// An unreachable Pushgateway does not fail the run
+	server.Close()
+	app.pushMetrics(context.Background(), cfg, cm, runErr)
+	if len(logger.warnMessages) != 1 {
+		t.Errorf("Expected a warning, got %v", logger.warnMessages)
+	}
+}
@@ -0,0 +1,358 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+// Outcomes of a certificate request as shown in the run summary
+const (
+	OutcomeIssued   = "issued"
+	OutcomeRenewed  = "renewed"
+	OutcomeSkipped  = "skipped"   // Still valid, nothing to do
+	OutcomeDNSSetup = "dns-setup" // Waiting for CNAME records to be created
+	OutcomeDeferred = "deferred"  // Rate limited, retried on the next run
+	OutcomeFailed   = "failed"
+
+	// The certificate is current but could not be deployed to all targets
+	OutcomeDeployFailed = "deploy-failed"
+)
+
+// Process exit codes, so wrappers and cron monitoring can tell what a run
+// needs: nothing, new DNS records, a look at some certificates or at the
+// configuration, or another attempt against the ACME server later
+const (
+	ExitOK             = 0 // All certificates are fine, or nothing had to be done
+	ExitTotalFailure   = 1 // Nothing succeeded, or the run failed for another reason
+	ExitDNSSetup       = 2 // CNAME records must be created before the run can continue
+	ExitPartialFailure = 3 // Some certificates failed while others were processed
+	ExitConfigError    = 4 // The configuration is invalid or cannot be loaded
+	ExitACMEError      = 5 // The ACME server refused the request or rate limited it
+
+	// ExitChanged replaces ExitOK with -changed-exit-code when a certificate
+	// was issued or renewed, or a command changed the storage directory
+	ExitChanged = 9
+)
+
+// summaryReasonLength limits the failure reason shown in the summary table,
+// the full error was already logged when the certificate failed
+const summaryReasonLength = 100
+
+// CertResult is the outcome of one certificate request
+type CertResult struct {
+	Name      string
+	Outcome   string
+	Err       error
+	Action    string   // init, renew or skip, empty if it could not be determined
+	Domains   []string // Requested domains
+	OldExpiry time.Time
+	NewExpiry time.Time // Zero unless a certificate was issued or renewed
+	Duration  time.Duration
+}
+
+// failed reports whether the result counts as a failure of the run
+func (r CertResult) failed() bool {
+	return r.Outcome == OutcomeFailed || r.Outcome == OutcomeDeferred || r.Outcome == OutcomeDeployFailed
+}
+
+// changed reports whether the result put a new certificate in place
+func (r CertResult) changed() bool {
+	return r.Outcome == OutcomeIssued || r.Outcome == OutcomeRenewed
+}
+
+// ProcessingError is returned by ProcessAutoMode if certificates failed. It
+// holds the results of all certificates and unwraps to the individual errors,
+// so errors.Is and errors.As see every failure.
+type ProcessingError struct {
+	Results []CertResult
+}
+
+// Failures returns the results of the failed and deferred certificates
+func (e *ProcessingError) Failures() []CertResult {
+	var failures []CertResult
+	for _, r := range e.Results {
+		if r.failed() {
+			failures = append(failures, r)
+		}
+	}
+	return failures
+}
+
+// Partial reports whether some certificates were processed successfully.
+// Certificates waiting for DNS records do not count as processed.
+func (e *ProcessingError) Partial() bool {
+	for _, r := range e.Results {
+		if !r.failed() && r.Outcome != OutcomeDNSSetup {
+			return true
+		}
+	}
+	return false
+}
+
+// Error implements the error interface
+func (e *ProcessingError) Error() string {
+	var names []string
+	for _, r := range e.Failures() {
+		names = append(names, r.Name)
+	}
+	return fmt.Sprintf("%d of %d certificate(s) failed: %s", len(names), len(e.Results), strings.Join(names, ", "))
+}
+
+// Unwrap returns the errors of the failed certificates and of those waiting
+// for DNS records, so errors.Is finds ErrDNSSetupNeeded next to the failures
+func (e *ProcessingError) Unwrap() []error {
+	var errs []error
+	for _, r := range e.Results {
+		if r.failed() || r.Outcome == OutcomeDNSSetup {
+			errs = append(errs, r.Err)
+		}
+	}
+	return errs
+}
+
+// dnsSetupOnly reports whether err only asks for DNS records, without
+// certificates that failed
+func dnsSetupOnly(err error) bool {
+	var procErr *ProcessingError
+	return errors.Is(err, manager.ErrDNSSetupNeeded) && !errors.As(err, &procErr)
+}
+
+// ExitCode maps the error returned by Run to the process exit code. The
+// -check mode exits with the Nagios plugin state instead.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var checkErr *CheckError
+	if errors.As(err, &checkErr) {
+		return checkErr.State
+	}
+	var procErr *ProcessingError
+	if errors.As(err, &procErr) {
+		if procErr.Partial() {
+			return ExitPartialFailure
+		}
+		// Every certificate failed: an ACME exit code only if all of them
+		// were refused by the ACME server
+		for _, r := range procErr.Failures() {
+			if !manager.IsACMEError(r.Err) {
+				return ExitTotalFailure
+			}
+		}
+		return ExitACMEError
+	}
+	var appErr *common.ApplicationError
+	switch {
+	case errors.Is(err, manager.ErrDNSSetupNeeded):
+		return ExitDNSSetup
+	case errors.As(err, &appErr) && appErr.IsType(common.ErrorTypeConfig):
+		return ExitConfigError
+	case manager.IsACMEError(err):
+		return ExitACMEError
+	}
+	return ExitTotalFailure
+}
+
+// resultFor classifies the outcome of an action
+func resultFor(req CertRequest, action string, err error) CertResult {
+	result := CertResult{Name: req.Name, Err: err, Action: action, Domains: req.Domains}
+	switch {
+	case errors.Is(err, manager.ErrDNSSetupNeeded):
+		result.Outcome = OutcomeDNSSetup
+	case common.IsRateLimitError(err):
+		result.Outcome = OutcomeDeferred
+	case err != nil:
+		result.Outcome = OutcomeFailed
+	case action == "init":
+		result.Outcome = OutcomeIssued
+	case action == "renew":
+		result.Outcome = OutcomeRenewed
+	default:
+		result.Outcome = OutcomeSkipped
+	}
+	return result
+}
+
+// logSummary prints a table of all certificate results. With failures it is
+// logged at warning level so it also shows in quiet mode. In quiet mode a run
+// that only skipped valid certificates prints nothing, otherwise the table
+// lists the certificates that changed or need attention at warning level, so
+// cron mails the summary exactly when something happened.
+func (cm *CertificateManager) logSummary(results []CertResult) {
+	if len(results) == 0 {
+		return
+	}
+	logf := cm.logger.Infof
+	counts := make(map[string]int)
+	nameWidth := len("CERTIFICATE")
+	var rows []CertResult
+	for _, r := range results {
+		counts[r.Outcome]++
+		if r.failed() {
+			logf = cm.logger.Warnf
+		}
+		if cm.quiet && r.Outcome == OutcomeSkipped {
+			continue
+		}
+		rows = append(rows, r)
+		if len(r.Name) > nameWidth {
+			nameWidth = len(r.Name)
+		}
+	}
+	if cm.quiet {
+		if len(rows) == 0 {
+			return
+		}
+		logf = cm.logger.Warnf
+	}
+
+	logf("===== CERTIFICATE SUMMARY =====")
+	logf("    %-*s  %-9s  %s", nameWidth, "CERTIFICATE", "RESULT", "DETAILS")
+	for _, r := range rows {
+		logf("    %-*s  %-9s  %s", nameWidth, r.Name, r.Outcome, summaryReason(r.Err))
+	}
+
+	var totals []string
+	for _, outcome := range []string{OutcomeIssued, OutcomeRenewed, OutcomeSkipped, OutcomeDNSSetup, OutcomeDeferred, OutcomeFailed, OutcomeDeployFailed} {
+		if counts[outcome] > 0 {
+			totals = append(totals, fmt.Sprintf("%d %s", counts[outcome], outcome))
+		}
+	}
+	logf("%s", strings.Join(totals, ", "))
+	logf("===============================")
+}
+
+// summaryReason shortens an error to a single table cell
+func summaryReason(err error) string {
+	if err == nil {
+		return ""
+	}
+	reason := []rune(strings.Join(strings.Fields(err.Error()), " "))
+	if len(reason) > summaryReasonLength {
+		return string(reason[:summaryReasonLength-3]) + "..."
+	}
+	return string(reason)
+}
+
+// RunReport is the machine-readable report of a run written to run_report
+type RunReport struct {
+	Started      time.Time    `json:"started"`
+	Finished     time.Time    `json:"finished"`
+	Mode         string       `json:"mode"` // auto or manual
+	ExitCode     int          `json:"exit_code"`
+	Error        string       `json:"error,omitempty"`
+	Certificates []CertReport `json:"certificates"`
+}
+
+// CertReport is the entry of one certificate in the RunReport
+type CertReport struct {
+	Name            string     `json:"name"`
+	Action          string     `json:"action,omitempty"`
+	Outcome         string     `json:"outcome"`
+	Domains         []string   `json:"domains"`
+	OldExpiry       *time.Time `json:"old_expiry,omitempty"`
+	NewExpiry       *time.Time `json:"new_expiry,omitempty"`
+	DurationSeconds float64    `json:"duration_seconds"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// newRunReport builds the report of a run from the certificate results and
+// the error the run ends with
+func newRunReport(started time.Time, mode string, results []CertResult, runErr error) *RunReport {
+	report := &RunReport{
+		Started:      started.UTC(),
+		Finished:     time.Now().UTC(),
+		Mode:         mode,
+		ExitCode:     ExitCode(runErr),
+		Certificates: []CertReport{},
+	}
+	if runErr != nil {
+		report.Error = runErr.Error()
+	}
+	optionalTime := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		t = t.UTC()
+		return &t
+	}
+	for _, r := range results {
+		entry := CertReport{
+			Name:            r.Name,
+			Action:          r.Action,
+			Outcome:         r.Outcome,
+			Domains:         r.Domains,
+			OldExpiry:       optionalTime(r.OldExpiry),
+			NewExpiry:       optionalTime(r.NewExpiry),
+			DurationSeconds: r.Duration.Seconds(),
+		}
+		if r.Err != nil {
+			entry.Error = r.Err.Error()
+		}
+		report.Certificates = append(report.Certificates, entry)
+	}
+	return report
+}
+
+// writeRunReport replaces the report file atomically, so a monitoring system
+// never reads a half written report
+func writeRunReport(path string, report *RunReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// removeFromRunReport drops a deleted certificate from the run report, a
+// missing report is left alone
+func removeFromRunReport(path, certName string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var report RunReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return err
+	}
+	certificates := report.Certificates[:0]
+	for _, entry := range report.Certificates {
+		if entry.Name != certName {
+			certificates = append(certificates, entry)
+		}
+	}
+	if len(certificates) == len(report.Certificates) {
+		return nil
+	}
+	report.Certificates = certificates
+	return writeRunReport(path, &report)
+}
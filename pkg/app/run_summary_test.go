@@ -0,0 +1,306 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+// failingRunner fails the named certificates and issues all others
+func failingRunner(failures map[string]error) LegoRunnerFunc {
+	return func(ctx context.Context, cfg *manager.Config, store interface{}, action, certName string, domains []string, keyType string) error {
+		if err, ok := failures[certName]; ok {
+			return err
+		}
+		return mockLegoRunner(ctx, cfg, store, action, certName, domains, keyType)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	partial := &ProcessingError{Results: []CertResult{
+		{Name: "a", Outcome: OutcomeRenewed},
+		{Name: "b", Outcome: OutcomeFailed, Err: errors.New("boom")},
+	}}
+	total := &ProcessingError{Results: []CertResult{
+		{Name: "b", Outcome: OutcomeFailed, Err: errors.New("boom")},
+	}}
+	rateLimited := common.NewRateLimitError("obtain certificate", "Rate limited")
+	refused := &ProcessingError{Results: []CertResult{
+		{Name: "a", Outcome: OutcomeDeferred, Err: rateLimited},
+		{Name: "b", Outcome: OutcomeFailed, Err: fmt.Errorf("obtain: %w", &acme.ProblemDetails{Type: "urn:ietf:params:acme:error:rejectedIdentifier"})},
+	}}
+	dnsAndFailed := &ProcessingError{Results: []CertResult{
+		{Name: "a", Outcome: OutcomeDNSSetup, Err: manager.ErrDNSSetupNeeded},
+		{Name: "b", Outcome: OutcomeFailed, Err: errors.New("boom")},
+	}}
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"ok", nil, ExitOK},
+		{"dns setup and failed", dnsAndFailed, ExitTotalFailure},
+		{"partial", fmt.Errorf("processing certificates in auto mode: %w", partial), ExitPartialFailure},
+		{"total", total, ExitTotalFailure},
+		{"total acme", refused, ExitACMEError},
+		{"dns setup", manager.ErrDNSSetupNeeded, ExitDNSSetup},
+		{"config", fmt.Errorf("loading manager config: %w", common.NewConfigError("parse config file", "Invalid YAML")), ExitConfigError},
+		{"rate limit", rateLimited, ExitACMEError},
+		{"check", &CheckError{State: CheckWarning}, CheckWarning},
+		{"other", errors.New("storage broken"), ExitTotalFailure},
+	}
+	for _, tt := range tests {
+		if got := ExitCode(tt.err); got != tt.want {
+			t.Errorf("%s: expected exit code %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestProcessingError_KeepsDNSSetupNeeded(t *testing.T) {
+	err := &ProcessingError{Results: []CertResult{
+		{Name: "a", Outcome: OutcomeRenewed},
+		{Name: "b", Outcome: OutcomeDNSSetup, Err: manager.ErrDNSSetupNeeded},
+		{Name: "c", Outcome: OutcomeFailed, Err: errors.New("boom")},
+	}}
+	if !errors.Is(err, manager.ErrDNSSetupNeeded) {
+		t.Error("Expected ErrDNSSetupNeeded in the error chain next to the failure")
+	}
+	if dnsSetupOnly(err) {
+		t.Error("Expected the failure to count, not only the DNS setup")
+	}
+	if !dnsSetupOnly(manager.ErrDNSSetupNeeded) {
+		t.Error("Expected a bare ErrDNSSetupNeeded to be DNS setup only")
+	}
+	if got := ExitCode(err); got != ExitPartialFailure {
+		t.Errorf("Expected partial failure with one renewal, got %d", got)
+	}
+}
+
+func TestSummaryReason_TruncatesRunes(t *testing.T) {
+	reason := summaryReason(errors.New(strings.Repeat("ä", 2*summaryReasonLength)))
+	if !utf8.ValidString(reason) {
+		t.Errorf("Expected valid UTF-8, got %q", reason)
+	}
+	if n := utf8.RuneCountInString(reason); n != summaryReasonLength {
+		t.Errorf("Expected %d characters, got %d", summaryReasonLength, n)
+	}
+}
+
+func TestApplication_SuccessExitCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		flag    bool
+		results []CertResult
+		want    int
+		changed bool
+	}{
+		{"unchanged", true, []CertResult{{Outcome: OutcomeSkipped}}, ExitOK, false},
+		{"renewed", true, []CertResult{{Outcome: OutcomeSkipped}, {Outcome: OutcomeRenewed}}, ExitChanged, true},
+		{"issued", true, []CertResult{{Outcome: OutcomeIssued}}, ExitChanged, true},
+		{"without flag", false, []CertResult{{Outcome: OutcomeIssued}}, ExitOK, true},
+		{"dns setup", true, []CertResult{{Outcome: OutcomeDNSSetup}}, ExitOK, false},
+	}
+	for _, tt := range tests {
+		app := NewApplication("test")
+		app.config.ChangedExitCode = tt.flag
+		for _, r := range tt.results {
+			if r.changed() {
+				app.markChanged()
+			}
+		}
+		if got := app.SuccessExitCode(); got != tt.want || app.Changed() != tt.changed {
+			t.Errorf("%s: expected exit code %d and changed %v, got %d and %v", tt.name, tt.want, tt.changed, got, app.Changed())
+		}
+	}
+}
+
+func TestProcessAutoMode_ContinuesWithSummary(t *testing.T) {
+	logger := &mockLogger{}
+	cm, err := NewCertificateManager(createParallelTestConfig(t.TempDir(), 3, 1), logger)
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	boom := errors.New("acme: error: 403 :: urn:ietf:params:acme:error:unauthorized")
+	cm.SetLegoRunner(failingRunner(map[string]error{"cert-01": boom}))
+
+	err = cm.ProcessAutoMode(context.Background())
+	var procErr *ProcessingError
+	if !errors.As(err, &procErr) || !errors.Is(err, boom) {
+		t.Fatalf("Expected processing error wrapping the failure, got %v", err)
+	}
+	if len(procErr.Results) != 3 || len(procErr.Failures()) != 1 || !procErr.Partial() {
+		t.Errorf("Expected 1 of 3 certificates to fail, got %+v", procErr.Results)
+	}
+	if !strings.Contains(err.Error(), "1 of 3 certificate(s) failed: cert-01") {
+		t.Errorf("Unexpected error message: %v", err)
+	}
+
+	summary := strings.Join(logger.warnMessages, "\n")
+	for _, want := range []string{"CERTIFICATE SUMMARY", "cert-00      issued", "cert-01      failed     failed to initialize certificate cert-01", "2 issued, 1 failed"} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("Expected %q in summary, got:\n%s", want, summary)
+		}
+	}
+}
+
+func TestProcessAutoMode_PerCertTimeout(t *testing.T) {
+	cfg := createParallelTestConfig(t.TempDir(), 3, 1)
+	cfg.PerCertTimeout = 50 * time.Millisecond
+	cm, err := NewCertificateManager(cfg, &mockLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	cm.SetLegoRunner(func(ctx context.Context, cfg *manager.Config, store interface{}, action, certName string, domains []string, keyType string) error {
+		if certName == "cert-01" {
+			// Stuck like a DNS propagation that never completes
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return mockLegoRunner(ctx, cfg, store, action, certName, domains, keyType)
+	})
+
+	err = cm.ProcessAutoMode(context.Background())
+	var procErr *ProcessingError
+	if !errors.As(err, &procErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected processing error wrapping the timeout, got %v", err)
+	}
+	failures := procErr.Failures()
+	if len(failures) != 1 || failures[0].Name != "cert-01" || !strings.Contains(failures[0].Err.Error(), "exceeded per_cert_timeout of 50ms") {
+		t.Errorf("Expected only cert-01 to time out, got %+v", failures)
+	}
+	for _, r := range procErr.Results {
+		if r.Name != "cert-01" && r.Outcome != OutcomeIssued {
+			t.Errorf("Expected %s to be issued after the timeout, got %s", r.Name, r.Outcome)
+		}
+	}
+}
+
+func TestProcessAutoMode_RunReport(t *testing.T) {
+	dir := t.TempDir()
+	cfg := createParallelTestConfig(dir, 2, 1)
+	cfg.RunReport = filepath.Join(dir, "reports", "last-run.json")
+	cm, err := NewCertificateManager(cfg, &mockLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	cm.SetLegoRunner(failingRunner(map[string]error{"cert-01": errors.New("boom")}))
+	_ = cm.ProcessAutoMode(context.Background())
+
+	data, err := os.ReadFile(cfg.RunReport)
+	if err != nil {
+		t.Fatalf("Expected a run report: %v", err)
+	}
+	var report RunReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("Invalid run report: %v\n%s", err, data)
+	}
+	if report.Mode != "auto" || report.ExitCode != ExitPartialFailure || !strings.Contains(report.Error, "cert-01") {
+		t.Errorf("Unexpected run fields: %+v", report)
+	}
+	if len(report.Certificates) != 2 {
+		t.Fatalf("Expected 2 certificates, got %+v", report.Certificates)
+	}
+	for _, c := range report.Certificates {
+		if c.Action != "init" || len(c.Domains) == 0 || c.OldExpiry != nil {
+			t.Errorf("Unexpected entry for %s: %+v", c.Name, c)
+		}
+		if c.Name == "cert-01" && (c.Outcome != OutcomeFailed || !strings.Contains(c.Error, "boom")) {
+			t.Errorf("Expected the failure of cert-01 to be reported, got %+v", c)
+		}
+	}
+}
+
+func TestProcessAutoMode_SummaryAllOK(t *testing.T) {
+	logger := &mockLogger{}
+	cm, err := NewCertificateManager(createParallelTestConfig(t.TempDir(), 2, 1), logger)
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	cm.SetLegoRunner(mockLegoRunner)
+
+	if err := cm.ProcessAutoMode(context.Background()); err != nil {
+		t.Fatalf("ProcessAutoMode failed: %v", err)
+	}
+	if !strings.Contains(strings.Join(logger.infoMessages, "\n"), "2 issued") {
+		t.Error("Expected summary totals at info level")
+	}
+	for _, msg := range logger.warnMessages {
+		if strings.Contains(msg, "SUMMARY") {
+			t.Error("Expected no warning level summary without failures")
+		}
+	}
+}
+
+func TestLogSummary_Quiet(t *testing.T) {
+	logger := &mockLogger{}
+	cm := &CertificateManager{logger: logger, quiet: true}
+	cm.logSummary([]CertResult{
+		{Name: "web", Outcome: OutcomeSkipped},
+		{Name: "mail", Outcome: OutcomeSkipped},
+	})
+	if len(logger.infoMessages)+len(logger.warnMessages) != 0 {
+		t.Errorf("Expected no output when all certificates were skipped, got %v %v", logger.infoMessages, logger.warnMessages)
+	}
+
+	cm.logSummary([]CertResult{
+		{Name: "web", Outcome: OutcomeSkipped},
+		{Name: "mail", Outcome: OutcomeRenewed},
+	})
+	summary := strings.Join(logger.warnMessages, "\n")
+	if !strings.Contains(summary, "mail") || !strings.Contains(summary, "1 renewed, 1 skipped") {
+		t.Errorf("Expected a warning level summary of the renewal, got:\n%s", summary)
+	}
+	if strings.Contains(summary, "web ") {
+		t.Errorf("Expected skipped certificates to be left out of the table, got:\n%s", summary)
+	}
+}
+
+func TestProcessAutoMode_DNSSetupOnly(t *testing.T) {
+	cm, err := NewCertificateManager(createParallelTestConfig(t.TempDir(), 2, 1), &mockLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	cm.SetLegoRunner(failingRunner(map[string]error{"cert-00": manager.ErrDNSSetupNeeded}))
+
+	err = cm.ProcessAutoMode(context.Background())
+	if !errors.Is(err, manager.ErrDNSSetupNeeded) {
+		t.Fatalf("Expected ErrDNSSetupNeeded, got %v", err)
+	}
+	var procErr *ProcessingError
+	if errors.As(err, &procErr) {
+		t.Error("Expected pending DNS setup alone not to count as failure")
+	}
+}
+
+func TestProcessManualMode_StopsOnFirstError(t *testing.T) {
+	cm, err := NewCertificateManager(createTestConfig(t.TempDir()), &mockLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+	boom := errors.New("boom")
+	calls := 0
+	cm.SetLegoRunner(func(ctx context.Context, cfg *manager.Config, store interface{}, action, certName string, domains []string, keyType string) error {
+		calls++
+		return boom
+	})
+
+	err = cm.ProcessManualMode(context.Background(), []string{"one@one.example.com", "two@two.example.com"})
+	if !errors.Is(err, boom) || calls != 1 {
+		t.Errorf("Expected manual mode to stop after the first failure, got %v after %d calls", err, calls)
+	}
+	var procErr *ProcessingError
+	if errors.As(err, &procErr) {
+		t.Error("Expected manual mode to return the plain error")
+	}
+}
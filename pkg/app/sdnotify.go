@@ -0,0 +1,109 @@
+package app
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// systemd notification states, see sd_notify(3)
+const (
+	sdReady     = "READY=1"
+	sdStopping  = "STOPPING=1"
+	sdWatchdog  = "WATCHDOG=1"
+	sdStatusFmt = "STATUS="
+)
+
+// sdNotify sends state to the service manager named by NOTIFY_SOCKET. It
+// reports false without an error when not started by systemd with
+// Type=notify, so callers need not check for that themselves.
+func sdNotify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// A leading @ names a socket in the abstract namespace
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// sdWatchdogInterval returns how often systemd expects a watchdog ping from
+// this process (WatchdogSec= of the unit), 0 if the watchdog is off
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// daemonWatchdog pings the systemd watchdog as long as the daemon makes
+// progress. A certificate run taking longer than maxRun counts as hung: the
+// pings stop and systemd restarts the service once WatchdogSec= passes.
+type daemonWatchdog struct {
+	maxRun time.Duration
+	now    func() time.Time
+
+	mu       sync.Mutex
+	runStart time.Time // Start of the current run, zero between runs
+}
+
+// startRun and endRun bracket one certificate run
+func (w *daemonWatchdog) startRun() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.runStart = w.now()
+}
+
+func (w *daemonWatchdog) endRun() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.runStart = time.Time{}
+}
+
+// healthy reports whether no run has been going on for longer than maxRun
+func (w *daemonWatchdog) healthy() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.runStart.IsZero() || w.now().Sub(w.runStart) <= w.maxRun
+}
+
+// run pings every period until done is closed, skipping the pings while a
+// run hangs. logHang is called once per hung run.
+func (w *daemonWatchdog) run(period time.Duration, done <-chan struct{}, logHang func()) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	reported := false
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if !w.healthy() {
+			if !reported {
+				reported = true
+				logHang()
+			}
+			continue
+		}
+		reported = false
+		_, _ = sdNotify(sdWatchdog)
+	}
+}
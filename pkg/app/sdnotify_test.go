@@ -0,0 +1,180 @@
+package app
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// listenNotify points NOTIFY_SOCKET at a new socket and returns it. The
+// directory is not t.TempDir() as socket paths are limited to 108 bytes.
+func listenNotify(t *testing.T) *net.UnixConn {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	path := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen on %s: %v", path, err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+// readNotify returns the next message sent to the socket
+func readNotify(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	buf := make([]byte, 4096)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("No notification received: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestSdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if notified, err := sdNotify(sdReady); notified || err != nil {
+		t.Errorf("Expected no notification without NOTIFY_SOCKET, got %v, %v", notified, err)
+	}
+
+	conn := listenNotify(t)
+	if notified, err := sdNotify(sdReady); !notified || err != nil {
+		t.Fatalf("Expected a notification, got %v, %v", notified, err)
+	}
+	if msg := readNotify(t, conn); msg != sdReady {
+		t.Errorf("Expected %q, got %q", sdReady, msg)
+	}
+
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing"))
+	if _, err := sdNotify(sdReady); err == nil {
+		t.Error("Expected an error for a socket nobody listens on")
+	}
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	t.Setenv("WATCHDOG_PID", "")
+	if d := sdWatchdogInterval(); d != 0 {
+		t.Errorf("Expected no watchdog, got %s", d)
+	}
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	if d := sdWatchdogInterval(); d != 30*time.Second {
+		t.Errorf("Expected 30s, got %s", d)
+	}
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if d := sdWatchdogInterval(); d != 30*time.Second {
+		t.Errorf("Expected 30s for this process, got %s", d)
+	}
+	// The watchdog is meant for another process, e.g. the parent shell
+	t.Setenv("WATCHDOG_PID", "1")
+	if d := sdWatchdogInterval(); d != 0 {
+		t.Errorf("Expected no watchdog for another process, got %s", d)
+	}
+}
+
+func TestDaemonWatchdog_Hang(t *testing.T) {
+	now := time.Now()
+	w := &daemonWatchdog{maxRun: time.Hour, now: func() time.Time { return now }}
+	if !w.healthy() {
+		t.Error("Expected a healthy daemon between runs")
+	}
+	w.startRun()
+	now = now.Add(59 * time.Minute)
+	if !w.healthy() {
+		t.Error("Expected a run within maxRun to be healthy")
+	}
+	now = now.Add(2 * time.Minute)
+	if w.healthy() {
+		t.Error("Expected a run longer than maxRun to count as hung")
+	}
+	w.endRun()
+	if !w.healthy() {
+		t.Error("Expected a healthy daemon after the run ended")
+	}
+}
+
+func TestDaemonWatchdog_Run(t *testing.T) {
+	conn := listenNotify(t)
+	var hung atomic.Bool
+	w := &daemonWatchdog{maxRun: time.Hour, now: func() time.Time {
+		if hung.Load() {
+			return time.Now().Add(2 * time.Hour)
+		}
+		return time.Now()
+	}}
+	done := make(chan struct{})
+	defer close(done)
+	var hangs int32
+	go w.run(5*time.Millisecond, done, func() { atomic.AddInt32(&hangs, 1) })
+
+	if msg := readNotify(t, conn); msg != sdWatchdog {
+		t.Fatalf("Expected %q, got %q", sdWatchdog, msg)
+	}
+
+	w.startRun()
+	hung.Store(true)
+	time.Sleep(50 * time.Millisecond)
+	if c := atomic.LoadInt32(&hangs); c != 1 {
+		t.Errorf("Expected the hang to be logged once, got %d", c)
+	}
+	// Drain the pings sent before the hang, then expect silence
+	_ = conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	buf := make([]byte, 64)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			break
+		}
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, err := conn.Read(buf); err == nil {
+		t.Errorf("Expected no pings while the run hangs, got %q", buf[:n])
+	}
+}
+
+func TestRunDaemon_NotifiesSystemd(t *testing.T) {
+	conn := listenNotify(t)
+	tmpDir := t.TempDir()
+	cfg := createTestConfig(tmpDir)
+	cm, err := NewCertificateManager(cfg, &syncLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create certificate manager: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cm.SetLegoRunner(mockLegoRunner)
+
+	app := NewApplication("test")
+	app.logger = &syncLogger{}
+	app.config.DaemonInterval = time.Hour
+
+	done := make(chan error, 1)
+	go func() { done <- app.RunDaemon(ctx, cm, nil) }()
+
+	if msg := readNotify(t, conn); !strings.HasPrefix(msg, sdReady+"\n"+sdStatusFmt) {
+		t.Errorf("Expected READY with a status, got %q", msg)
+	}
+	if msg := readNotify(t, conn); !strings.HasPrefix(msg, sdStatusFmt+"Last run completed") {
+		t.Errorf("Expected the status of the first run, got %q", msg)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Expected clean daemon shutdown, got %v", err)
+	}
+	if msg := readNotify(t, conn); msg != sdStopping {
+		t.Errorf("Expected %q, got %q", sdStopping, msg)
+	}
+}
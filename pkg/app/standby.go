@@ -0,0 +1,48 @@
+package app
+
+import (
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+// holdsLease reports whether this manager processes the certificates in the
+// run starting now. Without a standby section it always does; with one, it
+// takes or renews the lease and stands by while another manager holds it.
+// Taking the lease over from another manager first fetches what that one
+// stored in the storage backend.
+func (app *Application) holdsLease(cfg *manager.Config, status *StatusServer) (bool, error) {
+	if cfg.Standby == nil {
+		return true, nil
+	}
+	interval := cfg.Standby.Interval
+	if interval <= 0 {
+		interval = app.config.DaemonInterval
+	}
+	if interval <= 0 {
+		interval = DefaultDaemonInterval
+	}
+
+	result, err := manager.AcquireLease(cfg.Standby, interval, time.Now())
+	if err != nil {
+		return false, common.WrapError(err, common.ErrorTypeStorage, "acquire lease",
+			"Failed to check the standby lease, certificates are not processed without it").
+			AddContext("lease_file", cfg.Standby.LeaseFile).
+			AddSuggestion("Check that the shared storage holding the lease file is mounted and writable")
+	}
+	if !result.Held {
+		app.logger.Infof("Standing by, %s holds the lease until %s", result.Lease.Holder, result.Lease.Expires.Local().Format(time.RFC3339))
+		status.RecordStandby(result.Lease.Holder)
+		return false, nil
+	}
+	if result.TookOver {
+		app.logger.Warnf("Taking over from %s, which last renewed the lease at %s", result.Previous.Holder, result.Previous.Renewed.Local().Format(time.RFC3339))
+		if err := app.SyncStorage(cfg); err != nil {
+			return false, err
+		}
+	} else {
+		app.logger.Debugf("Holding the standby lease %s until %s", cfg.Standby.LeaseFile, result.Lease.Expires.Local().Format(time.RFC3339))
+	}
+	return true, nil
+}
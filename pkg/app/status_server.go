@@ -0,0 +1,313 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+	"github.com/oetiker/go-acme-dns-manager/pkg/metrics"
+)
+
+// statusShutdownTimeout limits how long Close waits for running requests
+const statusShutdownTimeout = 5 * time.Second
+
+// StatusServer serves the state of the manager over HTTP for container probes,
+// load balancers and dashboards. It is started with status_listen in auto and
+// daemon mode:
+//
+//	/healthz  200 while the process is running
+//	/readyz   200 once the first certificate run has finished or the manager
+//	          stands by for another lease holder, 503 before
+//	/certs    JSON list of the managed certificates with expiry and last action,
+//	          from the index of the sqlite storage backend if it is used
+type StatusServer struct {
+	cfg    *manager.Config
+	logger common.LoggerInterface
+	now    func() time.Time
+
+	server   *http.Server
+	listener net.Listener
+
+	mu      sync.RWMutex
+	ready   bool
+	lastRun time.Time
+	lastErr error
+	actions map[string]certAction
+	// leaseHolder is the manager holding the standby lease while this one stands by
+	leaseHolder string
+}
+
+// certAction is the outcome of the last run for one certificate
+type certAction struct {
+	Outcome string
+	At      time.Time
+	Err     error
+}
+
+// CertStatus is one entry of the /certs response
+type CertStatus struct {
+	Name          string     `json:"name"`
+	Configured    bool       `json:"configured"`
+	Present       bool       `json:"present"`
+	Domains       int        `json:"domains"`
+	NotAfter      *time.Time `json:"not_after,omitempty"`
+	ExpirySeconds float64    `json:"expiry_seconds"`
+	Expired       bool       `json:"expired"`
+	LastAction    string     `json:"last_action,omitempty"` // Outcome of the last run, see Outcome*
+	LastActionAt  *time.Time `json:"last_action_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	OCSPStatus    string     `json:"ocsp_status,omitempty"` // Result of the last OCSP check, see manager.OCSPStatus*
+}
+
+// readyStatus is the /readyz response
+type readyStatus struct {
+	Ready        bool       `json:"ready"`
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastRunError string     `json:"last_run_error,omitempty"`
+	Standby      bool       `json:"standby,omitempty"`      // Another manager holds the standby lease
+	LeaseHolder  string     `json:"lease_holder,omitempty"` // Name of that manager
+}
+
+// NewStatusServer creates a status server for the configuration, Start makes it listen
+func NewStatusServer(cfg *manager.Config, logger common.LoggerInterface) *StatusServer {
+	return &StatusServer{
+		cfg:     cfg,
+		logger:  logger,
+		now:     time.Now,
+		actions: make(map[string]certAction),
+	}
+}
+
+// Handler returns the HTTP handler serving the status endpoints
+func (s *StatusServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+	mux.HandleFunc("GET /certs", s.handleCerts)
+	return mux
+}
+
+// Start listens on addr and serves the status endpoints in the background
+func (s *StatusServer) Start(addr string) error {
+	listener, err := listenStatus(addr)
+	if err != nil {
+		return err
+	}
+	s.Serve(listener)
+	return nil
+}
+
+// listenStatus opens the listening socket of the status server
+func listenStatus(addr string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, common.WrapError(err, common.ErrorTypeNetwork, "start status server",
+			"Failed to listen on status_listen address").
+			AddContext("status_listen", addr).
+			AddSuggestion("Check that the address is valid and the port is not in use")
+	}
+	return listener, nil
+}
+
+// Serve answers requests on a listener opened before, e.g. while the
+// process still had the privileges for a port below 1024
+func (s *StatusServer) Serve(listener net.Listener) {
+	s.listener = listener
+	s.server = &http.Server{Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Errorf("Status server stopped: %v", err)
+		}
+	}()
+	s.logger.Infof("Status server listening on http://%s", listener.Addr())
+}
+
+// Addr returns the address the server listens on, useful with port 0
+func (s *StatusServer) Addr() string {
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// Close stops the server, waiting briefly for running requests
+func (s *StatusServer) Close() error {
+	if s == nil || s.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), statusShutdownTimeout)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+// RecordRun stores the results of a certificate run and marks the server ready
+func (s *StatusServer) RecordRun(results []CertResult, err error) {
+	if s == nil {
+		return
+	}
+	at := s.now().UTC()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ready = true
+	s.lastRun = at
+	s.lastErr = err
+	s.leaseHolder = ""
+	for _, r := range results {
+		s.actions[r.Name] = certAction{Outcome: r.Outcome, At: at, Err: r.Err}
+	}
+}
+
+// RecordStandby marks the server ready while holder processes the certificates
+func (s *StatusServer) RecordStandby(holder string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ready = true
+	s.leaseHolder = holder
+}
+
+func (s *StatusServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (s *StatusServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	status := readyStatus{Ready: s.ready, Standby: s.leaseHolder != "", LeaseHolder: s.leaseHolder}
+	if s.ready && !s.lastRun.IsZero() {
+		lastRun := s.lastRun
+		status.LastRun = &lastRun
+		if s.lastErr != nil {
+			status.LastRunError = s.lastErr.Error()
+		}
+	}
+	s.mu.RUnlock()
+
+	code := http.StatusOK
+	if !status.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, status)
+}
+
+func (s *StatusServer) handleCerts(w http.ResponseWriter, r *http.Request) {
+	now := s.now()
+	certs, err := s.certStatuses(now)
+	if err != nil {
+		http.Error(w, "collecting certificate state: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.mu.RLock()
+	for i, status := range certs {
+		if action, ok := s.actions[status.Name]; ok {
+			at := action.At
+			certs[i].LastAction = action.Outcome
+			certs[i].LastActionAt = &at
+			if action.Err != nil {
+				certs[i].LastError = action.Err.Error()
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"generated_at": now.UTC(),
+		"certificates": certs,
+	})
+}
+
+// certStatuses lists the configured and stored certificates. With the sqlite
+// backend they come from its index, otherwise every certificate file of the
+// storage directory is read.
+func (s *StatusServer) certStatuses(now time.Time) ([]CertStatus, error) {
+	indexed, ok, err := manager.IndexedCertificates(s.cfg)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return indexedCertStatuses(s.cfg, indexed, now)
+	}
+
+	snap, err := metrics.Collect(s.cfg, now)
+	if err != nil {
+		return nil, err
+	}
+	certs := make([]CertStatus, 0, len(snap.Certificates))
+	for _, m := range snap.Certificates {
+		certs = append(certs, CertStatus{
+			Name:          m.Name,
+			Configured:    m.Configured,
+			Present:       m.Present,
+			Domains:       m.Domains,
+			NotAfter:      m.NotAfter,
+			ExpirySeconds: m.ExpirySeconds,
+			Expired:       m.Expired,
+			OCSPStatus:    m.OCSPStatus,
+		})
+	}
+	return certs, nil
+}
+
+// indexedCertStatuses builds the /certs entries from the certificates the
+// sqlite backend indexed and the auto_domains certificates
+func indexedCertStatuses(cfg *manager.Config, indexed []manager.IndexedCertificate, now time.Time) ([]CertStatus, error) {
+	state, err := manager.LoadState(cfg.CertStoragePath)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]*CertStatus)
+	get := func(name string) *CertStatus {
+		if status, ok := byName[name]; ok {
+			return status
+		}
+		status := &CertStatus{Name: name}
+		byName[name] = status
+		return status
+	}
+	if cfg.AutoDomains != nil {
+		for name, certCfg := range cfg.AutoDomains.Certs {
+			status := get(name)
+			status.Configured = true
+			status.Domains = len(certCfg.Domains)
+		}
+	}
+	for _, cert := range indexed {
+		status := get(cert.Name)
+		notAfter := cert.NotAfter
+		status.Present = true
+		status.Domains = len(cert.Domains)
+		status.NotAfter = &notAfter
+		status.ExpirySeconds = notAfter.Sub(now).Seconds()
+		status.Expired = now.After(notAfter)
+		// A check before the current certificate was issued was of an earlier one
+		if ocsp := state.Certificates[cert.Name].OCSP; ocsp != nil && !ocsp.CheckedAt.Before(cert.NotBefore) {
+			status.OCSPStatus = ocsp.Status
+		}
+	}
+
+	certs := make([]CertStatus, 0, len(byName))
+	for _, status := range byName {
+		certs = append(certs, *status)
+	}
+	sort.Slice(certs, func(i, j int) bool { return certs[i].Name < certs[j].Name })
+	return certs, nil
+}
+
+// writeJSON sends v as indented JSON
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
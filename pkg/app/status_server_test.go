@@ -0,0 +1,181 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+func TestStatusServer_Endpoints(t *testing.T) {
+	tmpDir := t.TempDir()
+	status := NewStatusServer(createTestConfig(tmpDir), &mockLogger{})
+	status.now = func() time.Time { return time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC) }
+	server := httptest.NewServer(status.Handler())
+	defer server.Close()
+
+	get := func(path string, v interface{}) int {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		if v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatalf("Decoding %s failed: %v", path, err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	if code := get("/healthz", nil); code != http.StatusOK {
+		t.Errorf("Expected /healthz 200, got %d", code)
+	}
+	var ready readyStatus
+	if code := get("/readyz", &ready); code != http.StatusServiceUnavailable || ready.Ready {
+		t.Errorf("Expected /readyz 503 before the first run, got %d %+v", code, ready)
+	}
+
+	boom := errors.New("acme server unavailable")
+	status.RecordRun([]CertResult{
+		{Name: "example-cert", Outcome: OutcomeIssued},
+		{Name: "wildcard-cert", Outcome: OutcomeFailed, Err: boom},
+	}, &ProcessingError{Results: []CertResult{{Name: "wildcard-cert", Outcome: OutcomeFailed, Err: boom}}})
+
+	if code := get("/readyz", &ready); code != http.StatusOK || !ready.Ready || ready.LastRun == nil {
+		t.Errorf("Expected /readyz 200 after a run, got %d %+v", code, ready)
+	}
+	if ready.LastRunError == "" {
+		t.Error("Expected the error of the last run in /readyz")
+	}
+
+	var certs struct {
+		Certificates []CertStatus `json:"certificates"`
+	}
+	if code := get("/certs", &certs); code != http.StatusOK {
+		t.Fatalf("Expected /certs 200, got %d", code)
+	}
+	if len(certs.Certificates) != 2 {
+		t.Fatalf("Expected 2 certificates, got %+v", certs.Certificates)
+	}
+	issued, failed := certs.Certificates[0], certs.Certificates[1]
+	if issued.Name != "example-cert" || !issued.Configured || issued.LastAction != OutcomeIssued || issued.LastActionAt == nil {
+		t.Errorf("Unexpected status of example-cert: %+v", issued)
+	}
+	if failed.LastAction != OutcomeFailed || failed.LastError != boom.Error() {
+		t.Errorf("Unexpected status of wildcard-cert: %+v", failed)
+	}
+
+	resp, err := http.Post(server.URL+"/certs", "application/json", nil)
+	if err != nil {
+		t.Fatalf("POST /certs failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected POST /certs to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestIndexedCertStatuses(t *testing.T) {
+	config := createTestConfig(t.TempDir())
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	indexed := []manager.IndexedCertificate{
+		{Name: "example-cert", Domains: []string{"example.com"}, NotBefore: now.Add(-24 * time.Hour), NotAfter: now.Add(time.Hour)},
+		{Name: "retired", Domains: []string{"old.example.com"}, NotAfter: now.Add(-time.Hour)},
+	}
+	certs, err := indexedCertStatuses(config, indexed, now)
+	if err != nil {
+		t.Fatalf("indexedCertStatuses failed: %v", err)
+	}
+	byName := make(map[string]CertStatus)
+	for _, status := range certs {
+		byName[status.Name] = status
+	}
+	if got := byName["example-cert"]; !got.Configured || !got.Present || got.ExpirySeconds != 3600 || got.Expired {
+		t.Errorf("Unexpected status of example-cert: %+v", got)
+	}
+	if got := byName["retired"]; got.Configured || !got.Present || !got.Expired {
+		t.Errorf("Unexpected status of retired: %+v", got)
+	}
+	if got, ok := byName["wildcard-cert"]; !ok || got.Present {
+		t.Errorf("Expected the configured wildcard-cert as not present, got %+v", got)
+	}
+}
+
+func TestStatusServer_StartClose(t *testing.T) {
+	status := NewStatusServer(createTestConfig(t.TempDir()), &mockLogger{})
+	if err := status.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	resp, err := http.Get("http://" + status.Addr() + "/healthz")
+	if err != nil {
+		t.Fatalf("GET /healthz failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "ok\n" {
+		t.Errorf("Expected body ok, got %q", body)
+	}
+	if err := status.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+
+	// The port is taken by a second server on the same address
+	other := NewStatusServer(createTestConfig(t.TempDir()), &mockLogger{})
+	if err := other.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer func() { _ = other.Close() }()
+	err = NewStatusServer(createTestConfig(t.TempDir()), &mockLogger{}).Start(other.Addr())
+	if appErr := common.GetApplicationError(err); appErr == nil || appErr.Type != common.ErrorTypeNetwork {
+		t.Errorf("Expected network error for a busy port, got %v", err)
+	}
+
+	// Recording on a disabled server is a no-op
+	var disabled *StatusServer
+	disabled.RecordRun(nil, nil)
+	if err := disabled.Close(); err != nil {
+		t.Errorf("Expected nil server to close cleanly, got %v", err)
+	}
+}
+
+// TestApplication_DropPrivilegesOpensStatusPort tests that the status port is
+// opened before switching users and served from that listener
+func TestApplication_DropPrivilegesOpensStatusPort(t *testing.T) {
+	cfg := createTestConfig(t.TempDir())
+	cfg.StatusListen = "127.0.0.1:0"
+	// Already running as the configured user, nothing to switch
+	cfg.RunAsUser = strconv.Itoa(os.Geteuid())
+	cfg.RunAsGroup = strconv.Itoa(os.Getegid())
+
+	app := NewApplication("test-version")
+	app.logger = &mockLogger{}
+	app.config.AutoMode = true
+	listener, err := app.dropPrivileges(cfg)
+	if err != nil || listener == nil {
+		t.Fatalf("Expected an open status listener, got %v, %v", listener, err)
+	}
+	status := NewStatusServer(cfg, &mockLogger{})
+	status.Serve(listener)
+	defer func() { _ = status.Close() }()
+	resp, err := http.Get("http://" + status.Addr() + "/healthz")
+	if err != nil {
+		t.Fatalf("GET /healthz failed: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	// Without run_as_user the status server opens the port itself
+	cfg.RunAsUser, cfg.RunAsGroup = "", ""
+	if listener, err := app.dropPrivileges(cfg); err != nil || listener != nil {
+		t.Errorf("Expected no listener without run_as_user, got %v, %v", listener, err)
+	}
+}
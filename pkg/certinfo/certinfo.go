@@ -0,0 +1,341 @@
+// Package certinfo reads and inspects the certificate artifacts written by
+// go-acme-dns-manager. It is the single place that knows the storage layout and
+// how to interpret the stored files, so other tools (deployment agents, monitoring
+// scripts) can read certificates exactly the way the manager does.
+//
+// Storage layout below the configured cert_storage_path:
+//
+//	certificates/<name>.crt         leaf certificate followed by the chain (PEM)
+//	certificates/<name>.key         private key (PEM)
+//	certificates/<name>.issuer.crt  issuer certificate (PEM, optional)
+//	certificates/<name>.json        Lego certificate resource metadata
+//
+// Optional output formats, written only when configured for the certificate:
+//
+//	certificates/<name>.pfx           PKCS#12 bundle of key, certificate and chain
+//	certificates/<name>.combined.pem  private key followed by certificate and chain (HAProxy)
+//	certificates/<name>.fullchain.pem certificate and chain without the key
+//	certificates/<name>.tlsa          TLSA records for DANE (zone file lines)
+//	certificates/<name>.tlsa.json     TLSA records for DANE (JSON)
+package certinfo
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CertificatesDirName is the directory below the storage path holding certificate files
+const CertificatesDirName = "certificates"
+
+// ErrNoCertificate is returned when PEM data contains no certificate block
+var ErrNoCertificate = errors.New("no PEM certificate found")
+
+// Paths holds the file locations of one stored certificate
+type Paths struct {
+	Certificate string
+	PrivateKey  string
+	Issuer      string
+	Metadata    string
+
+	// Optional output formats
+	PFX         string
+	CombinedPEM string
+	FullChain   string
+
+	// DANE records written when tlsa is configured
+	TLSA     string
+	TLSAJSON string
+}
+
+// CertificatesDir returns the directory holding all certificate files
+func CertificatesDir(storagePath string) string {
+	return filepath.Join(storagePath, CertificatesDirName)
+}
+
+// PathsFor returns the file locations for the named certificate
+func PathsFor(storagePath, certName string) Paths {
+	dir := CertificatesDir(storagePath)
+	return Paths{
+		Certificate: filepath.Join(dir, certName+".crt"),
+		PrivateKey:  filepath.Join(dir, certName+".key"),
+		Issuer:      filepath.Join(dir, certName+".issuer.crt"),
+		Metadata:    filepath.Join(dir, certName+".json"),
+		PFX:         filepath.Join(dir, certName+".pfx"),
+		CombinedPEM: filepath.Join(dir, certName+".combined.pem"),
+		FullChain:   filepath.Join(dir, certName+".fullchain.pem"),
+		TLSA:        filepath.Join(dir, certName+".tlsa"),
+		TLSAJSON:    filepath.Join(dir, certName+".tlsa.json"),
+	}
+}
+
+// Info describes a parsed leaf certificate
+type Info struct {
+	CommonName   string
+	DNSNames     []string
+	NotBefore    time.Time
+	NotAfter     time.Time
+	Issuer       string
+	SerialNumber string
+	KeyAlgorithm string
+
+	// Certificate is the parsed leaf certificate for callers needing more detail
+	Certificate *x509.Certificate
+}
+
+// Parse decodes the first certificate in PEM data, which is the leaf in a bundle
+func Parse(certPEM []byte) (*Info, error) {
+	for {
+		var block *pem.Block
+		block, certPEM = pem.Decode(certPEM)
+		if block == nil {
+			return nil, ErrNoCertificate
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing certificate: %w", err)
+		}
+		return FromX509(cert), nil
+	}
+}
+
+// FromX509 builds an Info from an already parsed certificate
+func FromX509(cert *x509.Certificate) *Info {
+	return &Info{
+		CommonName:   cert.Subject.CommonName,
+		DNSNames:     cert.DNSNames,
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+		Issuer:       cert.Issuer.CommonName,
+		SerialNumber: formatSerial(cert.SerialNumber),
+		KeyAlgorithm: KeyAlgorithm(cert.PublicKey),
+		Certificate:  cert,
+	}
+}
+
+// Load reads and parses a PEM certificate file
+func Load(path string) (*Info, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading certificate file %s: %w", path, err)
+	}
+	info, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("certificate file %s: %w", path, err)
+	}
+	return info, nil
+}
+
+// TimeLeft returns the remaining validity relative to now
+func (i *Info) TimeLeft(now time.Time) time.Duration {
+	return i.NotAfter.Sub(now)
+}
+
+// Lifetime returns the validity period of the certificate from NotBefore to NotAfter
+func (i *Info) Lifetime() time.Duration {
+	return i.NotAfter.Sub(i.NotBefore)
+}
+
+// IsExpired reports whether the certificate is no longer valid at the given time
+func (i *Info) IsExpired(now time.Time) bool {
+	return !now.Before(i.NotAfter)
+}
+
+// CompareDomains compares the certificate SANs with the requested domains.
+// It returns the requested domains missing from the certificate and the
+// certificate domains that were not requested.
+func (i *Info) CompareDomains(requested []string) (missing, extra []string) {
+	return CompareDomains(i.DNSNames, requested)
+}
+
+// CompareDomains compares two domain lists, see Info.CompareDomains
+func CompareDomains(certDomains, requested []string) (missing, extra []string) {
+	have := make(map[string]bool, len(certDomains))
+	for _, d := range certDomains {
+		have[d] = true
+	}
+	want := make(map[string]bool, len(requested))
+	for _, d := range requested {
+		want[d] = true
+	}
+
+	for _, d := range requested {
+		if !have[d] {
+			missing = append(missing, d)
+		}
+	}
+	for _, d := range certDomains {
+		if !want[d] {
+			extra = append(extra, d)
+		}
+	}
+	return missing, extra
+}
+
+// KeyAlgorithm returns a short name for the public key type, matching the
+// key_type names used in the configuration where possible (e.g. rsa2048, ec256)
+func KeyAlgorithm(pub crypto.PublicKey) string {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("rsa%d", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ec%d", k.Curve.Params().BitSize)
+	case ed25519.PublicKey:
+		return "ed25519"
+	default:
+		return "unknown"
+	}
+}
+
+// KeyMatches reports whether the PEM private key belongs to the certificate
+func KeyMatches(cert *x509.Certificate, keyPEM []byte) (bool, error) {
+	key, err := ParsePrivateKey(keyPEM)
+	if err != nil {
+		return false, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return false, fmt.Errorf("private key of type %T cannot be compared", key)
+	}
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return false, fmt.Errorf("public key of type %T cannot be compared", signer.Public())
+	}
+	return pub.Equal(cert.PublicKey), nil
+}
+
+// ParsePrivateKey decodes a PEM encoded PKCS#1, PKCS#8 or SEC1 private key
+func ParsePrivateKey(keyPEM []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+}
+
+// Artifact describes the full set of stored files for one certificate
+type Artifact struct {
+	Name  string
+	Paths Paths
+	Info  *Info // nil if the certificate file is missing or unreadable
+
+	HasKey      bool
+	HasIssuer   bool
+	HasMetadata bool
+	// KeyMatches is true when the stored private key belongs to the certificate
+	KeyMatches bool
+}
+
+// Complete reports whether certificate, key and metadata are all present
+func (a *Artifact) Complete() bool {
+	return a.Info != nil && a.HasKey && a.HasMetadata
+}
+
+// LoadArtifact inspects all stored files of the named certificate.
+// Missing optional files are reported through the Has* fields; an error is
+// only returned if the certificate file exists but cannot be parsed.
+func LoadArtifact(storagePath, certName string) (*Artifact, error) {
+	return LoadArtifactWithKey(storagePath, certName, PathsFor(storagePath, certName).PrivateKey)
+}
+
+// LoadArtifactWithKey is LoadArtifact for a certificate whose private key is
+// kept in keyFile, e.g. outside the storage path
+func LoadArtifactWithKey(storagePath, certName, keyFile string) (*Artifact, error) {
+	a := &Artifact{Name: certName, Paths: PathsFor(storagePath, certName)}
+	a.Paths.PrivateKey = keyFile
+	a.HasIssuer = fileExists(a.Paths.Issuer)
+	a.HasMetadata = fileExists(a.Paths.Metadata)
+
+	keyPEM, keyErr := os.ReadFile(a.Paths.PrivateKey)
+	a.HasKey = keyErr == nil
+
+	if !fileExists(a.Paths.Certificate) {
+		return a, nil
+	}
+
+	info, err := Load(a.Paths.Certificate)
+	if err != nil {
+		return a, err
+	}
+	a.Info = info
+
+	if a.HasKey {
+		match, err := KeyMatches(info.Certificate, keyPEM)
+		if err != nil {
+			return a, fmt.Errorf("checking private key %s: %w", a.Paths.PrivateKey, err)
+		}
+		a.KeyMatches = match
+	}
+
+	return a, nil
+}
+
+// List returns the names of all certificates found in the storage path, sorted.
+// A certificate is listed if its .crt, .key or .json file exists.
+func List(storagePath string) ([]string, error) {
+	entries, err := os.ReadDir(CertificatesDir(storagePath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading certificates directory: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		name := e.Name()
+		switch {
+		case strings.HasSuffix(name, ".issuer.crt"):
+			continue
+		case strings.HasSuffix(name, ".crt"):
+			seen[strings.TrimSuffix(name, ".crt")] = true
+		case strings.HasSuffix(name, ".key"):
+			seen[strings.TrimSuffix(name, ".key")] = true
+		case strings.HasSuffix(name, ".json"):
+			seen[strings.TrimSuffix(name, ".json")] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func formatSerial(serial *big.Int) string {
+	if serial == nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", serial)
+}
@@ -0,0 +1,168 @@
+package certinfo
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// generateCert creates a self-signed certificate and its EC private key in PEM format
+func generateCert(t *testing.T, domains []string, validFor time.Duration) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(0xabc123),
+		Subject:      pkix.Name{CommonName: domains[0]},
+		Issuer:       pkix.Name{CommonName: domains[0]},
+		DNSNames:     domains,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validFor),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
+
+func writeArtifact(t *testing.T, storage, name string, certPEM, keyPEM []byte, withMeta bool) {
+	t.Helper()
+	paths := PathsFor(storage, name)
+	if err := os.MkdirAll(CertificatesDir(storage), 0750); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	if certPEM != nil {
+		if err := os.WriteFile(paths.Certificate, certPEM, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if keyPEM != nil {
+		if err := os.WriteFile(paths.PrivateKey, keyPEM, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if withMeta {
+		if err := os.WriteFile(paths.Metadata, []byte(`{"domain":"x"}`), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	leaf, _ := generateCert(t, []string{"example.com", "www.example.com"}, 48*time.Hour)
+	chain, _ := generateCert(t, []string{"Issuer CA"}, 480*time.Hour)
+
+	info, err := Parse(append(leaf, chain...))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if info.CommonName != "example.com" {
+		t.Errorf("Expected leaf certificate to be parsed, got CN %s", info.CommonName)
+	}
+	if !reflect.DeepEqual(info.DNSNames, []string{"example.com", "www.example.com"}) {
+		t.Errorf("Unexpected SANs: %v", info.DNSNames)
+	}
+	if info.KeyAlgorithm != "ec256" {
+		t.Errorf("Expected key algorithm ec256, got %s", info.KeyAlgorithm)
+	}
+	if info.SerialNumber != "abc123" {
+		t.Errorf("Expected serial abc123, got %s", info.SerialNumber)
+	}
+	if info.IsExpired(time.Now()) {
+		t.Error("Certificate should not be expired")
+	}
+	if left := info.TimeLeft(time.Now()); left < 47*time.Hour || left > 48*time.Hour {
+		t.Errorf("Unexpected time left: %v", left)
+	}
+	if lifetime := info.Lifetime(); lifetime != 49*time.Hour {
+		t.Errorf("Expected lifetime 49h, got %v", lifetime)
+	}
+
+	if _, err := Parse([]byte("not a certificate")); err != ErrNoCertificate {
+		t.Errorf("Expected ErrNoCertificate, got %v", err)
+	}
+}
+
+func TestCompareDomains(t *testing.T) {
+	missing, extra := CompareDomains([]string{"a.com", "b.com"}, []string{"b.com", "c.com"})
+	if !reflect.DeepEqual(missing, []string{"c.com"}) {
+		t.Errorf("Unexpected missing domains: %v", missing)
+	}
+	if !reflect.DeepEqual(extra, []string{"a.com"}) {
+		t.Errorf("Unexpected extra domains: %v", extra)
+	}
+}
+
+func TestKeyMatches(t *testing.T) {
+	certPEM, keyPEM := generateCert(t, []string{"example.com"}, time.Hour)
+	_, otherKey := generateCert(t, []string{"example.com"}, time.Hour)
+
+	info, err := Parse(certPEM)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if ok, err := KeyMatches(info.Certificate, keyPEM); err != nil || !ok {
+		t.Errorf("Expected matching key, got %v, %v", ok, err)
+	}
+	if ok, err := KeyMatches(info.Certificate, otherKey); err != nil || ok {
+		t.Errorf("Expected non-matching key, got %v, %v", ok, err)
+	}
+	if _, err := KeyMatches(info.Certificate, []byte("garbage")); err == nil {
+		t.Error("Expected error for invalid key")
+	}
+}
+
+func TestLoadArtifactAndList(t *testing.T) {
+	storage := t.TempDir()
+
+	certPEM, keyPEM := generateCert(t, []string{"example.com"}, time.Hour)
+	writeArtifact(t, storage, "complete", certPEM, keyPEM, true)
+	writeArtifact(t, storage, "keyonly", nil, keyPEM, false)
+
+	a, err := LoadArtifact(storage, "complete")
+	if err != nil {
+		t.Fatalf("LoadArtifact failed: %v", err)
+	}
+	if !a.Complete() || !a.KeyMatches || a.HasIssuer {
+		t.Errorf("Unexpected artifact state: %+v", a)
+	}
+
+	partial, err := LoadArtifact(storage, "keyonly")
+	if err != nil {
+		t.Fatalf("LoadArtifact failed: %v", err)
+	}
+	if partial.Complete() || partial.Info != nil || !partial.HasKey {
+		t.Errorf("Expected incomplete artifact with key only, got %+v", partial)
+	}
+
+	names, err := List(storage)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if !reflect.DeepEqual(names, []string{"complete", "keyonly"}) {
+		t.Errorf("Unexpected certificate names: %v", names)
+	}
+
+	if names, err := List(filepath.Join(storage, "missing")); err != nil || names != nil {
+		t.Errorf("Expected empty list for missing storage, got %v, %v", names, err)
+	}
+}
@@ -0,0 +1,188 @@
+// Package certmanager is the library interface of go-acme-dns-manager. It lets
+// other Go programs obtain and renew certificates through acme-dns the same way
+// the command line tool does, without shelling out to the binary.
+//
+//	cfg, err := manager.LoadConfig("config.yaml")
+//	...
+//	m, err := certmanager.New(cfg)
+//	...
+//	res, err := m.EnsureCertificate(ctx, certmanager.Request{
+//		Name:    "example",
+//		Domains: []string{"example.com", "*.example.com"},
+//	})
+//	if errors.Is(err, certmanager.ErrDNSSetupNeeded) {
+//		// create the CNAME records listed in res.DNSSetup and call again
+//	}
+//
+// Certificates are written to the storage layout described in package certinfo,
+// res.Paths points at the files.
+package certmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+// Actions reported in Result.Action
+const (
+	ActionInit  = manager.ActionInit  // A new certificate was obtained
+	ActionRenew = manager.ActionRenew // The certificate was renewed
+	ActionSkip  = manager.ActionSkip  // The certificate is valid, nothing was done
+)
+
+// ErrDNSSetupNeeded is returned by EnsureCertificate if CNAME records have to
+// be created before the certificate can be issued. Result.DNSSetup lists them.
+var ErrDNSSetupNeeded = manager.ErrDNSSetupNeeded
+
+// Request describes a certificate to ensure
+type Request struct {
+	Name    string   // Certificate name, used for the file names in the storage directory
+	Domains []string // Domains of the certificate, the first becomes the common name
+	KeyType string   // Optional key type (e.g. "ec256", "rsa2048"), empty for the configured default
+}
+
+// Result describes what EnsureCertificate did
+type Result struct {
+	Name     string
+	Action   string                 // ActionInit, ActionRenew or ActionSkip
+	Reason   string                 // Why the certificate was obtained or renewed
+	Paths    certinfo.Paths         // Location of the certificate files
+	DNSSetup []manager.DNSSetupInfo // CNAME records still missing, set with ErrDNSSetupNeeded
+}
+
+// legoRunner performs the ACME exchange, replaced in tests
+type legoRunner func(ctx context.Context, cfg *manager.Config, store interface{}, action, certName string, domains []string, keyType string) error
+
+// Manager obtains and renews certificates. It is safe for concurrent use.
+type Manager struct {
+	cfg      *manager.Config
+	store    interface{}
+	resolver manager.DNSResolver
+	runLego  legoRunner
+
+	// setupMu serializes acme-dns pre-checks, so concurrent calls never
+	// register two acme-dns accounts for the same domain
+	setupMu sync.Mutex
+}
+
+// New creates a Manager for a loaded configuration. The acme-dns accounts are
+// read from and saved to the storage directory of the configuration.
+func New(cfg *manager.Config) (*Manager, error) {
+	if cfg == nil {
+		return nil, errors.New("certmanager: configuration is nil")
+	}
+	store, err := manager.NewConfigAccountStore(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("certmanager: loading acme-dns accounts: %w", err)
+	}
+	return &Manager{
+		cfg:     cfg,
+		store:   store,
+		runLego: manager.RunLegoWithStoreContext,
+	}, nil
+}
+
+// NewFromConfigFile loads and validates a configuration file and creates a Manager for it
+func NewFromConfigFile(path string) (*Manager, error) {
+	cfg, err := manager.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(cfg)
+}
+
+// SetDNSResolver replaces the resolver used to verify the CNAME records. By
+// default the pre-check resolver of the configuration is used.
+func (m *Manager) SetDNSResolver(resolver manager.DNSResolver) {
+	m.resolver = resolver
+}
+
+// EnsureCertificate makes sure a valid certificate for the request exists. A
+// missing certificate is obtained, one that expires within the renewal
+// threshold or lacks requested domains is renewed, anything else is left alone.
+//
+// If CNAME records are missing and the configured DNS providers cannot create
+// them, the records are returned in Result.DNSSetup together with
+// ErrDNSSetupNeeded. With cfg.DNSWait set, it waits for them instead.
+//
+// Canceling ctx aborts acme-dns registration, DNS verification and the ACME
+// exchange at the next request.
+func (m *Manager) EnsureCertificate(ctx context.Context, req Request) (*Result, error) {
+	if req.Name == "" {
+		return nil, errors.New("certmanager: request has no certificate name")
+	}
+	if len(req.Domains) == 0 {
+		return nil, fmt.Errorf("certmanager: request %s has no domains", req.Name)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &Result{Name: req.Name, Paths: certinfo.PathsFor(m.cfg.CertStoragePath, req.Name)}
+	decision, err := manager.DetermineAction(m.cfg, req.Name, req.Domains, req.KeyType, m.cfg.GetRenewalThreshold())
+	if err != nil {
+		return nil, err
+	}
+	result.Action, result.Reason = decision.Action, decision.Reason
+	if decision.Action == manager.ActionSkip {
+		return result, nil
+	}
+	if decision.Action == manager.ActionInit {
+		// Move leftovers of an interrupted issuance out of the way before starting over
+		if _, err := manager.QuarantinePartialArtifacts(m.cfg, req.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	remaining, err := m.prepareDNS(ctx, req.Domains)
+	if err != nil {
+		return nil, err
+	}
+	if len(remaining) > 0 {
+		result.DNSSetup = remaining
+		return result, ErrDNSSetupNeeded
+	}
+
+	if err := m.runLego(ctx, m.cfg, m.store, decision.Action, req.Name, req.Domains, req.KeyType); err != nil {
+		return nil, fmt.Errorf("%s certificate %s: %w", decision.Action, req.Name, err)
+	}
+	return result, nil
+}
+
+// prepareDNS registers missing acme-dns accounts, lets the DNS providers create
+// missing records and returns the records that still have to be created
+func (m *Manager) prepareDNS(ctx context.Context, domains []string) ([]manager.DNSSetupInfo, error) {
+	cfg := m.cfg
+	m.setupMu.Lock()
+	setupInfo, err := manager.PreCheckAcmeDNSWithStoreContext(ctx, cfg, m.store, domains, m.resolver)
+	m.setupMu.Unlock()
+	if err != nil || len(setupInfo) == 0 {
+		return nil, err
+	}
+
+	executors, err := manager.NewDNSSetupExecutors(cfg)
+	if err != nil {
+		return nil, err
+	}
+	remaining := manager.ApplyDNSSetup(ctx, executors, setupInfo)
+	if len(remaining) == 0 || cfg.DNSWait == nil {
+		return remaining, nil
+	}
+
+	resolver := m.resolver
+	if resolver == nil {
+		resolver = manager.NewPrecheckResolver(cfg)
+	}
+	if err := manager.WaitForDNSSetup(ctx, cfg.DNSWait, resolver, remaining); err != nil {
+		if errors.Is(err, ErrDNSSetupNeeded) {
+			return remaining, nil
+		}
+		return nil, err
+	}
+	return nil, nil
+}
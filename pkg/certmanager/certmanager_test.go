@@ -0,0 +1,210 @@
+package certmanager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+// staticResolver answers CNAME lookups from a map, unknown names are NXDOMAIN
+type staticResolver map[string]string
+
+func (r staticResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	if target, ok := r[host]; ok {
+		return target + ".", nil
+	}
+	return "", &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// lego records the calls of the fake Lego runner
+type lego struct {
+	calls []string
+	err   error
+}
+
+func (l *lego) run(ctx context.Context, cfg *manager.Config, store interface{}, action, certName string, domains []string, keyType string) error {
+	l.calls = append(l.calls, action+" "+certName)
+	return l.err
+}
+
+// newTestManager creates a Manager on a temporary storage directory that knows
+// an acme-dns account for example.com
+func newTestManager(t *testing.T, resolver staticResolver) (*Manager, *lego) {
+	t.Helper()
+	dir := t.TempDir()
+	accounts := map[string]manager.AcmeDnsAccount{
+		"example.com": {Username: "user", Password: "secret", FullDomain: "abc.auth.example.org", SubDomain: "abc"},
+	}
+	data, err := json.Marshal(accounts)
+	if err != nil {
+		t.Fatalf("Failed to marshal accounts: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, manager.AcmeDNSAccountsFile), data, 0600); err != nil {
+		t.Fatalf("Failed to write accounts: %v", err)
+	}
+
+	m, err := New(&manager.Config{CertStoragePath: dir, AcmeDnsServer: "https://acme-dns.invalid"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	m.SetDNSResolver(resolver)
+	runner := &lego{}
+	m.runLego = runner.run
+	return m, runner
+}
+
+// writeCertificate stores a self-signed certificate valid for the given days
+func writeCertificate(t *testing.T, storagePath, certName string, domains []string, days int) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domains[0]},
+		DNSNames:     domains,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Duration(days) * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+
+	paths := certinfo.PathsFor(storagePath, certName)
+	if err := os.MkdirAll(filepath.Dir(paths.Certificate), 0700); err != nil {
+		t.Fatalf("Failed to create certificate directory: %v", err)
+	}
+	if err := os.WriteFile(paths.Certificate, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(paths.Metadata, []byte(`{"domain":"`+domains[0]+`"}`), 0600); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+}
+
+func TestEnsureCertificate_Skip(t *testing.T) {
+	m, runner := newTestManager(t, staticResolver{})
+	writeCertificate(t, m.cfg.CertStoragePath, "web", []string{"example.com"}, 80)
+
+	res, err := m.EnsureCertificate(context.Background(), Request{Name: "web", Domains: []string{"example.com"}, KeyType: "ec256"})
+	if err != nil {
+		t.Fatalf("EnsureCertificate failed: %v", err)
+	}
+	if res.Action != ActionSkip {
+		t.Errorf("Expected action %s, got %s", ActionSkip, res.Action)
+	}
+	if len(runner.calls) != 0 {
+		t.Errorf("Expected no Lego calls, got %v", runner.calls)
+	}
+}
+
+func TestEnsureCertificate_Renew(t *testing.T) {
+	m, runner := newTestManager(t, staticResolver{"_acme-challenge.example.com": "abc.auth.example.org"})
+	writeCertificate(t, m.cfg.CertStoragePath, "web", []string{"example.com"}, 5)
+
+	res, err := m.EnsureCertificate(context.Background(), Request{Name: "web", Domains: []string{"example.com"}})
+	if err != nil {
+		t.Fatalf("EnsureCertificate failed: %v", err)
+	}
+	if res.Action != ActionRenew || res.Reason == "" {
+		t.Errorf("Expected action %s with reason, got %s (%q)", ActionRenew, res.Action, res.Reason)
+	}
+	if len(runner.calls) != 1 || runner.calls[0] != "renew web" {
+		t.Errorf("Expected one renew call, got %v", runner.calls)
+	}
+}
+
+func TestEnsureCertificate_Init(t *testing.T) {
+	m, runner := newTestManager(t, staticResolver{"_acme-challenge.example.com": "abc.auth.example.org"})
+
+	res, err := m.EnsureCertificate(context.Background(), Request{Name: "web", Domains: []string{"example.com", "*.example.com"}})
+	if err != nil {
+		t.Fatalf("EnsureCertificate failed: %v", err)
+	}
+	if res.Action != ActionInit {
+		t.Errorf("Expected action %s, got %s", ActionInit, res.Action)
+	}
+	if want := certinfo.PathsFor(m.cfg.CertStoragePath, "web"); res.Paths != want {
+		t.Errorf("Expected paths %+v, got %+v", want, res.Paths)
+	}
+	if len(runner.calls) != 1 || runner.calls[0] != "init web" {
+		t.Errorf("Expected one init call, got %v", runner.calls)
+	}
+}
+
+func TestEnsureCertificate_DNSSetupNeeded(t *testing.T) {
+	m, runner := newTestManager(t, staticResolver{})
+
+	res, err := m.EnsureCertificate(context.Background(), Request{Name: "web", Domains: []string{"example.com"}})
+	if !errors.Is(err, ErrDNSSetupNeeded) {
+		t.Fatalf("Expected ErrDNSSetupNeeded, got %v", err)
+	}
+	if res == nil || len(res.DNSSetup) != 1 {
+		t.Fatalf("Expected one DNS record to set up, got %+v", res)
+	}
+	want := manager.DNSSetupInfo{ChallengeDomain: "_acme-challenge.example.com", TargetDomain: "abc.auth.example.org"}
+	if !reflect.DeepEqual(res.DNSSetup[0], want) {
+		t.Errorf("Expected %+v, got %+v", want, res.DNSSetup[0])
+	}
+	if len(runner.calls) != 0 {
+		t.Errorf("Expected no Lego calls, got %v", runner.calls)
+	}
+}
+
+func TestEnsureCertificate_LegoError(t *testing.T) {
+	m, runner := newTestManager(t, staticResolver{"_acme-challenge.example.com": "abc.auth.example.org"})
+	runner.err = errors.New("order failed")
+
+	_, err := m.EnsureCertificate(context.Background(), Request{Name: "web", Domains: []string{"example.com"}})
+	if !errors.Is(err, runner.err) {
+		t.Errorf("Expected wrapped Lego error, got %v", err)
+	}
+}
+
+func TestEnsureCertificate_Canceled(t *testing.T) {
+	m, runner := newTestManager(t, staticResolver{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := m.EnsureCertificate(ctx, Request{Name: "web", Domains: []string{"example.com"}}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if len(runner.calls) != 0 {
+		t.Errorf("Expected no Lego calls, got %v", runner.calls)
+	}
+}
+
+func TestEnsureCertificate_InvalidRequest(t *testing.T) {
+	m, _ := newTestManager(t, staticResolver{})
+
+	if _, err := m.EnsureCertificate(context.Background(), Request{Domains: []string{"example.com"}}); err == nil {
+		t.Error("Expected error for request without name")
+	}
+	if _, err := m.EnsureCertificate(context.Background(), Request{Name: "web"}); err == nil {
+		t.Error("Expected error for request without domains")
+	}
+}
+
+func TestNew_NilConfig(t *testing.T) {
+	if _, err := New(nil); err == nil {
+		t.Error("Expected error for nil configuration")
+	}
+}
@@ -16,6 +16,10 @@ const DefaultNetworkTimeout = 10 * time.Second
 // DefaultDNSLookupTimeout is the default timeout for DNS lookup operations
 const DefaultDNSLookupTimeout = 5 * time.Second
 
+// DefaultRunTimeout is the deadline of one certificate run: a one-shot
+// command or one pass of the daemon
+const DefaultRunTimeout = 30 * time.Minute
+
 // WithTimeout creates a context with a timeout for the operation
 func WithTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
 	return context.WithTimeout(parent, timeout)
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 )
@@ -25,6 +26,9 @@ const (
 	ErrorTypeValidation ErrorType = "VALIDATION"
 	// ErrorTypeAuthentication represents authentication errors
 	ErrorTypeAuthentication ErrorType = "AUTHENTICATION"
+	// ErrorTypeRateLimit represents rate limits and other transient server refusals
+	// that persisted through all retries
+	ErrorTypeRateLimit ErrorType = "RATE_LIMIT"
 )
 
 // ApplicationError is our custom error type that provides structured error information
@@ -189,6 +193,19 @@ func NewACMEError(operation, message string) *ApplicationError {
 		AddSuggestion("Verify account credentials and rate limits")
 }
 
+// NewRateLimitError creates a rate limit error
+func NewRateLimitError(operation, message string) *ApplicationError {
+	return NewApplicationError(ErrorTypeRateLimit, operation, message).
+		AddSuggestion("Run again later, the affected certificates are retried on the next run").
+		AddSuggestion("Check the rate limits of your ACME server, e.g. https://letsencrypt.org/docs/rate-limits/")
+}
+
+// IsRateLimitError checks if an error chain contains a rate limit ApplicationError
+func IsRateLimitError(err error) bool {
+	var appErr *ApplicationError
+	return errors.As(err, &appErr) && appErr.IsType(ErrorTypeRateLimit)
+}
+
 // NewCertificateError creates a certificate processing error
 func NewCertificateError(operation, message string) *ApplicationError {
 	return NewApplicationError(ErrorTypeCertificate, operation, message).
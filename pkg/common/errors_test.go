@@ -2,6 +2,7 @@ package common
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 	"testing"
 )
@@ -274,6 +275,25 @@ func TestErrorHelpers(t *testing.T) {
 	if validationErr.Type != ErrorTypeValidation {
 		t.Errorf("NewValidationError should create VALIDATION type error, got %v", validationErr.Type)
 	}
+
+	rateLimitErr := NewRateLimitError("obtain", "too many certificates")
+	if rateLimitErr.Type != ErrorTypeRateLimit {
+		t.Errorf("NewRateLimitError should create RATE_LIMIT type error, got %v", rateLimitErr.Type)
+	}
+}
+
+// TestIsRateLimitError tests detection of wrapped rate limit errors
+func TestIsRateLimitError(t *testing.T) {
+	wrapped := fmt.Errorf("processing certificate: %w", NewRateLimitError("obtain", "too many certificates"))
+	if !IsRateLimitError(wrapped) {
+		t.Error("Expected wrapped rate limit error to be detected")
+	}
+	if IsRateLimitError(NewACMEError("obtain", "unauthorized")) {
+		t.Error("Expected ACME error not to be a rate limit error")
+	}
+	if IsRateLimitError(errors.New("plain")) {
+		t.Error("Expected plain error not to be a rate limit error")
+	}
 }
 
 // TestErrorChaining tests that errors work with standard Go error handling
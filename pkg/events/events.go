@@ -0,0 +1,105 @@
+// Package events defines the certificate lifecycle events emitted by
+// go-acme-dns-manager and the publishers that deliver them to external systems.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Type identifies the kind of lifecycle event
+type Type string
+
+const (
+	// TypeCertificateIssued is emitted after a new certificate was obtained
+	TypeCertificateIssued Type = "certificate.issued"
+	// TypeCertificateRenewed is emitted after an existing certificate was renewed
+	TypeCertificateRenewed Type = "certificate.renewed"
+	// TypeCertificateFailed is emitted when obtaining or renewing a certificate failed
+	TypeCertificateFailed Type = "certificate.failed"
+	// TypeDNSSetupNeeded is emitted when CNAME records must be created before issuance can continue
+	TypeDNSSetupNeeded Type = "dns.setup_needed"
+)
+
+// Event is the payload delivered to every publisher.
+// All transports (message queues, HTTP callbacks, ...) share this JSON representation
+// so consumers can switch transports without changing their parsers.
+type Event struct {
+	Type      Type      `json:"type"`
+	Time      time.Time `json:"time"`
+	CertName  string    `json:"cert_name,omitempty"`
+	Domains   []string  `json:"domains,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// NewEvent creates an event of the given type stamped with the current time
+func NewEvent(eventType Type, certName string, domains []string) Event {
+	return Event{
+		Type:     eventType,
+		Time:     time.Now().UTC(),
+		CertName: certName,
+		Domains:  domains,
+	}
+}
+
+// Marshal returns the JSON encoding of the event
+func (e Event) Marshal() ([]byte, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling event %s: %w", e.Type, err)
+	}
+	return data, nil
+}
+
+// Publisher delivers events to an external system
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+	Close() error
+}
+
+// MultiPublisher fans out every event to a list of publishers
+type MultiPublisher struct {
+	publishers []Publisher
+}
+
+// NewMultiPublisher creates a publisher that forwards events to all given publishers
+func NewMultiPublisher(publishers ...Publisher) *MultiPublisher {
+	return &MultiPublisher{publishers: publishers}
+}
+
+// Add registers another publisher
+func (m *MultiPublisher) Add(p Publisher) {
+	m.publishers = append(m.publishers, p)
+}
+
+// Len returns the number of registered publishers
+func (m *MultiPublisher) Len() int {
+	return len(m.publishers)
+}
+
+// Publish delivers the event to every publisher.
+// A failing publisher does not prevent delivery to the others; all errors are joined.
+func (m *MultiPublisher) Publish(ctx context.Context, event Event) error {
+	var errs []error
+	for _, p := range m.publishers {
+		if err := p.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes all publishers
+func (m *MultiPublisher) Close() error {
+	var errs []error
+	for _, p := range m.publishers {
+		if err := p.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
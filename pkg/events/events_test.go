@@ -0,0 +1,74 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// recordingPublisher collects published events for assertions
+type recordingPublisher struct {
+	events []Event
+	err    error
+	closed bool
+}
+
+func (r *recordingPublisher) Publish(ctx context.Context, event Event) error {
+	r.events = append(r.events, event)
+	return r.err
+}
+
+func (r *recordingPublisher) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestEventMarshal(t *testing.T) {
+	event := NewEvent(TypeCertificateIssued, "my-cert", []string{"example.com", "www.example.com"})
+	event.RequestID = "req-1"
+
+	data, err := event.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+
+	if decoded["type"] != "certificate.issued" {
+		t.Errorf("Expected type certificate.issued, got %v", decoded["type"])
+	}
+	if decoded["cert_name"] != "my-cert" {
+		t.Errorf("Expected cert_name my-cert, got %v", decoded["cert_name"])
+	}
+	if _, ok := decoded["error"]; ok {
+		t.Error("Expected empty error to be omitted")
+	}
+	if event.Time.IsZero() {
+		t.Error("Expected event time to be set")
+	}
+}
+
+func TestMultiPublisher(t *testing.T) {
+	failing := &recordingPublisher{err: errors.New("broker down")}
+	working := &recordingPublisher{}
+	multi := NewMultiPublisher(failing, working)
+
+	err := multi.Publish(context.Background(), NewEvent(TypeCertificateRenewed, "c", nil))
+	if err == nil {
+		t.Fatal("Expected error from failing publisher")
+	}
+	if len(working.events) != 1 {
+		t.Errorf("Expected working publisher to still receive the event, got %d events", len(working.events))
+	}
+
+	if err := multi.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if !failing.closed || !working.closed {
+		t.Error("Expected all publishers to be closed")
+	}
+}
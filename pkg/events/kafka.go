@@ -0,0 +1,85 @@
+package events
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+// KafkaOptions configures a Kafka publisher
+type KafkaOptions struct {
+	Brokers  []string
+	Topic    string
+	Username string // SASL/PLAIN username, optional
+	Password string // SASL/PLAIN password, optional
+	TLS      bool
+	Timeout  time.Duration
+}
+
+// KafkaPublisher publishes events to a Kafka topic
+type KafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaPublisher validates the options and creates a Kafka publisher
+func NewKafkaPublisher(opts KafkaOptions) (*KafkaPublisher, error) {
+	if len(opts.Brokers) == 0 {
+		return nil, fmt.Errorf("kafka: at least one broker is required")
+	}
+	if opts.Topic == "" {
+		return nil, fmt.Errorf("kafka: topic must not be empty")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultPublishTimeout
+	}
+
+	transport := &kafka.Transport{
+		DialTimeout: opts.Timeout,
+		ClientID:    "go-acme-dns-manager",
+	}
+	if opts.TLS {
+		transport.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if opts.Username != "" {
+		transport.SASL = plain.Mechanism{Username: opts.Username, Password: opts.Password}
+	}
+
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(opts.Brokers...),
+		Topic:        opts.Topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		WriteTimeout: opts.Timeout,
+		Transport:    transport,
+	}
+
+	return &KafkaPublisher{writer: writer}, nil
+}
+
+// Publish writes the event to the configured topic, keyed by certificate name
+// so that all events of one certificate land in the same partition
+func (p *KafkaPublisher) Publish(ctx context.Context, event Event) error {
+	payload, err := event.Marshal()
+	if err != nil {
+		return err
+	}
+
+	err = p.writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(event.CertName),
+		Value: payload,
+		Time:  event.Time,
+	})
+	if err != nil {
+		return fmt.Errorf("kafka: publishing to topic %s: %w", p.writer.Topic, err)
+	}
+	return nil
+}
+
+// Close flushes and closes the underlying writer
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,29 @@
+package events
+
+import "testing"
+
+func TestNewKafkaPublisher_Validation(t *testing.T) {
+	if _, err := NewKafkaPublisher(KafkaOptions{Topic: "events"}); err == nil {
+		t.Error("Expected error when no brokers are configured")
+	}
+	if _, err := NewKafkaPublisher(KafkaOptions{Brokers: []string{"localhost:9092"}}); err == nil {
+		t.Error("Expected error when topic is empty")
+	}
+
+	p, err := NewKafkaPublisher(KafkaOptions{
+		Brokers:  []string{"localhost:9092"},
+		Topic:    "events",
+		Username: "user",
+		Password: "pass",
+		TLS:      true,
+	})
+	if err != nil {
+		t.Fatalf("NewKafkaPublisher failed: %v", err)
+	}
+	if p.writer.Topic != "events" {
+		t.Errorf("Expected topic events, got %s", p.writer.Topic)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultPublishTimeout bounds a single publish operation
+const DefaultPublishTimeout = 10 * time.Second
+
+// NATSOptions configures a NATS publisher
+type NATSOptions struct {
+	URL      string // nats://host:4222 or tls://host:4222
+	Subject  string
+	Username string
+	Password string
+	Token    string
+	Timeout  time.Duration
+}
+
+// NATSPublisher publishes events to a NATS subject using the core NATS text protocol.
+// A connection is opened per publish; the tool runs briefly from cron, so there is
+// no benefit in keeping a long-lived connection around.
+type NATSPublisher struct {
+	opts NATSOptions
+	addr string
+	tls  bool
+}
+
+// NewNATSPublisher validates the options and creates a NATS publisher
+func NewNATSPublisher(opts NATSOptions) (*NATSPublisher, error) {
+	if opts.Subject == "" {
+		return nil, fmt.Errorf("nats: subject must not be empty")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultPublishTimeout
+	}
+
+	u, err := url.Parse(opts.URL)
+	if err != nil {
+		return nil, fmt.Errorf("nats: parsing url %s: %w", opts.URL, err)
+	}
+
+	p := &NATSPublisher{opts: opts, addr: u.Host}
+	switch u.Scheme {
+	case "nats":
+	case "tls":
+		p.tls = true
+	default:
+		return nil, fmt.Errorf("nats: unsupported url scheme %q (expected nats:// or tls://)", u.Scheme)
+	}
+	if u.Port() == "" {
+		p.addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	// Credentials embedded in the URL are used unless set explicitly
+	if u.User != nil && p.opts.Username == "" && p.opts.Token == "" {
+		if pw, ok := u.User.Password(); ok {
+			p.opts.Username = u.User.Username()
+			p.opts.Password = pw
+		} else {
+			p.opts.Token = u.User.Username()
+		}
+	}
+
+	return p, nil
+}
+
+// natsConnect is the CONNECT payload of the NATS protocol
+type natsConnect struct {
+	Verbose   bool   `json:"verbose"`
+	Pedantic  bool   `json:"pedantic"`
+	Name      string `json:"name"`
+	Lang      string `json:"lang"`
+	Version   string `json:"version"`
+	User      string `json:"user,omitempty"`
+	Pass      string `json:"pass,omitempty"`
+	AuthToken string `json:"auth_token,omitempty"`
+}
+
+// Publish sends the event to the configured subject and waits for the server to acknowledge it
+func (p *NATSPublisher) Publish(ctx context.Context, event Event) error {
+	payload, err := event.Marshal()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.opts.Timeout)
+	defer cancel()
+
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return fmt.Errorf("nats: connecting to %s: %w", p.addr, err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	reader := bufio.NewReader(conn)
+
+	// The server greets us with INFO before anything else
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("nats: reading server info: %w", err)
+	}
+	if !strings.HasPrefix(line, "INFO") {
+		return fmt.Errorf("nats: unexpected greeting: %s", strings.TrimSpace(line))
+	}
+
+	if p.tls {
+		host, _, _ := net.SplitHostPort(p.addr)
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return fmt.Errorf("nats: tls handshake with %s: %w", p.addr, err)
+		}
+		conn = tlsConn
+		reader = bufio.NewReader(conn)
+	}
+
+	connect, err := json.Marshal(natsConnect{
+		Name:      "go-acme-dns-manager",
+		Lang:      "go",
+		Version:   "1",
+		User:      p.opts.Username,
+		Pass:      p.opts.Password,
+		AuthToken: p.opts.Token,
+	})
+	if err != nil {
+		return fmt.Errorf("nats: marshalling connect: %w", err)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "CONNECT %s\r\n", connect)
+	fmt.Fprintf(&msg, "PUB %s %d\r\n%s\r\n", p.opts.Subject, len(payload), payload)
+	msg.WriteString("PING\r\n")
+
+	if _, err := conn.Write([]byte(msg.String())); err != nil {
+		return fmt.Errorf("nats: writing to %s: %w", p.addr, err)
+	}
+
+	// A PONG confirms the server processed everything sent before the PING
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("nats: waiting for acknowledgement: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats: server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		case line == "PING":
+			if _, err := conn.Write([]byte("PONG\r\n")); err != nil {
+				return fmt.Errorf("nats: answering ping: %w", err)
+			}
+		}
+	}
+}
+
+// Close is a no-op since connections are not kept open between publishes
+func (p *NATSPublisher) Close() error {
+	return nil
+}
@@ -0,0 +1,160 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNATSServer accepts a single connection and records the published message
+type fakeNATSServer struct {
+	listener net.Listener
+	connect  chan string
+	subject  chan string
+	payload  chan []byte
+	reply    string // response to PING, e.g. "PONG" or "-ERR 'Authorization Violation'"
+}
+
+func newFakeNATSServer(t *testing.T, reply string) *fakeNATSServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &fakeNATSServer{
+		listener: l,
+		connect:  make(chan string, 1),
+		subject:  make(chan string, 1),
+		payload:  make(chan []byte, 1),
+		reply:    reply,
+	}
+	go s.serve()
+	t.Cleanup(func() { _ = l.Close() })
+	return s
+}
+
+func (s *fakeNATSServer) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+
+	_, _ = conn.Write([]byte(`INFO {"server_id":"test","version":"2.10.0","max_payload":1048576}` + "\r\n"))
+	reader := bufio.NewReader(conn)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "CONNECT "):
+			s.connect <- strings.TrimPrefix(line, "CONNECT ")
+		case strings.HasPrefix(line, "PUB "):
+			fields := strings.Fields(line)
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			buf := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, buf); err != nil {
+				return
+			}
+			s.subject <- fields[1]
+			s.payload <- buf[:size]
+		case line == "PING":
+			_, _ = conn.Write([]byte(s.reply + "\r\n"))
+		}
+	}
+}
+
+func TestNATSPublisher_Publish(t *testing.T) {
+	server := newFakeNATSServer(t, "PONG")
+
+	publisher, err := NewNATSPublisher(NATSOptions{
+		URL:      "nats://" + server.listener.Addr().String(),
+		Subject:  "certs.events",
+		Username: "alice",
+		Password: "secret",
+		Timeout:  5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewNATSPublisher failed: %v", err)
+	}
+
+	event := NewEvent(TypeCertificateRenewed, "web", []string{"example.com"})
+	if err := publisher.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	var connect natsConnect
+	if err := json.Unmarshal([]byte(<-server.connect), &connect); err != nil {
+		t.Fatalf("Invalid CONNECT payload: %v", err)
+	}
+	if connect.User != "alice" || connect.Pass != "secret" {
+		t.Errorf("Expected credentials alice/secret, got %s/%s", connect.User, connect.Pass)
+	}
+
+	if subject := <-server.subject; subject != "certs.events" {
+		t.Errorf("Expected subject certs.events, got %s", subject)
+	}
+
+	var received Event
+	if err := json.Unmarshal(<-server.payload, &received); err != nil {
+		t.Fatalf("Invalid event payload: %v", err)
+	}
+	if received.Type != TypeCertificateRenewed || received.CertName != "web" {
+		t.Errorf("Unexpected event received: %+v", received)
+	}
+}
+
+func TestNATSPublisher_ServerError(t *testing.T) {
+	server := newFakeNATSServer(t, "-ERR 'Authorization Violation'")
+
+	publisher, err := NewNATSPublisher(NATSOptions{
+		URL:     "nats://" + server.listener.Addr().String(),
+		Subject: "certs.events",
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewNATSPublisher failed: %v", err)
+	}
+
+	err = publisher.Publish(context.Background(), NewEvent(TypeCertificateFailed, "web", nil))
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("Expected authorization error, got %v", err)
+	}
+}
+
+func TestNewNATSPublisher_Validation(t *testing.T) {
+	tests := []struct {
+		name string
+		opts NATSOptions
+	}{
+		{"missing subject", NATSOptions{URL: "nats://localhost:4222"}},
+		{"bad scheme", NATSOptions{URL: "http://localhost:4222", Subject: "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewNATSPublisher(tt.opts); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+
+	p, err := NewNATSPublisher(NATSOptions{URL: "nats://tok3n@localhost", Subject: "x"})
+	if err != nil {
+		t.Fatalf("NewNATSPublisher failed: %v", err)
+	}
+	if p.addr != "localhost:4222" {
+		t.Errorf("Expected default port to be added, got %s", p.addr)
+	}
+	if p.opts.Token != "tok3n" {
+		t.Errorf("Expected token from URL, got %q", p.opts.Token)
+	}
+}
@@ -0,0 +1,253 @@
+package manager
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-jose/go-jose/v4"
+)
+
+// KeyRotationResult describes a completed account key rollover
+type KeyRotationResult struct {
+	AcmeServer string
+	AccountURL string
+	KeyFile    string
+	BackupDir  string // Directory holding the previous account key and account.json
+}
+
+// ErrNoAccount is returned by RotateAccountKey if the ACME server has no registration yet
+var ErrNoAccount = errors.New("no ACME registration found")
+
+// RotateAccountKey replaces the ACME account key of the configured server. It
+// generates a new key, performs the key-change operation of RFC 8555 section
+// 7.3.5 against the CA and then replaces the key and registration files. The
+// previous files are kept in 'backup-<timestamp>/' next to account.json.
+//
+// The new key is written to disk before the CA is asked to switch, so it
+// cannot be lost even if the process dies right after the CA accepted it.
+func RotateAccountKey(cfg *Config) (*KeyRotationResult, error) {
+	serverDir, err := accountServerDir(cfg)
+	if err != nil {
+		return nil, err
+	}
+	keyFile := filepath.Join(serverDir, cfg.Email, "keys", cfg.Email+".key")
+	accountFile := filepath.Join(serverDir, "account.json")
+
+	// Check first, loading the user would create a key for a new account
+	if _, err := os.Stat(accountFile); os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w for %s", ErrNoAccount, cfg.AcmeServer)
+	}
+	user, err := createOrLoadUser(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("loading ACME account: %w", err)
+	}
+	if user.Registration == nil || user.Registration.URI == "" {
+		return nil, fmt.Errorf("%w for %s", ErrNoAccount, cfg.AcmeServer)
+	}
+
+	oldKey, ok := user.key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported account key type %T", user.key)
+	}
+
+	newKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating new account key: %w", err)
+	}
+	pendingKeyFile := keyFile + ".new"
+	if err := os.WriteFile(pendingKeyFile, certcrypto.PEMEncode(newKey), PrivateKeyPermissions); err != nil {
+		return nil, fmt.Errorf("writing new account key %s: %w", pendingKeyFile, err)
+	}
+
+	httpClient := &http.Client{Timeout: cfg.HTTPTimeout, Transport: cfg.httpTransport()}
+	if httpClient.Timeout == 0 {
+		httpClient.Timeout = DefaultHTTPTimeout
+	}
+	if err := changeAccountKey(httpClient, cfg.AcmeServer, user.Registration.URI, oldKey, newKey); err != nil {
+		_ = os.Remove(pendingKeyFile)
+		return nil, err
+	}
+	DefaultLogger.Infof("CA accepted the new key for account %s", user.Registration.URI)
+
+	// The CA now only accepts the new key, from here on keep going on errors
+	// and tell the operator where the new key is
+	backupDir := filepath.Join(serverDir, "backup-"+time.Now().UTC().Format(quarantineTimeFormat))
+	if err := os.MkdirAll(backupDir, DirPermissions); err != nil {
+		return nil, fmt.Errorf("creating backup directory (new key is in %s): %w", pendingKeyFile, err)
+	}
+	for _, src := range []string{keyFile, accountFile} {
+		if err := copyFile(src, filepath.Join(backupDir, filepath.Base(src))); err != nil {
+			return nil, fmt.Errorf("backing up %s (new key is in %s): %w", src, pendingKeyFile, err)
+		}
+	}
+	if err := os.Rename(pendingKeyFile, keyFile); err != nil {
+		return nil, fmt.Errorf("replacing %s (new key is in %s): %w", keyFile, pendingKeyFile, err)
+	}
+	// The registration does not change, rewriting it keeps account.json in
+	// step with the key and records both in the manifest
+	if err := saveUser(cfg, user); err != nil {
+		return nil, err
+	}
+	recordManifest(cfg.CertStoragePath, keyFile, pendingKeyFile)
+	if err := publishStorageFiles(cfg, keyFile); err != nil {
+		return nil, fmt.Errorf("storing new key %s in storage backend: %w", keyFile, err)
+	}
+
+	return &KeyRotationResult{
+		AcmeServer: cfg.AcmeServer,
+		AccountURL: user.Registration.URI,
+		KeyFile:    keyFile,
+		BackupDir:  backupDir,
+	}, nil
+}
+
+// changeAccountKey posts the nested key-change JWS to the CA
+func changeAccountKey(httpClient *http.Client, directoryURL, accountURL string, oldKey, newKey crypto.Signer) error {
+	dir, err := fetchDirectory(httpClient, directoryURL)
+	if err != nil {
+		return err
+	}
+	if dir.KeyChangeURL == "" {
+		return fmt.Errorf("ACME server %s does not support key changes", directoryURL)
+	}
+
+	// Inner JWS: signed by the new key, proves possession of it
+	inner, err := signJWS(newKey, "", "", dir.KeyChangeURL, map[string]interface{}{
+		"account": accountURL,
+		"oldKey":  jose.JSONWebKey{Key: oldKey.Public()},
+	})
+	if err != nil {
+		return fmt.Errorf("signing key change with new key: %w", err)
+	}
+
+	// A badNonce answer is expected now and then, retry once with a fresh nonce
+	for attempt := 1; ; attempt++ {
+		nonce, err := fetchNonce(httpClient, dir.NewNonceURL)
+		if err != nil {
+			return err
+		}
+		outer, err := signJWS(oldKey, accountURL, nonce, dir.KeyChangeURL, json.RawMessage(inner))
+		if err != nil {
+			return fmt.Errorf("signing key change with old key: %w", err)
+		}
+
+		resp, err := httpClient.Post(dir.KeyChangeURL, "application/jose+json", bytes.NewReader(outer))
+		if err != nil {
+			return fmt.Errorf("posting key change: %w", err)
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		_ = resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return nil
+		}
+
+		var problem acme.ProblemDetails
+		_ = json.Unmarshal(body, &problem)
+		if problem.Type == acme.BadNonceErr && attempt < 2 {
+			continue
+		}
+		return fmt.Errorf("key change rejected by %s: HTTP %d: %s %s", directoryURL, resp.StatusCode, problem.Type, problem.Detail)
+	}
+}
+
+// signJWS creates a flattened JSON JWS. With kid set the key is referenced by
+// the account URL, otherwise the public key is embedded (as required for the
+// inner key-change JWS).
+func signJWS(key crypto.Signer, kid, nonce, url string, payload interface{}) ([]byte, error) {
+	alg, err := jwsAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := (&jose.SignerOptions{EmbedJWK: kid == ""}).WithHeader("url", url)
+	if nonce != "" {
+		opts = opts.WithHeader(jose.HeaderKey("nonce"), nonce)
+	}
+	signingKey := jose.SigningKey{Algorithm: alg, Key: key}
+	if kid != "" {
+		signingKey.Key = jose.JSONWebKey{Key: key, KeyID: kid}
+	}
+	signer, err := jose.NewSigner(signingKey, opts)
+	if err != nil {
+		return nil, err
+	}
+	signed, err := signer.Sign(data)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(signed.FullSerialize()), nil
+}
+
+// jwsAlgorithm maps an account key to its JWS signature algorithm
+func jwsAlgorithm(key crypto.Signer) (jose.SignatureAlgorithm, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported account key type %T", key)
+}
+
+// fetchDirectory reads the ACME directory
+func fetchDirectory(httpClient *http.Client, directoryURL string) (*acme.Directory, error) {
+	resp, err := httpClient.Get(directoryURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetching ACME directory %s: %w", directoryURL, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching ACME directory %s: HTTP %d", directoryURL, resp.StatusCode)
+	}
+	var dir acme.Directory
+	if err := json.NewDecoder(resp.Body).Decode(&dir); err != nil {
+		return nil, fmt.Errorf("parsing ACME directory %s: %w", directoryURL, err)
+	}
+	return &dir, nil
+}
+
+// fetchNonce gets a fresh anti-replay nonce
+func fetchNonce(httpClient *http.Client, newNonceURL string) (string, error) {
+	resp, err := httpClient.Head(newNonceURL)
+	if err != nil {
+		return "", fmt.Errorf("fetching nonce: %w", err)
+	}
+	_ = resp.Body.Close()
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", fmt.Errorf("no Replay-Nonce from %s (HTTP %d)", newNonceURL, resp.StatusCode)
+	}
+	return nonce, nil
+}
+
+// copyFile copies src to dst with the permissions of private keys
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, PrivateKeyPermissions)
+}
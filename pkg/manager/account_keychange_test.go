@@ -0,0 +1,216 @@
+package manager
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/registration"
+	"github.com/go-jose/go-jose/v4"
+)
+
+// fakeKeyChangeCA verifies key-change requests like an RFC 8555 server
+type fakeKeyChangeCA struct {
+	*httptest.Server
+	mu          sync.Mutex
+	accountURL  string
+	accountKey  crypto.PublicKey
+	nonces      int
+	badNonces   int // Number of requests to answer with badNonce first
+	requests    int
+	lastFailure string
+}
+
+func newFakeKeyChangeCA(t *testing.T) *fakeKeyChangeCA {
+	ca := &fakeKeyChangeCA{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/directory", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":  ca.URL + "/nonce",
+			"keyChange": ca.URL + "/key-change",
+		})
+	})
+	mux.HandleFunc("/nonce", func(w http.ResponseWriter, r *http.Request) {
+		ca.mu.Lock()
+		ca.nonces++
+		w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", ca.nonces))
+		ca.mu.Unlock()
+	})
+	mux.HandleFunc("/key-change", func(w http.ResponseWriter, r *http.Request) {
+		ca.mu.Lock()
+		defer ca.mu.Unlock()
+		ca.requests++
+		newKey, err := ca.verify(r)
+		if err != nil {
+			ca.lastFailure = err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprintf(w, `{"type":"urn:ietf:params:acme:error:malformed","detail":%q}`, err.Error())
+			return
+		}
+		if ca.badNonces > 0 {
+			ca.badNonces--
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"type":"urn:ietf:params:acme:error:badNonce","detail":"stale"}`))
+			return
+		}
+		ca.accountKey = newKey
+		w.WriteHeader(http.StatusOK)
+	})
+	ca.Server = httptest.NewServer(mux)
+	t.Cleanup(ca.Close)
+	return ca
+}
+
+// verify checks a key-change request and returns the new account key
+func (ca *fakeKeyChangeCA) verify(r *http.Request) (crypto.PublicKey, error) {
+	algs := []jose.SignatureAlgorithm{jose.ES256, jose.ES384, jose.RS256}
+	body, _ := io.ReadAll(r.Body)
+	outer, err := jose.ParseSigned(string(body), algs)
+	if err != nil {
+		return nil, fmt.Errorf("outer JWS: %w", err)
+	}
+	header := outer.Signatures[0].Protected
+	if header.KeyID != ca.accountURL || header.Nonce == "" || header.ExtraHeaders["url"] != ca.URL+"/key-change" {
+		return nil, fmt.Errorf("unexpected outer header %+v", header)
+	}
+	innerData, err := outer.Verify(ca.accountKey)
+	if err != nil {
+		return nil, fmt.Errorf("outer signature: %w", err)
+	}
+
+	inner, err := jose.ParseSigned(string(innerData), algs)
+	if err != nil {
+		return nil, fmt.Errorf("inner JWS: %w", err)
+	}
+	newKey := inner.Signatures[0].Protected.JSONWebKey
+	if newKey == nil || inner.Signatures[0].Protected.Nonce != "" {
+		return nil, fmt.Errorf("inner JWS must embed the new key and carry no nonce")
+	}
+	payload, err := inner.Verify(newKey)
+	if err != nil {
+		return nil, fmt.Errorf("inner signature: %w", err)
+	}
+	var change struct {
+		Account string          `json:"account"`
+		OldKey  jose.JSONWebKey `json:"oldKey"`
+	}
+	if err := json.Unmarshal(payload, &change); err != nil {
+		return nil, err
+	}
+	oldPrint, _ := change.OldKey.Thumbprint(crypto.SHA256)
+	wantPrint, _ := (&jose.JSONWebKey{Key: ca.accountKey}).Thumbprint(crypto.SHA256)
+	if change.Account != ca.accountURL || string(oldPrint) != string(wantPrint) {
+		return nil, fmt.Errorf("key change payload does not match the account")
+	}
+	return newKey.Key, nil
+}
+
+// setupKeyChangeAccount registers an account for the fake CA in a temp storage
+func setupKeyChangeAccount(t *testing.T, ca *fakeKeyChangeCA) (*Config, *MyUser) {
+	t.Helper()
+	cfg := &Config{Email: "test@example.com", CertStoragePath: t.TempDir(), AcmeServer: ca.URL + "/directory"}
+	user, err := createOrLoadUser(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	user.Registration = &registration.Resource{URI: ca.URL + "/acct/1"}
+	if err := saveUser(cfg, user); err != nil {
+		t.Fatalf("Failed to save user: %v", err)
+	}
+	ca.accountURL = user.Registration.URI
+	ca.accountKey = user.key.(crypto.Signer).Public()
+	return cfg, user
+}
+
+func TestRotateAccountKey(t *testing.T) {
+	ca := newFakeKeyChangeCA(t)
+	ca.badNonces = 1
+	cfg, user := setupKeyChangeAccount(t, ca)
+
+	result, err := RotateAccountKey(cfg)
+	if err != nil {
+		t.Fatalf("RotateAccountKey failed: %v (CA: %s)", err, ca.lastFailure)
+	}
+	if ca.requests != 2 {
+		t.Errorf("Expected a retry after badNonce, got %d requests", ca.requests)
+	}
+
+	keyPEM, err := os.ReadFile(result.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := certcrypto.ParsePEMPrivateKey(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPrint, _ := (&jose.JSONWebKey{Key: newKey.(crypto.Signer).Public()}).Thumbprint(crypto.SHA256)
+	caPrint, _ := (&jose.JSONWebKey{Key: ca.accountKey}).Thumbprint(crypto.SHA256)
+	if string(newPrint) != string(caPrint) {
+		t.Error("Expected the stored key to be the one the CA switched to")
+	}
+
+	backupKey, err := os.ReadFile(filepath.Join(result.BackupDir, "test@example.com.key"))
+	if err != nil {
+		t.Fatalf("Expected backup of the old key: %v", err)
+	}
+	if string(backupKey) != string(certcrypto.PEMEncode(user.key)) {
+		t.Error("Expected the backup to hold the old key")
+	}
+	if _, err := os.Stat(filepath.Join(result.BackupDir, "account.json")); err != nil {
+		t.Errorf("Expected backup of account.json: %v", err)
+	}
+	if _, err := os.Stat(result.KeyFile + ".new"); !os.IsNotExist(err) {
+		t.Error("Expected no pending key file after success")
+	}
+
+	reloaded, err := createOrLoadUser(cfg)
+	if err != nil || reloaded.Registration == nil || reloaded.Registration.URI != ca.accountURL {
+		t.Errorf("Expected the registration to survive the rotation, got %+v (%v)", reloaded, err)
+	}
+}
+
+func TestRotateAccountKey_Rejected(t *testing.T) {
+	ca := newFakeKeyChangeCA(t)
+	cfg, user := setupKeyChangeAccount(t, ca)
+	ca.accountURL = ca.URL + "/acct/other"
+
+	if _, err := RotateAccountKey(cfg); err == nil {
+		t.Fatal("Expected error when the CA rejects the key change")
+	}
+	keyFile := filepath.Join(cfg.CertStoragePath, "accounts", mustAccountDirName(t, cfg.AcmeServer), "test@example.com", "keys", "test@example.com.key")
+	keyPEM, _ := os.ReadFile(keyFile)
+	if string(keyPEM) != string(certcrypto.PEMEncode(user.key)) {
+		t.Error("Expected the old key to stay in place after a rejected key change")
+	}
+	if _, err := os.Stat(keyFile + ".new"); !os.IsNotExist(err) {
+		t.Error("Expected the pending key to be removed after a rejected key change")
+	}
+}
+
+func TestRotateAccountKey_NoAccount(t *testing.T) {
+	cfg := &Config{Email: "test@example.com", CertStoragePath: t.TempDir(), AcmeServer: "https://ca.example.com/directory"}
+	if _, err := RotateAccountKey(cfg); !errors.Is(err, ErrNoAccount) {
+		t.Errorf("Expected ErrNoAccount, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.CertStoragePath, "accounts")); !os.IsNotExist(err) {
+		t.Error("Expected no account files to be created")
+	}
+}
+
+func mustAccountDirName(t *testing.T, server string) string {
+	t.Helper()
+	name, err := AccountDirName(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
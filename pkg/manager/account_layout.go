@@ -0,0 +1,333 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Values of accounts_layout
+const (
+	AccountsLayoutFile    = "file"    // All accounts in acme-dns-accounts.json
+	AccountsLayoutSharded = "sharded" // One file per base domain below acme-dns-accounts/
+)
+
+// MigratedSuffix is appended to the accounts file once its accounts were
+// moved to the shards of the sharded layout
+const MigratedSuffix = ".migrated"
+
+// accountLayout reads and writes the accounts of an accountStore. key is
+// ACME_DNS_ACCOUNTS_KEY, the content is encrypted with it when set; load
+// reports whether some of it was still stored in plaintext.
+type accountLayout interface {
+	load(key string) (map[string]AcmeDnsAccount, bool, error)
+	save(accounts map[string]AcmeDnsAccount, key string) error
+}
+
+// fileAccountLayout keeps all accounts in one JSON file
+type fileAccountLayout struct {
+	filePath string
+	store    CertificateStore // Reads and writes the file, key is its name below the store root
+	storeKey string
+}
+
+func (l *fileAccountLayout) load(key string) (map[string]AcmeDnsAccount, bool, error) {
+	data, err := l.store.Get(l.storeKey)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("reading accounts file %s: %w", l.filePath, err)
+	}
+	if key != "" {
+		if err := removePlaintextBackup(filepath.Dir(l.filePath), l.filePath); err != nil {
+			return nil, false, err
+		}
+	}
+	accounts, err := decodeAccounts(data, key, l.filePath)
+	return accounts, key != "" && len(accounts) > 0 && !isEncryptedAccounts(data), err
+}
+
+func (l *fileAccountLayout) save(accounts map[string]AcmeDnsAccount, key string) error {
+	data, err := encodeAccounts(accounts, key)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(l.filePath)
+	if err := localFilePolicy(l.store).mkdirAll(dir, dir); err != nil {
+		return fmt.Errorf("creating directory %s for accounts file: %w", dir, err)
+	}
+
+	// Keep the previous content, credentials lost to a bad write cannot be recovered from acme-dns
+	backup, err := backupAccounts(l.filePath, key, localFilePolicy(l.store))
+	if err != nil {
+		return err
+	}
+	err = l.store.Put(l.storeKey, data, true)
+	if err != nil {
+		return fmt.Errorf("writing accounts file %s: %w", l.filePath, err)
+	}
+	if backup != "" {
+		recordManifest(dir, l.filePath, backup)
+	} else {
+		recordManifest(dir, l.filePath)
+	}
+	return nil
+}
+
+// shardedAccountLayout keeps the accounts of every base domain in a file of
+// their own, e.g. acme-dns-accounts/example.com.json for example.com and
+// *.example.com. A save only rewrites the shards that changed, so thousands
+// of domains do not mean rewriting all credentials for every registration.
+type shardedAccountLayout struct {
+	dir   string // Local shard directory
+	store CertificateStore
+	// legacy is the accounts file the shards are migrated from
+	legacy *fileAccountLayout
+	// written holds the serialized shards as last read or written, keyed by
+	// file name, to tell unchanged shards
+	written map[string][]byte
+	// fromLegacy is set when the accounts were read from the accounts file
+	// because no shards exist yet
+	fromLegacy bool
+}
+
+// prefix returns the store key of the shard directory
+func (l *shardedAccountLayout) prefix() string {
+	return AcmeDNSAccountsDir + "/"
+}
+
+// shardName returns the file of the shard holding the account of domain
+func shardName(domain string) string {
+	return accountBaseDomain(domain) + ".json"
+}
+
+func (l *shardedAccountLayout) load(key string) (map[string]AcmeDnsAccount, bool, error) {
+	keys, err := l.store.List(l.prefix())
+	if err != nil {
+		return nil, false, fmt.Errorf("listing accounts in %s: %w", l.dir, err)
+	}
+	var shards []string
+	for _, k := range keys {
+		// Backups and other files next to the shards are not accounts
+		if rest := strings.TrimPrefix(k, l.prefix()); strings.HasSuffix(rest, ".json") && !strings.Contains(rest, "/") {
+			shards = append(shards, k)
+		}
+	}
+	if len(shards) == 0 {
+		accounts, plaintext, err := l.legacy.load(key)
+		l.fromLegacy = len(accounts) > 0
+		return accounts, plaintext, err
+	}
+
+	accounts := make(map[string]AcmeDnsAccount)
+	plaintext := false
+	for _, k := range shards {
+		shardPath := filepath.Join(l.dir, path.Base(k))
+		data, err := l.store.Get(k)
+		if err != nil {
+			return nil, false, fmt.Errorf("reading accounts file %s: %w", shardPath, err)
+		}
+		if key != "" {
+			if err := removePlaintextBackup(filepath.Dir(l.dir), shardPath); err != nil {
+				return nil, false, err
+			}
+		}
+		shard, err := decodeAccounts(data, key, shardPath)
+		if err != nil {
+			return nil, false, err
+		}
+		for domain, account := range shard {
+			accounts[domain] = account
+		}
+		// A plaintext shard is encrypted on the next save even if unchanged
+		if key == "" || isEncryptedAccounts(data) {
+			if plain, err := json.MarshalIndent(shard, "", "  "); err == nil {
+				l.written[path.Base(k)] = plain
+			}
+		} else if len(shard) > 0 {
+			plaintext = true
+		}
+	}
+	return accounts, plaintext, nil
+}
+
+func (l *shardedAccountLayout) save(accounts map[string]AcmeDnsAccount, key string) error {
+	shards := make(map[string]map[string]AcmeDnsAccount)
+	for domain, account := range accounts {
+		name := shardName(domain)
+		if shards[name] == nil {
+			shards[name] = make(map[string]AcmeDnsAccount)
+		}
+		shards[name][domain] = account
+	}
+
+	if err := localFilePolicy(l.store).mkdirAll(filepath.Dir(l.dir), l.dir); err != nil {
+		return fmt.Errorf("creating directory %s for accounts files: %w", l.dir, err)
+	}
+	storagePath := filepath.Dir(l.dir)
+	names := make([]string, 0, len(shards))
+	for name := range shards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		plain, err := json.MarshalIndent(shards[name], "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshalling accounts: %w", err)
+		}
+		if bytes.Equal(l.written[name], plain) {
+			continue
+		}
+		data := plain
+		if key != "" {
+			if data, err = encryptAccounts(plain, key); err != nil {
+				return err
+			}
+		}
+		shardPath := filepath.Join(l.dir, name)
+		backup, err := backupAccounts(shardPath, key, localFilePolicy(l.store))
+		if err != nil {
+			return err
+		}
+		if err := l.store.Put(l.prefix()+name, data, true); err != nil {
+			return fmt.Errorf("writing accounts file %s: %w", shardPath, err)
+		}
+		l.written[name] = plain
+		if backup != "" {
+			recordManifest(storagePath, shardPath, backup)
+		} else {
+			recordManifest(storagePath, shardPath)
+		}
+	}
+
+	// Base domains whose last account was removed
+	for name := range l.written {
+		if _, ok := shards[name]; ok {
+			continue
+		}
+		shardPath := filepath.Join(l.dir, name)
+		if err := l.store.Delete(l.prefix() + name); err != nil {
+			return fmt.Errorf("removing accounts file %s: %w", shardPath, err)
+		}
+		delete(l.written, name)
+		recordManifest(storagePath, shardPath)
+	}
+	return nil
+}
+
+// migrate moves the accounts read from the accounts file to the shards and
+// renames the file with MigratedSuffix, so it is not read again but remains
+// at hand should the shards be lost
+func (l *shardedAccountLayout) migrate(s *accountStore) error {
+	if !l.fromLegacy {
+		return nil
+	}
+	if err := s.SaveAccounts(); err != nil {
+		return fmt.Errorf("migrating accounts to %s: %w", l.dir, err)
+	}
+	data, err := l.legacy.store.Get(l.legacy.storeKey)
+	if err != nil {
+		return fmt.Errorf("migrating accounts to %s: %w", l.dir, err)
+	}
+	if err := l.legacy.store.Put(l.legacy.storeKey+MigratedSuffix, data, true); err != nil {
+		return fmt.Errorf("migrating accounts to %s: %w", l.dir, err)
+	}
+	if err := l.legacy.store.Delete(l.legacy.storeKey); err != nil {
+		return fmt.Errorf("migrating accounts to %s: %w", l.dir, err)
+	}
+	l.fromLegacy = false
+	recordManifest(filepath.Dir(l.dir), l.legacy.filePath, l.legacy.filePath+MigratedSuffix)
+	DefaultLogger.Infof("Migrated %d acme-dns accounts from %s to one file per base domain in %s", len(s.GetAllAccounts()), l.legacy.filePath, l.dir)
+	return nil
+}
+
+// migrateFromShards moves the accounts back from the shards to the accounts
+// file when accounts_layout was set back to file after a migration, instead
+// of registering every domain again. The shards are renamed with
+// MigratedSuffix like the accounts file on the way there. s is the store of
+// the file layout, it is left alone if it holds accounts already.
+func (l *fileAccountLayout) migrateFromShards(s *accountStore, storagePath string) error {
+	if len(s.GetAllAccounts()) > 0 {
+		return nil
+	}
+	sharded, err := newShardedAccountStore(storagePath, l.store)
+	if err != nil {
+		return fmt.Errorf("reading accounts to migrate back to %s: %w", l.filePath, err)
+	}
+	shards := sharded.layout.(*shardedAccountLayout)
+	accounts := sharded.GetAllAccounts()
+	if len(accounts) == 0 || shards.fromLegacy {
+		return nil
+	}
+
+	s.mu.Lock()
+	s.accounts = accounts
+	s.mu.Unlock()
+	if err := s.SaveAccounts(); err != nil {
+		return fmt.Errorf("migrating accounts back to %s: %w", l.filePath, err)
+	}
+	keys, err := l.store.List(shards.prefix())
+	if err != nil {
+		return fmt.Errorf("migrating accounts back to %s: %w", l.filePath, err)
+	}
+	for _, k := range keys {
+		if rest := strings.TrimPrefix(k, shards.prefix()); !strings.HasSuffix(rest, ".json") || strings.Contains(rest, "/") {
+			continue
+		}
+		data, err := l.store.Get(k)
+		if err == nil {
+			err = l.store.Put(k+MigratedSuffix, data, true)
+		}
+		if err == nil {
+			err = l.store.Delete(k)
+		}
+		if err != nil {
+			return fmt.Errorf("migrating accounts back to %s: %w", l.filePath, err)
+		}
+		shardPath := filepath.Join(shards.dir, path.Base(k))
+		recordManifest(storagePath, shardPath, shardPath+MigratedSuffix)
+	}
+	DefaultLogger.Infof("Migrated %d acme-dns accounts from %s back to %s, accounts_layout is file", len(accounts), shards.dir, l.filePath)
+	return nil
+}
+
+// decodeAccounts parses the content of an accounts file, decrypting it with
+// key if it is encrypted. path names the file in errors.
+func decodeAccounts(data []byte, key, path string) (map[string]AcmeDnsAccount, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if isEncryptedAccounts(data) {
+		var err error
+		if data, err = decryptAccounts(data, key); err != nil {
+			return nil, fmt.Errorf("reading accounts file %s: %w", path, err)
+		}
+	}
+
+	var accounts map[string]AcmeDnsAccount
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return nil, fmt.Errorf("parsing accounts file %s: %w", path, err)
+	}
+	return accounts, nil
+}
+
+// encodeAccounts serializes accounts, encrypted with key if it is set
+func encodeAccounts(accounts map[string]AcmeDnsAccount, key string) ([]byte, error) {
+	data, err := json.MarshalIndent(accounts, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshalling accounts: %w", err)
+	}
+	if key != "" {
+		if data, err = encryptAccounts(data, key); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
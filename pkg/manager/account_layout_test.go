@@ -0,0 +1,162 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShardedAccountStore_MigratesAccountsFile(t *testing.T) {
+	dir := t.TempDir()
+	legacy, err := NewAccountStore(filepath.Join(dir, AcmeDNSAccountsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	example := AcmeDnsAccount{Username: "user1", Password: "secret1", FullDomain: "one.auth.example.org", SubDomain: "one"}
+	other := AcmeDnsAccount{Username: "user2", Password: "secret2", FullDomain: "two.auth.example.org", SubDomain: "two"}
+	legacy.SetAccount("example.com", example)
+	legacy.SetAccount("*.example.com", example)
+	legacy.SetAccount("other.org", other)
+	if err := legacy.SaveAccounts(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{CertStoragePath: dir, AccountsLayout: AccountsLayoutSharded}
+	store, err := NewConfigAccountStore(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open sharded store: %v", err)
+	}
+	if got := len(store.GetAllAccounts()); got != 3 {
+		t.Errorf("Expected 3 migrated accounts, got %d", got)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, AcmeDNSAccountsFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected the accounts file to be moved away, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, AcmeDNSAccountsFile+MigratedSuffix)); err != nil {
+		t.Errorf("Expected the migrated accounts file to be kept: %v", err)
+	}
+	shard, err := NewAccountStore(filepath.Join(dir, AcmeDNSAccountsDir, "example.com.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accounts := shard.GetAllAccounts(); len(accounts) != 2 || accounts["*.example.com"].Username != example.Username {
+		t.Errorf("Expected the example.com shard to hold the name and its wildcard, got %+v", accounts)
+	}
+
+	// Opening again reads the shards, not the migrated file
+	reopened, err := NewConfigAccountStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account, ok := reopened.GetAccount("other.org"); !ok || account.Username != other.Username {
+		t.Errorf("Expected other.org from its shard, got %+v", account)
+	}
+}
+
+func TestFileAccountStore_MigratesBackFromShards(t *testing.T) {
+	dir := t.TempDir()
+	account := AcmeDnsAccount{Username: "user1", Password: "secret1", FullDomain: "one.auth.example.org", SubDomain: "one"}
+	sharded, err := NewConfigAccountStore(&Config{CertStoragePath: dir, AccountsLayout: AccountsLayoutSharded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sharded.SetAccount("example.com", account)
+	if err := sharded.SaveAccounts(); err != nil {
+		t.Fatal(err)
+	}
+
+	// accounts_layout set back to file
+	cfg := &Config{CertStoragePath: dir}
+	store, err := NewConfigAccountStore(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open file store: %v", err)
+	}
+	if got, ok := store.GetAccount("example.com"); !ok || got.Username != account.Username {
+		t.Errorf("Expected the account from the shards, got %+v", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, AcmeDNSAccountsFile)); err != nil {
+		t.Errorf("Expected the accounts file to be written: %v", err)
+	}
+	shardPath := filepath.Join(dir, AcmeDNSAccountsDir, "example.com.json")
+	if _, err := os.Stat(shardPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the shard to be moved away, got %v", err)
+	}
+	if _, err := os.Stat(shardPath + MigratedSuffix); err != nil {
+		t.Errorf("Expected the migrated shard to be kept: %v", err)
+	}
+
+	// Switching to sharded again migrates the file once more
+	resharded, err := NewConfigAccountStore(&Config{CertStoragePath: dir, AccountsLayout: AccountsLayoutSharded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resharded.GetAccount("example.com"); !ok {
+		t.Error("Expected the account after switching back to sharded")
+	}
+}
+
+func TestShardedAccountStore_SavesChangedShardsOnly(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{CertStoragePath: dir, AccountsLayout: AccountsLayoutSharded}
+	store, err := NewConfigAccountStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetAccount("example.com", AcmeDnsAccount{Username: "user1", FullDomain: "one.auth.example.org"})
+	store.SetAccount("other.org", AcmeDnsAccount{Username: "user2", FullDomain: "two.auth.example.org"})
+	if err := store.SaveAccounts(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewConfigAccountStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetAccount("new.net", AcmeDnsAccount{Username: "user3", FullDomain: "three.auth.example.org"})
+	store.DeleteAccount("other.org")
+	if err := store.SaveAccounts(); err != nil {
+		t.Fatal(err)
+	}
+
+	shardDir := filepath.Join(dir, AcmeDNSAccountsDir)
+	if _, err := os.Stat(filepath.Join(shardDir, "example.com.json"+BackupSuffix)); !os.IsNotExist(err) {
+		t.Error("Expected the unchanged example.com shard not to be rewritten")
+	}
+	if _, err := os.Stat(filepath.Join(shardDir, "new.net.json")); err != nil {
+		t.Errorf("Expected a shard for new.net: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(shardDir, "other.org.json")); !os.IsNotExist(err) {
+		t.Errorf("Expected the emptied other.org shard to be removed, got %v", err)
+	}
+
+	local, err := NewLocalAccountStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(local.GetAllAccounts()); got != 2 {
+		t.Errorf("Expected 2 accounts in the shards, got %d", got)
+	}
+}
+
+func TestShardedAccountStore_EncryptsShards(t *testing.T) {
+	t.Setenv(AccountsKeyEnv, "correct horse battery staple")
+	dir := t.TempDir()
+	store, err := NewConfigAccountStore(&Config{CertStoragePath: dir, AccountsLayout: AccountsLayoutSharded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetAccount("example.com", AcmeDnsAccount{Username: "user", Password: "secret-password"})
+	if err := store.SaveAccounts(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, AcmeDNSAccountsDir, "example.com.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncryptedAccounts(data) || strings.Contains(string(data), "secret-password") {
+		t.Errorf("Expected an encrypted shard, got:\n%s", data)
+	}
+}
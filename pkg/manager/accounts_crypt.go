@@ -0,0 +1,214 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/nrdcg/goacmedns"
+	acmednsstorage "github.com/nrdcg/goacmedns/storage"
+)
+
+// AccountsKeyEnv names the environment variable holding the key that encrypts
+// the acme-dns accounts file at rest. It takes either an age X25519 identity
+// (AGE-SECRET-KEY-1...) or a passphrase.
+const AccountsKeyEnv = "ACME_DNS_ACCOUNTS_KEY"
+
+// ageHeader starts every binary age file
+const ageHeader = "age-encryption.org/v1\n"
+
+// isEncryptedAccounts reports whether the accounts file content is age encrypted
+func isEncryptedAccounts(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(ageHeader))
+}
+
+// accountsCipher returns the age recipient and identity for the key. Keys that
+// look like an age identity are used as such, anything else is a passphrase.
+func accountsCipher(key string) (age.Recipient, age.Identity, error) {
+	if strings.HasPrefix(key, "AGE-SECRET-KEY-") {
+		identity, err := age.ParseX25519Identity(strings.TrimSpace(key))
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing age identity from %s: %w", AccountsKeyEnv, err)
+		}
+		return identity.Recipient(), identity, nil
+	}
+
+	recipient, err := age.NewScryptRecipient(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("using passphrase from %s: %w", AccountsKeyEnv, err)
+	}
+	identity, err := age.NewScryptIdentity(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("using passphrase from %s: %w", AccountsKeyEnv, err)
+	}
+	return recipient, identity, nil
+}
+
+// encryptAccounts encrypts the serialized accounts with the key
+func encryptAccounts(data []byte, key string) ([]byte, error) {
+	recipient, _, err := accountsCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipient)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting accounts: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("encrypting accounts: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("encrypting accounts: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decryptAccounts decrypts an age encrypted accounts file with the key
+func decryptAccounts(data []byte, key string) ([]byte, error) {
+	if key == "" {
+		return nil, fmt.Errorf("accounts file is encrypted but %s is not set", AccountsKeyEnv)
+	}
+	_, identity, err := accountsCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(bytes.NewReader(data), identity)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting accounts with %s: %w", AccountsKeyEnv, err)
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting accounts with %s: %w", AccountsKeyEnv, err)
+	}
+	return plain, nil
+}
+
+// accountsKeyFromEnv returns the configured encryption key, if any
+func accountsKeyFromEnv() string {
+	return os.Getenv(AccountsKeyEnv)
+}
+
+// backupAccounts keeps the current content of an accounts file in
+// path+BackupSuffix before it is replaced, like backupFile. With key set, a
+// plaintext content is encrypted for the backup, the credentials must not
+// stay readable next to the encrypted file.
+func backupAccounts(path, key string, policy *filePolicy) (string, error) {
+	if key == "" {
+		return backupFile(path, policy)
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("reading %s: %w", path, err)
+	}
+	if len(data) > 0 && !isEncryptedAccounts(data) {
+		if data, err = encryptAccounts(data, key); err != nil {
+			return "", err
+		}
+	}
+	backup := path + BackupSuffix
+	uid, gid := policy.owner()
+	if err := writeFileAtomicOwned(backup, data, policy.fileMode(true), uid, gid); err != nil {
+		return "", fmt.Errorf("writing backup %s: %w", backup, err)
+	}
+	return backup, nil
+}
+
+// removePlaintextBackup removes the backup of an accounts file below
+// storagePath if it holds plaintext credentials, written before the
+// encryption was turned on
+func removePlaintextBackup(storagePath, path string) error {
+	backup := path + BackupSuffix
+	data, err := os.ReadFile(backup)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("reading %s: %w", backup, err)
+	}
+	if len(data) == 0 || isEncryptedAccounts(data) {
+		return nil
+	}
+	if err := os.Remove(backup); err != nil {
+		return fmt.Errorf("removing plaintext backup %s: %w", backup, err)
+	}
+	recordManifest(storagePath, backup)
+	DefaultLogger.Infof("Removed the plaintext backup %s, %s encrypts the acme-dns accounts", backup, AccountsKeyEnv)
+	return nil
+}
+
+// encryptPlaintext saves the accounts right away if they were read in
+// plaintext although a key is set, instead of leaving the credentials
+// readable until the next registration
+func (s *accountStore) encryptPlaintext() error {
+	s.mu.RLock()
+	plaintext := s.plaintext
+	s.mu.RUnlock()
+	if !plaintext {
+		return nil
+	}
+	if err := s.SaveAccounts(); err != nil {
+		return fmt.Errorf("encrypting acme-dns accounts in %s: %w", s.filePath, err)
+	}
+	DefaultLogger.Infof("Encrypted the acme-dns accounts in %s with %s", s.filePath, AccountsKeyEnv)
+	return nil
+}
+
+// Encrypted reports whether the store writes its file encrypted
+func (s *accountStore) Encrypted() bool {
+	return s.key != ""
+}
+
+// providerStorage exposes the account store to the lego acme-dns provider, which
+// cannot read an encrypted accounts file by itself
+type providerStorage struct {
+	store *accountStore
+	cfg   *Config // Selects the shared account of a name, may be nil
+}
+
+var _ goacmedns.Storage = providerStorage{}
+
+func (p providerStorage) Save(ctx context.Context) error {
+	return p.store.SaveAccounts()
+}
+
+func (p providerStorage) Put(ctx context.Context, domain string, account goacmedns.Account) error {
+	p.store.SetAccount(domain, AcmeDnsAccount{
+		Username:   account.Username,
+		Password:   account.Password,
+		FullDomain: account.FullDomain,
+		SubDomain:  account.SubDomain,
+	})
+	return nil
+}
+
+func (p providerStorage) Fetch(ctx context.Context, domain string) (goacmedns.Account, error) {
+	// Lego asks for the base form of a domain stored under its wildcard
+	account, _, ok := p.cfg.LookupAcmeDNSAccount(p.store, domain)
+	if !ok {
+		return goacmedns.Account{}, acmednsstorage.ErrDomainNotFound
+	}
+	return toGoacmednsAccount(account), nil
+}
+
+func (p providerStorage) FetchAll(ctx context.Context) (map[string]goacmedns.Account, error) {
+	all := make(map[string]goacmedns.Account)
+	for domain, account := range p.store.GetAllAccounts() {
+		all[domain] = toGoacmednsAccount(account)
+	}
+	return all, nil
+}
+
+func toGoacmednsAccount(account AcmeDnsAccount) goacmedns.Account {
+	return goacmedns.Account{
+		FullDomain: account.FullDomain,
+		SubDomain:  account.SubDomain,
+		Username:   account.Username,
+		Password:   account.Password,
+	}
+}
@@ -0,0 +1,194 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	acmednsstorage "github.com/nrdcg/goacmedns/storage"
+)
+
+func saveTestAccount(t *testing.T, path string) {
+	t.Helper()
+	store, err := NewAccountStore(path)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	store.SetAccount("example.com", AcmeDnsAccount{Username: "user", Password: "secret-password", FullDomain: "abc.auth.example.org", SubDomain: "abc"})
+	if err := store.SaveAccounts(); err != nil {
+		t.Fatalf("Failed to save accounts: %v", err)
+	}
+}
+
+func TestAccountStore_Encrypted(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, key := range map[string]string{
+		"identity":   identity.String(),
+		"passphrase": "correct horse battery staple",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(AccountsKeyEnv, key)
+			path := filepath.Join(t.TempDir(), AcmeDNSAccountsFile)
+			saveTestAccount(t, path)
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !isEncryptedAccounts(data) || strings.Contains(string(data), "secret-password") {
+				t.Fatalf("Expected encrypted accounts file, got:\n%s", data)
+			}
+
+			store, err := NewAccountStore(path)
+			if err != nil {
+				t.Fatalf("Failed to load encrypted store: %v", err)
+			}
+			if account, ok := store.GetAccount("example.com"); !ok || account.Password != "secret-password" {
+				t.Errorf("Expected decrypted account, got %+v", account)
+			}
+
+			t.Setenv(AccountsKeyEnv, "")
+			if _, err := NewAccountStore(path); err == nil || !strings.Contains(err.Error(), AccountsKeyEnv) {
+				t.Errorf("Expected error naming %s without key, got %v", AccountsKeyEnv, err)
+			}
+
+			t.Setenv(AccountsKeyEnv, "wrong key")
+			if _, err := NewAccountStore(path); err == nil {
+				t.Error("Expected error with wrong key")
+			}
+		})
+	}
+}
+
+func TestAccountStore_EncryptsPlaintextOnSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AcmeDNSAccountsFile)
+	saveTestAccount(t, path)
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(AccountsKeyEnv, identity.String())
+
+	store, err := NewAccountStore(path)
+	if err != nil {
+		t.Fatalf("Expected plaintext file to load with key set, got %v", err)
+	}
+	if _, ok := store.GetAccount("example.com"); !ok {
+		t.Fatal("Expected account from plaintext file")
+	}
+	if err := store.SaveAccounts(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if !isEncryptedAccounts(data) {
+		t.Error("Expected file to be encrypted after saving with key set")
+	}
+	// The backup of the plaintext content must not keep the credentials readable
+	backup, err := os.ReadFile(path + BackupSuffix)
+	if err != nil {
+		t.Fatalf("Expected a backup of the previous content: %v", err)
+	}
+	if !isEncryptedAccounts(backup) || strings.Contains(string(backup), "secret-password") {
+		t.Errorf("Expected an encrypted backup, got:\n%s", backup)
+	}
+	if plain, err := decryptAccounts(backup, identity.String()); err != nil || !strings.Contains(string(plain), "secret-password") {
+		t.Errorf("Expected the backup to decrypt to the previous accounts, got %v", err)
+	}
+}
+
+func TestNewConfigAccountStore_EncryptsPlaintext(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, layout := range []string{AccountsLayoutFile, AccountsLayoutSharded} {
+		t.Run(layout, func(t *testing.T) {
+			storage := t.TempDir()
+			cfg := &Config{CertStoragePath: storage, AccountsLayout: layout}
+			store, err := NewConfigAccountStore(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			store.SetAccount("example.com", AcmeDnsAccount{Username: "user", Password: "secret-password", FullDomain: "abc.auth.example.org", SubDomain: "abc"})
+			if err := store.SaveAccounts(); err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(storage, AcmeDNSAccountsFile)
+			if layout == AccountsLayoutSharded {
+				path = filepath.Join(storage, AcmeDNSAccountsDir, "example.com.json")
+			}
+
+			// Opening the store with a key encrypts it without waiting for a registration
+			t.Setenv(AccountsKeyEnv, identity.String())
+			store, err = NewConfigAccountStore(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := store.GetAccount("example.com"); !ok {
+				t.Fatal("Expected the account from the plaintext file")
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !isEncryptedAccounts(data) {
+				t.Errorf("Expected %s to be encrypted on load, got:\n%s", path, data)
+			}
+			if backup, err := os.ReadFile(path + BackupSuffix); err == nil && strings.Contains(string(backup), "secret-password") {
+				t.Errorf("Expected no plaintext backup, got:\n%s", backup)
+			}
+		})
+	}
+}
+
+func TestAccountStore_RemovesPlaintextBackup(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(AccountsKeyEnv, identity.String())
+	path := filepath.Join(t.TempDir(), AcmeDNSAccountsFile)
+	saveTestAccount(t, path)
+	if err := os.WriteFile(path+BackupSuffix, []byte(`{"example.com":{"password":"secret-password"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewAccountStore(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + BackupSuffix); !os.IsNotExist(err) {
+		t.Errorf("Expected the plaintext backup to be removed, got %v", err)
+	}
+}
+
+func TestProviderStorage(t *testing.T) {
+	store, err := NewAccountStore(filepath.Join(t.TempDir(), AcmeDNSAccountsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := providerStorage{store: store}
+	ctx := context.Background()
+
+	if _, err := storage.Fetch(ctx, "example.com"); !errors.Is(err, acmednsstorage.ErrDomainNotFound) {
+		t.Errorf("Expected ErrDomainNotFound, got %v", err)
+	}
+	store.SetAccount("example.com", AcmeDnsAccount{Username: "user", Password: "pw", FullDomain: "abc.auth.example.org", SubDomain: "abc"})
+
+	account, err := storage.Fetch(ctx, "example.com")
+	if err != nil || account.Username != "user" || account.FullDomain != "abc.auth.example.org" {
+		t.Errorf("Unexpected account %+v (%v)", account, err)
+	}
+	all, err := storage.FetchAll(ctx)
+	if err != nil || len(all) != 1 {
+		t.Errorf("Expected one account, got %v (%v)", all, err)
+	}
+}
@@ -1,17 +1,21 @@
 package manager
 
 import (
+	"context"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/lego"
 	"github.com/go-acme/lego/v4/registration"
 )
 
@@ -32,20 +36,104 @@ func (u *MyUser) GetPrivateKey() crypto.PrivateKey {
 	return u.key
 }
 
-// createOrLoadUser creates a new ACME user or loads an existing one from storage.
-func createOrLoadUser(cfg *Config) (*MyUser, error) {
-	// Determine storage path relative to config file
-	baseStorageDir := cfg.CertStoragePath
+// AccountDirName returns the directory below '<cert_storage_path>/accounts/'
+// holding the account of an ACME server. It is the host name, like Lego uses,
+// for directory URLs ending in the usual '/directory' path. Other paths are
+// appended, so several CAs on one host (e.g. step-ca provisioners) get
+// separate accounts.
+func AccountDirName(acmeServer string) (string, error) {
+	acmeURL, err := url.Parse(acmeServer)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse ACME server URL: %w", err)
+	}
+	if acmeURL.Host == "" {
+		return "", fmt.Errorf("ACME server URL %q has no host", acmeServer)
+	}
+
+	path := strings.Trim(acmeURL.Path, "/")
+	if path == "" || path == "directory" {
+		return acmeURL.Host, nil
+	}
+	return acmeURL.Host + "_" + strings.ReplaceAll(path, "/", "_"), nil
+}
+
+// accountServerDir returns the account directory of the configured ACME
+// server, moving the account there from a directory named after the host only
+func accountServerDir(cfg *Config) (string, error) {
+	name, err := AccountDirName(cfg.AcmeServer)
+	if err != nil {
+		return "", err
+	}
+	if err := migrateHostAccountDir(cfg, name); err != nil {
+		return "", err
+	}
+	return filepath.Join(cfg.CertStoragePath, "accounts", name), nil
+}
+
+// migrateHostAccountDir moves the account of a server whose directory URL has
+// a path, like ZeroSSL, Buypass or step-ca, from 'accounts/<host>', where
+// earlier versions kept it, to 'accounts/<name>'. Without this the account
+// would be registered again. An account registered at another path of the
+// host is left alone, another CA on the same host may still use it.
+func migrateHostAccountDir(cfg *Config, name string) error {
+	acmeURL, err := url.Parse(cfg.AcmeServer)
+	if err != nil || name == acmeURL.Host {
+		return nil
+	}
+	accountsDir := filepath.Join(cfg.CertStoragePath, "accounts")
+	oldDir, newDir := filepath.Join(accountsDir, acmeURL.Host), filepath.Join(accountsDir, name)
+	if _, err := os.Stat(newDir); !os.IsNotExist(err) {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(oldDir, cfg.Email)); err != nil {
+		return nil
+	}
+	if data, err := os.ReadFile(filepath.Join(oldDir, "account.json")); err == nil {
+		var reg registration.Resource
+		prefix := strings.TrimSuffix(strings.TrimSuffix(cfg.AcmeServer, "/"), "/directory") + "/"
+		if json.Unmarshal(data, &reg) == nil && reg.URI != "" && !strings.HasPrefix(reg.URI, prefix) {
+			DefaultLogger.Debugf("Account in %s belongs to %s, not to %s", oldDir, reg.URI, cfg.AcmeServer)
+			return nil
+		}
+	}
 
-	// Extract ACME server hostname from URL to create server-specific directory
-	acmeURL, urlErr := url.Parse(cfg.AcmeServer)
-	if urlErr != nil {
-		return nil, fmt.Errorf("failed to parse ACME server URL: %w", urlErr)
+	files, err := filesBelow(oldDir)
+	if err != nil {
+		return err
+	}
+	// Through the store, so a remote backend moves the account as well
+	for _, oldPath := range files {
+		rel, err := filepath.Rel(oldDir, oldPath)
+		if err != nil {
+			return err
+		}
+		newPath := filepath.Join(newDir, rel)
+		data, err := os.ReadFile(oldPath)
+		if err != nil {
+			return fmt.Errorf("moving ACME account: %w", err)
+		}
+		if err := writeStorageFile(cfg, newPath, data, true); err != nil {
+			return fmt.Errorf("moving ACME account to %s: %w", newPath, err)
+		}
+		if err := deleteStorageFile(cfg, oldPath); err != nil {
+			return fmt.Errorf("moving ACME account from %s: %w", oldPath, err)
+		}
+		recordManifest(cfg.CertStoragePath, oldPath, newPath)
+	}
+	if err := os.RemoveAll(oldDir); err != nil {
+		return fmt.Errorf("removing %s: %w", oldDir, err)
 	}
+	DefaultLogger.Infof("Moved the ACME account of %s from %s to %s", cfg.AcmeServer, oldDir, newDir)
+	return nil
+}
 
-	// Create Lego-style account path structure
-	accountsBaseDir := filepath.Join(baseStorageDir, "accounts")
-	serverDir := filepath.Join(accountsBaseDir, acmeURL.Host)
+// createOrLoadUser creates a new ACME user or loads an existing one from storage.
+func createOrLoadUser(cfg *Config) (*MyUser, error) {
+	// Lego-style account path structure, one directory per ACME server
+	serverDir, err := accountServerDir(cfg)
+	if err != nil {
+		return nil, err
+	}
 	emailDir := filepath.Join(serverDir, cfg.Email)
 
 	// Ensure the directory exists
@@ -81,10 +169,11 @@ func createOrLoadUser(cfg *Config) (*MyUser, error) {
 
 		// Save the new key
 		keyBytes := certcrypto.PEMEncode(privateKey)
-		if writeErr := os.WriteFile(keyFilePath, keyBytes, PrivateKeyPermissions); writeErr != nil {
+		if writeErr := writeStorageFile(cfg, keyFilePath, keyBytes, true); writeErr != nil {
 			return nil, fmt.Errorf("saving private key to %s: %w", keyFilePath, writeErr)
 		}
 		DefaultLogger.Infof("Saved new private key to %s", keyFilePath)
+		recordManifest(cfg.CertStoragePath, keyFilePath)
 	} else if err != nil {
 		return nil, fmt.Errorf("checking private key file %s: %w", keyFilePath, err)
 	} else {
@@ -99,6 +188,15 @@ func createOrLoadUser(cfg *Config) (*MyUser, error) {
 		if parseErr != nil {
 			return nil, fmt.Errorf("parsing private key from %s: %w", keyFilePath, parseErr)
 		}
+		// ACME requests are signed with RS256 or ES256/ES384, an Ed25519
+		// account key would only fail at the CA
+		signer, _ := privateKey.(crypto.Signer)
+		if signer == nil {
+			return nil, fmt.Errorf("private key in %s cannot sign", keyFilePath)
+		}
+		if _, algErr := jwsAlgorithm(signer); algErr != nil {
+			return nil, fmt.Errorf("private key in %s cannot be used as ACME account key (RSA or ECDSA P-256/P-384 required): %w", keyFilePath, algErr)
+		}
 	}
 
 	user := &MyUser{
@@ -130,15 +228,11 @@ func saveUser(cfg *Config, user *MyUser) error {
 		return fmt.Errorf("cannot save user without registration resource")
 	}
 
-	// Extract ACME server hostname from URL for server-specific directory
-	acmeURL, urlErr := url.Parse(cfg.AcmeServer)
-	if urlErr != nil {
-		return fmt.Errorf("failed to parse ACME server URL: %w", urlErr)
+	// Lego-style account path structure, one directory per ACME server
+	serverDir, err := accountServerDir(cfg)
+	if err != nil {
+		return err
 	}
-
-	// Create Lego-style account path structure
-	accountsBaseDir := filepath.Join(cfg.CertStoragePath, "accounts")
-	serverDir := filepath.Join(accountsBaseDir, acmeURL.Host)
 	emailDir := filepath.Join(serverDir, cfg.Email)
 
 	// Ensure the directory exists
@@ -154,10 +248,46 @@ func saveUser(cfg *Config, user *MyUser) error {
 		return fmt.Errorf("marshalling registration resource: %w", err)
 	}
 
-	err = os.WriteFile(accountFilePath, regBytes, PrivateKeyPermissions)
+	err = writeStorageFile(cfg, accountFilePath, regBytes, true)
 	if err != nil {
 		return fmt.Errorf("writing account file %s: %w", accountFilePath, err)
 	}
 	DefaultLogger.Infof("Saved ACME registration to %s", accountFilePath)
+	recordManifest(cfg.CertStoragePath, accountFilePath)
 	return nil
 }
+
+// RegisterACMEAccount registers the ACME account of the configured server and
+// email unless it is registered already, agreeing to the terms of service.
+// It returns the account URL and whether a new registration was made.
+func RegisterACMEAccount(ctx context.Context, cfg *Config) (string, bool, error) {
+	user, err := createOrLoadUser(cfg)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create/load ACME user: %w", err)
+	}
+	if user.Registration != nil {
+		return user.Registration.URI, false, nil
+	}
+
+	legoConfig := lego.NewConfig(user)
+	legoConfig.CADirURL = cfg.AcmeServer
+	legoConfig.HTTPClient = &http.Client{
+		Timeout:   cfg.HTTPTimeout,
+		Transport: newContextTransport(ctx, cfg.httpTransport()),
+	}
+	client, err := lego.NewClient(legoConfig)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create Lego client: %w", err)
+	}
+	reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+	if err != nil {
+		recordAudit(cfg, AuditRecord{Action: AuditRegisterAccount}, err)
+		return "", false, fmt.Errorf("ACME registration failed: %w", err)
+	}
+	recordAudit(cfg, AuditRecord{Action: AuditRegisterAccount, Account: reg.URI}, nil)
+	user.Registration = reg
+	if err := saveUser(cfg, user); err != nil {
+		return reg.URI, true, err
+	}
+	return reg.URI, true, nil
+}
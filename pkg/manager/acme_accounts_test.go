@@ -0,0 +1,169 @@
+package manager
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-acme/lego/v4/registration"
+)
+
+func TestAccountDirName(t *testing.T) {
+	tests := []struct {
+		server string
+		want   string
+	}{
+		{"https://acme-v02.api.letsencrypt.org/directory", "acme-v02.api.letsencrypt.org"},
+		{"https://acme-staging-v02.api.letsencrypt.org/directory", "acme-staging-v02.api.letsencrypt.org"},
+		{"https://ca.internal:9000/acme/acme/directory", "ca.internal:9000_acme_acme_directory"},
+		{"https://ca.internal", "ca.internal"},
+	}
+	for _, tt := range tests {
+		got, err := AccountDirName(tt.server)
+		if err != nil {
+			t.Errorf("AccountDirName(%q) failed: %v", tt.server, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("AccountDirName(%q) = %q, expected %q", tt.server, got, tt.want)
+		}
+	}
+
+	if _, err := AccountDirName("not a url"); err == nil {
+		t.Error("Expected error for URL without host")
+	}
+}
+
+func TestConfig_ForCert(t *testing.T) {
+	cfg := &Config{
+		AcmeServer: "https://acme-v02.api.letsencrypt.org/directory",
+		AutoDomains: &AutoDomainsConfig{Certs: map[string]CertConfig{
+			"internal": {Domains: []string{"app.internal.example.com"}, AcmeServer: "https://ca.internal/acme/acme/directory"},
+			"public":   {Domains: []string{"example.com"}},
+		}},
+	}
+
+	if got := cfg.ForCert("public"); got != cfg {
+		t.Error("Expected the global config for a certificate without override")
+	}
+	if got := cfg.ForCert("manual-only"); got != cfg {
+		t.Error("Expected the global config for a certificate not in auto_domains")
+	}
+
+	internal := cfg.ForCert("internal")
+	if internal == cfg || internal.AcmeServer != "https://ca.internal/acme/acme/directory" {
+		t.Errorf("Expected a copy with the certificate's ACME server, got %s", internal.AcmeServer)
+	}
+	if cfg.AcmeServer != "https://acme-v02.api.letsencrypt.org/directory" {
+		t.Error("Expected the global config to stay unchanged")
+	}
+}
+
+func TestAccounts_SeparatePerServer(t *testing.T) {
+	storage := t.TempDir()
+	production := &Config{Email: "test@example.com", CertStoragePath: storage, AcmeServer: "https://acme-v02.api.letsencrypt.org/directory"}
+	internal := &Config{Email: "test@example.com", CertStoragePath: storage, AcmeServer: "https://ca.internal/acme/acme/directory"}
+
+	prodUser, err := createOrLoadUser(production)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	prodUser.Registration = &registration.Resource{URI: "https://acme-v02.api.letsencrypt.org/acme/acct/1"}
+	if err := saveUser(production, prodUser); err != nil {
+		t.Fatalf("Failed to save user: %v", err)
+	}
+
+	internalUser, err := createOrLoadUser(internal)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if internalUser.Registration != nil {
+		t.Error("Expected no registration for the internal CA")
+	}
+	if internalUser.GetPrivateKey() == nil {
+		t.Fatal("Expected a private key for the internal CA account")
+	}
+
+	for _, path := range []string{
+		filepath.Join(storage, "accounts", "acme-v02.api.letsencrypt.org", "account.json"),
+		filepath.Join(storage, "accounts", "acme-v02.api.letsencrypt.org", "test@example.com", "keys", "test@example.com.key"),
+		filepath.Join(storage, "accounts", "ca.internal_acme_acme_directory", "test@example.com", "keys", "test@example.com.key"),
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s: %v", path, err)
+		}
+	}
+
+	reloaded, err := createOrLoadUser(production)
+	if err != nil {
+		t.Fatalf("Failed to reload user: %v", err)
+	}
+	if reloaded.Registration == nil || reloaded.Registration.URI != prodUser.Registration.URI {
+		t.Errorf("Expected the production registration to be reloaded, got %+v", reloaded.Registration)
+	}
+}
+
+func TestCreateOrLoadUser_RejectsEd25519(t *testing.T) {
+	storage := t.TempDir()
+	cfg := &Config{Email: "test@example.com", CertStoragePath: storage, AcmeServer: "https://ca.internal/acme/acme/directory"}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(storage, "accounts", "ca.internal_acme_acme_directory", "test@example.com", "keys", "test@example.com.key")
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := createOrLoadUser(cfg); err == nil {
+		t.Error("Expected an Ed25519 account key to be rejected")
+	}
+}
+
+func TestAccountServerDir_MovesHostDirectory(t *testing.T) {
+	storage := t.TempDir()
+	// Earlier versions kept every account of a host in accounts/<host>
+	hostOnly := &Config{Email: "test@example.com", CertStoragePath: storage, AcmeServer: "https://acme.zerossl.com/directory"}
+	user, err := createOrLoadUser(hostOnly)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	user.Registration = &registration.Resource{URI: "https://acme.zerossl.com/v2/DV90/account/abc"}
+	if err := saveUser(hostOnly, user); err != nil {
+		t.Fatalf("Failed to save user: %v", err)
+	}
+
+	// Another CA on the same host keeps the directory
+	other := &Config{Email: "test@example.com", CertStoragePath: storage, AcmeServer: "https://acme.zerossl.com/v2/OTHER"}
+	if dir, err := accountServerDir(other); err != nil || filepath.Base(dir) != "acme.zerossl.com_v2_OTHER" {
+		t.Fatalf("Unexpected account directory %s, %v", dir, err)
+	}
+	if _, err := os.Stat(filepath.Join(storage, "accounts", "acme.zerossl.com", "account.json")); err != nil {
+		t.Fatalf("Expected the account of another path to stay: %v", err)
+	}
+
+	zeroSSL := &Config{Email: "test@example.com", CertStoragePath: storage, AcmeServer: "https://acme.zerossl.com/v2/DV90"}
+	moved, err := createOrLoadUser(zeroSSL)
+	if err != nil {
+		t.Fatalf("Failed to load the moved user: %v", err)
+	}
+	if moved.Registration == nil || moved.Registration.URI != user.Registration.URI {
+		t.Errorf("Expected the existing registration after the upgrade, got %+v", moved.Registration)
+	}
+	if !reflect.DeepEqual(moved.GetPrivateKey(), user.GetPrivateKey()) {
+		t.Error("Expected the existing account key after the upgrade")
+	}
+	if _, err := os.Stat(filepath.Join(storage, "accounts", "acme.zerossl.com")); !os.IsNotExist(err) {
+		t.Errorf("Expected the host directory to be moved, got %v", err)
+	}
+}
@@ -1,17 +1,45 @@
 package manager
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
-	"time"
 
 	"github.com/oetiker/go-acme-dns-manager/pkg/common"
 )
 
+// Values of account_granularity
+const (
+	AccountPerDomain     = "domain"      // One account per name, shared with its wildcard
+	AccountPerBaseDomain = "base_domain" // One account per registrable domain
+)
+
+// AcmeDNSAccountKey returns the domain a new acme-dns account for domain is
+// registered under: the name without wildcard, or with account_granularity
+// base_domain its registrable domain, e.g. example.co.uk for
+// www.example.co.uk
+func (c *Config) AcmeDNSAccountKey(domain string) string {
+	if c != nil && c.AccountGranularity == AccountPerBaseDomain {
+		if registrable := RegistrableDomain(domain); registrable != "" {
+			return registrable
+		}
+	}
+	return GetBaseDomain(domain)
+}
+
+// LookupAcmeDNSAccount finds the acme-dns account used for domain and the
+// key it is stored under. An account of the name itself comes first, so
+// CNAME records created before switching account_granularity to
+// base_domain keep working.
+func (c *Config) LookupAcmeDNSAccount(store *accountStore, domain string) (AcmeDnsAccount, string, bool) {
+	if account, key, ok := store.LookupAccount(domain); ok {
+		return account, key, true
+	}
+	if key := c.AcmeDNSAccountKey(domain); key != accountBaseDomain(domain) {
+		return store.LookupAccount(key)
+	}
+	return AcmeDnsAccount{}, "", false
+}
+
 // RegisterNewAccount interacts with the acme-dns server's /register endpoint.
 // It updates the account store with the new account details and saves the store file.
 // For wildcard domains, it uses the base domain name for registration to maintain consistency.
@@ -23,38 +51,27 @@ func RegisterNewAccount(cfg *Config, store *accountStore, domain string) (*AcmeD
 // RegisterNewAccountWithLogger is the version that accepts a logger parameter for dependency injection.
 // This allows for better testability and removes dependency on global state.
 func RegisterNewAccountWithLogger(cfg *Config, store *accountStore, domain string, logger common.LoggerInterface) (*AcmeDnsAccount, error) {
-	return RegisterNewAccountWithDeps(cfg, store, domain, logger, &http.Client{Timeout: 30 * time.Second})
+	return RegisterNewAccountWithDeps(cfg, store, domain, logger, cfg.acmeDNSHTTPClient())
 }
 
 // RegisterNewAccountWithDeps is the fully parameterized version that accepts all dependencies.
 // This provides maximum testability by allowing injection of all external dependencies.
 func RegisterNewAccountWithDeps(cfg *Config, store *accountStore, domain string, logger common.LoggerInterface, httpClient common.HTTPClientInterface) (*AcmeDnsAccount, error) {
+	return RegisterNewAccountContext(context.Background(), cfg, store, domain, logger, httpClient)
+}
+
+// RegisterNewAccountContext is RegisterNewAccountWithDeps with a context that
+// cancels the registration request and the waits between retries.
+func RegisterNewAccountContext(ctx context.Context, cfg *Config, store *accountStore, domain string, logger common.LoggerInterface, httpClient common.HTTPClientInterface) (*AcmeDnsAccount, error) {
 	// Extract the base domain for registration purposes
 	baseDomain := GetBaseDomain(domain)
 
-	// Check if we already have an account for the base domain
-	if account, exists := store.GetAccount(baseDomain); exists && domain != baseDomain {
-		// If we're registering a wildcard but already have an account for the base domain,
-		// associate the wildcard with the existing account
-		store.SetAccount(domain, account)
-		logger.Infof("Using existing acme-dns account from %s for %s", baseDomain, domain)
-
-		// Since we're sharing the account, we also need to verify the CNAME is valid
-		// to prevent the main loop from requesting the same CNAME again
-		cnameValid, _ := VerifyCnameRecord(cfg, domain, account.FullDomain)
-		if cnameValid {
-			logger.Infof("Verified that the CNAME for %s is already properly set up", domain)
-		}
-
-		return &account, nil
-	}
-
-	// Or if we're registering a base domain but already have an account for the wildcard version
-	wildcardDomain := "*." + baseDomain
-	if account, exists := store.GetAccount(wildcardDomain); exists && domain != wildcardDomain {
-		// Associate the base domain with the existing wildcard account
+	// Check if we already have an account for the base domain or the
+	// wildcard, also in a different form, e.g. stored for another certificate
+	if account, key, exists := store.LookupAccount(domain); exists && key != domain {
+		// Associate the domain with the existing account
 		store.SetAccount(domain, account)
-		logger.Infof("Using existing acme-dns account from %s for %s", wildcardDomain, domain)
+		logger.Infof("Using existing acme-dns account from %s for %s", key, domain)
 
 		// Since we're sharing the account, we also need to verify the CNAME is valid
 		// to prevent the main loop from requesting the same CNAME again
@@ -66,49 +83,9 @@ func RegisterNewAccountWithDeps(cfg *Config, store *accountStore, domain string,
 		return &account, nil
 	}
 
-	registerURL, err := url.JoinPath(cfg.AcmeDnsServer, "/register")
+	newAccount, err := registerAcmeDNSAccount(ctx, cfg, domain, newAcmeDNSClient(cfg, httpClient, logger))
 	if err != nil {
-		return nil, fmt.Errorf("constructing register URL: %w", err)
-	}
-
-	logger.Infof("Registering new acme-dns account for %s at %s", domain, registerURL)
-
-	// acme-dns expects an empty JSON object {}
-	requestBody := []byte("{}")
-
-	req, err := http.NewRequest("POST", registerURL, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return nil, fmt.Errorf("creating registration request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Content-Length", fmt.Sprintf("%d", len(requestBody)))
-	req.Header.Set("User-Agent", "go-acme-dns-manager") // Identify our client
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("sending registration request to %s: %w", registerURL, err)
-	}
-	defer func() {
-		if closeErr := resp.Body.Close(); closeErr != nil {
-			// Log but don't return, we already have a response to process
-			logger.Errorf("Failed to close response body: %v", closeErr)
-		}
-	}()
-
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("reading registration response body: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusCreated { // 201
-		return nil, fmt.Errorf("failed to register at %s: status %d %s, body: %s",
-			registerURL, resp.StatusCode, resp.Status, string(bodyBytes))
-	}
-
-	var newAccount AcmeDnsAccount
-	err = json.Unmarshal(bodyBytes, &newAccount)
-	if err != nil {
-		return nil, fmt.Errorf("parsing registration response JSON: %w, body: %s", err, string(bodyBytes))
+		return nil, err
 	}
 
 	// Store the new account details in the account store for the requested domain
@@ -122,8 +99,7 @@ func RegisterNewAccountWithDeps(cfg *Config, store *accountStore, domain string,
 	}
 
 	// If this is a base domain, also store for the wildcard version
-	// (wildcardDomain is already defined at the top of the function)
-	if domain != wildcardDomain {
+	if wildcardDomain := "*." + baseDomain; domain != wildcardDomain {
 		store.SetAccount(wildcardDomain, newAccount)
 		logger.Debugf("Also associating account with wildcard domain %s", wildcardDomain)
 	}
@@ -142,3 +118,15 @@ func RegisterNewAccountWithDeps(cfg *Config, store *accountStore, domain string,
 
 	return &newAccount, nil
 }
+
+// registerAcmeDNSAccount registers a new account at the acme-dns server
+// without storing it
+func registerAcmeDNSAccount(ctx context.Context, cfg *Config, domain string, client *AcmeDNSClient) (account AcmeDnsAccount, err error) {
+	server := cfg.AcmeDnsServerFor(domain)
+	defer func() {
+		recordAudit(cfg, AuditRecord{Action: AuditRegisterAcmeDNS, Domains: []string{domain}, AcmeServer: server, Account: account.FullDomain}, err)
+	}()
+
+	client.logger.Infof("Registering new acme-dns account for %s at %s", domain, server)
+	return client.Register(ctx, server, cfg.AcmeDnsAllowFrom)
+}
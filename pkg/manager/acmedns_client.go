@@ -0,0 +1,206 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+)
+
+// userAgentProduct names the program in the User-Agent of acme-dns requests
+const userAgentProduct = "go-acme-dns-manager"
+
+// userAgentVersion is the program version added to the User-Agent
+var userAgentVersion atomic.Value
+
+// SetUserAgentVersion adds the program version to the User-Agent of the
+// requests to acme-dns servers
+func SetUserAgentVersion(version string) {
+	userAgentVersion.Store(version)
+}
+
+// UserAgent returns the User-Agent of the requests to acme-dns servers,
+// e.g. go-acme-dns-manager/1.4.0
+func UserAgent() string {
+	if version, _ := userAgentVersion.Load().(string); version != "" {
+		return userAgentProduct + "/" + version
+	}
+	return userAgentProduct
+}
+
+// AcmeDNSRequestTimeout returns the timeout of one acme-dns API request:
+// acme_dns_timeout, else http_timeout, else DefaultHTTPTimeout
+func (c *Config) AcmeDNSRequestTimeout() time.Duration {
+	if c.AcmeDnsTimeout > 0 {
+		return c.AcmeDnsTimeout
+	}
+	if c.HTTPTimeout > 0 {
+		return c.HTTPTimeout
+	}
+	return DefaultHTTPTimeout
+}
+
+// acmeDNSTransport returns the transport of requests to acme-dns servers: the
+// pooled keep-alive transport of the proxy and TLS settings, shared by all
+// clients of the run, with the User-Agent set
+func (c *Config) acmeDNSTransport() http.RoundTripper {
+	return &userAgentTransport{next: c.httpTransport()}
+}
+
+// acmeDNSHTTPClient returns the HTTP client of requests to acme-dns servers
+func (c *Config) acmeDNSHTTPClient() *http.Client {
+	return &http.Client{Timeout: c.AcmeDNSRequestTimeout(), Transport: c.acmeDNSTransport()}
+}
+
+// userAgentTransport sets the User-Agent of every request, also of those
+// built by the goacmedns client of the DNS-01 provider
+type userAgentTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", UserAgent())
+	return t.next.RoundTrip(req)
+}
+
+// AcmeDNSClient talks to the API of acme-dns servers. Registration, account
+// rotation and the self-test use it, so their requests share connections,
+// timeouts, retries and the User-Agent.
+type AcmeDNSClient struct {
+	httpClient common.HTTPClientInterface
+	retry      RetryConfig
+	logger     common.LoggerInterface
+}
+
+// NewAcmeDNSClient returns the acme-dns client of cfg. Requests time out
+// after AcmeDNSRequestTimeout and are retried according to the retry section.
+func NewAcmeDNSClient(cfg *Config) *AcmeDNSClient {
+	return newAcmeDNSClient(cfg, cfg.acmeDNSHTTPClient(), DefaultLogger)
+}
+
+// newAcmeDNSClient returns an acme-dns client sending through httpClient
+func newAcmeDNSClient(cfg *Config, httpClient common.HTTPClientInterface, logger common.LoggerInterface) *AcmeDNSClient {
+	return &AcmeDNSClient{httpClient: httpClient, retry: cfg.RetryPolicy(), logger: logger}
+}
+
+// Register creates a new account at the acme-dns server. With allowFrom the
+// account only accepts updates from these CIDRs.
+func (c *AcmeDNSClient) Register(ctx context.Context, server string, allowFrom []string) (AcmeDnsAccount, error) {
+	registerURL, err := url.JoinPath(server, "/register")
+	if err != nil {
+		return AcmeDnsAccount{}, fmt.Errorf("constructing register URL: %w", err)
+	}
+
+	// acme-dns expects an empty JSON object {} for unrestricted accounts
+	requestBody := []byte("{}")
+	if len(allowFrom) > 0 {
+		requestBody, err = json.Marshal(map[string][]string{"allowfrom": allowFrom})
+		if err != nil {
+			return AcmeDnsAccount{}, fmt.Errorf("encoding registration request: %w", err)
+		}
+		c.logger.Debugf("Restricting updates of the new account to %s", strings.Join(allowFrom, ", "))
+	}
+
+	var bodyBytes []byte
+	err = withRetry(ctx, c.retry, "register acme-dns account", nil, func() error {
+		var postErr error
+		bodyBytes, postErr = c.post(ctx, "register", "registration", registerURL, requestBody, nil, http.StatusCreated)
+		return postErr
+	})
+	if err != nil {
+		return AcmeDnsAccount{}, err
+	}
+
+	var account AcmeDnsAccount
+	if err := json.Unmarshal(bodyBytes, &account); err != nil {
+		return AcmeDnsAccount{}, fmt.Errorf("parsing registration response JSON: %w, body: %s", err, string(bodyBytes))
+	}
+	return account, nil
+}
+
+// Update sets the TXT value of the account at the acme-dns server
+func (c *AcmeDNSClient) Update(ctx context.Context, server string, account AcmeDnsAccount, value string) error {
+	updateURL, err := url.JoinPath(server, "/update")
+	if err != nil {
+		return fmt.Errorf("constructing update URL: %w", err)
+	}
+	body, err := json.Marshal(map[string]string{"subdomain": account.SubDomain, "txt": value})
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("X-Api-User", account.Username)
+	header.Set("X-Api-Key", account.Password)
+
+	return withRetry(ctx, c.retry, "update acme-dns record", nil, func() error {
+		_, postErr := c.post(ctx, "update", "update", updateURL, body, header, http.StatusOK)
+		return postErr
+	})
+}
+
+// acmeDNSStatusError is returned for unexpected responses of the acme-dns API
+type acmeDNSStatusError struct {
+	Action     string // register or update
+	URL        string
+	StatusCode int
+	Status     string
+	Body       string
+	RetryAfter time.Duration // Parsed Retry-After header, 0 if absent
+}
+
+func (e *acmeDNSStatusError) Error() string {
+	return fmt.Sprintf("failed to %s at %s: status %d %s, body: %s", e.Action, e.URL, e.StatusCode, e.Status, e.Body)
+}
+
+// post sends one JSON request and returns the body of a response with
+// status want. action and noun name the request in errors.
+func (c *AcmeDNSClient) post(ctx context.Context, action, noun, endpoint string, requestBody []byte, header http.Header, want int) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("creating %s request: %w", noun, err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Length", fmt.Sprintf("%d", len(requestBody)))
+	req.Header.Set("User-Agent", UserAgent()) // Also for clients injected without userAgentTransport
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("sending %s request to %s: %w", noun, endpoint, err)
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil {
+			// Log but don't return, we already have a response to process
+			c.logger.Errorf("Failed to close response body: %v", closeErr)
+		}
+	}()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s response body: %w", noun, err)
+	}
+
+	if resp.StatusCode != want {
+		return nil, &acmeDNSStatusError{
+			Action:     action,
+			URL:        endpoint,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       strings.TrimSpace(string(bodyBytes)),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+	return bodyBytes, nil
+}
@@ -0,0 +1,115 @@
+package manager
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUserAgent(t *testing.T) {
+	t.Cleanup(func() { SetUserAgentVersion("") })
+
+	if got := UserAgent(); got != "go-acme-dns-manager" {
+		t.Errorf("Expected User-Agent without version, got %q", got)
+	}
+	SetUserAgentVersion("1.2.3")
+	if got := UserAgent(); got != "go-acme-dns-manager/1.2.3" {
+		t.Errorf("Expected versioned User-Agent, got %q", got)
+	}
+}
+
+func TestConfig_AcmeDNSRequestTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want time.Duration
+	}{
+		{"default", Config{}, DefaultHTTPTimeout},
+		{"http_timeout", Config{HTTPTimeout: time.Minute}, time.Minute},
+		{"acme_dns_timeout", Config{HTTPTimeout: time.Minute, AcmeDnsTimeout: 5 * time.Second}, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.AcmeDNSRequestTimeout(); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAcmeDNSClient_Update(t *testing.T) {
+	waits := recordSleeps(t)
+	SetUserAgentVersion("1.2.3")
+	t.Cleanup(func() { SetUserAgentVersion("") })
+
+	var mu sync.Mutex
+	var agents []string
+	connections := 0
+	requests := 0
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		agents = append(agents, r.Header.Get("User-Agent"))
+		if r.Header.Get("X-Api-User") != "user" || r.Header.Get("X-Api-Key") != "secret" {
+			http.Error(w, `{"error": "forbidden"}`, http.StatusUnauthorized)
+			return
+		}
+		if requests == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"txt": "value"}`))
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			connections++
+			mu.Unlock()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	client := NewAcmeDNSClient(&Config{AcmeDnsServer: server.URL})
+	account := AcmeDnsAccount{Username: "user", Password: "secret", SubDomain: "sub"}
+	for i := 0; i < 2; i++ {
+		if err := client.Update(context.Background(), server.URL, account, "value"); err != nil {
+			t.Fatalf("Update %d failed: %v", i+1, err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 3 || len(*waits) != 1 {
+		t.Errorf("Expected the throttled update to be retried once, got %d requests and waits %v", requests, *waits)
+	}
+	for _, agent := range agents {
+		if agent != "go-acme-dns-manager/1.2.3" {
+			t.Errorf("Expected User-Agent go-acme-dns-manager/1.2.3, got %q", agent)
+		}
+	}
+	if connections != 1 {
+		t.Errorf("Expected the requests to share one keep-alive connection, got %d connections", connections)
+	}
+}
+
+func TestAcmeDNSClient_UpdateRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": "forbidden"}`, http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client := NewAcmeDNSClient(&Config{AcmeDnsServer: server.URL})
+	err := client.Update(context.Background(), server.URL, AcmeDnsAccount{Username: "user", Password: "wrong"}, "value")
+	if err == nil {
+		t.Fatal("Expected an error for a rejected update")
+	}
+	if want := "failed to update at " + server.URL + "/update: status 401"; !containsString(err.Error(), want) {
+		t.Errorf("Expected error containing %q, got %v", want, err)
+	}
+}
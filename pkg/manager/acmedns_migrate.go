@@ -0,0 +1,129 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AccountMigration describes the consolidation of the acme-dns accounts of
+// one base domain into a single account stored under the base domain and
+// its wildcard
+type AccountMigration struct {
+	Domain  string         // Base domain
+	Keys    []string       // Keys the accounts are stored under now
+	Keep    AcmeDnsAccount // Account used for both keys afterwards
+	Dropped []string       // fulldomain of the duplicate accounts removed
+	Live    bool           // The _acme-challenge CNAME points to the kept account
+}
+
+// PlanAccountMigration finds base domains whose acme-dns accounts are not
+// stored under exactly the base domain and its wildcard, e.g. because a
+// domain moved to another certificate in a different form or in a
+// different case, or because a second account was registered for it. With
+// several accounts the one the _acme-challenge CNAME points to is kept,
+// otherwise the one of the base domain. A nil resolver selects the
+// pre-check resolver of the configuration. Nothing is changed, see
+// ApplyAccountMigration.
+func PlanAccountMigration(ctx context.Context, cfg *Config, store *accountStore, resolver DNSResolver) ([]AccountMigration, error) {
+	accounts := store.GetAllAccounts()
+	groups := make(map[string][]string)
+	for key := range accounts {
+		base := accountBaseDomain(key)
+		groups[base] = append(groups[base], key)
+	}
+	bases := make([]string, 0, len(groups))
+	for base := range groups {
+		bases = append(bases, base)
+	}
+	sort.Strings(bases)
+	if resolver == nil {
+		resolver = NewPrecheckResolver(cfg)
+	}
+
+	var migrations []AccountMigration
+	for _, base := range bases {
+		keys := groups[base]
+		// The base domain comes first, it is kept if no CNAME decides
+		sort.Slice(keys, func(i, j int) bool {
+			if ki, kj := keys[i] == base, keys[j] == base; ki != kj {
+				return ki
+			}
+			return keys[i] < keys[j]
+		})
+		var candidates []AcmeDnsAccount
+		seen := make(map[string]bool)
+		for _, key := range keys {
+			if account := accounts[key]; !seen[account.FullDomain] {
+				seen[account.FullDomain] = true
+				candidates = append(candidates, account)
+			}
+		}
+		canonical := len(keys) == 2 && keys[0] == base && keys[1] == "*."+base
+		if len(candidates) == 1 && canonical {
+			continue
+		}
+
+		migration := AccountMigration{Domain: base, Keys: keys, Keep: candidates[0]}
+		if len(candidates) > 1 {
+			target, err := liveCNAMETarget(ctx, cfg, resolver, base)
+			if err != nil {
+				return nil, fmt.Errorf("looking up the CNAME of %s: %w", base, err)
+			}
+			for _, account := range candidates {
+				if target != "" && target == strings.ToLower(cfg.ExpectedCNAMETarget(base, account.FullDomain)) {
+					migration.Keep, migration.Live = account, true
+					break
+				}
+			}
+			for _, account := range candidates {
+				if account.FullDomain != migration.Keep.FullDomain {
+					migration.Dropped = append(migration.Dropped, account.FullDomain)
+				}
+			}
+		}
+		migrations = append(migrations, migration)
+	}
+	return migrations, nil
+}
+
+// liveCNAMETarget returns the target of the _acme-challenge CNAME of a base
+// domain, or of its challenge alias, in lower case; "" if there is none
+func liveCNAMETarget(ctx context.Context, cfg *Config, resolver DNSResolver, base string) (string, error) {
+	record := GetChallengeSubdomain(base)
+	if alias := cfg.ChallengeAliasFor(base); alias != "" {
+		record = alias
+	}
+	ctx, cancel := context.WithTimeout(ctx, DefaultDNSTimeout*time.Second)
+	defer cancel()
+	target, err := resolver.LookupCNAME(ctx, record)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(strings.TrimSuffix(target, ".")), nil
+}
+
+// ApplyAccountMigration stores the kept account of each migration under the
+// base domain and its wildcard, removes all other keys of the base domain
+// and saves the store. The dropped accounts remain on the acme-dns server.
+func ApplyAccountMigration(store *accountStore, migrations []AccountMigration) error {
+	if len(migrations) == 0 {
+		return nil
+	}
+	for _, migration := range migrations {
+		for _, key := range migration.Keys {
+			store.DeleteAccount(key)
+		}
+		store.SetAccount(migration.Domain, migration.Keep)
+		store.SetAccount("*."+migration.Domain, migration.Keep)
+	}
+	return store.SaveAccounts()
+}
@@ -0,0 +1,83 @@
+package manager
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlanAccountMigration(t *testing.T) {
+	storage := t.TempDir()
+	cfg := &Config{CertStoragePath: storage}
+	store, err := NewAccountStore(filepath.Join(storage, AcmeDNSAccountsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := AcmeDnsAccount{Username: "a", FullDomain: "a.auth.example.org"}
+	b := AcmeDnsAccount{Username: "b", FullDomain: "b.auth.example.org"}
+	c := AcmeDnsAccount{Username: "c", FullDomain: "c.auth.example.org"}
+	store.SetAccount("ok.example.com", a)
+	store.SetAccount("*.ok.example.com", a)
+	// Moved from a wildcard certificate to one with the base domain
+	store.SetAccount("*.moved.example.com", b)
+	// Registered twice, the CNAME points to the second account
+	store.SetAccount("dup.example.com", a)
+	store.SetAccount("*.Dup.example.com.", c)
+
+	resolver := staticResolver{"_acme-challenge.dup.example.com": "c.auth.example.org"}
+	migrations, err := PlanAccountMigration(context.Background(), cfg, store, resolver)
+	if err != nil {
+		t.Fatalf("PlanAccountMigration failed: %v", err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("Expected migrations of dup and moved, got %+v", migrations)
+	}
+	dup, moved := migrations[0], migrations[1]
+	if dup.Domain != "dup.example.com" || dup.Keep.Username != "c" || !dup.Live || len(dup.Dropped) != 1 || dup.Dropped[0] != "a.auth.example.org" {
+		t.Errorf("Expected the account of the CNAME to be kept for dup, got %+v", dup)
+	}
+	if moved.Domain != "moved.example.com" || moved.Keep.Username != "b" || len(moved.Dropped) != 0 {
+		t.Errorf("Expected the wildcard account to be kept for moved, got %+v", moved)
+	}
+
+	// Without a matching CNAME the account of the base domain wins
+	migrations, err = PlanAccountMigration(context.Background(), cfg, store, staticResolver{})
+	if err != nil || migrations[0].Keep.Username != "a" || migrations[0].Live {
+		t.Errorf("Expected the base domain account without CNAME, got %+v (%v)", migrations, err)
+	}
+
+	if err := ApplyAccountMigration(store, migrations); err != nil {
+		t.Fatalf("ApplyAccountMigration failed: %v", err)
+	}
+	reloaded, _ := NewAccountStore(filepath.Join(storage, AcmeDNSAccountsFile))
+	accounts := reloaded.GetAllAccounts()
+	if len(accounts) != 6 || accounts["dup.example.com"].Username != "a" || accounts["*.dup.example.com"].Username != "a" || accounts["moved.example.com"].Username != "b" {
+		t.Errorf("Expected base and wildcard keys of every domain, got %v", accounts)
+	}
+	if migrations, _ := PlanAccountMigration(context.Background(), cfg, reloaded, staticResolver{}); len(migrations) != 0 {
+		t.Errorf("Expected nothing to migrate after applying, got %+v", migrations)
+	}
+}
+
+func TestAccountStore_LookupAccount(t *testing.T) {
+	store, err := NewAccountStore(filepath.Join(t.TempDir(), AcmeDNSAccountsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	account := AcmeDnsAccount{FullDomain: "a.auth.example.org"}
+	store.SetAccount("Example.com.", account)
+
+	for _, domain := range []string{"example.com", "*.example.com", "EXAMPLE.com"} {
+		if got, key, ok := store.LookupAccount(domain); !ok || got.FullDomain != account.FullDomain || key != "Example.com." {
+			t.Errorf("LookupAccount(%q) = %v, %q, %v", domain, got, key, ok)
+		}
+	}
+	if _, _, ok := store.LookupAccount("www.example.com"); ok {
+		t.Error("Expected no account for a subdomain")
+	}
+
+	// Lego fetches the wildcard form of a domain registered as base domain
+	if _, err := (providerStorage{store: store}).Fetch(context.Background(), "*.example.com"); err != nil {
+		t.Errorf("Expected the provider storage to find the shared account: %v", err)
+	}
+}
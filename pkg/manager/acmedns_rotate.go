@@ -0,0 +1,118 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrNoAcmeDNSAccount is returned when rotating the credentials of a domain
+// without an acme-dns account
+var ErrNoAcmeDNSAccount = errors.New("no acme-dns account registered")
+
+// AcmeDNSRotation describes a credential rotation of one domain
+type AcmeDNSRotation struct {
+	Domain    string // Base domain
+	OldTarget string // fulldomain of the replaced account
+	NewTarget string // fulldomain of the new account
+	Resumed   bool   // The new account was registered by an earlier, unfinished rotation
+}
+
+// RotateAcmeDNSAccount replaces the acme-dns account of a domain, e.g. after
+// its credentials leaked. A new account is registered, the _acme-challenge
+// CNAME is pointed at it through the configured DNS providers or printed for
+// manual change, and once the new CNAME resolves the entry in the accounts
+// file is replaced. Until then the new account is kept in the pending
+// accounts file, so a rotation that timed out is resumed by running it again
+// instead of registering yet another account. cfg.DNSWait limits the wait.
+// A nil resolver selects the pre-check resolver of the configuration.
+func RotateAcmeDNSAccount(ctx context.Context, cfg *Config, store *accountStore, domain string, resolver DNSResolver) (*AcmeDNSRotation, error) {
+	base := GetBaseDomain(domain)
+	wildcard := "*." + base
+	old, oldKey, ok := cfg.LookupAcmeDNSAccount(store, base)
+	if !ok {
+		return nil, fmt.Errorf("%w for %s", ErrNoAcmeDNSAccount, base)
+	}
+	if target := cfg.ExpectedCNAMETarget(base, ""); target != "" {
+		return nil, fmt.Errorf("the CNAME target of %s is fixed to %s by dns_precheck.cname_targets, remove the entry before rotating", base, target)
+	}
+
+	pendingPath := filepath.Join(cfg.CertStoragePath, AcmeDNSPendingAccountsFile)
+	backend, err := cfg.Store()
+	if err != nil {
+		return nil, err
+	}
+	pending, err := newAccountStore(pendingPath, backend, AcmeDNSPendingAccountsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	rotation := &AcmeDNSRotation{Domain: base, OldTarget: old.FullDomain}
+	account, resumed := pending.GetAccount(base)
+	if resumed {
+		DefaultLogger.Infof("Resuming the rotation of %s with the account registered before", base)
+	} else {
+		account, err = registerAcmeDNSAccount(ctx, cfg, base, NewAcmeDNSClient(cfg))
+		if err != nil {
+			return nil, err
+		}
+		pending.SetAccount(base, account)
+		if err := pending.SaveAccounts(); err != nil {
+			return nil, fmt.Errorf("saving the new acme-dns account: %w", err)
+		}
+	}
+	rotation.NewTarget, rotation.Resumed = account.FullDomain, resumed
+
+	// With a challenge alias only the alias record changes
+	record := GetChallengeSubdomain(base)
+	if alias := cfg.ChallengeAliasFor(base); alias != "" {
+		record = alias
+	}
+	setupInfo := []DNSSetupInfo{{ChallengeDomain: record, TargetDomain: account.FullDomain}}
+	executors, err := NewDNSSetupExecutors(cfg)
+	if err != nil {
+		return rotation, err
+	}
+	if remaining := ApplyDNSSetup(ctx, executors, setupInfo); len(remaining) > 0 {
+		ReportDNSSetup(cfg, remaining)
+	}
+	// Records created by a provider must be visible as well before the switch
+	if resolver == nil {
+		resolver = NewPrecheckResolver(cfg)
+	}
+	if err := WaitForDNSSetup(ctx, cfg.DNSWait, resolver, setupInfo); err != nil {
+		return rotation, err
+	}
+
+	for _, key := range []string{base, wildcard, oldKey} {
+		if _, ok := store.GetAccount(key); ok {
+			store.SetAccount(key, account)
+		}
+	}
+	if err := store.SaveAccounts(); err != nil {
+		return rotation, fmt.Errorf("saving the rotated acme-dns account: %w", err)
+	}
+
+	pending.DeleteAccount(base)
+	if err := savePendingAccounts(cfg, pending, pendingPath); err != nil {
+		DefaultLogger.Warnf("Warning: removing %s from %s: %v", base, AcmeDNSPendingAccountsFile, err)
+	}
+	return rotation, nil
+}
+
+// savePendingAccounts saves the pending accounts, removing the file with its
+// backup once no rotation is pending anymore
+func savePendingAccounts(cfg *Config, pending *accountStore, path string) error {
+	if len(pending.GetAllAccounts()) > 0 {
+		return pending.SaveAccounts()
+	}
+	for _, p := range []string{path, path + BackupSuffix} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	recordManifest(cfg.CertStoragePath, path, path+BackupSuffix)
+	return publishStorageFiles(cfg, path)
+}
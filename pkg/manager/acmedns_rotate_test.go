@@ -0,0 +1,102 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRotateAcmeDNSAccount(t *testing.T) {
+	var registrations atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/register" {
+			http.NotFound(w, r)
+			return
+		}
+		registrations.Add(1)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"username": "new-user", "password": "new-secret", "fulldomain": "new.auth.example.org", "subdomain": "new", "allowfrom": []}`))
+	}))
+	defer server.Close()
+
+	storage := t.TempDir()
+	cfg := &Config{
+		AcmeDnsServer:   server.URL,
+		CertStoragePath: storage,
+		DNSWait:         &DNSWaitOptions{Timeout: 30 * time.Millisecond, Interval: 10 * time.Millisecond},
+	}
+	store, err := NewConfigAccountStore(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create account store: %v", err)
+	}
+	old := AcmeDnsAccount{Username: "leaked", Password: "leaked", FullDomain: "old.auth.example.org", SubDomain: "old"}
+	store.SetAccount("example.com", old)
+	store.SetAccount("*.example.com", old)
+	if err := store.SaveAccounts(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The CNAME still points to the old account, the rotation times out
+	resolver := &appearingResolver{records: map[string]string{"_acme-challenge.example.com": "old.auth.example.org"}}
+	rotation, err := RotateAcmeDNSAccount(context.Background(), cfg, store, "*.example.com", resolver)
+	if !errors.Is(err, ErrDNSSetupNeeded) {
+		t.Fatalf("Expected ErrDNSSetupNeeded while the CNAME is unchanged, got %v", err)
+	}
+	if rotation.NewTarget != "new.auth.example.org" || rotation.OldTarget != "old.auth.example.org" {
+		t.Errorf("Unexpected rotation %+v", rotation)
+	}
+	if account, _ := store.GetAccount("example.com"); account.Username != "leaked" {
+		t.Error("Expected the old account to stay in use until the CNAME changed")
+	}
+	pendingPath := filepath.Join(storage, AcmeDNSPendingAccountsFile)
+	if _, err := os.Stat(pendingPath); err != nil {
+		t.Fatalf("Expected the new account in the pending file: %v", err)
+	}
+
+	// Once the CNAME points to the new account the rotation completes
+	resolver.records["_acme-challenge.example.com"] = "new.auth.example.org"
+	rotation, err = RotateAcmeDNSAccount(context.Background(), cfg, store, "example.com", resolver)
+	if err != nil {
+		t.Fatalf("RotateAcmeDNSAccount failed: %v", err)
+	}
+	if !rotation.Resumed || registrations.Load() != 1 {
+		t.Errorf("Expected the pending account to be reused, got %d registrations (%+v)", registrations.Load(), rotation)
+	}
+
+	reloaded, err := NewConfigAccountStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"example.com", "*.example.com"} {
+		if account, _ := reloaded.GetAccount(key); account.Username != "new-user" {
+			t.Errorf("Expected the new account for %s, got %+v", key, account)
+		}
+	}
+	if _, err := os.Stat(pendingPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the pending file to be removed, got %v", err)
+	}
+	assertCleanManifest(t, storage)
+}
+
+func TestRotateAcmeDNSAccount_Errors(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir(), AcmeDnsServer: "http://127.0.0.1:1"}
+	store, err := NewConfigAccountStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RotateAcmeDNSAccount(context.Background(), cfg, store, "example.com", &appearingResolver{}); !errors.Is(err, ErrNoAcmeDNSAccount) {
+		t.Errorf("Expected ErrNoAcmeDNSAccount, got %v", err)
+	}
+
+	store.SetAccount("example.com", AcmeDnsAccount{FullDomain: "old.auth.example.org"})
+	cfg.DNSPrecheck = &DNSPrecheckConfig{CNAMETargets: map[string]string{"example.com": "public.auth.example.org"}}
+	if _, err := RotateAcmeDNSAccount(context.Background(), cfg, store, "example.com", &appearingResolver{}); err == nil {
+		t.Error("Expected an error for a domain with a fixed CNAME target")
+	}
+}
@@ -0,0 +1,121 @@
+package manager
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Steps of the acme-dns self-test reported in AcmeDNSSelfTestResult
+const (
+	SelfTestStepUpdate = "update" // Setting the TXT record through the acme-dns API
+	SelfTestStepDNS    = "dns"    // Resolving the TXT record through the CNAME
+)
+
+// Polling of the self-test TXT record, replaced in tests
+var (
+	selfTestTimeout  = 60 * time.Second
+	selfTestInterval = 2 * time.Second
+)
+
+// TXTResolver is implemented by resolvers that can look up TXT records. The
+// system resolver and the encrypted resolvers implement it.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// LookupTXT implements TXTResolver using the system resolver
+func (r *DefaultDNSResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return r.Resolver.LookupTXT(ctx, name)
+}
+
+// AcmeDNSSelfTestResult is the outcome of the self-test of one domain
+type AcmeDNSSelfTestResult struct {
+	Domain          string // Base domain of the account
+	ChallengeDomain string
+	FullDomain      string // acme-dns subdomain the CNAME points to
+	Step            string // Step that failed, empty on success
+	Err             error
+	Duration        time.Duration
+}
+
+// SelfTestAcmeDNS runs the DNS-01 challenge path of every registered acme-dns
+// account without an ACME order: a random TXT value is set through the
+// acme-dns update API and must then resolve through the _acme-challenge
+// CNAME. Wildcard and base domain share an account and are tested once.
+func SelfTestAcmeDNS(ctx context.Context, cfg *Config, store *accountStore, resolver TXTResolver) []AcmeDNSSelfTestResult {
+	accounts := make(map[string]AcmeDnsAccount)
+	for domain, account := range store.GetAllAccounts() {
+		accounts[GetBaseDomain(domain)] = account
+	}
+	domains := make([]string, 0, len(accounts))
+	for domain := range accounts {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	client := NewAcmeDNSClient(cfg)
+
+	var results []AcmeDNSSelfTestResult
+	for _, domain := range domains {
+		if ctx.Err() != nil {
+			break
+		}
+		results = append(results, selfTestAccount(ctx, cfg, client, resolver, domain, accounts[domain]))
+	}
+	return results
+}
+
+// selfTestAccount tests the challenge path of one account
+func selfTestAccount(ctx context.Context, cfg *Config, client *AcmeDNSClient, resolver TXTResolver, domain string, account AcmeDnsAccount) AcmeDNSSelfTestResult {
+	start := time.Now()
+	result := AcmeDNSSelfTestResult{
+		Domain:          domain,
+		ChallengeDomain: GetChallengeSubdomain(domain),
+		FullDomain:      account.FullDomain,
+	}
+	fail := func(step string, err error) AcmeDNSSelfTestResult {
+		result.Step, result.Err, result.Duration = step, err, time.Since(start)
+		return result
+	}
+
+	// acme-dns only accepts TXT values of exactly 43 characters
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return fail(SelfTestStepUpdate, err)
+	}
+	value := base64.RawURLEncoding.EncodeToString(random)
+
+	if err := client.Update(ctx, cfg.AcmeDnsServerFor(domain), account, value); err != nil {
+		return fail(SelfTestStepUpdate, err)
+	}
+	DefaultLogger.Debugf("Set self-test TXT value for %s, waiting for it to resolve via %s", domain, result.ChallengeDomain)
+
+	pollCtx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+	var lastErr error
+	for {
+		records, err := resolver.LookupTXT(pollCtx, result.ChallengeDomain)
+		if err == nil && slices.Contains(records, value) {
+			result.Duration = time.Since(start)
+			return result
+		}
+		if err != nil {
+			lastErr = err
+		} else {
+			lastErr = fmt.Errorf("TXT record of %s does not contain the test value (found %d other value(s)), check that the CNAME points to %s", result.ChallengeDomain, len(records), account.FullDomain)
+		}
+
+		timer := time.NewTimer(selfTestInterval)
+		select {
+		case <-pollCtx.Done():
+			timer.Stop()
+			return fail(SelfTestStepDNS, fmt.Errorf("not visible after %s: %w", selfTestTimeout, lastErr))
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAcmeDNS accepts updates for one account and serves the TXT value on
+// the CNAME of the domains in cnames
+type fakeAcmeDNS struct {
+	mu     sync.Mutex
+	txt    map[string]string // subdomain -> value
+	cnames map[string]string // challenge domain -> subdomain
+}
+
+func (f *fakeAcmeDNS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/update" || r.Header.Get("X-Api-User") != "user" || r.Header.Get("X-Api-Key") != "secret" {
+		http.Error(w, `{"error": "forbidden"}`, http.StatusUnauthorized)
+		return
+	}
+	var update struct {
+		SubDomain string `json:"subdomain"`
+		TXT       string `json:"txt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil || len(update.TXT) != 43 {
+		http.Error(w, `{"error": "bad_txt"}`, http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.txt[update.SubDomain] = update.TXT
+	f.mu.Unlock()
+	_, _ = w.Write([]byte(`{"txt": "` + update.TXT + `"}`))
+}
+
+func (f *fakeAcmeDNS) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	subdomain, ok := f.cnames[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return []string{f.txt[subdomain]}, nil
+}
+
+func TestSelfTestAcmeDNS(t *testing.T) {
+	selfTestTimeout, selfTestInterval = 50*time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() { selfTestTimeout, selfTestInterval = 60*time.Second, 2*time.Second })
+
+	fake := &fakeAcmeDNS{
+		txt:    make(map[string]string),
+		cnames: map[string]string{"_acme-challenge.example.com": "abc"},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store, err := NewAccountStore(filepath.Join(t.TempDir(), "accounts.json"))
+	if err != nil {
+		t.Fatalf("Failed to create account store: %v", err)
+	}
+	good := AcmeDnsAccount{Username: "user", Password: "secret", SubDomain: "abc", FullDomain: "abc.auth.example.org"}
+	store.SetAccount("example.com", good)
+	store.SetAccount("*.example.com", good)
+	// The CNAME of example.net is missing
+	store.SetAccount("example.net", AcmeDnsAccount{Username: "user", Password: "secret", SubDomain: "def", FullDomain: "def.auth.example.org"})
+	// The credentials of example.org are wrong
+	store.SetAccount("example.org", AcmeDnsAccount{Username: "user", Password: "wrong", SubDomain: "ghi", FullDomain: "ghi.auth.example.org"})
+
+	results := SelfTestAcmeDNS(context.Background(), &Config{AcmeDnsServer: server.URL}, store, fake)
+	if len(results) != 3 {
+		t.Fatalf("Expected one result per base domain, got %+v", results)
+	}
+
+	want := map[string]string{"example.com": "", "example.net": SelfTestStepDNS, "example.org": SelfTestStepUpdate}
+	for _, r := range results {
+		if r.Step != want[r.Domain] {
+			t.Errorf("Expected %s to fail at %q, got %q (%v)", r.Domain, want[r.Domain], r.Step, r.Err)
+		}
+		if (r.Err == nil) != (r.Step == "") {
+			t.Errorf("Expected an error exactly for failed steps, got %+v", r)
+		}
+	}
+	if results[0].ChallengeDomain != "_acme-challenge.example.com" || results[0].FullDomain != "abc.auth.example.org" {
+		t.Errorf("Unexpected result %+v", results[0])
+	}
+	if !strings.Contains(results[2].Err.Error(), "401") {
+		t.Errorf("Expected the HTTP status in the update error, got %v", results[2].Err)
+	}
+}
@@ -0,0 +1,125 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/providers/dns/acmedns"
+	"github.com/nrdcg/goacmedns"
+)
+
+// AcmeDnsServerFor returns the acme-dns server responsible for a domain: the
+// acme_dns_servers entry with the longest matching domain suffix, or
+// acme_dns_server if none matches
+func (c *Config) AcmeDnsServerFor(domain string) string {
+	name := strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(domain, "*."), "."))
+	server, matched := c.AcmeDnsServer, ""
+	for suffix, target := range c.AcmeDnsServers {
+		suffix = strings.ToLower(strings.TrimPrefix(strings.TrimSuffix(suffix, "."), "."))
+		if (name == suffix || strings.HasSuffix(name, "."+suffix)) && len(suffix) > len(matched) {
+			server, matched = target, suffix
+		}
+	}
+	return server
+}
+
+// acmeDNSServerURLs returns the distinct acme-dns servers of the configuration
+func (c *Config) acmeDNSServerURLs() []string {
+	seen := map[string]bool{c.AcmeDnsServer: true}
+	servers := []string{c.AcmeDnsServer}
+	for _, server := range c.AcmeDnsServers {
+		if !seen[server] {
+			seen[server] = true
+			servers = append(servers, server)
+		}
+	}
+	sort.Strings(servers[1:])
+	return servers
+}
+
+// validateAcmeDNSServers checks the acme_dns_servers entries
+func validateAcmeDNSServers(servers map[string]string) error {
+	for suffix, server := range servers {
+		if strings.Trim(suffix, ".") == "" {
+			return fmt.Errorf("acme_dns_servers has an empty domain suffix")
+		}
+		if u, err := url.Parse(server); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("acme_dns_servers entry %q is not an http(s) URL: %q", suffix, server)
+		}
+	}
+	return nil
+}
+
+// acmeDNSRouter presents DNS-01 challenges through the acme-dns server
+// responsible for each domain
+type acmeDNSRouter struct {
+	cfg       *Config
+	store     *accountStore
+	providers map[string]challenge.Provider // Keyed by server URL
+}
+
+var _ challenge.Provider = (*acmeDNSRouter)(nil)
+
+// acmeDNSHTTPClient returns the client option for the acme-dns clients of
+// the DNS-01 provider, bound to ctx like the requests of the Lego client
+func acmeDNSHTTPClient(ctx context.Context, cfg *Config) goacmedns.Option {
+	return goacmedns.WithHTTPClient(&http.Client{Timeout: cfg.AcmeDNSRequestTimeout(), Transport: newContextTransport(ctx, cfg.acmeDNSTransport())})
+}
+
+// newAcmeDNSRouter creates one acme-dns provider per configured server, all
+// sharing the account store
+func newAcmeDNSRouter(ctx context.Context, cfg *Config, store *accountStore) (*acmeDNSRouter, error) {
+	router := &acmeDNSRouter{cfg: cfg, store: store, providers: make(map[string]challenge.Provider)}
+	for _, server := range cfg.acmeDNSServerURLs() {
+		client, err := goacmedns.NewClient(server, acmeDNSHTTPClient(ctx, cfg))
+		if err != nil {
+			return nil, fmt.Errorf("acme-dns server %s: %w", server, err)
+		}
+		provider, err := acmedns.NewDNSProviderClient(client, providerStorage{store: store, cfg: cfg})
+		if err != nil {
+			return nil, fmt.Errorf("acme-dns server %s: %w", server, err)
+		}
+		router.providers[server] = provider
+	}
+	return router, nil
+}
+
+// provider returns the provider of the server responsible for the domain,
+// for an account shared with other names the server of the name it was
+// registered for
+func (r *acmeDNSRouter) provider(domain string) challenge.Provider {
+	server := r.cfg.AcmeDnsServerFor(domain)
+	if _, key, ok := r.cfg.LookupAcmeDNSAccount(r.store, domain); ok {
+		server = r.cfg.AcmeDnsServerFor(key)
+	}
+	DefaultLogger.Debugf("Using acme-dns server %s for %s", server, domain)
+	return r.providers[server]
+}
+
+// Present sets the challenge TXT record on the responsible acme-dns server
+func (r *acmeDNSRouter) Present(domain, token, keyAuth string) error {
+	return r.provider(domain).Present(domain, token, keyAuth)
+}
+
+// CleanUp is delegated to the responsible acme-dns provider
+func (r *acmeDNSRouter) CleanUp(domain, token, keyAuth string) error {
+	return r.provider(domain).CleanUp(domain, token, keyAuth)
+}
+
+// sequentialProvider makes Lego validate one name after another. acme-dns
+// keeps the last two TXT records of an account, more names sharing one
+// account cannot be validated at the same time.
+type sequentialProvider struct {
+	challenge.Provider
+}
+
+// Sequential is the wait between two validations
+func (sequentialProvider) Sequential() time.Duration {
+	return 0
+}
@@ -0,0 +1,135 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestAcmeDnsServerFor(t *testing.T) {
+	cfg := &Config{
+		AcmeDnsServer: "https://public.example.org",
+		AcmeDnsServers: map[string]string{
+			"corp.example.com":     "https://internal.example.org",
+			"lab.corp.example.com": "https://lab.example.org",
+			".example.net.":        "https://net.example.org",
+		},
+	}
+	for domain, want := range map[string]string{
+		"example.com":                      "https://public.example.org",
+		"corp.example.com":                 "https://internal.example.org",
+		"*.corp.example.com":               "https://internal.example.org",
+		"WWW.Corp.Example.com":             "https://internal.example.org",
+		"x.lab.corp.example.com":           "https://lab.example.org",
+		"notcorp.example.com":              "https://public.example.org",
+		"www.example.net":                  "https://net.example.org",
+		"_acme-challenge.corp.example.com": "https://internal.example.org",
+	} {
+		if got := cfg.AcmeDnsServerFor(domain); got != want {
+			t.Errorf("AcmeDnsServerFor(%q) = %q, want %q", domain, got, want)
+		}
+	}
+
+	want := []string{"https://public.example.org", "https://internal.example.org", "https://lab.example.org", "https://net.example.org"}
+	if got := cfg.acmeDNSServerURLs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected servers %v, got %v", want, got)
+	}
+}
+
+func TestValidateAcmeDNSServers(t *testing.T) {
+	if err := validateAcmeDNSServers(map[string]string{"corp.example.com": "https://acme-dns.corp.example.com"}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	for _, servers := range []map[string]string{
+		{".": "https://acme-dns.example.com"},
+		{"corp.example.com": "acme-dns.corp.example.com"},
+		{"corp.example.com": "ftp://acme-dns.corp.example.com"},
+	} {
+		if err := validateAcmeDNSServers(servers); err == nil {
+			t.Errorf("Expected an error for %v", servers)
+		}
+	}
+}
+
+// updateRecorder records the subdomains updated on one acme-dns server
+type updateRecorder struct {
+	mu         sync.Mutex
+	subdomains []string
+}
+
+func (u *updateRecorder) server(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var update struct {
+			SubDomain string `json:"subdomain"`
+			TXT       string `json:"txt"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		u.mu.Lock()
+		u.subdomains = append(u.subdomains, update.SubDomain)
+		u.mu.Unlock()
+		_, _ = w.Write([]byte(`{"txt": "` + update.TXT + `"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestAcmeDNSRouter(t *testing.T) {
+	var public, internal updateRecorder
+	cfg := &Config{
+		AcmeDnsServer:  public.server(t).URL,
+		AcmeDnsServers: map[string]string{"corp.example.com": internal.server(t).URL},
+	}
+
+	store, err := NewAccountStore(filepath.Join(t.TempDir(), "accounts.json"))
+	if err != nil {
+		t.Fatalf("Failed to create account store: %v", err)
+	}
+	store.SetAccount("example.com", AcmeDnsAccount{Username: "u", Password: "p", FullDomain: "pub.auth.example.org", SubDomain: "pub"})
+	store.SetAccount("corp.example.com", AcmeDnsAccount{Username: "u", Password: "p", FullDomain: "int.auth.example.org", SubDomain: "int"})
+
+	router, err := newAcmeDNSRouter(context.Background(), cfg, store)
+	if err != nil {
+		t.Fatalf("newAcmeDNSRouter failed: %v", err)
+	}
+	for _, domain := range []string{"example.com", "corp.example.com"} {
+		if err := router.Present(domain, "token", "key-auth"); err != nil {
+			t.Fatalf("Present(%s) failed: %v", domain, err)
+		}
+	}
+
+	if !reflect.DeepEqual(public.subdomains, []string{"pub"}) {
+		t.Errorf("Expected the public server to update pub, got %v", public.subdomains)
+	}
+	if !reflect.DeepEqual(internal.subdomains, []string{"int"}) {
+		t.Errorf("Expected the internal server to update int, got %v", internal.subdomains)
+	}
+}
+
+func TestRegisterNewAccountWithDeps_RoutedServer(t *testing.T) {
+	store, err := NewAccountStore(filepath.Join(t.TempDir(), "accounts.json"))
+	if err != nil {
+		t.Fatalf("Failed to create account store: %v", err)
+	}
+	mockClient := &mockHTTPClient{
+		responses: []*http.Response{createMockResponse(http.StatusCreated, createMockAcmeDnsAccountResponse())},
+	}
+	cfg := &Config{
+		AcmeDnsServer:  "https://acme-dns.example.com",
+		AcmeDnsServers: map[string]string{"corp.example.com": "https://acme-dns.corp.example.com"},
+	}
+
+	if _, err := RegisterNewAccountWithDeps(cfg, store, "*.corp.example.com", &mockLogger{}, mockClient); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := mockClient.requests[0].URL.String(); got != "https://acme-dns.corp.example.com/register" {
+		t.Errorf("Expected registration at the internal server, got %s", got)
+	}
+}
@@ -1,13 +1,18 @@
 package manager
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
+
+	"github.com/go-acme/lego/v4/providers/dns/acmedns"
 )
 
 // mockHTTPClient implements HTTPClientInterface for testing
@@ -163,6 +168,36 @@ func TestRegisterNewAccountWithDeps_Success(t *testing.T) {
 	}
 }
 
+func TestRegisterNewAccountWithDeps_AllowFrom(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		allowFrom []string
+		wantBody  string
+	}{
+		{"unrestricted", nil, `{}`},
+		{"restricted", []string{"192.0.2.10/32", "2001:db8::/64"}, `{"allowfrom":["192.0.2.10/32","2001:db8::/64"]}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store, err := NewAccountStore(filepath.Join(t.TempDir(), "accounts.json"))
+			if err != nil {
+				t.Fatalf("Failed to create account store: %v", err)
+			}
+			mockClient := &mockHTTPClient{
+				responses: []*http.Response{createMockResponse(http.StatusCreated, createMockAcmeDnsAccountResponse())},
+			}
+			cfg := &Config{AcmeDnsServer: "https://acme-dns.example.com", AcmeDnsAllowFrom: tc.allowFrom}
+
+			if _, err := RegisterNewAccountWithDeps(cfg, store, "example.com", &mockLogger{}, mockClient); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			body, _ := io.ReadAll(mockClient.requests[0].Body)
+			if string(body) != tc.wantBody {
+				t.Errorf("Expected registration body %s, got %s", tc.wantBody, body)
+			}
+		})
+	}
+}
+
 func TestRegisterNewAccountWithDeps_ExistingAccount(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -548,3 +583,62 @@ func BenchmarkRegisterNewAccountWithDeps(b *testing.B) {
 		_, _ = RegisterNewAccountWithDeps(cfg, store, "*.example.com", mockLog, mockClient)
 	}
 }
+
+func TestAccountGranularity_BaseDomain(t *testing.T) {
+	var registrations atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registrations.Add(1)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"username": "user", "password": "secret", "fulldomain": "shared.auth.example.org", "subdomain": "shared"}`))
+	}))
+	defer server.Close()
+	t.Setenv(acmedns.EnvAPIBase, "")
+	t.Setenv(acmedns.EnvStoragePath, "")
+
+	store, err := NewAccountStore(filepath.Join(t.TempDir(), AcmeDNSAccountsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An account of its own created before the switch is kept
+	store.SetAccount("old.example.co.uk", AcmeDnsAccount{FullDomain: "old.auth.example.org"})
+	cfg := &Config{AcmeDnsServer: server.URL, AccountGranularity: AccountPerBaseDomain}
+
+	domains := []string{"www.example.co.uk", "*.api.example.co.uk", "old.example.co.uk"}
+	setupInfo, err := PreCheckAcmeDNSWithResolver(cfg, store, domains, staticResolver{
+		"_acme-challenge.old.example.co.uk": "old.auth.example.org",
+	})
+	if err != nil {
+		t.Fatalf("PreCheckAcmeDNS failed: %v", err)
+	}
+	if registrations.Load() != 1 {
+		t.Errorf("Expected one account for example.co.uk, got %d registrations", registrations.Load())
+	}
+	targets := make(map[string]string)
+	for _, info := range setupInfo {
+		targets[info.ChallengeDomain] = info.TargetDomain
+	}
+	if len(targets) != 2 || targets["_acme-challenge.www.example.co.uk"] != "shared.auth.example.org" || targets["_acme-challenge.api.example.co.uk"] != "shared.auth.example.org" {
+		t.Errorf("Expected a CNAME per name to the shared account, got %v", targets)
+	}
+	if _, ok := store.GetAccount("example.co.uk"); !ok {
+		t.Errorf("Expected the account under the registrable domain, got %v", store.GetAllAccounts())
+	}
+
+	// Lego finds the shared account for any name of the domain
+	account, err := (providerStorage{store: store, cfg: cfg}).Fetch(context.Background(), "shop.example.co.uk")
+	if err != nil || account.FullDomain != "shared.auth.example.org" {
+		t.Errorf("Expected the shared account for shop.example.co.uk, got %v (%v)", account, err)
+	}
+	if _, err := (providerStorage{store: store, cfg: &Config{}}).Fetch(context.Background(), "shop.example.co.uk"); err == nil {
+		t.Error("Expected no shared account with account_granularity domain")
+	}
+
+	// Names sharing an account are validated one after another
+	provider, err := newAcmeDNSChallengeProvider(context.Background(), cfg, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := provider.(sequentialProvider); !ok {
+		t.Errorf("Expected a sequential provider, got %T", provider)
+	}
+}
@@ -0,0 +1,128 @@
+package manager
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrCertificateExists is returned by AdoptCertificate if the storage
+// directory already holds a certificate of that name
+var ErrCertificateExists = errors.New("certificate already in storage")
+
+// AdoptedCertificate describes a certificate taken over by AdoptCertificate
+type AdoptedCertificate struct {
+	Name     string
+	Domains  []string
+	KeyType  string // Key type of the certificate, empty if it has no key_type equivalent
+	NotAfter time.Time
+}
+
+// OutcomeAdopted is recorded in the state file for adopted certificates
+const OutcomeAdopted = "adopted"
+
+// AdoptCertificate stores a certificate issued elsewhere under certName, so
+// the next renewal run takes it over. It checks that the key belongs to the
+// certificate and, if given, that the chain issued it. If chainPEM is empty
+// and certPEM holds a bundle, the certificates after the leaf are the chain.
+// The metadata is synthesized from the certificate.
+func AdoptCertificate(cfg *Config, certName string, certPEM, keyPEM, chainPEM []byte) (*AdoptedCertificate, error) {
+	paths := certinfo.PathsFor(cfg.CertStoragePath, certName)
+	if _, err := os.Stat(paths.Certificate); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrCertificateExists, paths.Certificate)
+	}
+
+	leafPEM, bundleChain := splitLeaf(certPEM)
+	if len(chainPEM) == 0 {
+		chainPEM = bundleChain
+	}
+	info, err := certinfo.Parse(leafPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parsing certificate: %w", err)
+	}
+	if len(info.DNSNames) == 0 {
+		return nil, errors.New("certificate has no DNS names")
+	}
+	if ok, err := certinfo.KeyMatches(info.Certificate, keyPEM); err != nil {
+		return nil, fmt.Errorf("checking private key: %w", err)
+	} else if !ok {
+		return nil, errors.New("private key does not belong to the certificate")
+	}
+	if len(chainPEM) > 0 {
+		issuer, err := certinfo.Parse(chainPEM)
+		if err != nil {
+			return nil, fmt.Errorf("parsing chain: %w", err)
+		}
+		if err := info.Certificate.CheckSignatureFrom(issuer.Certificate); err != nil {
+			return nil, fmt.Errorf("chain did not issue the certificate: %w", err)
+		}
+	}
+
+	adopted := &AdoptedCertificate{Name: certName, Domains: info.DNSNames, NotAfter: info.NotAfter}
+	if isValidKeyType(info.KeyAlgorithm) {
+		adopted.KeyType = info.KeyAlgorithm
+	}
+
+	// Like Lego with Bundle set, the certificate file holds the chain as well
+	bundle := leafPEM
+	if len(chainPEM) > 0 {
+		bundle = joinPEM(leafPEM, chainPEM)
+	}
+	resource := &certificate.Resource{
+		Domain:            info.DNSNames[0],
+		Certificate:       bundle,
+		PrivateKey:        keyPEM,
+		IssuerCertificate: chainPEM,
+	}
+	if err := saveCertificates(cfg, certName, resource, ""); err != nil {
+		return nil, err
+	}
+
+	certState := CertState{
+		Domains:    info.DNSNames,
+		KeyType:    info.KeyAlgorithm,
+		AcmeServer: cfg.ForCert(certName).AcmeServer,
+		LastResult: OutcomeAdopted,
+		LastRun:    time.Now().UTC(),
+	}
+	if err := UpdateCertState(cfg.CertStoragePath, certName, certState); err != nil {
+		DefaultLogger.Warnf("Warning: recording state of certificate %s: %v", certName, err)
+	}
+	return adopted, nil
+}
+
+// splitLeaf separates the first certificate of a PEM bundle from the rest
+func splitLeaf(bundle []byte) (leaf, chain []byte) {
+	block, rest := pem.Decode(bundle)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return bundle, nil
+	}
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		return bundle, nil
+	}
+	rest = bytes.TrimSpace(rest)
+	if len(rest) == 0 {
+		return pem.EncodeToMemory(block), nil
+	}
+	return pem.EncodeToMemory(block), rest
+}
+
+// AutoDomainsSnippet returns an auto_domains section with the given
+// certificates, for the user to add to the configuration
+func AutoDomainsSnippet(certs map[string]CertConfig) (string, error) {
+	data, err := yaml.Marshal(map[string]interface{}{
+		"auto_domains": map[string]interface{}{"certs": certs},
+	})
+	if err != nil {
+		return "", fmt.Errorf("generating auto_domains: %w", err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,158 @@
+package manager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+// newTestChain creates a CA and an ECDSA leaf certificate it issued, returning
+// the PEM encoded leaf, its key and the CA certificate
+func newTestChain(t *testing.T, domains []string) (certPEM, keyPEM, chainPEM []byte) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: domains[0]},
+		DNSNames:     domains,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(60 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		certcrypto.PEMEncode(key),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+}
+
+func TestAdoptCertificate(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir(), AcmeServer: "https://acme.invalid/directory"}
+	certPEM, keyPEM, chainPEM := newTestChain(t, []string{"example.com", "www.example.com"})
+
+	adopted, err := AdoptCertificate(cfg, "web", certPEM, keyPEM, chainPEM)
+	if err != nil {
+		t.Fatalf("AdoptCertificate failed: %v", err)
+	}
+	if !reflect.DeepEqual(adopted.Domains, []string{"example.com", "www.example.com"}) || adopted.KeyType != "ec256" {
+		t.Errorf("Unexpected result %+v", adopted)
+	}
+
+	artifact, err := certinfo.LoadArtifact(cfg.CertStoragePath, "web")
+	if err != nil || !artifact.Complete() || !artifact.KeyMatches || !artifact.HasIssuer {
+		t.Errorf("Expected complete stored certificate, got %+v (%v)", artifact, err)
+	}
+	resource, err := LoadCertificateResource(cfg, "web")
+	if err != nil {
+		t.Fatalf("Loading metadata failed: %v", err)
+	}
+	if resource.Domain != "example.com" {
+		t.Errorf("Expected metadata domain example.com, got %s", resource.Domain)
+	}
+
+	state, err := LoadState(cfg.CertStoragePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := state.Certificates["web"]; got.LastResult != OutcomeAdopted || got.AcmeServer != cfg.AcmeServer {
+		t.Errorf("Expected adopted state, got %+v", got)
+	}
+
+	if _, err := AdoptCertificate(cfg, "web", certPEM, keyPEM, chainPEM); !errors.Is(err, ErrCertificateExists) {
+		t.Errorf("Expected ErrCertificateExists, got %v", err)
+	}
+}
+
+func TestAdoptCertificate_Bundle(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir()}
+	certPEM, keyPEM, chainPEM := newTestChain(t, []string{"example.com"})
+
+	if _, err := AdoptCertificate(cfg, "web", append(certPEM, chainPEM...), keyPEM, nil); err != nil {
+		t.Fatalf("AdoptCertificate failed: %v", err)
+	}
+	issuer, err := os.ReadFile(certinfo.PathsFor(cfg.CertStoragePath, "web").Issuer)
+	if err != nil {
+		t.Fatalf("Expected issuer file from bundle: %v", err)
+	}
+	if strings.TrimSpace(string(issuer)) != strings.TrimSpace(string(chainPEM)) {
+		t.Error("Expected issuer file to hold the chain of the bundle")
+	}
+}
+
+func TestAdoptCertificate_Mismatch(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir()}
+	certPEM, keyPEM, chainPEM := newTestChain(t, []string{"example.com"})
+	otherCert, otherKey, otherChain := newTestChain(t, []string{"example.com"})
+
+	tests := []struct {
+		name             string
+		cert, key, chain []byte
+		wantErrSubstring string
+	}{
+		{"foreign key", certPEM, otherKey, chainPEM, "does not belong"},
+		{"foreign chain", certPEM, keyPEM, otherChain, "chain did not issue"},
+		{"no certificate", keyPEM, keyPEM, nil, "parsing certificate"},
+		{"bad key", otherCert, []byte("junk"), nil, "checking private key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := AdoptCertificate(cfg, "web", tt.cert, tt.key, tt.chain)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErrSubstring) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErrSubstring, err)
+			}
+		})
+	}
+	if _, err := os.Stat(certinfo.PathsFor(cfg.CertStoragePath, "web").Certificate); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing stored, got %v", err)
+	}
+}
+
+func TestAutoDomainsSnippet(t *testing.T) {
+	snippet, err := AutoDomainsSnippet(map[string]CertConfig{"web": {Domains: []string{"example.com"}, KeyType: "ec256"}})
+	if err != nil {
+		t.Fatalf("AutoDomainsSnippet failed: %v", err)
+	}
+	want := "auto_domains:\n    certs:\n        web:\n            domains:\n                - example.com\n            key_type: ec256\n"
+	if snippet != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, snippet)
+	}
+}
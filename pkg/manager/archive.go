@@ -0,0 +1,361 @@
+package manager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+// ArchiveDirName is the directory below the storage path keeping the current
+// and the replaced certificate generations in '<cert-name>/<timestamp>/'
+const ArchiveDirName = "archive"
+
+// archiveTimeFormat is the UTC timestamp naming a generation directory. It has
+// microseconds, so a rollback right after a renewal gets a directory of its own.
+const archiveTimeFormat = "20060102T150405.000000Z"
+
+// archivedKeyFile is the file in a generation directory recording where the
+// private key of the generation was archived, if it was kept in key_output_dir
+const archivedKeyFile = "key_file"
+
+// ErrNoGeneration is returned by RollbackCertificate if nothing is archived
+var ErrNoGeneration = errors.New("no archived generation")
+
+// Generation is one archived set of certificate files
+type Generation struct {
+	CertName string
+	Time     time.Time // When the generation was archived
+	Dir      string
+	NotAfter time.Time // Expiry of the archived certificate, zero if it cannot be read
+}
+
+// certificateFiles returns all files that make up a stored certificate
+func certificateFiles(paths certinfo.Paths) []string {
+	return []string{paths.Certificate, paths.PrivateKey, paths.Issuer, paths.Metadata, paths.PFX, paths.CombinedPEM, paths.FullChain, paths.TLSA, paths.TLSAJSON}
+}
+
+// archiveGeneration copies the current files of a certificate into a new
+// generation directory, with the files live_dir links to if it is set. It
+// does nothing if neither the archive nor live_dir is enabled or there is no
+// certificate yet.
+func archiveGeneration(cfg *Config, certName string) (*Generation, error) {
+	paths := certinfo.PathsFor(cfg.CertStoragePath, certName)
+	if cfg.KeepGenerations <= 0 && cfg.LiveDir == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(paths.Certificate); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	now := time.Now().UTC()
+	certDir := filepath.Join(cfg.CertStoragePath, ArchiveDirName, certName)
+	dir := filepath.Join(certDir, now.Format(archiveTimeFormat))
+	for {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			break
+		}
+		now = now.Add(time.Microsecond)
+		dir = filepath.Join(certDir, now.Format(archiveTimeFormat))
+	}
+	if err := os.MkdirAll(dir, DirPermissions); err != nil {
+		return nil, fmt.Errorf("creating archive directory %s: %w", dir, err)
+	}
+
+	var archived []string
+	for _, src := range certificateFiles(paths) {
+		dst := filepath.Join(dir, filepath.Base(src))
+		copied, err := copyStorageFile(src, dst)
+		if err != nil {
+			return nil, err
+		}
+		if copied {
+			archived = append(archived, dst)
+		}
+	}
+	outputKey, err := archiveOutputKey(cfg, certName, dir)
+	if err != nil {
+		return nil, err
+	}
+	if outputKey != "" {
+		archived = append(archived, filepath.Join(dir, archivedKeyFile))
+	}
+	if cfg.LiveDir != "" {
+		written, err := writeLiveFiles(cfg, certName, dir, outputKey)
+		if err != nil {
+			return nil, err
+		}
+		archived = append(archived, written...)
+	}
+	recordManifest(cfg.CertStoragePath, archived...)
+	return &Generation{CertName: certName, Time: now, Dir: dir}, nil
+}
+
+// archiveCurrent returns the generation of the current files of a
+// certificate, archiving them unless the newest generation already holds
+// them. A certificate stored before it could be archived, e.g. by an older
+// version or -adopt, is archived before it is replaced this way.
+func archiveCurrent(cfg *Config, certName string) (*Generation, error) {
+	generations, err := ListGenerations(cfg, certName)
+	if err != nil {
+		return nil, err
+	}
+	if n := len(generations); n > 0 && isCurrentGeneration(cfg, generations[n-1]) {
+		return &generations[n-1], nil
+	}
+	gen, err := archiveGeneration(cfg, certName)
+	if err != nil || gen == nil {
+		return gen, err
+	}
+	DefaultLogger.Infof("Archived the certificate of %s to %s", certName, gen.Dir)
+	return gen, nil
+}
+
+// isCurrentGeneration reports whether a generation holds the certificate
+// that is in place
+func isCurrentGeneration(cfg *Config, gen Generation) bool {
+	current, err := os.ReadFile(certinfo.PathsFor(cfg.CertStoragePath, gen.CertName).Certificate)
+	if err != nil {
+		return false
+	}
+	archived, err := os.ReadFile(filepath.Join(gen.Dir, gen.CertName+".crt"))
+	return err == nil && bytes.Equal(current, archived)
+}
+
+// archiveOutputKey copies a private key kept in key_output_dir to
+// '.archive/<cert-name>/<timestamp>/' in that directory, so it stays on the
+// volume it was put on, and records the copy in the generation directory
+// genDir. It returns the path of the copy, empty if nothing was archived.
+func archiveOutputKey(cfg *Config, certName, genDir string) (string, error) {
+	state, err := LoadState(cfg.CertStoragePath)
+	if err != nil {
+		return "", err
+	}
+	keyFile := state.Certificates[certName].KeyFile
+	if keyFile == "" {
+		return "", nil
+	}
+	dir := filepath.Join(filepath.Dir(keyFile), "."+ArchiveDirName, certName, filepath.Base(genDir))
+	if err := os.MkdirAll(dir, DirPermissions); err != nil {
+		return "", fmt.Errorf("creating key archive directory %s: %w", dir, err)
+	}
+	dst := filepath.Join(dir, filepath.Base(keyFile))
+	if copied, err := copyStorageFile(keyFile, dst); err != nil || !copied {
+		return "", err
+	}
+	pointer := filepath.Join(genDir, archivedKeyFile)
+	if err := os.WriteFile(pointer, []byte(dst+"\n"), PrivateKeyPermissions); err != nil {
+		return "", fmt.Errorf("writing %s: %w", pointer, err)
+	}
+	return dst, nil
+}
+
+// readArchivedKey returns the private key a generation archived in
+// key_output_dir, nil if its key was archived with the other files
+func readArchivedKey(genDir string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(genDir, archivedKeyFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading archived key location: %w", err)
+	}
+	keyFile := strings.TrimSpace(string(data))
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading archived private key: %w", err)
+	}
+	return keyPEM, nil
+}
+
+// removeArchivedKey removes the private key a generation archived in
+// key_output_dir together with the key archive directories left empty
+func removeArchivedKey(genDir string) error {
+	data, err := os.ReadFile(filepath.Join(genDir, archivedKeyFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("reading archived key location: %w", err)
+	}
+	dir := filepath.Dir(strings.TrimSpace(string(data)))
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("removing archived private key %s: %w", dir, err)
+	}
+	// Fails while other generations are archived, which is fine
+	certDir := filepath.Dir(dir)
+	if os.Remove(certDir) == nil {
+		_ = os.Remove(filepath.Dir(certDir))
+	}
+	return nil
+}
+
+// copyStorageFile copies a file keeping its permissions, a missing source is skipped
+func copyStorageFile(src, dst string) (bool, error) {
+	info, err := os.Stat(src)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("checking %s: %w", src, err)
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return false, fmt.Errorf("reading %s: %w", src, err)
+	}
+	if err := os.WriteFile(dst, data, info.Mode().Perm()); err != nil {
+		return false, fmt.Errorf("writing %s: %w", dst, err)
+	}
+	return true, nil
+}
+
+// ListGenerations returns the archived generations of a certificate, oldest first
+func ListGenerations(cfg *Config, certName string) ([]Generation, error) {
+	certDir := filepath.Join(cfg.CertStoragePath, ArchiveDirName, certName)
+	entries, err := os.ReadDir(certDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading archive directory: %w", err)
+	}
+
+	var generations []Generation
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		stamp, err := time.Parse(archiveTimeFormat, e.Name())
+		if err != nil {
+			continue
+		}
+		gen := Generation{CertName: certName, Time: stamp, Dir: filepath.Join(certDir, e.Name())}
+		if info, err := certinfo.Load(filepath.Join(gen.Dir, certName+".crt")); err == nil {
+			gen.NotAfter = info.NotAfter
+		}
+		generations = append(generations, gen)
+	}
+	sort.Slice(generations, func(i, j int) bool { return generations[i].Time.Before(generations[j].Time) })
+	return generations, nil
+}
+
+// pruneGenerations removes the oldest generations until the current one and
+// cfg.KeepGenerations replaced ones are left
+func pruneGenerations(cfg *Config, certName string) error {
+	keep := max(cfg.KeepGenerations, 0) + 1
+	generations, err := ListGenerations(cfg, certName)
+	if err != nil {
+		return err
+	}
+	for len(generations) > keep {
+		if err := removeGeneration(cfg, generations[0]); err != nil {
+			return err
+		}
+		generations = generations[1:]
+	}
+	return nil
+}
+
+// removeGeneration deletes a generation directory, its manifest entries and
+// the private key it archived in key_output_dir
+func removeGeneration(cfg *Config, gen Generation) error {
+	entries, err := os.ReadDir(gen.Dir)
+	if err != nil {
+		return fmt.Errorf("reading generation %s: %w", gen.Dir, err)
+	}
+	if err := removeArchivedKey(gen.Dir); err != nil {
+		return err
+	}
+	var removed []string
+	for _, e := range entries {
+		removed = append(removed, filepath.Join(gen.Dir, e.Name()))
+	}
+	if err := os.RemoveAll(gen.Dir); err != nil {
+		return fmt.Errorf("removing generation %s: %w", gen.Dir, err)
+	}
+	recordManifest(cfg.CertStoragePath, removed...)
+	return nil
+}
+
+// RollbackCertificate puts the generation archived before the current one
+// of a certificate back in place, e.g. when a renewed certificate breaks a
+// service. The replaced files are quarantined below failed/ and their
+// generation is removed from the archive, so repeated rollbacks go further
+// back.
+func RollbackCertificate(cfg *Config, certName string) (*Generation, error) {
+	generations, err := ListGenerations(cfg, certName)
+	if err != nil {
+		return nil, err
+	}
+	var current *Generation
+	if n := len(generations); n > 0 && isCurrentGeneration(cfg, generations[n-1]) {
+		current = &generations[n-1]
+		generations = generations[:n-1]
+	}
+	if len(generations) == 0 {
+		return nil, fmt.Errorf("%w for certificate %s", ErrNoGeneration, certName)
+	}
+	gen := generations[len(generations)-1]
+
+	if _, err := QuarantineArtifacts(cfg, certName); err != nil {
+		return nil, fmt.Errorf("moving current files of %s aside: %w", certName, err)
+	}
+
+	paths := certinfo.PathsFor(cfg.CertStoragePath, certName)
+	if err := os.MkdirAll(certinfo.CertificatesDir(cfg.CertStoragePath), DirPermissions); err != nil {
+		return nil, fmt.Errorf("creating certificates directory: %w", err)
+	}
+	var restored []string
+	for _, dst := range certificateFiles(paths) {
+		copied, err := copyStorageFile(filepath.Join(gen.Dir, filepath.Base(dst)), dst)
+		if err != nil {
+			return nil, err
+		}
+		if copied {
+			restored = append(restored, dst)
+		}
+	}
+	recordManifest(cfg.CertStoragePath, restored...)
+	if err := publishStorageFiles(cfg, certificateFiles(paths)...); err != nil {
+		return nil, fmt.Errorf("storing restored files of %s: %w", certName, err)
+	}
+	if certPEM, err := os.ReadFile(paths.Certificate); err == nil {
+		keyPEM, _ := os.ReadFile(paths.PrivateKey)
+		archivedKey, err := readArchivedKey(gen.Dir)
+		if err != nil {
+			return nil, err
+		}
+		if archivedKey != nil {
+			keyPEM = archivedKey
+		}
+		keyOutput := len(keyPEM) > 0 && keyInOutputDir(cfg, certName)
+		if archivedKey != nil && !keyOutput {
+			// key_output_dir was dropped from the configuration since
+			if err := writeStorageFile(cfg, paths.PrivateKey, keyPEM, true); err != nil {
+				return nil, fmt.Errorf("restoring private key of %s: %w", certName, err)
+			}
+		}
+		if err := saveOutputDirs(cfg, certName, certPEM, keyPEM); err != nil {
+			return nil, err
+		}
+		if keyOutput {
+			if err := removeStorageKey(cfg, certName); err != nil {
+				return nil, err
+			}
+		}
+		// The restored generation stays archived, live_dir links to it
+		if err := updateLiveLinks(cfg, certName, &gen); err != nil {
+			return nil, fmt.Errorf("updating live links of %s: %w", certName, err)
+		}
+	}
+
+	if current != nil {
+		if err := removeGeneration(cfg, *current); err != nil {
+			return nil, err
+		}
+	}
+	return &gen, nil
+}
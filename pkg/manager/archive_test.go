@@ -0,0 +1,177 @@
+package manager
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+// saveTestGeneration stores a fresh certificate for certName and returns its PEM
+func saveTestGeneration(t *testing.T, cfg *Config, certName string) []byte {
+	t.Helper()
+	certPEM, keyPEM, chainPEM := newTestChain(t, []string{certName + ".example.com"})
+	resource := &certificate.Resource{Domain: certName + ".example.com", Certificate: certPEM, PrivateKey: keyPEM, IssuerCertificate: chainPEM}
+	if err := saveCertificates(cfg, certName, resource, ""); err != nil {
+		t.Fatalf("saveCertificates failed: %v", err)
+	}
+	return certPEM
+}
+
+// assertCleanManifest fails if the storage directory differs from its manifest
+func assertCleanManifest(t *testing.T, storagePath string) {
+	t.Helper()
+	report, err := Fsck(storagePath, false)
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if len(report.Issues) != 0 {
+		t.Errorf("Expected manifest to match the storage directory, got %+v", report.Issues)
+	}
+}
+
+func TestArchiveGenerations(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir(), KeepGenerations: 2}
+
+	var saved [][]byte
+	for i := 0; i < 4; i++ {
+		saved = append(saved, saveTestGeneration(t, cfg, "web"))
+	}
+
+	generations, err := ListGenerations(cfg, "web")
+	if err != nil {
+		t.Fatalf("ListGenerations failed: %v", err)
+	}
+	// The current generation and the two it replaced
+	if len(generations) != 3 {
+		t.Fatalf("Expected 3 generations, got %d", len(generations))
+	}
+	for i, want := range saved[1:] {
+		archived, err := os.ReadFile(filepath.Join(generations[i].Dir, "web.crt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(archived, want) {
+			t.Errorf("Expected generation %d to hold certificate %d", i, i+1)
+		}
+	}
+	if generations[1].NotAfter.IsZero() {
+		t.Error("Expected expiry of the archived certificate")
+	}
+	for _, name := range []string{"web.key", "web.issuer.crt", "web.json"} {
+		if _, err := os.Stat(filepath.Join(generations[1].Dir, name)); err != nil {
+			t.Errorf("Expected %s in the generation: %v", name, err)
+		}
+	}
+	assertCleanManifest(t, cfg.CertStoragePath)
+}
+
+func TestArchiveGenerations_Disabled(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir()}
+	saveTestGeneration(t, cfg, "web")
+	saveTestGeneration(t, cfg, "web")
+
+	if _, err := os.Stat(filepath.Join(cfg.CertStoragePath, ArchiveDirName)); !os.IsNotExist(err) {
+		t.Errorf("Expected no archive directory, got %v", err)
+	}
+}
+
+func TestRollbackCertificate(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir(), KeepGenerations: 3}
+
+	if _, err := RollbackCertificate(cfg, "web"); !errors.Is(err, ErrNoGeneration) {
+		t.Errorf("Expected ErrNoGeneration, got %v", err)
+	}
+
+	first := saveTestGeneration(t, cfg, "web")
+	second := saveTestGeneration(t, cfg, "web")
+	saveTestGeneration(t, cfg, "web")
+
+	paths := certinfo.PathsFor(cfg.CertStoragePath, "web")
+	for _, want := range [][]byte{second, first} {
+		if _, err := RollbackCertificate(cfg, "web"); err != nil {
+			t.Fatalf("RollbackCertificate failed: %v", err)
+		}
+		current, err := os.ReadFile(paths.Certificate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(current, want) {
+			t.Error("Expected the previous certificate to be restored")
+		}
+		artifact, err := certinfo.LoadArtifact(cfg.CertStoragePath, "web")
+		if err != nil || !artifact.Complete() || !artifact.KeyMatches {
+			t.Errorf("Expected complete restored certificate, got %+v (%v)", artifact, err)
+		}
+	}
+
+	quarantined, err := ListQuarantined(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 2 {
+		t.Errorf("Expected the replaced files in quarantine twice, got %d", len(quarantined))
+	}
+	if _, err := RollbackCertificate(cfg, "web"); !errors.Is(err, ErrNoGeneration) {
+		t.Errorf("Expected ErrNoGeneration after using up the archive, got %v", err)
+	}
+	assertCleanManifest(t, cfg.CertStoragePath)
+}
+
+// TestRollbackCertificate_KeyOutputDir tests that generations keep a key from
+// key_output_dir on its volume and a rollback puts it back there
+func TestRollbackCertificate_KeyOutputDir(t *testing.T) {
+	dir := t.TempDir()
+	keyDir := filepath.Join(dir, "secure")
+	cfg := &Config{
+		CertStoragePath: filepath.Join(dir, "storage"),
+		KeepGenerations: 3,
+		AutoDomains: &AutoDomainsConfig{Certs: map[string]CertConfig{
+			"web": {Domains: []string{"web.example.com"}, KeyOutputDir: keyDir},
+		}},
+	}
+	first := saveTestGeneration(t, cfg, "web")
+	saveTestGeneration(t, cfg, "web")
+
+	generations, err := ListGenerations(cfg, "web")
+	if err != nil || len(generations) != 2 {
+		t.Fatalf("Expected two generations, got %d (%v)", len(generations), err)
+	}
+	keyArchive := func(gen Generation) string {
+		return filepath.Join(keyDir, ".archive", "web", filepath.Base(gen.Dir), "web.key")
+	}
+	for _, gen := range generations {
+		if _, err := os.Stat(filepath.Join(gen.Dir, "web.key")); !os.IsNotExist(err) {
+			t.Errorf("Expected no key in the storage archive, got %v", err)
+		}
+		if _, err := os.Stat(keyArchive(gen)); err != nil {
+			t.Errorf("Expected the key archived in key_output_dir: %v", err)
+		}
+	}
+
+	if _, err := RollbackCertificate(cfg, "web"); err != nil {
+		t.Fatalf("RollbackCertificate failed: %v", err)
+	}
+	paths := certinfo.PathsFor(cfg.CertStoragePath, "web")
+	if current, err := os.ReadFile(paths.Certificate); err != nil || !bytes.Equal(current, first) {
+		t.Errorf("Expected the first certificate to be restored: %v", err)
+	}
+	if _, err := os.Stat(paths.PrivateKey); !os.IsNotExist(err) {
+		t.Errorf("Expected no key in the storage directory, got %v", err)
+	}
+	artifact, err := LoadArtifact(cfg.CertStoragePath, "web")
+	if err != nil || !artifact.Complete() || !artifact.KeyMatches || artifact.Paths.PrivateKey != filepath.Join(keyDir, "web.key") {
+		t.Errorf("Expected the restored key in key_output_dir, got %+v (%v)", artifact, err)
+	}
+	if _, err := os.Stat(keyArchive(generations[1])); !os.IsNotExist(err) {
+		t.Errorf("Expected the key archive to be removed with its generation, got %v", err)
+	}
+	if _, err := os.Stat(keyArchive(generations[0])); err != nil {
+		t.Errorf("Expected the key of the restored generation to stay archived: %v", err)
+	}
+	assertCleanManifest(t, cfg.CertStoragePath)
+}
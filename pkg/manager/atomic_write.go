@@ -0,0 +1,87 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// BackupSuffix is appended to the accounts file to name the copy of its
+// previous content
+const BackupSuffix = ".bak"
+
+// writeFileAtomic writes data to a temporary file in the directory of path,
+// syncs it and renames it over path. A crash leaves either the old or the new
+// content in place, never a truncated file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return writeFileAtomicOwned(path, data, perm, -1, -1)
+}
+
+// writeFileAtomicOwned is writeFileAtomic giving the file to uid and gid
+// before it replaces path, -1 keeps the owner or group of the process
+func writeFileAtomicOwned(path string, data []byte, perm os.FileMode, uid, gid int) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	// Removing fails harmlessly once the file has been renamed
+	defer func() { _ = os.Remove(tmpName) }()
+
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if uid >= 0 || gid >= 0 {
+		if err := tmp.Chown(uid, gid); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir flushes a directory so a rename in it survives a crash. Not all
+// platforms can sync directories, so errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
+
+// backupFile copies the current content of path to path+BackupSuffix as a
+// private file of policy and returns the backup path, or an empty string if
+// path does not exist yet
+func backupFile(path string, policy *filePolicy) (string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("reading %s: %w", path, err)
+	}
+	backup := path + BackupSuffix
+	uid, gid := policy.owner()
+	if err := writeFileAtomicOwned(backup, data, policy.fileMode(true), uid, gid); err != nil {
+		return "", fmt.Errorf("writing backup %s: %w", backup, err)
+	}
+	return backup, nil
+}
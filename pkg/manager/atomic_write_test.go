@@ -0,0 +1,95 @@
+package manager
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.key")
+
+	if err := writeFileAtomic(path, []byte("first"), PrivateKeyPermissions); err != nil {
+		t.Fatalf("writeFileAtomic failed: %v", err)
+	}
+	if err := writeFileAtomic(path, []byte("second"), PrivateKeyPermissions); err != nil {
+		t.Fatalf("writeFileAtomic (replace) failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading file: %v", err)
+	}
+	if string(data) != "second" {
+		t.Errorf("content = %q, want %q", data, "second")
+	}
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("stat: %v", err)
+		}
+		if info.Mode().Perm() != PrivateKeyPermissions {
+			t.Errorf("mode = %04o, want %04o", info.Mode().Perm(), PrivateKeyPermissions)
+		}
+	}
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("reading dir: %v", err)
+	}
+	if len(entries) != 1 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("directory holds %v, want only test.key", names)
+	}
+}
+
+func TestWriteFileAtomic_MissingDir(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "test.crt")
+	if err := writeFileAtomic(path, []byte("data"), CertificatePermissions); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
+
+func TestSaveAccounts_Backup(t *testing.T) {
+	dir := t.TempDir()
+	storePath := filepath.Join(dir, AcmeDNSAccountsFile)
+	store, err := NewAccountStore(storePath)
+	if err != nil {
+		t.Fatalf("NewAccountStore failed: %v", err)
+	}
+
+	store.SetAccount("example.com", AcmeDnsAccount{Username: "first"})
+	if err := store.SaveAccounts(); err != nil {
+		t.Fatalf("SaveAccounts failed: %v", err)
+	}
+	if _, err := os.Stat(storePath + BackupSuffix); !os.IsNotExist(err) {
+		t.Errorf("expected no backup after the first save, got %v", err)
+	}
+
+	store.SetAccount("example.com", AcmeDnsAccount{Username: "second"})
+	if err := store.SaveAccounts(); err != nil {
+		t.Fatalf("SaveAccounts (second) failed: %v", err)
+	}
+
+	data, err := os.ReadFile(storePath + BackupSuffix)
+	if err != nil {
+		t.Fatalf("reading backup: %v", err)
+	}
+	var backup map[string]AcmeDnsAccount
+	if err := json.Unmarshal(data, &backup); err != nil {
+		t.Fatalf("parsing backup: %v", err)
+	}
+	if backup["example.com"].Username != "first" {
+		t.Errorf("backup username = %q, want %q", backup["example.com"].Username, "first")
+	}
+
+	// The backup is covered by the manifest
+	assertCleanManifest(t, dir)
+}
@@ -0,0 +1,236 @@
+package manager
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// AuditLogFile is the append-only log below the storage path recording every
+// issuance, renewal, revocation and account registration
+const AuditLogFile = "audit.jsonl"
+
+// Actions of audit records
+const (
+	AuditIssue           = "issue"
+	AuditRenew           = "renew"
+	AuditRevoke          = "revoke"
+	AuditRegisterAccount = "register-account"         // ACME account
+	AuditRegisterAcmeDNS = "register-acmedns-account" // acme-dns account of a domain
+)
+
+// Results of audit records
+const (
+	AuditSuccess = "success"
+	AuditFailed  = "failed"
+)
+
+// auditMu serializes appends of parallel certificate workers
+var auditMu sync.Mutex
+
+// AuditRecord is one line of the audit log. PrevHash chains the records: it
+// is the SHA-256 of the previous line, so editing or removing a record breaks
+// the link of the record after it.
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	User       string    `json:"user"`
+	Host       string    `json:"host"`
+	Action     string    `json:"action"`
+	CertName   string    `json:"cert_name,omitempty"`
+	Domains    []string  `json:"domains,omitempty"`
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+	AcmeServer string    `json:"acme_server,omitempty"` // The acme-dns server for acme-dns accounts
+	OrderURL   string    `json:"order_url,omitempty"`
+	Account    string    `json:"account,omitempty"` // ACME account URL or acme-dns fulldomain
+	PrevHash   string    `json:"prev_hash"`
+}
+
+// AuditLog is the content of the audit log
+type AuditLog struct {
+	Records []AuditRecord
+	// BrokenLine is the line of the first record not chained to the line
+	// before it, 0 if the chain is intact
+	BrokenLine int
+}
+
+// AppendAudit stamps record with the time, user and host and appends it to
+// the audit log of the storage directory, chained to the last record
+func AppendAudit(storagePath string, record AuditRecord) error {
+	_, err := appendAudit(storagePath, record)
+	return err
+}
+
+// appendAudit is AppendAudit returning the stamped record
+func appendAudit(storagePath string, record AuditRecord) (AuditRecord, error) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	record.Time = time.Now().UTC()
+	record.User = currentUser()
+	record.Host, _ = os.Hostname()
+
+	if err := os.MkdirAll(storagePath, DirPermissions); err != nil {
+		return record, fmt.Errorf("creating storage directory: %w", err)
+	}
+	path := filepath.Join(storagePath, AuditLogFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, PrivateKeyPermissions)
+	if err != nil {
+		return record, fmt.Errorf("opening audit log: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	last, err := lastLine(f)
+	if err != nil {
+		return record, fmt.Errorf("reading audit log %s: %w", path, err)
+	}
+	if len(last) > 0 {
+		record.PrevHash = lineHash(last)
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return record, err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return record, fmt.Errorf("writing audit log %s: %w", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		return record, fmt.Errorf("writing audit log %s: %w", path, err)
+	}
+	// The manifest lets -fsck also catch edits that rewrite the whole chain
+	recordManifest(storagePath, path)
+	return record, nil
+}
+
+// ReadAuditLog reads the audit log of the storage directory and checks the
+// chain of its records. A missing log has no records.
+func ReadAuditLog(storagePath string) (*AuditLog, error) {
+	path := filepath.Join(storagePath, AuditLogFile)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &AuditLog{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	log := &AuditLog{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	prevHash := ""
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Bytes()
+		var record AuditRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, fmt.Errorf("audit log %s line %d: %w", path, lineNo, err)
+		}
+		if record.PrevHash != prevHash && log.BrokenLine == 0 {
+			log.BrokenLine = lineNo
+		}
+		log.Records = append(log.Records, record)
+		prevHash = lineHash(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading audit log %s: %w", path, err)
+	}
+	return log, nil
+}
+
+// ForCert returns the records of a certificate
+func (l *AuditLog) ForCert(certName string) []AuditRecord {
+	var records []AuditRecord
+	for _, record := range l.Records {
+		if record.CertName == certName {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// recordAudit appends a record to the audit log of cfg. A failure is only
+// logged, the action it records already happened.
+func recordAudit(cfg *Config, record AuditRecord, actionErr error) {
+	if cfg.CertStoragePath == "" {
+		return
+	}
+	record.Result = AuditSuccess
+	if actionErr != nil {
+		record.Result = AuditFailed
+		record.Error = actionErr.Error()
+	}
+	if record.AcmeServer == "" {
+		record.AcmeServer = cfg.AcmeServer
+	}
+	stamped, err := appendAudit(cfg.CertStoragePath, record)
+	if err != nil {
+		DefaultLogger.Warnf("Warning: recording %s in the audit log: %v", record.Action, err)
+		return
+	}
+	// The sqlite backend keeps a copy for queries, the log stays authoritative
+	q, err := cfg.sqliteIndex()
+	if err != nil || q == nil {
+		return
+	}
+	if err := q.AppendHistory(stamped); err != nil {
+		DefaultLogger.Warnf("Warning: recording %s in the %s history: %v", record.Action, q.Name(), err)
+	}
+}
+
+// auditAction returns the audit action of a Lego run action
+func auditAction(action string) string {
+	if action == "init" {
+		return AuditIssue
+	}
+	return AuditRenew
+}
+
+// lineHash returns the hex SHA-256 of an audit log line without newline
+func lineHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// lastLine returns the last line of f without newline, nil for an empty
+// file. Only the end of the file is read.
+func lastLine(f *os.File) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	for chunk := int64(4096); ; chunk *= 4 {
+		if chunk > size {
+			chunk = size
+		}
+		buf := make([]byte, chunk)
+		if _, err := f.ReadAt(buf, size-chunk); err != nil {
+			return nil, err
+		}
+		trimmed := bytes.TrimRight(buf, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return trimmed[i+1:], nil
+		}
+		if chunk == size {
+			return trimmed, nil
+		}
+	}
+}
+
+// currentUser returns the name of the user running the process
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return fmt.Sprintf("uid %d", os.Getuid())
+}
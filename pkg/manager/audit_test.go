@@ -0,0 +1,98 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAppendAudit_Chain(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{CertStoragePath: dir, AcmeServer: "https://ca.example/directory"}
+	recordAudit(cfg, AuditRecord{Action: AuditRegisterAccount, Account: "https://ca.example/acct/1"}, nil)
+	recordAudit(cfg, AuditRecord{Action: AuditIssue, CertName: "web", Domains: []string{"example.com"}, OrderURL: "https://ca.example/order/1"}, nil)
+	recordAudit(cfg, AuditRecord{Action: AuditRenew, CertName: "api", Domains: []string{"api.example.com"}}, errors.New("rate limited"))
+	recordAudit(cfg, AuditRecord{Action: AuditRevoke, CertName: "web", Domains: []string{"example.com"}}, nil)
+
+	log, err := ReadAuditLog(dir)
+	if err != nil {
+		t.Fatalf("ReadAuditLog failed: %v", err)
+	}
+	if len(log.Records) != 4 || log.BrokenLine != 0 {
+		t.Fatalf("Expected 4 chained records, got %d, broken at %d", len(log.Records), log.BrokenLine)
+	}
+	first := log.Records[0]
+	if first.PrevHash != "" || first.User == "" || first.Time.IsZero() || first.AcmeServer != cfg.AcmeServer || first.Result != AuditSuccess {
+		t.Errorf("Unexpected first record %+v", first)
+	}
+	if failed := log.Records[2]; failed.Result != AuditFailed || failed.Error != "rate limited" {
+		t.Errorf("Expected the failure to be recorded, got %+v", failed)
+	}
+	web := log.ForCert("web")
+	if len(web) != 2 || web[0].OrderURL != "https://ca.example/order/1" || web[1].Action != AuditRevoke {
+		t.Errorf("Expected the issuance and revocation of web, got %+v", web)
+	}
+	if issues, _ := Fsck(dir, false); len(issues.Issues) != 0 {
+		t.Errorf("Expected the audit log in the manifest, got %+v", issues.Issues)
+	}
+}
+
+func TestReadAuditLog_Tampered(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{CertStoragePath: dir}
+	for _, name := range []string{"a", "b", "c"} {
+		recordAudit(cfg, AuditRecord{Action: AuditIssue, CertName: name}, nil)
+	}
+	path := filepath.Join(dir, AuditLogFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hiding that certificate b was issued breaks the link of c
+	edited := strings.Replace(string(data), `"cert_name":"b"`, `"cert_name":"x"`, 1)
+	if err := os.WriteFile(path, []byte(edited), 0600); err != nil {
+		t.Fatal(err)
+	}
+	log, err := ReadAuditLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.BrokenLine != 3 {
+		t.Errorf("Expected the chain to break at line 3, got %d", log.BrokenLine)
+	}
+
+	// So does removing a record
+	lines := strings.SplitAfter(string(data), "\n")
+	if err := os.WriteFile(path, []byte(lines[0]+lines[2]), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if log, err = ReadAuditLog(dir); err != nil || log.BrokenLine != 2 {
+		t.Errorf("Expected the chain to break at line 2, got %d (%v)", log.BrokenLine, err)
+	}
+}
+
+func TestReadAuditLog_Missing(t *testing.T) {
+	log, err := ReadAuditLog(t.TempDir())
+	if err != nil || len(log.Records) != 0 || log.BrokenLine != 0 {
+		t.Errorf("Expected an empty log, got %+v (%v)", log, err)
+	}
+}
+
+func TestLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	long := strings.Repeat("x", 10000)
+	if err := os.WriteFile(path, []byte("first\n"+long+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	if line, err := lastLine(f); err != nil || string(line) != long {
+		t.Errorf("Expected the long last line, got %d bytes (%v)", len(line), err)
+	}
+}
@@ -4,23 +4,35 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
 
+	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/go-acme/lego/v4/certificate"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
 )
 
+// CertMetadata is the content of the metadata file of a certificate: the
+// Lego resource and the CA that issued the certificate
+type CertMetadata struct {
+	certificate.Resource
+	AcmeServer string `json:"acme_server,omitempty"` // Directory URL it was ordered from, empty if issued elsewhere
+	Issuer     string `json:"issuer,omitempty"`      // Issuer DN of the leaf certificate
+}
+
 // saveCertificates saves the obtained certificate files using the certName.
-func saveCertificates(cfg *Config, certName string, resource *certificate.Resource) error {
-	certsDir := filepath.Join(cfg.CertStoragePath, "certificates") // Use renamed field
+// acmeServer is the directory URL the certificate was ordered from, empty
+// for certificates issued elsewhere.
+func saveCertificates(cfg *Config, certName string, resource *certificate.Resource, acmeServer string) error {
+	certsDir := certinfo.CertificatesDir(cfg.CertStoragePath)
 	if err := os.MkdirAll(certsDir, DirPermissions); err != nil {
 		return fmt.Errorf("creating certificates directory %s: %w", certsDir, err)
 	}
 
 	// Use the provided certName for filenames
-	certFile := filepath.Join(certsDir, certName+".crt")
-	keyFile := filepath.Join(certsDir, certName+".key")
-	issuerFile := filepath.Join(certsDir, certName+".issuer.crt")
-	jsonFile := filepath.Join(certsDir, certName+".json")
+	paths := certinfo.PathsFor(cfg.CertStoragePath, certName)
+	certFile := paths.Certificate
+	keyFile := paths.PrivateKey
+	issuerFile := paths.Issuer
+	jsonFile := paths.Metadata
 
 	// Ensure resource.Domain is set correctly, use certName if primary domain isn't obvious
 	// Lego usually sets resource.Domain to the first domain in the request.
@@ -29,21 +41,37 @@ func saveCertificates(cfg *Config, certName string, resource *certificate.Resour
 		resource.Domain = certName // Or maybe the first domain from the request? Let's stick to certName for consistency.
 	}
 
-	err := os.WriteFile(certFile, resource.Certificate, CertificatePermissions)
+	// Keep the generation being replaced for -rollback, a failure must not block the renewal
+	if _, err := archiveCurrent(cfg, certName); err != nil {
+		DefaultLogger.Warnf("Warning: archiving previous generation of %s: %v", certName, err)
+	}
+
+	err := writeStorageFile(cfg, certFile, resource.Certificate, false)
 	if err != nil {
 		return fmt.Errorf("writing certificate file %s: %w", certFile, err)
 	}
 	DefaultLogger.Infof("Saved certificate to %s", certFile)
 
-	err = os.WriteFile(keyFile, resource.PrivateKey, PrivateKeyPermissions)
-	if err != nil {
-		return fmt.Errorf("writing private key file %s: %w", keyFile, err)
+	keyOutput := len(resource.PrivateKey) > 0 && keyInOutputDir(cfg, certName)
+	switch {
+	case keyOutput:
+		// saveOutputDirs writes the key to key_output_dir, the stored one goes afterwards
+	case len(resource.PrivateKey) > 0:
+		err = writeStorageFile(cfg, keyFile, resource.PrivateKey, true)
+		if err != nil {
+			return fmt.Errorf("writing private key file %s: %w", keyFile, err)
+		}
+		DefaultLogger.Infof("Saved private key to %s", keyFile)
+	default:
+		// Issued for an external key (csr_path), a key left from before would not match
+		if err := deleteStorageFile(cfg, keyFile); err != nil {
+			return fmt.Errorf("removing private key file %s: %w", keyFile, err)
+		}
 	}
-	DefaultLogger.Infof("Saved private key to %s", keyFile)
 
 	// Save issuer certificate if present
 	if len(resource.IssuerCertificate) > 0 {
-		err = os.WriteFile(issuerFile, resource.IssuerCertificate, CertificatePermissions)
+		err = writeStorageFile(cfg, issuerFile, resource.IssuerCertificate, false)
 		if err != nil {
 			// Non-fatal, just log
 			DefaultLogger.Warnf("Warning: writing issuer certificate file %s: %v", issuerFile, err)
@@ -53,36 +81,101 @@ func saveCertificates(cfg *Config, certName string, resource *certificate.Resour
 	}
 
 	// Save metadata
-	jsonBytes, err := json.MarshalIndent(resource, "", "  ")
+	metadata := CertMetadata{Resource: *resource, AcmeServer: acmeServer}
+	if chain, parseErr := certcrypto.ParsePEMBundle(resource.Certificate); parseErr == nil {
+		metadata.Issuer = chain[0].Issuer.String()
+	}
+	jsonBytes, err := json.MarshalIndent(&metadata, "", "  ")
 	if err != nil {
 		// Use certName in the error message
 		return fmt.Errorf("marshalling certificate metadata for %s: %w", certName, err)
 	}
-	err = os.WriteFile(jsonFile, jsonBytes, PrivateKeyPermissions)
+	err = writeStorageFile(cfg, jsonFile, jsonBytes, true)
 	if err != nil {
 		return fmt.Errorf("writing certificate metadata file %s: %w", jsonFile, err)
 	}
 	DefaultLogger.Infof("Saved certificate metadata to %s", jsonFile)
 
+	if err := saveOutputFormats(cfg, certName, resource); err != nil {
+		return err
+	}
+	if err := saveTLSARecords(cfg, certName, resource); err != nil {
+		return err
+	}
+	if err := saveOutputDirs(cfg, certName, resource.Certificate, resource.PrivateKey); err != nil {
+		return err
+	}
+	if keyOutput {
+		if err := removeStorageKey(cfg, certName); err != nil {
+			return err
+		}
+	}
+	// live_dir links to the new generation, without it only -rollback misses it
+	gen, err := archiveCurrent(cfg, certName)
+	if err != nil && cfg.LiveDir == "" {
+		DefaultLogger.Warnf("Warning: archiving the certificate of %s: %v", certName, err)
+	} else if err != nil {
+		return fmt.Errorf("archiving the certificate of %s: %w", certName, err)
+	}
+	if err := updateLiveLinks(cfg, certName, gen); err != nil {
+		return fmt.Errorf("updating live links of %s: %w", certName, err)
+	}
+	// Only after the switch, live_dir may still link to the oldest generation
+	if gen != nil {
+		if err := pruneGenerations(cfg, certName); err != nil {
+			DefaultLogger.Warnf("Warning: removing old generations of %s: %v", certName, err)
+		}
+	}
+
+	recordManifest(cfg.CertStoragePath, certificateFiles(paths)...)
 	return nil
 }
 
-// LoadCertificateResource loads the certificate metadata from the JSON file.
-// Exported function. Accepts certName instead of domain.
-func LoadCertificateResource(cfg *Config, certName string) (*certificate.Resource, error) {
-	jsonFile := filepath.Join(cfg.CertStoragePath, "certificates", fmt.Sprintf("%s.json", certName)) // Use renamed field
-
-	if _, err := os.Stat(jsonFile); os.IsNotExist(err) {
-		// It's okay if the file doesn't exist (e.g., for 'init' action), return specific error?
-		// Or let the caller handle os.IsNotExist. Let's return the error.
-		return nil, err // Return the os.IsNotExist error
-	} else if err != nil {
-		// Other stat error
-		return nil, fmt.Errorf("checking certificate metadata file %s: %w", jsonFile, err)
+// LoadCertMetadata reads the metadata file of a certificate through the
+// configured store
+func LoadCertMetadata(cfg *Config, certName string) (*CertMetadata, error) {
+	store, err := cfg.Store()
+	if err != nil {
+		return nil, err
+	}
+	jsonFile := certinfo.PathsFor(cfg.CertStoragePath, certName).Metadata
+	key, err := manifestKey(cfg.CertStoragePath, jsonFile)
+	if err != nil {
+		return nil, err
+	}
+	data, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	var metadata CertMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("parsing certificate metadata file %s: %w", jsonFile, err)
 	}
+	return &metadata, nil
+}
 
-	data, err := os.ReadFile(jsonFile)
+// LoadCertificateResource loads the certificate metadata from the JSON file.
+// Exported function. Accepts certName instead of domain. The files are read
+// through the configured store, so a remote backend has the last word, the
+// private key from key_output_dir if it is kept there.
+func LoadCertificateResource(cfg *Config, certName string) (*certificate.Resource, error) {
+	store, err := cfg.Store()
 	if err != nil {
+		return nil, err
+	}
+	key := func(path string) string {
+		k, _ := manifestKey(cfg.CertStoragePath, path)
+		return k
+	}
+	paths := certinfo.PathsFor(cfg.CertStoragePath, certName)
+	jsonFile := paths.Metadata
+
+	data, err := store.Get(key(jsonFile))
+	if os.IsNotExist(err) {
+		// It's okay if the file doesn't exist (e.g., for 'init' action), let
+		// the caller handle os.IsNotExist
+		return nil, err
+	} else if err != nil {
 		return nil, fmt.Errorf("reading certificate metadata file %s: %w", jsonFile, err)
 	}
 
@@ -93,8 +186,7 @@ func LoadCertificateResource(cfg *Config, certName string) (*certificate.Resourc
 	}
 
 	// We also need to load the private key associated with the certificate
-	keyFile := filepath.Join(cfg.CertStoragePath, "certificates", fmt.Sprintf("%s.key", certName)) // Use renamed field
-	keyBytes, err := os.ReadFile(keyFile)
+	keyBytes, keyFile, err := readPrivateKey(cfg, certName)
 	if err != nil {
 		// If the key is missing, that's a problem for renewal
 		return nil, fmt.Errorf("reading certificate private key file %s: %w", keyFile, err)
@@ -102,8 +194,8 @@ func LoadCertificateResource(cfg *Config, certName string) (*certificate.Resourc
 	resource.PrivateKey = keyBytes // Lego expects the raw bytes here for renewal
 
 	// Load the actual certificate file content too
-	certFile := filepath.Join(cfg.CertStoragePath, "certificates", fmt.Sprintf("%s.crt", certName)) // Use renamed field
-	certBytes, err := os.ReadFile(certFile)
+	certFile := paths.Certificate
+	certBytes, err := store.Get(key(certFile))
 	if err != nil {
 		// If the cert file is missing, also a problem
 		return nil, fmt.Errorf("reading certificate file %s: %w", certFile, err)
@@ -112,3 +204,15 @@ func LoadCertificateResource(cfg *Config, certName string) (*certificate.Resourc
 
 	return &resource, nil
 }
+
+// removeStorageKey deletes the private key in the storage directory of a
+// certificate whose key is kept in key_output_dir
+func removeStorageKey(cfg *Config, certName string) error {
+	keyFile := certinfo.PathsFor(cfg.CertStoragePath, certName).PrivateKey
+	if err := deleteStorageFile(cfg, keyFile); err != nil {
+		return fmt.Errorf("removing private key file %s: %w", keyFile, err)
+	}
+	recordManifest(cfg.CertStoragePath, keyFile)
+	DefaultLogger.Infof("The private key of %s is kept in key_output_dir only", certName)
+	return nil
+}
@@ -33,7 +33,7 @@ func TestSaveCertificates_Success(t *testing.T) {
 	testCert := createCompleteCertificateResource()
 
 	// Test saving certificates
-	err := saveCertificates(cfg, certName, testCert)
+	err := saveCertificates(cfg, certName, testCert, "")
 	if err != nil {
 		t.Fatalf("Failed to save certificates: %v", err)
 	}
@@ -115,7 +115,7 @@ func TestSaveCertificates_EmptyDomain(t *testing.T) {
 	testCert.Domain = "" // Empty domain to test the warning path
 
 	// Test saving certificates with empty domain
-	err := saveCertificates(cfg, certName, testCert)
+	err := saveCertificates(cfg, certName, testCert, "")
 	if err != nil {
 		t.Fatalf("Failed to save certificates: %v", err)
 	}
@@ -148,7 +148,7 @@ func TestSaveCertificates_NoIssuerCertificate(t *testing.T) {
 	testCert.IssuerCertificate = nil // No issuer certificate
 
 	// Test saving certificates without issuer certificate
-	err := saveCertificates(cfg, certName, testCert)
+	err := saveCertificates(cfg, certName, testCert, "")
 	if err != nil {
 		t.Fatalf("Failed to save certificates: %v", err)
 	}
@@ -181,7 +181,7 @@ func TestSaveCertificates_DirectoryCreationError(t *testing.T) {
 	testCert := createCompleteCertificateResource()
 
 	// Test saving certificates when directory creation fails
-	err = saveCertificates(cfg, certName, testCert)
+	err = saveCertificates(cfg, certName, testCert, "")
 	if err == nil {
 		t.Fatal("Expected error when directory creation fails")
 	}
@@ -216,7 +216,7 @@ func TestSaveCertificates_FileWriteError(t *testing.T) {
 	}
 
 	// Test saving certificates when file writing fails
-	err = saveCertificates(cfg, certName, testCert)
+	err = saveCertificates(cfg, certName, testCert, "")
 	if err == nil {
 		t.Fatal("Expected error when file writing fails")
 	}
@@ -236,7 +236,7 @@ func TestLoadCertificateResource_Comprehensive(t *testing.T) {
 	originalCert := createCompleteCertificateResource()
 
 	// First save the certificate
-	err := saveCertificates(cfg, certName, originalCert)
+	err := saveCertificates(cfg, certName, originalCert, "")
 	if err != nil {
 		t.Fatalf("Failed to save certificate for test: %v", err)
 	}
@@ -299,7 +299,7 @@ func TestLoadCertificateResource_MissingPrivateKey(t *testing.T) {
 	originalCert := createCompleteCertificateResource()
 
 	// Save certificate normally
-	err := saveCertificates(cfg, certName, originalCert)
+	err := saveCertificates(cfg, certName, originalCert, "")
 	if err != nil {
 		t.Fatalf("Failed to save certificate: %v", err)
 	}
@@ -332,7 +332,7 @@ func TestLoadCertificateResource_MissingCertificateFile(t *testing.T) {
 	originalCert := createCompleteCertificateResource()
 
 	// Save certificate normally
-	err := saveCertificates(cfg, certName, originalCert)
+	err := saveCertificates(cfg, certName, originalCert, "")
 	if err != nil {
 		t.Fatalf("Failed to save certificate: %v", err)
 	}
@@ -365,7 +365,7 @@ func TestLoadCertificateResource_CorruptedJSON(t *testing.T) {
 	originalCert := createCompleteCertificateResource()
 
 	// Save certificate normally
-	err := saveCertificates(cfg, certName, originalCert)
+	err := saveCertificates(cfg, certName, originalCert, "")
 	if err != nil {
 		t.Fatalf("Failed to save certificate: %v", err)
 	}
@@ -433,7 +433,7 @@ func BenchmarkSaveCertificates(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		certName := "bench-cert-" + string(rune(i))
-		_ = saveCertificates(cfg, certName, testCert)
+		_ = saveCertificates(cfg, certName, testCert, "")
 	}
 }
 
@@ -447,7 +447,7 @@ func BenchmarkLoadCertificateResource(b *testing.B) {
 	certName := "bench-load-cert"
 
 	// Pre-save the certificate
-	err := saveCertificates(cfg, certName, testCert)
+	err := saveCertificates(cfg, certName, testCert, "")
 	if err != nil {
 		b.Fatalf("Failed to save certificate for benchmark: %v", err)
 	}
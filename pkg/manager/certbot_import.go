@@ -0,0 +1,281 @@
+package manager
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/registration"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+// CertbotImport is the result of ImportCertbot
+type CertbotImport struct {
+	Certificates []CertbotCertificate
+	Accounts     []CertbotAccount
+}
+
+// CertbotCertificate describes one imported certbot lineage
+type CertbotCertificate struct {
+	Name       string
+	Domains    []string
+	KeyType    string // Key type of the certificate, empty if it has no key_type equivalent
+	AcmeServer string
+	Skipped    string // Why the lineage was not imported, empty if it was
+}
+
+// CertbotAccount describes one imported certbot ACME account
+type CertbotAccount struct {
+	AcmeServer string
+	AccountURL string
+	Skipped    string // Why the account was not imported, empty if it was
+}
+
+// certbotRenewal is the part of a certbot renewal/<name>.conf file we use
+type certbotRenewal struct {
+	cert, privkey, chain string
+	server, account      string
+}
+
+// certbotRegistration is the content of a certbot regr.json file
+type certbotRegistration struct {
+	Body acme.Account `json:"body"`
+	URI  string       `json:"uri"`
+}
+
+// ImportCertbot converts a certbot configuration directory (usually
+// /etc/letsencrypt) into the storage layout of cfg. Every lineage with a
+// renewal configuration is copied to the certificates directory, and the ACME
+// accounts they use are converted to the account layout, so renewals keep the
+// existing registration. Certificates and accounts already present in the
+// storage directory are left alone and reported as skipped.
+func ImportCertbot(cfg *Config, certbotDir string) (*CertbotImport, error) {
+	confs, err := filepath.Glob(filepath.Join(certbotDir, "renewal", "*.conf"))
+	if err != nil {
+		return nil, fmt.Errorf("listing certbot renewal configurations: %w", err)
+	}
+	if len(confs) == 0 {
+		return nil, fmt.Errorf("no renewal configurations found in %s", filepath.Join(certbotDir, "renewal"))
+	}
+	sort.Strings(confs)
+
+	result := &CertbotImport{}
+	accounts := make(map[string]string) // ACME server -> certbot account ID
+	for _, conf := range confs {
+		name := strings.TrimSuffix(filepath.Base(conf), ".conf")
+		renewal, err := parseCertbotRenewal(conf)
+		if err != nil {
+			return nil, err
+		}
+		imported := importCertbotCertificate(cfg, certbotDir, name, renewal)
+		result.Certificates = append(result.Certificates, imported)
+		if renewal.server != "" && renewal.account != "" {
+			if _, seen := accounts[renewal.server]; !seen {
+				accounts[renewal.server] = renewal.account
+			}
+		}
+	}
+
+	servers := make([]string, 0, len(accounts))
+	for server := range accounts {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+	for _, server := range servers {
+		result.Accounts = append(result.Accounts, importCertbotAccount(cfg, certbotDir, server, accounts[server]))
+	}
+	return result, nil
+}
+
+// parseCertbotRenewal reads the file locations, ACME server and account of a
+// certbot renewal configuration
+func parseCertbotRenewal(path string) (*certbotRenewal, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading certbot renewal configuration: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	renewal := &certbotRenewal{}
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.Trim(line, "[]")
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch section + "." + key {
+		case ".cert":
+			renewal.cert = value
+		case ".privkey":
+			renewal.privkey = value
+		case ".chain":
+			renewal.chain = value
+		case "renewalparams.server":
+			renewal.server = value
+		case "renewalparams.account":
+			renewal.account = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading certbot renewal configuration %s: %w", path, err)
+	}
+	return renewal, nil
+}
+
+// readCertbotFile reads a file of a lineage. The live/<name>/ copy below the
+// given directory is preferred, so trees copied from another host work even
+// though the renewal configuration holds absolute paths.
+func readCertbotFile(certbotDir, name, file, configured string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(certbotDir, "live", name, file))
+	if err == nil || configured == "" {
+		return data, err
+	}
+	return os.ReadFile(configured)
+}
+
+// importCertbotCertificate copies one lineage into the storage directory
+func importCertbotCertificate(cfg *Config, certbotDir, name string, renewal *certbotRenewal) CertbotCertificate {
+	imported := CertbotCertificate{Name: name, AcmeServer: renewal.server}
+	if _, err := os.Stat(certinfo.PathsFor(cfg.CertStoragePath, name).Certificate); err == nil {
+		imported.Skipped = ErrCertificateExists.Error()
+		return imported
+	}
+
+	certPEM, err := readCertbotFile(certbotDir, name, "cert.pem", renewal.cert)
+	if err != nil {
+		imported.Skipped = fmt.Sprintf("reading certificate: %v", err)
+		return imported
+	}
+	keyPEM, err := readCertbotFile(certbotDir, name, "privkey.pem", renewal.privkey)
+	if err != nil {
+		imported.Skipped = fmt.Sprintf("reading private key: %v", err)
+		return imported
+	}
+	// The chain is optional, without it the certificate is stored on its own
+	chainPEM, _ := readCertbotFile(certbotDir, name, "chain.pem", renewal.chain)
+
+	// The state records the server the lineage was issued from
+	lineageCfg := *cfg
+	if renewal.server != "" {
+		lineageCfg.AcmeServer = renewal.server
+	}
+	adopted, err := AdoptCertificate(&lineageCfg, name, certPEM, keyPEM, chainPEM)
+	if err != nil {
+		imported.Skipped = err.Error()
+		return imported
+	}
+	imported.Domains = adopted.Domains
+	imported.KeyType = adopted.KeyType
+	return imported
+}
+
+// importCertbotAccount converts the certbot account with the given ID for an
+// ACME server. Certbot stores the key as JWK, it is written as PEM like the
+// keys of accounts registered by the manager.
+func importCertbotAccount(cfg *Config, certbotDir, server, accountID string) CertbotAccount {
+	imported := CertbotAccount{AcmeServer: server}
+	serverCfg := *cfg
+	serverCfg.AcmeServer = server
+	serverDir, err := accountServerDir(&serverCfg)
+	if err != nil {
+		imported.Skipped = err.Error()
+		return imported
+	}
+	if _, err := os.Stat(filepath.Join(serverDir, "account.json")); err == nil {
+		imported.Skipped = "account already in storage"
+		return imported
+	}
+
+	accountDir, err := certbotAccountDir(certbotDir, server, accountID)
+	if err != nil {
+		imported.Skipped = err.Error()
+		return imported
+	}
+	keyData, err := os.ReadFile(filepath.Join(accountDir, "private_key.json"))
+	if err != nil {
+		imported.Skipped = fmt.Sprintf("reading account key: %v", err)
+		return imported
+	}
+	var jwk jose.JSONWebKey
+	if err := json.Unmarshal(keyData, &jwk); err != nil || jwk.IsPublic() {
+		imported.Skipped = "account key is not a private JWK"
+		return imported
+	}
+	regData, err := os.ReadFile(filepath.Join(accountDir, "regr.json"))
+	if err != nil {
+		imported.Skipped = fmt.Sprintf("reading account registration: %v", err)
+		return imported
+	}
+	var regr certbotRegistration
+	if err := json.Unmarshal(regData, &regr); err != nil || regr.URI == "" {
+		imported.Skipped = "account registration has no account URL"
+		return imported
+	}
+	imported.AccountURL = regr.URI
+
+	keysDir := filepath.Join(serverDir, cfg.Email, "keys")
+	if err := os.MkdirAll(keysDir, DirPermissions); err != nil {
+		imported.Skipped = fmt.Sprintf("creating keys directory: %v", err)
+		return imported
+	}
+	keyFile := filepath.Join(keysDir, cfg.Email+".key")
+	if err := writeStorageFile(cfg, keyFile, certcrypto.PEMEncode(jwk.Key), true); err != nil {
+		imported.Skipped = fmt.Sprintf("writing account key: %v", err)
+		return imported
+	}
+	recordManifest(cfg.CertStoragePath, keyFile)
+
+	if regr.Body.Status == "" {
+		regr.Body.Status = acme.StatusValid
+	}
+	user := &MyUser{Email: cfg.Email, Registration: &registration.Resource{URI: regr.URI, Body: regr.Body}}
+	if err := saveUser(&serverCfg, user); err != nil {
+		imported.Skipped = err.Error()
+	}
+	return imported
+}
+
+// certbotAccountDir finds accounts/<server host and path>/<id> below the certbot directory
+func certbotAccountDir(certbotDir, server, accountID string) (string, error) {
+	hostPath := strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	dir := filepath.Join(certbotDir, "accounts", filepath.FromSlash(strings.TrimSuffix(hostPath, "/")), accountID)
+	if _, err := os.Stat(dir); err != nil {
+		return "", fmt.Errorf("account %s not found: %w", accountID, err)
+	}
+	return dir, nil
+}
+
+// AutoDomainsYAML returns an auto_domains section for the imported
+// certificates, skipped ones are left out. The ACME server is only set where
+// it differs from the one of cfg.
+func (imp *CertbotImport) AutoDomainsYAML(cfg *Config) (string, error) {
+	certs := make(map[string]CertConfig)
+	for _, c := range imp.Certificates {
+		if c.Skipped != "" {
+			continue
+		}
+		certCfg := CertConfig{Domains: c.Domains, KeyType: c.KeyType}
+		if c.AcmeServer != "" && c.AcmeServer != cfg.AcmeServer {
+			certCfg.AcmeServer = c.AcmeServer
+		}
+		certs[c.Name] = certCfg
+	}
+	return AutoDomainsSnippet(certs)
+}
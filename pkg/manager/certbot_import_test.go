@@ -0,0 +1,205 @@
+package manager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+	"gopkg.in/yaml.v3"
+)
+
+const certbotServer = "https://acme-v02.api.letsencrypt.org/directory"
+
+// writeCertbotTree creates a minimal certbot directory with one ECDSA lineage
+// and the account it was issued with
+func writeCertbotTree(t *testing.T, dir, name string, domains []string) {
+	t.Helper()
+	certPEM, keyPEM, chainPEM := newTestChain(t, domains)
+	live := filepath.Join(dir, "live", name)
+	files := map[string][]byte{
+		filepath.Join(live, "cert.pem"):    certPEM,
+		filepath.Join(live, "chain.pem"):   chainPEM,
+		filepath.Join(live, "privkey.pem"): keyPEM,
+		filepath.Join(dir, "renewal", name+".conf"): []byte(`# renew_before_expiry = 30 days
+version = 2.11.0
+archive_dir = /etc/letsencrypt/archive/` + name + `
+cert = /etc/letsencrypt/live/` + name + `/cert.pem
+privkey = /etc/letsencrypt/live/` + name + `/privkey.pem
+chain = /etc/letsencrypt/live/` + name + `/chain.pem
+fullchain = /etc/letsencrypt/live/` + name + `/fullchain.pem
+
+[renewalparams]
+account = 0123abcd
+server = ` + certbotServer + `
+authenticator = dns-acmedns
+key_type = ecdsa
+`),
+	}
+
+	accountKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate account key: %v", err)
+	}
+	jwk, err := jose.JSONWebKey{Key: accountKey}.MarshalJSON()
+	if err != nil {
+		t.Fatalf("Failed to encode account key: %v", err)
+	}
+	accountDir := filepath.Join(dir, "accounts", "acme-v02.api.letsencrypt.org", "directory", "0123abcd")
+	files[filepath.Join(accountDir, "private_key.json")] = jwk
+	files[filepath.Join(accountDir, "regr.json")] = []byte(`{"body": {}, "uri": "https://acme-v02.api.letsencrypt.org/acme/acct/12345"}`)
+
+	for path, data := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestImportCertbot(t *testing.T) {
+	certbotDir := t.TempDir()
+	writeCertbotTree(t, certbotDir, "example.com", []string{"example.com", "www.example.com"})
+	cfg := &Config{Email: "admin@example.com", AcmeServer: certbotServer, CertStoragePath: t.TempDir()}
+
+	imported, err := ImportCertbot(cfg, certbotDir)
+	if err != nil {
+		t.Fatalf("ImportCertbot failed: %v", err)
+	}
+	if len(imported.Certificates) != 1 || imported.Certificates[0].Skipped != "" {
+		t.Fatalf("Expected one imported certificate, got %+v", imported.Certificates)
+	}
+	c := imported.Certificates[0]
+	if !reflect.DeepEqual(c.Domains, []string{"example.com", "www.example.com"}) || c.KeyType != "ec256" {
+		t.Errorf("Unexpected certificate %+v", c)
+	}
+
+	artifact, err := certinfo.LoadArtifact(cfg.CertStoragePath, "example.com")
+	if err != nil || !artifact.Complete() {
+		t.Errorf("Expected complete stored certificate, got %+v (%v)", artifact, err)
+	}
+
+	if len(imported.Accounts) != 1 || imported.Accounts[0].Skipped != "" {
+		t.Fatalf("Expected one imported account, got %+v", imported.Accounts)
+	}
+	user, err := createOrLoadUser(cfg)
+	if err != nil {
+		t.Fatalf("Loading imported account failed: %v", err)
+	}
+	if user.Registration == nil || user.Registration.URI != "https://acme-v02.api.letsencrypt.org/acme/acct/12345" {
+		t.Errorf("Expected imported registration, got %+v", user.Registration)
+	}
+	if _, ok := user.GetPrivateKey().(*ecdsa.PrivateKey); !ok {
+		t.Errorf("Expected ECDSA account key, got %T", user.GetPrivateKey())
+	}
+
+	snippet, err := imported.AutoDomainsYAML(cfg)
+	if err != nil {
+		t.Fatalf("AutoDomainsYAML failed: %v", err)
+	}
+	var parsed struct {
+		AutoDomains AutoDomainsConfig `yaml:"auto_domains"`
+	}
+	if err := yaml.Unmarshal([]byte(snippet), &parsed); err != nil {
+		t.Fatalf("Generated YAML does not parse: %v\n%s", err, snippet)
+	}
+	want := CertConfig{Domains: []string{"example.com", "www.example.com"}, KeyType: "ec256"}
+	if got := parsed.AutoDomains.Certs["example.com"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	// A second import leaves the existing files alone
+	again, err := ImportCertbot(cfg, certbotDir)
+	if err != nil {
+		t.Fatalf("Second ImportCertbot failed: %v", err)
+	}
+	if !strings.Contains(again.Certificates[0].Skipped, "already") || !strings.Contains(again.Accounts[0].Skipped, "already") {
+		t.Errorf("Expected certificate and account to be skipped, got %+v", again)
+	}
+}
+
+func TestImportCertbot_OtherServer(t *testing.T) {
+	certbotDir := t.TempDir()
+	writeCertbotTree(t, certbotDir, "example.com", []string{"example.com"})
+	cfg := &Config{Email: "admin@example.com", AcmeServer: "https://acme.invalid/directory", CertStoragePath: t.TempDir()}
+
+	imported, err := ImportCertbot(cfg, certbotDir)
+	if err != nil {
+		t.Fatalf("ImportCertbot failed: %v", err)
+	}
+	snippet, err := imported.AutoDomainsYAML(cfg)
+	if err != nil {
+		t.Fatalf("AutoDomainsYAML failed: %v", err)
+	}
+	if !strings.Contains(snippet, "acme_server: "+certbotServer) {
+		t.Errorf("Expected ACME server override in:\n%s", snippet)
+	}
+}
+
+func TestImportCertbot_KeyMismatch(t *testing.T) {
+	certbotDir := t.TempDir()
+	writeCertbotTree(t, certbotDir, "example.com", []string{"example.com"})
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(certbotDir, "live", "example.com", "privkey.pem"), certcrypto.PEMEncode(other), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Email: "admin@example.com", AcmeServer: certbotServer, CertStoragePath: t.TempDir()}
+
+	imported, err := ImportCertbot(cfg, certbotDir)
+	if err != nil {
+		t.Fatalf("ImportCertbot failed: %v", err)
+	}
+	if imported.Certificates[0].Skipped == "" {
+		t.Error("Expected certificate with foreign key to be skipped")
+	}
+	if _, err := os.Stat(certinfo.PathsFor(cfg.CertStoragePath, "example.com").Certificate); !os.IsNotExist(err) {
+		t.Errorf("Expected no stored certificate, got %v", err)
+	}
+}
+
+func TestImportCertbot_NoRenewalConfigs(t *testing.T) {
+	if _, err := ImportCertbot(&Config{CertStoragePath: t.TempDir()}, t.TempDir()); err == nil {
+		t.Error("Expected error for a directory without renewal configurations")
+	}
+}
+
+func TestParseCertbotRenewal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x.conf")
+	content := "cert = /a/cert.pem\nprivkey=/a/privkey.pem\n[renewalparams]\nserver = https://ca/dir\naccount = abc\ncert = ignored\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	renewal, err := parseCertbotRenewal(path)
+	if err != nil {
+		t.Fatalf("parseCertbotRenewal failed: %v", err)
+	}
+	want := certbotRenewal{cert: "/a/cert.pem", privkey: "/a/privkey.pem", server: "https://ca/dir", account: "abc"}
+	if *renewal != want {
+		t.Errorf("Expected %+v, got %+v", want, *renewal)
+	}
+}
+
+// TestCertbotRegistration_UnknownFields checks that old regr.json files with extra fields parse
+func TestCertbotRegistration_UnknownFields(t *testing.T) {
+	var regr certbotRegistration
+	data := `{"body": {"key": {"kty": "RSA"}, "contact": ["mailto:a@example.com"], "status": "valid"}, "uri": "https://ca/acct/1", "new_authzr_uri": "x"}`
+	if err := json.Unmarshal([]byte(data), &regr); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if regr.URI != "https://ca/acct/1" || regr.Body.Status != "valid" || len(regr.Body.Contact) != 1 {
+		t.Errorf("Unexpected registration %+v", regr)
+	}
+}
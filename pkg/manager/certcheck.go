@@ -3,79 +3,131 @@ package manager
 
 import (
 	"crypto/x509"
-	"encoding/pem"
 	"fmt"
 	"os"
 	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
 )
 
-// CertificateNeedsRenewal checks if a certificate needs renewal based on:
-// 1. Expiry time (if it expires within renewalThreshold)
-// 2. Domain changes (if requested domains are not all in the certificate)
-// Returns whether renewal is needed, reason for renewal, and any error encountered
-func CertificateNeedsRenewal(certPath string, requestedDomains []string, renewalThreshold time.Duration) (bool, string, error) {
-	// Read the certificate file
-	certBytes, err := os.ReadFile(certPath)
+// Actions decided by DetermineAction
+const (
+	ActionInit  = "init"  // No complete certificate yet, obtain a new one
+	ActionRenew = "renew" // Expires soon, lacks requested domains or has another key type
+	ActionSkip  = "skip"  // Valid, nothing to do
+)
+
+// ActionDecision is the result of DetermineAction
+type ActionDecision struct {
+	Action   string
+	Reason   string // Why the action is needed, empty for ActionSkip
+	CheckErr error  // Set if the certificate could not be checked and is renewed to be safe
+}
+
+// DetermineAction decides whether a certificate has to be obtained, renewed or
+// can be left alone. A certificate without metadata or certificate file is
+// obtained from scratch, as is a staging certificate once a production ACME
+// server is configured, a certificate ordered from another ACME server than
+// the configured one and a certificate the last OCSP check found revoked.
+// A certificate whose key does not have the requested key type is renewed
+// with a new key; an empty keyType stands for DefaultKeyType, as in Diff.
+func DetermineAction(cfg *Config, certName string, domains []string, keyType string, renewalThreshold time.Duration) (ActionDecision, error) {
+	paths := certinfo.PathsFor(cfg.CertStoragePath, certName)
+
+	// If metadata file doesn't exist, it's a new certificate
+	if _, err := os.Stat(paths.Metadata); os.IsNotExist(err) {
+		return ActionDecision{Action: ActionInit, Reason: fmt.Sprintf("Certificate metadata not found at %s", paths.Metadata)}, nil
+	} else if err != nil {
+		return ActionDecision{}, fmt.Errorf("checking certificate metadata %s: %w", paths.Metadata, err)
+	}
+
+	// If certificate file doesn't exist, it's a new certificate
+	if _, err := os.Stat(paths.Certificate); os.IsNotExist(err) {
+		return ActionDecision{Action: ActionInit, Reason: fmt.Sprintf("Certificate file not found at %s", paths.Certificate)}, nil
+	} else if err != nil {
+		return ActionDecision{}, fmt.Errorf("checking certificate file %s: %w", paths.Certificate, err)
+	}
+
+	info, err := certinfo.Load(paths.Certificate)
 	if err != nil {
-		return true, fmt.Sprintf("could not read certificate file: %v", err), err
+		// If we can't check the certificate, assume it needs renewal
+		return ActionDecision{Action: ActionRenew, Reason: fmt.Sprintf("could not load certificate: %v", err), CheckErr: err}, nil
+	}
+	// A staging certificate left over from testing is replaced as soon as
+	// the certificate is configured for a production ACME server
+	if reason := stagingReason(cfg, certName, info); reason != "" {
+		return ActionDecision{Action: ActionInit, Reason: reason}, nil
+	}
+	if reason := acmeServerReason(cfg, certName); reason != "" {
+		return ActionDecision{Action: ActionInit, Reason: reason}, nil
+	}
+	if reason := revokedReason(cfg.CertStoragePath, certName, info); reason != "" {
+		return ActionDecision{Action: ActionInit, Reason: reason}, nil
+	}
+	if server := cfg.ForCert(certName).AcmeServer; server != "" && !IsStagingServer(server) {
+		if err := checkIssuerTrusted(paths.Certificate, cfg.CABundlePath); err != nil {
+			DefaultLogger.Warnf("Certificate %s (issuer %q) is not trusted by the system roots or ca_bundle_path: %v", certName, info.Issuer, err)
+		}
+	}
+	if reason := keyTypeReason(cfg, certName, info, keyType); reason != "" {
+		return ActionDecision{Action: ActionRenew, Reason: reason}, nil
 	}
+	threshold := cfg.RenewalThresholdFor(info.Lifetime(), renewalThreshold)
+	if needsRenewal, reason := renewalReason(info, domains, threshold); needsRenewal {
+		return ActionDecision{Action: ActionRenew, Reason: reason}, nil
+	}
+	return ActionDecision{Action: ActionSkip}, nil
+}
 
-	block, _ := pem.Decode(certBytes)
-	if block == nil {
-		return true, "failed to decode PEM block", fmt.Errorf("failed to decode PEM block")
+// keyTypeReason returns why the key of a certificate does not match the
+// effective key type, "" if it matches. Certificates with a csr_path get the
+// key type of their CSR.
+func keyTypeReason(cfg *Config, certName string, info *certinfo.Info, keyType string) string {
+	if cfg.CSRPathFor(certName) != "" {
+		return ""
+	}
+	want := EffectiveKeyType(keyType)
+	if info.KeyAlgorithm == want {
+		return ""
 	}
+	return fmt.Sprintf("certificate key is %s, key_type is %s", info.KeyAlgorithm, want)
+}
 
-	cert, err := x509.ParseCertificate(block.Bytes)
+// CertificateNeedsRenewal checks if a certificate needs renewal based on:
+// 1. Expiry time (if it expires within renewalThreshold)
+// 2. Domain changes (if requested domains are not all in the certificate)
+// Returns whether renewal is needed, reason for renewal, and any error encountered
+func CertificateNeedsRenewal(certPath string, requestedDomains []string, renewalThreshold time.Duration) (bool, string, error) {
+	info, err := certinfo.Load(certPath)
 	if err != nil {
-		return true, fmt.Sprintf("failed to parse certificate: %v", err), err
+		return true, fmt.Sprintf("could not load certificate: %v", err), err
 	}
+	needsRenewal, reason := renewalReason(info, requestedDomains, renewalThreshold)
+	return needsRenewal, reason, nil
+}
 
+// renewalReason checks a loaded certificate against the threshold and the requested domains
+func renewalReason(info *certinfo.Info, requestedDomains []string, renewalThreshold time.Duration) (bool, string) {
 	// Check expiry
-	timeLeft := time.Until(cert.NotAfter)
-	expiryReason := ""
+	timeLeft := info.TimeLeft(time.Now())
 	if timeLeft <= renewalThreshold {
-		expiryReason = fmt.Sprintf("certificate expires in %v (threshold is %v)",
+		expiryReason := fmt.Sprintf("certificate expires in %v (threshold is %v)",
 			timeLeft.Round(time.Hour), renewalThreshold.Round(time.Hour))
-		return true, expiryReason, nil
+		return true, expiryReason
 	}
 
 	// Check for domain mismatches
-	missingDomains, _ := CompareCertificateDomains(cert, requestedDomains)
+	missingDomains, _ := info.CompareDomains(requestedDomains)
 	if len(missingDomains) > 0 {
-		return true, fmt.Sprintf("certificate missing domains: %v", missingDomains), nil
+		return true, fmt.Sprintf("certificate missing domains: %v", missingDomains)
 	}
 
 	// No renewal needed
-	return false, "", nil
+	return false, ""
 }
 
 // CompareCertificateDomains compares the domains in a certificate against a list of requested domains
 // Returns two slices: domains missing from the cert, and domains in cert but not requested
 func CompareCertificateDomains(cert *x509.Certificate, requestedDomains []string) (missingDomains, extraDomains []string) {
-	// Create maps for easier comparison
-	existingDomainsMap := make(map[string]bool)
-	for _, domain := range cert.DNSNames {
-		existingDomainsMap[domain] = true
-	}
-
-	requestedDomainsMap := make(map[string]bool)
-	for _, domain := range requestedDomains {
-		requestedDomainsMap[domain] = true
-	}
-
-	// Find domains missing from certificate
-	for _, domain := range requestedDomains {
-		if !existingDomainsMap[domain] {
-			missingDomains = append(missingDomains, domain)
-		}
-	}
-
-	// Find extra domains in certificate
-	for _, domain := range cert.DNSNames {
-		if !requestedDomainsMap[domain] {
-			extraDomains = append(extraDomains, domain)
-		}
-	}
-
-	return missingDomains, extraDomains
+	return certinfo.CompareDomains(cert.DNSNames, requestedDomains)
 }
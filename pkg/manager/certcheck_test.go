@@ -1,13 +1,19 @@
 package manager
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/pem"
 	"math/big"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
 )
 
 func TestCertificateNeedsRenewal(t *testing.T) {
@@ -190,3 +196,118 @@ func TestCompareCertificateDomains(t *testing.T) {
 		})
 	}
 }
+
+func TestDetermineAction(t *testing.T) {
+	storage := t.TempDir()
+	cfg := &Config{CertStoragePath: storage}
+	paths := certinfo.PathsFor(storage, "web")
+	threshold := 30 * 24 * time.Hour
+
+	// Nothing stored yet
+	decision, err := DetermineAction(cfg, "web", []string{"example.com"}, "rsa2048", threshold)
+	if err != nil {
+		t.Fatalf("DetermineAction failed: %v", err)
+	}
+	if decision.Action != ActionInit {
+		t.Errorf("Expected %s without metadata, got %s", ActionInit, decision.Action)
+	}
+
+	// Metadata without certificate
+	if err := os.MkdirAll(filepath.Dir(paths.Metadata), 0700); err != nil {
+		t.Fatalf("Failed to create certificate directory: %v", err)
+	}
+	if err := os.WriteFile(paths.Metadata, []byte(`{"domain":"example.com"}`), 0600); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+	decision, err = DetermineAction(cfg, "web", []string{"example.com"}, "rsa2048", threshold)
+	if err != nil {
+		t.Fatalf("DetermineAction failed: %v", err)
+	}
+	if decision.Action != ActionInit || decision.Reason != "Certificate file not found at "+paths.Certificate {
+		t.Errorf("Expected %s for missing certificate, got %s (%q)", ActionInit, decision.Action, decision.Reason)
+	}
+
+	// Valid certificate, then one lacking a requested domain
+	if err := createTestCertificateWithDomains(paths.Certificate, paths.PrivateKey, []string{"example.com"}); err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	decision, err = DetermineAction(cfg, "web", []string{"example.com"}, "rsa2048", threshold)
+	if err != nil {
+		t.Fatalf("DetermineAction failed: %v", err)
+	}
+	if decision.Action != ActionSkip {
+		t.Errorf("Expected %s for valid certificate, got %s (%q)", ActionSkip, decision.Action, decision.Reason)
+	}
+	decision, err = DetermineAction(cfg, "web", []string{"example.com", "www.example.com"}, "rsa2048", threshold)
+	if err != nil {
+		t.Fatalf("DetermineAction failed: %v", err)
+	}
+	if decision.Action != ActionRenew || decision.CheckErr != nil {
+		t.Errorf("Expected %s for missing domain, got %s (%v)", ActionRenew, decision.Action, decision.CheckErr)
+	}
+
+	// The certificate has an rsa2048 key
+	decision, err = DetermineAction(cfg, "web", []string{"example.com"}, "rsa2048", threshold)
+	if err != nil || decision.Action != ActionSkip {
+		t.Errorf("Expected %s for matching key type, got %s (%v)", ActionSkip, decision.Action, err)
+	}
+	decision, err = DetermineAction(cfg, "web", []string{"example.com"}, "ec256", threshold)
+	if err != nil || decision.Action != ActionRenew || decision.Reason != "certificate key is rsa2048, key_type is ec256" {
+		t.Errorf("Expected %s for changed key type, got %s (%q, %v)", ActionRenew, decision.Action, decision.Reason, err)
+	}
+	csrCfg := &Config{CertStoragePath: storage, AutoDomains: &AutoDomainsConfig{Certs: map[string]CertConfig{
+		"web": {Domains: []string{"example.com"}, CSRPath: "/etc/acme/web.csr"},
+	}}}
+	decision, err = DetermineAction(csrCfg, "web", []string{"example.com"}, "ec256", threshold)
+	if err != nil || decision.Action != ActionSkip {
+		t.Errorf("Expected the key type of a CSR certificate not to be compared, got %s (%v)", decision.Action, err)
+	}
+}
+
+func TestDetermineAction_GracePercent(t *testing.T) {
+	storage := t.TempDir()
+	paths := certinfo.PathsFor(storage, "web")
+	if err := os.MkdirAll(filepath.Dir(paths.Metadata), 0700); err != nil {
+		t.Fatalf("Failed to create certificate directory: %v", err)
+	}
+	if err := os.WriteFile(paths.Metadata, []byte(`{"domain":"example.com"}`), 0600); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+	// A short-lived certificate valid for 6 days with 4 days left
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-2 * 24 * time.Hour),
+		NotAfter:     time.Now().Add(4 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	if err := os.WriteFile(paths.Certificate, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	threshold := 30 * 24 * time.Hour
+
+	for _, tt := range []struct {
+		percent int
+		want    string
+	}{
+		{0, ActionRenew},  // The fixed 30 days renew on every run
+		{33, ActionSkip},  // Renew with 2 days left
+		{80, ActionRenew}, // Renew with 4.8 days left
+	} {
+		cfg := &Config{CertStoragePath: storage, AutoDomains: &AutoDomainsConfig{GracePercent: tt.percent}}
+		decision, err := DetermineAction(cfg, "web", []string{"example.com"}, "ec256", threshold)
+		if err != nil {
+			t.Fatalf("DetermineAction failed: %v", err)
+		}
+		if decision.Action != tt.want {
+			t.Errorf("grace_percent %d: expected %s, got %s (%q)", tt.percent, tt.want, decision.Action, decision.Reason)
+		}
+	}
+}
@@ -54,11 +54,19 @@ func ParseCertArg(arg string) (string, []string, string, error) {
 		if domainPart == "" {
 			return "", nil, "", fmt.Errorf("empty domain name")
 		}
+		// Internationalized names are validated and ordered in punycode
+		domain, err := NormalizeDomain(domainPart)
+		if err != nil {
+			return "", nil, "", err
+		}
 		// Advanced RFC validation for DNS names
-		if !IsValidDNSName(domainPart) {
+		if !IsValidDNSName(domain) {
 			return "", nil, "", fmt.Errorf("invalid domain name '%s': does not conform to DNS name standards", domainPart)
 		}
-		return domainPart, []string{domainPart}, keyType, nil
+		if IsPublicSuffix(domain) {
+			return "", nil, "", fmt.Errorf("invalid domain name '%s': %s is a public suffix, no CA issues certificates for it", domainPart, GetBaseDomain(domain))
+		}
+		return domain, []string{domain}, keyType, nil
 	}
 
 	// Process explicit cert-name@domain format
@@ -73,11 +81,18 @@ func ParseCertArg(arg string) (string, []string, string, error) {
 	for _, d := range rawDomains {
 		trimmed := strings.TrimSpace(d)
 		if trimmed != "" {
+			domain, err := NormalizeDomain(trimmed)
+			if err != nil {
+				return "", nil, "", err
+			}
 			// Validate the domain according to DNS standards
-			if !IsValidDNSName(trimmed) {
+			if !IsValidDNSName(domain) {
 				return "", nil, "", fmt.Errorf("invalid domain name '%s': does not conform to DNS name standards", trimmed)
 			}
-			domains = append(domains, trimmed)
+			if IsPublicSuffix(domain) {
+				return "", nil, "", fmt.Errorf("invalid domain name '%s': %s is a public suffix, no CA issues certificates for it", trimmed, GetBaseDomain(domain))
+			}
+			domains = append(domains, domain)
 		}
 	}
 
@@ -77,6 +77,22 @@ func TestParseCertArg(t *testing.T) {
 			wantKeyType: "",
 			wantErr:     true,
 		},
+		{
+			name:        "Invalid - Public Suffix",
+			arg:         "mycert@example.co.uk,*.co.uk",
+			wantName:    "",
+			wantDomains: nil,
+			wantKeyType: "",
+			wantErr:     true,
+		},
+		{
+			name:        "Multi-Label Public Suffix",
+			arg:         "shop.example.co.uk",
+			wantName:    "shop.example.co.uk",
+			wantDomains: []string{"shop.example.co.uk"},
+			wantKeyType: "",
+			wantErr:     false,
+		},
 		{
 			name:        "Invalid - Empty Certificate Name",
 			arg:         "@example.com",
@@ -0,0 +1,212 @@
+package manager
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// domainFilePrefix starts domains entries naming a file with one domain per line
+const domainFilePrefix = "@file:"
+
+// maxExpandedDomains bounds the names one domains entry expands to, a typo
+// in a range must not produce a million SANs
+const maxExpandedDomains = 1000
+
+// expandDomainEntries replaces the @file: and brace entries in the domains
+// of every auto_domains certificate by the names they stand for. Files are
+// relative to configDir. Names listed twice after the expansion are kept once.
+// Globs select domain files only, a * in a name is a wildcard certificate
+// name and allowed as the whole first label only.
+func expandDomainEntries(cfg *Config, configDir string) error {
+	if cfg.AutoDomains == nil {
+		return nil
+	}
+	for name, certCfg := range cfg.AutoDomains.Certs {
+		var domains []string
+		seen := make(map[string]bool)
+		for _, entry := range certCfg.Domains {
+			expanded, err := expandDomainEntry(entry, configDir)
+			if err != nil {
+				return fmt.Errorf("auto_domains.certs.%s: %w", name, err)
+			}
+			for _, domain := range expanded {
+				if err := checkWildcardLabel(domain); err != nil {
+					return fmt.Errorf("auto_domains.certs.%s: %w", name, err)
+				}
+				if key := strings.ToLower(domain); !seen[key] {
+					seen[key] = true
+					domains = append(domains, domain)
+				}
+			}
+		}
+		if len(domains) == 0 {
+			return fmt.Errorf("auto_domains.certs.%s: domains expand to no names", name)
+		}
+		certCfg.Domains = domains
+		cfg.AutoDomains.Certs[name] = certCfg
+	}
+	return nil
+}
+
+// expandDomainEntry returns the names of one domains entry: the lines of the
+// files an @file: entry names, or the brace expansion of any other entry. An
+// @file: path may be a glob like domains/*.txt, the matching files are read
+// in lexical order.
+func expandDomainEntry(entry, configDir string) ([]string, error) {
+	pattern, ok := strings.CutPrefix(entry, domainFilePrefix)
+	if !ok {
+		return expandBraces(entry)
+	}
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(configDir, pattern)
+	}
+	paths := []string{pattern}
+	if strings.ContainsAny(pattern, "*?[") {
+		var err error
+		if paths, err = filepath.Glob(pattern); err != nil {
+			return nil, fmt.Errorf("domain file pattern %s: %w", pattern, err)
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("no domain file matches %s", pattern)
+		}
+	}
+	var domains []string
+	for _, path := range paths {
+		lines, err := readDomainFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			expanded, err := expandBraces(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			domains = append(domains, expanded...)
+		}
+	}
+	return domains, nil
+}
+
+// checkWildcardLabel rejects a * anywhere but as the whole first label, names
+// are not matched against anything, so a glob in a name would only reach the
+// CA as an invalid identifier
+func checkWildcardLabel(domain string) error {
+	if strings.Contains(strings.TrimPrefix(domain, "*."), "*") {
+		return fmt.Errorf("%q: * is only allowed as the first label of a wildcard name like *.example.com, globs select @file: paths", domain)
+	}
+	return nil
+}
+
+// readDomainFile returns the lines of a domain list without blank lines and
+// # comments, e.g. an inventory exported from a CMDB
+func readDomainFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading domain file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading domain file %s: %w", path, err)
+	}
+	return lines, nil
+}
+
+// expandBraces expands shell-like brace groups: {a,b}.example.com yields
+// a.example.com and b.example.com, web{1..3}.example.com yields web1 to
+// web3, and {01..10} keeps the leading zeros. Groups may be nested.
+func expandBraces(pattern string) ([]string, error) {
+	open := strings.IndexByte(pattern, '{')
+	if open < 0 {
+		if strings.ContainsRune(pattern, '}') {
+			return nil, fmt.Errorf("unbalanced braces in %q", pattern)
+		}
+		return []string{pattern}, nil
+	}
+	depth, end := 0, -1
+	var commas []int
+	for i := open; i < len(pattern) && end < 0; i++ {
+		switch pattern[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				end = i
+			}
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		}
+	}
+	if end < 0 {
+		return nil, fmt.Errorf("unbalanced braces in %q", pattern)
+	}
+
+	var alternatives []string
+	if len(commas) > 0 {
+		start := open + 1
+		for _, comma := range append(commas, end) {
+			alternatives = append(alternatives, pattern[start:comma])
+			start = comma + 1
+		}
+	} else {
+		var err error
+		if alternatives, err = expandRange(pattern[open+1 : end]); err != nil {
+			return nil, fmt.Errorf("%w in %q", err, pattern)
+		}
+	}
+
+	prefix, suffix := pattern[:open], pattern[end+1:]
+	var expanded []string
+	for _, alternative := range alternatives {
+		names, err := expandBraces(prefix + alternative + suffix)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, names...)
+		if len(expanded) > maxExpandedDomains {
+			return nil, fmt.Errorf("%q expands to more than %d names", pattern, maxExpandedDomains)
+		}
+	}
+	return expanded, nil
+}
+
+// expandRange expands the inside of a {first..last} group
+func expandRange(group string) ([]string, error) {
+	from, to, ok := strings.Cut(group, "..")
+	if !ok {
+		return nil, fmt.Errorf("brace group {%s} needs a comma or a range like {1..3}", group)
+	}
+	first, err1 := strconv.Atoi(from)
+	last, err2 := strconv.Atoi(to)
+	if err1 != nil || err2 != nil || first < 0 || last < first {
+		return nil, fmt.Errorf("invalid range {%s}", group)
+	}
+	if last-first >= maxExpandedDomains {
+		return nil, fmt.Errorf("range {%s} has more than %d values", group, maxExpandedDomains)
+	}
+	// {01..10} pads to the width of the first value like bash
+	width := 0
+	if len(from) > 1 && from[0] == '0' {
+		width = len(from)
+	}
+	values := make([]string, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		values = append(values, fmt.Sprintf("%0*d", width, i))
+	}
+	return values, nil
+}
@@ -0,0 +1,105 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandBraces(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"example.com", []string{"example.com"}},
+		{"{www,api}.example.com", []string{"www.example.com", "api.example.com"}},
+		{"web{1..3}.example.com", []string{"web1.example.com", "web2.example.com", "web3.example.com"}},
+		{"node{08..10}.example.com", []string{"node08.example.com", "node09.example.com", "node10.example.com"}},
+		{"{a,b}.{x,y}.example.com", []string{"a.x.example.com", "a.y.example.com", "b.x.example.com", "b.y.example.com"}},
+		{"{www,{eu,us}-api}.example.com", []string{"www.example.com", "eu-api.example.com", "us-api.example.com"}},
+		{"{,www.}example.com", []string{"example.com", "www.example.com"}},
+	}
+	for _, tt := range tests {
+		got, err := expandBraces(tt.pattern)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v (%v)", tt.pattern, tt.want, got, err)
+		}
+	}
+
+	for _, pattern := range []string{"{a,b.example.com", "a}.example.com", "{a}.example.com", "web{3..1}.example.com", "web{1..5000}.example.com"} {
+		if got, err := expandBraces(pattern); err == nil {
+			t.Errorf("%s: expected an error, got %v", pattern, got)
+		}
+	}
+}
+
+func TestLoadConfig_DomainEntries(t *testing.T) {
+	dir := t.TempDir()
+	inventory := "# exported from the CMDB\nshop.example.com\n\n  {eu,us}.shop.example.com  # regions\nexample.com\n"
+	if err := os.MkdirAll(filepath.Join(dir, "domains"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "domains", "shop.txt"), []byte(inventory), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "domains", "blog.txt"), []byte("blog.example.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "config.yaml")
+	write := func(domains string) {
+		t.Helper()
+		content := `
+email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+auto_domains:
+  certs:
+    shop:
+      domains: ` + domains + `
+`
+		if err := os.WriteFile(configPath, []byte(content), PrivateKeyPermissions); err != nil {
+			t.Fatalf("Failed to write test config: %v", err)
+		}
+	}
+
+	write(`["example.com", "@file:domains/shop.txt", "{www,api}.example.com"]`)
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	want := []string{"example.com", "shop.example.com", "eu.shop.example.com", "us.shop.example.com", "www.example.com", "api.example.com"}
+	if got := cfg.AutoDomains.Certs["shop"].Domains; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	// A glob reads every matching file in lexical order
+	write(`["@file:domains/*.txt"]`)
+	if cfg, err = LoadConfig(configPath); err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	want = []string{"blog.example.com", "shop.example.com", "eu.shop.example.com", "us.shop.example.com", "example.com"}
+	if got := cfg.AutoDomains.Certs["shop"].Domains; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	write(`["*.example.com"]`)
+	if _, err := LoadConfig(configPath); err != nil {
+		t.Errorf("Expected a wildcard name to be accepted, got %v", err)
+	}
+	for _, domains := range []string{`["web*.example.com"]`, `["www.*.example.com"]`, `["@file:domains/*.csv"]`} {
+		write(domains)
+		if _, err := LoadConfig(configPath); err == nil {
+			t.Errorf("Expected an error for %s", domains)
+		}
+	}
+
+	write(`["@file:domains/missing.txt"]`)
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected an error for a missing domain file")
+	}
+	write(`["{www,api.example.com"]`)
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected an error for unbalanced braces")
+	}
+}
@@ -0,0 +1,100 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// interpolateEnv replaces ${NAME} in the values of a YAML configuration with
+// the environment variable NAME. ${NAME:-default} falls back to default if
+// NAME is unset or empty, $${ yields a literal ${. Keys are left alone. An
+// unset variable without default is an error listing every such variable
+// with its line. Configurations without ${ are returned unchanged.
+func interpolateEnv(data []byte) ([]byte, error) {
+	if !strings.Contains(string(data), "${") {
+		return data, nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		// Without a node tree there are no values to interpolate; the
+		// syntax error surfaces once the file is parsed into Config
+		return data, nil
+	}
+
+	var missing []string
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		switch node.Kind {
+		case yaml.DocumentNode, yaml.SequenceNode:
+			for _, child := range node.Content {
+				walk(child)
+			}
+		case yaml.MappingNode:
+			for i := 1; i < len(node.Content); i += 2 {
+				walk(node.Content[i])
+			}
+		case yaml.ScalarNode:
+			value, unset := expandEnv(node.Value, os.LookupEnv)
+			for _, name := range unset {
+				missing = append(missing, fmt.Sprintf("%s (line %d)", name, node.Line))
+			}
+			if value != node.Value {
+				node.Value = value
+				// Let plain scalars resolve again, "${PORT}" may become a number
+				if node.Style == 0 {
+					node.Tag = ""
+				}
+			}
+		}
+		// Aliases share the node of their anchor, which is expanded once
+	}
+	walk(&doc)
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("environment variables not set: %s (use ${NAME:-default} for optional values)", strings.Join(missing, ", "))
+	}
+	return yaml.Marshal(&doc)
+}
+
+// expandEnv expands ${NAME} and ${NAME:-default} in s using lookup and
+// returns the names of unset variables without default. $${ is an escaped
+// ${, a $ not followed by { is kept as is.
+func expandEnv(s string, lookup func(string) (string, bool)) (string, []string) {
+	var out strings.Builder
+	var missing []string
+	for {
+		i := strings.Index(s, "$")
+		if i < 0 || i == len(s)-1 {
+			out.WriteString(s)
+			return out.String(), missing
+		}
+		out.WriteString(s[:i])
+		s = s[i:]
+		switch {
+		case strings.HasPrefix(s, "$${"):
+			out.WriteString("${")
+			s = s[3:]
+		case strings.HasPrefix(s, "${"):
+			end := strings.Index(s, "}")
+			if end < 0 {
+				out.WriteString(s)
+				return out.String(), missing
+			}
+			name, fallback, hasDefault := strings.Cut(s[2:end], ":-")
+			if value, ok := lookup(name); ok && (value != "" || !hasDefault) {
+				out.WriteString(value)
+			} else if hasDefault {
+				out.WriteString(fallback)
+			} else {
+				missing = append(missing, name)
+			}
+			s = s[end+1:]
+		default:
+			out.WriteByte('$')
+			s = s[1:]
+		}
+	}
+}
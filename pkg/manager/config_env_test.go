@@ -0,0 +1,84 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	env := map[string]string{"HOST": "acme-dns.example.com", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+	tests := []struct {
+		in, want string
+		missing  []string
+	}{
+		{"https://${HOST}/", "https://acme-dns.example.com/", nil},
+		{"${UNSET:-fallback}", "fallback", nil},
+		{"${EMPTY:-fallback}", "fallback", nil},
+		{"${EMPTY}", "", nil},
+		{"$${HOST} costs $5", "${HOST} costs $5", nil},
+		{"pa$$word$", "pa$$word$", nil},
+		{"${UNSET}-${OTHER}", "-", []string{"UNSET", "OTHER"}},
+		{"${HOST", "${HOST", nil},
+	}
+	for _, tt := range tests {
+		got, missing := expandEnv(tt.in, lookup)
+		if got != tt.want || !reflect.DeepEqual(missing, tt.missing) {
+			t.Errorf("expandEnv(%q): expected %q %v, got %q %v", tt.in, tt.want, tt.missing, got, missing)
+		}
+	}
+}
+
+func TestLoadConfig_EnvInterpolation(t *testing.T) {
+	t.Setenv("TEST_ACME_EMAIL", "ops@example.com")
+	t.Setenv("TEST_ACME_DNS", "https://acme-dns.example.com")
+	t.Setenv("TEST_PARALLEL", "4")
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+email: ${TEST_ACME_EMAIL}
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "${TEST_ACME_DNS}"
+cert_storage_path: "${TEST_STORAGE:-storage}"
+auto_domains:
+  max_parallel: ${TEST_PARALLEL}
+  certs:
+    web:
+      domains: ["example.com"]
+      pfx_password: "$${literal}"
+      output_formats: ["pfx"]
+`
+	if err := os.WriteFile(configPath, []byte(content), PrivateKeyPermissions); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.Email != "ops@example.com" || cfg.AcmeDnsServer != "https://acme-dns.example.com" {
+		t.Errorf("Variables not expanded: email %q, acme_dns_server %q", cfg.Email, cfg.AcmeDnsServer)
+	}
+	if cfg.CertStoragePath != filepath.Join(filepath.Dir(configPath), "storage") {
+		t.Errorf("Expected default storage path, got %s", cfg.CertStoragePath)
+	}
+	if cfg.AutoDomains.MaxParallel != 4 {
+		t.Errorf("Expected max_parallel 4 from the environment, got %d", cfg.AutoDomains.MaxParallel)
+	}
+	if got := cfg.AutoDomains.Certs["web"].PFXPassword; got != "${literal}" {
+		t.Errorf("Expected escaped literal, got %q", got)
+	}
+
+	content = strings.Replace(content, "${TEST_ACME_EMAIL}", "${TEST_UNSET_EMAIL}", 1)
+	if err := os.WriteFile(configPath, []byte(content), PrivateKeyPermissions); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+	_, err = LoadConfig(configPath)
+	if err == nil || !strings.Contains(err.Error(), "TEST_UNSET_EMAIL (line 2)") {
+		t.Errorf("Expected an error naming the unset variable, got %v", err)
+	}
+}
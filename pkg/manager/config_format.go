@@ -0,0 +1,35 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// configToYAML converts JSON and TOML configuration files, selected by their
+// .json or .toml extension, to YAML, so that they go through the same
+// interpolation, includes and schema validation. Other files are returned
+// unchanged.
+func configToYAML(path string, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	var content map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if err := json.Unmarshal(data, &content); err != nil {
+			return nil, fmt.Errorf("parsing JSON file %s: %w", path, err)
+		}
+	case ".toml":
+		if err := toml.Unmarshal(data, &content); err != nil {
+			return nil, fmt.Errorf("parsing TOML file %s: %w", path, err)
+		}
+	default:
+		return data, nil
+	}
+	return yaml.Marshal(content)
+}
@@ -0,0 +1,70 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig_JSONAndTOML(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.json": `{
+  "email": "test@example.com",
+  "acme_server": "https://acme-staging-v02.api.letsencrypt.org/directory",
+  "acme_dns_server": "https://acme-dns.example.com",
+  "auto_domains": {
+    "grace_days": 20,
+    "certs": {"web": {"domains": ["example.com"], "key_type": "ec256"}}
+  }
+}`,
+		"config.toml": `email = "test@example.com"
+acme_server = "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server = "https://acme-dns.example.com"
+
+[auto_domains]
+grace_days = 20
+
+[auto_domains.certs.web]
+domains = ["example.com"]
+key_type = "ec256"
+`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(content), PrivateKeyPermissions); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := LoadConfig(path)
+			if err != nil {
+				t.Fatalf("LoadConfig failed: %v", err)
+			}
+			if cfg.Email != "test@example.com" || cfg.AutoDomains.GraceDays != 20 {
+				t.Errorf("Unexpected config: email %q, grace_days %d", cfg.Email, cfg.AutoDomains.GraceDays)
+			}
+			if web := cfg.AutoDomains.Certs["web"]; web.KeyType != "ec256" || len(web.Domains) != 1 {
+				t.Errorf("Unexpected certificate web: %+v", web)
+			}
+		})
+	}
+
+	// The schema applies to every format
+	path := filepath.Join(dir, "typo.toml")
+	if err := os.WriteFile(path, []byte(files["config.toml"]+"graceDays = 3\n"), PrivateKeyPermissions); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "graceDays") {
+		t.Errorf("Expected a validation error for the unknown key, got %v", err)
+	}
+
+	// Syntax errors name the format
+	path = filepath.Join(dir, "broken.json")
+	if err := os.WriteFile(path, []byte(`{"email": `), PrivateKeyPermissions); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "parsing JSON file") {
+		t.Errorf("Expected a JSON parse error, got %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// includeKey is the top-level key listing the certificate fragment files
+const includeKey = "include"
+
+// mergeIncludes adds the certificates of the fragment files named by the
+// top-level include key (one glob or a list of globs, relative to configDir)
+// to auto_domains.certs. Each fragment maps certificate names to their
+// settings, exactly like auto_domains.certs. A name defined twice is an
+// error. The include key is removed, configurations without it are returned
+// unchanged.
+func mergeIncludes(data []byte, configDir string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		// No top-level mapping, so no include key to look for; parsing the
+		// file into Config rejects it
+		return data, nil
+	}
+	root := doc.Content[0]
+	includeNode := removeMappingKey(root, includeKey)
+	if includeNode == nil {
+		return data, nil
+	}
+	var patterns []string
+	switch includeNode.Kind {
+	case yaml.ScalarNode:
+		patterns = []string{includeNode.Value}
+	case yaml.SequenceNode:
+		if err := includeNode.Decode(&patterns); err != nil {
+			return nil, fmt.Errorf("'include' must be a glob or a list of globs: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("'include' must be a glob or a list of globs")
+	}
+
+	var files []string
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(configDir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("include pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			DefaultLogger.Warnf("Warning: include pattern %s matches no files", pattern)
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+
+	autoDomains := mappingValue(root, "auto_domains")
+	if autoDomains.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("'auto_domains' must be a mapping to include certificates")
+	}
+	certs := mappingValue(autoDomains, "certs")
+	if certs.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("'auto_domains.certs' must be a mapping to include certificates")
+	}
+	definedIn := make(map[string]string)
+	for i := 0; i+1 < len(certs.Content); i += 2 {
+		definedIn[certs.Content[i].Value] = "the main configuration"
+	}
+	for _, file := range files {
+		fragment, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading include file %s: %w", file, err)
+		}
+		if fragment, err = configToYAML(file, fragment); err != nil {
+			return nil, err
+		}
+		if fragment, err = interpolateEnv(fragment); err != nil {
+			return nil, fmt.Errorf("include file %s: %w", file, err)
+		}
+		var fragmentDoc yaml.Node
+		if err := yaml.Unmarshal(fragment, &fragmentDoc); err != nil {
+			return nil, fmt.Errorf("parsing include file %s: %w", file, err)
+		}
+		if len(fragmentDoc.Content) == 0 {
+			continue
+		}
+		entries := fragmentDoc.Content[0]
+		if entries.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("include file %s must map certificate names to their settings", file)
+		}
+		for i := 0; i+1 < len(entries.Content); i += 2 {
+			name := entries.Content[i].Value
+			if previous, ok := definedIn[name]; ok {
+				return nil, fmt.Errorf("certificate %q in include file %s is already defined in %s", name, file, previous)
+			}
+			definedIn[name] = file
+			certs.Content = append(certs.Content, entries.Content[i], entries.Content[i+1])
+		}
+		DefaultLogger.Debugf("Included %d certificate(s) from %s", len(entries.Content)/2, file)
+	}
+	return yaml.Marshal(&doc)
+}
+
+// removeMappingKey removes key from a mapping node and returns its value, or
+// nil if the key is not present
+func removeMappingKey(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			value := mapping.Content[i+1]
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return value
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the mapping stored under key, adding an empty one if
+// the key is missing or null
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			value := mapping.Content[i+1]
+			if value.Kind == yaml.ScalarNode && value.Tag == "!!null" {
+				*value = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			}
+			return value
+		}
+	}
+	value := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+	return value
+}
@@ -0,0 +1,100 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig_Include(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	writeFile := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), PrivateKeyPermissions); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	writeFile(configPath, `
+email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+include: conf.d/*.yaml
+auto_domains:
+  grace_days: 20
+  certs:
+    main:
+      domains: ["example.com"]
+`)
+	writeFile(filepath.Join(dir, "conf.d", "web.yaml"), `
+web:
+  domains: ["www.example.com"]
+  key_type: ec256
+`)
+	writeFile(filepath.Join(dir, "conf.d", "mail.yaml"), `
+mail:
+  domains: ["mail.example.com"]
+smtp:
+  domains: ["smtp.example.com"]
+`)
+	writeFile(filepath.Join(dir, "conf.d", "notes.txt"), "not included")
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if len(cfg.AutoDomains.Certs) != 4 || cfg.AutoDomains.GraceDays != 20 {
+		t.Fatalf("Expected the main and three included certificates, got %+v", cfg.AutoDomains)
+	}
+	if web := cfg.AutoDomains.Certs["web"]; web.KeyType != "ec256" || web.Domains[0] != "www.example.com" {
+		t.Errorf("Unexpected included certificate web: %+v", web)
+	}
+
+	// A name defined twice is rejected
+	writeFile(filepath.Join(dir, "conf.d", "main.yaml"), "main:\n  domains: [\"other.example.com\"]\n")
+	_, err = LoadConfig(configPath)
+	if err == nil || !strings.Contains(err.Error(), `certificate "main"`) || !strings.Contains(err.Error(), "main configuration") {
+		t.Errorf("Expected an error for the duplicate certificate, got %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "conf.d", "main.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fragments are validated like the main configuration
+	writeFile(filepath.Join(dir, "conf.d", "web.yaml"), "web:\n  domains: [\"www.example.com\"]\n  key_typ: ec256\n")
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected a validation error for an unknown key in an included certificate")
+	}
+}
+
+func TestLoadConfig_IncludeWithoutAutoDomains(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	content := `
+email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+include: ["certs/*.yaml"]
+`
+	if err := os.WriteFile(configPath, []byte(content), PrivateKeyPermissions); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "certs"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "certs", "web.yaml"), []byte("web:\n  domains: [\"example.com\"]\n"), PrivateKeyPermissions); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.AutoDomains == nil || len(cfg.AutoDomains.Certs) != 1 || cfg.AutoDomains.GraceDays != DefaultGraceDays {
+		t.Errorf("Expected auto_domains with the included certificate and default grace days, got %+v", cfg.AutoDomains)
+	}
+}
@@ -0,0 +1,147 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// unknownConfigKeys walks the config instance along ConfigSchema and returns
+// one message per key the schema does not allow, with the full dotted path
+// and the closest valid key, e.g. graceDays -> grace_days. Misspelled keys
+// would otherwise only show up as vague schema errors.
+func unknownConfigKeys(instance interface{}) ([]string, error) {
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(ConfigSchema), &schema); err != nil {
+		return nil, fmt.Errorf("schema parsing error: %w", err)
+	}
+	var messages []string
+	walkConfigKeys(instance, schema, "", &messages)
+	return messages, nil
+}
+
+func walkConfigKeys(instance interface{}, schema map[string]interface{}, path string, messages *[]string) {
+	switch value := instance.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			if propertySchema, ok := properties[key].(map[string]interface{}); ok {
+				walkConfigKeys(value[key], propertySchema, keyPath, messages)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case map[string]interface{}:
+				// Named entries such as certificates or providers
+				walkConfigKeys(value[key], additional, keyPath, messages)
+			case bool:
+				if additional || properties == nil {
+					continue
+				}
+				message := fmt.Sprintf("Unknown option '%s'", keyPath)
+				if suggestion := suggestKey(key, properties); suggestion != "" {
+					message += fmt.Sprintf(", did you mean '%s'?", suggestion)
+				} else if section := sectionOf(key, properties); section != "" {
+					message += fmt.Sprintf(", it belongs in the '%s' section", section)
+				}
+				*messages = append(*messages, message)
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range value {
+				walkConfigKeys(item, items, fmt.Sprintf("%s[%d]", path, i), messages)
+			}
+		}
+	}
+}
+
+// suggestKey returns the valid key closest to key, or "" if none is close
+// enough. camelCase and dashes are converted to snake_case first.
+func suggestKey(key string, properties map[string]interface{}) string {
+	normalized := snakeCase(key)
+	if _, ok := properties[normalized]; ok {
+		return normalized
+	}
+	candidates := make([]string, 0, len(properties))
+	for candidate := range properties {
+		candidates = append(candidates, candidate)
+	}
+	sort.Strings(candidates)
+	best, bestDistance := "", max(2, len(normalized)/3)+1
+	for _, candidate := range candidates {
+		if distance := levenshtein(normalized, candidate); distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+// sectionOf returns the section among properties that has key as an option,
+// e.g. auto_domains for a misplaced grace_days
+func sectionOf(key string, properties map[string]interface{}) string {
+	normalized := snakeCase(key)
+	sections := make([]string, 0, len(properties))
+	for section := range properties {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	for _, section := range sections {
+		sectionSchema, _ := properties[section].(map[string]interface{})
+		sectionProperties, _ := sectionSchema["properties"].(map[string]interface{})
+		if _, ok := sectionProperties[normalized]; ok {
+			return section
+		}
+	}
+	return ""
+}
+
+// snakeCase converts graceDays or grace-days to grace_days
+func snakeCase(key string) string {
+	var out strings.Builder
+	for i, r := range key {
+		switch {
+		case r == '-' || r == ' ':
+			out.WriteRune('_')
+		case unicode.IsUpper(r):
+			if i > 0 {
+				out.WriteRune('_')
+			}
+			out.WriteRune(unicode.ToLower(r))
+		default:
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,72 @@
+package manager
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig_UnknownKeys(t *testing.T) {
+	base := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+`
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{
+			name:   "camelCase per-cert key",
+			config: base + "auto_domains:\n  certs:\n    web:\n      domains: [\"example.com\"]\n      keyType: ec256\n",
+			want:   "Unknown option 'auto_domains.certs.web.keyType', did you mean 'key_type'?",
+		},
+		{
+			name:   "camelCase section key",
+			config: base + "auto_domains:\n  graceDays: 10\n  certs: {}\n",
+			want:   "Unknown option 'auto_domains.graceDays', did you mean 'grace_days'?",
+		},
+		{
+			name:   "misspelled top-level key",
+			config: base + "dns_resolvr: \"1.1.1.1\"\n",
+			want:   "Unknown option 'dns_resolvr', did you mean 'dns_resolver'?",
+		},
+		{
+			name:   "key in the wrong section",
+			config: base + "grace_days: 10\n",
+			want:   "Unknown option 'grace_days', it belongs in the 'auto_domains' section",
+		},
+		{
+			name:   "unrelated key",
+			config: base + "frobnicate: true\n",
+			want:   "Unknown option 'frobnicate'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateConfig([]byte(tt.config))
+			if err == nil {
+				t.Fatal("Expected a validation error")
+			}
+			if !strings.Contains(err.Error(), tt.want) || strings.Contains(err.Error(), tt.want+",") {
+				t.Errorf("Expected %q in %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestSuggestKey(t *testing.T) {
+	properties := map[string]interface{}{"grace_days": nil, "max_parallel": nil, "certs": nil}
+	tests := map[string]string{
+		"graceDays":    "grace_days",
+		"grace-days":   "grace_days",
+		"grace_dyas":   "grace_days",
+		"maxParallell": "max_parallel",
+		"cert":         "certs",
+		"domains":      "",
+	}
+	for key, want := range tests {
+		if got := suggestKey(key, properties); got != want {
+			t.Errorf("suggestKey(%q): expected %q, got %q", key, want, got)
+		}
+	}
+}
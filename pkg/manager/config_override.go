@@ -0,0 +1,82 @@
+package manager
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigOverride replaces one value of the configuration file, e.g. from
+// the command line. Key is a dotted path like auto_domains.grace_days,
+// Value is parsed as YAML, so numbers, booleans and [a, b] lists keep their
+// type.
+type ConfigOverride struct {
+	Key   string
+	Value string
+}
+
+// ParseConfigOverride parses a key=value override
+func ParseConfigOverride(s string) (ConfigOverride, error) {
+	key, value, ok := strings.Cut(s, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return ConfigOverride{}, fmt.Errorf("override %q is not of the form key=value", s)
+	}
+	for _, part := range strings.Split(key, ".") {
+		if part == "" {
+			return ConfigOverride{}, fmt.Errorf("override key %q has an empty path element", key)
+		}
+	}
+	return ConfigOverride{Key: key, Value: value}, nil
+}
+
+// LoadConfigWithOverrides reads the configuration like LoadConfig, with the
+// overrides applied before it is validated. Missing sections on the path of
+// a key are created, a missing file is treated as empty.
+func LoadConfigWithOverrides(path string, overrides []ConfigOverride) (*Config, error) {
+	return loadConfig(path, overrides)
+}
+
+// applyOverrides sets the override values in the YAML configuration
+func applyOverrides(data []byte, overrides []ConfigOverride) ([]byte, error) {
+	if len(overrides) == 0 {
+		return data, nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		// An override cannot repair a broken file, parsing it into Config
+		// reports the syntax error with the file name
+		return data, nil
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+
+	for _, override := range overrides {
+		var value yaml.Node
+		if err := yaml.Unmarshal([]byte(override.Value), &value); err != nil {
+			return nil, fmt.Errorf("override %s: value is not valid YAML: %w", override.Key, err)
+		}
+		valueNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: ""}
+		if len(value.Content) > 0 {
+			valueNode = value.Content[0]
+		}
+
+		parts := strings.Split(override.Key, ".")
+		node := doc.Content[0]
+		if node.Kind != yaml.MappingNode {
+			// Reported by the schema validation that follows
+			return data, nil
+		}
+		for _, part := range parts[:len(parts)-1] {
+			node = mappingValue(node, part)
+			if node.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("override %s: %s is not a section", override.Key, part)
+			}
+		}
+		removeMappingKey(node, parts[len(parts)-1])
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: parts[len(parts)-1]}, valueNode)
+	}
+	return yaml.Marshal(&doc)
+}
@@ -0,0 +1,67 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigOverride(t *testing.T) {
+	tests := []struct {
+		in        string
+		key       string
+		value     string
+		expectErr bool
+	}{
+		{"email=ops@example.com", "email", "ops@example.com", false},
+		{"auto_domains.grace_days=10", "auto_domains.grace_days", "10", false},
+		{"profile=", "profile", "", false},
+		{"hook_env=A=B", "hook_env", "A=B", false},
+		{"email", "", "", true},
+		{"=value", "", "", true},
+		{"auto_domains..grace_days=1", "", "", true},
+	}
+	for _, tt := range tests {
+		override, err := ParseConfigOverride(tt.in)
+		if (err != nil) != tt.expectErr {
+			t.Errorf("ParseConfigOverride(%q): unexpected error %v", tt.in, err)
+			continue
+		}
+		if err == nil && (override.Key != tt.key || override.Value != tt.value) {
+			t.Errorf("ParseConfigOverride(%q): expected %s=%s, got %s=%s", tt.in, tt.key, tt.value, override.Key, override.Value)
+		}
+	}
+}
+
+func TestLoadConfigWithOverrides(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := `
+email: "test@example.com"
+acme_server: "https://acme-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+`
+	if err := os.WriteFile(configPath, []byte(content), PrivateKeyPermissions); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	cfg, err := LoadConfigWithOverrides(configPath, []ConfigOverride{
+		{Key: "acme_server", Value: "https://acme-staging-v02.api.letsencrypt.org/directory"},
+		{Key: "auto_domains.certs.web.domains", Value: "[example.com, www.example.com]"},
+		{Key: "auto_domains.max_parallel", Value: "2"},
+	})
+	if err != nil {
+		t.Fatalf("LoadConfigWithOverrides failed: %v", err)
+	}
+	if !strings.Contains(cfg.AcmeServer, "staging") {
+		t.Errorf("Expected the staging server, got %s", cfg.AcmeServer)
+	}
+	if cfg.AutoDomains == nil || len(cfg.AutoDomains.Certs["web"].Domains) != 2 || cfg.AutoDomains.MaxParallel != 2 {
+		t.Errorf("Expected the sections created by the overrides, got %+v", cfg.AutoDomains)
+	}
+
+	_, err = LoadConfigWithOverrides(configPath, []ConfigOverride{{Key: "email.address", Value: "x"}})
+	if err == nil || !strings.Contains(err.Error(), "email is not a section") {
+		t.Errorf("Expected an error for a key below a scalar, got %v", err)
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -177,3 +179,290 @@ func TestAccountStoreOperations(t *testing.T) {
 			reloaded.Username, testAccount.Username)
 	}
 }
+
+func TestLoadConfig_Events(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "config.yaml")
+	configContent := []byte(`
+email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+events:
+  nats:
+    url: "nats://nats.example.com:4222"
+    subject: "certs.events"
+    token: "s3cret"
+  kafka:
+    brokers: ["kafka1:9092", "kafka2:9092"]
+    topic: "cert-events"
+    username: "svc"
+    password: "pw"
+    tls: true
+`)
+	if err := os.WriteFile(configPath, configContent, PrivateKeyPermissions); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.Events == nil || cfg.Events.NATS == nil || cfg.Events.Kafka == nil {
+		t.Fatal("Expected both NATS and Kafka events config")
+	}
+	if cfg.Events.NATS.Subject != "certs.events" || cfg.Events.NATS.Token != "s3cret" {
+		t.Errorf("Unexpected NATS config: %+v", cfg.Events.NATS)
+	}
+	if len(cfg.Events.Kafka.Brokers) != 2 || !cfg.Events.Kafka.TLS {
+		t.Errorf("Unexpected Kafka config: %+v", cfg.Events.Kafka)
+	}
+
+	// A Kafka section without topic must be rejected by the schema
+	bad := []byte(`
+email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+events:
+  kafka:
+    brokers: ["kafka1:9092"]
+`)
+	if err := os.WriteFile(configPath, bad, PrivateKeyPermissions); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected schema validation error for kafka without topic")
+	}
+}
+
+func TestLoadConfig_Notifications(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(smtp string) {
+		t.Helper()
+		content := `
+email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+notifications:
+  smtp:
+` + smtp
+		if err := os.WriteFile(configPath, []byte(content), PrivateKeyPermissions); err != nil {
+			t.Fatalf("Failed to write test config: %v", err)
+		}
+	}
+
+	write(`    host: "mail.example.com"
+    port: 465
+    tls: tls
+    from: "ACME Manager <acme@example.com>"
+    to: ["dns-team@example.com"]
+`)
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.Notifications == nil || cfg.Notifications.SMTP == nil {
+		t.Fatal("Expected the SMTP notification config")
+	}
+	if smtp := cfg.Notifications.SMTP; smtp.Port != 465 || smtp.TLS != "tls" || len(smtp.To) != 1 {
+		t.Errorf("Unexpected SMTP config: %+v", smtp)
+	}
+
+	write(`    host: "mail.example.com"
+    from: "acme@example.com"
+    to: ["dns-team@example.com"]
+    events: [dns.setup_needed]
+  pushover:
+    token: "app"
+    user: "user"
+    priority: 1
+    events: [certificate.failed, certificate.renewed]
+  telegram:
+    bot_token: "123:abc"
+    chat_id: "-10042"
+  teams:
+    webhook_url: "https://example.webhook.office.com/hook"
+`)
+	cfg, err = LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	nc := cfg.Notifications
+	if nc.Pushover == nil || nc.Pushover.Priority != 1 || len(nc.Pushover.Events) != 2 || nc.Telegram == nil || nc.Teams == nil {
+		t.Errorf("Expected all notification channels, got %+v", nc)
+	}
+
+	// Recipients are required, and the TLS mode must be known
+	write(`    host: "mail.example.com"
+    from: "acme@example.com"
+`)
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected schema validation error for smtp without recipients")
+	}
+	write(`    host: "mail.example.com"
+    tls: ssl
+    from: "acme@example.com"
+    to: ["dns-team@example.com"]
+`)
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected schema validation error for an unknown tls mode")
+	}
+	write(`    host: "mail.example.com"
+    from: "acme@example.com"
+    to: ["dns-team@example.com"]
+    events: [certificate.revoked]
+`)
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected schema validation error for an unknown event type")
+	}
+}
+
+func TestLoadConfig_GracePercent(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(autoDomains string) {
+		t.Helper()
+		content := `
+email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+auto_domains:
+` + autoDomains + `
+  certs:
+    short:
+      domains: ["example.com"]
+`
+		if err := os.WriteFile(configPath, []byte(content), PrivateKeyPermissions); err != nil {
+			t.Fatalf("Failed to write test config: %v", err)
+		}
+	}
+
+	write("  grace_percent: 33")
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.AutoDomains.GracePercent != 33 || cfg.AutoDomains.GraceDays != 0 {
+		t.Errorf("Expected grace_percent 33 without default grace_days, got %+v", cfg.AutoDomains)
+	}
+
+	write("  grace_percent: 33\n  grace_days: 10")
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected error when both grace_days and grace_percent are set")
+	}
+
+	write("  grace_percent: 100")
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected schema validation error for grace_percent 100")
+	}
+
+	// The splays must fit into the deadline of a run
+	write("  renew_splay: 15m")
+	if _, err := LoadConfig(configPath); err != nil {
+		t.Errorf("Expected renew_splay 15m to be accepted, got %v", err)
+	}
+	write("  renew_splay: 30m")
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected error for a renew_splay reaching the run timeout")
+	}
+}
+
+func TestLoadConfig_CABundle(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	write := func(extra string) {
+		t.Helper()
+		content := `
+email: "test@example.com"
+acme_server: "https://ca.internal.example.com/acme/acme/directory"
+acme_dns_server: "https://acme-dns.internal.example.com"
+` + extra
+		if err := os.WriteFile(configPath, []byte(content), PrivateKeyPermissions); err != nil {
+			t.Fatalf("Failed to write test config: %v", err)
+		}
+	}
+
+	write("ca_bundle_path: internal-ca.pem\n")
+	if _, err := LoadConfig(configPath); err == nil || !strings.Contains(err.Error(), "ca_bundle_path") {
+		t.Errorf("Expected an error for the missing CA bundle, got %v", err)
+	}
+
+	_, _, chain := newTestChain(t, []string{"ca.internal.example.com"})
+	if err := os.WriteFile(filepath.Join(dir, "internal-ca.pem"), chain, 0644); err != nil {
+		t.Fatal(err)
+	}
+	write("ca_bundle_path: internal-ca.pem\ninsecure_skip_verify: true\n")
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.CABundlePath != filepath.Join(dir, "internal-ca.pem") || !cfg.InsecureSkipVerify {
+		t.Errorf("Expected the bundle relative to the config file and insecure_skip_verify, got %q %v", cfg.CABundlePath, cfg.InsecureSkipVerify)
+	}
+}
+
+func TestLoadConfig_AcmeDnsAllowFrom(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(allowFrom string) {
+		t.Helper()
+		content := `
+email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+acme_dns_allowfrom: ` + allowFrom + `
+`
+		if err := os.WriteFile(configPath, []byte(content), PrivateKeyPermissions); err != nil {
+			t.Fatalf("Failed to write test config: %v", err)
+		}
+	}
+
+	write(`["192.0.2.10/32", "2001:db8::/64"]`)
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if len(cfg.AcmeDnsAllowFrom) != 2 {
+		t.Errorf("Expected two allowfrom entries, got %v", cfg.AcmeDnsAllowFrom)
+	}
+
+	write(`["192.0.2.10"]`)
+	if _, err := LoadConfig(configPath); err == nil || !strings.Contains(err.Error(), "acme_dns_allowfrom") {
+		t.Errorf("Expected an error for an address without prefix length, got %v", err)
+	}
+}
+
+func TestRenewalThresholdFor(t *testing.T) {
+	fixed := 30 * 24 * time.Hour
+	sixDays := 6 * 24 * time.Hour
+
+	if got := (&Config{}).RenewalThresholdFor(sixDays, fixed); got != fixed {
+		t.Errorf("Expected fixed threshold without auto_domains, got %v", got)
+	}
+	cfg := &Config{AutoDomains: &AutoDomainsConfig{GracePercent: 50}}
+	if got := cfg.RenewalThresholdFor(sixDays, fixed); got != 3*24*time.Hour {
+		t.Errorf("Expected half of six days, got %v", got)
+	}
+	if got := cfg.RenewalThresholdFor(0, fixed); got != fixed {
+		t.Errorf("Expected fixed threshold for unknown lifetime, got %v", got)
+	}
+}
+
+func TestRenewSplayFor(t *testing.T) {
+	if got := (&Config{}).RenewSplayFor("web"); got != 0 {
+		t.Errorf("Expected no splay without configuration, got %v", got)
+	}
+
+	cfg := &Config{AutoDomains: &AutoDomainsConfig{RenewSplay: time.Hour}}
+	seen := make(map[time.Duration]bool)
+	for _, name := range []string{"web", "mail", "vpn", "api"} {
+		d := cfg.RenewSplayFor(name)
+		if d < 0 || d >= time.Hour {
+			t.Errorf("Splay of %s outside [0, 1h): %v", name, d)
+		}
+		if again := cfg.RenewSplayFor(name); again != d {
+			t.Errorf("Expected stable splay for %s, got %v and %v", name, d, again)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("Expected different certificates to get different delays, got %v", seen)
+	}
+}
@@ -2,6 +2,17 @@ package manager
 
 import "time"
 
+// AcmeDNSAccountsFile is the file below the storage path holding acme-dns credentials
+const AcmeDNSAccountsFile = "acme-dns-accounts.json"
+
+// AcmeDNSAccountsDir is the directory below the storage path holding one
+// accounts file per base domain with accounts_layout sharded
+const AcmeDNSAccountsDir = "acme-dns-accounts"
+
+// AcmeDNSPendingAccountsFile holds acme-dns accounts registered by a credential
+// rotation whose CNAME change is not visible yet
+const AcmeDNSPendingAccountsFile = "acme-dns-accounts.pending.json"
+
 // Constants for file permissions
 const (
 	// DirPermissions defines permissions for directories (0750)
@@ -16,6 +27,9 @@ const (
 	// DefaultGraceDays defines the default renewal period in days
 	DefaultGraceDays = 30
 
+	// DefaultKeepGenerations defines how many replaced certificate generations are archived
+	DefaultKeepGenerations = 3
+
 	// DefaultDNSTimeout defines the timeout for DNS operations in seconds
 	DefaultDNSTimeout = 15
 
@@ -0,0 +1,83 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge/dns01"
+)
+
+func TestContextTransport(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &http.Client{Transport: newContextTransport(ctx, nil)}
+
+	// The body stays readable after RoundTrip returned
+	resp, err := client.Get(server.URL + "/fast")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil || string(body) != "ok" {
+		t.Errorf("Expected body ok, got %q (%v)", body, err)
+	}
+
+	// Canceling the bound context aborts a request in flight
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := client.Get(server.URL + "/slow"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled for request in flight, got %v", err)
+	}
+
+	// Requests after cancellation are not sent at all
+	if _, err := client.Get(server.URL + "/fast"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled after cancellation, got %v", err)
+	}
+}
+
+func TestCancelablePreCheck(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	check := func(fqdn, value string) (bool, error) {
+		calls++
+		return false, nil
+	}
+
+	// Lego's check runs while the context is live
+	preCheck := cancelablePreCheck(ctx, nil)
+	if stop, _ := preCheck("example.com", "_acme-challenge.example.com.", "v", check); stop || calls != 1 {
+		t.Errorf("Expected the wrapped check to run, got stop=%v after %d calls", stop, calls)
+	}
+
+	// A wrapped pre-check replaces Lego's check
+	wrapped := cancelablePreCheck(ctx, func(domain, fqdn, value string, _ dns01.PreCheckFunc) (bool, error) {
+		return true, nil
+	})
+	if stop, _ := wrapped("example.com", "_acme-challenge.example.com.", "v", check); !stop || calls != 1 {
+		t.Errorf("Expected the wrapped pre-check to run instead, got stop=%v after %d calls", stop, calls)
+	}
+
+	// Cancellation ends the propagation wait without checking again
+	cancel()
+	if stop, err := preCheck("example.com", "_acme-challenge.example.com.", "v", check); !stop || err != nil || calls != 1 {
+		t.Errorf("Expected the wait to end after cancellation, got stop=%v err=%v after %d calls", stop, err, calls)
+	}
+}
@@ -0,0 +1,166 @@
+package manager
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/lego"
+)
+
+// CSRPathFor returns the csr_path of the named certificate, or "" if the
+// manager generates the key of the certificate itself
+func (cfg *Config) CSRPathFor(certName string) string {
+	certCfg, ok := cfg.CertConfigFor(certName)
+	if !ok {
+		return ""
+	}
+	return certCfg.CSRPath
+}
+
+// resolveCSRPaths makes csr_path settings relative to the config file
+// directory and rejects settings that need the private key, which certificates
+// with an external key do not have
+func resolveCSRPaths(cfg *Config, configDir string) error {
+	if cfg.AutoDomains == nil {
+		return nil
+	}
+	for name, certCfg := range cfg.AutoDomains.Certs {
+		if certCfg.CSRPath == "" {
+			continue
+		}
+		if certCfg.KeyType != "" || certCfg.ReuseKey {
+			return fmt.Errorf("auto_domains.certs.%s: key_type and reuse_key cannot be used with csr_path, the CSR determines the key", name)
+		}
+		for _, format := range certCfg.OutputFormats {
+			if format == OutputFormatPFX || format == OutputFormatHAProxyPEM {
+				return fmt.Errorf("auto_domains.certs.%s: output format %s contains the private key, which is not available with csr_path", name, format)
+			}
+		}
+		if certCfg.Deploy != nil {
+			return fmt.Errorf("auto_domains.certs.%s: deploy copies the private key, which is not available with csr_path", name)
+		}
+		if !filepath.IsAbs(certCfg.CSRPath) {
+			certCfg.CSRPath = filepath.Join(configDir, certCfg.CSRPath)
+			cfg.AutoDomains.Certs[name] = certCfg
+		}
+	}
+	return nil
+}
+
+// LoadCSR reads a PEM or DER encoded certificate signing request and checks
+// its signature
+func LoadCSR(path string) (*x509.CertificateRequest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading CSR %s: %w", path, err)
+	}
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, fmt.Errorf("reading CSR %s: unexpected PEM block %q", path, block.Type)
+		}
+		der = block.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, fmt.Errorf("parsing CSR %s: %w", path, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("checking signature of CSR %s: %w", path, err)
+	}
+	return csr, nil
+}
+
+// csrDomains returns the names a CSR requests, the common name and the DNS
+// names, sorted and without duplicates
+func csrDomains(csr *x509.CertificateRequest) []string {
+	seen := map[string]bool{}
+	var domains []string
+	for _, d := range append([]string{csr.Subject.CommonName}, csr.DNSNames...) {
+		d = strings.ToLower(d)
+		if d != "" && !seen[d] {
+			seen[d] = true
+			domains = append(domains, d)
+		}
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// checkCSRDomains verifies that the CSR requests exactly the configured domains
+func checkCSRDomains(csr *x509.CertificateRequest, domains []string) error {
+	want := make([]string, len(domains))
+	for i, d := range domains {
+		want[i] = strings.ToLower(d)
+	}
+	sort.Strings(want)
+	want = slices.Compact(want)
+	have := csrDomains(csr)
+	if strings.Join(have, ",") != strings.Join(want, ",") {
+		return fmt.Errorf("CSR requests %s, but the certificate is configured for %s", DisplayDomains(have), DisplayDomains(want))
+	}
+	return nil
+}
+
+// obtainForCSR orders a certificate for the configured CSR. Init and renewal
+// are the same for external keys: the key never leaves its owner, so every
+// renewal is a new order for the same CSR.
+func obtainForCSR(ctx context.Context, client *lego.Client, cfg *Config, certName string, domains []string, profile string, policy RetryConfig, recorder *retryAfterRecorder) error {
+	csrPath := cfg.CSRPathFor(certName)
+	csr, err := LoadCSR(csrPath)
+	if err != nil {
+		return err
+	}
+	if err := checkCSRDomains(csr, domains); err != nil {
+		return fmt.Errorf("certificate %s: %w", certName, err)
+	}
+
+	DefaultLogger.Infof("Requesting certificate %s for the CSR %s", certName, csrPath)
+	request := certificate.ObtainForCSRRequest{
+		CSR:     csr,
+		Bundle:  true,
+		Profile: profile,
+	}
+	var resource *certificate.Resource
+	err = withRetry(ctx, policy, "obtain certificate for CSR", recorder, func() error {
+		var obtainErr error
+		resource, obtainErr = client.Certificate.ObtainForCSR(request)
+		return obtainErr
+	})
+	if err != nil {
+		return fmt.Errorf("failed to obtain certificate for CSR: %w", err)
+	}
+	DefaultLogger.Infof("Successfully obtained certificate '%s'!", certName)
+
+	// Lego returns the CSR it was given, the private key stays with its owner
+	resource.PrivateKey = nil
+	if err := validateIssuedCertificate(certName, resource, domains, time.Now()); err != nil {
+		return err
+	}
+	if err := storeCertificates(cfg, certName, resource); err != nil {
+		return fmt.Errorf("failed to save certificate '%s': %w", certName, err)
+	}
+	return nil
+}
+
+// ExcludeExternalKeys drops the missing-key issues of certificates with a
+// csr_path, whose private key is kept outside the storage directory
+func (r *StorageReport) ExcludeExternalKeys(cfg *Config) {
+	issues := r.Issues[:0]
+	for _, issue := range r.Issues {
+		if issue.Kind == StorageMissingKey && cfg.CSRPathFor(issue.CertName) != "" {
+			continue
+		}
+		issues = append(issues, issue)
+	}
+	r.Issues = issues
+}
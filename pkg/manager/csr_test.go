@@ -0,0 +1,119 @@
+package manager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestCSR creates a CSR for the given names and returns its path
+func writeTestCSR(t *testing.T, dir string, pemEncoded bool, commonName string, dnsNames ...string) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: commonName},
+		DNSNames: dnsNames,
+	}, key)
+	if err != nil {
+		t.Fatalf("Failed to create CSR: %v", err)
+	}
+	data := der
+	if pemEncoded {
+		data = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	}
+	path := filepath.Join(dir, "service.csr")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("Failed to write CSR: %v", err)
+	}
+	return path
+}
+
+func TestLoadCSR(t *testing.T) {
+	for _, pemEncoded := range []bool{true, false} {
+		path := writeTestCSR(t, t.TempDir(), pemEncoded, "example.com", "example.com", "www.example.com")
+		csr, err := LoadCSR(path)
+		if err != nil {
+			t.Fatalf("LoadCSR (pem=%v) failed: %v", pemEncoded, err)
+		}
+		if err := checkCSRDomains(csr, []string{"www.example.com", "example.com"}); err != nil {
+			t.Errorf("Unexpected domain mismatch: %v", err)
+		}
+		if err := checkCSRDomains(csr, []string{"example.com"}); err == nil || !strings.Contains(err.Error(), "www.example.com") {
+			t.Errorf("Expected a domain mismatch, got %v", err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "broken.csr")
+	_ = os.WriteFile(path, []byte("-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n"), 0o644)
+	if _, err := LoadCSR(path); err == nil {
+		t.Error("Expected an error for a certificate instead of a CSR")
+	}
+}
+
+func TestResolveCSRPaths(t *testing.T) {
+	newCfg := func(certCfg CertConfig) *Config {
+		certCfg.Domains = []string{"example.com"}
+		certCfg.CSRPath = "csr/example.csr"
+		return &Config{AutoDomains: &AutoDomainsConfig{Certs: map[string]CertConfig{"example": certCfg}}}
+	}
+
+	cfg := newCfg(CertConfig{OutputFormats: []string{OutputFormatFullChainOnly}})
+	if err := resolveCSRPaths(cfg, "/etc/acme"); err != nil {
+		t.Fatalf("resolveCSRPaths failed: %v", err)
+	}
+	if got := cfg.CSRPathFor("example"); got != filepath.Join("/etc/acme", "csr/example.csr") {
+		t.Errorf("Expected the path relative to the config directory, got %s", got)
+	}
+
+	for name, certCfg := range map[string]CertConfig{
+		"key_type": {KeyType: "ec256"},
+		"pfx":      {OutputFormats: []string{OutputFormatPFX}},
+		"deploy":   {Deploy: &DeployConfig{}},
+	} {
+		if err := resolveCSRPaths(newCfg(certCfg), "/etc/acme"); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected %s to be rejected with csr_path, got %v", name, err)
+		}
+	}
+}
+
+func TestSaveCertificates_ExternalKey(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir()}
+	if err := saveCertificates(cfg, "test-cert", createCompleteCertificateResource(), ""); err != nil {
+		t.Fatalf("Failed to save certificates: %v", err)
+	}
+
+	// A certificate for an external key replaces the one with a local key
+	resource := createCompleteCertificateResource()
+	resource.PrivateKey = nil
+	if err := saveCertificates(cfg, "test-cert", resource, ""); err != nil {
+		t.Fatalf("Failed to save certificates: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.CertStoragePath, "certificates", "test-cert.key")); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale private key to be removed, got %v", err)
+	}
+}
+
+func TestStorageReport_ExcludeExternalKeys(t *testing.T) {
+	cfg := &Config{AutoDomains: &AutoDomainsConfig{Certs: map[string]CertConfig{
+		"hsm": {Domains: []string{"example.com"}, CSRPath: "/etc/acme/hsm.csr"},
+	}}}
+	report := &StorageReport{Issues: []StorageIssue{
+		{CertName: "hsm", Kind: StorageMissingKey},
+		{CertName: "hsm", Kind: StorageMissingMetadata},
+		{CertName: "other", Kind: StorageMissingKey},
+	}}
+	report.ExcludeExternalKeys(cfg)
+	if len(report.Issues) != 2 || report.Issues[0].Kind != StorageMissingMetadata || report.Issues[1].CertName != "other" {
+		t.Errorf("Unexpected issues %+v", report.Issues)
+	}
+}
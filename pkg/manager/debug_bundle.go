@@ -0,0 +1,181 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redactedValue replaces secrets in the sanitized configuration
+const redactedValue = "[redacted]"
+
+// secretConfigKeys hold credentials wherever they appear in the configuration
+var secretConfigKeys = map[string]bool{
+	"password":          true,
+	"pfx_password":      true,
+	"token":             true,
+	"api_token":         true,
+	"access_key_id":     true,
+	"secret_access_key": true,
+	"tsig_secret":       true,
+	"vault_token":       true,
+	"bot_token":         true,
+	"webhook_url":       true,
+}
+
+// secretConfigMaps hold credentials in all of their values
+var secretConfigMaps = map[string]bool{
+	"dns_challenge_credentials": true,
+}
+
+// SanitizedConfig returns the configuration file at path as YAML for a bug
+// report: fragments are included and the overrides applied like on load,
+// but ${NAME} references are kept instead of being expanded. Passwords,
+// tokens, keys and provider credentials are replaced by "[redacted]", the
+// passwords of URLs by "xxxxx".
+func SanitizedConfig(path string, overrides []ConfigOverride) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && len(overrides) > 0 {
+		data, err = nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config file %s: %w", path, err)
+	}
+	if data, err = configToYAML(path, data); err != nil {
+		return nil, err
+	}
+	if data, err = mergeIncludes(data, filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	if data, err = applyOverrides(data, overrides); err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	redactConfigNode(&doc, false)
+	return yaml.Marshal(&doc)
+}
+
+// redactConfigNode replaces the secrets below node, all scalars if secret
+func redactConfigNode(node *yaml.Node, secret bool) {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			redactConfigNode(child, secret)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			redactConfigNode(node.Content[i+1], secret || secretConfigKeys[key] || secretConfigMaps[key])
+		}
+	case yaml.ScalarNode:
+		node.Value = redactScalar(node.Value, secret)
+	}
+}
+
+// redactScalar redacts one value. A plain ${NAME} reference names the
+// variable holding the secret and is kept.
+func redactScalar(value string, secret bool) string {
+	if value == "" {
+		return value
+	}
+	if secret {
+		if strings.HasPrefix(value, "${") && strings.HasSuffix(value, "}") && !strings.Contains(value, ":-") {
+			return value
+		}
+		return redactedValue
+	}
+	// e.g. a NATS URL with user:password@, the password becomes xxxxx
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return value
+}
+
+// ChallengeLookup is what DNS returns for the _acme-challenge name of a domain
+type ChallengeLookup struct {
+	Domain          string   `json:"domain"`
+	ChallengeDomain string   `json:"challenge_domain"`
+	ChallengeAlias  string   `json:"challenge_alias,omitempty"`
+	ExpectedCNAME   string   `json:"expected_cname,omitempty"` // Empty without acme-dns account or cname_targets entry
+	CNAME           string   `json:"cname,omitempty"`
+	CNAMEError      string   `json:"cname_error,omitempty"`
+	TXT             []string `json:"txt,omitempty"`
+	TXTError        string   `json:"txt_error,omitempty"`
+	Duration        string   `json:"duration"`
+}
+
+// LookupChallenges resolves the _acme-challenge CNAME and TXT records of
+// every domain configured in auto_domains or registered in the acme-dns
+// account store (which may be nil) through the pre-check resolver. Wildcard
+// and base domain share one record and are looked up once.
+func LookupChallenges(ctx context.Context, cfg *Config, store *accountStore) []ChallengeLookup {
+	// Base domain -> acme-dns subdomain of its account, empty without account
+	domains := make(map[string]string)
+	if cfg.AutoDomains != nil {
+		for _, certCfg := range cfg.AutoDomains.Certs {
+			for _, domain := range certCfg.Domains {
+				domains[strings.ToLower(GetBaseDomain(domain))] = ""
+			}
+		}
+	}
+	if store != nil {
+		for domain, account := range store.GetAllAccounts() {
+			domains[strings.ToLower(GetBaseDomain(domain))] = account.FullDomain
+		}
+	}
+	sorted := make([]string, 0, len(domains))
+	for domain := range domains {
+		sorted = append(sorted, domain)
+	}
+	sort.Strings(sorted)
+
+	resolver := NewPrecheckResolver(cfg)
+	lookups := make([]ChallengeLookup, 0, len(sorted))
+	for _, domain := range sorted {
+		if ctx.Err() != nil {
+			break
+		}
+		lookups = append(lookups, lookupChallenge(ctx, cfg, resolver, domain, domains[domain]))
+	}
+	return lookups
+}
+
+// lookupChallenge resolves the records of one base domain
+func lookupChallenge(ctx context.Context, cfg *Config, resolver DNSResolver, domain, accountTarget string) ChallengeLookup {
+	start := time.Now()
+	lookup := ChallengeLookup{
+		Domain:          domain,
+		ChallengeDomain: GetChallengeSubdomain(domain),
+		ChallengeAlias:  cfg.ChallengeAliasFor(domain),
+	}
+	lookup.ExpectedCNAME = cfg.ExpectedCNAMETarget(domain, accountTarget)
+
+	lookupCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if cname, err := resolver.LookupCNAME(lookupCtx, lookup.ChallengeDomain); err != nil {
+		lookup.CNAMEError = err.Error()
+	} else {
+		lookup.CNAME = strings.TrimSuffix(cname, ".")
+	}
+	if txtResolver, ok := resolver.(TXTResolver); ok {
+		if records, err := txtResolver.LookupTXT(lookupCtx, lookup.ChallengeDomain); err != nil {
+			lookup.TXTError = err.Error()
+		} else {
+			lookup.TXT = records
+		}
+	}
+	lookup.Duration = time.Since(start).Round(time.Millisecond).String()
+	DefaultLogger.Debugf("Looked up %s in %s", lookup.ChallengeDomain, lookup.Duration)
+	return lookup
+}
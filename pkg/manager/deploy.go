@@ -0,0 +1,117 @@
+package manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+// Steps of a deployment reported in DeployError
+const (
+	DeployStepConnect = "connect"
+	DeployStepUpload  = "upload"
+	DeployStepReload  = "reload"
+)
+
+// DeployError describes where a deployment failed
+type DeployError struct {
+	Target string // e.g. "ssh://deploy@web1.example.com:22"
+	Step   string // One of the DeployStep* constants
+	Path   string // Remote path for uploads
+	Output string // Output of a failed reload command
+	Err    error
+}
+
+// Error implements the error interface
+func (e *DeployError) Error() string {
+	msg := fmt.Sprintf("deploying to %s: %s", e.Target, e.Step)
+	if e.Path != "" {
+		msg += " " + e.Path
+	}
+	msg += ": " + e.Err.Error()
+	if e.Output != "" {
+		msg += " (output: " + e.Output + ")"
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error
+func (e *DeployError) Unwrap() error { return e.Err }
+
+// DeployResult is the outcome of deploying a certificate to one target
+type DeployResult struct {
+	Target   string
+	Deployed bool // False if the target already had the certificate or the deployment failed
+	Err      error
+}
+
+// deployFile is one file copied to a target
+type deployFile struct {
+	data       []byte
+	remotePath string
+	private    bool
+}
+
+// DeployCertificate copies a certificate to all targets in its deploy
+// configuration that do not have the current certificate yet, so a target
+// that failed is retried on the next run. It returns nil if the certificate
+// has no deploy configuration.
+func DeployCertificate(ctx context.Context, cfg *Config, certName string) ([]DeployResult, error) {
+	certCfg, ok := cfg.CertConfigFor(certName)
+	if !ok || certCfg.Deploy == nil || len(certCfg.Deploy.SSH) == 0 {
+		return nil, nil
+	}
+
+	paths := certinfo.PathsFor(cfg.CertStoragePath, certName)
+	certPEM, err := os.ReadFile(paths.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("reading certificate for deployment: %w", err)
+	}
+	keyPEM, _, err := readPrivateKey(cfg, certName)
+	if err != nil {
+		return nil, fmt.Errorf("reading private key for deployment: %w", err)
+	}
+	issuerPEM, err := os.ReadFile(paths.Issuer)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading issuer certificate for deployment: %w", err)
+	}
+	sum := sha256.Sum256(certPEM)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	state, err := LoadState(cfg.CertStoragePath)
+	if err != nil {
+		return nil, err
+	}
+	deployed := state.Certificates[certName].Deployments
+
+	var results []DeployResult
+	for _, target := range certCfg.Deploy.SSH {
+		result := DeployResult{Target: target.String()}
+		if deployed[result.Target] == fingerprint {
+			results = append(results, result)
+			continue
+		}
+
+		files := []deployFile{
+			{data: certPEM, remotePath: target.CertPath},
+			{data: keyPEM, remotePath: target.KeyPath, private: true},
+		}
+		if target.IssuerPath != "" && len(issuerPEM) > 0 {
+			files = append(files, deployFile{data: issuerPEM, remotePath: target.IssuerPath})
+		}
+		if err := deploySSH(ctx, cfg, target, files); err != nil {
+			result.Err = err
+		} else {
+			result.Deployed = true
+			if err := RecordDeployment(cfg.CertStoragePath, certName, result.Target, fingerprint); err != nil {
+				DefaultLogger.Warnf("Warning: recording deployment of %s to %s: %v", certName, result.Target, err)
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
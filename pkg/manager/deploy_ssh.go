@@ -0,0 +1,196 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// DefaultDeployTimeout bounds the deployment to one SSH target
+const DefaultDeployTimeout = 30 * time.Second
+
+// address returns host:port of the target
+func (t SSHDeployConfig) address() string {
+	if _, _, err := net.SplitHostPort(t.Host); err == nil {
+		return t.Host
+	}
+	return net.JoinHostPort(strings.Trim(t.Host, "[]"), "22")
+}
+
+// String identifies the target in logs and in the state file
+func (t SSHDeployConfig) String() string {
+	return "ssh://" + t.User + "@" + t.address()
+}
+
+// configRelative resolves a path relative to the directory of the config file
+func (cfg *Config) configRelative(path string) string {
+	if path == "" || filepath.IsAbs(path) || cfg.configPath == "" {
+		return path
+	}
+	return filepath.Join(filepath.Dir(cfg.configPath), path)
+}
+
+// sshClientConfig builds the client configuration of a target. The returned
+// function releases the connection to the SSH agent, if one was used.
+func sshClientConfig(cfg *Config, t SSHDeployConfig) (*ssh.ClientConfig, func(), error) {
+	release := func() {}
+
+	var auth ssh.AuthMethod
+	if t.KeyFile != "" {
+		keyFile := cfg.configRelative(t.KeyFile)
+		keyPEM, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, release, fmt.Errorf("reading SSH key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(keyPEM)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, release, fmt.Errorf("SSH key %s is protected by a passphrase, load it into the SSH agent and omit key_file", keyFile)
+		} else if err != nil {
+			return nil, release, fmt.Errorf("parsing SSH key %s: %w", keyFile, err)
+		}
+		auth = ssh.PublicKeys(signer)
+	} else {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, release, errors.New("no key_file configured and no SSH agent running (SSH_AUTH_SOCK)")
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, release, fmt.Errorf("connecting to SSH agent: %w", err)
+		}
+		release = func() { _ = conn.Close() }
+		auth = ssh.PublicKeysCallback(agent.NewClient(conn).Signers)
+	}
+
+	// The host key is always verified, either pinned or from known_hosts
+	var hostKeyCallback ssh.HostKeyCallback
+	if t.HostKey != "" {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(t.HostKey))
+		if err != nil {
+			release()
+			return nil, func() {}, fmt.Errorf("parsing host_key: %w", err)
+		}
+		hostKeyCallback = ssh.FixedHostKey(pub)
+	} else {
+		knownHostsFile := cfg.configRelative(t.KnownHostsFile)
+		if knownHostsFile == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				release()
+				return nil, func() {}, fmt.Errorf("locating known_hosts: %w", err)
+			}
+			knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		callback, err := knownhosts.New(knownHostsFile)
+		if err != nil {
+			release()
+			return nil, func() {}, fmt.Errorf("reading known_hosts: %w", err)
+		}
+		hostKeyCallback = callback
+	}
+
+	return &ssh.ClientConfig{
+		User:            t.User,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         t.timeout(),
+	}, release, nil
+}
+
+// timeout returns the configured timeout or the default
+func (t SSHDeployConfig) timeout() time.Duration {
+	if t.Timeout > 0 {
+		return t.Timeout
+	}
+	return DefaultDeployTimeout
+}
+
+// shellQuote quotes a string for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// deploySSH copies the files to the target and runs its reload command.
+// Every file is written to a temporary name and renamed, so services never
+// read a half-written certificate.
+func deploySSH(ctx context.Context, cfg *Config, t SSHDeployConfig, files []deployFile) error {
+	target := t.String()
+	fail := func(step, path string, err error) error {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("%w: %v", ctx.Err(), err)
+		}
+		return &DeployError{Target: target, Step: step, Path: path, Err: err}
+	}
+
+	clientConfig, release, err := sshClientConfig(cfg, t)
+	defer release()
+	if err != nil {
+		return &DeployError{Target: target, Step: DeployStepConnect, Err: err}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout())
+	defer cancel()
+	dialer := net.Dialer{Timeout: t.timeout()}
+	conn, err := dialer.DialContext(ctx, "tcp", t.address())
+	if err != nil {
+		return fail(DeployStepConnect, "", err)
+	}
+	// Closing the connection aborts whatever runs when the deadline passes
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, t.address(), clientConfig)
+	if err != nil {
+		_ = conn.Close()
+		return fail(DeployStepConnect, "", err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer func() { _ = client.Close() }()
+
+	for _, f := range files {
+		mode := "644"
+		if f.private {
+			mode = "600"
+		}
+		tmp := f.remotePath + ".tmp"
+		cmd := fmt.Sprintf("umask 077 && cat > %s && chmod %s %s && mv -f %s %s",
+			shellQuote(tmp), mode, shellQuote(tmp), shellQuote(tmp), shellQuote(f.remotePath))
+		if out, err := runSSH(client, cmd, f.data); err != nil {
+			deployErr := fail(DeployStepUpload, f.remotePath, err).(*DeployError)
+			deployErr.Output = out
+			return deployErr
+		}
+	}
+
+	if t.ReloadCommand != "" {
+		if out, err := runSSH(client, t.ReloadCommand, nil); err != nil {
+			deployErr := fail(DeployStepReload, "", err).(*DeployError)
+			deployErr.Output = out
+			return deployErr
+		}
+	}
+	return nil
+}
+
+// runSSH runs a command in a new session with stdin, returning its trimmed output
+func runSSH(client *ssh.Client, cmd string, stdin []byte) (string, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = session.Close() }()
+	session.Stdin = bytes.NewReader(stdin)
+	out, err := session.CombinedOutput(cmd)
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,290 @@
+package manager
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer runs commands of an SSH client with the local shell. It
+// returns its address and host key in authorized_keys format.
+func testSSHServer(t *testing.T, clientKey ssh.PublicKey) (string, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("needs a POSIX shell")
+	}
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	serverConfig.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSSH(conn, serverConfig)
+		}
+	}()
+	return listener.Addr().String(), string(ssh.MarshalAuthorizedKey(hostSigner.PublicKey()))
+}
+
+func serveTestSSH(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer func() { _ = channel.Close() }()
+			for req := range requests {
+				if req.Type != "exec" || len(req.Payload) < 4 {
+					_ = req.Reply(false, nil)
+					continue
+				}
+				_ = req.Reply(true, nil)
+				command := string(req.Payload[4 : 4+binary.BigEndian.Uint32(req.Payload)])
+				cmd := exec.Command("sh", "-c", command)
+				cmd.Stdin, cmd.Stdout, cmd.Stderr = channel, channel, channel.Stderr()
+				status := uint32(0)
+				if err := cmd.Run(); err != nil {
+					status = 1
+				}
+				_, _ = channel.SendRequest("exit-status", false, binary.BigEndian.AppendUint32(nil, status))
+				return
+			}
+		}()
+	}
+}
+
+// newDeployTestConfig creates a storage directory with certificate 'web' and
+// an SSH key for deployments
+func newDeployTestConfig(t *testing.T) (*Config, string, ssh.PublicKey) {
+	t.Helper()
+	storage := t.TempDir()
+	if err := os.MkdirAll(certinfo.CertificatesDir(storage), DirPermissions); err != nil {
+		t.Fatal(err)
+	}
+	paths := certinfo.PathsFor(storage, "web")
+	if err := createTestCertificateWithDomains(paths.Certificate, paths.PrivateKey, []string{"web.example.com"}); err != nil {
+		t.Fatalf("Failed to create test certificate: %v", err)
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{CertStoragePath: storage, AutoDomains: &AutoDomainsConfig{Certs: map[string]CertConfig{
+		"web": {Domains: []string{"web.example.com"}},
+	}}}
+	return cfg, keyFile, sshPub
+}
+
+func setDeploy(cfg *Config, target SSHDeployConfig) {
+	certCfg := cfg.AutoDomains.Certs["web"]
+	certCfg.Deploy = &DeployConfig{SSH: []SSHDeployConfig{target}}
+	cfg.AutoDomains.Certs["web"] = certCfg
+}
+
+func TestDeployCertificate_SSH(t *testing.T) {
+	cfg, keyFile, clientKey := newDeployTestConfig(t)
+	addr, hostKey := testSSHServer(t, clientKey)
+	remote := t.TempDir()
+	target := SSHDeployConfig{
+		Host:          addr,
+		User:          "deploy",
+		KeyFile:       keyFile,
+		HostKey:       hostKey,
+		CertPath:      filepath.Join(remote, "it's web.crt"),
+		KeyPath:       filepath.Join(remote, "web.key"),
+		ReloadCommand: "touch " + shellQuote(filepath.Join(remote, "reloaded")),
+	}
+	setDeploy(cfg, target)
+
+	results, err := DeployCertificate(context.Background(), cfg, "web")
+	if err != nil {
+		t.Fatalf("DeployCertificate failed: %v", err)
+	}
+	if len(results) != 1 || !results[0].Deployed || results[0].Err != nil {
+		t.Fatalf("Expected one successful deployment, got %+v", results)
+	}
+
+	local, _ := os.ReadFile(certinfo.PathsFor(cfg.CertStoragePath, "web").Certificate)
+	copied, err := os.ReadFile(target.CertPath)
+	if err != nil || string(copied) != string(local) {
+		t.Errorf("Expected the certificate at %s: %v", target.CertPath, err)
+	}
+	info, err := os.Stat(target.KeyPath)
+	if err != nil {
+		t.Fatalf("Expected the key at %s: %v", target.KeyPath, err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected remote key mode 0600, got %04o", info.Mode().Perm())
+	}
+	if _, err := os.Stat(filepath.Join(remote, "reloaded")); err != nil {
+		t.Error("Expected the reload command to run")
+	}
+
+	// Nothing changed, nothing to deploy
+	_ = os.Remove(filepath.Join(remote, "reloaded"))
+	results, err = DeployCertificate(context.Background(), cfg, "web")
+	if err != nil {
+		t.Fatalf("DeployCertificate (second) failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Deployed || results[0].Err != nil {
+		t.Errorf("Expected the deployment to be skipped, got %+v", results)
+	}
+	if _, err := os.Stat(filepath.Join(remote, "reloaded")); err == nil {
+		t.Error("Expected no reload without a new certificate")
+	}
+}
+
+func TestDeployCertificate_SSHHostKeyMismatch(t *testing.T) {
+	cfg, keyFile, clientKey := newDeployTestConfig(t)
+	addr, _ := testSSHServer(t, clientKey)
+	_, otherHostKey := testSSHServer(t, clientKey)
+	setDeploy(cfg, SSHDeployConfig{
+		Host: addr, User: "deploy", KeyFile: keyFile, HostKey: otherHostKey,
+		CertPath: filepath.Join(t.TempDir(), "web.crt"), KeyPath: filepath.Join(t.TempDir(), "web.key"),
+	})
+
+	results, err := DeployCertificate(context.Background(), cfg, "web")
+	if err != nil {
+		t.Fatalf("DeployCertificate failed: %v", err)
+	}
+	var deployErr *DeployError
+	if len(results) != 1 || !errors.As(results[0].Err, &deployErr) || deployErr.Step != DeployStepConnect {
+		t.Fatalf("Expected a connect error, got %+v", results)
+	}
+	if !strings.Contains(deployErr.Error(), "host key mismatch") {
+		t.Errorf("Expected a host key mismatch, got %v", deployErr)
+	}
+}
+
+func TestDeployCertificate_SSHReloadFails(t *testing.T) {
+	cfg, keyFile, clientKey := newDeployTestConfig(t)
+	addr, hostKey := testSSHServer(t, clientKey)
+	remote := t.TempDir()
+	setDeploy(cfg, SSHDeployConfig{
+		Host: addr, User: "deploy", KeyFile: keyFile, HostKey: hostKey,
+		CertPath: filepath.Join(remote, "web.crt"), KeyPath: filepath.Join(remote, "web.key"),
+		ReloadCommand: "echo nginx: configuration test failed; exit 1",
+	})
+
+	results, err := DeployCertificate(context.Background(), cfg, "web")
+	if err != nil {
+		t.Fatalf("DeployCertificate failed: %v", err)
+	}
+	var deployErr *DeployError
+	if len(results) != 1 || !errors.As(results[0].Err, &deployErr) || deployErr.Step != DeployStepReload {
+		t.Fatalf("Expected a reload error, got %+v", results)
+	}
+	if deployErr.Output != "nginx: configuration test failed" {
+		t.Errorf("Expected the command output, got %q", deployErr.Output)
+	}
+
+	// The failed target is retried on the next run
+	state, err := LoadState(cfg.CertStoragePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Certificates["web"].Deployments) != 0 {
+		t.Errorf("Expected no recorded deployment, got %v", state.Certificates["web"].Deployments)
+	}
+}
+
+func TestDeployCertificate_NotConfigured(t *testing.T) {
+	cfg, _, _ := newDeployTestConfig(t)
+	results, err := DeployCertificate(context.Background(), cfg, "web")
+	if err != nil || results != nil {
+		t.Errorf("Expected nothing without deploy configuration, got %+v, %v", results, err)
+	}
+}
+
+func TestSSHDeployConfig_String(t *testing.T) {
+	for host, want := range map[string]string{
+		"web1.example.com":      "ssh://deploy@web1.example.com:22",
+		"web1.example.com:2222": "ssh://deploy@web1.example.com:2222",
+		"2001:db8::1":           "ssh://deploy@[2001:db8::1]:22",
+		"[2001:db8::1]:2222":    "ssh://deploy@[2001:db8::1]:2222",
+	} {
+		if got := (SSHDeployConfig{Host: host, User: "deploy"}).String(); got != want {
+			t.Errorf("String() for %s = %s, want %s", host, got, want)
+		}
+	}
+}
+
+func TestUpdateCertState_KeepsDeployments(t *testing.T) {
+	storage := t.TempDir()
+	if err := RecordDeployment(storage, "web", "ssh://deploy@web1:22", "abc"); err != nil {
+		t.Fatalf("RecordDeployment failed: %v", err)
+	}
+	if err := UpdateCertState(storage, "web", CertState{LastResult: "renewed"}); err != nil {
+		t.Fatalf("UpdateCertState failed: %v", err)
+	}
+	state, err := LoadState(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Certificates["web"].Deployments["ssh://deploy@web1:22"] != "abc" {
+		t.Errorf("Expected the deployment to survive the update, got %+v", state.Certificates["web"])
+	}
+}
@@ -0,0 +1,136 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// authoritativeDNSPort is the port authoritative nameservers are queried on,
+// replaced in tests
+var authoritativeDNSPort = "53"
+
+// nsLookuper is implemented by resolvers that can discover the nameservers of
+// a zone for the authoritative pre-check
+type nsLookuper interface {
+	LookupNS(ctx context.Context, name string) ([]*net.NS, error)
+}
+
+// LookupNS implements nsLookuper using the system resolver
+func (r *DefaultDNSResolver) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	return r.Resolver.LookupNS(ctx, name)
+}
+
+// LookupNS implements nsLookuper through the encrypted resolver
+func (r *encryptedResolver) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	answer, err := r.query(ctx, name, dns.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+	var servers []*net.NS
+	for _, rr := range answer {
+		if ns, ok := rr.(*dns.NS); ok {
+			servers = append(servers, &net.NS{Host: ns.Ns})
+		}
+	}
+	return servers, nil
+}
+
+// ChecksAuthoritative reports whether the CNAME pre-check also queries every
+// authoritative nameserver of the zone
+func (c *Config) ChecksAuthoritative() bool {
+	return c.DNSPrecheck != nil && c.DNSPrecheck.Authoritative
+}
+
+// zoneNameservers finds the zone of a name by walking up its labels until a
+// name with NS records is found, and returns the nameserver host names sorted
+func zoneNameservers(ctx context.Context, resolver nsLookuper, name string) (string, []string, error) {
+	labels := dns.SplitDomainName(name)
+	// Stop at the registrable domain, the public suffix above it, e.g. co.uk,
+	// has NS records of its own but never holds the record
+	for i := 0; i < len(labels)-1; i++ {
+		zone := strings.Join(labels[i:], ".")
+		if IsPublicSuffix(zone) {
+			break
+		}
+		servers, err := resolver.LookupNS(ctx, zone)
+		if err != nil || len(servers) == 0 {
+			continue
+		}
+		var hosts []string
+		for _, ns := range servers {
+			hosts = append(hosts, strings.TrimSuffix(ns.Host, "."))
+		}
+		sort.Strings(hosts)
+		return zone, hosts, nil
+	}
+	return "", nil, fmt.Errorf("no authoritative nameservers found for %s", name)
+}
+
+// queryAuthoritativeCNAME asks one nameserver directly for the CNAME of a name
+func queryAuthoritativeCNAME(ctx context.Context, server, name string) (string, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), dns.TypeCNAME)
+	msg.RecursionDesired = false
+
+	client := &dns.Client{Timeout: DefaultDNSTimeout * time.Second}
+	resp, _, err := client.ExchangeContext(ctx, msg, net.JoinHostPort(server, authoritativeDNSPort))
+	if err == nil && resp.Truncated {
+		client.Net = "tcp"
+		resp, _, err = client.ExchangeContext(ctx, msg, net.JoinHostPort(server, authoritativeDNSPort))
+	}
+	if err != nil {
+		return "", err
+	}
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return "", fmt.Errorf("server returned %s", dns.RcodeToString[resp.Rcode])
+	}
+	for _, rr := range resp.Answer {
+		if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, dns.Fqdn(name)) {
+			return strings.TrimSuffix(cname.Target, "."), nil
+		}
+	}
+	return "", nil
+}
+
+// checkAuthoritativeCNAME queries every authoritative nameserver of the zone
+// for the challenge CNAME and returns the nameservers without the expected
+// record, so partially propagated or split-brain zones are caught before the
+// ACME order. Resolvers that cannot discover nameservers skip the check.
+func checkAuthoritativeCNAME(ctx context.Context, resolver DNSResolver, challengeDomain, expectedTarget string) ([]string, error) {
+	lookuper, ok := resolver.(nsLookuper)
+	if !ok {
+		DefaultLogger.Debugf("Resolver %T cannot look up nameservers, skipping authoritative check of %s", resolver, challengeDomain)
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, DefaultDNSTimeout*time.Second)
+	defer cancel()
+	zone, servers, err := zoneNameservers(ctx, lookuper, GetBaseDomain(strings.TrimPrefix(challengeDomain, acmeChallengePrefix+".")))
+	if err != nil {
+		return nil, err
+	}
+	DefaultLogger.Debugf("Checking %s on the %d nameservers of %s: %s", challengeDomain, len(servers), zone, strings.Join(servers, ", "))
+
+	var missing []string
+	for _, server := range servers {
+		target, err := queryAuthoritativeCNAME(ctx, server, challengeDomain)
+		switch {
+		case err != nil:
+			DefaultLogger.Warnf("Nameserver %s could not be asked for %s: %v", server, challengeDomain, err)
+			missing = append(missing, server)
+		case target == "":
+			DefaultLogger.Warnf("CNAME record for %s is missing on nameserver %s", challengeDomain, server)
+			missing = append(missing, server)
+		case !strings.EqualFold(target, strings.TrimSuffix(expectedTarget, ".")):
+			DefaultLogger.Warnf("CNAME record for %s on nameserver %s points to %s instead of %s", challengeDomain, server, target, expectedTarget)
+			missing = append(missing, server)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,189 @@
+package manager
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// zoneResolver is a recursive resolver that already sees the CNAME records
+// and knows the nameservers of example.com
+type zoneResolver struct {
+	records     map[string]string
+	nameservers []string
+}
+
+func (r *zoneResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	if target, ok := r.records[host]; ok {
+		return target + ".", nil
+	}
+	return "", &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (r *zoneResolver) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	if name != "example.com" {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	var servers []*net.NS
+	for _, host := range r.nameservers {
+		servers = append(servers, &net.NS{Host: host + "."})
+	}
+	return servers, nil
+}
+
+// authoritativeServer answers CNAME queries from its records
+type authoritativeServer struct {
+	mu      sync.Mutex
+	records map[string]string
+}
+
+func (s *authoritativeServer) set(name, target string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[name] = target
+}
+
+func (s *authoritativeServer) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+	name := req.Question[0].Name
+	if target, ok := s.records[name]; ok {
+		rr, _ := dns.NewRR(name + " 300 IN CNAME " + target)
+		resp.Answer = append(resp.Answer, rr)
+	} else {
+		resp.Rcode = dns.RcodeNameError
+	}
+	_ = w.WriteMsg(resp)
+}
+
+// startAuthoritativeServers runs two nameservers on 127.0.0.1 and 127.0.0.2
+// sharing one port, as the port of authoritative queries is fixed
+func startAuthoritativeServers(t *testing.T) (*authoritativeServer, *authoritativeServer) {
+	t.Helper()
+	first, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(first.LocalAddr().String())
+	second, err := net.ListenPacket("udp", net.JoinHostPort("127.0.0.2", port))
+	if err != nil {
+		_ = first.Close()
+		t.Skipf("Cannot listen on 127.0.0.2: %v", err)
+	}
+
+	var servers []*authoritativeServer
+	for _, conn := range []net.PacketConn{first, second} {
+		handler := &authoritativeServer{records: make(map[string]string)}
+		server := &dns.Server{PacketConn: conn, Handler: handler}
+		go func() { _ = server.ActivateAndServe() }()
+		t.Cleanup(func() { _ = server.Shutdown() })
+		servers = append(servers, handler)
+	}
+
+	previous := authoritativeDNSPort
+	authoritativeDNSPort = port
+	t.Cleanup(func() { authoritativeDNSPort = previous })
+	return servers[0], servers[1]
+}
+
+func TestCheckAuthoritativeCNAME(t *testing.T) {
+	ns1, ns2 := startAuthoritativeServers(t)
+	ns1.set("_acme-challenge.example.com.", "abc.auth.example.org.")
+	ns2.set("_acme-challenge.example.com.", "old.auth.example.org.")
+	resolver := &zoneResolver{nameservers: []string{"127.0.0.2", "127.0.0.1"}}
+
+	missing, err := checkAuthoritativeCNAME(context.Background(), resolver, "_acme-challenge.example.com", "abc.auth.example.org")
+	if err != nil {
+		t.Fatalf("checkAuthoritativeCNAME failed: %v", err)
+	}
+	if want := []string{"127.0.0.2"}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("Expected %v to miss the record, got %v", want, missing)
+	}
+
+	// A resolver without nameserver discovery skips the check
+	missing, err = checkAuthoritativeCNAME(context.Background(), &appearingResolver{}, "_acme-challenge.example.com", "abc.auth.example.org")
+	if err != nil || missing != nil {
+		t.Errorf("Expected the check to be skipped, got %v, %v", missing, err)
+	}
+}
+
+// suffixResolver only knows the nameservers of the public suffix co.uk
+type suffixResolver struct{}
+
+func (suffixResolver) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	if name != "co.uk" {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return []*net.NS{{Host: "nsa.nic.uk."}}, nil
+}
+
+func TestZoneNameservers_NotFound(t *testing.T) {
+	if _, _, err := zoneNameservers(context.Background(), &zoneResolver{}, "www.example.net"); err == nil {
+		t.Error("Expected an error without nameservers")
+	}
+	// The nameservers of a public suffix are not those of the zone
+	if zone, _, err := zoneNameservers(context.Background(), suffixResolver{}, "_acme-challenge.www.example.co.uk"); err == nil {
+		t.Errorf("Expected an error instead of the public suffix %s", zone)
+	}
+}
+
+func TestPreCheckAcmeDNS_Authoritative(t *testing.T) {
+	ns1, _ := startAuthoritativeServers(t)
+	ns1.set("_acme-challenge.example.com.", "abc.auth.example.org.")
+	resolver := &zoneResolver{
+		records:     map[string]string{"_acme-challenge.example.com": "abc.auth.example.org"},
+		nameservers: []string{"127.0.0.1", "127.0.0.2"},
+	}
+
+	store, err := NewAccountStore(filepath.Join(t.TempDir(), "accounts.json"))
+	if err != nil {
+		t.Fatalf("Failed to create account store: %v", err)
+	}
+	store.SetAccount("example.com", AcmeDnsAccount{Username: "u", Password: "p", FullDomain: "abc.auth.example.org", SubDomain: "abc"})
+
+	// Without the option the resolver answer is enough
+	cfg := &Config{}
+	setupInfo, err := PreCheckAcmeDNSWithResolver(cfg, store, []string{"example.com", "*.example.com"}, resolver)
+	if err != nil || setupInfo != nil {
+		t.Fatalf("Expected no setup needed, got %v, %v", setupInfo, err)
+	}
+
+	cfg.DNSPrecheck = &DNSPrecheckConfig{Authoritative: true}
+	setupInfo, err = PreCheckAcmeDNSWithResolver(cfg, store, []string{"example.com", "*.example.com"}, resolver)
+	if err != nil {
+		t.Fatalf("PreCheckAcmeDNSWithResolver failed: %v", err)
+	}
+	want := []DNSSetupInfo{{ChallengeDomain: "_acme-challenge.example.com", TargetDomain: "abc.auth.example.org", MissingOn: []string{"127.0.0.2"}}}
+	if !reflect.DeepEqual(setupInfo, want) {
+		t.Errorf("Expected %+v, got %+v", want, setupInfo)
+	}
+}
+
+func TestWaitForDNSSetup_Authoritative(t *testing.T) {
+	ns1, ns2 := startAuthoritativeServers(t)
+	ns1.set("_acme-challenge.example.com.", "abc.auth.example.org.")
+	resolver := &zoneResolver{
+		records:     map[string]string{"_acme-challenge.example.com": "abc.auth.example.org"},
+		nameservers: []string{"127.0.0.1", "127.0.0.2"},
+	}
+	setupInfo := []DNSSetupInfo{{ChallengeDomain: "_acme-challenge.example.com", TargetDomain: "abc.auth.example.org", MissingOn: []string{"127.0.0.2"}}}
+
+	if pending := pendingDNSRecords(context.Background(), resolver, setupInfo); len(pending) != 1 {
+		t.Fatalf("Expected the record to be pending, got %+v", pending)
+	}
+
+	ns2.set("_acme-challenge.example.com.", "abc.auth.example.org.")
+	opts := &DNSWaitOptions{Timeout: 5 * time.Second, Interval: 10 * time.Millisecond}
+	if err := WaitForDNSSetup(context.Background(), opts, resolver, setupInfo); err != nil {
+		t.Fatalf("Expected the record to be found on all nameservers, got %v", err)
+	}
+}
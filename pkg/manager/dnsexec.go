@@ -0,0 +1,151 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// DefaultDNSRecordTTL is the TTL used for CNAME records created by DNS setup executors
+const DefaultDNSRecordTTL = 300
+
+// DNSSetupExecutor applies the _acme-challenge CNAME records required by acme-dns.
+// DisplayDNSInstructions is the manual counterpart used for every record that no
+// configured executor is responsible for.
+type DNSSetupExecutor interface {
+	// Name identifies the executor in log messages
+	Name() string
+	// Handles reports whether the executor manages the zone of the given record name
+	Handles(fqdn string) bool
+	// Apply creates or updates a CNAME record pointing fqdn at target
+	Apply(ctx context.Context, fqdn, target string) error
+}
+
+// zoneMatcher implements Handles for executors that manage a fixed list of zones
+type zoneMatcher []string
+
+// Handles reports whether fqdn lies within one of the zones
+func (z zoneMatcher) Handles(fqdn string) bool {
+	return z.zoneFor(fqdn) != ""
+}
+
+// zoneFor returns the most specific zone containing fqdn, or "" if none does
+func (z zoneMatcher) zoneFor(fqdn string) string {
+	name := strings.ToLower(strings.TrimSuffix(fqdn, "."))
+	best := ""
+	for _, zone := range z {
+		zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+		if (name == zone || strings.HasSuffix(name, "."+zone)) && len(zone) > len(best) {
+			best = zone
+		}
+	}
+	return best
+}
+
+// recordTTL returns the configured TTL or the default
+func (p DNSProviderConfig) recordTTL() int {
+	if p.TTL > 0 {
+		return p.TTL
+	}
+	return DefaultDNSRecordTTL
+}
+
+// NewDNSSetupExecutor creates the executor for a provider configuration
+func NewDNSSetupExecutor(p DNSProviderConfig) (DNSSetupExecutor, error) {
+	if len(p.Zones) == 0 {
+		return nil, fmt.Errorf("dns provider %s: at least one zone is required", p.Type)
+	}
+	switch p.Type {
+	case "cloudflare":
+		return newCloudflareExecutor(p)
+	case "route53":
+		return newRoute53Executor(p)
+	case "rfc2136":
+		return newRFC2136Executor(p)
+	default:
+		return nil, fmt.Errorf("unknown dns provider type %q", p.Type)
+	}
+}
+
+// NewDNSSetupExecutors creates executors for all configured DNS providers
+func NewDNSSetupExecutors(cfg *Config) ([]DNSSetupExecutor, error) {
+	var executors []DNSSetupExecutor
+	for i, p := range cfg.DNSProviders {
+		exec, err := NewDNSSetupExecutor(p)
+		if err != nil {
+			return nil, fmt.Errorf("dns_providers[%d]: %w", i, err)
+		}
+		executors = append(executors, exec)
+	}
+	return executors, nil
+}
+
+// ApplyDNSSetup hands every required record to the first executor managing its zone.
+// It returns the records that still need manual action: records no executor handles
+// and records whose executor failed. Failures are logged, not returned, so the
+// user always gets instructions for whatever is left.
+func ApplyDNSSetup(ctx context.Context, executors []DNSSetupExecutor, setupInfo []DNSSetupInfo) []DNSSetupInfo {
+	if len(executors) == 0 {
+		return setupInfo
+	}
+
+	var remaining []DNSSetupInfo
+	for _, info := range setupInfo {
+		var exec DNSSetupExecutor
+		for _, e := range executors {
+			if e.Handles(info.ChallengeDomain) {
+				exec = e
+				break
+			}
+		}
+		if exec == nil {
+			remaining = append(remaining, info)
+			continue
+		}
+
+		target := strings.TrimSuffix(info.TargetDomain, ".")
+		DefaultLogger.Infof("Creating CNAME %s -> %s via %s", info.ChallengeDomain, target, exec.Name())
+		if err := exec.Apply(ctx, info.ChallengeDomain, target); err != nil {
+			DefaultLogger.Errorf("Failed to create CNAME for %s via %s: %v", info.ChallengeDomain, exec.Name(), err)
+			remaining = append(remaining, info)
+			continue
+		}
+		DefaultLogger.Infof("CNAME for %s created via %s", info.ChallengeDomain, exec.Name())
+	}
+	return remaining
+}
+
+// HandleDNSSetup applies required records through the configured DNS providers and
+// prints manual instructions for the rest. It returns ErrDNSSetupNeeded if manual
+// action is required, nil once every record was created automatically and can be
+// resolved or, with cfg.DNSWait set, appeared while waiting.
+func HandleDNSSetup(ctx context.Context, cfg *Config, setupInfo []DNSSetupInfo) error {
+	executors, err := NewDNSSetupExecutors(cfg)
+	if err != nil {
+		return err
+	}
+
+	remaining := ApplyDNSSetup(ctx, executors, setupInfo)
+	if len(remaining) == 0 {
+		return WaitForCreatedDNSRecords(ctx, cfg, NewPrecheckResolver(cfg), setupInfo)
+	}
+
+	ReportDNSSetup(cfg, remaining)
+	if cfg.DNSWait != nil {
+		// The records created through providers must have propagated as well
+		return WaitForDNSSetup(ctx, cfg.DNSWait, NewPrecheckResolver(cfg), setupInfo)
+	}
+	return ErrDNSSetupNeeded
+}
+
+// WaitForCreatedDNSRecords waits until the records DNS providers have just
+// created resolve, so the ACME server is not asked to validate before they
+// propagated. It waits as long as -wait-for-dns would, with its defaults if
+// the option is not set.
+func WaitForCreatedDNSRecords(ctx context.Context, cfg *Config, resolver DNSResolver, created []DNSSetupInfo) error {
+	if len(created) == 0 {
+		return nil
+	}
+	DefaultLogger.Infof("Waiting for %d CNAME record(s) created via DNS providers to propagate", len(created))
+	return WaitForDNSSetup(ctx, cfg.DNSWait, resolver, created)
+}
@@ -0,0 +1,155 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// cloudflareAPIBase is the Cloudflare v4 API endpoint
+const cloudflareAPIBase = "https://api.cloudflare.com/client/v4"
+
+// cloudflareExecutor creates CNAME records through the Cloudflare v4 API
+type cloudflareExecutor struct {
+	zoneMatcher
+	token   string
+	zoneID  string // ID of idZone, the first configured zone
+	idZone  string
+	ttl     int
+	baseURL string
+	client  *http.Client
+}
+
+func newCloudflareExecutor(p DNSProviderConfig) (*cloudflareExecutor, error) {
+	if p.APIToken == "" {
+		return nil, fmt.Errorf("dns provider cloudflare: api_token is required")
+	}
+	return &cloudflareExecutor{
+		zoneMatcher: zoneMatcher(p.Zones),
+		token:       p.APIToken,
+		zoneID:      p.ZoneID,
+		idZone:      zoneMatcher(p.Zones[:1]).zoneFor(p.Zones[0]),
+		ttl:         p.recordTTL(),
+		baseURL:     cloudflareAPIBase,
+		client:      &http.Client{Timeout: DefaultHTTPTimeout},
+	}, nil
+}
+
+// Name implements DNSSetupExecutor
+func (c *cloudflareExecutor) Name() string { return "cloudflare" }
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+	Proxied bool   `json:"proxied"`
+}
+
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+// Apply implements DNSSetupExecutor. An existing CNAME with the same name is
+// updated in place, otherwise a new record is created.
+func (c *cloudflareExecutor) Apply(ctx context.Context, fqdn, target string) error {
+	name := strings.TrimSuffix(fqdn, ".")
+
+	// zone_id belongs to the first zone, records in the other zones are looked up
+	zone := c.zoneFor(name)
+	zoneID := c.zoneID
+	if zoneID == "" || zone != c.idZone {
+		var err error
+		if zoneID, err = c.lookupZoneID(ctx, zone); err != nil {
+			return err
+		}
+	}
+
+	var existing []cloudflareRecord
+	query := url.Values{"type": {"CNAME"}, "name": {name}}
+	if err := c.do(ctx, http.MethodGet, "/zones/"+zoneID+"/dns_records?"+query.Encode(), nil, &existing); err != nil {
+		return fmt.Errorf("listing records for %s: %w", name, err)
+	}
+
+	record := cloudflareRecord{Type: "CNAME", Name: name, Content: target, TTL: c.ttl}
+	if len(existing) > 0 {
+		if strings.EqualFold(strings.TrimSuffix(existing[0].Content, "."), target) {
+			return nil
+		}
+		if err := c.do(ctx, http.MethodPut, "/zones/"+zoneID+"/dns_records/"+existing[0].ID, record, nil); err != nil {
+			return fmt.Errorf("updating record %s: %w", name, err)
+		}
+		return nil
+	}
+
+	if err := c.do(ctx, http.MethodPost, "/zones/"+zoneID+"/dns_records", record, nil); err != nil {
+		return fmt.Errorf("creating record %s: %w", name, err)
+	}
+	return nil
+}
+
+// lookupZoneID resolves a zone name to its Cloudflare zone ID
+func (c *cloudflareExecutor) lookupZoneID(ctx context.Context, zone string) (string, error) {
+	var zones []struct {
+		ID string `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/zones?"+url.Values{"name": {zone}}.Encode(), nil, &zones); err != nil {
+		return "", fmt.Errorf("looking up zone %s: %w", zone, err)
+	}
+	if len(zones) == 0 {
+		return "", fmt.Errorf("zone %s not found in Cloudflare account", zone)
+	}
+	return zones[0].ID, nil
+}
+
+// do performs an API call and decodes the 'result' field into out if it is not nil
+func (c *cloudflareExecutor) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var parsed cloudflareResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return fmt.Errorf("decoding response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if !parsed.Success {
+		var msgs []string
+		for _, e := range parsed.Errors {
+			msgs = append(msgs, fmt.Sprintf("%d: %s", e.Code, e.Message))
+		}
+		return fmt.Errorf("cloudflare API error (HTTP %d): %s", resp.StatusCode, strings.Join(msgs, "; "))
+	}
+	if out != nil {
+		return json.Unmarshal(parsed.Result, out)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeCloudflare serves the subset of the v4 API used by the executor
+type fakeCloudflare struct {
+	records map[string]cloudflareRecord
+	calls   []string
+}
+
+func (f *fakeCloudflare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.calls = append(f.calls, r.Method+" "+r.URL.Path)
+	reply := func(result interface{}) {
+		data, _ := json.Marshal(result)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": json.RawMessage(data)})
+	}
+
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":9109,"message":"Invalid access token"}]}`))
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/zones":
+		if r.URL.Query().Get("name") == "example.com" {
+			reply([]map[string]string{{"id": "zone1"}})
+		} else {
+			reply([]map[string]string{})
+		}
+	case r.Method == http.MethodGet && r.URL.Path == "/zones/zone1/dns_records":
+		var out []cloudflareRecord
+		if rec, ok := f.records[r.URL.Query().Get("name")]; ok {
+			out = append(out, rec)
+		}
+		reply(out)
+	case (r.Method == http.MethodPost || r.Method == http.MethodPut) && strings.HasPrefix(r.URL.Path, "/zones/zone1/dns_records"):
+		var rec cloudflareRecord
+		_ = json.NewDecoder(r.Body).Decode(&rec)
+		rec.ID = "rec-" + rec.Name
+		f.records[rec.Name] = rec
+		reply(rec)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"success":false,"errors":[{"code":7003,"message":"not found"}]}`))
+	}
+}
+
+func newTestCloudflare(t *testing.T, token string) (*cloudflareExecutor, *fakeCloudflare) {
+	t.Helper()
+	fake := &fakeCloudflare{records: make(map[string]cloudflareRecord)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	exec, err := newCloudflareExecutor(DNSProviderConfig{Type: "cloudflare", Zones: []string{"example.com"}, APIToken: token})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	exec.baseURL = server.URL
+	return exec, fake
+}
+
+func TestCloudflareExecutor_CreateAndUpdate(t *testing.T) {
+	exec, fake := newTestCloudflare(t, "secret")
+	ctx := context.Background()
+
+	if err := exec.Apply(ctx, "_acme-challenge.example.com", "abc.auth.example.net"); err != nil {
+		t.Fatalf("Expected create to succeed, got %v", err)
+	}
+	rec := fake.records["_acme-challenge.example.com"]
+	if rec.Type != "CNAME" || rec.Content != "abc.auth.example.net" || rec.TTL != DefaultDNSRecordTTL || rec.Proxied {
+		t.Errorf("Unexpected record created: %+v", rec)
+	}
+
+	// Same target again is a no-op
+	fake.calls = nil
+	if err := exec.Apply(ctx, "_acme-challenge.example.com", "abc.auth.example.net"); err != nil {
+		t.Fatalf("Expected no-op to succeed, got %v", err)
+	}
+	for _, call := range fake.calls {
+		if !strings.HasPrefix(call, "GET") {
+			t.Errorf("Expected only lookups for unchanged record, got %s", call)
+		}
+	}
+
+	// Different target updates the existing record
+	fake.calls = nil
+	if err := exec.Apply(ctx, "_acme-challenge.example.com", "xyz.auth.example.net"); err != nil {
+		t.Fatalf("Expected update to succeed, got %v", err)
+	}
+	if got := fake.calls[len(fake.calls)-1]; got != "PUT /zones/zone1/dns_records/rec-_acme-challenge.example.com" {
+		t.Errorf("Expected PUT of existing record, got %s", got)
+	}
+	if fake.records["_acme-challenge.example.com"].Content != "xyz.auth.example.net" {
+		t.Errorf("Expected record to be updated, got %+v", fake.records["_acme-challenge.example.com"])
+	}
+}
+
+func TestCloudflareExecutor_Errors(t *testing.T) {
+	exec, _ := newTestCloudflare(t, "wrong")
+	err := exec.Apply(context.Background(), "_acme-challenge.example.com", "abc.auth.example.net")
+	if err == nil || !strings.Contains(err.Error(), "Invalid access token") {
+		t.Errorf("Expected API error message, got %v", err)
+	}
+
+	exec, _ = newTestCloudflare(t, "secret")
+	exec.zoneMatcher = zoneMatcher{"example.org"}
+	err = exec.Apply(context.Background(), "_acme-challenge.example.org", "abc.auth.example.net")
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected zone not found error, got %v", err)
+	}
+}
+
+func TestCloudflareExecutor_ZoneIDOfFirstZone(t *testing.T) {
+	exec, fake := newTestCloudflare(t, "secret")
+	exec.zoneMatcher = zoneMatcher{"example.org", "example.com"}
+	exec.zoneID, exec.idZone = "zone-org", "example.org"
+
+	// zone_id belongs to example.org, the record in example.com needs its own ID
+	if err := exec.Apply(context.Background(), "_acme-challenge.www.example.com", "abc.auth.example.net"); err != nil {
+		t.Fatalf("Expected create to succeed, got %v", err)
+	}
+	if fake.calls[0] != "GET /zones" {
+		t.Errorf("Expected a zone lookup for example.com, got %v", fake.calls)
+	}
+	if _, ok := fake.records["_acme-challenge.www.example.com"]; !ok {
+		t.Errorf("Expected the record in zone1, got calls %v", fake.calls)
+	}
+}
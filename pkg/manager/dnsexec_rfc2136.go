@@ -0,0 +1,91 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// rfc2136Executor creates CNAME records with RFC 2136 dynamic updates,
+// the same mechanism used by nsupdate
+type rfc2136Executor struct {
+	zoneMatcher
+	nameserver    string
+	tsigKey       string
+	tsigSecret    string
+	tsigAlgorithm string
+	ttl           int
+}
+
+func newRFC2136Executor(p DNSProviderConfig) (*rfc2136Executor, error) {
+	if p.Nameserver == "" {
+		return nil, fmt.Errorf("dns provider rfc2136: nameserver is required")
+	}
+	if (p.TSIGKey == "") != (p.TSIGSecret == "") {
+		return nil, fmt.Errorf("dns provider rfc2136: tsig_key and tsig_secret must be set together")
+	}
+
+	nameserver := p.Nameserver
+	if _, _, err := net.SplitHostPort(nameserver); err != nil {
+		nameserver = net.JoinHostPort(nameserver, "53")
+	}
+
+	algorithm := dns.HmacSHA256
+	if p.TSIGAlgorithm != "" {
+		algorithm = dns.Fqdn(strings.ToLower(p.TSIGAlgorithm))
+		switch algorithm {
+		case dns.HmacSHA1, dns.HmacSHA224, dns.HmacSHA256, dns.HmacSHA384, dns.HmacSHA512:
+		default:
+			return nil, fmt.Errorf("dns provider rfc2136: unsupported tsig_algorithm %q", p.TSIGAlgorithm)
+		}
+	}
+
+	return &rfc2136Executor{
+		zoneMatcher:   zoneMatcher(p.Zones),
+		nameserver:    nameserver,
+		tsigKey:       p.TSIGKey,
+		tsigSecret:    p.TSIGSecret,
+		tsigAlgorithm: algorithm,
+		ttl:           p.recordTTL(),
+	}, nil
+}
+
+// Name implements DNSSetupExecutor
+func (r *rfc2136Executor) Name() string { return "rfc2136" }
+
+// Apply implements DNSSetupExecutor. The update replaces any existing CNAME of
+// the name, so a stale delegation is corrected in the same transaction.
+func (r *rfc2136Executor) Apply(ctx context.Context, fqdn, target string) error {
+	name := dns.Fqdn(fqdn)
+	zone := dns.Fqdn(r.zoneFor(fqdn))
+
+	rr := &dns.CNAME{
+		Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: uint32(r.ttl)},
+		Target: dns.Fqdn(target),
+	}
+
+	msg := new(dns.Msg)
+	msg.SetUpdate(zone)
+	msg.RemoveRRset([]dns.RR{rr})
+	msg.Insert([]dns.RR{rr})
+
+	client := &dns.Client{Net: "tcp", Timeout: time.Duration(DefaultDNSTimeout) * time.Second}
+	if r.tsigKey != "" {
+		keyName := dns.Fqdn(r.tsigKey)
+		msg.SetTsig(keyName, r.tsigAlgorithm, 300, time.Now().Unix())
+		client.TsigSecret = map[string]string{keyName: r.tsigSecret}
+	}
+
+	resp, _, err := client.ExchangeContext(ctx, msg, r.nameserver)
+	if err != nil {
+		return fmt.Errorf("dynamic update of %s via %s: %w", name, r.nameserver, err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("dynamic update of %s via %s rejected: %s", name, r.nameserver, dns.RcodeToString[resp.Rcode])
+	}
+	return nil
+}
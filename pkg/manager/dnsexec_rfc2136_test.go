@@ -0,0 +1,110 @@
+package manager
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// startUpdateServer runs a TCP DNS server recording received updates
+func startUpdateServer(t *testing.T, tsig map[string]string, rcode int) (string, chan *dns.Msg) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	updates := make(chan *dns.Msg, 1)
+	server := &dns.Server{
+		Listener:   listener,
+		TsigSecret: tsig,
+		// The default accept function answers updates with NOTIMP
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			reply := new(dns.Msg)
+			reply.SetRcode(r, rcode)
+			if sig := r.IsTsig(); sig != nil {
+				if w.TsigStatus() != nil {
+					reply.SetRcode(r, dns.RcodeNotAuth)
+				} else {
+					reply.SetTsig(sig.Hdr.Name, sig.Algorithm, 300, int64(sig.TimeSigned))
+				}
+			}
+			updates <- r
+			_ = w.WriteMsg(reply)
+		}),
+	}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go func() { _ = server.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = server.Shutdown() })
+
+	return listener.Addr().String(), updates
+}
+
+func TestRFC2136Executor_Apply(t *testing.T) {
+	secret := "c2VjcmV0c2VjcmV0c2VjcmV0"
+	addr, updates := startUpdateServer(t, map[string]string{"acme-update.": secret}, dns.RcodeSuccess)
+
+	exec, err := newRFC2136Executor(DNSProviderConfig{
+		Type:       "rfc2136",
+		Zones:      []string{"example.com"},
+		Nameserver: addr,
+		TSIGKey:    "acme-update",
+		TSIGSecret: secret,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	if err := exec.Apply(context.Background(), "_acme-challenge.www.example.com", "abc.auth.example.net"); err != nil {
+		t.Fatalf("Expected update to succeed, got %v", err)
+	}
+
+	msg := <-updates
+	if msg.Opcode != dns.OpcodeUpdate {
+		t.Errorf("Expected update opcode, got %d", msg.Opcode)
+	}
+	if len(msg.Question) != 1 || msg.Question[0].Name != "example.com." {
+		t.Errorf("Expected zone example.com., got %v", msg.Question)
+	}
+	if msg.IsTsig() == nil {
+		t.Error("Expected update to be TSIG signed")
+	}
+
+	// The update section removes the existing RRset and inserts the new CNAME
+	var inserted *dns.CNAME
+	removed := false
+	for _, rr := range msg.Ns {
+		if rr.Header().Class == dns.ClassANY && rr.Header().Rrtype == dns.TypeCNAME {
+			removed = true
+		}
+		if c, ok := rr.(*dns.CNAME); ok && rr.Header().Class == dns.ClassINET {
+			inserted = c
+		}
+	}
+	if !removed {
+		t.Error("Expected existing CNAME RRset to be removed")
+	}
+	if inserted == nil || inserted.Hdr.Name != "_acme-challenge.www.example.com." || inserted.Target != "abc.auth.example.net." {
+		t.Errorf("Unexpected inserted record: %v", inserted)
+	}
+}
+
+func TestRFC2136Executor_Refused(t *testing.T) {
+	addr, _ := startUpdateServer(t, nil, dns.RcodeRefused)
+
+	exec, err := newRFC2136Executor(DNSProviderConfig{Type: "rfc2136", Zones: []string{"example.com"}, Nameserver: addr})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+
+	err = exec.Apply(context.Background(), "_acme-challenge.example.com", "abc.auth.example.net")
+	if err == nil || !strings.Contains(err.Error(), "REFUSED") {
+		t.Errorf("Expected REFUSED error, got %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// route53Endpoint is the global Route53 API endpoint
+const route53Endpoint = "https://route53.amazonaws.com"
+
+// route53Executor upserts CNAME records through the Route53 REST API.
+// Requests are signed with AWS Signature Version 4 directly, which avoids
+// pulling the AWS SDK in for a single API call.
+type route53Executor struct {
+	zoneMatcher
+	hostedZoneID string
+	accessKeyID  string
+	secretKey    string
+	sessionToken string
+	region       string
+	ttl          int
+	endpoint     string
+	client       *http.Client
+	now          func() time.Time
+}
+
+func newRoute53Executor(p DNSProviderConfig) (*route53Executor, error) {
+	if p.HostedZoneID == "" {
+		return nil, fmt.Errorf("dns provider route53: hosted_zone_id is required")
+	}
+
+	// Fall back to the standard AWS environment variables for credentials
+	accessKey, secretKey, sessionToken := p.AccessKeyID, p.SecretAccessKey, ""
+	if accessKey == "" && secretKey == "" {
+		accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("dns provider route53: access_key_id and secret_access_key are required (or AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY)")
+	}
+
+	region := p.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &route53Executor{
+		zoneMatcher:  zoneMatcher(p.Zones),
+		hostedZoneID: strings.TrimPrefix(p.HostedZoneID, "/hostedzone/"),
+		accessKeyID:  accessKey,
+		secretKey:    secretKey,
+		sessionToken: sessionToken,
+		region:       region,
+		ttl:          p.recordTTL(),
+		endpoint:     route53Endpoint,
+		client:       &http.Client{Timeout: DefaultHTTPTimeout},
+		now:          time.Now,
+	}, nil
+}
+
+// Name implements DNSSetupExecutor
+func (r *route53Executor) Name() string { return "route53" }
+
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ChangeResourceRecordSetsRequest"`
+	Comment string          `xml:"ChangeBatch>Comment"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type route53Change struct {
+	Action    string           `xml:"Action"`
+	RecordSet route53RecordSet `xml:"ResourceRecordSet"`
+}
+
+type route53RecordSet struct {
+	Name   string   `xml:"Name"`
+	Type   string   `xml:"Type"`
+	TTL    int      `xml:"TTL"`
+	Values []string `xml:"ResourceRecords>ResourceRecord>Value"`
+}
+
+// Apply implements DNSSetupExecutor using an UPSERT change
+func (r *route53Executor) Apply(ctx context.Context, fqdn, target string) error {
+	change := route53ChangeRequest{
+		Comment: "go-acme-dns-manager acme-dns delegation",
+		Changes: []route53Change{{
+			Action: "UPSERT",
+			RecordSet: route53RecordSet{
+				Name:   strings.TrimSuffix(fqdn, ".") + ".",
+				Type:   "CNAME",
+				TTL:    r.ttl,
+				Values: []string{strings.TrimSuffix(target, ".") + "."},
+			},
+		}},
+	}
+
+	body, err := xml.Marshal(change)
+	if err != nil {
+		return err
+	}
+	body = append([]byte(xml.Header), body...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		r.endpoint+"/2013-04-01/hostedzone/"+r.hostedZoneID+"/rrset/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	if r.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", r.sessionToken)
+	}
+	signV4(req, body, r.accessKeyID, r.secretKey, r.region, "route53", r.now().UTC())
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("route53 request for %s: %w", fqdn, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if xml.Unmarshal(data, &apiErr) == nil && apiErr.Code != "" {
+			return fmt.Errorf("route53 API error (HTTP %d): %s: %s", resp.StatusCode, apiErr.Code, apiErr.Message)
+		}
+		return fmt.Errorf("route53 API error (HTTP %d): %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+// signV4 adds AWS Signature Version 4 headers to req. The request path must
+// already be escaped and the query string, if any, canonically ordered.
+func signV4(req *http.Request, body []byte, accessKey, secretKey, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	// Canonical headers: host plus all x-amz-* and content-type headers, sorted
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
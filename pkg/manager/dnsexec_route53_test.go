@@ -0,0 +1,115 @@
+package manager
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSignV4_Vanilla checks the signer against the "get-vanilla" case of the
+// AWS Signature Version 4 test suite
+func TestSignV4_Vanilla(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	signV4(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", now)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Expected Authorization\n  %s\ngot\n  %s", want, got)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("Expected X-Amz-Date 20150830T123600Z, got %s", got)
+	}
+}
+
+func TestRoute53Executor_Apply(t *testing.T) {
+	var gotPath, gotAuth string
+	var gotChange route53ChangeRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		if err := xml.Unmarshal(body, &gotChange); err != nil {
+			t.Errorf("Failed to parse request body: %v", err)
+		}
+		_, _ = w.Write([]byte(`<ChangeResourceRecordSetsResponse><ChangeInfo><Id>/change/C1</Id><Status>PENDING</Status></ChangeInfo></ChangeResourceRecordSetsResponse>`))
+	}))
+	defer server.Close()
+
+	exec, err := newRoute53Executor(DNSProviderConfig{
+		Type:            "route53",
+		Zones:           []string{"example.com"},
+		HostedZoneID:    "/hostedzone/Z123",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "SECRET",
+		TTL:             120,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	exec.endpoint = server.URL
+
+	if err := exec.Apply(context.Background(), "_acme-challenge.example.com", "abc.auth.example.net"); err != nil {
+		t.Fatalf("Expected apply to succeed, got %v", err)
+	}
+
+	if gotPath != "/2013-04-01/hostedzone/Z123/rrset/" {
+		t.Errorf("Unexpected request path %s", gotPath)
+	}
+	if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(gotAuth, "/us-east-1/route53/aws4_request") {
+		t.Errorf("Unexpected Authorization header %s", gotAuth)
+	}
+	if len(gotChange.Changes) != 1 {
+		t.Fatalf("Expected one change, got %d", len(gotChange.Changes))
+	}
+	change := gotChange.Changes[0]
+	if change.Action != "UPSERT" || change.RecordSet.Name != "_acme-challenge.example.com." ||
+		change.RecordSet.Type != "CNAME" || change.RecordSet.TTL != 120 ||
+		len(change.RecordSet.Values) != 1 || change.RecordSet.Values[0] != "abc.auth.example.net." {
+		t.Errorf("Unexpected change: %+v", change)
+	}
+}
+
+func TestRoute53Executor_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`<ErrorResponse><Error><Code>AccessDenied</Code><Message>not allowed</Message></Error></ErrorResponse>`))
+	}))
+	defer server.Close()
+
+	exec, err := newRoute53Executor(DNSProviderConfig{
+		Type: "route53", Zones: []string{"example.com"}, HostedZoneID: "Z123",
+		AccessKeyID: "AKID", SecretAccessKey: "SECRET",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create executor: %v", err)
+	}
+	exec.endpoint = server.URL
+
+	err = exec.Apply(context.Background(), "_acme-challenge.example.com", "abc.auth.example.net")
+	if err == nil || !strings.Contains(err.Error(), "AccessDenied: not allowed") {
+		t.Errorf("Expected AccessDenied error, got %v", err)
+	}
+}
+
+func TestRoute53Executor_EnvCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "ENVKEY")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "ENVSECRET")
+	t.Setenv("AWS_SESSION_TOKEN", "TOKEN")
+
+	exec, err := newRoute53Executor(DNSProviderConfig{Type: "route53", Zones: []string{"example.com"}, HostedZoneID: "Z123"})
+	if err != nil {
+		t.Fatalf("Expected credentials from environment, got %v", err)
+	}
+	if exec.accessKeyID != "ENVKEY" || exec.sessionToken != "TOKEN" {
+		t.Errorf("Unexpected credentials: %s/%s", exec.accessKeyID, exec.sessionToken)
+	}
+}
@@ -0,0 +1,169 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeExecutor records applied records and optionally fails
+type fakeExecutor struct {
+	zoneMatcher
+	applied map[string]string
+	err     error
+}
+
+func (f *fakeExecutor) Name() string { return "fake" }
+
+func (f *fakeExecutor) Apply(ctx context.Context, fqdn, target string) error {
+	if f.err != nil {
+		return f.err
+	}
+	if f.applied == nil {
+		f.applied = make(map[string]string)
+	}
+	f.applied[fqdn] = target
+	return nil
+}
+
+func TestZoneMatcher(t *testing.T) {
+	z := zoneMatcher{"example.com", "sub.example.com.", "Example.ORG"}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"_acme-challenge.example.com", "example.com"},
+		{"_acme-challenge.www.sub.example.com.", "sub.example.com"},
+		{"_acme-challenge.example.org", "example.org"},
+		{"example.com", "example.com"},
+		{"_acme-challenge.notexample.com", ""},
+		{"_acme-challenge.example.net", ""},
+	}
+	for _, tt := range tests {
+		if got := z.zoneFor(tt.name); got != tt.want {
+			t.Errorf("zoneFor(%q): expected %q, got %q", tt.name, tt.want, got)
+		}
+		if got := z.Handles(tt.name); got != (tt.want != "") {
+			t.Errorf("Handles(%q): expected %v, got %v", tt.name, tt.want != "", got)
+		}
+	}
+}
+
+func TestNewDNSSetupExecutor_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     DNSProviderConfig
+		wantErr string
+	}{
+		{"no zones", DNSProviderConfig{Type: "cloudflare", APIToken: "x"}, "at least one zone"},
+		{"unknown type", DNSProviderConfig{Type: "bind", Zones: []string{"a.com"}}, "unknown dns provider"},
+		{"cloudflare without token", DNSProviderConfig{Type: "cloudflare", Zones: []string{"a.com"}}, "api_token"},
+		{"route53 without zone id", DNSProviderConfig{Type: "route53", Zones: []string{"a.com"}}, "hosted_zone_id"},
+		{"rfc2136 without nameserver", DNSProviderConfig{Type: "rfc2136", Zones: []string{"a.com"}}, "nameserver"},
+		{"rfc2136 key without secret", DNSProviderConfig{Type: "rfc2136", Zones: []string{"a.com"}, Nameserver: "ns", TSIGKey: "k"}, "tsig_key and tsig_secret"},
+		{"rfc2136 bad algorithm", DNSProviderConfig{Type: "rfc2136", Zones: []string{"a.com"}, Nameserver: "ns", TSIGAlgorithm: "md4"}, "tsig_algorithm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDNSSetupExecutor(tt.cfg)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	exec, err := NewDNSSetupExecutor(DNSProviderConfig{Type: "rfc2136", Zones: []string{"a.com"}, Nameserver: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("Expected valid rfc2136 config, got %v", err)
+	}
+	if ns := exec.(*rfc2136Executor).nameserver; ns != "127.0.0.1:53" {
+		t.Errorf("Expected default port to be added, got %s", ns)
+	}
+}
+
+func TestApplyDNSSetup_Routing(t *testing.T) {
+	ok := &fakeExecutor{zoneMatcher: zoneMatcher{"example.com"}}
+	failing := &fakeExecutor{zoneMatcher: zoneMatcher{"example.org"}, err: errors.New("api down")}
+
+	setupInfo := []DNSSetupInfo{
+		{ChallengeDomain: "_acme-challenge.example.com", TargetDomain: "abc.auth.example.net."},
+		{ChallengeDomain: "_acme-challenge.example.org", TargetDomain: "def.auth.example.net"},
+		{ChallengeDomain: "_acme-challenge.example.net", TargetDomain: "ghi.auth.example.net"},
+	}
+
+	remaining := ApplyDNSSetup(context.Background(), []DNSSetupExecutor{ok, failing}, setupInfo)
+
+	if got := ok.applied["_acme-challenge.example.com"]; got != "abc.auth.example.net" {
+		t.Errorf("Expected CNAME target without trailing dot, got %q", got)
+	}
+	if len(remaining) != 2 {
+		t.Fatalf("Expected 2 remaining records (failed and unhandled), got %d: %v", len(remaining), remaining)
+	}
+	if remaining[0].ChallengeDomain != "_acme-challenge.example.org" || remaining[1].ChallengeDomain != "_acme-challenge.example.net" {
+		t.Errorf("Unexpected remaining records: %v", remaining)
+	}
+
+	// Without executors everything stays manual
+	if got := ApplyDNSSetup(context.Background(), nil, setupInfo); len(got) != len(setupInfo) {
+		t.Errorf("Expected all records to remain without executors, got %d", len(got))
+	}
+}
+
+func TestHandleDNSSetup_ManualDefault(t *testing.T) {
+	cfg := &Config{}
+	err := HandleDNSSetup(context.Background(), cfg, []DNSSetupInfo{
+		{ChallengeDomain: "_acme-challenge.example.com", TargetDomain: "abc.auth.example.net"},
+	})
+	if !errors.Is(err, ErrDNSSetupNeeded) {
+		t.Errorf("Expected ErrDNSSetupNeeded without providers, got %v", err)
+	}
+}
+
+func TestLoadConfig_DNSProviders(t *testing.T) {
+	tmpDir := t.TempDir()
+	base := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+`
+	write := func(content string) string {
+		path := filepath.Join(tmpDir, "config.yaml")
+		if err := os.WriteFile(path, []byte(base+content), 0600); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return path
+	}
+
+	cfg, err := LoadConfig(write(`dns_providers:
+  - type: cloudflare
+    zones: ["example.com"]
+    api_token: "token"
+  - type: rfc2136
+    zones: ["example.net"]
+    nameserver: "ns1.example.net"
+    ttl: 60
+`))
+	if err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+	if len(cfg.DNSProviders) != 2 || cfg.DNSProviders[1].TTL != 60 {
+		t.Errorf("Unexpected dns_providers: %+v", cfg.DNSProviders)
+	}
+
+	if _, err := LoadConfig(write(`dns_providers:
+  - type: cloudflare
+    zones: ["example.com"]
+`)); err == nil || !strings.Contains(err.Error(), "api_token") {
+		t.Errorf("Expected missing api_token error, got %v", err)
+	}
+
+	if _, err := LoadConfig(write(`dns_providers:
+  - type: powerdns
+    zones: ["example.com"]
+`)); err == nil {
+		t.Error("Expected schema error for unknown provider type")
+	}
+}
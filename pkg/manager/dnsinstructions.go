@@ -0,0 +1,112 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DNSZoneSetup holds the records required in one DNS zone
+type DNSZoneSetup struct {
+	Zone    string
+	Records []DNSSetupInfo
+}
+
+// DNSZoneOf returns the zone a record most likely belongs to: its
+// registrable domain by the Public Suffix List, e.g. example.co.uk for
+// _acme-challenge.www.example.co.uk. Names that are a public suffix
+// themselves are returned unchanged.
+func DNSZoneOf(name string) string {
+	if zone := RegistrableDomain(name); zone != "" {
+		return zone
+	}
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// GroupDNSSetupByZone groups the required records by zone, sorted by zone
+// and record name
+func GroupDNSSetupByZone(setupInfo []DNSSetupInfo) []DNSZoneSetup {
+	byZone := make(map[string][]DNSSetupInfo)
+	for _, info := range setupInfo {
+		zone := DNSZoneOf(info.ChallengeDomain)
+		byZone[zone] = append(byZone[zone], info)
+	}
+	zones := make([]DNSZoneSetup, 0, len(byZone))
+	for zone, records := range byZone {
+		sort.Slice(records, func(i, j int) bool { return records[i].ChallengeDomain < records[j].ChallengeDomain })
+		zones = append(zones, DNSZoneSetup{Zone: zone, Records: records})
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].Zone < zones[j].Zone })
+	return zones
+}
+
+// zoneFileLines returns the records of a zone in BIND format with comments
+func zoneFileLines(zone DNSZoneSetup) []string {
+	var lines []string
+	for _, info := range zone.Records {
+		lines = append(lines, fmt.Sprintf("%s. IN CNAME %s.", info.ChallengeDomain, info.TargetDomain))
+		if display := DisplayDomain(info.ChallengeDomain); display != info.ChallengeDomain {
+			lines = append(lines, "; "+display)
+		}
+		if len(info.MissingOn) > 0 {
+			lines = append(lines, "; missing on "+strings.Join(info.MissingOn, ", "))
+		}
+	}
+	return lines
+}
+
+// zoneInstructions returns the records of a zone in BIND format below a
+// comment naming the zone
+func zoneInstructions(zone DNSZoneSetup) string {
+	return fmt.Sprintf("; Required DNS changes for zone %s, %d record(s)\n", zone.Zone, len(zone.Records)) +
+		strings.Join(zoneFileLines(zone), "\n") + "\n"
+}
+
+// DNSInstructionsText returns the records of all zones in BIND format, one
+// block per zone, e.g. for a mail to the DNS team
+func DNSInstructionsText(setupInfo []DNSSetupInfo) string {
+	var blocks []string
+	for _, zone := range GroupDNSSetupByZone(setupInfo) {
+		blocks = append(blocks, zoneInstructions(zone))
+	}
+	return strings.Join(blocks, "\n")
+}
+
+// WriteDNSInstructions writes the records of every zone to <zone>.txt in
+// dir, to be handed to the zone owners, and returns the written files.
+// Files of zones without required records are left alone.
+func WriteDNSInstructions(dir string, setupInfo []DNSSetupInfo) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	var written []string
+	for _, zone := range GroupDNSSetupByZone(setupInfo) {
+		path := filepath.Join(dir, zone.Zone+".txt")
+		if err := writeFileAtomic(path, []byte(zoneInstructions(zone)), 0644); err != nil {
+			return written, err
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
+
+// ReportDNSSetup displays the required records, passes them to
+// cfg.DNSSetupReported and, with dns_instructions_dir, writes them per zone
+func ReportDNSSetup(cfg *Config, setupInfo []DNSSetupInfo) {
+	DisplayDNSInstructions(setupInfo)
+	if cfg != nil && cfg.DNSSetupReported != nil {
+		cfg.DNSSetupReported(setupInfo)
+	}
+	if cfg == nil || cfg.DNSInstructionsDir == "" {
+		return
+	}
+	written, err := WriteDNSInstructions(cfg.DNSInstructionsDir, setupInfo)
+	if err != nil {
+		DefaultLogger.Errorf("Error writing DNS instructions to %s: %v", cfg.DNSInstructionsDir, err)
+	}
+	for _, path := range written {
+		DefaultLogger.Warnf("DNS changes written to %s", path)
+	}
+}
@@ -0,0 +1,81 @@
+package manager
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDNSZoneOf(t *testing.T) {
+	for name, want := range map[string]string{
+		"_acme-challenge.example.com":         "example.com",
+		"_acme-challenge.www.example.co.uk.":  "example.co.uk",
+		"_acme-challenge.shop.Example.com.au": "example.com.au",
+		"_acme-challenge.user.github.io":      "user.github.io",
+		"co.uk":                               "co.uk",
+	} {
+		if got := DNSZoneOf(name); got != want {
+			t.Errorf("DNSZoneOf(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestReportDNSSetup(t *testing.T) {
+	oldLogger := DefaultLogger
+	defer func() { DefaultLogger = oldLogger }()
+	var buf bytes.Buffer
+	DefaultLogger = NewColorfulLogger(&buf, LogLevelInfo, false, false)
+
+	dir := filepath.Join(t.TempDir(), "dns-changes")
+	setupInfo := []DNSSetupInfo{
+		{ChallengeDomain: "_acme-challenge.www.example.co.uk", TargetDomain: "b.auth.example.org"},
+		{ChallengeDomain: "_acme-challenge.example.org", TargetDomain: "c.auth.example.org", MissingOn: []string{"ns2.example.org"}},
+		{ChallengeDomain: "_acme-challenge.example.co.uk", TargetDomain: "a.auth.example.org"},
+	}
+	ReportDNSSetup(&Config{DNSInstructionsDir: dir}, setupInfo)
+
+	zones := GroupDNSSetupByZone(setupInfo)
+	if len(zones) != 2 || zones[0].Zone != "example.co.uk" || len(zones[0].Records) != 2 || zones[0].Records[0].ChallengeDomain != "_acme-challenge.example.co.uk" {
+		t.Fatalf("Expected 2 zones sorted by name and record, got %+v", zones)
+	}
+	output := buf.String()
+	for _, want := range []string{"3 CNAME record(s) in 2 zone(s)", "; Zone example.co.uk, 2 record(s)", "; Zone example.org, 1 record(s)", "; missing on ns2.example.org"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected %q in the instructions:\n%s", want, output)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "example.co.uk.txt"))
+	if err != nil {
+		t.Fatalf("Expected a file for example.co.uk: %v", err)
+	}
+	want := "; Required DNS changes for zone example.co.uk, 2 record(s)\n" +
+		"_acme-challenge.example.co.uk. IN CNAME a.auth.example.org.\n" +
+		"_acme-challenge.www.example.co.uk. IN CNAME b.auth.example.org.\n"
+	if string(data) != want {
+		t.Errorf("Unexpected zone file:\n%s", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "example.org.txt")); err != nil {
+		t.Errorf("Expected a file for example.org: %v", err)
+	}
+}
+
+func TestDNSInstructionsText(t *testing.T) {
+	text := DNSInstructionsText([]DNSSetupInfo{
+		{ChallengeDomain: "_acme-challenge.example.org", TargetDomain: "c.auth.example.org"},
+		{ChallengeDomain: "_acme-challenge.example.com", TargetDomain: "a.auth.example.org"},
+	})
+	want := "; Required DNS changes for zone example.com, 1 record(s)\n" +
+		"_acme-challenge.example.com. IN CNAME a.auth.example.org.\n" +
+		"\n" +
+		"; Required DNS changes for zone example.org, 1 record(s)\n" +
+		"_acme-challenge.example.org. IN CNAME c.auth.example.org.\n"
+	if text != want {
+		t.Errorf("Unexpected instructions:\n%s", text)
+	}
+	if DNSInstructionsText(nil) != "" {
+		t.Error("Expected no instructions without records")
+	}
+}
@@ -0,0 +1,174 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultPrecheckConcurrency is the number of CNAME lookups of a pre-check
+// running at the same time unless dns_precheck.concurrency says otherwise
+const DefaultPrecheckConcurrency = 10
+
+// PrecheckConcurrency returns the number of concurrent CNAME lookups of a pre-check
+func (c *Config) PrecheckConcurrency() int {
+	if c.DNSPrecheck != nil && c.DNSPrecheck.Concurrency > 0 {
+		return c.DNSPrecheck.Concurrency
+	}
+	return DefaultPrecheckConcurrency
+}
+
+// PrecheckLookupTimeout returns the limit of one CNAME check, including the
+// authoritative nameserver queries
+func (c *Config) PrecheckLookupTimeout() time.Duration {
+	if c.DNSPrecheck != nil && c.DNSPrecheck.LookupTimeout > 0 {
+		return c.DNSPrecheck.LookupTimeout
+	}
+	return DefaultDNSTimeout * time.Second
+}
+
+// DNSCache remembers within one run the CNAME records found valid and the
+// acme-dns accounts registered, so certificates sharing domains do not look
+// them up or register them again. Records found missing are looked up again,
+// they may have been created in the meantime.
+type DNSCache struct {
+	mu         sync.Mutex
+	valid      map[string]bool
+	registered map[string]AcmeDnsAccount
+}
+
+// NewDNSCache creates an empty cache for a run
+func NewDNSCache() *DNSCache {
+	return &DNSCache{valid: make(map[string]bool), registered: make(map[string]AcmeDnsAccount)}
+}
+
+// isValid reports whether the record of check was found valid in this run.
+// A nil cache knows nothing.
+func (c *DNSCache) isValid(check *cnameCheck) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.valid[check.key()]
+}
+
+func (c *DNSCache) addValid(check *cnameCheck) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.valid[check.key()] = true
+}
+
+// registeredAccount returns the account registered for key in this run
+func (c *DNSCache) registeredAccount(key string) (AcmeDnsAccount, bool) {
+	if c == nil {
+		return AcmeDnsAccount{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	account, ok := c.registered[key]
+	return account, ok
+}
+
+func (c *DNSCache) addRegistered(key string, account AcmeDnsAccount) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.registered[key] = account
+}
+
+// cnameCheck is one record the pre-check verifies
+type cnameCheck struct {
+	domain        string // Certificate domain the record is checked for
+	name          string // Record name, the _acme-challenge name or its alias
+	target        string // Expected CNAME target
+	alias         bool   // The record is a challenge_alias name
+	authoritative bool   // Also check the authoritative nameservers
+
+	valid   bool
+	missing []string // Authoritative nameservers without the record
+	err     error
+}
+
+// key identifies the record and the way it is checked in the DNSCache
+func (check *cnameCheck) key() string {
+	return fmt.Sprintf("%s %s %t", check.name, check.target, check.authoritative)
+}
+
+// runCNAMEChecks verifies the records of checks with at most
+// PrecheckConcurrency lookups at a time. The results are stored in the
+// checks, so they are aggregated in the order of checks regardless of the
+// order the lookups finish in. Records the DNSCache of cfg knows as valid
+// are not looked up again.
+func runCNAMEChecks(ctx context.Context, cfg *Config, resolver DNSResolver, checks []*cnameCheck) {
+	var pending []*cnameCheck
+	for _, check := range checks {
+		if cfg.DNSCache.isValid(check) {
+			DefaultLogger.Debugf("CNAME record for %s was found valid earlier in this run", check.name)
+			check.valid = true
+			continue
+		}
+		pending = append(pending, check)
+	}
+
+	workers := cfg.PrecheckConcurrency()
+	if workers > len(pending) {
+		workers = len(pending)
+	}
+	timeout := cfg.PrecheckLookupTimeout()
+
+	jobs := make(chan *cnameCheck)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for check := range jobs {
+				check.run(ctx, resolver, timeout)
+			}
+		}()
+	}
+	for _, check := range pending {
+		jobs <- check
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, check := range pending {
+		if check.valid {
+			cfg.DNSCache.addValid(check)
+		}
+	}
+}
+
+// run looks up the record of the check within timeout
+func (check *cnameCheck) run(ctx context.Context, resolver DNSResolver, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	valid, err := VerifyWithResolverContext(ctx, resolver, check.name, check.target)
+	if err != nil {
+		if check.alias {
+			check.err = fmt.Errorf("DNS verification of challenge alias %s failed for %s: %w", check.name, check.domain, err)
+		} else {
+			check.err = fmt.Errorf("DNS verification failed for %s: %w", check.domain, err)
+		}
+		return
+	}
+	if valid && check.authoritative {
+		missing, err := checkAuthoritativeCNAME(ctx, resolver, check.name, check.target)
+		if err != nil {
+			check.err = fmt.Errorf("authoritative DNS verification failed for %s: %w", check.domain, err)
+			return
+		}
+		check.missing = missing
+		valid = len(missing) == 0
+	}
+	check.valid = valid
+}
@@ -0,0 +1,196 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowResolver answers like staticResolver after a delay and records the
+// highest number of lookups in flight
+type slowResolver struct {
+	records staticResolver
+	delay   time.Duration
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	lookups     int
+}
+
+func (r *slowResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	r.mu.Lock()
+	r.inFlight++
+	r.lookups++
+	if r.inFlight > r.maxInFlight {
+		r.maxInFlight = r.inFlight
+	}
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.inFlight--
+		r.mu.Unlock()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", &net.DNSError{Err: ctx.Err().Error(), Name: host, IsTimeout: true}
+	case <-time.After(r.delay):
+	}
+	return r.records.LookupCNAME(ctx, host)
+}
+
+func TestPreCheckAcmeDNS_Concurrent(t *testing.T) {
+	store, err := NewAccountStore(filepath.Join(t.TempDir(), "acme-dns-accounts.json"))
+	if err != nil {
+		t.Fatalf("Failed to create account store: %v", err)
+	}
+	records := staticResolver{}
+	var domains []string
+	for i := 0; i < 40; i++ {
+		domain := fmt.Sprintf("host%02d.example%02d.com", i, i)
+		target := fmt.Sprintf("acct%02d.auth.example.org", i)
+		store.SetAccount(GetBaseDomain(domain), AcmeDnsAccount{FullDomain: target})
+		domains = append(domains, domain)
+		// Every fourth record is missing
+		if i%4 != 0 {
+			records["_acme-challenge."+GetBaseDomain(domain)] = target
+		}
+	}
+
+	resolver := &slowResolver{records: records, delay: 20 * time.Millisecond}
+	cfg := &Config{DNSPrecheck: &DNSPrecheckConfig{Concurrency: 8}}
+	start := time.Now()
+	setupInfo, err := PreCheckAcmeDNSWithResolver(cfg, store, domains, resolver)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 40*20*time.Millisecond/2 {
+		t.Errorf("Expected concurrent lookups, the pre-check took %s", elapsed)
+	}
+	if resolver.maxInFlight > 8 || resolver.maxInFlight < 2 {
+		t.Errorf("Expected up to 8 lookups in flight, got %d", resolver.maxInFlight)
+	}
+
+	if len(setupInfo) != 10 {
+		t.Fatalf("Expected 10 missing records, got %d: %v", len(setupInfo), setupInfo)
+	}
+	for i := 1; i < len(setupInfo); i++ {
+		if setupInfo[i-1].ChallengeDomain >= setupInfo[i].ChallengeDomain {
+			t.Errorf("Expected the records sorted, got %v", setupInfo)
+			break
+		}
+	}
+	if setupInfo[0].ChallengeDomain != "_acme-challenge.host00.example00.com" || setupInfo[0].TargetDomain != "acct00.auth.example.org" {
+		t.Errorf("Unexpected first record %+v", setupInfo[0])
+	}
+}
+
+func TestPreCheckAcmeDNS_SharedRecordLookedUpOnce(t *testing.T) {
+	store, err := NewAccountStore(filepath.Join(t.TempDir(), "acme-dns-accounts.json"))
+	if err != nil {
+		t.Fatalf("Failed to create account store: %v", err)
+	}
+	store.SetAccount("example.com", AcmeDnsAccount{FullDomain: "abc.auth.example.org"})
+	resolver := &slowResolver{records: staticResolver{"_acme-challenge.example.com": "abc.auth.example.org"}}
+
+	if _, err := PreCheckAcmeDNSWithResolver(&Config{}, store, []string{"example.com", "*.example.com"}, resolver); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resolver.lookups != 1 {
+		t.Errorf("Expected the shared record to be looked up once, got %d lookups", resolver.lookups)
+	}
+}
+
+func TestPreCheckAcmeDNS_LookupTimeout(t *testing.T) {
+	store, err := NewAccountStore(filepath.Join(t.TempDir(), "acme-dns-accounts.json"))
+	if err != nil {
+		t.Fatalf("Failed to create account store: %v", err)
+	}
+	store.SetAccount("example.com", AcmeDnsAccount{FullDomain: "abc.auth.example.org"})
+	store.SetAccount("example.net", AcmeDnsAccount{FullDomain: "def.auth.example.org"})
+	resolver := &slowResolver{delay: time.Minute}
+	cfg := &Config{DNSPrecheck: &DNSPrecheckConfig{LookupTimeout: 50 * time.Millisecond}}
+
+	start := time.Now()
+	_, err = PreCheckAcmeDNSWithResolver(cfg, store, []string{"example.net", "example.com"}, resolver)
+	if err == nil {
+		t.Fatal("Expected a timeout error")
+	}
+	// The first domain in the request reports its error, whichever lookup ended first
+	if !strings.Contains(err.Error(), "DNS verification failed for example.net") {
+		t.Errorf("Expected the error of the first domain, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the lookups to time out quickly, took %s", elapsed)
+	}
+}
+
+func TestPrecheckDefaults(t *testing.T) {
+	cfg := &Config{}
+	if cfg.PrecheckConcurrency() != DefaultPrecheckConcurrency || cfg.PrecheckLookupTimeout() != DefaultDNSTimeout*time.Second {
+		t.Errorf("Unexpected defaults %d, %s", cfg.PrecheckConcurrency(), cfg.PrecheckLookupTimeout())
+	}
+}
+
+func TestPreCheckAcmeDNS_CacheWithinRun(t *testing.T) {
+	store, err := NewAccountStore(filepath.Join(t.TempDir(), "acme-dns-accounts.json"))
+	if err != nil {
+		t.Fatalf("Failed to create account store: %v", err)
+	}
+	store.SetAccount("example.com", AcmeDnsAccount{FullDomain: "abc.auth.example.org"})
+	store.SetAccount("example.net", AcmeDnsAccount{FullDomain: "def.auth.example.org"})
+	resolver := &slowResolver{records: staticResolver{"_acme-challenge.example.com": "abc.auth.example.org"}}
+	cfg := &Config{DNSCache: NewDNSCache()}
+
+	// Two certificates sharing example.com, example.net is missing
+	for _, domains := range [][]string{{"example.com", "example.net"}, {"*.example.com", "example.net"}} {
+		setupInfo, err := PreCheckAcmeDNSWithResolver(cfg, store, domains, resolver)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(setupInfo) != 1 || setupInfo[0].ChallengeDomain != "_acme-challenge.example.net" {
+			t.Errorf("Expected the example.net record to be missing, got %v", setupInfo)
+		}
+	}
+	// example.com once, the missing example.net record every time
+	if resolver.lookups != 3 {
+		t.Errorf("Expected 3 lookups, got %d", resolver.lookups)
+	}
+
+	// The next run looks everything up again
+	cfg.DNSCache = NewDNSCache()
+	if _, err := PreCheckAcmeDNSWithResolver(cfg, store, []string{"example.com"}, resolver); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resolver.lookups != 4 {
+		t.Errorf("Expected a lookup in the new run, got %d lookups", resolver.lookups)
+	}
+}
+
+func TestPreCheckAcmeDNS_ReusesRegistrationOfRun(t *testing.T) {
+	store, err := NewAccountStore(filepath.Join(t.TempDir(), "acme-dns-accounts.json"))
+	if err != nil {
+		t.Fatalf("Failed to create account store: %v", err)
+	}
+	cfg := &Config{DNSCache: NewDNSCache()}
+	registered := AcmeDnsAccount{Username: "user", Password: "pass", FullDomain: "abc.auth.example.org", SubDomain: "abc"}
+	cfg.DNSCache.addRegistered("example.com", registered)
+
+	// Without the cache the pre-check would register at the (unset) acme-dns server
+	setupInfo, err := PreCheckAcmeDNSWithResolver(cfg, store, []string{"*.example.com"}, staticResolver{})
+	if err != nil {
+		t.Fatalf("Expected the account of the run to be reused, got %v", err)
+	}
+	if account, _, ok := cfg.LookupAcmeDNSAccount(store, "example.com"); !ok || account.FullDomain != registered.FullDomain {
+		t.Errorf("Expected the stored account %+v, got %+v", registered, account)
+	}
+	if len(setupInfo) != 1 || setupInfo[0].TargetDomain != "abc.auth.example.org" {
+		t.Errorf("Expected the CNAME record of the reused account, got %v", setupInfo)
+	}
+}
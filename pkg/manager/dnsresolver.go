@@ -0,0 +1,220 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/miekg/dns"
+)
+
+// Prefixes of dns_resolver selecting an encrypted transport
+const (
+	ResolverSchemeDoH = "https://" // DNS-over-HTTPS (RFC 8484)
+	ResolverSchemeDoT = "tls://"   // DNS-over-TLS (RFC 7858)
+)
+
+// DefaultDoTPort is the port of DNS-over-TLS resolvers without explicit port
+const DefaultDoTPort = "853"
+
+// dohContentType is the media type of DNS messages sent over HTTPS
+const dohContentType = "application/dns-message"
+
+// maxCNAMEChain limits how many CNAME records are followed in an answer
+const maxCNAMEChain = 8
+
+// IsEncryptedResolver reports whether a resolver address uses DoH or DoT
+func IsEncryptedResolver(addr string) bool {
+	return strings.HasPrefix(addr, ResolverSchemeDoH) || strings.HasPrefix(addr, ResolverSchemeDoT)
+}
+
+// normalizeResolverAddress adds the default port to plain and DoT resolvers
+func normalizeResolverAddress(addr string) string {
+	switch {
+	case addr == "" || strings.HasPrefix(addr, ResolverSchemeDoH):
+		return addr
+	case strings.HasPrefix(addr, ResolverSchemeDoT):
+		host := strings.TrimPrefix(addr, ResolverSchemeDoT)
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), DefaultDoTPort)
+		}
+		return ResolverSchemeDoT + host
+	case !strings.Contains(addr, ":"):
+		return addr + ":53"
+	}
+	return addr
+}
+
+// validateResolverAddress checks a dns_resolver or external_resolver value
+func validateResolverAddress(field, addr string) error {
+	switch {
+	case strings.HasPrefix(addr, ResolverSchemeDoH):
+		u, err := url.Parse(addr)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("%s %q is not a valid DNS-over-HTTPS URL", field, addr)
+		}
+	case strings.HasPrefix(addr, ResolverSchemeDoT):
+		if strings.TrimPrefix(addr, ResolverSchemeDoT) == "" {
+			return fmt.Errorf("%s %q is missing the DNS-over-TLS host", field, addr)
+		}
+	case strings.Contains(addr, "://"):
+		return fmt.Errorf("%s %q uses an unsupported scheme, use host[:port], tls://host[:port] or https://url", field, addr)
+	}
+	return nil
+}
+
+// encryptedResolver sends queries to a DNS-over-HTTPS or DNS-over-TLS
+// resolver, for networks that block or intercept plain DNS on port 53
+type encryptedResolver struct {
+	address    string // https:// URL or tls://host:port
+	httpClient *http.Client
+	dnsClient  *dns.Client
+}
+
+// newEncryptedResolver creates a resolver for a normalized DoH or DoT
+// address. DoH queries use the proxy and TLS settings of cfg.
+func newEncryptedResolver(cfg *Config, addr string) *encryptedResolver {
+	r := &encryptedResolver{address: addr}
+	if strings.HasPrefix(addr, ResolverSchemeDoH) {
+		r.httpClient = &http.Client{Timeout: DefaultDNSTimeout * time.Second, Transport: cfg.baseTransport()}
+		return r
+	}
+	host, _, _ := net.SplitHostPort(strings.TrimPrefix(addr, ResolverSchemeDoT))
+	r.dnsClient = &dns.Client{
+		Net:       "tcp-tls",
+		Timeout:   DefaultDNSTimeout * time.Second,
+		TLSConfig: &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12},
+	}
+	return r
+}
+
+// LookupCNAME implements the DNSResolver interface. Like net.Resolver it
+// follows the CNAME chain in the answer and returns the last target.
+func (r *encryptedResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	answer, err := r.query(ctx, host, dns.TypeCNAME)
+	if err != nil {
+		return "", err
+	}
+	name, found := dns.Fqdn(host), false
+	for i := 0; i < maxCNAMEChain; i++ {
+		next := ""
+		for _, rr := range answer {
+			if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, name) {
+				next = cname.Target
+				break
+			}
+		}
+		if next == "" {
+			break
+		}
+		name, found = next, true
+	}
+	if !found {
+		return "", &net.DNSError{Err: "no CNAME record", Name: host, Server: r.address, IsNotFound: true}
+	}
+	return name, nil
+}
+
+// LookupTXT returns the TXT records of a name, following CNAME records
+func (r *encryptedResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	answer, err := r.query(ctx, name, dns.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	var records []string
+	for _, rr := range answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			records = append(records, strings.Join(txt.Txt, ""))
+		}
+	}
+	return records, nil
+}
+
+// query sends one question and returns the answer section. NXDOMAIN is
+// returned as a not-found net.DNSError, as the system resolver does.
+func (r *encryptedResolver) query(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+
+	var resp *dns.Msg
+	var err error
+	if r.httpClient != nil {
+		resp, err = r.exchangeHTTPS(ctx, msg)
+	} else {
+		resp, err = r.exchangeTLS(ctx, msg)
+	}
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: name, Server: r.address}
+	}
+
+	switch resp.Rcode {
+	case dns.RcodeSuccess:
+		return resp.Answer, nil
+	case dns.RcodeNameError:
+		return nil, &net.DNSError{Err: "no such host", Name: name, Server: r.address, IsNotFound: true}
+	default:
+		return nil, &net.DNSError{Err: "server returned " + dns.RcodeToString[resp.Rcode], Name: name, Server: r.address}
+	}
+}
+
+// exchangeHTTPS posts a query to a DoH resolver
+func (r *encryptedResolver) exchangeHTTPS(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	// RFC 8484 recommends ID 0 so responses are cacheable
+	msg.Id = 0
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.address, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS-over-HTTPS request failed: %s", resp.Status)
+	}
+
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil {
+		return nil, fmt.Errorf("parsing DNS-over-HTTPS response: %w", err)
+	}
+	return reply, nil
+}
+
+// exchangeTLS sends a query to a DoT resolver
+func (r *encryptedResolver) exchangeTLS(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	reply, _, err := r.dnsClient.ExchangeContext(ctx, msg, strings.TrimPrefix(r.address, ResolverSchemeDoT))
+	return reply, err
+}
+
+// preCheck replaces the DNS-01 propagation check of lego, which only speaks
+// plain DNS, with a TXT lookup through the encrypted resolver
+func (r *encryptedResolver) preCheck(_, fqdn, value string, _ dns01.PreCheckFunc) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultDNSTimeout*time.Second)
+	defer cancel()
+	records, err := r.LookupTXT(ctx, fqdn)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(records, value), nil
+}
@@ -0,0 +1,168 @@
+package manager
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// testDNSAnswer answers for a small zone: _acme-challenge.example.com is a
+// CNAME to an acme-dns subdomain with a TXT record, missing.example.com
+// does not exist
+func testDNSAnswer(req *dns.Msg) *dns.Msg {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	q := req.Question[0]
+	switch q.Name {
+	case "_acme-challenge.example.com.":
+		cname, _ := dns.NewRR("_acme-challenge.example.com. 300 IN CNAME abc.auth.example.org.")
+		resp.Answer = append(resp.Answer, cname)
+		if q.Qtype == dns.TypeTXT {
+			txt, _ := dns.NewRR(`abc.auth.example.org. 1 IN TXT "token-value"`)
+			resp.Answer = append(resp.Answer, txt)
+		}
+	case "www.example.com.":
+		a, _ := dns.NewRR("www.example.com. 300 IN A 192.0.2.1")
+		resp.Answer = append(resp.Answer, a)
+	default:
+		resp.Rcode = dns.RcodeNameError
+	}
+	return resp
+}
+
+// newTestDoHResolver serves testDNSAnswer over HTTPS
+func newTestDoHResolver(t *testing.T) *encryptedResolver {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dohContentType {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		req := new(dns.Msg)
+		if err := req.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		packed, _ := testDNSAnswer(req).Pack()
+		w.Header().Set("Content-Type", dohContentType)
+		_, _ = w.Write(packed)
+	}))
+	t.Cleanup(server.Close)
+
+	// The test certificate is trusted through ca_bundle_path
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(bundle, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return newEncryptedResolver(&Config{CABundlePath: bundle}, server.URL+"/dns-query")
+}
+
+// newTestDoTResolver serves testDNSAnswer over TLS, borrowing the test
+// certificate of httptest
+func newTestDoTResolver(t *testing.T) *encryptedResolver {
+	t.Helper()
+	certServer := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(certServer.Close)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certServer.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{Listener: listener, Net: "tcp-tls", Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		_ = w.WriteMsg(testDNSAnswer(req))
+	})}
+	go func() { _ = server.ActivateAndServe() }()
+	t.Cleanup(func() { _ = server.Shutdown() })
+
+	resolver := newEncryptedResolver(&Config{}, normalizeResolverAddress(ResolverSchemeDoT+listener.Addr().String()))
+	resolver.dnsClient.TLSConfig.RootCAs = certServer.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	resolver.dnsClient.TLSConfig.ServerName = "example.com"
+	return resolver
+}
+
+func TestEncryptedResolver(t *testing.T) {
+	for name, newResolver := range map[string]func(*testing.T) *encryptedResolver{
+		"DoH": newTestDoHResolver,
+		"DoT": newTestDoTResolver,
+	} {
+		t.Run(name, func(t *testing.T) {
+			resolver := newResolver(t)
+			ctx := context.Background()
+
+			valid, err := VerifyWithResolver(resolver, "_acme-challenge.example.com", "abc.auth.example.org")
+			if err != nil || !valid {
+				t.Errorf("Expected a valid CNAME, got %v, %v", valid, err)
+			}
+
+			// No CNAME and no name at all both count as missing records
+			for _, host := range []string{"www.example.com", "missing.example.com"} {
+				_, err := resolver.LookupCNAME(ctx, host)
+				var dnsErr *net.DNSError
+				if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+					t.Errorf("Expected a not-found error for %s, got %v", host, err)
+				}
+			}
+
+			ok, err := resolver.preCheck("example.com", "_acme-challenge.example.com.", "token-value", nil)
+			if err != nil || !ok {
+				t.Errorf("Expected the TXT record to be found through the CNAME, got %v, %v", ok, err)
+			}
+			ok, err = resolver.preCheck("example.com", "_acme-challenge.example.com.", "other-value", nil)
+			if err != nil || ok {
+				t.Errorf("Expected a missing TXT value, got %v, %v", ok, err)
+			}
+		})
+	}
+}
+
+func TestEncryptedResolver_HTTPError(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "blocked", http.StatusForbidden)
+	}))
+	defer server.Close()
+	resolver := newEncryptedResolver(&Config{}, server.URL)
+	resolver.httpClient = server.Client()
+
+	_, err := resolver.LookupCNAME(context.Background(), "_acme-challenge.example.com")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || dnsErr.IsNotFound || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected a DNS error with the HTTP status, got %v", err)
+	}
+}
+
+func TestNewPrecheckResolver_Encrypted(t *testing.T) {
+	for _, addr := range []string{"https://dns.example.net/dns-query", "tls://1.1.1.1"} {
+		if _, ok := NewPrecheckResolver(&Config{DnsResolver: addr}).(*encryptedResolver); !ok {
+			t.Errorf("Expected an encrypted resolver for %s", addr)
+		}
+	}
+	if _, ok := NewPrecheckResolver(&Config{DnsResolver: "1.1.1.1"}).(*DefaultDNSResolver); !ok {
+		t.Error("Expected the system resolver type for plain DNS")
+	}
+}
+
+func TestValidateResolverAddress(t *testing.T) {
+	for _, addr := range []string{"", "1.1.1.1", "1.1.1.1:53", "[2001:db8::1]:53", "tls://dns.example.net", "https://dns.example.net/dns-query"} {
+		if err := validateResolverAddress("dns_resolver", addr); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", addr, err)
+		}
+	}
+	for _, addr := range []string{"tls://", "https://", "udp://1.1.1.1", "http://dns.example.net/dns-query"} {
+		if err := validateResolverAddress("dns_resolver", addr); err == nil {
+			t.Errorf("Expected %q to be rejected", addr)
+		}
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"net"
 	"strings"
 	"time"
+
+	"golang.org/x/net/publicsuffix"
 )
 
 // DNSResolver defines the interface for DNS resolution
@@ -24,6 +26,68 @@ func (r *DefaultDNSResolver) LookupCNAME(ctx context.Context, host string) (stri
 	return r.Resolver.LookupCNAME(ctx, host)
 }
 
+// PrecheckResolverAddress returns the host:port of the resolver used for CNAME
+// checks, tls://host:port or an https:// URL for encrypted resolvers, or ""
+// for the system resolver. An external resolver configured under dns_precheck
+// takes precedence over dns_resolver so split-horizon setups are checked
+// against the public view only.
+func (c *Config) PrecheckResolverAddress() string {
+	addr := c.DnsResolver
+	if c.DNSPrecheck != nil && c.DNSPrecheck.ExternalResolver != "" {
+		addr = c.DNSPrecheck.ExternalResolver
+	}
+	return normalizeResolverAddress(addr)
+}
+
+// ExpectedCNAMETarget returns the target the _acme-challenge record of domain
+// must point to. A cname_targets override wins over the acme-dns account target.
+func (c *Config) ExpectedCNAMETarget(domain, accountTarget string) string {
+	if c.DNSPrecheck != nil {
+		base := GetBaseDomain(domain)
+		for _, key := range []string{base, "*." + base} {
+			if target, ok := c.DNSPrecheck.CNAMETargets[key]; ok {
+				return strings.TrimSuffix(target, ".")
+			}
+		}
+	}
+	return strings.TrimSuffix(accountTarget, ".")
+}
+
+// ChallengeAliasFor returns the challenge_alias name the _acme-challenge
+// record of domain points to, or "" if the domain has no alias
+func (c *Config) ChallengeAliasFor(domain string) string {
+	base := GetBaseDomain(domain)
+	for _, key := range []string{base, "*." + base} {
+		if alias, ok := c.ChallengeAlias[key]; ok {
+			return strings.TrimSuffix(alias, ".")
+		}
+	}
+	return ""
+}
+
+// NewPrecheckResolver creates the resolver used for CNAME pre-checks
+func NewPrecheckResolver(cfg *Config) DNSResolver {
+	nsAddr := cfg.PrecheckResolverAddress()
+	if nsAddr == "" {
+		return &DefaultDNSResolver{Resolver: net.DefaultResolver}
+	}
+	if IsEncryptedResolver(nsAddr) {
+		return newEncryptedResolver(cfg, nsAddr)
+	}
+	return &DefaultDNSResolver{
+		Resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				d := net.Dialer{
+					Timeout: time.Second * 10,
+				}
+				// Ignore the address passed in, use the configured one
+				return d.DialContext(ctx, network, nsAddr)
+			},
+		},
+	}
+}
+
 // ACME challenge prefix for DNS validation
 const acmeChallengePrefix = "_acme-challenge"
 
@@ -97,7 +161,10 @@ func isAlphaNumeric(r rune) bool {
 	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
 }
 
-// GetBaseDomain extracts the base domain from a wildcard or regular domain
+// GetBaseDomain extracts the base domain from a wildcard or regular domain.
+// This is the name the DNS-01 challenge of the domain is answered for, so
+// it is not shortened to the registrable domain: www.example.co.uk keeps
+// its own _acme-challenge record and acme-dns account.
 func GetBaseDomain(domain string) string {
 	// Remove wildcard prefix if present
 	if strings.HasPrefix(domain, "*.") {
@@ -106,6 +173,26 @@ func GetBaseDomain(domain string) string {
 	return domain
 }
 
+// RegistrableDomain returns the domain a name was registered under
+// according to the Public Suffix List, e.g. example.co.uk for
+// *.www.example.co.uk or user.github.io for user.github.io. Names that are
+// a public suffix themselves return "".
+func RegistrableDomain(domain string) string {
+	name := strings.ToLower(strings.TrimSuffix(GetBaseDomain(domain), "."))
+	registrable, err := publicsuffix.EffectiveTLDPlusOne(name)
+	if err != nil {
+		return ""
+	}
+	return registrable
+}
+
+// IsPublicSuffix reports whether a domain, or the base of a wildcard, is a
+// public suffix such as co.uk or github.io, for which no CA issues
+// certificates
+func IsPublicSuffix(domain string) bool {
+	return RegistrableDomain(domain) == ""
+}
+
 // GetChallengeSubdomain creates the challenge subdomain for a given domain
 // This is exported for testing purposes
 func GetChallengeSubdomain(domain string) string {
@@ -123,32 +210,12 @@ func VerifyCnameRecord(cfg *Config, domain string, expectedTarget string) (bool,
 
 	DefaultLogger.Infof("Verifying CNAME record for %s -> %s", challengeDomain, expectedTarget)
 
-	var resolver DNSResolver
-
-	if cfg.DnsResolver != "" {
-		DefaultLogger.Infof("Using custom DNS resolver: %s", cfg.DnsResolver)
-		// Ensure the resolver address includes a port
-		resolverAddr := cfg.DnsResolver
-		if !strings.Contains(resolverAddr, ":") {
-			resolverAddr += ":53" // Default DNS port
-		}
-
-		customResolver := &net.Resolver{
-			PreferGo: true, // Use Go's built-in resolver
-			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-				d := net.Dialer{
-					Timeout: time.Second * 10, // Timeout for dialing the resolver
-				}
-				// Ignore the address passed in, use the configured one
-				return d.DialContext(ctx, "udp", resolverAddr)
-			},
-		}
-		resolver = &DefaultDNSResolver{Resolver: customResolver}
+	if addr := cfg.PrecheckResolverAddress(); addr != "" {
+		DefaultLogger.Infof("Using custom DNS resolver: %s", addr)
 	} else {
 		DefaultLogger.Infof("Using system default DNS resolver")
-		// Use default resolver
-		resolver = &DefaultDNSResolver{Resolver: net.DefaultResolver}
 	}
+	resolver := NewPrecheckResolver(cfg)
 
 	isValid, err := VerifyWithResolver(resolver, challengeDomain, expectedTarget)
 
@@ -164,7 +231,12 @@ func VerifyCnameRecord(cfg *Config, domain string, expectedTarget string) (bool,
 // This function allows for easier testing with mock resolvers
 // Exported for testing
 func VerifyWithResolver(resolver DNSResolver, challengeDomain string, expectedTarget string) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), DefaultDNSTimeout*time.Second) // Overall timeout for lookup
+	return VerifyWithResolverContext(context.Background(), resolver, challengeDomain, expectedTarget)
+}
+
+// VerifyWithResolverContext is VerifyWithResolver with a context that can cancel the lookup
+func VerifyWithResolverContext(ctx context.Context, resolver DNSResolver, challengeDomain string, expectedTarget string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, DefaultDNSTimeout*time.Second) // Overall timeout for lookup
 	defer cancel()
 
 	cname, err := resolver.LookupCNAME(ctx, challengeDomain)
@@ -184,6 +256,14 @@ func VerifyWithResolver(resolver DNSResolver, challengeDomain string, expectedTa
 	DefaultLogger.Infof("Found CNAME for %s: %s", challengeDomain, cname)
 
 	isValid := cname == expectedTarget
+	if !isValid && cname != "" {
+		// Resolvers follow the whole CNAME chain. If the expected target is an
+		// alias, e.g. from challenge_alias, it resolves to the same name.
+		if aliasTarget, err := resolver.LookupCNAME(ctx, expectedTarget); err == nil && strings.TrimSuffix(aliasTarget, ".") == cname {
+			DefaultLogger.Debugf("CNAME record for %s reaches %s through %s", challengeDomain, cname, expectedTarget)
+			isValid = true
+		}
+	}
 	if isValid {
 		DefaultLogger.Infof("CNAME record for %s is valid.", challengeDomain)
 	} else {
@@ -1,6 +1,11 @@
 package manager
 
 import (
+	"context"
+	"net"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 )
@@ -69,6 +74,29 @@ func TestVerifyCnameRecordLogic(t *testing.T) {
 }
 
 // Test the GetBaseDomain function
+func TestRegistrableDomain(t *testing.T) {
+	for domain, want := range map[string]string{
+		"example.com":          "example.com",
+		"*.www.example.co.uk":  "example.co.uk",
+		"shop.example.com.au.": "example.com.au",
+		"user.github.io":       "user.github.io",
+		"co.uk":                "",
+		"*.github.io":          "",
+		"host.corp.internal":   "corp.internal",
+	} {
+		if got := RegistrableDomain(domain); got != want {
+			t.Errorf("RegistrableDomain(%q) = %q, want %q", domain, got, want)
+		}
+		if IsPublicSuffix(domain) != (want == "") {
+			t.Errorf("IsPublicSuffix(%q) = %v", domain, want != "")
+		}
+	}
+	// The challenge record stays per name
+	if got := GetBaseDomain("*.www.example.co.uk"); got != "www.example.co.uk" {
+		t.Errorf("Expected GetBaseDomain to keep the full name, got %q", got)
+	}
+}
+
 func TestGetBaseDomain(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -262,3 +290,140 @@ func TestIsValidDNSName(t *testing.T) {
 		})
 	}
 }
+
+func TestPrecheckResolverAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{"system resolver", Config{}, ""},
+		{"dns_resolver adds port", Config{DnsResolver: "10.0.0.1"}, "10.0.0.1:53"},
+		{"external resolver wins", Config{DnsResolver: "10.0.0.1", DNSPrecheck: &DNSPrecheckConfig{ExternalResolver: "1.1.1.1:5353"}}, "1.1.1.1:5353"},
+		{"empty external falls back", Config{DnsResolver: "10.0.0.1:53", DNSPrecheck: &DNSPrecheckConfig{}}, "10.0.0.1:53"},
+		{"DoT adds port", Config{DnsResolver: "tls://dns.example.net"}, "tls://dns.example.net:853"},
+		{"DoT keeps port", Config{DnsResolver: "tls://[2001:db8::1]:8853"}, "tls://[2001:db8::1]:8853"},
+		{"DoH unchanged", Config{DnsResolver: "https://dns.example.net/dns-query"}, "https://dns.example.net/dns-query"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.PrecheckResolverAddress(); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestExpectedCNAMETarget(t *testing.T) {
+	cfg := &Config{DNSPrecheck: &DNSPrecheckConfig{CNAMETargets: map[string]string{
+		"example.com":   "public.auth.example.org.",
+		"*.example.net": "wild.auth.example.org",
+	}}}
+
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"example.com", "public.auth.example.org"},
+		{"*.example.com", "public.auth.example.org"},
+		{"example.net", "wild.auth.example.org"},
+		{"example.org", "account.auth.example.org"},
+	}
+	for _, tt := range tests {
+		if got := cfg.ExpectedCNAMETarget(tt.domain, "account.auth.example.org."); got != tt.want {
+			t.Errorf("ExpectedCNAMETarget(%q): expected %q, got %q", tt.domain, tt.want, got)
+		}
+	}
+
+	if got := (&Config{}).ExpectedCNAMETarget("example.com", "account.auth.example.org."); got != "account.auth.example.org" {
+		t.Errorf("Expected account target without override, got %q", got)
+	}
+}
+
+// staticResolver answers CNAME lookups from a map, unknown names are NXDOMAIN
+type staticResolver map[string]string
+
+func (r staticResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	if target, ok := r[host]; ok {
+		return target + ".", nil
+	}
+	return "", &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestPreCheckAcmeDNS_SplitHorizonTarget(t *testing.T) {
+	store, err := NewAccountStore(filepath.Join(t.TempDir(), "acme-dns-accounts.json"))
+	if err != nil {
+		t.Fatalf("Failed to create account store: %v", err)
+	}
+	store.SetAccount("example.com", AcmeDnsAccount{FullDomain: "internal.auth.example.org"})
+
+	cfg := &Config{DNSPrecheck: &DNSPrecheckConfig{CNAMETargets: map[string]string{
+		"example.com": "public.auth.example.org",
+	}}}
+
+	// The public view points at the override target, which must pass
+	setupInfo, err := PreCheckAcmeDNSWithResolver(cfg, store, []string{"example.com"},
+		staticResolver{"_acme-challenge.example.com": "public.auth.example.org"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if setupInfo != nil {
+		t.Errorf("Expected no setup needed with matching override target, got %v", setupInfo)
+	}
+
+	// The account target alone no longer satisfies the check, and instructions use the override
+	setupInfo, err = PreCheckAcmeDNSWithResolver(cfg, store, []string{"example.com"},
+		staticResolver{"_acme-challenge.example.com": "internal.auth.example.org"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(setupInfo) != 1 || setupInfo[0].TargetDomain != "public.auth.example.org" {
+		t.Errorf("Expected setup instruction for override target, got %v", setupInfo)
+	}
+}
+
+func TestPreCheckAcmeDNS_ChallengeAlias(t *testing.T) {
+	store, err := NewAccountStore(filepath.Join(t.TempDir(), "acme-dns-accounts.json"))
+	if err != nil {
+		t.Fatalf("Failed to create account store: %v", err)
+	}
+	store.SetAccount("example.com", AcmeDnsAccount{FullDomain: "abc.auth.example.org"})
+	cfg := &Config{ChallengeAlias: map[string]string{"*.example.com": "example-com.challenges.example.net."}}
+	if got := cfg.ChallengeAliasFor("example.com"); got != "example-com.challenges.example.net" {
+		t.Fatalf("Expected the wildcard alias for the base domain, got %q", got)
+	}
+
+	const alias = "example-com.challenges.example.net"
+	tests := []struct {
+		name     string
+		resolver staticResolver
+		want     []DNSSetupInfo
+	}{
+		{"missing", staticResolver{}, []DNSSetupInfo{
+			{ChallengeDomain: "_acme-challenge.example.com", TargetDomain: alias},
+			{ChallengeDomain: alias, TargetDomain: "abc.auth.example.org"},
+		}},
+		{"alias missing", staticResolver{"_acme-challenge.example.com": alias}, []DNSSetupInfo{
+			{ChallengeDomain: alias, TargetDomain: "abc.auth.example.org"},
+		}},
+		{"complete", staticResolver{"_acme-challenge.example.com": alias, alias: "abc.auth.example.org"}, nil},
+		// Resolvers that follow the chain return the final name, the extra hop
+		// through the alias must match it
+		{"followed", staticResolver{"_acme-challenge.example.com": "abc.auth.example.org", alias: "abc.auth.example.org"}, nil},
+		{"direct", staticResolver{"_acme-challenge.example.com": "old.auth.example.org", alias: "abc.auth.example.org"}, []DNSSetupInfo{
+			{ChallengeDomain: "_acme-challenge.example.com", TargetDomain: alias},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupInfo, err := PreCheckAcmeDNSWithResolver(cfg, store, []string{"example.com", "*.example.com"}, tt.resolver)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			sort.Slice(setupInfo, func(i, j int) bool { return setupInfo[i].ChallengeDomain < setupInfo[j].ChallengeDomain })
+			if !reflect.DeepEqual(setupInfo, tt.want) {
+				t.Errorf("Expected %+v, got %+v", tt.want, setupInfo)
+			}
+		})
+	}
+}
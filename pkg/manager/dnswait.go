@@ -0,0 +1,110 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Defaults for the -wait-for-dns command line options
+const (
+	DefaultDNSWaitTimeout  = 30 * time.Minute
+	DefaultDNSWaitInterval = 30 * time.Second
+)
+
+// DNSWaitOptions controls waiting for required CNAME records instead of exiting
+// with ErrDNSSetupNeeded. It is set from the command line, not the config file.
+type DNSWaitOptions struct {
+	Timeout  time.Duration // Give up after this long (default: 30m)
+	Interval time.Duration // Time between checks (default: 30s)
+}
+
+// WaitForDNSSetup polls the required CNAME records with resolver until all of
+// them point to their targets. It returns nil once they do, an error wrapping
+// ErrDNSSetupNeeded if the timeout expires first, and the context error if ctx
+// is canceled.
+func WaitForDNSSetup(ctx context.Context, opts *DNSWaitOptions, resolver DNSResolver, setupInfo []DNSSetupInfo) error {
+	timeout, interval := DefaultDNSWaitTimeout, DefaultDNSWaitInterval
+	if opts != nil && opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+	if opts != nil && opts.Interval > 0 {
+		interval = opts.Interval
+	}
+
+	deadline := time.Now().Add(timeout)
+	DefaultLogger.Warnf("Waiting up to %s for the DNS records to appear (checking every %s)...", timeout, interval)
+
+	pending := setupInfo
+	for {
+		pending = pendingDNSRecords(ctx, resolver, pending)
+		if len(pending) == 0 {
+			DefaultLogger.Info("All required DNS records are in place, continuing")
+			return nil
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			var names []string
+			for _, info := range pending {
+				names = append(names, info.ChallengeDomain)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("%w: records still missing after %s: %s", ErrDNSSetupNeeded, timeout, strings.Join(names, ", "))
+		}
+		if wait > interval {
+			wait = interval
+		}
+
+		DefaultLogger.Infof("%d of %d DNS record(s) still missing, checking again in %s", len(pending), len(setupInfo), wait.Round(time.Second))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// pendingDNSRecords returns the records whose CNAME does not yet point to the
+// expected target. Lookup failures count as pending, they are expected while
+// records propagate.
+func pendingDNSRecords(ctx context.Context, resolver DNSResolver, setupInfo []DNSSetupInfo) []DNSSetupInfo {
+	var pending []DNSSetupInfo
+	for _, info := range setupInfo {
+		lookupCtx, cancel := context.WithTimeout(ctx, DefaultDNSTimeout*time.Second)
+		cname, err := resolver.LookupCNAME(lookupCtx, info.ChallengeDomain)
+		cancel()
+
+		expected := strings.TrimSuffix(info.TargetDomain, ".")
+		switch {
+		case err != nil:
+			DefaultLogger.Debugf("CNAME lookup for %s failed: %v", info.ChallengeDomain, err)
+			pending = append(pending, info)
+		case !strings.EqualFold(strings.TrimSuffix(cname, "."), expected):
+			DefaultLogger.Debugf("CNAME for %s is %s, waiting for %s", info.ChallengeDomain, cname, expected)
+			pending = append(pending, info)
+		case len(info.MissingOn) > 0 && !authoritativeComplete(ctx, resolver, &info):
+			DefaultLogger.Debugf("CNAME for %s is still missing on %s", info.ChallengeDomain, strings.Join(info.MissingOn, ", "))
+			pending = append(pending, info)
+		default:
+			DefaultLogger.Infof("CNAME record for %s is now valid", info.ChallengeDomain)
+		}
+	}
+	return pending
+}
+
+// authoritativeComplete repeats the authoritative check for a record that was
+// missing on some nameservers and updates the list of those still missing it
+func authoritativeComplete(ctx context.Context, resolver DNSResolver, info *DNSSetupInfo) bool {
+	missing, err := checkAuthoritativeCNAME(ctx, resolver, info.ChallengeDomain, info.TargetDomain)
+	if err != nil {
+		DefaultLogger.Debugf("Authoritative check for %s failed: %v", info.ChallengeDomain, err)
+		return false
+	}
+	info.MissingOn = missing
+	return len(missing) == 0
+}
@@ -0,0 +1,103 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// appearingResolver answers with the CNAME only after a number of lookups
+type appearingResolver struct {
+	mu      sync.Mutex
+	records map[string]string
+	after   int
+	lookups int
+}
+
+func (r *appearingResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups++
+	if target, ok := r.records[host]; ok && r.lookups > r.after {
+		return target + ".", nil
+	}
+	return "", &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestWaitForDNSSetup_RecordsAppear(t *testing.T) {
+	resolver := &appearingResolver{
+		records: map[string]string{
+			"_acme-challenge.example.com": "abc.auth.example.org",
+			"_acme-challenge.example.net": "def.auth.example.org",
+		},
+		after: 3,
+	}
+	setupInfo := []DNSSetupInfo{
+		{ChallengeDomain: "_acme-challenge.example.com", TargetDomain: "abc.auth.example.org."},
+		{ChallengeDomain: "_acme-challenge.example.net", TargetDomain: "def.auth.example.org"},
+	}
+
+	opts := &DNSWaitOptions{Timeout: 5 * time.Second, Interval: 10 * time.Millisecond}
+	if err := WaitForDNSSetup(context.Background(), opts, resolver, setupInfo); err != nil {
+		t.Fatalf("Expected records to be found, got %v", err)
+	}
+	if resolver.lookups < 4 {
+		t.Errorf("Expected repeated lookups while records were missing, got %d", resolver.lookups)
+	}
+}
+
+func TestWaitForDNSSetup_Timeout(t *testing.T) {
+	resolver := &appearingResolver{records: map[string]string{
+		"_acme-challenge.example.com": "wrong.auth.example.org",
+	}}
+	setupInfo := []DNSSetupInfo{
+		{ChallengeDomain: "_acme-challenge.example.com", TargetDomain: "abc.auth.example.org"},
+	}
+
+	opts := &DNSWaitOptions{Timeout: 50 * time.Millisecond, Interval: 10 * time.Millisecond}
+	err := WaitForDNSSetup(context.Background(), opts, resolver, setupInfo)
+	if !errors.Is(err, ErrDNSSetupNeeded) {
+		t.Fatalf("Expected ErrDNSSetupNeeded after timeout, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "_acme-challenge.example.com") {
+		t.Errorf("Expected missing record in error, got %v", err)
+	}
+}
+
+func TestWaitForDNSSetup_Canceled(t *testing.T) {
+	resolver := &appearingResolver{}
+	setupInfo := []DNSSetupInfo{
+		{ChallengeDomain: "_acme-challenge.example.com", TargetDomain: "abc.auth.example.org"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	opts := &DNSWaitOptions{Timeout: time.Minute, Interval: 5 * time.Millisecond}
+	if err := WaitForDNSSetup(ctx, opts, resolver, setupInfo); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestWaitForCreatedDNSRecords(t *testing.T) {
+	resolver := &appearingResolver{records: map[string]string{"_acme-challenge.example.com": "abc.auth.example.org"}, after: 2}
+	cfg := &Config{DNSWait: &DNSWaitOptions{Timeout: 5 * time.Second, Interval: 10 * time.Millisecond}}
+	created := []DNSSetupInfo{{ChallengeDomain: "_acme-challenge.example.com", TargetDomain: "abc.auth.example.org"}}
+
+	if err := WaitForCreatedDNSRecords(context.Background(), cfg, resolver, created); err != nil {
+		t.Fatalf("Expected the created record to propagate, got %v", err)
+	}
+	if resolver.lookups < 3 {
+		t.Errorf("Expected to wait until the record resolves, got %d lookups", resolver.lookups)
+	}
+	if err := WaitForCreatedDNSRecords(context.Background(), cfg, resolver, nil); err != nil {
+		t.Errorf("Expected nothing to wait for without created records, got %v", err)
+	}
+}
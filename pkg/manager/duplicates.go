@@ -0,0 +1,104 @@
+package manager
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Values of auto_domains.duplicate_domains
+const (
+	DuplicateDomainsWarn   = "warn"   // Log every duplicate, the default
+	DuplicateDomainsError  = "error"  // Refuse the configuration or the requests
+	DuplicateDomainsIgnore = "ignore" // Say nothing, e.g. for deliberate RSA and ECDSA twins
+)
+
+// DuplicateDomain is a domain that several certificates request
+type DuplicateDomain struct {
+	Domain string
+	Certs  []string // Sorted certificate names
+}
+
+// String describes the duplicate in one line
+func (d DuplicateDomain) String() string {
+	return fmt.Sprintf("%s is in certificates %s", d.Domain, strings.Join(d.Certs, ", "))
+}
+
+// FindDuplicateDomains returns the domains that appear in more than one of
+// the certificates, which map names to their domains. Names are compared
+// case-insensitively; a wildcard and its base domain are different names.
+func FindDuplicateDomains(certs map[string][]string) []DuplicateDomain {
+	byDomain := make(map[string]map[string]bool)
+	for certName, domains := range certs {
+		for _, domain := range domains {
+			domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+			if byDomain[domain] == nil {
+				byDomain[domain] = make(map[string]bool)
+			}
+			byDomain[domain][certName] = true
+		}
+	}
+	var duplicates []DuplicateDomain
+	for domain, names := range byDomain {
+		if len(names) < 2 {
+			continue
+		}
+		d := DuplicateDomain{Domain: domain}
+		for name := range names {
+			d.Certs = append(d.Certs, name)
+		}
+		sort.Strings(d.Certs)
+		duplicates = append(duplicates, d)
+	}
+	sort.Slice(duplicates, func(i, j int) bool { return duplicates[i].Domain < duplicates[j].Domain })
+	return duplicates
+}
+
+// AutoDomainsDomains returns the domains of every auto_domains certificate
+// by certificate name
+func (cfg *Config) AutoDomainsDomains() map[string][]string {
+	certs := make(map[string][]string)
+	if cfg.AutoDomains != nil {
+		for name, certCfg := range cfg.AutoDomains.Certs {
+			certs[name] = certCfg.Domains
+		}
+	}
+	return certs
+}
+
+// DuplicateDomainsPolicy returns auto_domains.duplicate_domains, defaulting to warn
+func (cfg *Config) DuplicateDomainsPolicy() string {
+	if cfg.AutoDomains == nil || cfg.AutoDomains.DuplicateDomains == "" {
+		return DuplicateDomainsWarn
+	}
+	return cfg.AutoDomains.DuplicateDomains
+}
+
+// CheckDuplicateDomains reports the domains requested by several of the
+// certificates according to the duplicate_domains policy: it logs them, or
+// returns an error listing them. Two certificates for the same domain are
+// usually a copy-paste mistake, and their renewals draw on the same
+// duplicate certificate limit of the CA.
+func (cfg *Config) CheckDuplicateDomains(certs map[string][]string) error {
+	return cfg.ReportDuplicateDomains(FindDuplicateDomains(certs))
+}
+
+// ReportDuplicateDomains applies the duplicate_domains policy to duplicates
+// found by FindDuplicateDomains, see CheckDuplicateDomains
+func (cfg *Config) ReportDuplicateDomains(duplicates []DuplicateDomain) error {
+	policy := cfg.DuplicateDomainsPolicy()
+	if policy == DuplicateDomainsIgnore || len(duplicates) == 0 {
+		return nil
+	}
+	if policy == DuplicateDomainsError {
+		lines := make([]string, len(duplicates))
+		for i, d := range duplicates {
+			lines[i] = d.String()
+		}
+		return fmt.Errorf("domains requested by several certificates (auto_domains.duplicate_domains is error): %s", strings.Join(lines, "; "))
+	}
+	for _, d := range duplicates {
+		DefaultLogger.Warnf("Warning: %s; set auto_domains.duplicate_domains to ignore if this is intended", d)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindDuplicateDomains(t *testing.T) {
+	duplicates := FindDuplicateDomains(map[string][]string{
+		"web":      {"example.com", "www.example.com"},
+		"web-copy": {"WWW.example.com", "shop.example.com"},
+		"wildcard": {"*.example.com", "example.com."},
+		"other":    {"example.org"},
+	})
+	if len(duplicates) != 2 {
+		t.Fatalf("Expected 2 duplicates, got %v", duplicates)
+	}
+	if got := duplicates[0].String(); got != "example.com is in certificates web, wildcard" {
+		t.Errorf("Unexpected first duplicate %q", got)
+	}
+	if got := duplicates[1].String(); got != "www.example.com is in certificates web, web-copy" {
+		t.Errorf("Unexpected second duplicate %q", got)
+	}
+}
+
+func TestLoadConfig_DuplicateDomains(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(policy string) {
+		t.Helper()
+		content := `
+email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+auto_domains:
+` + policy + `
+  certs:
+    web:
+      domains: ["example.com", "www.example.com"]
+    mail:
+      domains: ["mail.example.com", "www.example.com"]
+`
+		if err := os.WriteFile(configPath, []byte(content), PrivateKeyPermissions); err != nil {
+			t.Fatalf("Failed to write test config: %v", err)
+		}
+	}
+
+	for _, policy := range []string{"", "  duplicate_domains: warn", "  duplicate_domains: ignore"} {
+		write(policy)
+		if _, err := LoadConfig(configPath); err != nil {
+			t.Errorf("%q: expected the config to load, got %v", policy, err)
+		}
+	}
+
+	write("  duplicate_domains: error")
+	_, err := LoadConfig(configPath)
+	if err == nil || !strings.Contains(err.Error(), "www.example.com is in certificates mail, web") {
+		t.Errorf("Expected the duplicate to be refused, got %v", err)
+	}
+
+	write("  duplicate_domains: fail")
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected schema validation error for an unknown policy")
+	}
+}
@@ -0,0 +1,88 @@
+package manager
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/lego"
+)
+
+// KeyTypeEd25519 is the key_type of certificates with an Ed25519 key. Lego
+// has no Ed25519 key type, such certificates are ordered for a CSR signed
+// with a key generated here. Public CAs like Let's Encrypt reject Ed25519
+// CSRs, it is meant for private CAs.
+const KeyTypeEd25519 = "ed25519"
+
+// encodePrivateKey PEM encodes a certificate private key. Lego only encodes
+// RSA and ECDSA keys, Ed25519 keys are stored as PKCS#8.
+func encodePrivateKey(key crypto.PrivateKey) ([]byte, error) {
+	if k, ok := key.(ed25519.PrivateKey); ok {
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+	return certcrypto.PEMEncode(key), nil
+}
+
+// obtainEd25519 orders a certificate with an Ed25519 key, the reused one or
+// a fresh one. Like certificates with a csr_path, init and renewal are both
+// a new order for the domains.
+func obtainEd25519(ctx context.Context, client *lego.Client, cfg *Config, certName string, domains []string, profile string, orderKey crypto.PrivateKey, policy RetryConfig, recorder *retryAfterRecorder) error {
+	key, ok := orderKey.(ed25519.PrivateKey)
+	if !ok {
+		return fmt.Errorf("certificate %s: expected an Ed25519 key, got %T", certName, orderKey)
+	}
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("encoding Ed25519 key: %w", err)
+	}
+
+	// Same subject as Lego would use: the first domain as common name if it fits
+	commonName := ""
+	if len(domains[0]) <= 64 {
+		commonName = domains[0]
+	}
+	der, err := certcrypto.CreateCSR(key, certcrypto.CSROptions{Domain: commonName, SAN: domains})
+	if err != nil {
+		return fmt.Errorf("creating CSR for %s: %w", certName, err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return fmt.Errorf("parsing CSR for %s: %w", certName, err)
+	}
+
+	DefaultLogger.Infof("Requesting certificate %s with an Ed25519 key for domains: %s", certName, DisplayDomains(domains))
+	request := certificate.ObtainForCSRRequest{
+		CSR:     csr,
+		Bundle:  true,
+		Profile: profile,
+	}
+	var resource *certificate.Resource
+	err = withRetry(ctx, policy, "obtain certificate", recorder, func() error {
+		var obtainErr error
+		resource, obtainErr = client.Certificate.ObtainForCSR(request)
+		return obtainErr
+	})
+	if err != nil {
+		return fmt.Errorf("failed to obtain certificate: %w", err)
+	}
+	DefaultLogger.Infof("Successfully obtained certificate '%s'!", certName)
+
+	resource.PrivateKey = keyPEM
+	if err := validateIssuedCertificate(certName, resource, domains, time.Now()); err != nil {
+		return err
+	}
+	if err := storeCertificates(cfg, certName, resource); err != nil {
+		return fmt.Errorf("failed to save certificate '%s': %w", certName, err)
+	}
+	return nil
+}
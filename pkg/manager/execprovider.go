@@ -0,0 +1,161 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+)
+
+// DNSChallengeProviderExec delegates DNS-01 challenges to a script
+const DNSChallengeProviderExec = "exec"
+
+// DefaultExecProviderTimeout limits one call of the exec DNS provider script
+const DefaultExecProviderTimeout = 2 * time.Minute
+
+// Actions sent to the exec DNS provider script
+const (
+	ExecActionPresent = "present"
+	ExecActionCleanup = "cleanup"
+)
+
+// ExecProviderRequest is written as JSON to the stdin of the exec DNS
+// provider script
+type ExecProviderRequest struct {
+	Action string `json:"action"` // present or cleanup
+	Domain string `json:"domain"` // Domain the challenge is for, without wildcard prefix
+	FQDN   string `json:"fqdn"`   // TXT record name after following CNAMEs, with trailing dot
+	Value  string `json:"value"`  // TXT record value
+	TTL    int    `json:"ttl"`
+}
+
+// ExecProviderResponse is read as JSON from the stdout of the exec DNS
+// provider script. Empty output counts as success if the script exits 0.
+type ExecProviderResponse struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ExecProviderError describes a failed call of the exec DNS provider script
+type ExecProviderError struct {
+	Action   string
+	FQDN     string
+	ExitCode int    // -1 if the script did not exit normally
+	Message  string // error from the response, or why the call failed
+	Stderr   string // Last part of stderr
+}
+
+func (e *ExecProviderError) Error() string {
+	msg := fmt.Sprintf("exec DNS provider %s of %s failed: %s", e.Action, e.FQDN, e.Message)
+	if e.ExitCode > 0 {
+		msg += fmt.Sprintf(" (exit code %d)", e.ExitCode)
+	}
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+// execProvider implements challenge.Provider by running a script
+type execProvider struct {
+	command []string
+	timeout time.Duration
+	ttl     int
+}
+
+var _ challenge.Provider = (*execProvider)(nil)
+
+// newExecProvider creates the exec DNS provider from its configuration
+func newExecProvider(c *DNSChallengeExecConfig) (*execProvider, error) {
+	if c == nil || len(c.Command) == 0 {
+		return nil, fmt.Errorf("dns_challenge_exec.command is required with the exec DNS provider")
+	}
+	p := &execProvider{command: c.Command, timeout: c.Timeout, ttl: c.TTL}
+	if p.timeout <= 0 {
+		p.timeout = DefaultExecProviderTimeout
+	}
+	if p.ttl <= 0 {
+		p.ttl = dns01.DefaultTTL
+	}
+	return p, nil
+}
+
+// Present asks the script to create the challenge TXT record
+func (p *execProvider) Present(domain, token, keyAuth string) error {
+	return p.call(context.Background(), ExecActionPresent, domain, keyAuth)
+}
+
+// CleanUp asks the script to remove the challenge TXT record
+func (p *execProvider) CleanUp(domain, token, keyAuth string) error {
+	return p.call(context.Background(), ExecActionCleanup, domain, keyAuth)
+}
+
+// call runs the script for one action and interprets its response
+func (p *execProvider) call(ctx context.Context, action, domain, keyAuth string) error {
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+	req := ExecProviderRequest{Action: action, Domain: domain, FQDN: info.EffectiveFQDN, Value: info.Value, TTL: p.ttl}
+	input, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, p.command[0], p.command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	DefaultLogger.Debugf("Running exec DNS provider %s for %s", action, info.EffectiveFQDN)
+	runErr := cmd.Run()
+
+	fail := func(exitCode int, message string) error {
+		return &ExecProviderError{Action: action, FQDN: info.EffectiveFQDN, ExitCode: exitCode, Message: message, Stderr: tail(stderr.String(), 512)}
+	}
+
+	var resp ExecProviderResponse
+	out := bytes.TrimSpace(stdout.Bytes())
+	parseErr := error(nil)
+	if len(out) > 0 {
+		parseErr = json.Unmarshal(out, &resp)
+	}
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return fail(-1, fmt.Sprintf("timed out after %s", p.timeout))
+	case runErr != nil:
+		exitCode := -1
+		if exitErr, ok := runErr.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		}
+		message := runErr.Error()
+		if parseErr == nil && resp.Error != "" {
+			message = resp.Error
+		}
+		return fail(exitCode, message)
+	case parseErr != nil:
+		return fail(0, fmt.Sprintf("invalid JSON response %q: %v", tail(string(out), 200), parseErr))
+	case len(out) > 0 && !resp.Success:
+		message := resp.Error
+		if message == "" {
+			message = "script reported no success"
+		}
+		return fail(0, message)
+	}
+	return nil
+}
+
+// tail returns the last n bytes of s, trimmed
+func tail(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) > n {
+		s = "..." + s[len(s)-n:]
+	}
+	return s
+}
@@ -0,0 +1,122 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeExecScript writes a shell script for the exec DNS provider tests
+func writeExecScript(t *testing.T, body string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("Shell scripts are not supported on Windows")
+	}
+	path := filepath.Join(t.TempDir(), "dns-hook.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	return path
+}
+
+func TestExecProvider_Request(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "request.json")
+	script := writeExecScript(t, "cat > \"$1\"\necho '{\"success\": true}'\n")
+	p, err := newExecProvider(&DNSChallengeExecConfig{Command: []string{script, out}})
+	if err != nil {
+		t.Fatalf("newExecProvider failed: %v", err)
+	}
+
+	if err := p.Present("example.com", "token", "keyAuth"); err != nil {
+		t.Fatalf("Present failed: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("Script did not receive the request: %v", err)
+	}
+	var req ExecProviderRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatalf("Invalid request JSON %q: %v", data, err)
+	}
+	if req.Action != ExecActionPresent || req.Domain != "example.com" || req.FQDN != "_acme-challenge.example.com." || req.Value == "" || req.TTL != 120 {
+		t.Errorf("Unexpected request: %+v", req)
+	}
+
+	if err := p.CleanUp("example.com", "token", "keyAuth"); err != nil {
+		t.Fatalf("CleanUp failed: %v", err)
+	}
+	data, _ = os.ReadFile(out)
+	if !strings.Contains(string(data), `"action":"cleanup"`) {
+		t.Errorf("Expected a cleanup request, got %s", data)
+	}
+}
+
+func TestExecProvider_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		exitCode int
+		message  string
+	}{
+		{"empty output", "exit 0\n", 0, ""},
+		{"reported failure", "echo '{\"success\": false, \"error\": \"zone locked\"}'\n", 0, "zone locked"},
+		{"exit code with error", "echo '{\"error\": \"no such zone\"}'\necho oops >&2\nexit 3\n", 3, "no such zone"},
+		{"invalid json", "echo done\n", 0, "invalid JSON response"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newExecProvider(&DNSChallengeExecConfig{Command: []string{writeExecScript(t, tt.script)}})
+			if err != nil {
+				t.Fatalf("newExecProvider failed: %v", err)
+			}
+			err = p.Present("example.com", "token", "keyAuth")
+			if tt.message == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			var execErr *ExecProviderError
+			if !errors.As(err, &execErr) {
+				t.Fatalf("Expected an ExecProviderError, got %v", err)
+			}
+			if execErr.ExitCode != tt.exitCode || !strings.Contains(execErr.Message, tt.message) {
+				t.Errorf("Unexpected error: %+v", execErr)
+			}
+			if tt.exitCode == 3 && execErr.Stderr != "oops" {
+				t.Errorf("Expected stderr in the error, got %q", execErr.Stderr)
+			}
+		})
+	}
+}
+
+func TestExecProvider_Timeout(t *testing.T) {
+	p, err := newExecProvider(&DNSChallengeExecConfig{Command: []string{writeExecScript(t, "exec sleep 5\n")}, Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("newExecProvider failed: %v", err)
+	}
+	err = p.CleanUp("example.com", "token", "keyAuth")
+	var execErr *ExecProviderError
+	if !errors.As(err, &execErr) || !strings.Contains(execErr.Message, "timed out") {
+		t.Errorf("Expected a timeout error, got %v", err)
+	}
+}
+
+func TestValidateDNSChallengeProvider_Exec(t *testing.T) {
+	if err := validateDNSChallengeProvider(&Config{DNSChallengeProvider: DNSChallengeProviderExec}); err == nil || !strings.Contains(err.Error(), "dns_challenge_exec.command") {
+		t.Errorf("Expected the command to be required, got %v", err)
+	}
+	cfg := &Config{DNSChallengeProvider: DNSChallengeProviderExec, DNSChallengeExec: &DNSChallengeExecConfig{Command: []string{"/bin/true"}}}
+	if err := validateDNSChallengeProvider(cfg); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	cfg.DNSChallengeProvider = "cloudflare"
+	if err := validateDNSChallengeProvider(cfg); err == nil {
+		t.Error("Expected dns_challenge_exec to be rejected with a lego provider")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package manager
+
+import "io/fs"
+
+// fileOwner is not available on Windows, files have ACLs instead of owners
+func fileOwner(info fs.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,315 @@
+package manager
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FilePermissionsConfig sets the modes and ownership of the certificates,
+// keys and accounts the manager writes, e.g. to make keys readable by the
+// group of a service account. Modes are octal strings like "0640".
+type FilePermissionsConfig struct {
+	KeyMode  string `yaml:"key_mode,omitempty"`  // Private keys, metadata and accounts (default: 0600), never readable by others
+	CertMode string `yaml:"cert_mode,omitempty"` // Certificates, chains and TLSA records (default: 0644)
+	DirMode  string `yaml:"dir_mode,omitempty"`  // Directories below the storage path (default: 0750)
+	Owner    string `yaml:"owner,omitempty"`     // User name or id, default: the user running the manager
+	Group    string `yaml:"group,omitempty"`     // Group name or id, default: the primary group of the owner
+}
+
+// filePolicy is the resolved file_permissions configuration. A nil policy
+// stands for the built-in modes without changing ownership.
+type filePolicy struct {
+	keyMode  os.FileMode
+	certMode os.FileMode
+	dirMode  os.FileMode
+	uid      int // -1 keeps the owner
+	gid      int // -1 keeps the group
+}
+
+// filePolicy resolves file_permissions, nil if it is not configured
+func (cfg *Config) filePolicy() (*filePolicy, error) {
+	fp := cfg.FilePermissions
+	if fp == nil {
+		return nil, nil
+	}
+	p := &filePolicy{keyMode: PrivateKeyPermissions, certMode: CertificatePermissions, dirMode: DirPermissions, uid: -1, gid: -1}
+	var err error
+	if p.keyMode, err = parseFileMode("key_mode", fp.KeyMode, p.keyMode); err != nil {
+		return nil, err
+	}
+	if p.keyMode&0007 != 0 {
+		return nil, fmt.Errorf("file_permissions.key_mode %q gives other users access to private keys, use the group bits and file_permissions.group instead", fp.KeyMode)
+	}
+	if p.certMode, err = parseFileMode("cert_mode", fp.CertMode, p.certMode); err != nil {
+		return nil, err
+	}
+	if p.dirMode, err = parseFileMode("dir_mode", fp.DirMode, p.dirMode); err != nil {
+		return nil, err
+	}
+	if fp.Owner != "" {
+		if p.uid, err = lookupUser("file_permissions.owner", fp.Owner); err != nil {
+			return nil, err
+		}
+	}
+	if fp.Group != "" {
+		if p.gid, err = lookupGroup("file_permissions.group", fp.Group); err != nil {
+			return nil, err
+		}
+	} else if fp.Owner != "" {
+		p.gid = primaryGroup(p.uid)
+	}
+	return p, nil
+}
+
+func parseFileMode(field, value string, def os.FileMode) (os.FileMode, error) {
+	if value == "" {
+		return def, nil
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("file_permissions.%s %q is not an octal file mode like 0640", field, value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// lookupUser returns the uid of a user name or numeric id, field names the
+// setting in errors
+func lookupUser(field, name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", field, err)
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+// primaryGroup returns the primary gid of uid, -1 if the user database has
+// no entry for it
+func primaryGroup(uid int) int {
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return -1
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return -1
+	}
+	return gid
+}
+
+// lookupGroup returns the gid of a group name or numeric id
+func lookupGroup(field, name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", field, err)
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// fileMode returns the mode of private or public files
+func (p *filePolicy) fileMode(private bool) os.FileMode {
+	switch {
+	case p == nil && private:
+		return PrivateKeyPermissions
+	case p == nil:
+		return CertificatePermissions
+	case private:
+		return p.keyMode
+	default:
+		return p.certMode
+	}
+}
+
+// directoryMode returns the mode of directories
+func (p *filePolicy) directoryMode() os.FileMode {
+	if p == nil {
+		return DirPermissions
+	}
+	return p.dirMode
+}
+
+// owner returns the uid and gid files are given, -1 keeps them
+func (p *filePolicy) owner() (int, int) {
+	if p == nil {
+		return -1, -1
+	}
+	return p.uid, p.gid
+}
+
+// mkdirAll creates dir below root. With a policy, the mode and ownership of
+// every directory between root and dir are set as well, the umask and
+// directories created before must not keep the service account out.
+func (p *filePolicy) mkdirAll(root, dir string) error {
+	if p == nil {
+		return os.MkdirAll(dir, DirPermissions)
+	}
+	if err := os.MkdirAll(dir, p.dirMode); err != nil {
+		return err
+	}
+	root = filepath.Clean(root)
+	for d := filepath.Clean(dir); d != root && strings.HasPrefix(d, root+string(filepath.Separator)); d = filepath.Dir(d) {
+		if err := p.apply(d, p.dirMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply sets mode and ownership of an existing file or directory
+func (p *filePolicy) apply(path string, mode os.FileMode) error {
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+	if uid, gid := p.owner(); uid >= 0 || gid >= 0 {
+		return os.Chown(path, uid, gid)
+	}
+	return nil
+}
+
+// localFilePolicy returns the policy of the storage directory behind store
+func localFilePolicy(store CertificateStore) *filePolicy {
+	switch s := store.(type) {
+	case *fileStore:
+		return s.policy
+	case *syncedStore:
+		return s.local.policy
+	}
+	return nil
+}
+
+// PermissionFix is one file or directory whose mode or ownership differs
+// from file_permissions
+type PermissionFix struct {
+	Path   string `json:"path"` // Storage-relative
+	Detail string `json:"detail"`
+	Fixed  bool   `json:"fixed"`
+	Error  string `json:"error,omitempty"` // Why correcting it failed
+}
+
+// PermissionsReport is the result of FixPermissions
+type PermissionsReport struct {
+	Checked int             `json:"checked"` // Files and directories checked
+	Fixes   []PermissionFix `json:"fixes"`
+}
+
+// Failed returns the number of files that could not be corrected
+func (r *PermissionsReport) Failed() int {
+	count := 0
+	for _, fix := range r.Fixes {
+		if fix.Error != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// FixPermissions checks the certificates, ACME accounts and acme-dns
+// accounts in the storage directory, and the directories holding them,
+// against file_permissions, or the built-in modes if it is not set, and
+// corrects them unless dryRun is set. Other files, like the state and the
+// archive, stay private to the manager. File modes and owners are not
+// meaningful on Windows, so nothing is checked there.
+func FixPermissions(cfg *Config, dryRun bool) (*PermissionsReport, error) {
+	policy, err := cfg.filePolicy()
+	if err != nil {
+		return nil, err
+	}
+	report := &PermissionsReport{Fixes: []PermissionFix{}}
+	if runtime.GOOS == "windows" {
+		return report, nil
+	}
+	storagePath := cfg.CertStoragePath
+	wantUID, wantGID := policy.owner()
+
+	err = filepath.WalkDir(storagePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == storagePath {
+				return fs.SkipDir
+			}
+			return err
+		}
+		// The storage directory itself is created by the user, like in VerifyStorage
+		if path == storagePath || d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		key, err := manifestKey(storagePath, path)
+		if err != nil {
+			return err
+		}
+		if !isPolicyStorageKey(key, d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		report.Checked++
+
+		want := policy.fileMode(!isPublicStorageFile(path))
+		if d.IsDir() {
+			want = policy.directoryMode()
+		}
+		var details []string
+		if mode := info.Mode().Perm(); mode != want {
+			details = append(details, fmt.Sprintf("mode %04o, should be %04o", mode, want))
+		}
+		if uid, gid, ok := fileOwner(info); ok {
+			if wantUID >= 0 && uid != wantUID {
+				details = append(details, fmt.Sprintf("owner %d, should be %d", uid, wantUID))
+			}
+			if wantGID >= 0 && gid != wantGID {
+				details = append(details, fmt.Sprintf("group %d, should be %d", gid, wantGID))
+			}
+		}
+		if len(details) == 0 {
+			return nil
+		}
+
+		fix := PermissionFix{Path: key, Detail: strings.Join(details, ", ")}
+		if !dryRun {
+			if err := policy.apply(path, want); err != nil {
+				fix.Error = err.Error()
+			} else {
+				fix.Fixed = true
+			}
+		}
+		report.Fixes = append(report.Fixes, fix)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scanning storage directory: %w", err)
+	}
+	sort.SliceStable(report.Fixes, func(i, j int) bool { return report.Fixes[i].Path < report.Fixes[j].Path })
+	return report, nil
+}
+
+// isPolicyStorageKey reports whether the storage-relative key is written
+// through the store, and so follows file_permissions. Directories match if
+// they hold such files.
+func isPolicyStorageKey(key string, dir bool) bool {
+	for _, prefix := range syncedStoragePrefixes {
+		if strings.HasSuffix(prefix, "/") {
+			if strings.HasPrefix(key, prefix) || dir && key+"/" == prefix {
+				return true
+			}
+		} else if !dir && (key == prefix || key == prefix+BackupSuffix || key == prefix+MigratedSuffix) {
+			return true
+		}
+	}
+	return false
+}
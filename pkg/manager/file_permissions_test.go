@@ -0,0 +1,179 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestConfig_FilePolicy(t *testing.T) {
+	if p, err := (&Config{}).filePolicy(); p != nil || err != nil {
+		t.Errorf("Expected no policy without file_permissions, got %+v, %v", p, err)
+	}
+
+	cfg := &Config{FilePermissions: &FilePermissionsConfig{KeyMode: "0640", Owner: strconv.Itoa(os.Getuid()), Group: strconv.Itoa(os.Getgid())}}
+	p, err := cfg.filePolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.keyMode != 0640 || p.certMode != CertificatePermissions || p.dirMode != DirPermissions {
+		t.Errorf("Unexpected modes %04o %04o %04o", p.keyMode, p.certMode, p.dirMode)
+	}
+	if p.uid != os.Getuid() || p.gid != os.Getgid() {
+		t.Errorf("Expected numeric owner and group, got %d:%d", p.uid, p.gid)
+	}
+
+	owner := &Config{FilePermissions: &FilePermissionsConfig{Owner: strconv.Itoa(os.Getuid())}}
+	if p, err = owner.filePolicy(); err != nil {
+		t.Fatal(err)
+	}
+	if want := primaryGroup(os.Getuid()); p.gid != want {
+		t.Errorf("Expected the primary group %d of the owner, got %d", want, p.gid)
+	}
+
+	for _, fp := range []FilePermissionsConfig{{KeyMode: "640x"}, {KeyMode: "0644"}, {KeyMode: "0601"}, {DirMode: "01777"}, {Owner: "no-such-user-acme-test"}, {Group: "no-such-group-acme-test"}} {
+		if _, err := (&Config{FilePermissions: &fp}).filePolicy(); err == nil {
+			t.Errorf("Expected an error for %+v", fp)
+		}
+	}
+}
+
+func TestFileStore_Put_FilePolicy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not meaningful on Windows")
+	}
+	dir := t.TempDir()
+	cfg := &Config{CertStoragePath: dir, FilePermissions: &FilePermissionsConfig{KeyMode: "0640", CertMode: "0604", DirMode: "0710", Group: strconv.Itoa(os.Getgid())}}
+	if err := writeStorageFile(cfg, filepath.Join(dir, "certificates", "web.key"), []byte("key"), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeStorageFile(cfg, filepath.Join(dir, "certificates", "web.crt"), []byte("cert"), false); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]os.FileMode{
+		"certificates":         0710,
+		"certificates/web.key": 0640,
+		"certificates/web.crt": 0604,
+	} {
+		info, err := os.Stat(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("Expected %s with mode %04o, got %04o", path, want, got)
+		}
+	}
+
+	// The modes of the policy are not reported as too open
+	report, err := VerifyConfigStorage(cfg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, issue := range report.Issues {
+		if issue.Kind == StoragePermissions {
+			t.Errorf("Unexpected permissions issue %+v", issue)
+		}
+	}
+}
+
+func TestFixPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not meaningful on Windows")
+	}
+	dir := t.TempDir()
+	certs := filepath.Join(dir, "certificates")
+	if err := os.MkdirAll(certs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]os.FileMode{
+		"certificates/web.key":  0600,
+		"certificates/web.crt":  0600,
+		AcmeDNSAccountsFile:     0644,
+		"state.json":            0600, // Not written through the store, stays private
+		"archive/web/1/web.key": 0600,
+	}
+	for path, mode := range files {
+		full := filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte("x"), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(full, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(certs, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{CertStoragePath: dir, FilePermissions: &FilePermissionsConfig{KeyMode: "0640"}}
+	report, err := FixPermissions(cfg, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, fix := range report.Fixes {
+		if fix.Fixed {
+			t.Errorf("Expected no changes in a dry run, %s was fixed", fix.Path)
+		}
+		paths = append(paths, fix.Path)
+	}
+	want := []string{AcmeDNSAccountsFile, "certificates", "certificates/web.crt", "certificates/web.key"}
+	if len(paths) != len(want) {
+		t.Fatalf("Expected %v to differ, got %v", want, paths)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Errorf("Expected %v to differ, got %v", want, paths)
+			break
+		}
+	}
+
+	if report, err = FixPermissions(cfg, false); err != nil || report.Failed() != 0 {
+		t.Fatalf("Expected the fix to succeed, got %v, %+v", err, report)
+	}
+	if info, _ := os.Stat(filepath.Join(certs, "web.key")); info.Mode().Perm() != 0640 {
+		t.Errorf("Expected web.key with mode 0640, got %04o", info.Mode().Perm())
+	}
+	if info, _ := os.Stat(filepath.Join(dir, "archive", "web", "1", "web.key")); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the archived key to stay private, got %04o", info.Mode().Perm())
+	}
+	if report, _ = FixPermissions(cfg, true); len(report.Fixes) != 0 {
+		t.Errorf("Expected nothing left to fix, got %+v", report.Fixes)
+	}
+}
+
+func TestLoadConfig_FilePermissions(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	load := func(section string) (*Config, error) {
+		content := `
+email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+` + section
+		if err := os.WriteFile(configPath, []byte(content), PrivateKeyPermissions); err != nil {
+			t.Fatalf("Failed to write test config: %v", err)
+		}
+		return LoadConfig(configPath)
+	}
+
+	cfg, err := load("file_permissions:\n  key_mode: \"0640\"\n  group: \"" + strconv.Itoa(os.Getgid()) + "\"\n")
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.FilePermissions == nil || cfg.FilePermissions.KeyMode != "0640" {
+		t.Errorf("Unexpected file_permissions: %+v", cfg.FilePermissions)
+	}
+
+	// Modes that are not octal are rejected by the schema, unknown users when loading
+	if _, err := load("file_permissions:\n  key_mode: \"rw-r-----\"\n"); err == nil {
+		t.Error("Expected an error for a symbolic mode")
+	}
+	if _, err := load("file_permissions:\n  owner: \"no-such-user-acme-test\"\n"); err == nil {
+		t.Error("Expected an error for an unknown owner")
+	}
+}
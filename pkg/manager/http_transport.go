@@ -0,0 +1,114 @@
+package manager
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// customTransports holds one transport per combination of proxy and TLS
+// settings, so the clients of a run share their connections
+var customTransports sync.Map
+
+// hasTransportSettings reports whether the ACME and acme-dns clients need
+// more than http.DefaultTransport
+func (cfg *Config) hasTransportSettings() bool {
+	return cfg.ProxyURL != "" || cfg.CABundlePath != "" || cfg.InsecureSkipVerify
+}
+
+// baseTransport returns http.DefaultTransport, which honors the proxy
+// environment variables, or a transport with proxy_url, ca_bundle_path and
+// insecure_skip_verify applied if any of them is set
+func (cfg *Config) baseTransport() http.RoundTripper {
+	if !cfg.hasTransportSettings() {
+		return http.DefaultTransport
+	}
+	key := cfg.ProxyURL + "|" + cfg.CABundlePath + "|" + strconv.FormatBool(cfg.InsecureSkipVerify)
+	if transport, ok := customTransports.Load(key); ok {
+		return transport.(http.RoundTripper)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	cfg.configureTransport(transport)
+	actual, _ := customTransports.LoadOrStore(key, transport)
+	return actual.(http.RoundTripper)
+}
+
+// httpTransport returns the transport of the clients talking to the ACME
+// and acme-dns servers: the proxy and TLS settings of baseTransport and the
+// -debug-acme wire log
+func (cfg *Config) httpTransport() http.RoundTripper {
+	return cfg.debugTransport(cfg.baseTransport())
+}
+
+// ClientTransport returns the transport for HTTP clients of other packages,
+// like the notifiers and the metrics push, with the proxy and TLS settings
+// of baseTransport
+func (cfg *Config) ClientTransport() http.RoundTripper {
+	return cfg.baseTransport()
+}
+
+// applyTransportSettings makes a client that brings its own transport, like
+// the one Lego creates with its TLS settings, use the proxy and TLS settings
+func (cfg *Config) applyTransportSettings(client *http.Client) {
+	if !cfg.hasTransportSettings() {
+		return
+	}
+	switch transport := client.Transport.(type) {
+	case nil:
+		client.Transport = cfg.baseTransport()
+	case *http.Transport:
+		cfg.configureTransport(transport)
+	}
+}
+
+// configureTransport applies proxy_url, ca_bundle_path and
+// insecure_skip_verify to transport. Roots already set on the transport,
+// e.g. from LEGO_CA_CERTIFICATES, are kept next to the bundle.
+func (cfg *Config) configureTransport(transport *http.Transport) {
+	if cfg.ProxyURL != "" {
+		transport.Proxy = cfg.proxyFunc()
+	}
+	if cfg.CABundlePath == "" && !cfg.InsecureSkipVerify {
+		return
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if transport.TLSClientConfig != nil {
+		tlsConfig = transport.TLSClientConfig.Clone()
+	}
+	if cfg.CABundlePath != "" {
+		pool, err := loadCABundle(cfg.CABundlePath, tlsConfig.RootCAs)
+		if err != nil {
+			// Checked when the config is loaded, so only a file changed since
+			DefaultLogger.Errorf("Using the system roots only: %v", err)
+		} else {
+			tlsConfig.RootCAs = pool
+		}
+	}
+	if cfg.InsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	transport.TLSClientConfig = tlsConfig
+}
+
+// loadCABundle returns base, or the system roots if base is nil, with the
+// PEM certificates of the file at path added
+func loadCABundle(path string, base *x509.CertPool) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ca_bundle_path: %w", err)
+	}
+	var pool *x509.CertPool
+	if base != nil {
+		pool = base.Clone()
+	} else if pool, err = x509.SystemCertPool(); err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("ca_bundle_path %s: no PEM certificates found", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,62 @@
+package manager
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBaseTransport_TLSSettings(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	bundle := filepath.Join(t.TempDir(), "internal-ca.pem")
+	if err := os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(cfg *Config) error {
+		resp, err := (&http.Client{Transport: cfg.httpTransport()}).Get(server.URL)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+	if transport := (&Config{}).baseTransport(); transport != http.DefaultTransport {
+		t.Errorf("Expected the default transport without settings, got %T", transport)
+	}
+	if err := get(&Config{}); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("Expected a verification error for the private CA, got %v", err)
+	}
+	if err := get(&Config{CABundlePath: bundle}); err != nil {
+		t.Errorf("Expected the server to be trusted with ca_bundle_path: %v", err)
+	}
+	if err := get(&Config{InsecureSkipVerify: true}); err != nil {
+		t.Errorf("Expected no verification with insecure_skip_verify: %v", err)
+	}
+
+	// Roots of Lego's transport are kept next to the bundle
+	legoRoots := x509.NewCertPool()
+	legoTransport := &http.Transport{}
+	legoTransport.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	legoTransport.TLSClientConfig.RootCAs = legoRoots
+	(&Config{CABundlePath: bundle}).applyTransportSettings(&http.Client{Transport: legoTransport})
+	if roots := legoTransport.TLSClientConfig.RootCAs; roots == legoRoots || roots == nil {
+		t.Error("Expected a new pool with the bundle added to Lego's roots")
+	}
+
+	if _, err := loadCABundle(filepath.Join(t.TempDir(), "missing.pem"), nil); err == nil {
+		t.Error("Expected an error for a missing bundle")
+	}
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCABundle(empty, nil); err == nil || !strings.Contains(err.Error(), "no PEM certificates") {
+		t.Errorf("Expected an error for a bundle without certificates, got %v", err)
+	}
+}
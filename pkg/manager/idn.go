@@ -0,0 +1,69 @@
+package manager
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// idnaProfile converts domain names following IDNA2008 as used for lookups:
+// input is mapped (e.g. to lower case) and labels are validated
+var idnaProfile = idna.New(
+	idna.MapForLookup(),
+	idna.BidiRule(),
+	idna.Transitional(false),
+)
+
+// NormalizeDomain converts an internationalized domain name to its ASCII
+// (punycode) form, e.g. bücher.example to xn--bcher-kva.example. ASCII names
+// are returned unchanged. A wildcard prefix is kept.
+func NormalizeDomain(domain string) (string, error) {
+	if isASCII(domain) {
+		return domain, nil
+	}
+	prefix := ""
+	if strings.HasPrefix(domain, "*.") {
+		prefix = "*."
+		domain = strings.TrimPrefix(domain, "*.")
+	}
+	ascii, err := idnaProfile.ToASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("invalid internationalized domain name '%s%s': %w", prefix, domain, err)
+	}
+	return prefix + ascii, nil
+}
+
+// DisplayDomain returns the Unicode form of a punycode domain for logs and
+// instructions, followed by the ASCII form if they differ. Names which cannot
+// be converted are returned unchanged.
+func DisplayDomain(domain string) string {
+	if !strings.Contains(domain, "xn--") {
+		return domain
+	}
+	base := strings.TrimPrefix(domain, "*.")
+	unicode, err := idna.Display.ToUnicode(base)
+	if err != nil || unicode == base {
+		return domain
+	}
+	return fmt.Sprintf("%s%s (%s)", domain[:len(domain)-len(base)], unicode, domain)
+}
+
+// DisplayDomains formats a domain list with DisplayDomain
+func DisplayDomains(domains []string) string {
+	display := make([]string, len(domains))
+	for i, d := range domains {
+		display[i] = DisplayDomain(d)
+	}
+	return "[" + strings.Join(display, " ") + "]"
+}
+
+// isASCII reports whether s contains only ASCII characters
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		domain  string
+		want    string
+		wantErr bool
+	}{
+		{"example.com", "example.com", false},
+		{"bücher.example", "xn--bcher-kva.example", false},
+		{"*.Bücher.example", "*.xn--bcher-kva.example", false},
+		{"münchen.de", "xn--mnchen-3ya.de", false},
+		{"a‍b.example", "", true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeDomain(tt.domain)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("NormalizeDomain(%q) = %q, %v, want %q", tt.domain, got, err, tt.want)
+		}
+	}
+}
+
+func TestDisplayDomain(t *testing.T) {
+	if got := DisplayDomain("*.xn--bcher-kva.example"); got != "*.bücher.example (*.xn--bcher-kva.example)" {
+		t.Errorf("Unexpected display form %q", got)
+	}
+	if got := DisplayDomain("example.com"); got != "example.com" {
+		t.Errorf("Expected ASCII domains unchanged, got %q", got)
+	}
+}
+
+func TestParseCertArg_IDN(t *testing.T) {
+	name, domains, _, err := ParseCertArg("shop@bücher.example,*.bücher.example")
+	if err != nil {
+		t.Fatalf("ParseCertArg failed: %v", err)
+	}
+	if name != "shop" || len(domains) != 2 || domains[0] != "xn--bcher-kva.example" || domains[1] != "*.xn--bcher-kva.example" {
+		t.Errorf("Unexpected result %q %v", name, domains)
+	}
+
+	name, domains, _, err = ParseCertArg("bücher.example")
+	if err != nil || name != "xn--bcher-kva.example" || domains[0] != "xn--bcher-kva.example" {
+		t.Errorf("Unexpected result %q %v %v", name, domains, err)
+	}
+}
+
+func TestLoadConfig_IDNAutoDomains(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+auto_domains:
+  grace_days: 30
+  certs:
+    shop:
+      domains: ["bücher.example", "www.bücher.example"]
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	domains := cfg.AutoDomains.Certs["shop"].Domains
+	if domains[0] != "xn--bcher-kva.example" || domains[1] != "www.xn--bcher-kva.example" {
+		t.Errorf("Expected punycode domains, got %v", domains)
+	}
+}
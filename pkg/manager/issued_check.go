@@ -0,0 +1,102 @@
+package manager
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+)
+
+// validateIssuedCertificate checks a certificate returned by the ACME server
+// before it is written: the leaf must cover all requested domains, still be
+// valid, belong to the private key (or the CSR for external keys) and be
+// signed along the returned chain up to the issuer. A broken bundle is
+// reported as ErrorTypeACME error and nothing is saved.
+func validateIssuedCertificate(certName string, resource *certificate.Resource, domains []string, now time.Time) error {
+	fail := func(message string) error {
+		return common.NewACMEError("validate issued certificate", message).
+			AddContext("certificate", certName).
+			AddSuggestion("Nothing was saved, the previous certificate files are unchanged")
+	}
+
+	chain, err := certcrypto.ParsePEMBundle(resource.Certificate)
+	if err != nil {
+		return fail(fmt.Sprintf("cannot parse the returned certificate: %v", err))
+	}
+	leaf := chain[0]
+
+	names := make(map[string]bool, len(leaf.DNSNames))
+	for _, name := range leaf.DNSNames {
+		names[strings.ToLower(name)] = true
+	}
+	var missing []string
+	for _, d := range domains {
+		if !names[strings.ToLower(d)] {
+			missing = append(missing, d)
+		}
+	}
+	if len(missing) > 0 {
+		return fail(fmt.Sprintf("certificate does not cover %s", DisplayDomains(missing)))
+	}
+
+	if !now.Before(leaf.NotAfter) {
+		return fail(fmt.Sprintf("certificate expired at %s", leaf.NotAfter.Format(time.RFC3339)))
+	}
+
+	if err := checkIssuedKey(leaf, resource); err != nil {
+		return fail(err.Error())
+	}
+
+	// Each certificate must be signed by the next one, the last by the issuer
+	if len(resource.IssuerCertificate) > 0 {
+		issuers, err := certcrypto.ParsePEMBundle(resource.IssuerCertificate)
+		if err != nil {
+			return fail(fmt.Sprintf("cannot parse the issuer certificate: %v", err))
+		}
+		if last := chain[len(chain)-1]; !last.Equal(issuers[0]) {
+			chain = append(chain, issuers[0])
+		}
+	}
+	if len(chain) < 2 {
+		return fail("no issuer certificate was returned with the certificate")
+	}
+	for i := 0; i < len(chain)-1; i++ {
+		if err := chain[i].CheckSignatureFrom(chain[i+1]); err != nil {
+			return fail(fmt.Sprintf("chain does not build: %q is not signed by %q: %v", chain[i].Subject.CommonName, chain[i+1].Subject.CommonName, err))
+		}
+	}
+	return nil
+}
+
+// checkIssuedKey checks that the leaf certificate belongs to the private key
+// of the resource, or to the public key of the CSR if there is no private key
+func checkIssuedKey(leaf *x509.Certificate, resource *certificate.Resource) error {
+	if len(resource.PrivateKey) > 0 {
+		match, err := certinfo.KeyMatches(leaf, resource.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("cannot compare the private key: %w", err)
+		}
+		if !match {
+			return fmt.Errorf("private key does not belong to the certificate")
+		}
+		return nil
+	}
+	if len(resource.CSR) == 0 {
+		return fmt.Errorf("neither private key nor CSR returned with the certificate")
+	}
+	csr, err := certcrypto.PemDecodeTox509CSR(resource.CSR)
+	if err != nil {
+		return fmt.Errorf("cannot parse the CSR: %w", err)
+	}
+	pub, ok := csr.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(leaf.PublicKey) {
+		return fmt.Errorf("certificate does not belong to the key of the CSR")
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package manager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+)
+
+func TestValidateIssuedCertificate(t *testing.T) {
+	now := time.Now()
+	if err := validateIssuedCertificate("web", createSignedResource(t), []string{"example.com"}, now); err != nil {
+		t.Fatalf("Unexpected error for a valid bundle: %v", err)
+	}
+
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKeyDER, _ := x509.MarshalECPrivateKey(otherKey)
+	otherIssuer := createSignedResource(t).IssuerCertificate
+
+	tests := []struct {
+		name    string
+		domains []string
+		at      time.Time
+		modify  func(r *certificate.Resource)
+		wantErr string
+	}{
+		{"missing domain", []string{"example.com", "www.example.com"}, now, nil, "does not cover [www.example.com]"},
+		{"expired", []string{"example.com"}, now.Add(48 * time.Hour), nil, "expired"},
+		{"wrong key", []string{"example.com"}, now, func(r *certificate.Resource) {
+			r.PrivateKey = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: otherKeyDER})
+		}, "private key does not belong"},
+		{"foreign issuer", []string{"example.com"}, now, func(r *certificate.Resource) {
+			leaf, _ := pem.Decode(r.Certificate)
+			r.Certificate = pem.EncodeToMemory(leaf)
+			r.IssuerCertificate = otherIssuer
+		}, "chain does not build"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resource := createSignedResource(t)
+			if tt.modify != nil {
+				tt.modify(resource)
+			}
+			err := validateIssuedCertificate("web", resource, tt.domains, tt.at)
+			appErr := common.GetApplicationError(err)
+			if appErr == nil || !appErr.IsType(common.ErrorTypeACME) {
+				t.Fatalf("Expected an ACME application error, got %v", err)
+			}
+			if !strings.Contains(appErr.Message, tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %q", tt.wantErr, appErr.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package manager
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+	"os"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+)
+
+// ReuseKeyFor reports whether the named certificate keeps its private key
+// across renewals (reuse_key)
+func (cfg *Config) ReuseKeyFor(certName string) bool {
+	certCfg, ok := cfg.CertConfigFor(certName)
+	return ok && certCfg.ReuseKey
+}
+
+// keyTypeOf returns the key_type name of a private key, or "" if it is none
+// of the supported types
+func keyTypeOf(key crypto.PrivateKey) string {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		switch k.N.BitLen() {
+		case 2048:
+			return "rsa2048"
+		case 3072:
+			return "rsa3072"
+		case 4096:
+			return "rsa4096"
+		}
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return "ec256"
+		case elliptic.P384():
+			return "ec384"
+		}
+	case ed25519.PrivateKey:
+		return KeyTypeEd25519
+	}
+	return ""
+}
+
+// loadReusableKey returns the stored private key of the named certificate for
+// reuse_key. It returns nil if there is no key yet, the key does not have
+// the requested type or was compromised, a new key is generated then and
+// reused from then on.
+func loadReusableKey(cfg *Config, certName, keyType string) (crypto.PrivateKey, error) {
+	if keyCompromised(cfg.CertStoragePath, certName) {
+		DefaultLogger.Warnf("Certificate %s was revoked for key compromise, generating a new key", certName)
+		return nil, nil
+	}
+	keyPEM, keyFile, err := readPrivateKey(cfg, certName)
+	if os.IsNotExist(err) {
+		DefaultLogger.Infof("No private key stored for %s yet, generating one to reuse on renewals", certName)
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading private key file %s: %w", keyFile, err)
+	}
+
+	privateKey, err := certcrypto.ParsePEMPrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key file %s for reuse: %w", keyFile, err)
+	}
+	want := EffectiveKeyType(keyType)
+	if have := keyTypeOf(privateKey); have != want {
+		DefaultLogger.Warnf("Private key of %s is %s but key_type is %s, generating a new key", certName, have, want)
+		return nil, nil
+	}
+	DefaultLogger.Infof("Reusing the private key of %s", certName)
+	return privateKey, nil
+}
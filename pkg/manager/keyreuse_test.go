@@ -0,0 +1,88 @@
+package manager
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+)
+
+func TestLoadReusableKey(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir()}
+
+	// No key yet, a new one is generated
+	key, err := loadReusableKey(cfg, "web", "ec256")
+	if err != nil || key != nil {
+		t.Fatalf("Expected no key before the first issuance, got %v, %v", key, err)
+	}
+
+	stored, err := certcrypto.GeneratePrivateKey(certcrypto.EC256)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	keyFile := filepath.Join(cfg.CertStoragePath, "certificates", "web.key")
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, certcrypto.PEMEncode(stored), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err = loadReusableKey(cfg, "web", "ec256")
+	if err != nil {
+		t.Fatalf("loadReusableKey failed: %v", err)
+	}
+	if ec, ok := key.(*ecdsa.PrivateKey); !ok || !ec.Equal(stored) {
+		t.Errorf("Expected the stored key to be reused")
+	}
+
+	// A changed key_type replaces the key
+	key, err = loadReusableKey(cfg, "web", "rsa2048")
+	if err != nil || key != nil {
+		t.Errorf("Expected a new key after a key_type change, got %v, %v", key, err)
+	}
+}
+
+func TestLoadReusableKey_Ed25519(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir()}
+	_, stored, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := encodePrivateKey(stored)
+	if err != nil {
+		t.Fatalf("encodePrivateKey failed: %v", err)
+	}
+	keyFile := filepath.Join(cfg.CertStoragePath, "certificates", "web.key")
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := loadReusableKey(cfg, "web", KeyTypeEd25519)
+	if err != nil {
+		t.Fatalf("loadReusableKey failed: %v", err)
+	}
+	if ed, ok := key.(ed25519.PrivateKey); !ok || !ed.Equal(stored) {
+		t.Errorf("Expected the stored Ed25519 key to be reused, got %T", key)
+	}
+	if !isValidKeyType(KeyTypeEd25519) || EffectiveKeyType(KeyTypeEd25519) != KeyTypeEd25519 {
+		t.Error("Expected ed25519 to be a valid key_type")
+	}
+}
+
+func TestReuseKeyFor(t *testing.T) {
+	cfg := &Config{AutoDomains: &AutoDomainsConfig{Certs: map[string]CertConfig{
+		"pinned": {Domains: []string{"example.com"}, ReuseKey: true},
+		"plain":  {Domains: []string{"example.org"}},
+	}}}
+	if !cfg.ReuseKeyFor("pinned") || cfg.ReuseKeyFor("plain") || cfg.ReuseKeyFor("manual") {
+		t.Error("Unexpected reuse_key lookup result")
+	}
+}
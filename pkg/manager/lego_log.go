@@ -0,0 +1,103 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	legolog "github.com/go-acme/lego/v4/log"
+)
+
+// legoLogger routes the log output of Lego into DefaultLogger. Lego logs
+// through a standard library logger with "[INFO] " and "[WARN] " prefixes,
+// which would bypass the log level and format of the application.
+type legoLogger struct{}
+
+// legoLogCerts maps the domains of the certificates Lego is working on to
+// their certificate names, so Lego's "[domain] ..." lines can be annotated
+var legoLogCerts = struct {
+	sync.Mutex
+	byDomain map[string]string
+}{byDomain: make(map[string]string)}
+
+// trackLegoLogCert annotates Lego log lines for the domains with the
+// certificate name until the returned function is called
+func trackLegoLogCert(certName string, domains []string) func() {
+	legoLogCerts.Lock()
+	defer legoLogCerts.Unlock()
+	for _, domain := range domains {
+		legoLogCerts.byDomain[strings.ToLower(domain)] = certName
+	}
+	return func() {
+		legoLogCerts.Lock()
+		defer legoLogCerts.Unlock()
+		for _, domain := range domains {
+			if legoLogCerts.byDomain[strings.ToLower(domain)] == certName {
+				delete(legoLogCerts.byDomain, strings.ToLower(domain))
+			}
+		}
+	}
+}
+
+// legoLogCertFor returns the certificate name for a Lego log line, empty if
+// the line names no tracked domain
+func legoLogCertFor(msg string) string {
+	if !strings.HasPrefix(msg, "[") {
+		return ""
+	}
+	end := strings.Index(msg, "]")
+	if end < 0 {
+		return ""
+	}
+	legoLogCerts.Lock()
+	defer legoLogCerts.Unlock()
+	return legoLogCerts.byDomain[strings.ToLower(msg[1:end])]
+}
+
+// log writes one Lego log line at the level given by its prefix. Lines
+// without prefix are progress messages and logged at info level.
+func (legoLogger) log(msg string) {
+	msg = strings.TrimRight(msg, "\n")
+	logf := DefaultLogger.Infof
+	switch {
+	case strings.HasPrefix(msg, "[WARN] "):
+		logf = DefaultLogger.Warnf
+		msg = strings.TrimPrefix(msg, "[WARN] ")
+	case strings.HasPrefix(msg, "[INFO] "):
+		msg = strings.TrimPrefix(msg, "[INFO] ")
+	}
+	if certName := legoLogCertFor(msg); certName != "" {
+		logf("%s: %s", certName, msg)
+		return
+	}
+	logf("%s", msg)
+}
+
+// Print implements legolog.StdLogger
+func (l legoLogger) Print(args ...any) { l.log(fmt.Sprint(args...)) }
+
+// Println implements legolog.StdLogger
+func (l legoLogger) Println(args ...any) { l.log(fmt.Sprintln(args...)) }
+
+// Printf implements legolog.StdLogger
+func (l legoLogger) Printf(format string, args ...any) { l.log(fmt.Sprintf(format, args...)) }
+
+// Fatal implements legolog.StdLogger, it exits like log.Fatal
+func (l legoLogger) Fatal(args ...any) { l.fatal(fmt.Sprint(args...)) }
+
+// Fatalln implements legolog.StdLogger, it exits like log.Fatalln
+func (l legoLogger) Fatalln(args ...any) { l.fatal(fmt.Sprintln(args...)) }
+
+// Fatalf implements legolog.StdLogger, it exits like log.Fatalf
+func (l legoLogger) Fatalf(format string, args ...any) { l.fatal(fmt.Sprintf(format, args...)) }
+
+func (legoLogger) fatal(msg string) {
+	DefaultLogger.Errorf("%s", strings.TrimRight(msg, "\n"))
+	os.Exit(1)
+}
+
+// routeLegoLog makes Lego log through DefaultLogger
+func routeLegoLog() {
+	legolog.Logger = legoLogger{}
+}
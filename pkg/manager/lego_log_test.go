@@ -0,0 +1,46 @@
+package manager
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	legolog "github.com/go-acme/lego/v4/log"
+)
+
+func TestLegoLogger(t *testing.T) {
+	oldLogger, oldLegoLogger := DefaultLogger, legolog.Logger
+	defer func() { DefaultLogger, legolog.Logger = oldLogger, oldLegoLogger }()
+
+	var buf bytes.Buffer
+	DefaultLogger = NewColorfulLogger(&buf, LogLevelInfo, false, false)
+	routeLegoLog()
+
+	untrack := trackLegoLogCert("web", []string{"www.example.com", "*.Example.com"})
+	legolog.Infof("[%s] acme: Obtaining bundled SAN certificate", "www.example.com")
+	legolog.Warnf("[%s] acme: error cleaning up", "*.example.com")
+	legolog.Infof("[%s] acme: Trying to solve DNS-01", "other.example.org")
+	legolog.Println("Wait for propagation")
+	untrack()
+	legolog.Infof("[%s] acme: Validations succeeded", "www.example.com")
+
+	want := []string{
+		"INFO web: [www.example.com] acme: Obtaining bundled SAN certificate",
+		"WARN web: [*.example.com] acme: error cleaning up",
+		"INFO [other.example.org] acme: Trying to solve DNS-01",
+		"INFO Wait for propagation",
+		"INFO [www.example.com] acme: Validations succeeded",
+	}
+	if got := strings.Split(strings.TrimSpace(buf.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected log output:\n%s", buf.String())
+	}
+
+	// Quiet mode hides Lego's progress but keeps its warnings
+	buf.Reset()
+	DefaultLogger = NewColorfulLogger(&buf, LogLevelQuiet, false, false)
+	legolog.Infof("[%s] acme: Obtaining bundled SAN certificate", "www.example.com")
+	legolog.Warnf("[%s] acme: error cleaning up", "www.example.com")
+	if got := strings.TrimSpace(buf.String()); got != "WARN [www.example.com] acme: error cleaning up" {
+		t.Errorf("Expected only the warning in quiet mode, got:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,128 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/providers/dns/cloudflare"
+	"github.com/go-acme/lego/v4/providers/dns/cloudns"
+	"github.com/go-acme/lego/v4/providers/dns/dnsmadeeasy"
+	"github.com/go-acme/lego/v4/providers/dns/duckdns"
+	"github.com/go-acme/lego/v4/providers/dns/dynu"
+	"github.com/go-acme/lego/v4/providers/dns/gandiv5"
+	"github.com/go-acme/lego/v4/providers/dns/godaddy"
+	"github.com/go-acme/lego/v4/providers/dns/hetzner"
+	"github.com/go-acme/lego/v4/providers/dns/hostingde"
+	"github.com/go-acme/lego/v4/providers/dns/httpreq"
+	"github.com/go-acme/lego/v4/providers/dns/luadns"
+	"github.com/go-acme/lego/v4/providers/dns/namecheap"
+	"github.com/go-acme/lego/v4/providers/dns/netcup"
+	"github.com/go-acme/lego/v4/providers/dns/pdns"
+	"github.com/go-acme/lego/v4/providers/dns/rfc2136"
+	"github.com/go-acme/lego/v4/providers/dns/selectel"
+)
+
+// DNSChallengeProviderAcmeDNS is the default DNS challenge provider
+const DNSChallengeProviderAcmeDNS = "acmedns"
+
+// legoDNSProviders are the lego DNS providers selectable with
+// dns_challenge_provider. Each reads its settings from the environment
+// variables documented by lego, which dns_challenge_credentials can set.
+var legoDNSProviders = map[string]func() (challenge.Provider, error){
+	"cloudflare":  func() (challenge.Provider, error) { return cloudflare.NewDNSProvider() },
+	"cloudns":     func() (challenge.Provider, error) { return cloudns.NewDNSProvider() },
+	"dnsmadeeasy": func() (challenge.Provider, error) { return dnsmadeeasy.NewDNSProvider() },
+	"duckdns":     func() (challenge.Provider, error) { return duckdns.NewDNSProvider() },
+	"dynu":        func() (challenge.Provider, error) { return dynu.NewDNSProvider() },
+	"gandiv5":     func() (challenge.Provider, error) { return gandiv5.NewDNSProvider() },
+	"godaddy":     func() (challenge.Provider, error) { return godaddy.NewDNSProvider() },
+	"hetzner":     func() (challenge.Provider, error) { return hetzner.NewDNSProvider() },
+	"hostingde":   func() (challenge.Provider, error) { return hostingde.NewDNSProvider() },
+	"httpreq":     func() (challenge.Provider, error) { return httpreq.NewDNSProvider() },
+	"luadns":      func() (challenge.Provider, error) { return luadns.NewDNSProvider() },
+	"namecheap":   func() (challenge.Provider, error) { return namecheap.NewDNSProvider() },
+	"netcup":      func() (challenge.Provider, error) { return netcup.NewDNSProvider() },
+	"pdns":        func() (challenge.Provider, error) { return pdns.NewDNSProvider() },
+	"rfc2136":     func() (challenge.Provider, error) { return rfc2136.NewDNSProvider() },
+	"selectel":    func() (challenge.Provider, error) { return selectel.NewDNSProvider() },
+}
+
+// UsesAcmeDNS reports whether DNS-01 challenges are answered through acme-dns,
+// which needs acme-dns accounts and _acme-challenge CNAME records
+func (c *Config) UsesAcmeDNS() bool {
+	return c.DNSChallengeProvider == "" || c.DNSChallengeProvider == DNSChallengeProviderAcmeDNS
+}
+
+// SupportedDNSChallengeProviders returns the names accepted by
+// dns_challenge_provider, sorted
+func SupportedDNSChallengeProviders() []string {
+	names := []string{DNSChallengeProviderAcmeDNS, DNSChallengeProviderExec}
+	for name := range legoDNSProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateDNSChallengeProvider checks dns_challenge_provider and its credentials
+func validateDNSChallengeProvider(cfg *Config) error {
+	if cfg.UsesAcmeDNS() {
+		if cfg.AcmeDnsServer == "" {
+			return fmt.Errorf("acme_dns_server is required with the acme-dns challenge provider")
+		}
+		if len(cfg.DNSChallengeCredentials) > 0 {
+			return fmt.Errorf("dns_challenge_credentials is only used with a lego dns_challenge_provider")
+		}
+		if cfg.DNSChallengeExec != nil {
+			return fmt.Errorf("dns_challenge_exec is only used with the exec dns_challenge_provider")
+		}
+		return nil
+	}
+	if cfg.DNSChallengeProvider == DNSChallengeProviderExec {
+		if len(cfg.DNSChallengeCredentials) > 0 {
+			return fmt.Errorf("dns_challenge_credentials is only used with a lego dns_challenge_provider")
+		}
+		_, err := newExecProvider(cfg.DNSChallengeExec)
+		return err
+	}
+	if cfg.DNSChallengeExec != nil {
+		return fmt.Errorf("dns_challenge_exec is only used with the exec dns_challenge_provider")
+	}
+	if _, ok := legoDNSProviders[cfg.DNSChallengeProvider]; !ok {
+		return fmt.Errorf("unknown dns_challenge_provider %q, supported: %s", cfg.DNSChallengeProvider, strings.Join(SupportedDNSChallengeProviders(), ", "))
+	}
+	return nil
+}
+
+// newLegoDNSProvider creates the configured lego DNS provider. The
+// credentials are exported to the environment first, where lego providers
+// read their settings from.
+func newLegoDNSProvider(cfg *Config) (challenge.Provider, error) {
+	newProvider, ok := legoDNSProviders[cfg.DNSChallengeProvider]
+	if !ok {
+		return nil, fmt.Errorf("unknown dns_challenge_provider %q", cfg.DNSChallengeProvider)
+	}
+
+	names := make([]string, 0, len(cfg.DNSChallengeCredentials))
+	for name := range cfg.DNSChallengeCredentials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := os.Setenv(name, cfg.DNSChallengeCredentials[name]); err != nil {
+			return nil, fmt.Errorf("failed to set %s env var: %w", name, err)
+		}
+	}
+	if len(names) > 0 {
+		DefaultLogger.Debugf("Set %s for the %s DNS provider", strings.Join(names, ", "), cfg.DNSChallengeProvider)
+	}
+
+	provider, err := newProvider()
+	if err != nil {
+		return nil, fmt.Errorf("%s DNS provider: %w", cfg.DNSChallengeProvider, err)
+	}
+	return provider, nil
+}
@@ -0,0 +1,78 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateDNSChallengeProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{"default acme-dns", Config{AcmeDnsServer: "https://acme-dns.example.com"}, ""},
+		{"explicit acme-dns", Config{AcmeDnsServer: "https://acme-dns.example.com", DNSChallengeProvider: "acmedns"}, ""},
+		{"acme-dns without server", Config{}, "acme_dns_server is required"},
+		{"acme-dns with credentials", Config{AcmeDnsServer: "https://acme-dns.example.com", DNSChallengeCredentials: map[string]string{"X": "y"}}, "dns_challenge_credentials"},
+		{"lego provider", Config{DNSChallengeProvider: "cloudflare"}, ""},
+		{"unknown provider", Config{DNSChallengeProvider: "nope"}, "supported: acmedns, cloudflare"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateDNSChallengeProvider(&tt.cfg)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNewLegoDNSProvider(t *testing.T) {
+	t.Setenv("RFC2136_NAMESERVER", "")
+	cfg := &Config{DNSChallengeProvider: "rfc2136"}
+	if _, err := newLegoDNSProvider(cfg); err == nil || !strings.Contains(err.Error(), "rfc2136 DNS provider") {
+		t.Errorf("Expected an error without the nameserver setting, got %v", err)
+	}
+
+	cfg.DNSChallengeCredentials = map[string]string{"RFC2136_NAMESERVER": "127.0.0.1:53"}
+	provider, err := newLegoDNSProvider(cfg)
+	if err != nil {
+		t.Fatalf("newLegoDNSProvider failed: %v", err)
+	}
+	if provider == nil || os.Getenv("RFC2136_NAMESERVER") != "127.0.0.1:53" {
+		t.Errorf("Expected the provider to be configured from the credentials")
+	}
+}
+
+func TestPreCheckAcmeDNS_LegoProvider(t *testing.T) {
+	store, err := NewAccountStore(filepath.Join(t.TempDir(), "accounts.json"))
+	if err != nil {
+		t.Fatalf("Failed to create account store: %v", err)
+	}
+
+	// Neither accounts nor CNAME records are needed
+	cfg := &Config{DNSChallengeProvider: "cloudflare"}
+	setupInfo, err := PreCheckAcmeDNSWithResolver(cfg, store, []string{"example.com"}, staticResolver{})
+	if err != nil || setupInfo != nil {
+		t.Errorf("Expected the pre-check to be skipped, got %v, %v", setupInfo, err)
+	}
+	if len(store.GetAllAccounts()) != 0 {
+		t.Errorf("Expected no acme-dns account to be registered")
+	}
+}
+
+func TestValidateConfig_LegoProviderWithoutAcmeDNS(t *testing.T) {
+	base := "email: \"test@example.com\"\nacme_server: \"https://acme-staging-v02.api.letsencrypt.org/directory\"\n"
+	if err := validateConfig([]byte(base + "dns_challenge_provider: cloudflare\n")); err != nil {
+		t.Errorf("Expected acme_dns_server to be optional with a lego provider, got %v", err)
+	}
+	if err := validateConfig([]byte(base + "dns_challenge_provider: acmedns\n")); err == nil {
+		t.Error("Expected acme_dns_server to be required with the acmedns provider")
+	}
+}
@@ -2,15 +2,14 @@ package manager
 
 import (
 	"context"
-	"crypto/x509"
-	"encoding/pem"
+	"crypto"
 	"errors"
 	"fmt"
-	"net"
+	"io"
 	"net/http"
 	"os"
-	"path/filepath"
-	"strings"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/go-acme/lego/v4/certcrypto"
@@ -20,6 +19,8 @@ import (
 	"github.com/go-acme/lego/v4/lego"
 	"github.com/go-acme/lego/v4/providers/dns/acmedns"
 	"github.com/go-acme/lego/v4/registration"
+	"github.com/nrdcg/goacmedns"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
 )
 
 // ErrDNSSetupNeeded is returned when DNS configuration is required.
@@ -30,11 +31,16 @@ var ErrDNSSetupNeeded = errors.New("DNS configuration needed")
 // and performs the type assertion internally. This allows external packages to call RunLego
 // without needing to import the unexported accountStore type.
 func RunLegoWithStore(cfg *Config, store interface{}, action string, certName string, domainsToProcess []string, keyType string) error {
+	return RunLegoWithStoreContext(context.Background(), cfg, store, action, certName, domainsToProcess, keyType)
+}
+
+// RunLegoWithStoreContext is RunLegoWithStore with a context, see RunLegoContext
+func RunLegoWithStoreContext(ctx context.Context, cfg *Config, store interface{}, action string, certName string, domainsToProcess []string, keyType string) error {
 	accountStore, ok := store.(*accountStore)
 	if !ok {
 		return fmt.Errorf("invalid store type: expected *accountStore, got %T", store)
 	}
-	return RunLego(cfg, accountStore, action, certName, domainsToProcess, keyType)
+	return RunLegoContext(ctx, cfg, accountStore, action, certName, domainsToProcess, keyType)
 }
 
 // PreCheckAcmeDNSWithStore is a wrapper function that accepts interface{} for the store parameter
@@ -58,74 +64,144 @@ func PreCheckAcmeDNSWithStoreAndResolver(cfg *Config, store interface{}, domains
 	return PreCheckAcmeDNSWithResolver(cfg, accountStore, domains, resolver)
 }
 
+// PreCheckAcmeDNSWithStoreContext is PreCheckAcmeDNSWithStoreAndResolver with a
+// context that cancels acme-dns registrations and DNS lookups. A nil resolver
+// selects the pre-check resolver of the configuration.
+func PreCheckAcmeDNSWithStoreContext(ctx context.Context, cfg *Config, store interface{}, domains []string, resolver DNSResolver) ([]DNSSetupInfo, error) {
+	accountStore, ok := store.(*accountStore)
+	if !ok {
+		return nil, fmt.Errorf("invalid store type: expected *accountStore, got %T", store)
+	}
+	if resolver == nil {
+		resolver = NewPrecheckResolver(cfg)
+	}
+	return preCheckAcmeDNS(ctx, cfg, accountStore, domains, resolver)
+}
+
 // PreCheckAcmeDNSWithResolver is a version that allows injection of a DNS resolver for testing
 func PreCheckAcmeDNSWithResolver(cfg *Config, store *accountStore, domains []string, resolver DNSResolver) ([]DNSSetupInfo, error) {
+	return preCheckAcmeDNS(context.Background(), cfg, store, domains, resolver)
+}
+
+// preCheckAcmeDNS registers missing acme-dns accounts and checks the CNAME
+// records, counting failed checks in the stats of cfg
+func preCheckAcmeDNS(ctx context.Context, cfg *Config, store *accountStore, domains []string, resolver DNSResolver) ([]DNSSetupInfo, error) {
+	setupInfo, err := checkAcmeDNS(ctx, cfg, store, domains, resolver)
+	if err != nil {
+		cfg.stats().Count("dns_precheck.failures", 1, "reason:error")
+	} else if len(setupInfo) > 0 {
+		cfg.stats().Count("dns_precheck.failures", int64(len(setupInfo)), "reason:missing_record")
+	}
+	return setupInfo, err
+}
+
+// checkAcmeDNS registers missing acme-dns accounts and checks the CNAME records
+func checkAcmeDNS(ctx context.Context, cfg *Config, store *accountStore, domains []string, resolver DNSResolver) ([]DNSSetupInfo, error) {
+	// Other DNS providers answer challenges in the zone itself, no CNAME is needed
+	if !cfg.UsesAcmeDNS() {
+		return nil, nil
+	}
+
 	// Use a map to avoid duplicate CNAME instructions
 	cnameMap := make(map[string]string)
 
 	// First pass: Register any missing ACME-DNS accounts
 	for _, domain := range domains {
-		baseDomain := GetBaseDomain(domain)
-		_, exists := store.GetAccount(baseDomain)
-		if !exists {
-			// Try wildcard version
-			wildcardDomain := "*." + baseDomain
-			_, exists = store.GetAccount(wildcardDomain)
-			if !exists {
-				// No account exists, register a new one with acme-dns
-				DefaultLogger.Infof("No ACME-DNS account found for domain %s, registering new account...", domain)
-				newAccount, err := RegisterNewAccount(cfg, store, domain)
-				if err != nil {
-					return nil, fmt.Errorf("failed to register ACME-DNS account for domain %s: %w", domain, err)
-				}
-
-				// Save the updated account store immediately
+		// Base domain and wildcard share an account, with account_granularity
+		// base_domain all names of the registrable domain do
+		if _, _, exists := cfg.LookupAcmeDNSAccount(store, domain); !exists {
+			// A store loaded before the registration of another certificate
+			// of this run gets that account instead of a second one
+			key := cfg.AcmeDNSAccountKey(domain)
+			if account, ok := cfg.DNSCache.registeredAccount(key); ok {
+				DefaultLogger.Infof("Using the acme-dns account registered earlier in this run for domain %s", domain)
+				store.SetAccount(key, account)
 				if err := store.SaveAccounts(); err != nil {
 					return nil, fmt.Errorf("failed to save ACME-DNS accounts: %w", err)
 				}
+				continue
+			}
 
-				challengeDomain := "_acme-challenge." + GetBaseDomain(domain)
-				cnameMap[challengeDomain] = newAccount.FullDomain
+			// No account exists, register a new one with acme-dns
+			DefaultLogger.Infof("No ACME-DNS account found for domain %s, registering new account...", domain)
+			newAccount, err := RegisterNewAccountContext(ctx, cfg, store, key, DefaultLogger, cfg.acmeDNSHTTPClient())
+			if err != nil {
+				return nil, fmt.Errorf("failed to register ACME-DNS account for domain %s: %w", domain, err)
 			}
+			cfg.DNSCache.addRegistered(key, *newAccount)
+
+			// Save the updated account store immediately
+			if err := store.SaveAccounts(); err != nil {
+				return nil, fmt.Errorf("failed to save ACME-DNS accounts: %w", err)
+			}
+
+			challengeDomain := "_acme-challenge." + GetBaseDomain(domain)
+			target := cfg.ExpectedCNAMETarget(domain, newAccount.FullDomain)
+			if alias := cfg.ChallengeAliasFor(domain); alias != "" {
+				cnameMap[alias] = target
+				target = alias
+			}
+			cnameMap[challengeDomain] = target
 		}
 	}
 
-	// Second pass: Check CNAME records for all domains using provided resolver
+	// Second pass: Check CNAME records for all domains using provided
+	// resolver. A record shared by several domains, like that of a base
+	// domain and its wildcard, is looked up once.
+	var checks []*cnameCheck
+	queued := make(map[string]bool)
+	addCheck := func(check *cnameCheck) {
+		if key := check.name + " " + check.target; !queued[key] {
+			queued[key] = true
+			checks = append(checks, check)
+		}
+	}
 	for _, domain := range domains {
-		baseDomain := GetBaseDomain(domain)
-		account, exists := store.GetAccount(baseDomain)
+		account, _, exists := cfg.LookupAcmeDNSAccount(store, domain)
 		if !exists {
-			// Try wildcard version
-			wildcardDomain := "*." + baseDomain
-			account, exists = store.GetAccount(wildcardDomain)
+			continue
 		}
+		challengeDomain := "_acme-challenge." + GetBaseDomain(domain)
+		expectedTarget := cfg.ExpectedCNAMETarget(domain, account.FullDomain)
+
+		// With a challenge alias the alias record points to acme-dns
+		// and the challenge record to the alias
+		if alias := cfg.ChallengeAliasFor(domain); alias != "" {
+			addCheck(&cnameCheck{domain: domain, name: alias, target: expectedTarget, alias: true})
+			expectedTarget = alias
+		}
+		addCheck(&cnameCheck{domain: domain, name: challengeDomain, target: expectedTarget, authoritative: cfg.ChecksAuthoritative()})
+	}
+	runCNAMEChecks(ctx, cfg, resolver, checks)
 
-		if exists {
-			// Check CNAME silently (no logging)
-			challengeDomain := "_acme-challenge." + GetBaseDomain(domain)
-			expectedTarget := strings.TrimSuffix(account.FullDomain, ".")
-
-			isValid, err := VerifyWithResolver(resolver, challengeDomain, expectedTarget)
-			if err != nil {
-				return nil, fmt.Errorf("DNS verification failed for %s: %w", domain, err)
-			}
-
-			if !isValid {
-				// Add to map (automatically handles duplicates)
-				cnameMap[challengeDomain] = account.FullDomain
+	missingOn := make(map[string][]string) // Challenge domain -> nameservers without the record
+	for _, check := range checks {
+		if check.err != nil {
+			return nil, check.err
+		}
+		if !check.valid {
+			// Add to map (automatically handles duplicates)
+			cnameMap[check.name] = check.target
+			if len(check.missing) > 0 {
+				missingOn[check.name] = check.missing
 			}
 		}
 	}
 
-	// Convert map to slice of DNSSetupInfo if any setup is needed
+	// Convert map to slice of DNSSetupInfo if any setup is needed, sorted so
+	// every run reports the records in the same order
 	if len(cnameMap) > 0 {
 		var setupInfo []DNSSetupInfo
 		for challenge, target := range cnameMap {
 			setupInfo = append(setupInfo, DNSSetupInfo{
 				ChallengeDomain: challenge,
 				TargetDomain:    target,
+				MissingOn:       missingOn[challenge],
 			})
 		}
+		sort.Slice(setupInfo, func(i, j int) bool {
+			return setupInfo[i].ChallengeDomain < setupInfo[j].ChallengeDomain
+		})
 		return setupInfo, nil
 	}
 
@@ -136,318 +212,214 @@ func PreCheckAcmeDNSWithResolver(cfg *Config, store *accountStore, domains []str
 type DNSSetupInfo struct {
 	ChallengeDomain string
 	TargetDomain    string
+	MissingOn       []string // Authoritative nameservers still missing the record
 }
 
 // PreCheckAcmeDNS ensures all domains have ACME-DNS accounts and valid CNAME records
 // Returns DNS setup information if setup is needed, nil if all domains are ready
 func PreCheckAcmeDNS(cfg *Config, store *accountStore, domains []string) ([]DNSSetupInfo, error) {
-	// Use a map to avoid duplicate CNAME instructions
-	cnameMap := make(map[string]string)
-
-	// First pass: Register any missing ACME-DNS accounts
-	for _, domain := range domains {
-		baseDomain := GetBaseDomain(domain)
-		_, exists := store.GetAccount(baseDomain)
-		if !exists {
-			// Try wildcard version
-			wildcardDomain := "*." + baseDomain
-			_, exists = store.GetAccount(wildcardDomain)
-			if !exists {
-				// No account exists, register a new one with acme-dns
-				DefaultLogger.Infof("No ACME-DNS account found for domain %s, registering new account...", domain)
-				newAccount, err := RegisterNewAccount(cfg, store, domain)
-				if err != nil {
-					return nil, fmt.Errorf("failed to register ACME-DNS account for domain %s: %w", domain, err)
-				}
-
-				// Save the updated account store immediately
-				if err := store.SaveAccounts(); err != nil {
-					return nil, fmt.Errorf("failed to save ACME-DNS accounts: %w", err)
-				}
-
-				challengeDomain := "_acme-challenge." + GetBaseDomain(domain)
-				cnameMap[challengeDomain] = newAccount.FullDomain
-			}
-		}
-	}
-
-	// Second pass: Check CNAME records for all domains
-	for _, domain := range domains {
-		baseDomain := GetBaseDomain(domain)
-		account, exists := store.GetAccount(baseDomain)
-		if !exists {
-			// Try wildcard version
-			wildcardDomain := "*." + baseDomain
-			account, exists = store.GetAccount(wildcardDomain)
-		}
-
-		if exists {
-			// Create resolver based on config
-			var resolver DNSResolver
-			if cfg.DnsResolver != "" {
-				// Use custom DNS resolver with specified IP
-				nsAddr := cfg.DnsResolver
-				if !strings.Contains(nsAddr, ":") {
-					nsAddr += ":53"
-				}
-				resolver = &DefaultDNSResolver{
-					Resolver: &net.Resolver{
-						PreferGo: true,
-						Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-							d := net.Dialer{
-								Timeout: time.Second * 10,
-							}
-							return d.DialContext(ctx, network, nsAddr)
-						},
-					},
-				}
-			} else {
-				// Use system default resolver
-				resolver = &DefaultDNSResolver{
-					Resolver: net.DefaultResolver,
-				}
-			}
-
-			// Check CNAME silently (no logging)
-			challengeDomain := "_acme-challenge." + GetBaseDomain(domain)
-			expectedTarget := strings.TrimSuffix(account.FullDomain, ".")
-
-			isValid, err := VerifyWithResolver(resolver, challengeDomain, expectedTarget)
-			if err != nil {
-				return nil, fmt.Errorf("DNS verification failed for %s: %w", domain, err)
-			}
-
-			if !isValid {
-				// Add to map (automatically handles duplicates)
-				cnameMap[challengeDomain] = account.FullDomain
-			}
-		}
-	}
-
-	// Convert map to slice of DNSSetupInfo if any setup is needed
-	if len(cnameMap) > 0 {
-		var setupInfo []DNSSetupInfo
-		for challenge, target := range cnameMap {
-			setupInfo = append(setupInfo, DNSSetupInfo{
-				ChallengeDomain: challenge,
-				TargetDomain:    target,
-			})
-		}
-		return setupInfo, nil
-	}
-
-	return nil, nil
+	return PreCheckAcmeDNSWithResolver(cfg, store, domains, NewPrecheckResolver(cfg))
 }
 
-// DisplayDNSInstructions shows DNS setup instructions in a sorted, deduplicated format
+// DisplayDNSInstructions shows DNS setup instructions grouped by DNS zone,
+// sorted and deduplicated, so each zone owner gets their own list
 func DisplayDNSInstructions(setupInfo []DNSSetupInfo) {
-	// Sort by challenge domain for consistent output
-	sortedInfo := make([]DNSSetupInfo, len(setupInfo))
-	copy(sortedInfo, setupInfo)
-
-	// Simple bubble sort for clarity (small lists)
-	for i := 0; i < len(sortedInfo)-1; i++ {
-		for j := 0; j < len(sortedInfo)-i-1; j++ {
-			if sortedInfo[j].ChallengeDomain > sortedInfo[j+1].ChallengeDomain {
-				sortedInfo[j], sortedInfo[j+1] = sortedInfo[j+1], sortedInfo[j]
-			}
-		}
-	}
+	zones := GroupDNSSetupByZone(setupInfo)
 
 	// Use Warn level so it shows even in quiet mode (these are required actions)
 	DefaultLogger.Warn("")
 	DefaultLogger.Warn("===== REQUIRED DNS CHANGES =====")
-	DefaultLogger.Warn("Add the following CNAME record(s) to your DNS:")
-	DefaultLogger.Warn("")
-	for _, info := range sortedInfo {
-		DefaultLogger.Warnf("    %s. IN CNAME %s.", info.ChallengeDomain, info.TargetDomain)
+	DefaultLogger.Warnf("Add the following %d CNAME record(s) in %d zone(s) to your DNS:", len(setupInfo), len(zones))
+	for _, zone := range zones {
+		DefaultLogger.Warn("")
+		DefaultLogger.Warnf("; Zone %s, %d record(s)", zone.Zone, len(zone.Records))
+		for _, line := range zoneFileLines(zone) {
+			DefaultLogger.Warnf("    %s", line)
+		}
 	}
 	DefaultLogger.Warn("")
 	DefaultLogger.Warn("=================================")
 	DefaultLogger.Warn("")
 }
 
-
 // RunLego performs the certificate obtain or renew operation.
 // Accepts config, account store, action, the certificate name, the domains list, and optional key type.
 // Exported function
 func RunLego(cfg *Config, store *accountStore, action string, certName string, domainsToProcess []string, keyType string) error {
+	return RunLegoContext(context.Background(), cfg, store, action, certName, domainsToProcess, keyType)
+}
+
+// RunLegoContext is RunLego with a context. Lego itself takes no context, so
+// the context is attached to every HTTP request of the Lego client and of the
+// acme-dns DNS-01 provider, and it ends the DNS propagation wait; canceling it
+// aborts the ACME exchange at the next request.
+func RunLegoContext(ctx context.Context, cfg *Config, store *accountStore, action string, certName string, domainsToProcess []string, keyType string) (err error) {
 	// Validate domainsToProcess ische not empty (should be caught by main, but good practice)
 	if len(domainsToProcess) == 0 {
 		return fmt.Errorf("RunLego called with empty domains list")
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	defer trackLegoLogCert(certName, domainsToProcess)()
+
+	// Certificates may be issued by another CA than the global acme_server
+	if certCfg := cfg.ForCert(certName); certCfg != cfg {
+		DefaultLogger.Infof("Using ACME server %s for certificate %s", certCfg.AcmeServer, certName)
+		cfg = certCfg
+	}
+
+	// Rate limits and other transient refusals are retried per the retry policy,
+	// the recorder makes Retry-After headers of the ACME server visible to it
+	policy := cfg.RetryPolicy()
+	recorder := newRetryAfterRecorder(nil)
 
 	// Pre-check ACME-DNS setup for all domains BEFORE initializing Lego
 	// This needs to happen for both init AND renew, because renewal might add new domains
 	if action == "init" || action == "renew" {
-		setupInfo, err := PreCheckAcmeDNS(cfg, store, domainsToProcess)
+		// Serialized so parallel runs never register two acme-dns accounts for the same domain
+		legoSetupMu.Lock()
+		setupInfo, err := preCheckAcmeDNS(ctx, cfg, store, domainsToProcess, NewPrecheckResolver(cfg))
+		legoSetupMu.Unlock()
 		if err != nil {
 			return err
 		}
 		if setupInfo != nil {
-			// DNS setup is needed, display instructions and return
-			DisplayDNSInstructions(setupInfo)
-			return ErrDNSSetupNeeded
+			// DNS setup is needed, let configured providers create the records and
+			// display instructions for the rest. Provider calls carry their own timeouts.
+			if err := HandleDNSSetup(ctx, cfg, setupInfo); err != nil {
+				return err
+			}
 		}
 	}
 
-	DefaultLogger.Info("Initializing Lego client...")
-
-	user, userErr := createOrLoadUser(cfg)
-	if userErr != nil {
-		return fmt.Errorf("failed to create/load ACME user: %w", userErr)
-	}
-
-	// Setup Lego config
-	legoConfig := lego.NewConfig(user)
-	legoConfig.CADirURL = cfg.AcmeServer
-
-	// Set key type, using provided value, or fall back to default
-	certKeyType := DefaultKeyType
-	if keyType != "" && isValidKeyType(keyType) {
-		certKeyType = keyType
-		DefaultLogger.Infof("Using specified key type: %s", certKeyType)
-	} else {
-		DefaultLogger.Infof("Using default key type: %s", certKeyType)
+	// Account loading, registration and the provider environment variables are
+	// process-wide state, so client setup is serialized as well. The lock is not
+	// held while waiting for a retry.
+	var client *lego.Client
+	setupErr := withRetry(ctx, policy, "set up ACME client", recorder, func() error {
+		legoSetupMu.Lock()
+		defer legoSetupMu.Unlock()
+		var err error
+		client, err = setupLegoClient(ctx, cfg, store, keyType, recorder)
+		return err
+	})
+	if setupErr != nil {
+		return setupErr
 	}
 
-	// Map our key types to Lego's certcrypto constants
-	var legoKeyType certcrypto.KeyType
-	switch certKeyType {
-	case "rsa2048":
-		legoKeyType = certcrypto.RSA2048
-	case "rsa3072":
-		legoKeyType = certcrypto.RSA3072
-	case "rsa4096":
-		legoKeyType = certcrypto.RSA4096
-	case "ec256":
-		legoKeyType = certcrypto.EC256
-	case "ec384":
-		legoKeyType = certcrypto.EC384
-	default:
-		// Default to RSA2048 if we don't have a mapping (shouldn't happen due to validation)
-		legoKeyType = certcrypto.RSA2048
-	}
-
-	legoConfig.Certificate.KeyType = legoKeyType
-	// Use timeouts from config
-	legoConfig.Certificate.Timeout = cfg.ChallengeTimeout
-	if legoConfig.HTTPClient == nil {
-		legoConfig.HTTPClient = &http.Client{}
-	}
-	legoConfig.HTTPClient.Timeout = cfg.HTTPTimeout
-
-	// Create Lego client
-	client, clientErr := lego.NewClient(legoConfig)
-	if clientErr != nil {
-		return fmt.Errorf("failed to create Lego client: %w", clientErr)
+	// The profile is only sent with new orders, check that the CA offers it first
+	profile := cfg.ProfileFor(certName)
+	if profile != "" {
+		if err := checkProfileSupported(ctx, cfg, profile); err != nil {
+			return err
+		}
+		DefaultLogger.Infof("Requesting ACME profile %s for certificate %s", profile, certName)
 	}
 
-	// This ensures only DNS-01 is used and prevents Lego from attempting other challenge types
-	client.Challenge.Remove(challenge.HTTP01)
-	client.Challenge.Remove(challenge.TLSALPN01)
-
-	// Setup acme-dns provider
-	// The provider reads ACME_DNS_API_BASE and ACME_DNS_STORAGE_PATH from env vars.
-	DefaultLogger.Info("Configuring ACME DNS provider...")
-
-	// Set the environment variables required by the acme-dns provider
-	DefaultLogger.Infof("Setting ACME_DNS_API_BASE=%s", cfg.AcmeDnsServer)
-	if setErr := os.Setenv("ACME_DNS_API_BASE", cfg.AcmeDnsServer); setErr != nil {
-		return fmt.Errorf("failed to set ACME_DNS_API_BASE env var: %w", setErr)
+	// An order an interrupted run left behind is completed or cleaned up first
+	if action == "init" || action == "renew" {
+		var pendingURL string
+		if pending, _ := LoadPendingOrder(cfg.CertStoragePath, certName); pending != nil {
+			pendingURL = pending.OrderURL
+		}
+		started := time.Now()
+		resumed, err := resumePendingOrder(ctx, cfg, certName, domainsToProcess)
+		if resumed {
+			countIssuance(cfg, action, certName, started, err)
+			recordAudit(cfg, AuditRecord{Action: auditAction(action), CertName: certName, Domains: domainsToProcess, OrderURL: pendingURL}, err)
+		}
+		if err != nil || resumed {
+			return err
+		}
 	}
 
-	// The acmedns provider uses the storage path to read the credentials from the JSON file
-	DefaultLogger.Infof("Setting ACME_DNS_STORAGE_PATH=%s", store.filePath)
-	if setErr := os.Setenv("ACME_DNS_STORAGE_PATH", store.filePath); setErr != nil {
-		return fmt.Errorf("failed to set ACME_DNS_STORAGE_PATH env var: %w", setErr)
+	// Orders the CA would likely refuse are deferred before they count against other limits
+	if action == "init" || action == "renew" {
+		if err := checkRateBudget(cfg, certName, domainsToProcess); err != nil {
+			return err
+		}
+		started := time.Now()
+		defer func() {
+			countIssuance(cfg, action, certName, started, err)
+			recordAudit(cfg, AuditRecord{Action: auditAction(action), CertName: certName, Domains: domainsToProcess, OrderURL: recorder.lastOrder()}, err)
+		}()
 	}
 
-	// Create the provider using our configured environment variables
-	var provider *acmedns.DNSProvider
-	var providerErr error
-	provider, providerErr = acmedns.NewDNSProvider()
-	if providerErr != nil {
-		return fmt.Errorf("failed to create acme-dns provider: %w", providerErr)
+	// Certificates with an external key are ordered for their CSR, init and renew alike
+	if cfg.CSRPathFor(certName) != "" && (action == "init" || action == "renew") {
+		defer trackPendingOrder(cfg, certName, domainsToProcess, nil, recorder)(&err)
+		return obtainForCSR(ctx, client, cfg, certName, domainsToProcess, profile, policy, recorder)
 	}
 
-	// Set up the DNS-01 provider with proper resolver configuration
-	var dnsErr error
-	if cfg.DnsResolver != "" {
-		// Format nameserver address correctly (add :53 if port is missing)
-		nsAddr := cfg.DnsResolver
-		if !strings.Contains(nsAddr, ":") {
-			nsAddr += ":53"
+	// With reuse_key the stored key is sent with every order, otherwise each
+	// order gets a fresh key
+	var reuseKey crypto.PrivateKey
+	if cfg.ReuseKeyFor(certName) {
+		var err error
+		if reuseKey, err = loadReusableKey(cfg, certName, keyType); err != nil {
+			return err
 		}
-
-		// Create a slice of nameservers with the custom resolver
-		nameservers := []string{nsAddr}
-		DefaultLogger.Infof("Configuring DNS-01 challenge with custom nameservers: %v", nameservers)
-
-		// Set DNS01 provider with custom recursive nameservers
-		dnsErr = client.Challenge.SetDNS01Provider(
-			provider,
-			dns01.AddRecursiveNameservers(nameservers),
-			dns01.DisableCompletePropagationRequirement(),
-		)
-	} else {
-		// Default case - use the provider as is
-		dnsErr = client.Challenge.SetDNS01Provider(provider)
 	}
-
-	if dnsErr != nil {
-		return fmt.Errorf("failed to set DNS01 provider: %w", dnsErr)
+	orderKey, err := newOrderKey(keyType, reuseKey)
+	if err != nil {
+		return err
+	}
+	orderKeyPEM, err := encodePrivateKey(orderKey)
+	if err != nil {
+		return fmt.Errorf("encoding private key: %w", err)
 	}
+	// Orders are recorded until their certificate is stored, so the next run
+	// can resume or clean up an order this run does not complete
+	defer trackPendingOrder(cfg, certName, domainsToProcess, orderKeyPEM, recorder)(&err)
 
-	// Register the user if needed
-	if user.Registration == nil {
-		DefaultLogger.Info("No existing ACME registration found. Registering...")
-		reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
-		if err != nil {
-			return fmt.Errorf("ACME registration failed: %w", err)
-		}
-		user.Registration = reg
-		DefaultLogger.Info("ACME registration successful.")
-		if err := saveUser(cfg, user); err != nil {
-			// Log error but continue, registration succeeded
-			DefaultLogger.Warnf("Warning: failed to save ACME registration details: %v", err)
-		}
-	} else {
-		DefaultLogger.Info("Using existing ACME registration.")
+	// Lego cannot generate Ed25519 keys, these certificates are ordered for a CSR
+	if EffectiveKeyType(keyType) == KeyTypeEd25519 && (action == "init" || action == "renew") {
+		return obtainEd25519(ctx, client, cfg, certName, domainsToProcess, profile, orderKey, policy, recorder)
 	}
 
 	// Perform the requested action
 	switch action {
 	case "init":
-		DefaultLogger.Infof("Requesting new certificate for domains: %v", domainsToProcess)
+		DefaultLogger.Infof("Requesting new certificate for domains: %s", DisplayDomains(domainsToProcess))
 
 		// ACME-DNS setup was already verified in PreCheckAcmeDNS, so we can proceed directly
 		request := certificate.ObtainRequest{
-			Domains: domainsToProcess, // Use domainsToProcess
-			Bundle:  true,             // Get certificate chain
+			Domains:    domainsToProcess, // Use domainsToProcess
+			Bundle:     true,             // Get certificate chain
+			Profile:    profile,
+			PrivateKey: orderKey,
 		}
-		certificates, err := client.Certificate.Obtain(request)
+		var certificates *certificate.Resource
+		err := withRetry(ctx, policy, "obtain certificate", recorder, func() error {
+			var obtainErr error
+			certificates, obtainErr = client.Certificate.Obtain(request)
+			return obtainErr
+		})
 		if err != nil {
 			return fmt.Errorf("failed to obtain certificate: %w", err)
 		}
 		DefaultLogger.Infof("Successfully obtained certificate '%s'!", certName)
+		if err := validateIssuedCertificate(certName, certificates, domainsToProcess, time.Now()); err != nil {
+			return err
+		}
 		// Lego automatically saves certs based on its internal storage logic,
 		// which relies on the working directory or can be configured.
 		// We need to ensure it saves to cfg.LegoStoragePath/certificates
-		// Pass certName to saveCertificates
-		if err := saveCertificates(cfg, certName, certificates); err != nil {
-			DefaultLogger.Warnf("Warning: failed to save certificate '%s': %v", certName, err)
+		// Pass certName to storeCertificates
+		if err := storeCertificates(cfg, certName, certificates); err != nil {
+			return fmt.Errorf("failed to save certificate '%s': %w", certName, err)
 		}
 	case "renew":
 		// When renewing, we need to check if the domain list has changed
 		// If it has, we can't use Lego's Renew() which keeps the same domains
 		// Instead, we need to use Obtain() to get a new certificate with all domains
 
-		DefaultLogger.Infof("Attempting to renew certificate %s for domains: %v", certName, domainsToProcess)
+		DefaultLogger.Infof("Attempting to renew certificate %s for domains: %s", certName, DisplayDomains(domainsToProcess))
 
 		// Check if the certificate resource file exists for the certificate name.
-		metaPath := filepath.Join(cfg.CertStoragePath, "certificates", fmt.Sprintf("%s.json", certName))
-		certPath := filepath.Join(cfg.CertStoragePath, "certificates", fmt.Sprintf("%s.crt", certName))
+		paths := certinfo.PathsFor(cfg.CertStoragePath, certName)
+		metaPath, certPath := paths.Metadata, paths.Certificate
 
 		// Check if certificate files exist
 		if _, err := os.Stat(metaPath); os.IsNotExist(err) {
@@ -467,56 +439,19 @@ func RunLego(cfg *Config, store *accountStore, action string, certName string, d
 
 		// Check if the domain list has changed by comparing certificate domains with requested domains
 		// We need to parse the actual certificate to get its domains
-		certBytes, err := os.ReadFile(certPath)
-		if err != nil {
-			return fmt.Errorf("failed to read certificate file for domain comparison: %w", err)
-		}
-
-		block, _ := pem.Decode(certBytes)
-		if block == nil {
-			return fmt.Errorf("failed to decode PEM block from certificate")
-		}
-
-		x509Cert, err := x509.ParseCertificate(block.Bytes)
+		certInfo, err := certinfo.Load(certPath)
 		if err != nil {
 			return fmt.Errorf("failed to parse certificate for domain comparison: %w", err)
 		}
 
-		// Compare domains - check if all requested domains are in the certificate
-		existingDomains := x509Cert.DNSNames
-		domainMismatch := false
-
-		// Check if any requested domain is missing from the certificate
-		for _, reqDomain := range domainsToProcess {
-			found := false
-			for _, certDomain := range existingDomains {
-				if reqDomain == certDomain {
-					found = true
-					break
-				}
-			}
-			if !found {
-				domainMismatch = true
-				DefaultLogger.Infof("Domain %s is not in the existing certificate, will obtain new certificate", reqDomain)
-				break
-			}
+		missingDomains, extraDomains := certInfo.CompareDomains(domainsToProcess)
+		for _, d := range missingDomains {
+			DefaultLogger.Infof("Domain %s is not in the existing certificate, will obtain new certificate", d)
 		}
-
-		// Also check if certificate has extra domains not in the request
-		for _, certDomain := range existingDomains {
-			found := false
-			for _, reqDomain := range domainsToProcess {
-				if certDomain == reqDomain {
-					found = true
-					break
-				}
-			}
-			if !found {
-				domainMismatch = true
-				DefaultLogger.Infof("Certificate has extra domain %s not in the request, will obtain new certificate", certDomain)
-				break
-			}
+		for _, d := range extraDomains {
+			DefaultLogger.Infof("Certificate has extra domain %s not in the request, will obtain new certificate", d)
 		}
+		domainMismatch := len(missingDomains) > 0 || len(extraDomains) > 0
 
 		// If domains have changed, we need to obtain a new certificate, not renew
 		if domainMismatch {
@@ -524,40 +459,54 @@ func RunLego(cfg *Config, store *accountStore, action string, certName string, d
 
 			// ACME-DNS was already checked above for all domains
 			request := certificate.ObtainRequest{
-				Domains: domainsToProcess,
-				Bundle:  true,
+				Domains:    domainsToProcess,
+				Bundle:     true,
+				Profile:    profile,
+				PrivateKey: orderKey,
 			}
 
-			newCertificates, err := client.Certificate.Obtain(request)
+			var newCertificates *certificate.Resource
+			err := withRetry(ctx, policy, "obtain certificate", recorder, func() error {
+				var obtainErr error
+				newCertificates, obtainErr = client.Certificate.Obtain(request)
+				return obtainErr
+			})
 			if err != nil {
 				return fmt.Errorf("failed to obtain new certificate with updated domains: %w", err)
 			}
 
 			DefaultLogger.Infof("Successfully obtained new certificate '%s' with updated domains!", certName)
-			if err := saveCertificates(cfg, certName, newCertificates); err != nil {
-				DefaultLogger.Warnf("Warning: failed to save new certificate '%s': %v", certName, err)
+			if err := validateIssuedCertificate(certName, newCertificates, domainsToProcess, time.Now()); err != nil {
+				return err
+			}
+			if err := storeCertificates(cfg, certName, newCertificates); err != nil {
+				return fmt.Errorf("failed to save new certificate '%s': %w", certName, err)
 			}
 		} else {
 			// Domains haven't changed, do a normal renewal
 			DefaultLogger.Info("Domain list unchanged, performing standard certificate renewal")
 
 			renewOptions := certificate.RenewOptions{
-				Bundle: true,
+				Bundle:  true,
+				Profile: profile,
 			}
 
-			newCertificates, err := client.Certificate.Renew(*existingCert, renewOptions.Bundle, renewOptions.MustStaple, renewOptions.PreferredChain)
+			// Lego renews with the key of the resource
+			renewCert := *existingCert
+			renewCert.PrivateKey = orderKeyPEM
+
+			var newCertificates *certificate.Resource
+			err := withRetry(ctx, policy, "renew certificate", recorder, func() error {
+				var renewErr error
+				newCertificates, renewErr = client.Certificate.RenewWithOptions(renewCert, &renewOptions)
+				return renewErr
+			})
 			if err != nil {
 				return fmt.Errorf("failed to renew certificate: %w", err)
 			}
 
-			// Check if renewal actually occurred (Lego might return the old cert if still valid)
-			if newCertificates == nil || string(newCertificates.Certificate) == string(existingCert.Certificate) {
-				DefaultLogger.Info("Certificate renewal not required or did not result in a new certificate.")
-			} else {
-				DefaultLogger.Infof("Successfully renewed certificate '%s'!", certName)
-				if err := saveCertificates(cfg, certName, newCertificates); err != nil {
-					DefaultLogger.Warnf("Warning: failed to save renewed certificate '%s': %v", certName, err)
-				}
+			if err := storeRenewedCertificates(cfg, certName, existingCert, newCertificates, domainsToProcess); err != nil {
+				return err
 			}
 		}
 	default:
@@ -567,3 +516,321 @@ func RunLego(cfg *Config, store *accountStore, action string, certName string, d
 
 	return nil
 }
+
+// storeRenewedCertificates saves the result of a renewal, unless lego
+// returned the existing certificate because no new one was issued
+func storeRenewedCertificates(cfg *Config, certName string, existing, renewed *certificate.Resource, domains []string) error {
+	// Check if renewal actually occurred (Lego might return the old cert if still valid)
+	if renewed == nil || string(renewed.Certificate) == string(existing.Certificate) {
+		DefaultLogger.Info("Certificate renewal not required or did not result in a new certificate.")
+		return nil
+	}
+	DefaultLogger.Infof("Successfully renewed certificate '%s'!", certName)
+	if err := validateIssuedCertificate(certName, renewed, domains, time.Now()); err != nil {
+		return err
+	}
+	if err := storeCertificates(cfg, certName, renewed); err != nil {
+		return fmt.Errorf("failed to save renewed certificate '%s': %w", certName, err)
+	}
+	return nil
+}
+
+// storeCertificates saves an obtained certificate and records it for the
+// rate limit budget. If saving fails half-way, the files already written are
+// quarantined so the next run starts from a clean state.
+func storeCertificates(cfg *Config, certName string, resource *certificate.Resource) error {
+	saveErr := saveCertificates(cfg, certName, resource, cfg.ForCert(certName).AcmeServer)
+	if saveErr == nil {
+		// Only a certificate actually issued counts against the limits of the CA
+		domains := []string{resource.Domain}
+		if leaf, err := certcrypto.ParsePEMCertificate(resource.Certificate); err == nil && len(leaf.DNSNames) > 0 {
+			domains = leaf.DNSNames
+		}
+		recordIssuance(cfg, certName, domains)
+		return nil
+	}
+
+	result, err := QuarantinePartialArtifacts(cfg, certName)
+	if err != nil {
+		DefaultLogger.Errorf("Failed to quarantine incomplete files of certificate %s: %v", certName, err)
+	}
+	reportQuarantine(certName, result)
+	return saveErr
+}
+
+// newAcmeDNSChallengeProvider creates the acme-dns DNS-01 provider using the
+// accounts of the store
+func newAcmeDNSChallengeProvider(ctx context.Context, cfg *Config, store *accountStore) (challenge.Provider, error) {
+	// Setup acme-dns provider
+	DefaultLogger.Info("Configuring ACME DNS provider...")
+	providerConfig := &acmedns.Config{
+		APIBase:     cfg.AcmeDnsServer,
+		StoragePath: store.filePath,
+	}
+
+	// The provider is configured programmatically. The settings are also exported
+	// to the environment for hooks and child processes, unless another tool on
+	// this host already set them to different values.
+	if conflicts := acmeDNSEnvConflicts(providerConfig); len(conflicts) > 0 {
+		for _, c := range conflicts {
+			DefaultLogger.Warnf("Environment variable %s is already set to a different value, leaving it untouched and using the configuration file setting", c)
+		}
+	} else {
+		DefaultLogger.Infof("Setting %s=%s", acmedns.EnvAPIBase, providerConfig.APIBase)
+		if setErr := os.Setenv(acmedns.EnvAPIBase, providerConfig.APIBase); setErr != nil {
+			return nil, fmt.Errorf("failed to set %s env var: %w", acmedns.EnvAPIBase, setErr)
+		}
+		DefaultLogger.Infof("Setting %s=%s", acmedns.EnvStoragePath, providerConfig.StoragePath)
+		if setErr := os.Setenv(acmedns.EnvStoragePath, providerConfig.StoragePath); setErr != nil {
+			return nil, fmt.Errorf("failed to set %s env var: %w", acmedns.EnvStoragePath, setErr)
+		}
+	}
+
+	var provider challenge.Provider
+	var err error
+	if len(cfg.AcmeDnsServers) > 0 {
+		// Each domain is presented on the acme-dns server its account lives on
+		provider, err = newAcmeDNSRouter(ctx, cfg, store)
+	} else {
+		// The client is created here, so its requests are bound to ctx. The
+		// store finds accounts shared between names and reads encrypted
+		// files, which lego's file storage does not.
+		var acmeDNSClient *goacmedns.Client
+		acmeDNSClient, err = goacmedns.NewClient(providerConfig.APIBase, acmeDNSHTTPClient(ctx, cfg))
+		if err == nil {
+			provider, err = acmedns.NewDNSProviderClient(acmeDNSClient, providerStorage{store: store, cfg: cfg})
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.AccountGranularity == AccountPerBaseDomain {
+		return sequentialProvider{provider}, nil
+	}
+	return provider, nil
+}
+
+// acmeDNSEnvConflicts returns the acme-dns provider environment variables that
+// are already set to values differing from the given configuration
+func acmeDNSEnvConflicts(providerConfig *acmedns.Config) []string {
+	var conflicts []string
+	for name, want := range map[string]string{
+		acmedns.EnvAPIBase:     providerConfig.APIBase,
+		acmedns.EnvStoragePath: providerConfig.StoragePath,
+	} {
+		if have, ok := os.LookupEnv(name); ok && have != "" && have != want {
+			conflicts = append(conflicts, name)
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// legoKeyType maps our key types to Lego's certcrypto constants
+func legoKeyType(keyType string) certcrypto.KeyType {
+	switch EffectiveKeyType(keyType) {
+	case "rsa2048":
+		return certcrypto.RSA2048
+	case "rsa3072":
+		return certcrypto.RSA3072
+	case "rsa4096":
+		return certcrypto.RSA4096
+	case "ec256":
+		return certcrypto.EC256
+	case "ec384":
+		return certcrypto.EC384
+	case KeyTypeEd25519:
+		// Unused, obtainEd25519 brings its own key
+		return certcrypto.EC256
+	default:
+		// Default to RSA2048 if we don't have a mapping (shouldn't happen due to validation)
+		return certcrypto.RSA2048
+	}
+}
+
+// legoSetupMu serializes account registration and Lego client setup
+// when certificates are processed in parallel
+var legoSetupMu sync.Mutex
+
+// setupLegoClient loads or registers the ACME account and returns a Lego client
+// configured with the acme-dns DNS-01 provider. The HTTP requests of the client
+// are bound to ctx and, if recorder is set, sent through it.
+func setupLegoClient(ctx context.Context, cfg *Config, store *accountStore, keyType string, recorder *retryAfterRecorder) (*lego.Client, error) {
+	DefaultLogger.Info("Initializing Lego client...")
+
+	user, userErr := createOrLoadUser(cfg)
+	if userErr != nil {
+		return nil, fmt.Errorf("failed to create/load ACME user: %w", userErr)
+	}
+
+	// Setup Lego config
+	legoConfig := lego.NewConfig(user)
+	legoConfig.CADirURL = cfg.AcmeServer
+
+	// Set key type, using provided value, or fall back to default
+	certKeyType := EffectiveKeyType(keyType)
+	if certKeyType == keyType {
+		DefaultLogger.Infof("Using specified key type: %s", certKeyType)
+	} else {
+		DefaultLogger.Infof("Using default key type: %s", certKeyType)
+	}
+
+	legoConfig.Certificate.KeyType = legoKeyType(keyType)
+	// Use timeouts from config
+	legoConfig.Certificate.Timeout = cfg.ChallengeTimeout
+	if legoConfig.HTTPClient == nil {
+		legoConfig.HTTPClient = &http.Client{}
+	}
+	legoConfig.HTTPClient.Timeout = cfg.HTTPTimeout
+	cfg.applyTransportSettings(legoConfig.HTTPClient)
+	if recorder != nil {
+		if legoConfig.HTTPClient.Transport != nil {
+			recorder.next = legoConfig.HTTPClient.Transport
+		}
+		legoConfig.HTTPClient.Transport = recorder
+	}
+	legoConfig.HTTPClient.Transport = newContextTransport(ctx, cfg.debugTransport(cfg.statsTransport(legoConfig.HTTPClient.Transport)))
+
+	// Create Lego client
+	client, clientErr := lego.NewClient(legoConfig)
+	if clientErr != nil {
+		return nil, fmt.Errorf("failed to create Lego client: %w", clientErr)
+	}
+
+	// This ensures only DNS-01 is used and prevents Lego from attempting other challenge types
+	client.Challenge.Remove(challenge.HTTP01)
+	client.Challenge.Remove(challenge.TLSALPN01)
+
+	var provider challenge.Provider
+	var providerErr error
+	if cfg.UsesAcmeDNS() {
+		provider, providerErr = newAcmeDNSChallengeProvider(ctx, cfg, store)
+	} else if cfg.DNSChallengeProvider == DNSChallengeProviderExec {
+		DefaultLogger.Info("Configuring exec DNS provider...")
+		provider, providerErr = newExecProvider(cfg.DNSChallengeExec)
+	} else {
+		DefaultLogger.Infof("Configuring %s DNS provider...", cfg.DNSChallengeProvider)
+		provider, providerErr = newLegoDNSProvider(cfg)
+	}
+	if providerErr != nil {
+		return nil, fmt.Errorf("failed to create DNS provider: %w", providerErr)
+	}
+
+	// Set up the DNS-01 provider with proper resolver configuration
+	var dnsErr error
+	if nsAddr := cfg.PrecheckResolverAddress(); IsEncryptedResolver(nsAddr) {
+		// Lego checks propagation over plain DNS only
+		DefaultLogger.Infof("Checking DNS-01 propagation through encrypted resolver %s", nsAddr)
+		dnsErr = client.Challenge.SetDNS01Provider(
+			provider,
+			dns01.WrapPreCheck(cancelablePreCheck(ctx, newEncryptedResolver(cfg, nsAddr).preCheck)),
+		)
+	} else if nsAddr != "" {
+		// Create a slice of nameservers with the custom resolver
+		nameservers := []string{nsAddr}
+		DefaultLogger.Infof("Configuring DNS-01 challenge with custom nameservers: %v", nameservers)
+
+		// Set DNS01 provider with custom recursive nameservers
+		dnsErr = client.Challenge.SetDNS01Provider(
+			provider,
+			dns01.AddRecursiveNameservers(nameservers),
+			dns01.DisableCompletePropagationRequirement(),
+			dns01.WrapPreCheck(cancelablePreCheck(ctx, nil)),
+		)
+	} else {
+		// Default case - use the provider as is
+		dnsErr = client.Challenge.SetDNS01Provider(provider, dns01.WrapPreCheck(cancelablePreCheck(ctx, nil)))
+	}
+
+	if dnsErr != nil {
+		return nil, fmt.Errorf("failed to set DNS01 provider: %w", dnsErr)
+	}
+
+	// Register the user if needed
+	if user.Registration == nil {
+		DefaultLogger.Info("No existing ACME registration found. Registering...")
+		reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+		if err != nil {
+			recordAudit(cfg, AuditRecord{Action: AuditRegisterAccount}, err)
+			return nil, fmt.Errorf("ACME registration failed: %w", err)
+		}
+		recordAudit(cfg, AuditRecord{Action: AuditRegisterAccount, Account: reg.URI}, nil)
+		user.Registration = reg
+		DefaultLogger.Info("ACME registration successful.")
+		if err := saveUser(cfg, user); err != nil {
+			// Log error but continue, registration succeeded
+			DefaultLogger.Warnf("Warning: failed to save ACME registration details: %v", err)
+		}
+	} else {
+		DefaultLogger.Info("Using existing ACME registration.")
+	}
+
+	return client, nil
+}
+
+// cancelablePreCheck ends Lego's DNS propagation wait once ctx is canceled.
+// The wait takes no context and only stops on success, so the check reports
+// the record as propagated; the following ACME request fails with the context
+// error. next is the check to wrap, nil for Lego's own propagation check.
+func cancelablePreCheck(ctx context.Context, next dns01.WrapPreCheckFunc) dns01.WrapPreCheckFunc {
+	return func(domain, fqdn, value string, check dns01.PreCheckFunc) (bool, error) {
+		if ctx.Err() != nil {
+			return true, nil
+		}
+		if next != nil {
+			return next(domain, fqdn, value, check)
+		}
+		return check(fqdn, value)
+	}
+}
+
+// contextTransport binds the requests of the Lego client to a context. Lego
+// does not accept one, so this is how cancellation reaches its HTTP requests.
+// The request keeps its own context as well, it carries the client timeout.
+type contextTransport struct {
+	ctx  context.Context
+	next http.RoundTripper
+}
+
+// newContextTransport wraps next, or http.DefaultTransport if next is nil
+func newContextTransport(ctx context.Context, next http.RoundTripper) *contextTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &contextTransport{ctx: ctx, next: next}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+	reqCtx, cancel := context.WithCancelCause(req.Context())
+	stop := context.AfterFunc(t.ctx, func() { cancel(context.Cause(t.ctx)) })
+	release := func() {
+		stop()
+		cancel(nil)
+	}
+
+	resp, err := t.next.RoundTrip(req.WithContext(reqCtx))
+	if err != nil {
+		release()
+		return nil, err
+	}
+	// The body is read after RoundTrip returns, keep the context alive until it is closed
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releaseOnClose calls release once the response body is closed
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releaseOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
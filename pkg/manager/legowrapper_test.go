@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/go-acme/lego/v4/certificate"
+	"github.com/go-acme/lego/v4/providers/dns/acmedns"
 )
 
 // TestRunLego_ValidationErrors tests input validation
@@ -201,7 +202,7 @@ func TestLoadCertificateResource_Success(t *testing.T) {
 
 	// Create mock certificate resource and save it
 	testCert := createTestCertificateResource()
-	if err := saveCertificates(cfg, certName, testCert); err != nil {
+	if err := saveCertificates(cfg, certName, testCert, ""); err != nil {
 		t.Fatalf("Failed to save test certificate: %v", err)
 	}
 
@@ -416,3 +417,25 @@ func BenchmarkKeyTypeMapping(b *testing.B) {
 		_ = result // Prevent optimization
 	}
 }
+
+func TestAcmeDNSEnvConflicts(t *testing.T) {
+	providerConfig := &acmedns.Config{APIBase: "https://acme-dns.example.com", StoragePath: "/srv/acme/acme-dns-accounts.json"}
+
+	t.Setenv(acmedns.EnvAPIBase, "")
+	t.Setenv(acmedns.EnvStoragePath, "")
+	if conflicts := acmeDNSEnvConflicts(providerConfig); len(conflicts) != 0 {
+		t.Errorf("Expected no conflicts for empty variables, got %v", conflicts)
+	}
+
+	t.Setenv(acmedns.EnvAPIBase, "https://acme-dns.example.com")
+	if conflicts := acmeDNSEnvConflicts(providerConfig); len(conflicts) != 0 {
+		t.Errorf("Expected no conflicts for matching values, got %v", conflicts)
+	}
+
+	t.Setenv(acmedns.EnvAPIBase, "https://other.example.net")
+	t.Setenv(acmedns.EnvStoragePath, "/var/lib/other/accounts.json")
+	conflicts := acmeDNSEnvConflicts(providerConfig)
+	if len(conflicts) != 2 || conflicts[0] != acmedns.EnvAPIBase || conflicts[1] != acmedns.EnvStoragePath {
+		t.Errorf("Expected both variables to conflict, got %v", conflicts)
+	}
+}
@@ -0,0 +1,164 @@
+package manager
+
+import (
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+)
+
+// Files of a live directory, named like certbot's so web server
+// configurations written for certbot work unchanged
+const (
+	LiveCert      = "cert.pem"      // The certificate alone
+	LiveChain     = "chain.pem"     // The intermediate certificates
+	LiveFullChain = "fullchain.pem" // Certificate followed by the intermediates
+	LivePrivKey   = "privkey.pem"   // The private key, missing for csr_path certificates
+)
+
+// liveCurrentLink points at the archive generation in use
+const liveCurrentLink = "current"
+
+// liveFiles are the links of a live directory, in the order they are created
+var liveFiles = []string{LiveCert, LiveChain, LiveFullChain, LivePrivKey}
+
+// writeLiveFiles adds the files live_dir links to to an archive generation:
+// cert.pem, chain.pem and fullchain.pem split from its certificate, and
+// privkey.pem linking to its key, which is the archived copy in
+// key_output_dir if the key is kept there. It returns the files written,
+// privkey.pem is left out as a symlink is not in the manifest.
+func writeLiveFiles(cfg *Config, certName, genDir, outputKey string) ([]string, error) {
+	certPEM, err := os.ReadFile(filepath.Join(genDir, certName+".crt"))
+	if err != nil {
+		return nil, fmt.Errorf("reading archived certificate: %w", err)
+	}
+	chain, err := certcrypto.ParsePEMBundle(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parsing certificate %s: %w", certName, err)
+	}
+	leaf := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain[0].Raw})
+	var intermediates []byte
+	for _, c := range chain[1:] {
+		intermediates = append(intermediates, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	// lego bundles the chain, a certificate stored without it has the issuer separately
+	if len(intermediates) == 0 {
+		if issuerPEM, err := os.ReadFile(filepath.Join(genDir, certName+".issuer.crt")); err == nil {
+			intermediates = joinPEM(issuerPEM)
+		}
+	}
+
+	policy, err := cfg.filePolicy()
+	if err != nil {
+		return nil, err
+	}
+	uid, gid := policy.owner()
+	var written []string
+	for _, f := range []struct {
+		name    string
+		content []byte
+	}{{LiveCert, leaf}, {LiveChain, intermediates}, {LiveFullChain, joinPEM(leaf, intermediates)}} {
+		path := filepath.Join(genDir, f.name)
+		if err := writeFileAtomicOwned(path, f.content, policy.fileMode(false), uid, gid); err != nil {
+			return nil, fmt.Errorf("writing %s: %w", path, err)
+		}
+		written = append(written, path)
+	}
+
+	keyTarget := outputKey
+	if keyTarget == "" {
+		if _, err := os.Stat(filepath.Join(genDir, certName+".key")); err == nil {
+			keyTarget = certName + ".key"
+		}
+	}
+	// No key for csr_path certificates
+	if keyTarget != "" {
+		if err := os.Symlink(keyTarget, filepath.Join(genDir, LivePrivKey)); err != nil {
+			return nil, fmt.Errorf("linking %s: %w", LivePrivKey, err)
+		}
+	}
+	return written, nil
+}
+
+// updateLiveLinks publishes an archive generation in live_dir/<cert-name>/.
+// The current link is switched to the generation with a rename, so readers
+// see either the old or the new certificate and key, never a mix. cert.pem,
+// chain.pem, fullchain.pem and privkey.pem link through current and never
+// change themselves.
+func updateLiveLinks(cfg *Config, certName string, gen *Generation) error {
+	if cfg.LiveDir == "" {
+		return nil
+	}
+	if gen == nil {
+		return fmt.Errorf("%w to link to", ErrNoGeneration)
+	}
+	policy, err := cfg.filePolicy()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(cfg.LiveDir, certName)
+	if err := os.MkdirAll(dir, policy.directoryMode()); err != nil {
+		return fmt.Errorf("creating live directory %s: %w", dir, err)
+	}
+	target, err := filepath.Rel(dir, gen.Dir)
+	if err != nil {
+		target = gen.Dir
+	}
+	if err := replaceSymlink(target, filepath.Join(dir, liveCurrentLink)); err != nil {
+		return fmt.Errorf("switching %s to %s: %w", dir, gen.Dir, err)
+	}
+	for _, name := range liveFiles {
+		link := filepath.Join(dir, name)
+		if _, err := os.Lstat(filepath.Join(gen.Dir, name)); err != nil {
+			// Issued for an external key, a link left from before would dangle
+			if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("removing %s: %w", link, err)
+			}
+			continue
+		}
+		target := liveCurrentLink + "/" + name
+		if current, err := os.Readlink(link); err == nil && current == target {
+			continue
+		}
+		if err := replaceSymlink(target, link); err != nil {
+			return fmt.Errorf("linking %s: %w", link, err)
+		}
+	}
+	syncDir(dir)
+	DefaultLogger.Infof("Updated live links in %s", dir)
+	return nil
+}
+
+// replaceSymlink points link at target, replacing what is there with a
+// rename, so the link never goes missing
+func replaceSymlink(target, link string) error {
+	tmp := filepath.Join(filepath.Dir(link), "."+filepath.Base(link)+".tmp-"+fmt.Sprint(time.Now().UnixNano()))
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// removeLiveDir removes the live directory of a certificate and returns the
+// removed files
+func removeLiveDir(cfg *Config, certName string) ([]string, error) {
+	if cfg.LiveDir == "" {
+		return nil, nil
+	}
+	dir := filepath.Join(cfg.LiveDir, certName)
+	files, err := filesBelow(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("removing live directory %s: %w", dir, err)
+	}
+	return files, nil
+}
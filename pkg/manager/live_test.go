@@ -0,0 +1,172 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-acme/lego/v4/certificate"
+)
+
+func TestSaveCertificates_LiveLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need extra privileges on Windows")
+	}
+	dir := t.TempDir()
+	cfg := &Config{CertStoragePath: dir, LiveDir: filepath.Join(dir, "live")}
+	liveDir := filepath.Join(cfg.LiveDir, "web")
+
+	certPEM, keyPEM, chainPEM := newTestChain(t, []string{"example.com"})
+	first := &certificate.Resource{Domain: "example.com", Certificate: joinPEM(certPEM, chainPEM), PrivateKey: keyPEM, IssuerCertificate: chainPEM}
+	if err := saveCertificates(cfg, "web", first, ""); err != nil {
+		t.Fatalf("Failed to save certificates: %v", err)
+	}
+	for name, want := range map[string]string{
+		LiveCert:      string(joinPEM(certPEM)),
+		LiveChain:     string(joinPEM(chainPEM)),
+		LiveFullChain: string(joinPEM(certPEM, chainPEM)),
+		LivePrivKey:   string(keyPEM),
+	} {
+		link := filepath.Join(liveDir, name)
+		if target, err := os.Readlink(link); err != nil || target != "current/"+name {
+			t.Errorf("Expected %s to link to current/%s, got %q, %v", name, name, target, err)
+		}
+		if data, err := os.ReadFile(link); err != nil || string(data) != want {
+			t.Errorf("Unexpected content of %s: %v\n%s", name, err, data)
+		}
+	}
+	firstGen, _ := os.Readlink(filepath.Join(liveDir, "current"))
+	if want, _ := filepath.Rel(liveDir, filepath.Join(dir, ArchiveDirName, "web")); filepath.Dir(firstGen) != want {
+		t.Errorf("Expected current to link to a generation in %s, got %s", want, firstGen)
+	}
+
+	// A renewal switches current to the new generation, the archive keeps no
+	// replaced ones without keep_generations
+	certPEM2, keyPEM2, chainPEM2 := newTestChain(t, []string{"example.com"})
+	second := &certificate.Resource{Domain: "example.com", Certificate: joinPEM(certPEM2, chainPEM2), PrivateKey: keyPEM2}
+	if err := saveCertificates(cfg, "web", second, ""); err != nil {
+		t.Fatalf("Failed to save renewed certificates: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(liveDir, LivePrivKey)); string(data) != string(keyPEM2) {
+		t.Error("Expected privkey.pem to hold the new key")
+	}
+	if data, _ := os.ReadFile(filepath.Join(liveDir, LiveChain)); string(data) != string(joinPEM(chainPEM2)) {
+		t.Error("Expected chain.pem to hold the intermediate of the bundle")
+	}
+	if _, err := os.Stat(filepath.Join(liveDir, firstGen)); !os.IsNotExist(err) {
+		t.Errorf("Expected the replaced generation to be removed, got %v", err)
+	}
+	generations, err := ListGenerations(cfg, "web")
+	if err != nil || len(generations) != 1 {
+		t.Fatalf("Expected only the current generation, got %d (%v)", len(generations), err)
+	}
+	if current, _ := os.Readlink(filepath.Join(liveDir, "current")); filepath.Join(liveDir, current) != generations[0].Dir {
+		t.Errorf("Expected current to link to %s, got %s", generations[0].Dir, current)
+	}
+	entries, _ := os.ReadDir(liveDir)
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp-") {
+			t.Errorf("Expected only the links, found %s", e.Name())
+		}
+	}
+
+	// The live files of the archive are in the manifest
+	report, err := Fsck(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) != 0 {
+		t.Errorf("Expected a clean fsck, got %+v", report.Issues)
+	}
+
+	files, err := removeLiveDir(cfg, "web")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("Expected the live directory to be removed, got %v, %v", files, err)
+	}
+	if _, err := os.Lstat(liveDir); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be gone, got %v", liveDir, err)
+	}
+}
+
+func TestUpdateLiveLinks_WithoutKey(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need extra privileges on Windows")
+	}
+	dir := t.TempDir()
+	cfg := &Config{CertStoragePath: filepath.Join(dir, "storage"), LiveDir: filepath.Join(dir, "live")}
+	certPEM, keyPEM, chainPEM := newTestChain(t, []string{"example.com"})
+	resource := &certificate.Resource{Domain: "example.com", Certificate: certPEM, PrivateKey: keyPEM, IssuerCertificate: chainPEM}
+	if err := saveCertificates(cfg, "web", resource, ""); err != nil {
+		t.Fatal(err)
+	}
+	// Issued for a CSR later on, the key link must not point at the old key
+	certPEM2, _, _ := newTestChain(t, []string{"example.com"})
+	resource = &certificate.Resource{Domain: "example.com", Certificate: certPEM2, IssuerCertificate: chainPEM}
+	if err := saveCertificates(cfg, "web", resource, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(cfg.LiveDir, "web", LivePrivKey)); !os.IsNotExist(err) {
+		t.Errorf("Expected no privkey.pem without a key, got %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(cfg.LiveDir, "web", LiveChain)); string(data) != string(joinPEM(chainPEM)) {
+		t.Error("Expected chain.pem from the issuer certificate")
+	}
+}
+
+// TestUpdateLiveLinks_KeyOutputDir tests that privkey.pem links to the key
+// archived in key_output_dir, the storage directory has none
+func TestUpdateLiveLinks_KeyOutputDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need extra privileges on Windows")
+	}
+	dir := t.TempDir()
+	cfg := &Config{
+		CertStoragePath: filepath.Join(dir, "storage"),
+		LiveDir:         filepath.Join(dir, "live"),
+		AutoDomains: &AutoDomainsConfig{Certs: map[string]CertConfig{
+			"web": {Domains: []string{"example.com"}, KeyOutputDir: filepath.Join(dir, "secure")},
+		}},
+	}
+	certPEM, keyPEM, _ := newTestChain(t, []string{"example.com"})
+	resource := &certificate.Resource{Domain: "example.com", Certificate: certPEM, PrivateKey: keyPEM}
+	if err := saveCertificates(cfg, "web", resource, ""); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(cfg.LiveDir, "web", LivePrivKey)
+	if data, err := os.ReadFile(link); err != nil || string(data) != string(keyPEM) {
+		t.Errorf("Expected privkey.pem to hold the key: %v", err)
+	}
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil || !strings.HasPrefix(target, filepath.Join(dir, "secure")+string(filepath.Separator)) {
+		t.Errorf("Expected privkey.pem to resolve below key_output_dir, got %s (%v)", target, err)
+	}
+}
+
+// TestRollbackCertificate_LiveLinks tests that a rollback links live_dir to
+// the restored generation and removes the one it replaced
+func TestRollbackCertificate_LiveLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need extra privileges on Windows")
+	}
+	dir := t.TempDir()
+	cfg := &Config{CertStoragePath: filepath.Join(dir, "storage"), LiveDir: filepath.Join(dir, "live"), KeepGenerations: 2}
+	first := saveTestGeneration(t, cfg, "web")
+	saveTestGeneration(t, cfg, "web")
+
+	gen, err := RollbackCertificate(cfg, "web")
+	if err != nil {
+		t.Fatalf("RollbackCertificate failed: %v", err)
+	}
+	liveDir := filepath.Join(cfg.LiveDir, "web")
+	if current, _ := os.Readlink(filepath.Join(liveDir, "current")); filepath.Join(liveDir, current) != gen.Dir {
+		t.Errorf("Expected current to link to the restored generation %s, got %s", gen.Dir, current)
+	}
+	if data, _ := os.ReadFile(filepath.Join(liveDir, LiveCert)); string(data) != string(joinPEM(first)) {
+		t.Error("Expected cert.pem of the restored certificate")
+	}
+	if generations, err := ListGenerations(cfg, "web"); err != nil || len(generations) != 1 {
+		t.Errorf("Expected only the restored generation to be left, got %d (%v)", len(generations), err)
+	}
+}
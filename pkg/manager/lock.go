@@ -0,0 +1,103 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LockFileName is the lock file below the storage path that keeps concurrent
+// runs from racing on the account store and certificate files
+const LockFileName = ".lock"
+
+// ErrLockHeld is returned when another instance holds the lock
+var ErrLockHeld = errors.New("storage directory is locked by another instance")
+
+// lockPollInterval is the time between attempts while waiting for the lock
+var lockPollInterval = 250 * time.Millisecond
+
+// InstanceLock is an exclusive advisory lock (flock on Unix, LockFileEx on
+// Windows) on '<cert_storage_path>/.lock'. The operating system releases it
+// when the process exits, so a crashed run never leaves a stale lock behind.
+type InstanceLock struct {
+	file *os.File
+	Path string
+}
+
+// AcquireLock takes the instance lock of the storage directory. If another
+// instance holds it, AcquireLock retries until timeout expires (zero means a
+// single attempt) and then returns an error wrapping ErrLockHeld that names
+// the holder.
+func AcquireLock(ctx context.Context, storagePath string, timeout time.Duration) (*InstanceLock, error) {
+	if err := os.MkdirAll(storagePath, DirPermissions); err != nil {
+		return nil, fmt.Errorf("creating storage directory %s: %w", storagePath, err)
+	}
+	path := filepath.Join(storagePath, LockFileName)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, PrivateKeyPermissions)
+	if err != nil {
+		return nil, fmt.Errorf("opening lock file %s: %w", path, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLockFile(file)
+		if err != nil {
+			_ = file.Close()
+			return nil, fmt.Errorf("locking %s: %w", path, err)
+		}
+		if locked {
+			break
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			holder := readLockHolder(path)
+			_ = file.Close()
+			return nil, fmt.Errorf("%w: %s (held by %s)", ErrLockHeld, path, holder)
+		}
+		if wait > lockPollInterval {
+			wait = lockPollInterval
+		}
+		select {
+		case <-ctx.Done():
+			_ = file.Close()
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+
+	// Record the holder for the error message of competing instances
+	holder := fmt.Sprintf("pid %d since %s", os.Getpid(), time.Now().UTC().Format(time.RFC3339))
+	if err := file.Truncate(0); err == nil {
+		_, _ = file.WriteAt([]byte(holder+"\n"), 0)
+	}
+	return &InstanceLock{file: file, Path: path}, nil
+}
+
+// Release gives up the lock. The file stays in place, removing it would let
+// a waiting instance lock a file that a third one has already replaced.
+func (l *InstanceLock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	_ = l.file.Truncate(0)
+	err := unlockFile(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.file = nil
+	return err
+}
+
+// readLockHolder returns the holder recorded in the lock file
+func readLockHolder(path string) string {
+	data, err := os.ReadFile(path)
+	if holder := strings.TrimSpace(string(data)); err == nil && holder != "" {
+		return holder
+	}
+	return "unknown process"
+}
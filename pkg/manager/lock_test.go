@@ -0,0 +1,69 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcquireLock_Exclusive(t *testing.T) {
+	storage := t.TempDir()
+	lock, err := AcquireLock(context.Background(), storage, 0)
+	if err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+
+	_, err = AcquireLock(context.Background(), storage, 0)
+	if !errors.Is(err, ErrLockHeld) {
+		t.Fatalf("Expected ErrLockHeld, got %v", err)
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("pid %d", os.Getpid())) {
+		t.Errorf("Expected holder pid in error, got %v", err)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Failed to release lock: %v", err)
+	}
+	again, err := AcquireLock(context.Background(), storage, 0)
+	if err != nil {
+		t.Fatalf("Expected lock to be free after release, got %v", err)
+	}
+	_ = again.Release()
+}
+
+func TestAcquireLock_WaitsForRelease(t *testing.T) {
+	storage := t.TempDir()
+	lock, err := AcquireLock(context.Background(), storage, 0)
+	if err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = lock.Release()
+	}()
+
+	second, err := AcquireLock(context.Background(), storage, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Expected lock after the holder released it, got %v", err)
+	}
+	_ = second.Release()
+}
+
+func TestAcquireLock_Canceled(t *testing.T) {
+	storage := t.TempDir()
+	lock, err := AcquireLock(context.Background(), storage, 0)
+	if err != nil {
+		t.Fatalf("Failed to acquire lock: %v", err)
+	}
+	defer func() { _ = lock.Release() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := AcquireLock(ctx, storage, time.Minute); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context error, got %v", err)
+	}
+}
@@ -193,7 +193,17 @@ func isTerminal(fd uintptr) bool {
 	return (fileInfo.Mode() & os.ModeCharDevice) != 0
 }
 
-// SetupDefaultLogger initializes the default logger with the specified level and format
+// logOutput is where SetupDefaultLogger sends log messages
+var logOutput io.Writer = os.Stdout
+
+// SetLogOutput changes where loggers created by SetupDefaultLogger write to.
+// Modes printing machine readable data on stdout use this to move logs to stderr.
+func SetLogOutput(w io.Writer) {
+	logOutput = w
+}
+
+// SetupDefaultLogger initializes the default logger with the specified level and format.
+// Lego's log output is routed through it as well.
 func SetupDefaultLogger(level LogLevel, format ...LogFormat) {
 	// Determine which format to use
 	logFormat := LogFormatDefault
@@ -216,20 +226,21 @@ func SetupDefaultLogger(level LogLevel, format ...LogFormat) {
 	switch logFormat {
 	case LogFormatGo:
 		// Standard Go format with timestamps
-		DefaultLogger = NewLogger(os.Stdout, level)
+		DefaultLogger = NewLogger(logOutput, level)
 	case LogFormatEmoji:
 		// Emoji format with colors if not disabled
-		DefaultLogger = NewColorfulLogger(os.Stdout, level, false, true)
+		DefaultLogger = NewColorfulLogger(logOutput, level, false, true)
 	case LogFormatColor:
 		// Colored format without emoji
-		DefaultLogger = NewColorfulLogger(os.Stdout, level, true, false)
+		DefaultLogger = NewColorfulLogger(logOutput, level, true, false)
 	case LogFormatASCII:
 		// Plain text format without colors or emoji
-		DefaultLogger = NewColorfulLogger(os.Stdout, level, false, false)
+		DefaultLogger = NewColorfulLogger(logOutput, level, false, false)
 	default:
 		// Fall back to debug logger if all else fails
-		DefaultLogger = NewLogger(os.Stdout, level)
+		DefaultLogger = NewLogger(logOutput, level)
 	}
+	routeLegoLog()
 }
 
 // GetDefaultLogger returns the default logger
@@ -0,0 +1,306 @@
+package manager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+// ManifestFile is the checksum manifest below the storage path. It records the
+// SHA-256 of every file the manager writes so -fsck can detect manual edits,
+// bit rot and files that were not created by the manager.
+const ManifestFile = "manifest.json"
+
+// manifestVersion is the format version written to new manifests
+const manifestVersion = 1
+
+// Kinds of discrepancies reported by Fsck
+const (
+	FsckModified = "modified" // Content differs from the recorded checksum
+	FsckMissing  = "missing"  // Recorded in the manifest but gone from disk
+	FsckForeign  = "foreign"  // Present on disk but never written by the manager
+)
+
+// manifestMu serializes manifest updates of parallel certificate workers
+var manifestMu sync.Mutex
+
+// Manifest maps storage-relative, slash-separated paths to their checksums
+type Manifest struct {
+	Version int                      `json:"version"`
+	Files   map[string]ManifestEntry `json:"files"`
+}
+
+// ManifestEntry is the recorded state of one file
+type ManifestEntry struct {
+	SHA256  string    `json:"sha256"`
+	Size    int64     `json:"size"`
+	Updated time.Time `json:"updated"`
+}
+
+// LoadManifest reads the manifest of a storage directory. A missing manifest
+// yields an empty one and exists=false.
+func LoadManifest(storagePath string) (manifest *Manifest, exists bool, err error) {
+	manifest = &Manifest{Version: manifestVersion, Files: make(map[string]ManifestEntry)}
+	data, err := os.ReadFile(filepath.Join(storagePath, ManifestFile))
+	if os.IsNotExist(err) {
+		return manifest, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("reading manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, true, fmt.Errorf("parsing manifest %s: %w", filepath.Join(storagePath, ManifestFile), err)
+	}
+	if manifest.Files == nil {
+		manifest.Files = make(map[string]ManifestEntry)
+	}
+	return manifest, true, nil
+}
+
+// save writes the manifest back to the storage directory
+func (m *Manifest) save(storagePath string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling manifest: %w", err)
+	}
+	path := filepath.Join(storagePath, ManifestFile)
+	if err := writeFileAtomic(path, data, PrivateKeyPermissions); err != nil {
+		return fmt.Errorf("writing manifest %s: %w", path, err)
+	}
+	return nil
+}
+
+// record updates the entry of one absolute path, dropping it if the file is gone
+func (m *Manifest) record(storagePath, path string) error {
+	rel, err := manifestKey(storagePath, path)
+	if err != nil {
+		return err
+	}
+	entry, err := checksumFile(path)
+	if os.IsNotExist(err) {
+		delete(m.Files, rel)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	m.Files[rel] = entry
+	return nil
+}
+
+// UpdateManifest records the current checksums of the given files, which must
+// be located below storagePath. Files that no longer exist are removed from the
+// manifest, so callers pass both written and moved-away paths.
+func UpdateManifest(storagePath string, paths ...string) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	manifest, _, err := LoadManifest(storagePath)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := manifest.record(storagePath, path); err != nil {
+			return err
+		}
+	}
+	return manifest.save(storagePath)
+}
+
+// recordManifest updates the manifest after a write. A failure does not undo
+// the write, it only means -fsck will report the file, so it is logged.
+func recordManifest(storagePath string, paths ...string) {
+	if err := UpdateManifest(storagePath, paths...); err != nil {
+		DefaultLogger.Warnf("Warning: updating checksum manifest: %v", err)
+	}
+}
+
+// manifestKey turns an absolute path into the manifest key
+func manifestKey(storagePath, path string) (string, error) {
+	rel, err := filepath.Rel(storagePath, path)
+	if err != nil {
+		return "", fmt.Errorf("locating %s below %s: %w", path, storagePath, err)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// checksumFile computes the manifest entry of a file
+func checksumFile(path string) (ManifestEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return ManifestEntry{}, fmt.Errorf("hashing %s: %w", path, err)
+	}
+	return ManifestEntry{SHA256: hex.EncodeToString(h.Sum(nil)), Size: size, Updated: time.Now().UTC()}, nil
+}
+
+// FsckIssue is one discrepancy between the manifest and the storage directory
+type FsckIssue struct {
+	Path     string `json:"path"`               // Storage-relative path
+	Kind     string `json:"kind"`               // FsckModified, FsckMissing or FsckForeign
+	Repaired string `json:"repaired,omitempty"` // What -fsck-repair did about it, empty if nothing
+}
+
+// FsckReport is the result of a storage integrity check
+type FsckReport struct {
+	ManifestExists bool        `json:"manifest_exists"`
+	Checked        int         `json:"checked"` // Files compared against the manifest
+	Issues         []FsckIssue `json:"issues"`
+}
+
+// Fsck compares the files below storagePath with the checksum manifest. The
+// manifest itself, the lock file and quarantined artifacts below failed/ are
+// not checked.
+//
+// With repair set, certificate metadata that is missing while certificate and
+// key are intact is regenerated, and the manifest is rewritten to match the
+// directory afterwards. The report still lists every discrepancy found.
+func Fsck(storagePath string, repair bool) (*FsckReport, error) {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	manifest, exists, err := LoadManifest(storagePath)
+	if err != nil {
+		return nil, err
+	}
+	report := &FsckReport{ManifestExists: exists}
+
+	onDisk := make(map[string]string)
+	err = filepath.WalkDir(storagePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == storagePath {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() && path == filepath.Join(storagePath, FailedDirName) {
+			return fs.SkipDir
+		}
+		if !d.Type().IsRegular() || path == filepath.Join(storagePath, ManifestFile) || path == filepath.Join(storagePath, LockFileName) {
+			return nil
+		}
+		rel, err := manifestKey(storagePath, path)
+		if err != nil {
+			return err
+		}
+		onDisk[rel] = path
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scanning storage directory: %w", err)
+	}
+
+	for rel, path := range onDisk {
+		recorded, ok := manifest.Files[rel]
+		if !ok {
+			report.Issues = append(report.Issues, FsckIssue{Path: rel, Kind: FsckForeign})
+			continue
+		}
+		report.Checked++
+		current, err := checksumFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if current.SHA256 != recorded.SHA256 {
+			report.Issues = append(report.Issues, FsckIssue{Path: rel, Kind: FsckModified})
+		}
+	}
+	for rel := range manifest.Files {
+		if _, ok := onDisk[rel]; !ok {
+			report.Issues = append(report.Issues, FsckIssue{Path: rel, Kind: FsckMissing})
+		}
+	}
+	sort.Slice(report.Issues, func(i, j int) bool { return report.Issues[i].Path < report.Issues[j].Path })
+
+	if !repair || (len(report.Issues) == 0 && exists) {
+		return report, nil
+	}
+
+	for i := range report.Issues {
+		issue := &report.Issues[i]
+		if issue.Kind == FsckMissing {
+			if path, ok := repairCertMetadata(storagePath, issue.Path); ok {
+				onDisk[issue.Path] = path
+				issue.Repaired = "regenerated from certificate"
+				continue
+			}
+		}
+		switch issue.Kind {
+		case FsckMissing:
+			issue.Repaired = "removed from manifest"
+		case FsckModified:
+			issue.Repaired = "checksum updated"
+		case FsckForeign:
+			issue.Repaired = "added to manifest"
+		}
+	}
+
+	rebuilt := &Manifest{Version: manifestVersion, Files: make(map[string]ManifestEntry, len(onDisk))}
+	for _, path := range onDisk {
+		if err := rebuilt.record(storagePath, path); err != nil {
+			return report, err
+		}
+	}
+	return report, rebuilt.save(storagePath)
+}
+
+// repairCertMetadata regenerates missing '<name>.json' certificate metadata if
+// the certificate and key next to it are present
+func repairCertMetadata(storagePath, rel string) (string, bool) {
+	dir, file := filepath.Split(filepath.FromSlash(rel))
+	if filepath.Clean(dir) != certinfo.CertificatesDirName || filepath.Ext(file) != ".json" {
+		return "", false
+	}
+	path, err := regenerateCertMetadata(storagePath, file[:len(file)-len(".json")])
+	if err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// regenerateCertMetadata writes the metadata of a certificate from its
+// certificate file. Renewal only needs the domain from the metadata, the
+// certificate and key are read from their files, so the key must be present.
+func regenerateCertMetadata(storagePath, certName string) (string, error) {
+	paths := certinfo.PathsFor(storagePath, certName)
+	keyFile, err := privateKeyFile(storagePath, certName)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(keyFile); err != nil {
+		return "", fmt.Errorf("private key missing: %w", err)
+	}
+	info, err := certinfo.Load(paths.Certificate)
+	if err != nil {
+		return "", err
+	}
+	if len(info.DNSNames) == 0 {
+		return "", errors.New("certificate has no DNS names")
+	}
+
+	data, err := json.MarshalIndent(&certificate.Resource{Domain: info.DNSNames[0]}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(paths.Metadata, data, PrivateKeyPermissions); err != nil {
+		DefaultLogger.Warnf("Warning: regenerating %s: %v", paths.Metadata, err)
+		return "", err
+	}
+	return paths.Metadata, nil
+}
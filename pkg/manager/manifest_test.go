@@ -0,0 +1,177 @@
+package manager
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+// issuesByPath indexes a report for assertions
+func issuesByPath(report *FsckReport) map[string]FsckIssue {
+	issues := make(map[string]FsckIssue)
+	for _, issue := range report.Issues {
+		issues[issue.Path] = issue
+	}
+	return issues
+}
+
+func TestManifest_RecordedOnWrite(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir()}
+	if err := saveCertificates(cfg, "web", createSignedResource(t), ""); err != nil {
+		t.Fatalf("Failed to save certificates: %v", err)
+	}
+	store, err := NewAccountStore(filepath.Join(cfg.CertStoragePath, AcmeDNSAccountsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetAccount("example.com", AcmeDnsAccount{Username: "u"})
+	if err := store.SaveAccounts(); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, exists, err := LoadManifest(cfg.CertStoragePath)
+	if err != nil || !exists {
+		t.Fatalf("Expected manifest to exist, got exists=%v err=%v", exists, err)
+	}
+	for _, key := range []string{"certificates/web.crt", "certificates/web.key", "certificates/web.issuer.crt", "certificates/web.json", AcmeDNSAccountsFile} {
+		if entry, ok := manifest.Files[key]; !ok || len(entry.SHA256) != 64 {
+			t.Errorf("Expected manifest entry for %s, got %+v", key, entry)
+		}
+	}
+
+	report, err := Fsck(cfg.CertStoragePath, false)
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if len(report.Issues) != 0 || report.Checked != 5 {
+		t.Errorf("Expected clean report for 5 files, got %+v", report)
+	}
+}
+
+func TestFsck_DetectsDiscrepancies(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir()}
+	if err := saveCertificates(cfg, "web", createSignedResource(t), ""); err != nil {
+		t.Fatalf("Failed to save certificates: %v", err)
+	}
+	paths := certinfo.PathsFor(cfg.CertStoragePath, "web")
+
+	if err := os.WriteFile(paths.Certificate, []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(paths.Issuer); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cfg.CertStoragePath, "certificates", "other.crt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Quarantined artifacts are not checked
+	if err := os.MkdirAll(filepath.Join(cfg.CertStoragePath, FailedDirName, "x-20250101T000000Z"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cfg.CertStoragePath, FailedDirName, "x-20250101T000000Z", "x.key"), []byte("k"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Fsck(cfg.CertStoragePath, false)
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	issues := issuesByPath(report)
+	want := map[string]string{
+		"certificates/web.crt":        FsckModified,
+		"certificates/web.issuer.crt": FsckMissing,
+		"certificates/other.crt":      FsckForeign,
+	}
+	if len(issues) != len(want) {
+		t.Errorf("Expected %d issues, got %+v", len(want), report.Issues)
+	}
+	for path, kind := range want {
+		if issues[path].Kind != kind {
+			t.Errorf("Expected %s to be %s, got %+v", path, kind, issues[path])
+		}
+	}
+
+	if _, err := Fsck(cfg.CertStoragePath, true); err != nil {
+		t.Fatalf("Fsck repair failed: %v", err)
+	}
+	report, err = Fsck(cfg.CertStoragePath, false)
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if len(report.Issues) != 0 {
+		t.Errorf("Expected no issues after repair, got %+v", report.Issues)
+	}
+}
+
+func TestFsck_RepairsCertificateMetadata(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir()}
+	if err := saveCertificates(cfg, "web", createSignedResource(t), ""); err != nil {
+		t.Fatalf("Failed to save certificates: %v", err)
+	}
+	paths := certinfo.PathsFor(cfg.CertStoragePath, "web")
+	if err := os.Remove(paths.Metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Fsck(cfg.CertStoragePath, true)
+	if err != nil {
+		t.Fatalf("Fsck repair failed: %v", err)
+	}
+	if issue := issuesByPath(report)["certificates/web.json"]; issue.Kind != FsckMissing || issue.Repaired != "regenerated from certificate" {
+		t.Errorf("Expected metadata to be regenerated, got %+v", issue)
+	}
+
+	data, err := os.ReadFile(paths.Metadata)
+	if err != nil {
+		t.Fatalf("Expected regenerated metadata: %v", err)
+	}
+	var resource certificate.Resource
+	if err := json.Unmarshal(data, &resource); err != nil || resource.Domain != "example.com" {
+		t.Errorf("Unexpected regenerated metadata: %s (%v)", data, err)
+	}
+	if _, err := LoadCertificateResource(cfg, "web"); err != nil {
+		t.Errorf("Expected repaired certificate to load, got %v", err)
+	}
+}
+
+func TestFsck_NoManifest(t *testing.T) {
+	storage := t.TempDir()
+	if err := os.WriteFile(filepath.Join(storage, "account.json"), []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	report, err := Fsck(storage, false)
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if report.ManifestExists || len(report.Issues) != 1 || report.Issues[0].Kind != FsckForeign {
+		t.Errorf("Expected single foreign file without manifest, got %+v", report)
+	}
+
+	if _, err := Fsck(storage, true); err != nil {
+		t.Fatalf("Fsck repair failed: %v", err)
+	}
+	if _, exists, _ := LoadManifest(storage); !exists {
+		t.Error("Expected repair to create the manifest")
+	}
+}
+
+func TestQuarantine_UpdatesManifest(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir()}
+	if err := saveCertificates(cfg, "web", createSignedResource(t), ""); err != nil {
+		t.Fatalf("Failed to save certificates: %v", err)
+	}
+	if _, err := QuarantineArtifacts(cfg, "web"); err != nil {
+		t.Fatalf("Quarantine failed: %v", err)
+	}
+	report, err := Fsck(cfg.CertStoragePath, false)
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if len(report.Issues) != 0 {
+		t.Errorf("Expected quarantined files to leave the manifest, got %+v", report.Issues)
+	}
+}
@@ -0,0 +1,200 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+	"golang.org/x/crypto/ocsp"
+)
+
+// OCSP status values recorded in the state file
+const (
+	OCSPStatusGood        = "good"
+	OCSPStatusRevoked     = "revoked"
+	OCSPStatusUnknown     = "unknown"     // The responder does not know the certificate
+	OCSPStatusUnsupported = "unsupported" // The certificate names no OCSP responder
+)
+
+// DefaultOCSPTimeout limits one OCSP request
+const DefaultOCSPTimeout = 30 * time.Second
+
+// maxOCSPResponseSize caps the response body read from a responder
+const maxOCSPResponseSize = 64 * 1024
+
+// OCSPState is the result of the last OCSP check of a certificate
+type OCSPState struct {
+	Status    string     `json:"status"`
+	Serial    string     `json:"serial"` // Serial number of the checked certificate
+	CheckedAt time.Time  `json:"checked_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	Reason    string     `json:"reason,omitempty"` // Revocation reason, e.g. keyCompromise
+}
+
+// ocspHTTPClient returns the client sending the OCSP requests, with the
+// proxy and TLS settings of the configuration
+func ocspHTTPClient(cfg *Config) *http.Client {
+	return &http.Client{Timeout: DefaultOCSPTimeout, Transport: cfg.baseTransport()}
+}
+
+// ocspReasons names the RFC 5280 revocation reason codes
+var ocspReasons = map[int]string{
+	ocsp.Unspecified:          "unspecified",
+	ocsp.KeyCompromise:        "keyCompromise",
+	ocsp.CACompromise:         "cACompromise",
+	ocsp.AffiliationChanged:   "affiliationChanged",
+	ocsp.Superseded:           "superseded",
+	ocsp.CessationOfOperation: "cessationOfOperation",
+	ocsp.CertificateHold:      "certificateHold",
+	ocsp.RemoveFromCRL:        "removeFromCRL",
+	ocsp.PrivilegeWithdrawn:   "privilegeWithdrawn",
+	ocsp.AACompromise:         "aACompromise",
+}
+
+// CheckOCSP asks the OCSP responder named in the stored certificate for its
+// revocation status. The issuer is taken from the stored chain. A certificate
+// without responder yields OCSPStatusUnsupported, not an error.
+func CheckOCSP(ctx context.Context, cfg *Config, certName string) (*OCSPState, error) {
+	paths := certinfo.PathsFor(cfg.CertStoragePath, certName)
+	leaf, issuer, err := loadOCSPChain(paths)
+	if err != nil {
+		return nil, err
+	}
+	result := &OCSPState{Serial: certinfo.FromX509(leaf).SerialNumber, CheckedAt: time.Now().UTC()}
+	if len(leaf.OCSPServer) == 0 {
+		result.Status = OCSPStatusUnsupported
+		return result, nil
+	}
+
+	request, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating OCSP request: %w", err)
+	}
+	responder := leaf.OCSPServer[0]
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, responder, bytes.NewReader(request))
+	if err != nil {
+		return nil, fmt.Errorf("creating OCSP request for %s: %w", responder, err)
+	}
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+	httpReq.Header.Set("Accept", "application/ocsp-response")
+	resp, err := ocspHTTPClient(cfg).Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("querying OCSP responder %s: %w", responder, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder %s returned %s", responder, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxOCSPResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("reading OCSP response from %s: %w", responder, err)
+	}
+	parsed, err := ocsp.ParseResponseForCert(body, leaf, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("parsing OCSP response from %s: %w", responder, err)
+	}
+
+	switch parsed.Status {
+	case ocsp.Good:
+		result.Status = OCSPStatusGood
+	case ocsp.Revoked:
+		result.Status = OCSPStatusRevoked
+		revokedAt := parsed.RevokedAt.UTC()
+		result.RevokedAt = &revokedAt
+		result.Reason = ocspReasons[parsed.RevocationReason]
+	default:
+		result.Status = OCSPStatusUnknown
+	}
+	return result, nil
+}
+
+// loadOCSPChain returns the stored leaf certificate and its issuer, from the
+// chain in the certificate file or else from the issuer file
+func loadOCSPChain(paths certinfo.Paths) (leaf, issuer *x509.Certificate, err error) {
+	data, err := os.ReadFile(paths.Certificate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading certificate file %s: %w", paths.Certificate, err)
+	}
+	chain, err := certcrypto.ParsePEMBundle(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing certificate file %s: %w", paths.Certificate, err)
+	}
+	if len(chain) > 1 {
+		return chain[0], chain[1], nil
+	}
+	issuerPEM, err := os.ReadFile(paths.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("the OCSP check needs the issuer certificate: %w", err)
+	}
+	issuers, err := certcrypto.ParsePEMBundle(issuerPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing issuer file %s: %w", paths.Issuer, err)
+	}
+	return chain[0], issuers[0], nil
+}
+
+// RecordOCSP stores the result of an OCSP check in the state file
+func RecordOCSP(storagePath, certName string, result *OCSPState) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	state, err := LoadState(storagePath)
+	if err != nil {
+		return err
+	}
+	certState := state.Certificates[certName]
+	certState.OCSP = result
+	state.Certificates[certName] = certState
+	return saveState(storagePath, state)
+}
+
+// revocation returns the last OCSP result of the stored certificate if it
+// found the certificate revoked, or nil. Results for an earlier certificate of
+// the same name are ignored.
+func revocation(storagePath, certName string, info *certinfo.Info) *OCSPState {
+	state, err := LoadState(storagePath)
+	if err != nil {
+		DefaultLogger.Warnf("Cannot read the OCSP status of %s: %v", certName, err)
+		return nil
+	}
+	result := state.Certificates[certName].OCSP
+	if result == nil || result.Status != OCSPStatusRevoked || result.Serial != info.SerialNumber {
+		return nil
+	}
+	return result
+}
+
+// revokedReason returns why the stored certificate has to be replaced
+// because the last OCSP check found it revoked, or "" if it was not
+func revokedReason(storagePath, certName string, info *certinfo.Info) string {
+	result := revocation(storagePath, certName, info)
+	if result == nil {
+		return ""
+	}
+	reason := "certificate was revoked"
+	if result.RevokedAt != nil {
+		reason += " at " + result.RevokedAt.Format(time.RFC3339)
+	}
+	if result.Reason != "" {
+		reason += " (" + result.Reason + ")"
+	}
+	return reason
+}
+
+// keyCompromised reports whether the last OCSP check found the stored
+// certificate revoked for key compromise, its key must not be reused then
+func keyCompromised(storagePath, certName string) bool {
+	info, err := certinfo.Load(certinfo.PathsFor(storagePath, certName).Certificate)
+	if err != nil {
+		return false
+	}
+	result := revocation(storagePath, certName, info)
+	return result != nil && result.Reason == ocspReasons[ocsp.KeyCompromise]
+}
@@ -0,0 +1,183 @@
+package manager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+// newOCSPTestCA creates the CA issuing the test certificates and signing the
+// OCSP responses
+func newOCSPTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+	return caCert, caKey
+}
+
+// writeOCSPCertificate stores a certificate issued by the CA naming responder
+// as OCSP server
+func writeOCSPCertificate(t *testing.T, storage, responder string, caCert *x509.Certificate, caKey *ecdsa.PrivateKey) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	if responder != "" {
+		leafTemplate.OCSPServer = []string{responder}
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+
+	paths := certinfo.PathsFor(storage, "web")
+	if err := os.MkdirAll(filepath.Dir(paths.Certificate), 0700); err != nil {
+		t.Fatal(err)
+	}
+	bundle := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw})...)
+	if err := os.WriteFile(paths.Certificate, bundle, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(paths.Metadata, []byte(`{"domain":"example.com"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ocspResponder answers every request with the given status
+func ocspResponder(t *testing.T, status int, ca *x509.Certificate, caKey *ecdsa.PrivateKey) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		template := ocsp.Response{
+			Status:           status,
+			SerialNumber:     req.SerialNumber,
+			ThisUpdate:       time.Now().Add(-time.Minute),
+			NextUpdate:       time.Now().Add(time.Hour),
+			RevokedAt:        time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+			RevocationReason: ocsp.KeyCompromise,
+		}
+		resp, err := ocsp.CreateResponse(ca, ca, template, caKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		_, _ = w.Write(resp)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCheckOCSP(t *testing.T) {
+	ca, caKey := newOCSPTestCA(t)
+	good := ocspResponder(t, ocsp.Good, ca, caKey)
+	cfg := &Config{CertStoragePath: t.TempDir()}
+	writeOCSPCertificate(t, cfg.CertStoragePath, good.URL, ca, caKey)
+	result, err := CheckOCSP(context.Background(), cfg, "web")
+	if err != nil {
+		t.Fatalf("CheckOCSP failed: %v", err)
+	}
+	if result.Status != OCSPStatusGood || result.Serial != "2a" {
+		t.Errorf("Expected good status for serial 2a, got %+v", result)
+	}
+
+	// The responder is reached through proxy_url
+	cfg = &Config{CertStoragePath: t.TempDir(), ProxyURL: good.URL}
+	writeOCSPCertificate(t, cfg.CertStoragePath, "http://ocsp.invalid/", ca, caKey)
+	if result, err := CheckOCSP(context.Background(), cfg, "web"); err != nil || result.Status != OCSPStatusGood {
+		t.Errorf("Expected good status through the proxy, got %+v (%v)", result, err)
+	}
+
+	cfg = &Config{CertStoragePath: t.TempDir()}
+	writeOCSPCertificate(t, cfg.CertStoragePath, "", ca, caKey)
+	if result, err := CheckOCSP(context.Background(), cfg, "web"); err != nil || result.Status != OCSPStatusUnsupported {
+		t.Errorf("Expected unsupported without responder, got %+v (%v)", result, err)
+	}
+}
+
+func TestCheckOCSP_RevokedIsReplaced(t *testing.T) {
+	ca, caKey := newOCSPTestCA(t)
+	revoked := ocspResponder(t, ocsp.Revoked, ca, caKey)
+	cfg := &Config{CertStoragePath: t.TempDir()}
+	writeOCSPCertificate(t, cfg.CertStoragePath, revoked.URL, ca, caKey)
+
+	result, err := CheckOCSP(context.Background(), cfg, "web")
+	if err != nil {
+		t.Fatalf("CheckOCSP failed: %v", err)
+	}
+	if result.Status != OCSPStatusRevoked || result.Reason != "keyCompromise" {
+		t.Fatalf("Expected revoked for key compromise, got %+v", result)
+	}
+	if err := RecordOCSP(cfg.CertStoragePath, "web", result); err != nil {
+		t.Fatalf("RecordOCSP failed: %v", err)
+	}
+	// A regular state update keeps the OCSP result
+	if err := UpdateCertState(cfg.CertStoragePath, "web", CertState{Domains: []string{"example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	decision, err := DetermineAction(cfg, "web", []string{"example.com"}, "ec256", time.Hour)
+	if err != nil {
+		t.Fatalf("DetermineAction failed: %v", err)
+	}
+	if decision.Action != ActionInit || !strings.Contains(decision.Reason, "revoked at 2026-01-02T03:04:05Z (keyCompromise)") {
+		t.Errorf("Expected %s for a revoked certificate, got %s (%q)", ActionInit, decision.Action, decision.Reason)
+	}
+	if !keyCompromised(cfg.CertStoragePath, "web") {
+		t.Error("Expected the key of the revoked certificate to count as compromised")
+	}
+
+	// The result no longer applies once the certificate was replaced
+	result.Serial = "1"
+	if err := RecordOCSP(cfg.CertStoragePath, "web", result); err != nil {
+		t.Fatal(err)
+	}
+	decision, err = DetermineAction(cfg, "web", []string{"example.com"}, "ec256", time.Hour)
+	if err != nil {
+		t.Fatalf("DetermineAction failed: %v", err)
+	}
+	if decision.Action != ActionSkip {
+		t.Errorf("Expected %s for a result of another certificate, got %s (%q)", ActionSkip, decision.Action, decision.Reason)
+	}
+}
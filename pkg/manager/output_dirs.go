@@ -0,0 +1,243 @@
+package manager
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+// Default file name templates of cert_output_dir and key_output_dir
+const (
+	DefaultCertFilename = "{{.Name}}.crt"
+	DefaultKeyFilename  = "{{.Name}}.key"
+)
+
+// outputFileData is what cert_filename and key_filename templates can use
+type outputFileData struct {
+	Name       string // Certificate name in auto_domains
+	CommonName string
+	Domains    []string
+	Serial     string // Hexadecimal
+	NotBefore  time.Time
+	NotAfter   time.Time
+}
+
+// resolveOutputDirs checks the file name templates of cert_output_dir and
+// key_output_dir and resolves relative directories against configDir
+func resolveOutputDirs(cfg *Config, configDir string) error {
+	if cfg.AutoDomains == nil {
+		return nil
+	}
+	sample := outputFileData{Name: "example", CommonName: "example.com", Domains: []string{"example.com"}, Serial: "01", NotBefore: time.Now(), NotAfter: time.Now()}
+	for name, certCfg := range cfg.AutoDomains.Certs {
+		if certCfg.KeyOutputDir != "" && certCfg.CSRPath != "" {
+			return fmt.Errorf("auto_domains.certs.%s: key_output_dir needs the private key, which is not available with csr_path", name)
+		}
+		if _, err := outputFilename("cert_filename", certCfg.CertFilename, DefaultCertFilename, sample); err != nil {
+			return fmt.Errorf("auto_domains.certs.%s: %w", name, err)
+		}
+		if _, err := outputFilename("key_filename", certCfg.KeyFilename, DefaultKeyFilename, sample); err != nil {
+			return fmt.Errorf("auto_domains.certs.%s: %w", name, err)
+		}
+		if certCfg.CertOutputDir != "" && !filepath.IsAbs(certCfg.CertOutputDir) {
+			certCfg.CertOutputDir = filepath.Join(configDir, certCfg.CertOutputDir)
+		}
+		if certCfg.KeyOutputDir != "" && !filepath.IsAbs(certCfg.KeyOutputDir) {
+			certCfg.KeyOutputDir = filepath.Join(configDir, certCfg.KeyOutputDir)
+		}
+		cfg.AutoDomains.Certs[name] = certCfg
+	}
+	return nil
+}
+
+// outputFilename renders a file name template, def is used if tmpl is empty.
+// The result must be a plain file name, directories belong in the output dir.
+func outputFilename(field, tmpl, def string, data outputFileData) (string, error) {
+	if tmpl == "" {
+		tmpl = def
+	}
+	t, err := template.New(field).Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", field, err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("%s: %w", field, err)
+	}
+	name := buf.String()
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("%s %q yields %q, which is not a file name", field, tmpl, name)
+	}
+	return name, nil
+}
+
+// saveOutputDirs writes the certificate with its chain to cert_output_dir and
+// the private key to key_output_dir of the certificate, if configured. The key
+// in key_output_dir is the only copy: renewals, reuse_key and -rollback read
+// it from there and the caller removes the one in the storage directory. The
+// files written are recorded in the state, files of the previous certificate
+// with another name are removed.
+func saveOutputDirs(cfg *Config, certName string, certPEM, keyPEM []byte) error {
+	certCfg, ok := cfg.CertConfigFor(certName)
+	if !ok {
+		return nil
+	}
+	var written []string
+	var keyFile string
+	if certCfg.CertOutputDir != "" || certCfg.KeyOutputDir != "" {
+		chain, err := certcrypto.ParsePEMBundle(certPEM)
+		if err != nil {
+			return fmt.Errorf("parsing certificate %s: %w", certName, err)
+		}
+		leaf := chain[0]
+		data := outputFileData{
+			Name:       certName,
+			CommonName: leaf.Subject.CommonName,
+			Domains:    leaf.DNSNames,
+			Serial:     fmt.Sprintf("%x", leaf.SerialNumber),
+			NotBefore:  leaf.NotBefore,
+			NotAfter:   leaf.NotAfter,
+		}
+		policy, err := cfg.filePolicy()
+		if err != nil {
+			return err
+		}
+
+		if certCfg.CertOutputDir != "" {
+			path, err := writeOutputFile(policy, certCfg.CertOutputDir, "cert_filename", certCfg.CertFilename, DefaultCertFilename, data, certPEM, false)
+			if err != nil {
+				return fmt.Errorf("writing certificate of %s to cert_output_dir: %w", certName, err)
+			}
+			written = append(written, path)
+		}
+		if certCfg.KeyOutputDir != "" && len(keyPEM) > 0 {
+			path, err := writeOutputFile(policy, certCfg.KeyOutputDir, "key_filename", certCfg.KeyFilename, DefaultKeyFilename, data, keyPEM, true)
+			if err != nil {
+				return fmt.Errorf("writing private key of %s to key_output_dir: %w", certName, err)
+			}
+			written = append(written, path)
+			keyFile = path
+		}
+	}
+
+	// Also run without output dirs, files of a removed output dir must go
+	previous, err := recordOutputFiles(cfg.CertStoragePath, certName, written, keyFile)
+	if err != nil {
+		return err
+	}
+	for _, path := range previous {
+		if slices.Contains(written, path) {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			DefaultLogger.Warnf("Warning: removing %s of the previous certificate of %s: %v", path, certName, err)
+		} else if err == nil {
+			DefaultLogger.Infof("Removed %s of the previous certificate of %s", path, certName)
+		}
+	}
+	return nil
+}
+
+// keyInOutputDir reports whether the private key of a certificate is kept in
+// its key_output_dir instead of the storage directory
+func keyInOutputDir(cfg *Config, certName string) bool {
+	certCfg, ok := cfg.CertConfigFor(certName)
+	return ok && certCfg.KeyOutputDir != ""
+}
+
+// privateKeyFile returns the file holding the private key of a certificate:
+// the one in key_output_dir recorded in the state, else the storage file. A
+// key stays where it is until the certificate is issued again, so a key is
+// found after key_output_dir was added to or removed from the configuration.
+func privateKeyFile(storagePath, certName string) (string, error) {
+	state, err := LoadState(storagePath)
+	if err != nil {
+		return "", err
+	}
+	if keyFile := state.Certificates[certName].KeyFile; keyFile != "" {
+		return keyFile, nil
+	}
+	return certinfo.PathsFor(storagePath, certName).PrivateKey, nil
+}
+
+// readPrivateKey reads the private key of a certificate and returns it with
+// the file it was read from. A key in the storage directory is read through
+// the configured store, so a remote backend has the last word.
+func readPrivateKey(cfg *Config, certName string) ([]byte, string, error) {
+	keyFile, err := privateKeyFile(cfg.CertStoragePath, certName)
+	if err != nil {
+		return nil, "", err
+	}
+	if keyFile != certinfo.PathsFor(cfg.CertStoragePath, certName).PrivateKey {
+		data, err := os.ReadFile(keyFile)
+		return data, keyFile, err
+	}
+	store, err := cfg.Store()
+	if err != nil {
+		return nil, keyFile, err
+	}
+	key, err := manifestKey(cfg.CertStoragePath, keyFile)
+	if err != nil {
+		return nil, keyFile, err
+	}
+	data, err := store.Get(key)
+	return data, keyFile, err
+}
+
+// LoadArtifact inspects the stored files of a certificate like
+// certinfo.LoadArtifact, with the private key from key_output_dir if it is
+// kept there. If the state cannot be read, the storage directory is checked.
+func LoadArtifact(storagePath, certName string) (*certinfo.Artifact, error) {
+	keyFile, err := privateKeyFile(storagePath, certName)
+	if err != nil {
+		return certinfo.LoadArtifact(storagePath, certName)
+	}
+	return certinfo.LoadArtifactWithKey(storagePath, certName, keyFile)
+}
+
+// writeOutputFile writes content to the file named by the template below dir
+// and returns its path
+func writeOutputFile(policy *filePolicy, dir, field, tmpl, def string, data outputFileData, content []byte, private bool) (string, error) {
+	name, err := outputFilename(field, tmpl, def, data)
+	if err != nil {
+		return "", err
+	}
+	// The directory may belong to another service, an existing one is left as it is
+	if err := os.MkdirAll(dir, policy.directoryMode()); err != nil {
+		return "", fmt.Errorf("creating directory %s: %w", dir, err)
+	}
+	path := filepath.Join(dir, name)
+	uid, gid := policy.owner()
+	if err := writeFileAtomicOwned(path, content, policy.fileMode(private), uid, gid); err != nil {
+		return "", err
+	}
+	if private {
+		DefaultLogger.Infof("Saved private key to %s", path)
+	} else {
+		DefaultLogger.Infof("Saved certificate to %s", path)
+	}
+	return path, nil
+}
+
+// removeOutputFiles removes the output files recorded in the state of a
+// certificate and returns the paths removed
+func removeOutputFiles(certState CertState) ([]string, error) {
+	var removed []string
+	for _, path := range certState.OutputFiles {
+		if err := os.Remove(path); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return removed, fmt.Errorf("removing %s: %w", path, err)
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
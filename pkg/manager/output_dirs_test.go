@@ -0,0 +1,106 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+)
+
+func TestSaveCertificates_OutputDirs(t *testing.T) {
+	dir := t.TempDir()
+	certPEM, keyPEM, _ := newTestChain(t, []string{"example.com"})
+	cfg := &Config{
+		CertStoragePath: filepath.Join(dir, "storage"),
+		AutoDomains: &AutoDomainsConfig{Certs: map[string]CertConfig{
+			"web": {
+				Domains:       []string{"example.com"},
+				CertOutputDir: filepath.Join(dir, "nginx"),
+				KeyOutputDir:  filepath.Join(dir, "secure"),
+				CertFilename:  "{{.Name}}-{{.NotAfter.Year}}.pem",
+			},
+		}},
+	}
+	resource := &certificate.Resource{Domain: "example.com", Certificate: certPEM, PrivateKey: keyPEM}
+	if err := saveCertificates(cfg, "web", resource, ""); err != nil {
+		t.Fatalf("Failed to save certificates: %v", err)
+	}
+
+	chain, err := certcrypto.ParsePEMBundle(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath := filepath.Join(dir, "nginx", fmt.Sprintf("web-%d.pem", chain[0].NotAfter.Year()))
+	if data, err := os.ReadFile(certPath); err != nil || string(data) != string(certPEM) {
+		t.Errorf("Expected the certificate in %s, got %v", certPath, err)
+	}
+	keyPath := filepath.Join(dir, "secure", "web.key")
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		t.Fatalf("Expected the key in %s: %v", keyPath, err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != PrivateKeyPermissions {
+		t.Errorf("Expected the key to be private, got %04o", info.Mode().Perm())
+	}
+	// key_output_dir holds the only copy of the key, renewals read it from there
+	if _, err := os.Stat(filepath.Join(cfg.CertStoragePath, "certificates", "web.key")); !os.IsNotExist(err) {
+		t.Errorf("Expected no key in the storage directory, got %v", err)
+	}
+	loaded, err := LoadCertificateResource(cfg, "web")
+	if err != nil {
+		t.Fatalf("Failed to load the certificate: %v", err)
+	}
+	if string(loaded.PrivateKey) != string(keyPEM) {
+		t.Error("Expected the key to be loaded from key_output_dir")
+	}
+	artifact, err := LoadArtifact(cfg.CertStoragePath, "web")
+	if err != nil || !artifact.Complete() || !artifact.KeyMatches {
+		t.Errorf("Expected a complete certificate with the key in key_output_dir, got %+v (%v)", artifact, err)
+	}
+}
+
+// TestSaveOutputDirs_RemovesPrevious tests that a certificate written under
+// a new file name removes the copy of the previous one, and nothing else
+func TestSaveOutputDirs_RemovesPrevious(t *testing.T) {
+	dir := t.TempDir()
+	certCfg := CertConfig{Domains: []string{"example.com"}, CertOutputDir: filepath.Join(dir, "nginx")}
+	cfg := &Config{CertStoragePath: filepath.Join(dir, "storage"), AutoDomains: &AutoDomainsConfig{Certs: map[string]CertConfig{}}}
+	foreign := filepath.Join(dir, "nginx", "other.pem")
+	if err := os.MkdirAll(filepath.Dir(foreign), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(foreign, []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM, _, _ := newTestChain(t, []string{"example.com"})
+	var paths []string
+	for _, tmpl := range []string{"{{.Name}}-1.pem", "{{.Name}}-2.pem"} {
+		certCfg.CertFilename = tmpl
+		cfg.AutoDomains.Certs["web"] = certCfg
+		if err := saveOutputDirs(cfg, "web", certPEM, nil); err != nil {
+			t.Fatal(err)
+		}
+		name, _ := outputFilename("cert_filename", tmpl, DefaultCertFilename, outputFileData{Name: "web"})
+		paths = append(paths, filepath.Join(dir, "nginx", name))
+	}
+	if _, err := os.Stat(paths[0]); !os.IsNotExist(err) {
+		t.Errorf("Expected the copy of the previous certificate to be removed: %v", err)
+	}
+	for _, path := range []string{paths[1], foreign} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be kept: %v", path, err)
+		}
+	}
+	state, err := LoadState(cfg.CertStoragePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := state.Certificates["web"].OutputFiles; len(files) != 1 || files[0] != paths[1] {
+		t.Errorf("Expected the new copy in the state, got %v", files)
+	}
+}
@@ -0,0 +1,93 @@
+package manager
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Output formats that can be requested per certificate via output_formats
+const (
+	OutputFormatPFX           = "pfx"            // <name>.pfx, PKCS#12 with key, certificate and chain
+	OutputFormatHAProxyPEM    = "haproxy_pem"    // <name>.combined.pem, key followed by certificate and chain
+	OutputFormatFullChainOnly = "fullchain_only" // <name>.fullchain.pem, certificate and chain without key
+)
+
+// PKCS#12 encodings selectable via pfx_encoding
+const (
+	PFXEncodingModern = "modern" // AES-256 and SHA-256, the default
+	PFXEncodingLegacy = "legacy" // 3DES and RC2 for Windows Server < 2019 and Java < 8u301
+)
+
+// saveOutputFormats writes the additional files requested by the certificate's
+// output_formats. Certificates without configuration get none.
+func saveOutputFormats(cfg *Config, certName string, resource *certificate.Resource) error {
+	certCfg, ok := cfg.CertConfigFor(certName)
+	if !ok || len(certCfg.OutputFormats) == 0 {
+		return nil
+	}
+	paths := certinfo.PathsFor(cfg.CertStoragePath, certName)
+
+	for _, format := range certCfg.OutputFormats {
+		var (
+			path    string
+			data    []byte
+			private bool
+			err     error
+		)
+		switch format {
+		case OutputFormatPFX:
+			path, private = paths.PFX, true
+			data, err = encodePFX(resource, certCfg)
+		case OutputFormatHAProxyPEM:
+			path, private = paths.CombinedPEM, true
+			data = joinPEM(resource.PrivateKey, resource.Certificate)
+		case OutputFormatFullChainOnly:
+			path, private = paths.FullChain, false
+			data = joinPEM(resource.Certificate)
+		default:
+			return fmt.Errorf("unknown output format %q for certificate %s", format, certName)
+		}
+		if err != nil {
+			return fmt.Errorf("encoding %s output for %s: %w", format, certName, err)
+		}
+		if err := writeStorageFile(cfg, path, data, private); err != nil {
+			return fmt.Errorf("writing %s file %s: %w", format, path, err)
+		}
+		DefaultLogger.Infof("Saved %s output to %s", format, path)
+	}
+	return nil
+}
+
+// encodePFX builds a password protected PKCS#12 file holding the private key,
+// the leaf certificate and the rest of the chain
+func encodePFX(resource *certificate.Resource, certCfg CertConfig) ([]byte, error) {
+	key, err := certcrypto.ParsePEMPrivateKey(resource.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+	chain, err := certcrypto.ParsePEMBundle(resource.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("parsing certificate: %w", err)
+	}
+
+	encoder := pkcs12.Modern
+	if certCfg.PFXEncoding == PFXEncodingLegacy {
+		encoder = pkcs12.LegacyDES
+	}
+	return encoder.Encode(key, chain[0], chain[1:], certCfg.PFXPassword)
+}
+
+// joinPEM concatenates PEM blocks making sure each part ends with a newline
+func joinPEM(parts ...[]byte) []byte {
+	var buf bytes.Buffer
+	for _, part := range parts {
+		buf.Write(bytes.TrimRight(part, "\n"))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,181 @@
+package manager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// createSignedResource returns a resource with a leaf certificate signed by a
+// throwaway CA, bundled like lego does (leaf followed by issuer)
+func createSignedResource(t *testing.T) *certificate.Resource {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	issuer := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	return &certificate.Resource{
+		Domain:            "example.com",
+		PrivateKey:        pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		Certificate:       append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}), issuer...),
+		IssuerCertificate: issuer,
+	}
+}
+
+func outputFormatsConfig(t *testing.T, certCfg CertConfig) *Config {
+	t.Helper()
+	return &Config{
+		CertStoragePath: t.TempDir(),
+		AutoDomains: &AutoDomainsConfig{Certs: map[string]CertConfig{
+			"web": certCfg,
+		}},
+	}
+}
+
+func TestSaveCertificates_OutputFormats(t *testing.T) {
+	for _, encoding := range []string{"", PFXEncodingLegacy} {
+		t.Run("encoding="+encoding, func(t *testing.T) {
+			cfg := outputFormatsConfig(t, CertConfig{
+				Domains:       []string{"example.com"},
+				OutputFormats: []string{OutputFormatPFX, OutputFormatHAProxyPEM, OutputFormatFullChainOnly},
+				PFXPassword:   "s3cret",
+				PFXEncoding:   encoding,
+			})
+			resource := createSignedResource(t)
+			if err := saveCertificates(cfg, "web", resource, ""); err != nil {
+				t.Fatalf("Failed to save certificates: %v", err)
+			}
+			paths := certinfo.PathsFor(cfg.CertStoragePath, "web")
+
+			pfxData, err := os.ReadFile(paths.PFX)
+			if err != nil {
+				t.Fatalf("Expected pfx file: %v", err)
+			}
+			key, leaf, caCerts, err := pkcs12.DecodeChain(pfxData, "s3cret")
+			if err != nil {
+				t.Fatalf("Failed to decode pfx: %v", err)
+			}
+			if key == nil || leaf.Subject.CommonName != "example.com" {
+				t.Errorf("Unexpected pfx content: key=%v leaf=%s", key != nil, leaf.Subject.CommonName)
+			}
+			if len(caCerts) != 1 || caCerts[0].Subject.CommonName != "Test CA" {
+				t.Errorf("Expected issuer in pfx chain, got %d certificates", len(caCerts))
+			}
+			if info, _ := os.Stat(paths.PFX); info.Mode().Perm() != PrivateKeyPermissions {
+				t.Errorf("Expected pfx permissions %o, got %o", PrivateKeyPermissions, info.Mode().Perm())
+			}
+
+			combined, err := os.ReadFile(paths.CombinedPEM)
+			if err != nil {
+				t.Fatalf("Expected combined PEM file: %v", err)
+			}
+			var types []string
+			for rest := combined; ; {
+				var block *pem.Block
+				block, rest = pem.Decode(rest)
+				if block == nil {
+					break
+				}
+				types = append(types, block.Type)
+			}
+			if got := strings.Join(types, ","); got != "EC PRIVATE KEY,CERTIFICATE,CERTIFICATE" {
+				t.Errorf("Expected key followed by leaf and issuer, got %s", got)
+			}
+
+			fullchain, err := os.ReadFile(paths.FullChain)
+			if err != nil {
+				t.Fatalf("Expected fullchain file: %v", err)
+			}
+			if strings.Contains(string(fullchain), "PRIVATE KEY") || strings.Count(string(fullchain), "BEGIN CERTIFICATE") != 2 {
+				t.Errorf("Expected certificate and chain without key, got:\n%s", fullchain)
+			}
+		})
+	}
+}
+
+func TestSaveCertificates_NoOutputFormats(t *testing.T) {
+	cfg := outputFormatsConfig(t, CertConfig{Domains: []string{"example.com"}})
+	if err := saveCertificates(cfg, "web", createSignedResource(t), ""); err != nil {
+		t.Fatalf("Failed to save certificates: %v", err)
+	}
+	paths := certinfo.PathsFor(cfg.CertStoragePath, "web")
+	for _, path := range []string{paths.PFX, paths.CombinedPEM, paths.FullChain} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected no %s without output_formats", path)
+		}
+	}
+}
+
+func TestSaveCertificates_PFXInvalidKey(t *testing.T) {
+	cfg := outputFormatsConfig(t, CertConfig{
+		Domains:       []string{"example.com"},
+		OutputFormats: []string{OutputFormatPFX},
+	})
+	if err := saveCertificates(cfg, "web", createCompleteCertificateResource(), ""); err == nil {
+		t.Error("Expected error when the key cannot be parsed for the pfx file")
+	}
+}
+
+func TestConfig_OutputFormatsSchema(t *testing.T) {
+	base := `email: test@example.com
+acme_server: https://acme-staging-v02.api.letsencrypt.org/directory
+key_type: ec256
+acme_dns_server: https://acme-dns.example.com
+cert_storage_path: /tmp/certs
+auto_domains:
+  certs:
+    web:
+      domains: [example.com]
+`
+	if err := validateConfig([]byte(base + "      output_formats: [pfx, haproxy_pem]\n      pfx_password: x\n      pfx_encoding: legacy\n")); err != nil {
+		t.Errorf("Expected valid output formats to pass schema validation, got %v", err)
+	}
+	if err := validateConfig([]byte(base + "      output_formats: [jks]\n")); err == nil {
+		t.Error("Expected unknown output format to fail schema validation")
+	}
+}
@@ -0,0 +1,297 @@
+package manager
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/acme/api"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+)
+
+// PendingOrdersDirName is the directory below the storage path holding the
+// ACME orders that were created but whose certificate was not stored yet,
+// one file per certificate
+const PendingOrdersDirName = "pending-orders"
+
+// PendingOrder is an ACME order of a certificate whose issuance did not
+// complete, e.g. because the process was killed while waiting for the DNS
+// challenge. The next run of the certificate resumes it if the CA issued the
+// certificate already, otherwise it deactivates the pending authorizations
+// of the order before ordering again.
+type PendingOrder struct {
+	CertName   string    `json:"cert_name"`
+	OrderURL   string    `json:"order_url"`
+	AcmeServer string    `json:"acme_server"`
+	Domains    []string  `json:"domains"`
+	Created    time.Time `json:"created"`
+
+	// PrivateKey is the PEM key the CSR of the order is signed with, empty
+	// for certificates with a csr_path
+	PrivateKey string `json:"private_key,omitempty"`
+}
+
+// pendingOrderPath returns the file recording the pending order of a certificate
+func pendingOrderPath(storagePath, certName string) string {
+	return filepath.Join(storagePath, PendingOrdersDirName, certName+".json")
+}
+
+// savePendingOrder records an order before its challenges are solved
+func savePendingOrder(storagePath string, order PendingOrder) error {
+	data, err := json.MarshalIndent(order, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling pending order: %w", err)
+	}
+	path := pendingOrderPath(storagePath, order.CertName)
+	if err := os.MkdirAll(filepath.Dir(path), DirPermissions); err != nil {
+		return fmt.Errorf("creating pending orders directory: %w", err)
+	}
+	if err := writeFileAtomic(path, data, PrivateKeyPermissions); err != nil {
+		return fmt.Errorf("writing pending order %s: %w", path, err)
+	}
+	recordManifest(storagePath, path)
+	return nil
+}
+
+// LoadPendingOrder returns the pending order of a certificate, or nil if
+// there is none
+func LoadPendingOrder(storagePath, certName string) (*PendingOrder, error) {
+	path := pendingOrderPath(storagePath, certName)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading pending order: %w", err)
+	}
+	var order PendingOrder
+	if err := json.Unmarshal(data, &order); err != nil {
+		return nil, fmt.Errorf("parsing pending order %s: %w", path, err)
+	}
+	return &order, nil
+}
+
+// ListPendingOrders returns all pending orders sorted by certificate name
+func ListPendingOrders(storagePath string) ([]PendingOrder, error) {
+	entries, err := os.ReadDir(filepath.Join(storagePath, PendingOrdersDirName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading pending orders directory: %w", err)
+	}
+	var orders []PendingOrder
+	for _, e := range entries {
+		certName, ok := strings.CutSuffix(e.Name(), ".json")
+		if e.IsDir() || !ok {
+			continue
+		}
+		order, err := LoadPendingOrder(storagePath, certName)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, *order)
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].CertName < orders[j].CertName })
+	return orders, nil
+}
+
+// removePendingOrder forgets the pending order of a certificate and reports
+// whether there was one
+func removePendingOrder(storagePath, certName string) (bool, error) {
+	path := pendingOrderPath(storagePath, certName)
+	if err := os.Remove(path); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("removing pending order %s: %w", path, err)
+	}
+	recordManifest(storagePath, path)
+	return true, nil
+}
+
+// trackPendingOrder records every order the CA creates through recorder as
+// the pending order of certName, with the PEM key of its CSR. The returned
+// function ends the tracking; it forgets the order if *errp is nil, i.e. the
+// certificate was stored. After a failure the order is kept for the next
+// run, which finds it completed, invalid or with pending authorizations.
+func trackPendingOrder(cfg *Config, certName string, domains []string, keyPEM []byte, recorder *retryAfterRecorder) func(errp *error) {
+	recorder.setOrderHook(func(orderURL string) {
+		order := PendingOrder{
+			CertName:   certName,
+			OrderURL:   orderURL,
+			AcmeServer: cfg.AcmeServer,
+			Domains:    domains,
+			Created:    time.Now().UTC(),
+			PrivateKey: string(keyPEM),
+		}
+		if err := savePendingOrder(cfg.CertStoragePath, order); err != nil {
+			DefaultLogger.Warnf("Warning: cannot record order %s of certificate %s: %v", orderURL, certName, err)
+			return
+		}
+		DefaultLogger.Debugf("Recorded pending order %s of certificate %s", orderURL, certName)
+	})
+	return func(errp *error) {
+		recorder.setOrderHook(nil)
+		if *errp != nil {
+			return
+		}
+		if _, err := removePendingOrder(cfg.CertStoragePath, certName); err != nil {
+			DefaultLogger.Warnf("Warning: %v", err)
+		}
+	}
+}
+
+// resumePendingOrder handles the order an earlier run of certName left
+// behind. If the CA has issued its certificate for the requested domains, the
+// certificate is downloaded and stored and true is returned. Otherwise the
+// pending authorizations of the order are deactivated, so they do not count
+// against the limits of the CA, and the order is forgotten.
+func resumePendingOrder(ctx context.Context, cfg *Config, certName string, domains []string) (bool, error) {
+	pending, err := LoadPendingOrder(cfg.CertStoragePath, certName)
+	if err != nil || pending == nil {
+		return false, err
+	}
+	forget := func() (bool, error) {
+		_, err := removePendingOrder(cfg.CertStoragePath, certName)
+		return false, err
+	}
+	if !sameAcmeServer(pending.AcmeServer, cfg.AcmeServer) {
+		DefaultLogger.Warnf("Forgetting order %s of certificate %s, it was created with %s", pending.OrderURL, certName, pending.AcmeServer)
+		return forget()
+	}
+
+	core, err := newACMECore(ctx, cfg)
+	if err != nil {
+		return false, err
+	}
+	order, err := core.Orders.Get(pending.OrderURL)
+	if err != nil {
+		DefaultLogger.Warnf("Cannot fetch order %s of certificate %s, ordering anew: %v", pending.OrderURL, certName, err)
+		return forget()
+	}
+
+	if order.Status == acme.StatusValid && sameDomainSet(pending.Domains, domains) {
+		DefaultLogger.Infof("Resuming order %s of certificate %s created %s, the CA has issued the certificate", pending.OrderURL, certName, pending.Created.Format(time.RFC3339))
+		cert, issuer, err := core.Certificates.Get(order.Certificate, true)
+		if err != nil {
+			return false, fmt.Errorf("downloading the certificate of order %s: %w", pending.OrderURL, err)
+		}
+		resource := &certificate.Resource{
+			Domain:            domains[0],
+			CertURL:           order.Certificate,
+			CertStableURL:     order.Certificate,
+			Certificate:       cert,
+			IssuerCertificate: issuer,
+		}
+		if pending.PrivateKey != "" {
+			resource.PrivateKey = []byte(pending.PrivateKey)
+		}
+		if err := validateIssuedCertificate(certName, resource, domains, time.Now()); err != nil {
+			return false, err
+		}
+		if err := storeCertificates(cfg, certName, resource); err != nil {
+			return false, fmt.Errorf("failed to save certificate '%s': %w", certName, err)
+		}
+		if _, err := removePendingOrder(cfg.CertStoragePath, certName); err != nil {
+			DefaultLogger.Warnf("Warning: %v", err)
+		}
+		return true, nil
+	}
+
+	deactivated := deactivatePendingAuthorizations(core, order)
+	DefaultLogger.Infof("Abandoning %s order %s of certificate %s, deactivated %d pending authorization(s)", order.Status, pending.OrderURL, certName, deactivated)
+	return forget()
+}
+
+// deactivatePendingAuthorizations deactivates the authorizations of an order
+// that still wait for their challenge and returns how many it deactivated.
+// Failures are only logged, the CA expires pending authorizations anyway.
+func deactivatePendingAuthorizations(core *api.Core, order acme.ExtendedOrder) int {
+	deactivated := 0
+	for _, authzURL := range order.Authorizations {
+		authz, err := core.Authorizations.Get(authzURL)
+		if err != nil {
+			DefaultLogger.Warnf("Cannot fetch authorization %s: %v", authzURL, err)
+			continue
+		}
+		if authz.Status != acme.StatusPending {
+			continue
+		}
+		if err := core.Authorizations.Deactivate(authzURL); err != nil {
+			DefaultLogger.Warnf("Cannot deactivate authorization %s for %s: %v", authzURL, authz.Identifier.Value, err)
+			continue
+		}
+		deactivated++
+	}
+	return deactivated
+}
+
+// newACMECore returns an ACME API client signing with the registered account
+// of the configured server, for requests Lego's certifier does not offer
+func newACMECore(ctx context.Context, cfg *Config) (*api.Core, error) {
+	user, err := createOrLoadUser(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create/load ACME user: %w", err)
+	}
+	if user.Registration == nil || user.Registration.URI == "" {
+		return nil, fmt.Errorf("%w for %s", ErrNoAccount, cfg.AcmeServer)
+	}
+	httpClient := &http.Client{Timeout: cfg.HTTPTimeout}
+	if httpClient.Timeout == 0 {
+		httpClient.Timeout = DefaultHTTPTimeout
+	}
+	cfg.applyTransportSettings(httpClient)
+	httpClient.Transport = newContextTransport(ctx, cfg.debugTransport(httpClient.Transport))
+	core, err := api.New(httpClient, "", cfg.AcmeServer, user.Registration.URI, user.key)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to ACME server %s: %w", cfg.AcmeServer, err)
+	}
+	return core, nil
+}
+
+// newOrderKey returns the key an order is placed with: the reused key if
+// there is one, otherwise a fresh key of keyType. Generating it here rather
+// than in Lego lets an interrupted order be completed with its key.
+func newOrderKey(keyType string, reuseKey crypto.PrivateKey) (crypto.PrivateKey, error) {
+	if reuseKey != nil {
+		return reuseKey, nil
+	}
+	if EffectiveKeyType(keyType) == KeyTypeEd25519 {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("generating Ed25519 key: %w", err)
+		}
+		return key, nil
+	}
+	key, err := certcrypto.GeneratePrivateKey(legoKeyType(keyType))
+	if err != nil {
+		return nil, fmt.Errorf("generating %s key: %w", EffectiveKeyType(keyType), err)
+	}
+	return key, nil
+}
+
+// sameDomainSet reports whether two domain lists hold the same names
+func sameDomainSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]bool, len(a))
+	for _, d := range a {
+		set[strings.ToLower(d)] = true
+	}
+	for _, d := range b {
+		if !set[strings.ToLower(d)] {
+			return false
+		}
+	}
+	return true
+}
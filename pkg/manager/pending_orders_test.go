@@ -0,0 +1,268 @@
+package manager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/registration"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+// fakeOrderCA serves an existing order, its authorization and certificate.
+// Signatures are not checked, only the requests the resume path makes.
+type fakeOrderCA struct {
+	*httptest.Server
+	mu          sync.Mutex
+	orderStatus string
+	authzStatus string
+	chain       []byte
+	deactivated int
+}
+
+func newFakeOrderCA(t *testing.T, orderStatus string, chain []byte) *fakeOrderCA {
+	ca := &fakeOrderCA{orderStatus: orderStatus, authzStatus: "pending", chain: chain}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/directory", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   ca.URL + "/nonce",
+			"newAccount": ca.URL + "/account",
+			"newOrder":   ca.URL + "/order",
+		})
+	})
+	mux.HandleFunc("/nonce", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+	})
+	mux.HandleFunc("/order/1", func(w http.ResponseWriter, r *http.Request) {
+		ca.mu.Lock()
+		defer ca.mu.Unlock()
+		w.Header().Set("Replay-Nonce", "order-nonce")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"status":         ca.orderStatus,
+			"identifiers":    []map[string]string{{"type": "dns", "value": "example.com"}},
+			"authorizations": []string{ca.URL + "/authz/1"},
+			"finalize":       ca.URL + "/order/1/finalize",
+			"certificate":    ca.URL + "/cert/1",
+		})
+	})
+	mux.HandleFunc("/authz/1", func(w http.ResponseWriter, r *http.Request) {
+		ca.mu.Lock()
+		defer ca.mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "payload\":\"ey") {
+			// Any payload is the deactivation, POST-as-GET has none
+			ca.authzStatus = "deactivated"
+			ca.deactivated++
+		}
+		w.Header().Set("Replay-Nonce", "authz-nonce")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"status":     ca.authzStatus,
+			"identifier": map[string]string{"type": "dns", "value": "example.com"},
+		})
+	})
+	mux.HandleFunc("/cert/1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "cert-nonce")
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_, _ = w.Write(ca.chain)
+	})
+	ca.Server = httptest.NewTLSServer(mux)
+	t.Cleanup(ca.Close)
+	return ca
+}
+
+// issuedChain returns a PEM leaf for example.com with the public key of key
+// followed by the CA that signed it
+func issuedChain(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Fake CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caTemplate, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})...)
+}
+
+// setupPendingOrder registers an account with the fake CA and records its
+// order as pending for the certificate web
+func setupPendingOrder(t *testing.T, ca *fakeOrderCA, key *ecdsa.PrivateKey) *Config {
+	t.Helper()
+	cfg := &Config{Email: "test@example.com", CertStoragePath: t.TempDir(), AcmeServer: ca.URL + "/directory", InsecureSkipVerify: true}
+	user, err := createOrLoadUser(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	user.Registration = &registration.Resource{URI: ca.URL + "/acct/1"}
+	if err := saveUser(cfg, user); err != nil {
+		t.Fatalf("Failed to save user: %v", err)
+	}
+	order := PendingOrder{
+		CertName:   "web",
+		OrderURL:   ca.URL + "/order/1",
+		AcmeServer: cfg.AcmeServer,
+		Domains:    []string{"example.com"},
+		Created:    time.Now(),
+		PrivateKey: string(certcrypto.PEMEncode(key)),
+	}
+	if err := savePendingOrder(cfg.CertStoragePath, order); err != nil {
+		t.Fatalf("savePendingOrder failed: %v", err)
+	}
+	return cfg
+}
+
+func TestResumePendingOrder_Issued(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := newFakeOrderCA(t, "valid", issuedChain(t, key))
+	cfg := setupPendingOrder(t, ca, key)
+
+	resumed, err := resumePendingOrder(t.Context(), cfg, "web", []string{"example.com"})
+	if err != nil || !resumed {
+		t.Fatalf("Expected the issued certificate to be collected, got %v (%v)", resumed, err)
+	}
+	info, err := certinfo.Load(certinfo.PathsFor(cfg.CertStoragePath, "web").Certificate)
+	if err != nil || info.DNSNames[0] != "example.com" {
+		t.Fatalf("Expected the certificate of the order to be stored: %v", err)
+	}
+	keyPEM, err := os.ReadFile(certinfo.PathsFor(cfg.CertStoragePath, "web").PrivateKey)
+	if err != nil || string(keyPEM) != string(certcrypto.PEMEncode(key)) {
+		t.Errorf("Expected the key of the order to be stored: %v", err)
+	}
+	if pending, _ := LoadPendingOrder(cfg.CertStoragePath, "web"); pending != nil {
+		t.Error("Expected the pending order to be forgotten")
+	}
+}
+
+func TestResumePendingOrder_Pending(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := newFakeOrderCA(t, "pending", nil)
+	cfg := setupPendingOrder(t, ca, key)
+
+	resumed, err := resumePendingOrder(t.Context(), cfg, "web", []string{"example.com"})
+	if err != nil || resumed {
+		t.Fatalf("Expected a pending order to be abandoned, got %v (%v)", resumed, err)
+	}
+	if ca.deactivated != 1 {
+		t.Errorf("Expected the pending authorization to be deactivated, got %d deactivations", ca.deactivated)
+	}
+	if pending, _ := LoadPendingOrder(cfg.CertStoragePath, "web"); pending != nil {
+		t.Error("Expected the pending order to be forgotten")
+	}
+
+	// Nothing to do without a pending order
+	if resumed, err := resumePendingOrder(t.Context(), cfg, "web", []string{"example.com"}); err != nil || resumed {
+		t.Errorf("Expected nothing to resume, got %v (%v)", resumed, err)
+	}
+}
+
+func TestResumePendingOrder_OtherServer(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := newFakeOrderCA(t, "valid", issuedChain(t, key))
+	cfg := setupPendingOrder(t, ca, key)
+	other := *cfg
+	other.AcmeServer = "https://acme.example.org/directory"
+
+	resumed, err := resumePendingOrder(t.Context(), &other, "web", []string{"example.com"})
+	if err != nil || resumed {
+		t.Fatalf("Expected the order of another server not to be resumed, got %v (%v)", resumed, err)
+	}
+	if orders, _ := ListPendingOrders(cfg.CertStoragePath); len(orders) != 0 {
+		t.Errorf("Expected the order to be forgotten, got %+v", orders)
+	}
+}
+
+func TestTrackPendingOrder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "https://ca.example"+r.URL.Path+"/1")
+		w.WriteHeader(http.StatusCreated)
+		if r.URL.Path == "/new-order" {
+			_, _ = w.Write([]byte(`{"status":"pending","finalize":"https://ca.example/finalize/1"}`))
+		} else {
+			_, _ = w.Write([]byte(`{"status":"valid"}`))
+		}
+	}))
+	defer server.Close()
+
+	cfg := &Config{CertStoragePath: t.TempDir(), AcmeServer: "https://ca.example/directory"}
+	recorder := newRetryAfterRecorder(nil)
+	client := &http.Client{Transport: recorder}
+	finish := trackPendingOrder(cfg, "web", []string{"example.com"}, []byte("KEY"), recorder)
+
+	for _, path := range []string{"/new-account", "/new-order"} {
+		resp, err := client.Post(server.URL+path, "application/jose+json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if !strings.Contains(string(body), "status") {
+			t.Errorf("Expected the body of %s to stay readable, got %q", path, body)
+		}
+	}
+	orders, err := ListPendingOrders(cfg.CertStoragePath)
+	if err != nil || len(orders) != 1 || orders[0].OrderURL != "https://ca.example/new-order/1" || orders[0].PrivateKey != "KEY" {
+		t.Fatalf("Expected only the order to be recorded, got %+v (%v)", orders, err)
+	}
+	if last := recorder.lastOrder(); last != "https://ca.example/new-order/1" {
+		t.Errorf("Expected the recorder to remember the order for the audit log, got %q", last)
+	}
+
+	// A failed attempt keeps the order for the next run, a stored certificate forgets it
+	failed := fmt.Errorf("interrupted")
+	finish(&failed)
+	if pending, _ := LoadPendingOrder(cfg.CertStoragePath, "web"); pending == nil {
+		t.Error("Expected the order of a failed attempt to be kept")
+	}
+	finish = trackPendingOrder(cfg, "web", []string{"example.com"}, nil, recorder)
+	var stored error
+	finish(&stored)
+	if pending, _ := LoadPendingOrder(cfg.CertStoragePath, "web"); pending != nil {
+		t.Error("Expected the order to be forgotten once the certificate is stored")
+	}
+}
@@ -0,0 +1,208 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Groups of the pre-flight checks reported in PreflightCheck
+const (
+	PreflightStorage = "storage"  // The storage directory is writable
+	PreflightACME    = "acme"     // The ACME directory resolves and answers
+	PreflightAcmeDNS = "acme-dns" // The acme-dns server resolves and is healthy
+)
+
+// PreflightCheck is the outcome of one pre-flight check
+type PreflightCheck struct {
+	Group  string
+	Target string // Path or URL that was checked
+	Note   string // Additional information on success
+	Err    error
+}
+
+// Preflight checks everything a run needs besides a valid configuration,
+// without registering accounts or ordering certificates: the storage
+// directory must be writable, every ACME server must serve a directory and
+// every acme-dns server must answer its health check. All checks are run,
+// the result has one entry per storage path and server.
+func Preflight(ctx context.Context, cfg *Config) []PreflightCheck {
+	timeout := cfg.HTTPTimeout
+	if timeout <= 0 {
+		timeout = DefaultHTTPTimeout
+	}
+	client := &http.Client{Timeout: timeout, Transport: cfg.httpTransport()}
+
+	// A passing check proves less without certificate verification
+	note := ""
+	if cfg.InsecureSkipVerify {
+		note = "TLS certificate NOT verified, insecure_skip_verify is set"
+	}
+
+	checks := []PreflightCheck{checkStorageWritable(cfg.CertStoragePath)}
+	for _, server := range preflightACMEServers(cfg) {
+		check := PreflightCheck{Group: PreflightACME, Target: server, Note: note}
+		check.Err = checkACMEDirectory(ctx, client, server)
+		checks = append(checks, check)
+	}
+	for _, server := range preflightAcmeDNSServers(cfg) {
+		check := PreflightCheck{Group: PreflightAcmeDNS, Target: server, Note: note}
+		check.Err = checkAcmeDNSHealth(ctx, client, server)
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// preflightACMEServers returns the global and per-certificate ACME servers
+func preflightACMEServers(cfg *Config) []string {
+	servers := map[string]bool{cfg.AcmeServer: true}
+	if cfg.AutoDomains != nil {
+		for name := range cfg.AutoDomains.Certs {
+			servers[cfg.ForCert(name).AcmeServer] = true
+		}
+	}
+	return sortedNonEmpty(servers)
+}
+
+// preflightAcmeDNSServers returns the global and per-suffix acme-dns servers
+func preflightAcmeDNSServers(cfg *Config) []string {
+	servers := map[string]bool{cfg.AcmeDnsServer: true}
+	for _, server := range cfg.AcmeDnsServers {
+		servers[server] = true
+	}
+	return sortedNonEmpty(servers)
+}
+
+func sortedNonEmpty(set map[string]bool) []string {
+	var list []string
+	for entry := range set {
+		if entry != "" {
+			list = append(list, entry)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// checkStorageWritable creates and removes a file in the storage directory,
+// or in its closest existing parent if it does not exist yet
+func checkStorageWritable(path string) PreflightCheck {
+	check := PreflightCheck{Group: PreflightStorage, Target: path}
+	dir := path
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				check.Err = fmt.Errorf("%s is not a directory", dir)
+				return check
+			}
+			break
+		}
+		// A file on the path is reported when the walk reaches it
+		if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, syscall.ENOTDIR) {
+			check.Err = err
+			return check
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			check.Err = err
+			return check
+		}
+		dir = parent
+	}
+	if dir != path {
+		check.Note = fmt.Sprintf("does not exist yet, will be created in %s", dir)
+	}
+
+	file, err := os.CreateTemp(dir, ".preflight-*")
+	if err != nil {
+		check.Err = fmt.Errorf("not writable: %w", err)
+		return check
+	}
+	_ = file.Close()
+	if err := os.Remove(file.Name()); err != nil {
+		check.Err = err
+	}
+	return check
+}
+
+// resolveHost looks up the host of a server URL, so that a typo in the name
+// is reported as such rather than as a connection error
+func resolveHost(ctx context.Context, server string) (*url.URL, error) {
+	u, err := url.Parse(server)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q", server)
+	}
+	if net.ParseIP(u.Hostname()) != nil {
+		return u, nil
+	}
+	if _, err := net.DefaultResolver.LookupHost(ctx, u.Hostname()); err != nil {
+		return nil, fmt.Errorf("cannot resolve %s: %w", u.Hostname(), err)
+	}
+	return u, nil
+}
+
+// checkACMEDirectory fetches the ACME directory and checks the endpoints
+// needed to order a certificate
+func checkACMEDirectory(ctx context.Context, client *http.Client, server string) error {
+	if _, err := resolveHost(ctx, server); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("directory request returned %s", resp.Status)
+	}
+	var directory map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&directory); err != nil {
+		return fmt.Errorf("not an ACME directory: %w", err)
+	}
+	var missing []string
+	for _, endpoint := range []string{"newNonce", "newAccount", "newOrder"} {
+		if _, ok := directory[endpoint].(string); !ok {
+			missing = append(missing, endpoint)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("not an ACME directory, missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// checkAcmeDNSHealth calls the /health endpoint of an acme-dns server
+func checkAcmeDNSHealth(ctx context.Context, client *http.Client, server string) error {
+	u, err := resolveHost(ctx, server)
+	if err != nil {
+		return err
+	}
+	healthURL := u.JoinPath("health").String()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check %s returned %s", healthURL, resp.Status)
+	}
+	return nil
+}
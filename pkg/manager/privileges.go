@@ -0,0 +1,106 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+)
+
+// processIdentity is the user and groups run_as_user and run_as_group
+// resolve to
+type processIdentity struct {
+	uid    int
+	gid    int
+	groups []int // Supplementary groups
+}
+
+// runAsIdentity resolves run_as_user and run_as_group, nil if neither is
+// set. Without run_as_group the primary group of the user is used, and a
+// named user keeps its supplementary groups. run_as_group alone is refused
+// for root, the process would keep running as uid 0.
+func (cfg *Config) runAsIdentity() (*processIdentity, error) {
+	if cfg.RunAsUser == "" && cfg.RunAsGroup == "" {
+		return nil, nil
+	}
+	if cfg.RunAsUser == "" && os.Getuid() == 0 {
+		return nil, fmt.Errorf("run_as_group %q without run_as_user keeps the manager running as root, set run_as_user as well", cfg.RunAsGroup)
+	}
+	id := &processIdentity{uid: os.Getuid(), gid: -1}
+	if cfg.RunAsUser != "" {
+		uid, err := lookupUser("run_as_user", cfg.RunAsUser)
+		if err != nil {
+			return nil, err
+		}
+		id.uid = uid
+		id.gid = primaryGroup(uid)
+		if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+			if ids, err := u.GroupIds(); err == nil {
+				for _, g := range ids {
+					if gid, err := strconv.Atoi(g); err == nil {
+						id.groups = append(id.groups, gid)
+					}
+				}
+			}
+		}
+	}
+	if cfg.RunAsGroup != "" {
+		gid, err := lookupGroup("run_as_group", cfg.RunAsGroup)
+		if err != nil {
+			return nil, err
+		}
+		id.gid = gid
+	}
+	if id.gid < 0 {
+		return nil, fmt.Errorf("run_as_user %q has no entry in the user database, set run_as_group as well", cfg.RunAsUser)
+	}
+	if len(id.groups) == 0 {
+		id.groups = []int{id.gid}
+	}
+	return id, nil
+}
+
+// validateRunAs resolves run_as_user and run_as_group and checks that the
+// switched process can still give files the owner and group of
+// file_permissions: without root, chown keeps the user and only changes to
+// groups the user is a member of.
+func (cfg *Config) validateRunAs() error {
+	id, err := cfg.runAsIdentity()
+	if err != nil || id == nil {
+		return err
+	}
+	policy, err := cfg.filePolicy()
+	if err != nil || policy == nil {
+		return err
+	}
+	uid, gid := policy.owner()
+	if uid >= 0 && uid != id.uid {
+		return fmt.Errorf("file_permissions.owner %q differs from run_as_user %q, files cannot be given to another user after switching", cfg.FilePermissions.Owner, cfg.RunAsUser)
+	}
+	if gid >= 0 && gid != id.gid && !slices.Contains(id.groups, gid) {
+		return fmt.Errorf("file_permissions.group %q is not a group of run_as_user %q, files cannot be given to it after switching", cfg.FilePermissions.Group, cfg.RunAsUser)
+	}
+	return nil
+}
+
+// DropPrivileges switches the process to run_as_user and run_as_group. It
+// reports false without changing anything if neither is set or the process
+// already runs as that user and group, so the same configuration works for
+// a manager started as the service user directly. Switching needs root.
+func DropPrivileges(cfg *Config) (bool, error) {
+	id, err := cfg.runAsIdentity()
+	if err != nil || id == nil {
+		return false, err
+	}
+	if os.Geteuid() == id.uid && os.Getegid() == id.gid {
+		return false, nil
+	}
+	if os.Geteuid() != 0 {
+		return false, fmt.Errorf("switching to uid %d and gid %d needs root, the manager runs as uid %d", id.uid, id.gid, os.Geteuid())
+	}
+	if err := setProcessIdentity(id); err != nil {
+		return false, fmt.Errorf("switching to uid %d and gid %d: %w", id.uid, id.gid, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,107 @@
+//go:build !windows
+
+package manager
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestConfig_RunAsIdentity(t *testing.T) {
+	if id, err := (&Config{}).runAsIdentity(); id != nil || err != nil {
+		t.Errorf("Expected no identity without run_as_user, got %+v, %v", id, err)
+	}
+
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("No user database entry for the current user: %v", err)
+	}
+	id, err := (&Config{RunAsUser: current.Username}).runAsIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strconv.Itoa(id.uid) != current.Uid || strconv.Itoa(id.gid) != current.Gid || len(id.groups) == 0 {
+		t.Errorf("Expected uid %s and primary gid %s, got %+v", current.Uid, current.Gid, id)
+	}
+
+	id, err = (&Config{RunAsUser: current.Uid, RunAsGroup: "4242"}).runAsIdentity()
+	if err != nil || id.gid != 4242 {
+		t.Errorf("Expected run_as_group to override the primary group, got %+v, %v", id, err)
+	}
+
+	if _, err := (&Config{RunAsUser: "no-such-user-acme-test"}).runAsIdentity(); err == nil {
+		t.Error("Expected an error for an unknown user")
+	}
+	if _, err := (&Config{RunAsUser: "4243", RunAsGroup: "no-such-group-acme-test"}).runAsIdentity(); err == nil {
+		t.Error("Expected an error for an unknown group")
+	}
+	if _, err := (&Config{RunAsGroup: "4242"}).runAsIdentity(); (err != nil) != (os.Getuid() == 0) {
+		t.Errorf("Expected run_as_group alone to be refused only for root, got %v", err)
+	}
+}
+
+func TestConfig_ValidateRunAs(t *testing.T) {
+	uid, gid := strconv.Itoa(os.Getuid()), strconv.Itoa(os.Getgid())
+	for _, tc := range []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"no run_as_user", Config{FilePermissions: &FilePermissionsConfig{Owner: "4243"}}, false},
+		{"same owner", Config{RunAsUser: uid, RunAsGroup: gid, FilePermissions: &FilePermissionsConfig{Owner: uid, Group: gid}}, false},
+		{"other owner", Config{RunAsUser: uid, RunAsGroup: gid, FilePermissions: &FilePermissionsConfig{Owner: "4243"}}, true},
+		{"foreign group", Config{RunAsUser: "4243", RunAsGroup: "4243", FilePermissions: &FilePermissionsConfig{Group: "4244"}}, true},
+	} {
+		if err := tc.cfg.validateRunAs(); (err != nil) != tc.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.wantErr, err)
+		}
+	}
+}
+
+func TestDropPrivileges_CurrentUser(t *testing.T) {
+	cfg := &Config{RunAsUser: strconv.Itoa(os.Geteuid()), RunAsGroup: strconv.Itoa(os.Getegid())}
+	dropped, err := DropPrivileges(cfg)
+	if err != nil || dropped {
+		t.Errorf("Expected no change when already running as run_as_user, got %v, %v", dropped, err)
+	}
+	if dropped, err := DropPrivileges(&Config{}); err != nil || dropped {
+		t.Errorf("Expected no change without run_as_user, got %v, %v", dropped, err)
+	}
+}
+
+// TestDropPrivileges_Switches drops to nobody in a child process, the test
+// process itself must keep its privileges
+func TestDropPrivileges_Switches(t *testing.T) {
+	if os.Getenv("ACME_TEST_DROP_PRIVILEGES") != "" {
+		if _, err := DropPrivileges(&Config{RunAsUser: "nobody"}); err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		// Regaining root must fail once the privileges are gone
+		_, err := DropPrivileges(&Config{RunAsUser: "0", RunAsGroup: "0"})
+		fmt.Printf("uid=%d regain=%v\n", os.Geteuid(), err != nil)
+		os.Exit(0)
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("switching users needs root")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("no user nobody")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDropPrivileges_Switches$")
+	cmd.Env = append(os.Environ(), "ACME_TEST_DROP_PRIVILEGES=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Child process failed: %v\n%s", err, out)
+	}
+	if want := fmt.Sprintf("uid=%s regain=true", nobody.Uid); !strings.Contains(string(out), want) {
+		t.Errorf("Expected %q, got:\n%s", want, out)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package manager
+
+import "syscall"
+
+// setProcessIdentity changes the groups before the user, the other way
+// around the process would lack the right to change them. Go applies the
+// changes to all threads of the process.
+func setProcessIdentity(id *processIdentity) error {
+	if err := syscall.Setgroups(id.groups); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(id.gid); err != nil {
+		return err
+	}
+	return syscall.Setuid(id.uid)
+}
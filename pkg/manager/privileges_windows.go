@@ -0,0 +1,11 @@
+//go:build windows
+
+package manager
+
+import "errors"
+
+// setProcessIdentity is not available on Windows, run the service under
+// the account it should use instead
+func setProcessIdentity(id *processIdentity) error {
+	return errors.New("run_as_user and run_as_group are not supported on Windows")
+}
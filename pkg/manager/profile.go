@@ -0,0 +1,49 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+)
+
+// checkProfileSupported verifies that the ACME server offers the requested
+// certificate profile. The profiles are advertised in the meta object of the
+// directory (draft-aaron-acme-profiles). Without the check a typo would only
+// surface as a malformed order rejected after the DNS challenge setup.
+func checkProfileSupported(ctx context.Context, cfg *Config, profile string) error {
+	httpClient := &http.Client{Timeout: cfg.HTTPTimeout, Transport: newContextTransport(ctx, cfg.httpTransport())}
+	if httpClient.Timeout == 0 {
+		httpClient.Timeout = DefaultHTTPTimeout
+	}
+	dir, err := fetchDirectory(httpClient, cfg.AcmeServer)
+	if err != nil {
+		return err
+	}
+	return profileSupported(dir.Meta.Profiles, cfg.AcmeServer, profile)
+}
+
+// profileSupported checks profile against the profiles of a directory
+func profileSupported(profiles map[string]string, acmeServer, profile string) error {
+	if _, ok := profiles[profile]; ok {
+		return nil
+	}
+	if len(profiles) == 0 {
+		return common.NewConfigError("check ACME profile",
+			fmt.Sprintf("ACME server %s does not support certificate profiles", acmeServer)).
+			AddContext("profile", profile).
+			AddSuggestion("Remove the profile setting or use an ACME server that offers profiles")
+	}
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return common.NewConfigError("check ACME profile",
+		fmt.Sprintf("ACME server %s does not offer profile %q", acmeServer, profile)).
+		AddContext("profile", profile).
+		AddContext("available_profiles", strings.Join(names, ", "))
+}
@@ -0,0 +1,71 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+)
+
+func TestProfileFor(t *testing.T) {
+	cfg := &Config{
+		Profile: "tlsserver",
+		AutoDomains: &AutoDomainsConfig{Certs: map[string]CertConfig{
+			"short":   {Domains: []string{"a.example.com"}, Profile: "shortlived"},
+			"default": {Domains: []string{"b.example.com"}},
+		}},
+	}
+	tests := map[string]string{"short": "shortlived", "default": "tlsserver", "manual": "tlsserver"}
+	for certName, want := range tests {
+		if got := cfg.ProfileFor(certName); got != want {
+			t.Errorf("ProfileFor(%q): expected %q, got %q", certName, want, got)
+		}
+	}
+	if got := (&Config{}).ProfileFor("any"); got != "" {
+		t.Errorf("Expected no profile without configuration, got %q", got)
+	}
+}
+
+func TestProfileSupported(t *testing.T) {
+	profiles := map[string]string{"classic": "The same profile as always", "shortlived": "6 days"}
+
+	if err := profileSupported(profiles, "https://ca.example.com/dir", "shortlived"); err != nil {
+		t.Errorf("Expected offered profile to pass, got %v", err)
+	}
+
+	err := profileSupported(profiles, "https://ca.example.com/dir", "tlsserver")
+	appErr := common.GetApplicationError(err)
+	if appErr == nil || appErr.Type != common.ErrorTypeConfig {
+		t.Fatalf("Expected config error, got %v", err)
+	}
+	if appErr.Context["available_profiles"] != "classic, shortlived" {
+		t.Errorf("Expected available profiles listed, got %v", appErr.Context["available_profiles"])
+	}
+
+	err = profileSupported(nil, "https://ca.example.com/dir", "shortlived")
+	if err == nil || !strings.Contains(err.Error(), "does not support certificate profiles") {
+		t.Errorf("Expected unsupported profiles error, got %v", err)
+	}
+}
+
+func TestCheckProfileSupported(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dir := acme.Directory{NewOrderURL: "http://" + r.Host + "/new-order"}
+		dir.Meta.Profiles = map[string]string{"tlsserver": "TLS server certificates"}
+		_ = json.NewEncoder(w).Encode(dir)
+	}))
+	defer server.Close()
+	cfg := &Config{AcmeServer: server.URL + "/directory"}
+
+	if err := checkProfileSupported(context.Background(), cfg, "tlsserver"); err != nil {
+		t.Errorf("Expected advertised profile to pass, got %v", err)
+	}
+	if err := checkProfileSupported(context.Background(), cfg, "shortlived"); err == nil {
+		t.Error("Expected error for profile missing from directory")
+	}
+}
@@ -0,0 +1,48 @@
+package manager
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// validateProxyURL checks proxy_url: an http, https or socks5 URL with host,
+// user and password in it authenticate CONNECT requests
+func validateProxyURL(proxyURL string) error {
+	if proxyURL == "" {
+		return nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return fmt.Errorf("proxy_url: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("proxy_url %q: scheme must be http, https or socks5", u.Redacted())
+	}
+	if u.Host == "" {
+		return fmt.Errorf("proxy_url %q: missing proxy host", u.Redacted())
+	}
+	return nil
+}
+
+// proxyFunc selects the proxy of the ACME and acme-dns clients: proxy_url
+// for every host not excluded by NO_PROXY, without proxy_url the usual
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables
+func (cfg *Config) proxyFunc() func(*http.Request) (*url.URL, error) {
+	if cfg.ProxyURL == "" {
+		return http.ProxyFromEnvironment
+	}
+	proxyConfig := &httpproxy.Config{
+		HTTPProxy:  cfg.ProxyURL,
+		HTTPSProxy: cfg.ProxyURL,
+		NoProxy:    httpproxy.FromEnvironment().NoProxy,
+	}
+	proxy := proxyConfig.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+}
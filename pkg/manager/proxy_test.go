@@ -0,0 +1,125 @@
+package manager
+
+import (
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// connectProxy tunnels CONNECT requests carrying the expected
+// Proxy-Authorization to the test server and counts them
+type connectProxy struct {
+	auth     string
+	upstream string // Address every tunnel is connected to
+	tunnels  atomic.Int32
+}
+
+func (p *connectProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		http.Error(w, "only CONNECT", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("Proxy-Authorization") != p.auth {
+		w.WriteHeader(http.StatusProxyAuthRequired)
+		return
+	}
+	upstream, err := net.Dial("tcp", p.upstream)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		_ = upstream.Close()
+		return
+	}
+	p.tunnels.Add(1)
+	_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	go func() {
+		_, _ = io.Copy(upstream, conn)
+		_ = upstream.Close()
+	}()
+	_, _ = io.Copy(conn, upstream)
+	_ = conn.Close()
+}
+
+func TestProxyURL(t *testing.T) {
+	t.Setenv("NO_PROXY", "internal.example.com")
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "directory")
+	}))
+	defer server.Close()
+	proxy := &connectProxy{
+		auth:     "Basic " + base64.StdEncoding.EncodeToString([]byte("user:s3cret")),
+		upstream: server.Listener.Addr().String(),
+	}
+	proxyServer := httptest.NewServer(proxy)
+	defer proxyServer.Close()
+
+	// Loopback addresses are never proxied, the test certificate is valid
+	// for example.com, which the proxy connects to the test server
+	get := func(user string) (*http.Response, error) {
+		proxyURL, _ := url.Parse(proxyServer.URL)
+		proxyURL.User = url.UserPassword(user, "s3cret")
+		cfg := &Config{ProxyURL: proxyURL.String()}
+		transport := cfg.baseTransport().(*http.Transport).Clone()
+		transport.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+		return (&http.Client{Transport: transport}).Get("https://example.com/directory")
+	}
+
+	resp, err := get("user")
+	if err != nil {
+		t.Fatalf("Request through the proxy failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "directory" || proxy.tunnels.Load() != 1 {
+		t.Errorf("Expected the response through one tunnel, got %q and %d tunnels", body, proxy.tunnels.Load())
+	}
+
+	if _, err := get("wrong"); err == nil || !strings.Contains(err.Error(), "Proxy Authentication Required") {
+		t.Errorf("Expected the proxy to reject wrong credentials, got %v", err)
+	}
+
+	// NO_PROXY hosts are reached directly
+	cfg := &Config{ProxyURL: "http://proxy.example.com:3128"}
+	for target, want := range map[string]string{
+		"https://acme-v02.api.letsencrypt.org/directory": "http://proxy.example.com:3128",
+		"https://internal.example.com/directory":         "",
+	} {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		got, err := cfg.proxyFunc()(req)
+		if err != nil || (got == nil && want != "") || (got != nil && got.String() != want) {
+			t.Errorf("Proxy for %s: got %v (%v), want %q", target, got, err, want)
+		}
+	}
+
+	// Lego's own transport keeps its settings and gets the proxy
+	legoTransport := &http.Transport{}
+	cfg.applyTransportSettings(&http.Client{Transport: legoTransport})
+	if legoTransport.Proxy == nil {
+		t.Error("Expected proxy_url to be applied to the Lego transport")
+	}
+}
+
+func TestValidateProxyURL(t *testing.T) {
+	for proxyURL, valid := range map[string]bool{
+		"":                                  true,
+		"http://proxy.example.com:3128":     true,
+		"https://user:pw@proxy.example.com": true,
+		"socks5://127.0.0.1:1080":           true,
+		"proxy.example.com:3128":            false,
+		"ftp://proxy.example.com":           false,
+		"http://":                           false,
+	} {
+		if err := validateProxyURL(proxyURL); (err == nil) != valid {
+			t.Errorf("validateProxyURL(%q) = %v, want valid %v", proxyURL, err, valid)
+		}
+	}
+}
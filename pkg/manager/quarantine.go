@@ -0,0 +1,152 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+// FailedDirName is the directory below the storage path receiving quarantined artifacts
+const FailedDirName = "failed"
+
+// quarantineTimeFormat is the UTC timestamp suffix of quarantine directories
+const quarantineTimeFormat = "20060102T150405Z"
+
+// QuarantineResult describes artifacts moved out of the certificates directory
+type QuarantineResult struct {
+	Dir   string   // Directory the files were moved to
+	Files []string // Original paths of the moved files
+}
+
+// IsPartialArtifact reports whether files of a certificate exist although the
+// certificate or its metadata is missing. Such leftovers come from an interrupted
+// or failed issuance, e.g. a private key that was written without its certificate.
+func IsPartialArtifact(cfg *Config, certName string) bool {
+	paths := certinfo.PathsFor(cfg.CertStoragePath, certName)
+	exists := func(p string) bool {
+		_, err := os.Stat(p)
+		return err == nil
+	}
+
+	hasCert, hasMeta := exists(paths.Certificate), exists(paths.Metadata)
+	if hasCert && hasMeta {
+		return false
+	}
+	return hasCert || hasMeta || exists(paths.PrivateKey) || exists(paths.Issuer)
+}
+
+// QuarantinePartialArtifacts moves the files of an incomplete certificate into
+// '<cert_storage_path>/failed/<certName>-<timestamp>/' so they cannot confuse the
+// next run. It returns nil if the certificate is complete or does not exist at all.
+func QuarantinePartialArtifacts(cfg *Config, certName string) (*QuarantineResult, error) {
+	if !IsPartialArtifact(cfg, certName) {
+		return nil, nil
+	}
+	return QuarantineArtifacts(cfg, certName)
+}
+
+// QuarantineArtifacts unconditionally moves all existing files of a certificate
+// into a timestamped directory below '<cert_storage_path>/failed/'.
+func QuarantineArtifacts(cfg *Config, certName string) (*QuarantineResult, error) {
+	paths := certinfo.PathsFor(cfg.CertStoragePath, certName)
+	// A directory per call, even if a certificate is quarantined twice within a second
+	now := time.Now().UTC()
+	dir := filepath.Join(cfg.CertStoragePath, FailedDirName, certName+"-"+now.Format(quarantineTimeFormat))
+	for {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			break
+		}
+		now = now.Add(time.Second)
+		dir = filepath.Join(cfg.CertStoragePath, FailedDirName, certName+"-"+now.Format(quarantineTimeFormat))
+	}
+
+	result := &QuarantineResult{Dir: dir}
+	for _, src := range certificateFiles(paths) {
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return result, fmt.Errorf("checking %s: %w", src, err)
+		}
+
+		if len(result.Files) == 0 {
+			if err := os.MkdirAll(dir, DirPermissions); err != nil {
+				return result, fmt.Errorf("creating quarantine directory %s: %w", dir, err)
+			}
+		}
+
+		dst := filepath.Join(dir, filepath.Base(src))
+		if err := os.Rename(src, dst); err != nil {
+			return result, fmt.Errorf("moving %s to %s: %w", src, dst, err)
+		}
+		result.Files = append(result.Files, src)
+	}
+
+	if len(result.Files) == 0 {
+		return nil, nil
+	}
+	recordManifest(cfg.CertStoragePath, result.Files...)
+	// A remote backend must not hand the files back on the next run
+	if err := publishStorageFiles(cfg, result.Files...); err != nil {
+		return result, fmt.Errorf("removing quarantined files from storage backend: %w", err)
+	}
+	return result, nil
+}
+
+// reportQuarantine logs what was moved into the quarantine directory
+func reportQuarantine(certName string, result *QuarantineResult) {
+	if result == nil {
+		return
+	}
+	DefaultLogger.Warnf("Quarantined %d incomplete file(s) of certificate %s to %s:", len(result.Files), certName, result.Dir)
+	for _, f := range result.Files {
+		DefaultLogger.Warnf("    %s", filepath.Base(f))
+	}
+}
+
+// QuarantineEntry describes one directory below '<cert_storage_path>/failed/'
+type QuarantineEntry struct {
+	CertName string
+	Time     time.Time
+	Dir      string
+}
+
+// ListQuarantined returns the quarantined artifact directories, oldest first.
+// Directories not following the '<certName>-<timestamp>' naming are skipped.
+func ListQuarantined(cfg *Config) ([]QuarantineEntry, error) {
+	failedDir := filepath.Join(cfg.CertStoragePath, FailedDirName)
+	dirEntries, err := os.ReadDir(failedDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading quarantine directory: %w", err)
+	}
+
+	var entries []QuarantineEntry
+	for _, e := range dirEntries {
+		if !e.IsDir() {
+			continue
+		}
+		idx := strings.LastIndex(e.Name(), "-")
+		if idx <= 0 {
+			continue
+		}
+		stamp, err := time.Parse(quarantineTimeFormat, e.Name()[idx+1:])
+		if err != nil {
+			continue
+		}
+		entries = append(entries, QuarantineEntry{
+			CertName: e.Name()[:idx],
+			Time:     stamp,
+			Dir:      filepath.Join(failedDir, e.Name()),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries, nil
+}
@@ -0,0 +1,110 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, DirPermissions); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("data"), PrivateKeyPermissions); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestIsPartialArtifact(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		partial bool
+	}{
+		{"nothing stored", nil, false},
+		{"complete", []string{"c.crt", "c.key", "c.json"}, false},
+		{"complete without key", []string{"c.crt", "c.json"}, false},
+		{"key only", []string{"c.key"}, true},
+		{"cert and key without metadata", []string{"c.crt", "c.key"}, true},
+		{"metadata only", []string{"c.json"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{CertStoragePath: t.TempDir()}
+			writeTestFiles(t, filepath.Join(cfg.CertStoragePath, "certificates"), tt.files...)
+			if got := IsPartialArtifact(cfg, "c"); got != tt.partial {
+				t.Errorf("IsPartialArtifact() = %v, want %v", got, tt.partial)
+			}
+		})
+	}
+}
+
+func TestQuarantinePartialArtifacts(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir()}
+	certsDir := filepath.Join(cfg.CertStoragePath, "certificates")
+	writeTestFiles(t, certsDir, "web.key", "web.issuer.crt", "other.crt", "other.json")
+
+	result, err := QuarantinePartialArtifacts(cfg, "web")
+	if err != nil {
+		t.Fatalf("QuarantinePartialArtifacts failed: %v", err)
+	}
+	if result == nil || len(result.Files) != 2 {
+		t.Fatalf("Expected 2 quarantined files, got %+v", result)
+	}
+	if !strings.HasPrefix(result.Dir, filepath.Join(cfg.CertStoragePath, FailedDirName, "web-")) {
+		t.Errorf("Unexpected quarantine directory %s", result.Dir)
+	}
+
+	for _, name := range []string{"web.key", "web.issuer.crt"} {
+		if _, err := os.Stat(filepath.Join(certsDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be moved out of the certificates directory", name)
+		}
+		if _, err := os.Stat(filepath.Join(result.Dir, name)); err != nil {
+			t.Errorf("Expected %s in quarantine directory: %v", name, err)
+		}
+	}
+
+	// Complete certificates are left alone
+	result, err = QuarantinePartialArtifacts(cfg, "other")
+	if err != nil || result != nil {
+		t.Errorf("Expected complete certificate to stay in place, got %+v, %v", result, err)
+	}
+	if _, err := os.Stat(filepath.Join(certsDir, "other.crt")); err != nil {
+		t.Errorf("Complete certificate was moved: %v", err)
+	}
+}
+
+func TestListQuarantined(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir()}
+
+	entries, err := ListQuarantined(cfg)
+	if err != nil || entries != nil {
+		t.Fatalf("Expected no entries without failed directory, got %v, %v", entries, err)
+	}
+
+	failedDir := filepath.Join(cfg.CertStoragePath, FailedDirName)
+	for _, name := range []string{"my-cert-20250102T030405Z", "web-20240101T000000Z", "garbage", "web-notatime"} {
+		if err := os.MkdirAll(filepath.Join(failedDir, name), 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err = ListQuarantined(cfg)
+	if err != nil {
+		t.Fatalf("ListQuarantined failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d: %v", len(entries), entries)
+	}
+	if entries[0].CertName != "web" || entries[1].CertName != "my-cert" {
+		t.Errorf("Expected entries sorted oldest first, got %s, %s", entries[0].CertName, entries[1].CertName)
+	}
+	if entries[1].Time.Year() != 2025 || entries[1].Time.Hour() != 3 {
+		t.Errorf("Unexpected timestamp: %v", entries[1].Time)
+	}
+}
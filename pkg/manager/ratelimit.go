@@ -0,0 +1,264 @@
+package manager
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+)
+
+// Issuance limits of Let's Encrypt, see https://letsencrypt.org/docs/rate-limits/
+const (
+	RateLimitWindow             = 7 * 24 * time.Hour // Sliding window of both limits
+	CertsPerRegisteredDomain    = 50                 // New certificates per registered domain
+	DuplicateCertificateLimit   = 5                  // Certificates per exact set of domains
+	rateBudgetWarningPercentage = 80                 // Warn once this much of a limit is used
+)
+
+// Names of the rate limits, as used in the budget and in parsed CA errors
+const (
+	RateLimitRegisteredDomain = "certificates-per-registered-domain"
+	RateLimitDuplicate        = "duplicate-certificate"
+)
+
+// Issuance is a certificate this installation obtained, kept in the state
+// file for RateLimitWindow to estimate the remaining rate limit budget
+type Issuance struct {
+	CertName   string    `json:"cert_name"`
+	Domains    []string  `json:"domains"`
+	AcmeServer string    `json:"acme_server"`
+	Time       time.Time `json:"time"`
+}
+
+// RecordIssuance adds an issued certificate to the state file and drops
+// issuances older than RateLimitWindow
+func RecordIssuance(storagePath string, issuance Issuance) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	state, err := LoadState(storagePath)
+	if err != nil {
+		return err
+	}
+	state.Issuances = append(recentIssuances(state.Issuances, issuance.Time), issuance)
+	return saveState(storagePath, state)
+}
+
+// recentIssuances returns the issuances within RateLimitWindow before now
+func recentIssuances(issuances []Issuance, now time.Time) []Issuance {
+	var recent []Issuance
+	for _, issuance := range issuances {
+		if now.Sub(issuance.Time) < RateLimitWindow {
+			recent = append(recent, issuance)
+		}
+	}
+	return recent
+}
+
+// enforcesLetsEncryptLimits reports whether an ACME directory is the
+// production service of Let's Encrypt. Staging has far higher limits and
+// other CAs publish their own.
+func enforcesLetsEncryptLimits(acmeServer string) bool {
+	u, err := url.Parse(acmeServer)
+	if err != nil || IsStagingServer(acmeServer) {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return host == "letsencrypt.org" || strings.HasSuffix(host, ".letsencrypt.org")
+}
+
+// RateBudgetUse is how much of one rate limit the issuances recorded in the
+// state file have used
+type RateBudgetUse struct {
+	Limit   string    // RateLimitRegisteredDomain or RateLimitDuplicate
+	Subject string    // Registered domain or the domain set
+	Used    int       // Certificates issued within RateLimitWindow
+	Max     int       // Size of the limit
+	ResetAt time.Time // When the oldest counted issuance leaves the window
+}
+
+// Exceeded reports whether one more certificate would exceed the limit
+func (u RateBudgetUse) Exceeded() bool {
+	return u.Used+1 > u.Max
+}
+
+// String describes the use of the limit in one line
+func (u RateBudgetUse) String() string {
+	return fmt.Sprintf("%d of %d certificates for %s in the last %s (%s), the oldest leaves the window at %s",
+		u.Used, u.Max, u.Subject, RateLimitWindow, u.Limit, u.ResetAt.Format(time.RFC3339))
+}
+
+// RateBudget returns the limits an order of domains for certName would
+// draw on and how much of them is used, the nearly exhausted ones only. It
+// only knows the certificates this storage directory obtained, other hosts
+// issuing for the same domains use the limits as well. Renewals of the same
+// domain set are exempt from the registered domain limit.
+func RateBudget(cfg *Config, certName string, domains []string, now time.Time) ([]RateBudgetUse, error) {
+	if !enforcesLetsEncryptLimits(cfg.AcmeServer) {
+		return nil, nil
+	}
+	state, err := LoadState(cfg.CertStoragePath)
+	if err != nil {
+		return nil, err
+	}
+	var issuances []Issuance
+	for _, issuance := range recentIssuances(state.Issuances, now) {
+		if enforcesLetsEncryptLimits(issuance.AcmeServer) {
+			issuances = append(issuances, issuance)
+		}
+	}
+
+	var uses []RateBudgetUse
+	add := func(limit, subject string, max int, counted []Issuance) {
+		if len(counted)*100 < max*rateBudgetWarningPercentage && len(counted)+1 <= max {
+			return
+		}
+		oldest := counted[0].Time
+		for _, issuance := range counted[1:] {
+			if issuance.Time.Before(oldest) {
+				oldest = issuance.Time
+			}
+		}
+		uses = append(uses, RateBudgetUse{Limit: limit, Subject: subject, Used: len(counted), Max: max, ResetAt: oldest.Add(RateLimitWindow)})
+	}
+
+	var duplicates []Issuance
+	for _, issuance := range issuances {
+		if sameDomainSet(issuance.Domains, domains) {
+			duplicates = append(duplicates, issuance)
+		}
+	}
+	add(RateLimitDuplicate, DisplayDomains(domains), DuplicateCertificateLimit, duplicates)
+	if len(duplicates) > 0 || storedCertificateCovers(cfg, certName, domains) {
+		return uses, nil
+	}
+
+	registered := make(map[string]bool)
+	for _, domain := range domains {
+		if name := RegistrableDomain(domain); name != "" {
+			registered[name] = true
+		}
+	}
+	names := make([]string, 0, len(registered))
+	for name := range registered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var counted []Issuance
+		for _, issuance := range issuances {
+			for _, domain := range issuance.Domains {
+				if RegistrableDomain(domain) == name {
+					counted = append(counted, issuance)
+					break
+				}
+			}
+		}
+		add(RateLimitRegisteredDomain, name, CertsPerRegisteredDomain, counted)
+	}
+	return uses, nil
+}
+
+// storedCertificateCovers reports whether the stored certificate of certName
+// is for exactly domains, which makes a new one a renewal
+func storedCertificateCovers(cfg *Config, certName string, domains []string) bool {
+	info, err := certinfo.Load(certinfo.PathsFor(cfg.CertStoragePath, certName).Certificate)
+	if err != nil {
+		return false
+	}
+	missing, extra := info.CompareDomains(domains)
+	return len(missing) == 0 && len(extra) == 0
+}
+
+// checkRateBudget warns about nearly exhausted rate limits before an order
+// and refuses orders that would exceed one, unless cfg.Force is set. The
+// refusal is a rate limit error, so the certificate is deferred to a later
+// run rather than failed.
+func checkRateBudget(cfg *Config, certName string, domains []string) error {
+	uses, err := RateBudget(cfg, certName, domains, time.Now())
+	if err != nil {
+		DefaultLogger.Warnf("Warning: cannot check the rate limit budget of certificate %s: %v", certName, err)
+		return nil
+	}
+	for _, use := range uses {
+		if !use.Exceeded() {
+			DefaultLogger.Warnf("Certificate %s: rate limit budget nearly used up, %s", certName, use)
+			continue
+		}
+		if cfg.Force {
+			DefaultLogger.Warnf("Certificate %s: ordering despite -force, the CA will likely refuse it: %s", certName, use)
+			continue
+		}
+		return common.NewRateLimitError("check rate limit budget",
+			fmt.Sprintf("certificate %s would likely exceed the %s limit of the CA", certName, use.Limit)).
+			AddContext("certificate", certName).
+			AddContext("limit", use.Limit).
+			AddContext("used", fmt.Sprintf("%d/%d", use.Used, use.Max)).
+			AddContext("reset_at", use.ResetAt.Format(time.RFC3339)).
+			AddSuggestion(fmt.Sprintf("Run again after %s, or with -force to order anyway", use.ResetAt.Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// RateLimitInfo is what a rate limit error of the CA says about the limit
+type RateLimitInfo struct {
+	Limit   string    // Name of the limit, e.g. duplicate-certificate
+	ResetAt time.Time // When the CA accepts orders again, zero if unknown
+	DocURL  string    // Documentation of the limit
+}
+
+var (
+	rateLimitResetPattern = regexp.MustCompile(`retry after (\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(?:Z| UTC|[+-]\d{2}:\d{2})?)`)
+	rateLimitDocPattern   = regexp.MustCompile(`https://letsencrypt\.org/docs/rate-limits/?(#[a-z0-9-]+)?`)
+)
+
+// rateLimitNames maps phrases of Let's Encrypt rate limit errors, and the
+// anchors of their documentation, to limit names
+var rateLimitNames = []struct{ phrase, name string }{
+	{"exact set of identifiers", RateLimitDuplicate},
+	{"#new-certificates-per-exact-set", RateLimitDuplicate},
+	{"#new-certificates-per-registered-domain", RateLimitRegisteredDomain},
+	{"too many certificates", RateLimitRegisteredDomain},
+	{"failed authorizations", "failed-authorizations"},
+	{"new orders", "new-orders-per-account"},
+	{"registrations", "new-registrations"},
+}
+
+// ParseRateLimit extracts the limit and its reset time from the detail of a
+// rateLimited problem, e.g. "too many certificates (5) already issued for
+// this exact set of identifiers in the last 168h0m0s, retry after
+// 2025-01-02 03:04:05 UTC: see https://letsencrypt.org/docs/rate-limits/#..."
+func ParseRateLimit(detail string) RateLimitInfo {
+	var info RateLimitInfo
+	if m := rateLimitResetPattern.FindStringSubmatch(detail); m != nil {
+		value := strings.Replace(strings.TrimSuffix(m[1], " UTC"), " ", "T", 1)
+		if !strings.HasSuffix(value, "Z") && !strings.ContainsAny(value[len("2006-01-02T"):], "+-") {
+			value += "Z"
+		}
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			info.ResetAt = t
+		}
+	}
+	info.DocURL = rateLimitDocPattern.FindString(detail)
+	lower := strings.ToLower(detail)
+	for _, entry := range rateLimitNames {
+		if strings.Contains(lower, entry.phrase) {
+			info.Limit = entry.name
+			break
+		}
+	}
+	return info
+}
+
+// recordIssuance remembers an obtained certificate for the rate limit budget
+func recordIssuance(cfg *Config, certName string, domains []string) {
+	issuance := Issuance{CertName: certName, Domains: domains, AcmeServer: cfg.AcmeServer, Time: time.Now().UTC()}
+	if err := RecordIssuance(cfg.CertStoragePath, issuance); err != nil {
+		DefaultLogger.Warnf("Warning: recording the issuance of certificate %s: %v", certName, err)
+	}
+}
@@ -0,0 +1,189 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+)
+
+const letsEncryptProduction = "https://acme-v02.api.letsencrypt.org/directory"
+
+// recordIssuances stores n issuances of domains, one hour apart, ending an hour ago
+func recordIssuances(t *testing.T, cfg *Config, n int, domains ...string) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		issuance := Issuance{CertName: domains[0], Domains: domains, AcmeServer: cfg.AcmeServer, Time: time.Now().Add(-time.Duration(n-i) * time.Hour)}
+		if err := RecordIssuance(cfg.CertStoragePath, issuance); err != nil {
+			t.Fatalf("RecordIssuance failed: %v", err)
+		}
+	}
+}
+
+func TestRecordIssuance_DropsOld(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for _, at := range []time.Time{now.Add(-RateLimitWindow - time.Hour), now.Add(-time.Hour), now} {
+		if err := RecordIssuance(dir, Issuance{CertName: "web", Domains: []string{"example.com"}, Time: at}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state, err := LoadState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Issuances) != 2 {
+		t.Errorf("Expected the issuance older than the window to be dropped, got %+v", state.Issuances)
+	}
+}
+
+func TestRateBudget_Duplicate(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir(), AcmeServer: letsEncryptProduction}
+	recordIssuances(t, cfg, DuplicateCertificateLimit-1, "example.com", "www.example.com")
+
+	uses, err := RateBudget(cfg, "web", []string{"www.example.com", "example.com"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uses) != 1 || uses[0].Limit != RateLimitDuplicate || uses[0].Used != 4 || uses[0].Exceeded() {
+		t.Fatalf("Expected a warning for the duplicate limit, got %+v", uses)
+	}
+
+	recordIssuances(t, cfg, 1, "example.com", "www.example.com")
+	err = checkRateBudget(cfg, "web", []string{"example.com", "www.example.com"})
+	if !common.IsRateLimitError(err) {
+		t.Fatalf("Expected the sixth duplicate to be refused, got %v", err)
+	}
+	if appErr := common.GetApplicationError(err); appErr.Context["limit"] != RateLimitDuplicate || appErr.Context["reset_at"] == "" {
+		t.Errorf("Expected the limit and its reset in the error, got %v", appErr.Context)
+	}
+
+	cfg.Force = true
+	if err := checkRateBudget(cfg, "web", []string{"example.com", "www.example.com"}); err != nil {
+		t.Errorf("Expected -force to order anyway, got %v", err)
+	}
+
+	// Other domain sets are not duplicates
+	cfg.Force = false
+	if err := checkRateBudget(cfg, "web", []string{"example.com"}); err != nil {
+		t.Errorf("Expected another domain set to be ordered, got %v", err)
+	}
+}
+
+func TestRateBudget_RegisteredDomain(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir(), AcmeServer: letsEncryptProduction}
+	for i := 0; i < CertsPerRegisteredDomain; i++ {
+		recordIssuances(t, cfg, 1, fmt.Sprintf("host%d.example.co.uk", i))
+	}
+
+	err := checkRateBudget(cfg, "new", []string{"new.example.co.uk", "new.example.org"})
+	if !common.IsRateLimitError(err) || common.GetApplicationError(err).Context["limit"] != RateLimitRegisteredDomain {
+		t.Fatalf("Expected the 51st certificate of example.co.uk to be refused, got %v", err)
+	}
+	if err := checkRateBudget(cfg, "other", []string{"www.example.org"}); err != nil {
+		t.Errorf("Expected another registered domain to be ordered, got %v", err)
+	}
+	// Renewing an exact set issued before is exempt
+	if err := checkRateBudget(cfg, "host1", []string{"host1.example.co.uk"}); err != nil {
+		t.Errorf("Expected a renewal to be exempt, got %v", err)
+	}
+}
+
+func TestRateBudget_OtherCAs(t *testing.T) {
+	for _, server := range []string{"https://acme-staging-v02.api.letsencrypt.org/directory", "https://acme.zerossl.com/v2/DV90"} {
+		cfg := &Config{CertStoragePath: t.TempDir(), AcmeServer: server}
+		recordIssuances(t, cfg, DuplicateCertificateLimit+1, "example.com")
+		if uses, err := RateBudget(cfg, "web", []string{"example.com"}, time.Now()); err != nil || len(uses) != 0 {
+			t.Errorf("%s: expected no Let's Encrypt budget, got %+v (%v)", server, uses, err)
+		}
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		detail string
+		limit  string
+		reset  string
+		doc    string
+	}{
+		{
+			"too many certificates (5) already issued for this exact set of identifiers in the last 168h0m0s, retry after 2025-01-02 03:04:05 UTC: see https://letsencrypt.org/docs/rate-limits/#new-certificates-per-exact-set-of-identifiers",
+			RateLimitDuplicate, "2025-01-02T03:04:05Z", "https://letsencrypt.org/docs/rate-limits/#new-certificates-per-exact-set-of-identifiers",
+		},
+		{
+			`too many certificates (50) already issued for "example.com" in the last 168h0m0s, retry after 2025-01-02T03:04:05Z: see https://letsencrypt.org/docs/rate-limits/#new-certificates-per-registered-domain`,
+			RateLimitRegisteredDomain, "2025-01-02T03:04:05Z", "https://letsencrypt.org/docs/rate-limits/#new-certificates-per-registered-domain",
+		},
+		{"too many failed authorizations (5) for \"example.com\" in the last 1h0m0s", "failed-authorizations", "", ""},
+		{"slow down", "", "", ""},
+	}
+	for _, tt := range tests {
+		info := ParseRateLimit(tt.detail)
+		reset := ""
+		if !info.ResetAt.IsZero() {
+			reset = info.ResetAt.Format(time.RFC3339)
+		}
+		if info.Limit != tt.limit || reset != tt.reset || info.DocURL != tt.doc {
+			t.Errorf("%q: expected %s %s %s, got %+v", tt.detail, tt.limit, tt.reset, tt.doc, info)
+		}
+	}
+}
+
+func TestWithRetry_RateLimitDescribed(t *testing.T) {
+	recordSleeps(t)
+	problem := &acme.ProblemDetails{
+		Type: acmeRateLimitedErr, HTTPStatus: http.StatusTooManyRequests,
+		Detail: "too many certificates (5) already issued for this exact set of identifiers in the last 168h0m0s, retry after 2025-01-02 03:04:05 UTC: see https://letsencrypt.org/docs/rate-limits/#new-certificates-per-exact-set-of-identifiers",
+	}
+	err := withRetry(context.Background(), RetryConfig{MaxAttempts: 1}, "obtain certificate", nil, func() error { return problem })
+	var appErr *common.ApplicationError
+	if !errors.As(err, &appErr) {
+		t.Fatalf("Expected an ApplicationError, got %v", err)
+	}
+	if appErr.Context["limit"] != RateLimitDuplicate || appErr.Context["reset_at"] != "2025-01-02T03:04:05Z" {
+		t.Errorf("Expected the limit and reset time in the context, got %v", appErr.Context)
+	}
+}
+
+// TestStoreRenewedCertificates_RecordsOnlyIssued tests that a renewal lego
+// answers with the existing certificate does not count against the budget
+func TestStoreRenewedCertificates_RecordsOnlyIssued(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir(), AcmeServer: "https://acme.example/directory"}
+	domains := []string{"example.com"}
+	certPEM, keyPEM, _ := newTestChain(t, domains)
+	existing := &certificate.Resource{Domain: "example.com", Certificate: certPEM, PrivateKey: keyPEM}
+	if err := saveCertificates(cfg, "web", existing, cfg.AcmeServer); err != nil {
+		t.Fatal(err)
+	}
+	issuances := func() int {
+		state, err := LoadState(cfg.CertStoragePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(state.Issuances)
+	}
+
+	for _, renewed := range []*certificate.Resource{nil, existing} {
+		if err := storeRenewedCertificates(cfg, "web", existing, renewed, domains); err != nil {
+			t.Fatalf("storeRenewedCertificates failed: %v", err)
+		}
+	}
+	if n := issuances(); n != 0 {
+		t.Errorf("Expected a renewal without a new certificate not to be recorded, got %d issuances", n)
+	}
+
+	newPEM, newKeyPEM, chainPEM := newTestChain(t, domains)
+	renewed := &certificate.Resource{Domain: "example.com", Certificate: newPEM, PrivateKey: newKeyPEM, IssuerCertificate: chainPEM}
+	if err := storeRenewedCertificates(cfg, "web", existing, renewed, domains); err != nil {
+		t.Fatalf("storeRenewedCertificates failed: %v", err)
+	}
+	if n := issuances(); n != 1 {
+		t.Errorf("Expected the renewed certificate to be recorded once, got %d issuances", n)
+	}
+}
@@ -0,0 +1,397 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+// ErrNothingStored is returned by DeleteCertificate if neither files nor
+// state of the certificate exist
+var ErrNothingStored = errors.New("nothing stored for this certificate")
+
+// ErrStillConfigured is returned by DeleteCertificate for a certificate that
+// is still defined in auto_domains, the next -auto run would issue it again
+var ErrStillConfigured = errors.New("certificate is still defined in auto_domains")
+
+// ErrRevocationFailed is returned by DeleteCertificate if the certificate
+// could not be revoked, nothing was removed then
+var ErrRevocationFailed = errors.New("revocation failed")
+
+// DeleteOptions selects the optional steps of DeleteCertificate
+type DeleteOptions struct {
+	Revoke       bool // Revoke the certificate at the CA before removing it
+	PruneAcmeDNS bool // Remove acme-dns accounts no other certificate uses
+}
+
+// DeleteResult describes what DeleteCertificate removed
+type DeleteResult struct {
+	Revoked         bool
+	Files           []string // Removed certificate, archive, live and output files
+	StateRemoved    bool
+	AcmeDNSAccounts []string // Domains whose acme-dns account was removed
+}
+
+// DeleteCertificate retires a certificate that is no longer configured: it
+// optionally revokes it, then removes its files, archived generations, the
+// copies in cert_output_dir and key_output_dir and its entry in the state
+// file. With opts.PruneAcmeDNS the acme-dns accounts
+// of its domains are removed from store unless another configured or stored
+// certificate still uses them. A failed revocation leaves everything in place.
+func DeleteCertificate(ctx context.Context, cfg *Config, store *accountStore, certName string, opts DeleteOptions) (*DeleteResult, error) {
+	if _, ok := cfg.CertConfigFor(certName); ok {
+		return nil, fmt.Errorf("%s: %w", certName, ErrStillConfigured)
+	}
+	state, err := LoadState(cfg.CertStoragePath)
+	if err != nil {
+		return nil, err
+	}
+	certState, inState := state.Certificates[certName]
+
+	paths := certinfo.PathsFor(cfg.CertStoragePath, certName)
+	files := existingFiles(certificateFiles(paths))
+	archiveDir := filepath.Join(cfg.CertStoragePath, ArchiveDirName, certName)
+	archived, err := filesBelow(archiveDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 && len(archived) == 0 && !inState {
+		return nil, fmt.Errorf("%s: %w", certName, ErrNothingStored)
+	}
+
+	domains := certState.Domains
+	if info, err := certinfo.Load(paths.Certificate); err == nil && len(info.DNSNames) > 0 {
+		domains = info.DNSNames
+	}
+
+	result := &DeleteResult{}
+	if opts.Revoke {
+		// The state knows the server the certificate was issued by
+		revokeCfg := cfg
+		if certState.AcmeServer != "" && certState.AcmeServer != cfg.AcmeServer {
+			serverCfg := *cfg
+			serverCfg.AcmeServer = certState.AcmeServer
+			revokeCfg = &serverCfg
+		}
+		err := RevokeCertificate(ctx, revokeCfg, certName, acme.CRLReasonCessationOfOperation)
+		recordAudit(revokeCfg, AuditRecord{Action: AuditRevoke, CertName: certName, Domains: domains}, err)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrRevocationFailed, err)
+		}
+		result.Revoked = true
+	}
+
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			return result, fmt.Errorf("removing %s: %w", file, err)
+		}
+		result.Files = append(result.Files, file)
+	}
+	recordManifest(cfg.CertStoragePath, result.Files...)
+	// A remote backend must not hand the files back on the next run
+	if err := publishStorageFiles(cfg, result.Files...); err != nil {
+		return result, fmt.Errorf("removing certificate files from storage backend: %w", err)
+	}
+	if len(archived) > 0 {
+		generations, err := ListGenerations(cfg, certName)
+		if err != nil {
+			return result, err
+		}
+		for _, gen := range generations {
+			if err := removeArchivedKey(gen.Dir); err != nil {
+				return result, err
+			}
+		}
+		if err := os.RemoveAll(archiveDir); err != nil {
+			return result, fmt.Errorf("removing archived generations %s: %w", archiveDir, err)
+		}
+		recordManifest(cfg.CertStoragePath, archived...)
+		result.Files = append(result.Files, archived...)
+	}
+	live, err := removeLiveDir(cfg, certName)
+	if err != nil {
+		return result, err
+	}
+	result.Files = append(result.Files, live...)
+	outputs, err := removeOutputFiles(certState)
+	result.Files = append(result.Files, outputs...)
+	if err != nil {
+		return result, err
+	}
+
+	if result.StateRemoved, err = RemoveCertState(cfg.CertStoragePath, certName); err != nil {
+		return result, err
+	}
+	// Its pending authorizations expire at the CA by themselves
+	if removed, err := removePendingOrder(cfg.CertStoragePath, certName); err != nil {
+		return result, err
+	} else if removed {
+		result.Files = append(result.Files, pendingOrderPath(cfg.CertStoragePath, certName))
+	}
+
+	if opts.PruneAcmeDNS && store != nil {
+		if result.AcmeDNSAccounts, err = pruneAcmeDNSAccounts(cfg, store, domains); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// RevokeCertificate revokes the stored certificate at the configured ACME
+// server with the account of the configuration. The CA accepts this from
+// the account that ordered the certificate or one authorized for all its
+// domains.
+func RevokeCertificate(ctx context.Context, cfg *Config, certName string, reason uint) error {
+	certPEM, err := os.ReadFile(certinfo.PathsFor(cfg.CertStoragePath, certName).Certificate)
+	if err != nil {
+		return fmt.Errorf("reading certificate to revoke: %w", err)
+	}
+	serverDir, err := accountServerDir(cfg)
+	if err != nil {
+		return err
+	}
+	// Check first, loading the user would create a key for a new account
+	if _, err := os.Stat(filepath.Join(serverDir, "account.json")); os.IsNotExist(err) {
+		return fmt.Errorf("%w for %s", ErrNoAccount, cfg.AcmeServer)
+	}
+	user, err := createOrLoadUser(cfg)
+	if err != nil {
+		return fmt.Errorf("loading ACME account: %w", err)
+	}
+	if user.Registration == nil {
+		return fmt.Errorf("%w for %s", ErrNoAccount, cfg.AcmeServer)
+	}
+
+	legoConfig := lego.NewConfig(user)
+	legoConfig.CADirURL = cfg.AcmeServer
+	if legoConfig.HTTPClient == nil {
+		legoConfig.HTTPClient = &http.Client{}
+	}
+	legoConfig.HTTPClient.Timeout = cfg.HTTPTimeout
+	cfg.applyTransportSettings(legoConfig.HTTPClient)
+	legoConfig.HTTPClient.Transport = newContextTransport(ctx, cfg.debugTransport(legoConfig.HTTPClient.Transport))
+	client, err := lego.NewClient(legoConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create Lego client: %w", err)
+	}
+	return withRetry(ctx, cfg.RetryPolicy(), "revoke certificate", nil, func() error {
+		return client.Certificate.RevokeWithReason(certPEM, &reason)
+	})
+}
+
+// pruneAcmeDNSAccounts removes the accounts of domains that no configured or
+// stored certificate uses anymore and returns their domains
+func pruneAcmeDNSAccounts(cfg *Config, store *accountStore, domains []string) ([]string, error) {
+	candidates := make(map[string]bool)
+	for _, domain := range domains {
+		candidates[GetBaseDomain(domain)] = true
+		candidates[cfg.AcmeDNSAccountKey(domain)] = true
+	}
+	unused, err := UnusedAcmeDNSAccounts(cfg, store)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, domain := range unused {
+		if candidates[GetBaseDomain(domain)] {
+			removed = append(removed, domain)
+		}
+	}
+	if err := RemoveAcmeDNSAccounts(store, removed); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// UnusedAcmeDNSAccounts returns the sorted domains of the accounts in store
+// that no auto_domains certificate and no stored certificate uses. Wildcard
+// and base domain share an account, so either keeps both.
+func UnusedAcmeDNSAccounts(cfg *Config, store *accountStore) ([]string, error) {
+	referenced, err := referencedAcmeDNSDomains(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var unused []string
+	for domain := range store.GetAllAccounts() {
+		if !referenced[GetBaseDomain(domain)] {
+			unused = append(unused, domain)
+		}
+	}
+	sort.Strings(unused)
+	return unused, nil
+}
+
+// RemoveAcmeDNSAccounts deletes the accounts of domains from store and saves
+// it. The accounts remain on the acme-dns server, which has no API to delete
+// them.
+func RemoveAcmeDNSAccounts(store *accountStore, domains []string) error {
+	if len(domains) == 0 {
+		return nil
+	}
+	for _, domain := range domains {
+		store.DeleteAccount(domain)
+	}
+	return store.SaveAccounts()
+}
+
+// referencedAcmeDNSDomains returns the base domains of all auto_domains
+// certificates and all stored certificates, the domains whose acme-dns
+// accounts are still needed
+func referencedAcmeDNSDomains(cfg *Config) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	if cfg.AutoDomains != nil {
+		for _, certCfg := range cfg.AutoDomains.Certs {
+			for _, domain := range certCfg.Domains {
+				referenced[GetBaseDomain(domain)] = true
+				referenced[cfg.AcmeDNSAccountKey(domain)] = true
+			}
+		}
+	}
+	names, err := certinfo.List(cfg.CertStoragePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		info, err := certinfo.Load(certinfo.PathsFor(cfg.CertStoragePath, name).Certificate)
+		if err != nil {
+			continue
+		}
+		for _, domain := range info.DNSNames {
+			referenced[GetBaseDomain(domain)] = true
+			referenced[cfg.AcmeDNSAccountKey(domain)] = true
+		}
+	}
+	return referenced, nil
+}
+
+// Kinds of leftovers reported by OrphanScan
+const (
+	OrphanFile    = "file"    // A file in the certificates directory
+	OrphanArchive = "archive" // Archived generations of a certificate
+	OrphanState   = "state"   // An entry in the state file
+	OrphanOutput  = "output"  // A copy in cert_output_dir or key_output_dir
+)
+
+// Orphan is a leftover of a certificate that is not in auto_domains
+type Orphan struct {
+	CertName string `json:"cert_name"`
+	Kind     string `json:"kind"`
+	Path     string `json:"path"`
+}
+
+// OrphanScan lists files in the certificates directory, archived generations,
+// state entries and output directory copies of certificates not defined in
+// auto_domains, sorted by certificate name and path. Nothing is changed,
+// -delete removes them.
+func OrphanScan(cfg *Config) ([]Orphan, error) {
+	configured := make(map[string]bool)
+	var claimed []string
+	if cfg.AutoDomains != nil {
+		for name := range cfg.AutoDomains.Certs {
+			configured[name] = true
+			claimed = append(claimed, certificateFiles(certinfo.PathsFor(cfg.CertStoragePath, name))...)
+		}
+	}
+	isClaimed := make(map[string]bool)
+	for _, path := range claimed {
+		isClaimed[path] = true
+	}
+
+	var orphans []Orphan
+	certDir := certinfo.CertificatesDir(cfg.CertStoragePath)
+	entries, err := os.ReadDir(certDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading certificates directory: %w", err)
+	}
+	for _, e := range entries {
+		path := filepath.Join(certDir, e.Name())
+		if e.IsDir() || isClaimed[path] {
+			continue
+		}
+		orphans = append(orphans, Orphan{CertName: certNameOf(e.Name()), Kind: OrphanFile, Path: path})
+	}
+
+	archiveEntries, err := os.ReadDir(filepath.Join(cfg.CertStoragePath, ArchiveDirName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading archive directory: %w", err)
+	}
+	for _, e := range archiveEntries {
+		if e.IsDir() && !configured[e.Name()] {
+			orphans = append(orphans, Orphan{CertName: e.Name(), Kind: OrphanArchive, Path: filepath.Join(cfg.CertStoragePath, ArchiveDirName, e.Name())})
+		}
+	}
+
+	state, err := LoadState(cfg.CertStoragePath)
+	if err != nil {
+		return nil, err
+	}
+	for name := range state.Certificates {
+		if configured[name] {
+			continue
+		}
+		orphans = append(orphans, Orphan{CertName: name, Kind: OrphanState, Path: filepath.Join(cfg.CertStoragePath, StateFile)})
+		for _, path := range existingFiles(state.Certificates[name].OutputFiles) {
+			orphans = append(orphans, Orphan{CertName: name, Kind: OrphanOutput, Path: path})
+		}
+	}
+
+	sort.Slice(orphans, func(i, j int) bool {
+		if orphans[i].CertName != orphans[j].CertName {
+			return orphans[i].CertName < orphans[j].CertName
+		}
+		return orphans[i].Path < orphans[j].Path
+	})
+	return orphans, nil
+}
+
+// certNameOf returns the certificate name of a file in the certificates
+// directory, the file name without the suffix of certinfo.Paths
+func certNameOf(fileName string) string {
+	for _, suffix := range []string{".issuer.crt", ".combined.pem", ".fullchain.pem", ".tlsa.json", ".crt", ".key", ".json", ".pfx", ".tlsa"} {
+		if strings.HasSuffix(fileName, suffix) {
+			return strings.TrimSuffix(fileName, suffix)
+		}
+	}
+	return fileName
+}
+
+// existingFiles returns the paths that exist
+func existingFiles(paths []string) []string {
+	var existing []string
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			existing = append(existing, path)
+		}
+	}
+	return existing
+}
+
+// filesBelow returns all files in a directory tree, nothing if it does not exist
+func filesBelow(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", dir, err)
+	}
+	return files, nil
+}
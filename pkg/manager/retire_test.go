@@ -0,0 +1,172 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+// writeRetireCert stores a certificate with key and metadata under name
+func writeRetireCert(t *testing.T, storage, name string, domains []string) certinfo.Paths {
+	t.Helper()
+	certPEM, keyPEM, _ := newTestChain(t, domains)
+	paths := certinfo.PathsFor(storage, name)
+	if err := os.MkdirAll(filepath.Dir(paths.Certificate), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for path, data := range map[string][]byte{paths.Certificate: certPEM, paths.PrivateKey: keyPEM, paths.Metadata: []byte("{}")} {
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return paths
+}
+
+func TestDeleteCertificate(t *testing.T) {
+	storage := t.TempDir()
+	cfg := &Config{CertStoragePath: storage, AcmeServer: "https://acme.example.com/directory", AutoDomains: &AutoDomainsConfig{
+		Certs: map[string]CertConfig{"web": {Domains: []string{"www.example.com"}}},
+	}}
+	old := writeRetireCert(t, storage, "old", []string{"old.example.com", "*.old.example.com", "shared.example.com"})
+	writeRetireCert(t, storage, "web", []string{"www.example.com"})
+	writeRetireCert(t, storage, "other", []string{"shared.example.com"})
+	generation := filepath.Join(storage, ArchiveDirName, "old", "20250101T000000.000000Z")
+	if err := os.MkdirAll(generation, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(generation, "old.key"), []byte("key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(t.TempDir(), "old.pem")
+	if err := os.WriteFile(output, []byte("cert"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"old", "web"} {
+		if err := UpdateCertState(storage, name, CertState{LastResult: "issued"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := recordOutputFiles(storage, "old", []string{output}, ""); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewAccountStore(filepath.Join(storage, AcmeDNSAccountsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, domain := range []string{"old.example.com", "*.old.example.com", "shared.example.com", "www.example.com"} {
+		store.SetAccount(domain, AcmeDnsAccount{FullDomain: "x.auth.example.org"})
+	}
+
+	if _, err := DeleteCertificate(context.Background(), cfg, store, "web", DeleteOptions{}); !errors.Is(err, ErrStillConfigured) {
+		t.Errorf("Expected configured certificates to be refused, got %v", err)
+	}
+	if _, err := DeleteCertificate(context.Background(), cfg, store, "missing", DeleteOptions{}); !errors.Is(err, ErrNothingStored) {
+		t.Errorf("Expected an error for an unknown certificate, got %v", err)
+	}
+	// Without an ACME account nothing is removed
+	if _, err := DeleteCertificate(context.Background(), cfg, store, "old", DeleteOptions{Revoke: true}); !errors.Is(err, ErrNoAccount) || !errors.Is(err, ErrRevocationFailed) {
+		t.Errorf("Expected the revocation to fail without account, got %v", err)
+	}
+	if _, err := os.Stat(old.Certificate); err != nil {
+		t.Fatalf("Expected the certificate to be kept after a failed revocation: %v", err)
+	}
+
+	result, err := DeleteCertificate(context.Background(), cfg, store, "old", DeleteOptions{PruneAcmeDNS: true})
+	if err != nil {
+		t.Fatalf("DeleteCertificate failed: %v", err)
+	}
+	if len(result.Files) != 5 || !result.StateRemoved {
+		t.Errorf("Expected 3 certificate files, 1 archived file, 1 output file and the state entry to be removed, got %+v", result)
+	}
+	for _, path := range []string{old.Certificate, old.PrivateKey, old.Metadata, filepath.Join(storage, ArchiveDirName, "old"), output} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", path)
+		}
+	}
+	state, _ := LoadState(storage)
+	if _, ok := state.Certificates["old"]; ok || len(state.Certificates) != 1 {
+		t.Errorf("Expected only the state of web to remain, got %v", state.Certificates)
+	}
+	// shared.example.com is still used by the stored certificate other
+	if len(result.AcmeDNSAccounts) != 2 || result.AcmeDNSAccounts[0] != "*.old.example.com" || result.AcmeDNSAccounts[1] != "old.example.com" {
+		t.Errorf("Expected the accounts of old.example.com to be removed, got %v", result.AcmeDNSAccounts)
+	}
+	reloaded, _ := NewAccountStore(filepath.Join(storage, AcmeDNSAccountsFile))
+	if accounts := reloaded.GetAllAccounts(); len(accounts) != 2 {
+		t.Errorf("Expected the accounts of shared and www to be saved, got %v", accounts)
+	}
+}
+
+func TestOrphanScan(t *testing.T) {
+	storage := t.TempDir()
+	cfg := &Config{CertStoragePath: storage, AutoDomains: &AutoDomainsConfig{
+		Certs: map[string]CertConfig{"web": {Domains: []string{"www.example.com"}}},
+	}}
+	writeRetireCert(t, storage, "web", []string{"www.example.com"})
+	old := writeRetireCert(t, storage, "old", []string{"old.example.com"})
+	if err := os.MkdirAll(filepath.Join(storage, ArchiveDirName, "gone", "20250101T000000.000000Z"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(t.TempDir(), "gone.pem")
+	if err := os.WriteFile(output, []byte("cert"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateCertState(storage, "gone", CertState{LastResult: "issued", OutputFiles: []string{output, output + ".missing"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	orphans, err := OrphanScan(cfg)
+	if err != nil {
+		t.Fatalf("OrphanScan failed: %v", err)
+	}
+	want := []Orphan{
+		{CertName: "gone", Kind: OrphanArchive, Path: filepath.Join(storage, ArchiveDirName, "gone")},
+		{CertName: "gone", Kind: OrphanState, Path: filepath.Join(storage, StateFile)},
+		{CertName: "gone", Kind: OrphanOutput, Path: output},
+		{CertName: "old", Kind: OrphanFile, Path: old.Certificate},
+		{CertName: "old", Kind: OrphanFile, Path: old.Metadata},
+		{CertName: "old", Kind: OrphanFile, Path: old.PrivateKey},
+	}
+	if len(orphans) != len(want) {
+		t.Fatalf("Expected %d orphans, got %+v", len(want), orphans)
+	}
+	for i := range want {
+		if orphans[i] != want[i] {
+			t.Errorf("Orphan %d: expected %+v, got %+v", i, want[i], orphans[i])
+		}
+	}
+}
+
+func TestUnusedAcmeDNSAccounts(t *testing.T) {
+	storage := t.TempDir()
+	cfg := &Config{CertStoragePath: storage, AutoDomains: &AutoDomainsConfig{
+		Certs: map[string]CertConfig{"web": {Domains: []string{"*.example.com"}}},
+	}}
+	writeRetireCert(t, storage, "old", []string{"old.example.org"})
+	store, err := NewAccountStore(filepath.Join(storage, AcmeDNSAccountsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, domain := range []string{"example.com", "old.example.org", "gone.example.net", "*.gone.example.net"} {
+		store.SetAccount(domain, AcmeDnsAccount{FullDomain: "x.auth.example.org"})
+	}
+
+	unused, err := UnusedAcmeDNSAccounts(cfg, store)
+	if err != nil {
+		t.Fatalf("UnusedAcmeDNSAccounts failed: %v", err)
+	}
+	if len(unused) != 2 || unused[0] != "*.gone.example.net" || unused[1] != "gone.example.net" {
+		t.Errorf("Expected the accounts of gone.example.net, got %v", unused)
+	}
+	if err := RemoveAcmeDNSAccounts(store, unused); err != nil {
+		t.Fatalf("RemoveAcmeDNSAccounts failed: %v", err)
+	}
+	reloaded, _ := NewAccountStore(filepath.Join(storage, AcmeDNSAccountsFile))
+	if accounts := reloaded.GetAllAccounts(); len(accounts) != 2 {
+		t.Errorf("Expected the accounts of example.com and old.example.org to remain, got %v", accounts)
+	}
+}
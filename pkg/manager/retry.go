@@ -0,0 +1,292 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+)
+
+// Defaults for the retry section of the configuration
+const (
+	DefaultRetryMaxAttempts  = 4
+	DefaultRetryInitialDelay = 2 * time.Second
+	DefaultRetryMaxDelay     = 5 * time.Minute
+)
+
+// acmeRateLimitedErr is the ACME problem type for rate limits (RFC 8555 section 6.7)
+const acmeRateLimitedErr = "urn:ietf:params:acme:error:rateLimited"
+
+// RetryConfig controls how often ACME and acme-dns requests are retried after
+// a rate limit or another transient refusal of the server
+type RetryConfig struct {
+	MaxAttempts  int           `yaml:"max_attempts,omitempty"`  // Attempts per operation, 1 disables retries (default: 4)
+	InitialDelay time.Duration `yaml:"initial_delay,omitempty"` // Delay before the first retry, doubled for each further one (default: 2s)
+	MaxDelay     time.Duration `yaml:"max_delay,omitempty"`     // Longest delay, also the longest Retry-After honored (default: 5m)
+}
+
+// RetryPolicy returns the retry configuration with defaults filled in
+func (cfg *Config) RetryPolicy() RetryConfig {
+	policy := RetryConfig{
+		MaxAttempts:  DefaultRetryMaxAttempts,
+		InitialDelay: DefaultRetryInitialDelay,
+		MaxDelay:     DefaultRetryMaxDelay,
+	}
+	if cfg.Retry != nil {
+		if cfg.Retry.MaxAttempts > 0 {
+			policy.MaxAttempts = cfg.Retry.MaxAttempts
+		}
+		if cfg.Retry.InitialDelay > 0 {
+			policy.InitialDelay = cfg.Retry.InitialDelay
+		}
+		if cfg.Retry.MaxDelay > 0 {
+			policy.MaxDelay = cfg.Retry.MaxDelay
+		}
+	}
+	return policy
+}
+
+// retrySleep waits between attempts, replaced in tests
+var retrySleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryAfterRecorder is an http.RoundTripper that remembers the Retry-After
+// header of the last throttled response. Lego turns responses into errors
+// without their headers, the recorder gives the retry loop access to them.
+// It also reports the orders the CA creates, see setOrderHook and lastOrder.
+type retryAfterRecorder struct {
+	next http.RoundTripper
+
+	mu         sync.Mutex
+	retryAfter time.Duration
+	orderHook  func(orderURL string)
+	orderURL   string // URL of the last order created
+}
+
+// newRetryAfterRecorder wraps next, or http.DefaultTransport if next is nil
+func newRetryAfterRecorder(next http.RoundTripper) *retryAfterRecorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &retryAfterRecorder{next: next}
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *retryAfterRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.next.RoundTrip(req)
+	if err == nil && isThrottleStatus(resp.StatusCode) {
+		if d := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); d > 0 {
+			r.mu.Lock()
+			r.retryAfter = d
+			r.mu.Unlock()
+		}
+	}
+	if err == nil && resp.StatusCode == http.StatusCreated {
+		if orderURL := newOrderURL(resp); orderURL != "" {
+			r.mu.Lock()
+			r.orderURL = orderURL
+			hook := r.orderHook
+			r.mu.Unlock()
+			if hook != nil {
+				hook(orderURL)
+			}
+		}
+	}
+	return resp, err
+}
+
+// lastOrder returns the URL of the last order the CA created, "" if none
+func (r *retryAfterRecorder) lastOrder() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.orderURL
+}
+
+// setOrderHook sets a function called with the URL of every order the CA
+// creates, before Lego sees the response; nil removes it
+func (r *retryAfterRecorder) setOrderHook(hook func(orderURL string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.orderHook = hook
+}
+
+// newOrderURL returns the Location of a 201 response creating an order, ""
+// for other responses such as a new account. Only orders have a finalize URL.
+func newOrderURL(resp *http.Response) string {
+	location := resp.Header.Get("Location")
+	if location == "" || resp.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	var order struct {
+		Finalize string `json:"finalize"`
+	}
+	if json.Unmarshal(body, &order) != nil || order.Finalize == "" {
+		return ""
+	}
+	return location
+}
+
+// take returns and clears the last recorded Retry-After
+func (r *retryAfterRecorder) take() time.Duration {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := r.retryAfter
+	r.retryAfter = 0
+	return d
+}
+
+// isThrottleStatus reports whether an HTTP status asks the client to come back later
+func isThrottleStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil && when.After(now) {
+		return when.Sub(now)
+	}
+	return 0
+}
+
+// classifyRetry decides whether err is worth another attempt. Rate limits are
+// reported separately so the final error can be typed accordingly.
+func classifyRetry(err error) (retryable, rateLimited bool, retryAfter time.Duration) {
+	var nonceErr *acme.NonceError
+	if errors.As(err, &nonceErr) {
+		return true, false, 0
+	}
+	var problem *acme.ProblemDetails
+	if errors.As(err, &problem) {
+		switch {
+		case problem.Type == acmeRateLimitedErr || problem.HTTPStatus == http.StatusTooManyRequests:
+			return true, true, 0
+		case problem.Type == acme.BadNonceErr || problem.HTTPStatus == http.StatusServiceUnavailable:
+			return true, false, 0
+		}
+		return false, false, 0
+	}
+	var statusErr *acmeDNSStatusError
+	if errors.As(err, &statusErr) && isThrottleStatus(statusErr.StatusCode) {
+		return true, statusErr.StatusCode == http.StatusTooManyRequests, statusErr.RetryAfter
+	}
+	return false, false, 0
+}
+
+// describeRateLimit adds the limit and reset time the problem document of
+// err names to rateErr, so the operator knows when a new attempt can work
+func describeRateLimit(rateErr *common.ApplicationError, err error) {
+	var problem *acme.ProblemDetails
+	if !errors.As(err, &problem) {
+		return
+	}
+	info := ParseRateLimit(problem.Detail)
+	if info.Limit != "" {
+		rateErr.AddContext("limit", info.Limit)
+	}
+	if !info.ResetAt.IsZero() {
+		rateErr.AddContext("reset_at", info.ResetAt.Format(time.RFC3339))
+		rateErr.AddSuggestion(fmt.Sprintf("The CA accepts new orders again after %s", info.ResetAt.Local().Format(time.RFC1123)))
+	}
+	if info.DocURL != "" {
+		rateErr.AddSuggestion("See " + info.DocURL)
+	}
+}
+
+// IsACMEError reports whether err was caused by an ACME server refusing a
+// request: a problem document, or an ACME or rate limit ApplicationError
+func IsACMEError(err error) bool {
+	var problem *acme.ProblemDetails
+	if errors.As(err, &problem) {
+		return true
+	}
+	var appErr *common.ApplicationError
+	return errors.As(err, &appErr) && (appErr.IsType(common.ErrorTypeACME) || appErr.IsType(common.ErrorTypeRateLimit))
+}
+
+// withRetry runs fn until it succeeds, fails permanently or the attempts of
+// the policy are used up. Between attempts it waits with exponential backoff,
+// or as long as the server asked via Retry-After if that is longer. A rate
+// limit that persists, or asks for a wait beyond max_delay, is returned as an
+// ErrorTypeRateLimit ApplicationError wrapping the last error.
+func withRetry(ctx context.Context, policy RetryConfig, operation string, recorder *retryAfterRecorder, fn func() error) error {
+	delay := policy.InitialDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		retryable, rateLimited, retryAfter := classifyRetry(err)
+		if recorded := recorder.take(); recorded > retryAfter {
+			retryAfter = recorded
+		}
+		if !retryable {
+			return err
+		}
+
+		wait := delay
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		if attempt >= policy.MaxAttempts || retryAfter > policy.MaxDelay {
+			if !rateLimited {
+				return err
+			}
+			rateErr := common.WrapError(err, common.ErrorTypeRateLimit, operation,
+				"The server is rate limiting requests").
+				AddContext("attempts", attempt).
+				AddSuggestion("Run again later, the affected certificates are retried on the next run")
+			if retryAfter > 0 {
+				rateErr.AddContext("retry_after", retryAfter.String())
+			}
+			describeRateLimit(rateErr, err)
+			return rateErr
+		}
+		if wait > policy.MaxDelay {
+			wait = policy.MaxDelay
+		}
+
+		DefaultLogger.Warnf("%s failed (attempt %d of %d), retrying in %s: %v", operation, attempt, policy.MaxAttempts, wait, err)
+		if err := retrySleep(ctx, wait); err != nil {
+			return err
+		}
+		delay *= 2
+		if delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+)
+
+// recordSleeps replaces retrySleep for the duration of a test
+func recordSleeps(t *testing.T) *[]time.Duration {
+	t.Helper()
+	var waits []time.Duration
+	orig := retrySleep
+	retrySleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	t.Cleanup(func() { retrySleep = orig })
+	return &waits
+}
+
+func rateLimitProblem() error {
+	return fmt.Errorf("error: one or more domains had a problem:\n%w", &acme.ProblemDetails{
+		Type: acmeRateLimitedErr, HTTPStatus: http.StatusTooManyRequests, Detail: "too many certificates",
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{"Wed, 01 Jan 2025 12:00:30 GMT", 30 * time.Second},
+		{"Wed, 01 Jan 2025 11:00:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, expected %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyRetry(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		retryable   bool
+		rateLimited bool
+	}{
+		{"rate limited", rateLimitProblem(), true, true},
+		{"bad nonce", &acme.NonceError{ProblemDetails: &acme.ProblemDetails{Type: acme.BadNonceErr, HTTPStatus: 400}}, true, false},
+		{"unavailable", &acme.ProblemDetails{HTTPStatus: http.StatusServiceUnavailable}, true, false},
+		{"unauthorized", &acme.ProblemDetails{Type: "urn:ietf:params:acme:error:unauthorized", HTTPStatus: 403}, false, false},
+		{"acme-dns 429", &acmeDNSStatusError{StatusCode: http.StatusTooManyRequests}, true, true},
+		{"acme-dns 500", &acmeDNSStatusError{StatusCode: http.StatusInternalServerError}, false, false},
+		{"plain", errors.New("boom"), false, false},
+	}
+	for _, tt := range tests {
+		retryable, rateLimited, _ := classifyRetry(tt.err)
+		if retryable != tt.retryable || rateLimited != tt.rateLimited {
+			t.Errorf("%s: expected retryable=%v rateLimited=%v, got %v %v", tt.name, tt.retryable, tt.rateLimited, retryable, rateLimited)
+		}
+	}
+}
+
+func TestConfig_RetryPolicy(t *testing.T) {
+	cfg := &Config{}
+	if got := cfg.RetryPolicy(); got.MaxAttempts != DefaultRetryMaxAttempts || got.InitialDelay != DefaultRetryInitialDelay || got.MaxDelay != DefaultRetryMaxDelay {
+		t.Errorf("Expected defaults, got %+v", got)
+	}
+	cfg.Retry = &RetryConfig{MaxAttempts: 1}
+	if got := cfg.RetryPolicy(); got.MaxAttempts != 1 || got.MaxDelay != DefaultRetryMaxDelay {
+		t.Errorf("Expected max_attempts override with default delays, got %+v", got)
+	}
+}
+
+func TestWithRetry_Backoff(t *testing.T) {
+	waits := recordSleeps(t)
+	policy := RetryConfig{MaxAttempts: 4, InitialDelay: time.Second, MaxDelay: 3 * time.Second}
+
+	calls := 0
+	err := withRetry(context.Background(), policy, "obtain certificate", nil, func() error {
+		calls++
+		if calls < 4 {
+			return &acme.NonceError{ProblemDetails: &acme.ProblemDetails{Type: acme.BadNonceErr, HTTPStatus: 400}}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected success after retries, got %v", err)
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}
+	if fmt.Sprint(*waits) != fmt.Sprint(want) {
+		t.Errorf("Expected waits %v, got %v", want, *waits)
+	}
+}
+
+func TestWithRetry_RateLimitExhausted(t *testing.T) {
+	recordSleeps(t)
+	policy := RetryConfig{MaxAttempts: 3, InitialDelay: time.Second, MaxDelay: time.Minute}
+
+	calls := 0
+	err := withRetry(context.Background(), policy, "obtain certificate", nil, func() error {
+		calls++
+		return rateLimitProblem()
+	})
+	if calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", calls)
+	}
+	if !common.IsRateLimitError(err) {
+		t.Fatalf("Expected rate limit error, got %v", err)
+	}
+	var problem *acme.ProblemDetails
+	if !errors.As(err, &problem) {
+		t.Error("Expected the ACME problem to stay in the error chain")
+	}
+}
+
+func TestWithRetry_NotRetryable(t *testing.T) {
+	waits := recordSleeps(t)
+	boom := errors.New("boom")
+	calls := 0
+	err := withRetry(context.Background(), (&Config{}).RetryPolicy(), "obtain certificate", nil, func() error {
+		calls++
+		return boom
+	})
+	if err != boom || calls != 1 || len(*waits) != 0 {
+		t.Errorf("Expected immediate failure, got %v after %d calls", err, calls)
+	}
+}
+
+func TestWithRetry_RetryAfter(t *testing.T) {
+	waits := recordSleeps(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", r.URL.Query().Get("after"))
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	recorder := newRetryAfterRecorder(nil)
+	client := &http.Client{Transport: recorder}
+	request := func(after string) func() error {
+		return func() error {
+			resp, err := client.Get(server.URL + "?after=" + after)
+			if err != nil {
+				return err
+			}
+			_ = resp.Body.Close()
+			return rateLimitProblem()
+		}
+	}
+	policy := RetryConfig{MaxAttempts: 2, InitialDelay: time.Second, MaxDelay: time.Minute}
+
+	// A Retry-After longer than the backoff is honored
+	if err := withRetry(context.Background(), policy, "obtain certificate", recorder, request("20")); !common.IsRateLimitError(err) {
+		t.Fatalf("Expected rate limit error, got %v", err)
+	}
+	if len(*waits) != 1 || (*waits)[0] != 20*time.Second {
+		t.Errorf("Expected one wait of 20s, got %v", *waits)
+	}
+
+	// A Retry-After beyond max_delay gives up right away
+	*waits = nil
+	err := withRetry(context.Background(), policy, "obtain certificate", recorder, request("3600"))
+	if !common.IsRateLimitError(err) || len(*waits) != 0 {
+		t.Fatalf("Expected immediate rate limit error, got %v after waits %v", err, *waits)
+	}
+	if appErr := common.GetApplicationError(err); appErr.Context["retry_after"] != "1h0m0s" {
+		t.Errorf("Expected retry_after in error context, got %v", appErr.Context)
+	}
+}
+
+func TestRegisterNewAccountWithDeps_RateLimited(t *testing.T) {
+	waits := recordSleeps(t)
+	cfg := &Config{AcmeDnsServer: "https://acme-dns.example.com"}
+	store, err := NewAccountStore(filepath.Join(t.TempDir(), "accounts.json"))
+	if err != nil {
+		t.Fatalf("Failed to create account store: %v", err)
+	}
+
+	throttled := createMockResponse(http.StatusTooManyRequests, "slow down")
+	throttled.Header.Set("Retry-After", "7")
+	mockClient := &mockHTTPClient{
+		responses: []*http.Response{throttled, createMockResponse(http.StatusCreated, createMockAcmeDnsAccountResponse())},
+		errors:    []error{nil, nil},
+	}
+
+	if _, err := RegisterNewAccountWithDeps(cfg, store, "example.com", &mockLogger{}, mockClient); err != nil {
+		t.Fatalf("Expected registration to succeed after retry, got %v", err)
+	}
+	if len(mockClient.requests) != 2 {
+		t.Errorf("Expected 2 requests, got %d", len(mockClient.requests))
+	}
+	if len(*waits) != 1 || (*waits)[0] != 7*time.Second {
+		t.Errorf("Expected one wait of 7s from Retry-After, got %v", *waits)
+	}
+}
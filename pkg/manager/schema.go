@@ -5,7 +5,14 @@ const ConfigSchema = `{
 	"$schema": "https://json-schema.org/draft/2020-12/schema",
 	"title": "go-acme-dns-manager configuration",
 	"type": "object",
-	"required": ["email", "acme_server", "acme_dns_server"],
+	"required": ["email", "acme_server"],
+	"anyOf": [
+		{"required": ["acme_dns_server"]},
+		{
+			"required": ["dns_challenge_provider"],
+			"properties": {"dns_challenge_provider": {"not": {"const": "acmedns"}}}
+		}
+	],
 	"additionalProperties": false,
 	"properties": {
 		"email": {
@@ -21,16 +28,169 @@ const ConfigSchema = `{
 		"acme_dns_server": {
 			"type": "string",
 			"format": "uri",
-			"description": "URL of your acme-dns server"
+			"description": "URL of your acme-dns server, required with the default acmedns challenge provider"
+		},
+		"acme_dns_servers": {
+			"type": "object",
+			"additionalProperties": {"type": "string", "format": "uri"},
+			"description": "acme-dns server URLs keyed by domain suffix, overriding acme_dns_server for matching domains"
+		},
+		"acme_dns_allowfrom": {
+			"type": "array",
+			"items": {"type": "string"},
+			"description": "CIDR ranges new acme-dns accounts accept updates from"
+		},
+		"acme_dns_timeout": {
+			"type": "string",
+			"description": "Timeout for one request to the acme-dns API, defaults to http_timeout. Format: Go duration string"
+		},
+		"account_granularity": {
+			"type": "string",
+			"enum": ["domain", "base_domain"],
+			"description": "Names sharing one acme-dns account: every name with its wildcard (domain, default) or the whole registrable domain (base_domain)"
+		},
+		"accounts_layout": {
+			"type": "string",
+			"enum": ["file", "sharded"],
+			"description": "Storage of the acme-dns accounts: one acme-dns-accounts.json (file, default) or one file per base domain below acme-dns-accounts/ (sharded)"
 		},
 		"key_type": {
 			"type": "string",
-			"enum": ["rsa2048", "rsa3072", "rsa4096", "ec256", "ec384"],
+			"enum": ["rsa2048", "rsa3072", "rsa4096", "ec256", "ec384", "ed25519"],
 			"description": "Key type for the certificate"
 		},
 		"dns_resolver": {
 			"type": "string",
-			"description": "DNS resolver to use for CNAME verification checks"
+			"description": "DNS resolver to use for CNAME verification checks: host[:port], tls://host[:port] (DNS-over-TLS) or an https:// URL (DNS-over-HTTPS)"
+		},
+		"keep_generations": {
+			"type": "integer",
+			"minimum": 0,
+			"description": "Number of replaced certificate generations kept in the archive directory next to the current one, 0 disables the archive unless live_dir is set"
+		},
+		"storage": {
+			"type": "object",
+			"additionalProperties": false,
+			"required": ["backend"],
+			"description": "Backend keeping certificates, keys and accounts",
+			"properties": {
+				"backend": {
+					"type": "string",
+					"enum": ["file", "vault", "s3", "kubernetes", "sqlite"],
+					"description": "Storage backend"
+				},
+				"prefix": {"type": "string", "description": "Vault path, S3 key prefix or Secret name prefix"},
+				"vault_address": {"type": "string", "description": "Vault server URL (default: VAULT_ADDR)"},
+				"vault_token": {"type": "string", "description": "Vault token (default: VAULT_TOKEN)"},
+				"vault_mount": {"type": "string", "minLength": 1, "description": "Mount path of the KV version 2 engine"},
+				"endpoint": {"type": "string", "description": "S3 endpoint URL"},
+				"bucket": {"type": "string", "minLength": 1, "description": "S3 bucket"},
+				"region": {"type": "string", "description": "S3 region used for request signing"},
+				"access_key_id": {"type": "string", "description": "S3 access key ID"},
+				"secret_access_key": {"type": "string", "description": "S3 secret access key"},
+				"namespace": {"type": "string", "description": "Kubernetes namespace of the Secrets"},
+				"api_server": {"type": "string", "description": "Kubernetes API server URL"},
+				"token_file": {"type": "string", "description": "File with the Kubernetes bearer token"},
+				"ca_file": {"type": "string", "description": "CA certificate of the Kubernetes API server"},
+				"path": {"type": "string", "minLength": 1, "description": "SQLite database file, relative to the config file (default: <cert_storage_path>/store.db)"}
+			}
+		},
+		"standby": {
+			"type": "object",
+			"additionalProperties": false,
+			"required": ["lease_file"],
+			"description": "Lease file coordinating two managers running this configuration as primary and hot standby",
+			"properties": {
+				"lease_file": {"type": "string", "minLength": 1, "description": "Lease file on storage shared by both hosts, relative to the config file"},
+				"node": {"type": "string", "minLength": 1, "description": "Name of this manager in the lease (default: the host name)"},
+				"interval": {"type": "string", "description": "Time between runs of both managers, default -daemon-interval. Format: Go duration string"},
+				"missed_cycles": {"type": "integer", "minimum": 1, "description": "Runs the lease holder may miss before the standby takes over (default: 3)"}
+			}
+		},
+		"run_as_user": {
+			"type": "string",
+			"minLength": 1,
+			"description": "User name or id the manager switches to when started as root"
+		},
+		"run_as_group": {
+			"type": "string",
+			"minLength": 1,
+			"description": "Group name or id the manager switches to when started as root (default: the primary group of run_as_user)"
+		},
+		"file_permissions": {
+			"type": "object",
+			"additionalProperties": false,
+			"description": "Modes and ownership of stored certificates, keys and accounts",
+			"properties": {
+				"key_mode": {"type": "string", "pattern": "^0?[0-7]{2}0$", "description": "Octal mode of private keys, metadata and accounts, without access for others (default: 0600)"},
+				"cert_mode": {"type": "string", "pattern": "^0?[0-7]{3}$", "description": "Octal mode of certificates and chains (default: 0644)"},
+				"dir_mode": {"type": "string", "pattern": "^0?[0-7]{3}$", "description": "Octal mode of directories below the storage path (default: 0750)"},
+				"owner": {"type": "string", "minLength": 1, "description": "User name or id owning the files (default: the user running the manager)"},
+				"group": {"type": "string", "minLength": 1, "description": "Group name or id owning the files (default: the primary group of owner)"}
+			}
+		},
+		"include": {
+			"oneOf": [
+				{"type": "string", "minLength": 1},
+				{"type": "array", "items": {"type": "string", "minLength": 1}}
+			],
+			"description": "Glob(s) of files, relative to the config file, mapping certificate names to their settings; merged into auto_domains.certs"
+		},
+		"live_dir": {
+			"type": "string",
+			"minLength": 1,
+			"description": "Directory, relative to the config file, receiving certbot style <cert-name>/ directories of cert.pem, chain.pem, fullchain.pem and privkey.pem symlinks into the latest archive generation"
+		},
+		"run_report": {
+			"type": "string",
+			"minLength": 1,
+			"description": "Path of the JSON report written at the end of every run, relative to the config file"
+		},
+		"dns_instructions_dir": {
+			"type": "string",
+			"minLength": 1,
+			"description": "Directory receiving one <zone>.txt file per DNS zone with the required CNAME records, relative to the config file"
+		},
+		"status_listen": {
+			"type": "string",
+			"minLength": 1,
+			"description": "Listen address of the HTTP status server (/healthz, /readyz, /certs) in auto and daemon mode, e.g. 127.0.0.1:8080"
+		},
+		"profile": {
+			"type": "string",
+			"minLength": 1,
+			"description": "ACME certificate profile requested for new orders (e.g. classic, tlsserver, shortlived)"
+		},
+		"statsd_addr": {
+			"type": "string",
+			"minLength": 1,
+			"description": "host:port of a statsd or DogStatsD agent receiving counters and timings over UDP, e.g. 127.0.0.1:8125"
+		},
+		"statsd_prefix": {
+			"type": "string",
+			"description": "Prefix of the statsd metric names, default acme_dns_manager."
+		},
+		"statsd_tags": {
+			"type": "array",
+			"items": {"type": "string", "pattern": "^[^|,#]+$"},
+			"description": "DogStatsD tags (key:value) added to every sample"
+		},
+		"pushgateway": {
+			"type": "object",
+			"required": ["url"],
+			"additionalProperties": false,
+			"description": "Prometheus Pushgateway receiving the metrics at the end of every run outside daemon mode",
+			"properties": {
+				"url": {
+					"type": "string",
+					"pattern": "^https?://",
+					"description": "Base URL of the Pushgateway, e.g. http://pushgateway:9091"
+				},
+				"job": {"type": "string", "minLength": 1, "description": "Job label, default go-acme-dns-manager"},
+				"instance": {"type": "string", "minLength": 1, "description": "Instance label, default the host name"},
+				"username": {"type": "string", "description": "Basic authentication username"},
+				"password": {"type": "string", "description": "Basic authentication password"}
+			}
 		},
 		"cert_storage_path": {
 			"type": "string",
@@ -44,6 +204,282 @@ const ConfigSchema = `{
 			"type": "string",
 			"description": "Timeout for HTTP requests made to the ACME server. Format: Go duration string"
 		},
+		"proxy_url": {
+			"type": "string",
+			"description": "Proxy URL (http, https or socks5) for the requests to the ACME and acme-dns servers"
+		},
+		"ca_bundle_path": {
+			"type": "string",
+			"minLength": 1,
+			"description": "PEM file with additional CA certificates trusted for the ACME and acme-dns servers, relative to the config file"
+		},
+		"insecure_skip_verify": {
+			"type": "boolean",
+			"description": "Disable TLS certificate verification of the ACME and acme-dns servers (tests only)"
+		},
+		"per_cert_timeout": {
+			"type": "string",
+			"description": "Time limit for obtaining or renewing a single certificate. Format: Go duration string"
+		},
+		"retry": {
+			"type": "object",
+			"additionalProperties": false,
+			"description": "Retry policy for rate limited or temporarily failing ACME and acme-dns requests",
+			"properties": {
+				"max_attempts": {
+					"type": "integer",
+					"minimum": 1,
+					"description": "Attempts per operation, 1 disables retries"
+				},
+				"initial_delay": {
+					"type": "string",
+					"description": "Delay before the first retry, doubled for each further one. Format: Go duration string"
+				},
+				"max_delay": {
+					"type": "string",
+					"description": "Longest delay between attempts and longest Retry-After honored. Format: Go duration string"
+				}
+			}
+		},
+		"events": {
+			"type": "object",
+			"additionalProperties": false,
+			"description": "Publishers for certificate lifecycle events",
+			"properties": {
+				"nats": {
+					"type": "object",
+					"required": ["url", "subject"],
+					"additionalProperties": false,
+					"properties": {
+						"url": {
+							"type": "string",
+							"pattern": "^(nats|tls)://",
+							"description": "NATS server URL (nats:// or tls://)"
+						},
+						"subject": {
+							"type": "string",
+							"minLength": 1,
+							"description": "Subject events are published to"
+						},
+						"username": {"type": "string"},
+						"password": {"type": "string"},
+						"token": {"type": "string"}
+					}
+				},
+				"kafka": {
+					"type": "object",
+					"required": ["brokers", "topic"],
+					"additionalProperties": false,
+					"properties": {
+						"brokers": {
+							"type": "array",
+							"items": {"type": "string"},
+							"minItems": 1,
+							"description": "Kafka bootstrap brokers (host:port)"
+						},
+						"topic": {
+							"type": "string",
+							"minLength": 1,
+							"description": "Topic events are published to"
+						},
+						"username": {"type": "string", "description": "SASL/PLAIN username"},
+						"password": {"type": "string", "description": "SASL/PLAIN password"},
+						"tls": {"type": "boolean", "description": "Connect to the brokers using TLS"}
+					}
+				}
+			}
+		},
+		"notifications": {
+			"type": "object",
+			"additionalProperties": false,
+			"description": "Notifications to people about failures and required DNS changes",
+			"properties": {
+				"smtp": {
+					"type": "object",
+					"required": ["host", "from", "to"],
+					"additionalProperties": false,
+					"properties": {
+						"host": {
+							"type": "string",
+							"minLength": 1,
+							"description": "Mail server host name"
+						},
+						"port": {
+							"type": "integer",
+							"minimum": 1,
+							"maximum": 65535,
+							"description": "Mail server port, default 587, 465 with tls: tls, 25 with tls: none"
+						},
+						"tls": {
+							"type": "string",
+							"enum": ["starttls", "tls", "none"],
+							"description": "Connection security, none only for a relay on localhost"
+						},
+						"username": {"type": "string", "description": "PLAIN authentication username"},
+						"password": {"type": "string", "description": "PLAIN authentication password"},
+						"from": {
+							"type": "string",
+							"minLength": 1,
+							"description": "Sender address, may include a name"
+						},
+						"to": {
+							"type": "array",
+							"items": {"type": "string", "minLength": 1},
+							"minItems": 1,
+							"description": "Recipient addresses"
+						},
+						"events": {
+							"type": "array",
+							"items": {"enum": ["certificate.failed", "dns.setup_needed", "certificate.issued", "certificate.renewed"]},
+							"description": "Event types to notify of, default certificate.failed and dns.setup_needed"
+						}
+					}
+				},
+				"pushover": {
+					"type": "object",
+					"required": ["token", "user"],
+					"additionalProperties": false,
+					"properties": {
+						"token": {"type": "string", "minLength": 1, "description": "Pushover application API token"},
+						"user": {"type": "string", "minLength": 1, "description": "Pushover user or group key"},
+						"priority": {
+							"type": "integer",
+							"minimum": -2,
+							"maximum": 1,
+							"description": "Message priority, 1 bypasses quiet hours"
+						},
+						"events": {
+							"type": "array",
+							"items": {"enum": ["certificate.failed", "dns.setup_needed", "certificate.issued", "certificate.renewed"]},
+							"description": "Event types to notify of, default certificate.failed and dns.setup_needed"
+						}
+					}
+				},
+				"telegram": {
+					"type": "object",
+					"required": ["bot_token", "chat_id"],
+					"additionalProperties": false,
+					"properties": {
+						"bot_token": {"type": "string", "minLength": 1, "description": "Token of the bot sending the messages"},
+						"chat_id": {"type": "string", "minLength": 1, "description": "Numeric chat ID or @channelname"},
+						"events": {
+							"type": "array",
+							"items": {"enum": ["certificate.failed", "dns.setup_needed", "certificate.issued", "certificate.renewed"]},
+							"description": "Event types to notify of, default certificate.failed and dns.setup_needed"
+						}
+					}
+				},
+				"teams": {
+					"type": "object",
+					"required": ["webhook_url"],
+					"additionalProperties": false,
+					"properties": {
+						"webhook_url": {
+							"type": "string",
+							"pattern": "^https?://",
+							"description": "Incoming webhook URL of a Microsoft Teams workflow or connector"
+						},
+						"events": {
+							"type": "array",
+							"items": {"enum": ["certificate.failed", "dns.setup_needed", "certificate.issued", "certificate.renewed"]},
+							"description": "Event types to notify of, default certificate.failed and dns.setup_needed"
+						}
+					}
+				}
+			}
+		},
+		"dns_precheck": {
+			"type": "object",
+			"additionalProperties": false,
+			"description": "CNAME pre-check settings for split-horizon DNS",
+			"properties": {
+				"external_resolver": {
+					"type": "string",
+					"description": "Resolver (host[:port], tls://host[:port] or https:// URL) used for CNAME checks instead of dns_resolver"
+				},
+				"cname_targets": {
+					"type": "object",
+					"description": "Expected externally visible CNAME target per domain",
+					"additionalProperties": {"type": "string", "minLength": 1}
+				},
+				"authoritative": {
+					"type": "boolean",
+					"description": "Also check the CNAME on every authoritative nameserver of the zone"
+				},
+				"concurrency": {
+					"type": "integer",
+					"minimum": 1,
+					"description": "Number of CNAME lookups running at the same time, default 10"
+				},
+				"lookup_timeout": {
+					"type": "string",
+					"description": "Limit of one CNAME check including the authoritative nameservers, e.g. 5s, default 15s"
+				}
+			}
+		},
+		"dns_challenge_provider": {
+			"type": "string",
+			"description": "DNS-01 challenge provider: acmedns (default), exec or a supported lego DNS provider such as cloudflare or rfc2136"
+		},
+		"dns_challenge_credentials": {
+			"type": "object",
+			"additionalProperties": {"type": "string"},
+			"description": "Environment variables configuring the lego DNS challenge provider, e.g. CLOUDFLARE_DNS_API_TOKEN"
+		},
+		"dns_challenge_exec": {
+			"type": "object",
+			"description": "Script answering DNS-01 challenges with the exec challenge provider",
+			"required": ["command"],
+			"additionalProperties": false,
+			"properties": {
+				"command": {
+					"type": "array",
+					"items": {"type": "string"},
+					"minItems": 1,
+					"description": "Script and its arguments, the request is passed as JSON on stdin"
+				},
+				"timeout": {"type": "string", "description": "Timeout of one call of the script (e.g. 2m)"},
+				"ttl": {"type": "integer", "minimum": 1, "description": "TTL of the TXT record passed to the script"}
+			}
+		},
+		"challenge_alias": {
+			"type": "object",
+			"additionalProperties": {"type": "string"},
+			"description": "Alias name per domain the _acme-challenge CNAME points to instead of the acme-dns fulldomain; the alias itself points to acme-dns"
+		},
+		"dns_providers": {
+			"type": "array",
+			"description": "DNS providers creating the acme-dns CNAME records automatically",
+			"items": {
+				"type": "object",
+				"required": ["type", "zones"],
+				"additionalProperties": false,
+				"properties": {
+					"type": {
+						"type": "string",
+						"enum": ["cloudflare", "route53", "rfc2136"],
+						"description": "Provider type"
+					},
+					"zones": {
+						"type": "array",
+						"items": {"type": "string", "minLength": 1},
+						"minItems": 1,
+						"description": "DNS zones managed through this provider"
+					},
+					"ttl": {"type": "integer", "minimum": 1, "description": "TTL of created records"},
+					"api_token": {"type": "string", "description": "Cloudflare API token"},
+					"zone_id": {"type": "string", "description": "Cloudflare zone ID of the first zone in zones (looked up by name if omitted and for the other zones)"},
+					"hosted_zone_id": {"type": "string", "description": "Route53 hosted zone ID"},
+					"access_key_id": {"type": "string", "description": "AWS access key ID"},
+					"secret_access_key": {"type": "string", "description": "AWS secret access key"},
+					"region": {"type": "string", "description": "AWS region used for request signing"},
+					"nameserver": {"type": "string", "description": "RFC2136 server accepting dynamic updates (host[:port])"},
+					"tsig_key": {"type": "string", "description": "TSIG key name"},
+					"tsig_secret": {"type": "string", "description": "Base64 encoded TSIG secret"},
+					"tsig_algorithm": {"type": "string", "description": "TSIG algorithm, e.g. hmac-sha256"}
+				}
+			}
+		},
 		"auto_domains": {
 			"type": "object",
 			"additionalProperties": false,
@@ -55,6 +491,32 @@ const ConfigSchema = `{
 					"description": "Renew certs expiring within this many days",
 					"default": 30
 				},
+				"grace_percent": {
+					"type": "integer",
+					"minimum": 1,
+					"maximum": 99,
+					"description": "Renew certs when less than this percentage of their lifetime is left, instead of grace_days"
+				},
+				"renew_splay": {
+					"type": "string",
+					"description": "Longest delay after the start of a run before a certificate is issued or renewed in auto mode, fixed per certificate name and shorter than 30m. Format: Go duration string"
+				},
+				"max_parallel": {
+					"type": "integer",
+					"minimum": 1,
+					"description": "Number of certificates processed concurrently",
+					"default": 1
+				},
+				"ocsp_check": {
+					"type": "boolean",
+					"description": "Query the OCSP responder of every stored certificate in auto mode and replace revoked certificates"
+				},
+				"duplicate_domains": {
+					"type": "string",
+					"enum": ["warn", "error", "ignore"],
+					"description": "How to treat a domain listed in several certificates",
+					"default": "warn"
+				},
 				"certs": {
 					"type": "object",
 					"additionalProperties": {
@@ -64,7 +526,7 @@ const ConfigSchema = `{
 						"properties": {
 							"key_type": {
 								"type": "string",
-								"enum": ["rsa2048", "rsa3072", "rsa4096", "ec256", "ec384"],
+								"enum": ["rsa2048", "rsa3072", "rsa4096", "ec256", "ec384", "ed25519"],
 								"description": "Override global key_type for this cert",
 								"default": "rsa4096"
 							},
@@ -75,6 +537,113 @@ const ConfigSchema = `{
 								},
 								"minItems": 1,
 								"description": "List of domains to include in the certificate"
+							},
+							"acme_server": {
+								"type": "string",
+								"format": "uri",
+								"description": "ACME server URL for this certificate, overriding the global acme_server; the account is kept separately per server"
+							},
+							"profile": {
+								"type": "string",
+								"minLength": 1,
+								"description": "ACME certificate profile for this certificate, overriding the global profile"
+							},
+							"csr_path": {
+								"type": "string",
+								"minLength": 1,
+								"description": "PEM or DER CSR the certificate is ordered for, the private key stays outside the manager"
+							},
+							"reuse_key": {
+								"type": "boolean",
+								"description": "Keep the private key across renewals so the public key stays the same"
+							},
+							"output_formats": {
+								"type": "array",
+								"items": {
+									"type": "string",
+									"enum": ["pfx", "haproxy_pem", "fullchain_only"]
+								},
+								"description": "Additional output files: pfx (name.pfx), haproxy_pem (name.combined.pem), fullchain_only (name.fullchain.pem)"
+							},
+							"cert_output_dir": {
+								"type": "string",
+								"minLength": 1,
+								"description": "Directory, relative to the config file, also receiving the certificate with its chain"
+							},
+							"key_output_dir": {
+								"type": "string",
+								"minLength": 1,
+								"description": "Directory, relative to the config file, receiving a copy of the private key, which is also kept in the storage directory"
+							},
+							"cert_filename": {
+								"type": "string",
+								"minLength": 1,
+								"description": "Go template of the certificate file name in cert_output_dir, e.g. {{.Name}}-{{.NotAfter.Year}}.pem (default: {{.Name}}.crt)"
+							},
+							"key_filename": {
+								"type": "string",
+								"minLength": 1,
+								"description": "Go template of the key file name in key_output_dir (default: {{.Name}}.key)"
+							},
+							"tlsa": {
+								"type": "object",
+								"description": "DANE TLSA records written after each issuance",
+								"required": ["ports"],
+								"additionalProperties": false,
+								"properties": {
+									"ports": {
+										"type": "array",
+										"minItems": 1,
+										"items": {"type": "string", "pattern": "^[0-9]{1,5}(/(tcp|udp|sctp))?$"},
+										"description": "Ports of the service, e.g. 443 or 25/tcp"
+									},
+									"usages": {
+										"type": "array",
+										"items": {"type": "string", "enum": ["3 1 1", "2 1 1"]},
+										"description": "TLSA usages, default both"
+									},
+									"format": {
+										"type": "string",
+										"enum": ["zone", "json"],
+										"description": "zone (name.tlsa, default) or json (name.tlsa.json)"
+									}
+								}
+							},
+							"pfx_password": {
+								"type": "string",
+								"description": "Password protecting the PKCS#12 file"
+							},
+							"pfx_encoding": {
+								"type": "string",
+								"enum": ["modern", "legacy"],
+								"description": "PKCS#12 encryption: modern (AES) or legacy (3DES/RC2) for old Windows and Java versions"
+							},
+							"deploy": {
+								"type": "object",
+								"additionalProperties": false,
+								"description": "Targets the certificate is copied to after it changed",
+								"properties": {
+									"ssh": {
+										"type": "array",
+										"items": {
+											"type": "object",
+											"additionalProperties": false,
+											"required": ["host", "user", "cert_path", "key_path"],
+											"properties": {
+												"host": {"type": "string", "minLength": 1, "description": "Remote host[:port]"},
+												"user": {"type": "string", "minLength": 1, "description": "Remote user"},
+												"key_file": {"type": "string", "description": "SSH private key, the SSH agent is used if omitted"},
+												"known_hosts": {"type": "string", "description": "known_hosts file verifying the host key"},
+												"host_key": {"type": "string", "description": "Pinned host key in authorized_keys format"},
+												"cert_path": {"type": "string", "minLength": 1, "description": "Remote path of the certificate"},
+												"key_path": {"type": "string", "minLength": 1, "description": "Remote path of the private key"},
+												"issuer_path": {"type": "string", "description": "Remote path of the issuer certificate"},
+												"reload_command": {"type": "string", "description": "Command run on the remote host after the copy"},
+												"timeout": {"type": "string", "description": "Connection and command timeout (e.g. 30s)"}
+											}
+										}
+									}
+								}
 							}
 						}
 					}
@@ -0,0 +1,100 @@
+package manager
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+// stagingIssuerMarkers identify the test CAs of Let's Encrypt and Pebble in
+// the issuer common name, e.g. "(STAGING) Ersatz Edamame E1" or
+// "Fake LE Intermediate X1"
+var stagingIssuerMarkers = []string{"(STAGING)", "Fake LE", "Happy Hacker Fake CA", "Pebble"}
+
+// IsStagingServer reports whether an ACME directory URL belongs to a test CA
+// whose certificates are not trusted by browsers
+func IsStagingServer(url string) bool {
+	url = strings.ToLower(url)
+	return strings.Contains(url, "staging") || strings.Contains(url, "pebble")
+}
+
+// IsStagingIssuer reports whether an issuer common name belongs to a test CA
+func IsStagingIssuer(issuer string) bool {
+	for _, marker := range stagingIssuerMarkers {
+		if strings.Contains(issuer, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// stagingReason returns why a stored certificate has to be replaced because
+// it was issued by a test CA while the certificate is configured for a
+// production ACME server, or "" if that is not the case
+func stagingReason(cfg *Config, certName string, info *certinfo.Info) string {
+	server := cfg.ForCert(certName).AcmeServer
+	if server == "" || IsStagingServer(server) || !IsStagingIssuer(info.Issuer) {
+		return ""
+	}
+	return fmt.Sprintf("certificate was issued by the staging CA %q but acme_server is %s", info.Issuer, server)
+}
+
+// acmeServerReason returns why a stored certificate has to be replaced
+// because it was ordered from another ACME server than the configured one,
+// or "" if not. Certificates without a recorded server, issued elsewhere or
+// stored by an older version, are not compared.
+func acmeServerReason(cfg *Config, certName string) string {
+	server := cfg.ForCert(certName).AcmeServer
+	if server == "" {
+		return ""
+	}
+	metadata, err := LoadCertMetadata(cfg, certName)
+	if err != nil || metadata.AcmeServer == "" || sameAcmeServer(metadata.AcmeServer, server) {
+		return ""
+	}
+	return fmt.Sprintf("certificate was issued by %s (%s) but acme_server is %s", metadata.AcmeServer, metadata.Issuer, server)
+}
+
+// sameAcmeServer compares two ACME directory URLs, ignoring case and a
+// trailing slash
+func sameAcmeServer(a, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "/"), strings.TrimSuffix(b, "/"))
+}
+
+// checkIssuerTrusted verifies the stored certificate bundle against the
+// system roots and the certificates of caBundlePath, if set. Other private
+// CAs fail this check as well, so the result is only a warning and never
+// forces a new certificate.
+func checkIssuerTrusted(certPath, caBundlePath string) error {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return err
+	}
+	chain, err := certcrypto.ParsePEMBundle(data)
+	if err != nil {
+		return err
+	}
+	var roots *x509.CertPool
+	if caBundlePath != "" {
+		if roots, err = loadCABundle(caBundlePath, nil); err != nil {
+			return err
+		}
+	} else if roots, err = x509.SystemCertPool(); err != nil {
+		// Nothing to check against
+		return nil
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   chain[0].NotBefore,
+	})
+	return err
+}
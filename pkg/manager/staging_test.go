@@ -0,0 +1,148 @@
+package manager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+func TestIsStagingServer(t *testing.T) {
+	for url, want := range map[string]bool{
+		"https://acme-staging-v02.api.letsencrypt.org/directory": true,
+		"https://pebble:14000/dir":                               true,
+		"https://acme-v02.api.letsencrypt.org/directory":         false,
+		"https://acme.zerossl.com/v2/DV90":                       false,
+	} {
+		if got := IsStagingServer(url); got != want {
+			t.Errorf("IsStagingServer(%q): expected %v, got %v", url, want, got)
+		}
+	}
+}
+
+func TestDetermineAction_StagingCertificate(t *testing.T) {
+	storage := t.TempDir()
+	paths := certinfo.PathsFor(storage, "web")
+	if err := os.MkdirAll(filepath.Dir(paths.Metadata), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(paths.Metadata, []byte(`{"domain":"example.com"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "(STAGING) Ersatz Edamame E1"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	if err := os.WriteFile(paths.Certificate, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		CertStoragePath: storage,
+		AcmeServer:      "https://acme-staging-v02.api.letsencrypt.org/directory",
+		AutoDomains:     &AutoDomainsConfig{Certs: map[string]CertConfig{"web": {Domains: []string{"example.com"}, KeyType: "ec256"}}},
+	}
+	decision, err := DetermineAction(cfg, "web", []string{"example.com"}, "ec256", 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("DetermineAction failed: %v", err)
+	}
+	if decision.Action != ActionSkip {
+		t.Errorf("Expected %s while staging is configured, got %s (%q)", ActionSkip, decision.Action, decision.Reason)
+	}
+
+	// Switching to production replaces the staging certificate
+	cfg.AcmeServer = "https://acme-v02.api.letsencrypt.org/directory"
+	decision, err = DetermineAction(cfg, "web", []string{"example.com"}, "ec256", 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("DetermineAction failed: %v", err)
+	}
+	if decision.Action != ActionInit || !strings.Contains(decision.Reason, "staging CA") {
+		t.Errorf("Expected %s for a staging certificate, got %s (%q)", ActionInit, decision.Action, decision.Reason)
+	}
+
+	drifts, err := Diff(cfg)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	want := `web: issued by staging CA "(STAGING) Ersatz Edamame E1", acme_server is https://acme-v02.api.letsencrypt.org/directory`
+	if len(drifts) != 1 || drifts[0].String() != want {
+		t.Errorf("Expected staging drift %q, got %v", want, drifts)
+	}
+}
+
+func TestDetermineAction_AcmeServerChanged(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir(), AcmeServer: "https://ca-a.example.org/directory"}
+	if err := saveCertificates(cfg, "web", createSignedResource(t), cfg.AcmeServer); err != nil {
+		t.Fatalf("saveCertificates failed: %v", err)
+	}
+	metadata, err := LoadCertMetadata(cfg, "web")
+	if err != nil {
+		t.Fatalf("LoadCertMetadata failed: %v", err)
+	}
+	if metadata.AcmeServer != cfg.AcmeServer || metadata.Issuer != "CN=Test CA" || metadata.Domain != "example.com" {
+		t.Errorf("Expected the issuing CA in the metadata, got %+v", metadata)
+	}
+
+	cfg.AcmeServer = "https://CA-A.example.org/directory/"
+	decision, err := DetermineAction(cfg, "web", []string{"example.com"}, "ec256", time.Hour)
+	if err != nil || decision.Action != ActionSkip {
+		t.Errorf("Expected %s for the same server, got %s (%q, %v)", ActionSkip, decision.Action, decision.Reason, err)
+	}
+
+	// Switching CAs replaces the certificate
+	cfg.AcmeServer = "https://ca-b.example.org/directory"
+	decision, err = DetermineAction(cfg, "web", []string{"example.com"}, "ec256", time.Hour)
+	if err != nil || decision.Action != ActionInit || !strings.Contains(decision.Reason, "issued by https://ca-a.example.org/directory (CN=Test CA)") {
+		t.Errorf("Expected %s for another server, got %s (%q, %v)", ActionInit, decision.Action, decision.Reason, err)
+	}
+
+	// Certificates issued elsewhere are not compared
+	if err := saveCertificates(cfg, "adopted", createSignedResource(t), ""); err != nil {
+		t.Fatalf("saveCertificates failed: %v", err)
+	}
+	decision, err = DetermineAction(cfg, "adopted", []string{"example.com"}, "ec256", time.Hour)
+	if err != nil || decision.Action != ActionSkip {
+		t.Errorf("Expected %s without a recorded server, got %s (%q, %v)", ActionSkip, decision.Action, decision.Reason, err)
+	}
+}
+
+func TestCheckIssuerTrusted_CABundle(t *testing.T) {
+	dir := t.TempDir()
+	certPEM, _, caPEM := newTestChain(t, []string{"example.com"})
+	certPath := filepath.Join(dir, "example.crt")
+	bundlePath := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(certPath, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bundlePath, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkIssuerTrusted(certPath, ""); err == nil {
+		t.Error("Expected a private CA not to be trusted by the system roots")
+	}
+	if err := checkIssuerTrusted(certPath, bundlePath); err != nil {
+		t.Errorf("Expected the CA of ca_bundle_path to be trusted, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultMissedCycles is the number of cycles a lease holder may miss before
+// the standby takes over
+const DefaultMissedCycles = 3
+
+// StandbyConfig lets two managers run the same configuration as primary and
+// hot standby. The one holding the lease file processes the certificates and
+// renews the lease with every run; the other only reads it and takes over
+// once the holder has not renewed it for missed_cycles runs.
+type StandbyConfig struct {
+	LeaseFile    string        `yaml:"lease_file"`              // On storage shared by both hosts, e.g. an NFS mount
+	Node         string        `yaml:"node,omitempty"`          // Name of this manager in the lease (default: the host name)
+	Interval     time.Duration `yaml:"interval,omitempty"`      // Time between runs (default: -daemon-interval)
+	MissedCycles int           `yaml:"missed_cycles,omitempty"` // Runs the holder may miss before the standby takes over (default: 3)
+}
+
+// Lease is the content of the lease file
+type Lease struct {
+	Holder   string    `json:"holder"`
+	PID      int       `json:"pid"`
+	Acquired time.Time `json:"acquired"` // When the holder took the lease over
+	Renewed  time.Time `json:"renewed"`
+	Expires  time.Time `json:"expires"`
+}
+
+// LeaseResult is the outcome of AcquireLease
+type LeaseResult struct {
+	Held     bool   // This manager holds the lease and processes the certificates
+	TookOver bool   // The lease was taken over from another holder
+	Lease    *Lease // The lease as it is now
+	Previous *Lease // The lease before, nil if there was none
+}
+
+// leaseSettleDelay is the time between writing a lease taken from another
+// holder and reading it back, so of two managers taking over at the same
+// moment only the one writing last goes ahead
+var leaseSettleDelay = 2 * time.Second
+
+// validateStandby checks that a standby configuration stores certificates
+// and accounts where both hosts reach them. With local storage the standby
+// taking over would issue every certificate again and register new acme-dns
+// accounts, and a shared cert_storage_path is locked by the primary.
+func (cfg *Config) validateStandby() error {
+	if cfg.Standby == nil {
+		return nil
+	}
+	if !cfg.IsRemoteStorage() {
+		return errors.New("standby needs a remote storage backend both hosts use, the file backend keeps the certificates local")
+	}
+	if cfg.Storage.Backend == StorageBackendSQLite && cfg.Storage.Path == "" {
+		return errors.New("standby with the sqlite storage backend needs storage.path on storage both hosts share, the default is below cert_storage_path")
+	}
+	return nil
+}
+
+// StandbyNode returns the name of this manager in the lease file
+func (s *StandbyConfig) StandbyNode() string {
+	if s.Node != "" {
+		return s.Node
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "unknown"
+	}
+	return host
+}
+
+// AcquireLease takes or renews the lease of s for a run starting at now.
+// interval is the time between runs, the lease expires after MissedCycles of
+// them. A valid lease of another holder is left alone and Held is false.
+func AcquireLease(s *StandbyConfig, interval time.Duration, now time.Time) (*LeaseResult, error) {
+	node := s.StandbyNode()
+	missed := s.MissedCycles
+	if missed <= 0 {
+		missed = DefaultMissedCycles
+	}
+
+	previous, err := ReadLease(s.LeaseFile)
+	if err != nil {
+		return nil, err
+	}
+	result := &LeaseResult{Previous: previous}
+	if previous != nil && previous.Holder != node && now.Before(previous.Expires) {
+		result.Lease = previous
+		return result, nil
+	}
+
+	lease := &Lease{Holder: node, PID: os.Getpid(), Acquired: now.UTC(), Renewed: now.UTC(), Expires: now.Add(time.Duration(missed) * interval).UTC()}
+	renewal := previous != nil && previous.Holder == node && now.Before(previous.Expires)
+	if renewal {
+		lease.Acquired = previous.Acquired
+	}
+	data, err := json.MarshalIndent(lease, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.LeaseFile), DirPermissions); err != nil {
+		return nil, fmt.Errorf("creating directory of lease file %s: %w", s.LeaseFile, err)
+	}
+	if err := writeFileAtomic(s.LeaseFile, append(data, '\n'), CertificatePermissions); err != nil {
+		return nil, fmt.Errorf("writing lease file %s: %w", s.LeaseFile, err)
+	}
+
+	if !renewal {
+		// The other manager may have found the lease expired as well
+		time.Sleep(leaseSettleDelay)
+		current, err := ReadLease(s.LeaseFile)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil || current.Holder != node || !current.Renewed.Equal(lease.Renewed) {
+			result.Lease = current
+			return result, nil
+		}
+	}
+	result.Held = true
+	result.TookOver = previous != nil && previous.Holder != node
+	result.Lease = lease
+	return result, nil
+}
+
+// ReadLease returns the lease in path, nil if there is none yet
+func ReadLease(path string) (*Lease, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading lease file %s: %w", path, err)
+	}
+	var lease Lease
+	if err := json.Unmarshal(data, &lease); err != nil {
+		// A lease cut short by a crash is no lease, the next run writes a new one
+		DefaultLogger.Warnf("Warning: ignoring unreadable lease file %s: %v", path, err)
+		return nil, nil
+	}
+	return &lease, nil
+}
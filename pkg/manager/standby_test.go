@@ -0,0 +1,81 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireLease(t *testing.T) {
+	defer func(d time.Duration) { leaseSettleDelay = d }(leaseSettleDelay)
+	leaseSettleDelay = 0
+
+	leaseFile := filepath.Join(t.TempDir(), "shared", "manager.lease")
+	primary := &StandbyConfig{LeaseFile: leaseFile, Node: "acme-1"}
+	standby := &StandbyConfig{LeaseFile: leaseFile, Node: "acme-2", MissedCycles: 2}
+	interval := time.Hour
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	result, err := AcquireLease(primary, interval, start)
+	if err != nil || !result.Held || result.TookOver || result.Previous != nil {
+		t.Fatalf("Expected the first manager to take a new lease, got %+v, %v", result, err)
+	}
+	if want := start.Add(DefaultMissedCycles * interval); !result.Lease.Expires.Equal(want) {
+		t.Errorf("Expected the lease to expire at %s, got %s", want, result.Lease.Expires)
+	}
+
+	result, err = AcquireLease(standby, interval, start.Add(time.Minute))
+	if err != nil || result.Held || result.Lease.Holder != "acme-1" {
+		t.Fatalf("Expected the standby to leave a valid lease alone, got %+v, %v", result, err)
+	}
+
+	// Renewing keeps the time the lease was acquired
+	result, err = AcquireLease(primary, interval, start.Add(interval))
+	if err != nil || !result.Held || !result.Lease.Acquired.Equal(start) {
+		t.Fatalf("Expected the holder to renew its lease, got %+v, %v", result, err)
+	}
+
+	// The primary misses its cycles, the standby takes over
+	result, err = AcquireLease(standby, interval, start.Add(interval+3*interval))
+	if err != nil || !result.Held || !result.TookOver || result.Previous.Holder != "acme-1" {
+		t.Fatalf("Expected the standby to take over an expired lease, got %+v, %v", result, err)
+	}
+	result, err = AcquireLease(primary, interval, start.Add(5*interval))
+	if err != nil || result.Held || result.Lease.Holder != "acme-2" {
+		t.Errorf("Expected the old primary to stand by now, got %+v, %v", result, err)
+	}
+}
+
+func TestReadLease_Unreadable(t *testing.T) {
+	leaseFile := filepath.Join(t.TempDir(), "manager.lease")
+	if lease, err := ReadLease(leaseFile); lease != nil || err != nil {
+		t.Errorf("Expected no lease for a missing file, got %+v, %v", lease, err)
+	}
+	if err := os.WriteFile(leaseFile, []byte(`{"holder": "acme-1", "exp`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if lease, err := ReadLease(leaseFile); lease != nil || err != nil {
+		t.Errorf("Expected a truncated lease to count as none, got %+v, %v", lease, err)
+	}
+}
+
+func TestConfig_ValidateStandby(t *testing.T) {
+	standby := &StandbyConfig{LeaseFile: "/shared/lease"}
+	for _, tc := range []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"no standby", Config{}, false},
+		{"local storage", Config{Standby: standby}, true},
+		{"file backend", Config{Standby: standby, Storage: &StorageConfig{Backend: StorageBackendFile}}, true},
+		{"sqlite below cert_storage_path", Config{Standby: standby, Storage: &StorageConfig{Backend: StorageBackendSQLite}}, true},
+		{"sqlite on shared storage", Config{Standby: standby, Storage: &StorageConfig{Backend: StorageBackendSQLite, Path: "/shared/store.db"}}, false},
+		{"s3", Config{Standby: standby, Storage: &StorageConfig{Backend: StorageBackendS3}}, false},
+	} {
+		if err := tc.cfg.validateStandby(); (err != nil) != tc.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tc.name, tc.wantErr, err)
+		}
+	}
+}
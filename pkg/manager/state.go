@@ -0,0 +1,315 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+// StateFile records below the storage path what was last requested for each
+// certificate and how it went. -diff compares it with the configuration.
+const StateFile = "state.json"
+
+// stateVersion is the format version written to new state files
+const stateVersion = 1
+
+// stateMu serializes state updates of parallel certificate workers
+var stateMu sync.Mutex
+
+// State is the content of the state file
+type State struct {
+	Version      int                  `json:"version"`
+	Certificates map[string]CertState `json:"certificates"`
+
+	// Issuances are the certificates obtained within RateLimitWindow
+	Issuances []Issuance `json:"issuances,omitempty"`
+}
+
+// CertState is what was last applied to a certificate and how its last run
+// went. Domains, KeyType and AcmeServer only change when it is issued or
+// renewed, they are empty until then.
+type CertState struct {
+	Domains    []string  `json:"domains"`
+	KeyType    string    `json:"key_type"`
+	AcmeServer string    `json:"acme_server"`
+	LastResult string    `json:"last_result"` // Outcome of the last run, e.g. issued, renewed, skipped, failed
+	LastError  string    `json:"last_error,omitempty"`
+	LastRun    time.Time `json:"last_run"`
+
+	// Deployments maps deploy targets to the SHA-256 of the certificate last
+	// copied there. It is kept when the rest of the state is updated.
+	Deployments map[string]string `json:"deployments,omitempty"`
+
+	// OCSP is the result of the last OCSP check, kept like Deployments
+	OCSP *OCSPState `json:"ocsp,omitempty"`
+
+	// OutputFiles are the files last written to cert_output_dir and
+	// key_output_dir, kept like Deployments. -delete removes them.
+	OutputFiles []string `json:"output_files,omitempty"`
+
+	// KeyFile is the private key in key_output_dir, kept like Deployments.
+	// When set it is the only copy, the storage directory has no key.
+	KeyFile string `json:"key_file,omitempty"`
+}
+
+// LoadState reads the state file of a storage directory, a missing file
+// yields an empty state
+func LoadState(storagePath string) (*State, error) {
+	state := &State{Version: stateVersion, Certificates: make(map[string]CertState)}
+	path := filepath.Join(storagePath, StateFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading state file: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("parsing state file %s: %w", path, err)
+	}
+	if state.Certificates == nil {
+		state.Certificates = make(map[string]CertState)
+	}
+	return state, nil
+}
+
+// UpdateCertState records the state of one certificate
+func UpdateCertState(storagePath, certName string, certState CertState) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	state, err := LoadState(storagePath)
+	if err != nil {
+		return err
+	}
+	if certState.Deployments == nil {
+		certState.Deployments = state.Certificates[certName].Deployments
+	}
+	if certState.OCSP == nil {
+		certState.OCSP = state.Certificates[certName].OCSP
+	}
+	if certState.OutputFiles == nil {
+		certState.OutputFiles = state.Certificates[certName].OutputFiles
+	}
+	if certState.KeyFile == "" {
+		certState.KeyFile = state.Certificates[certName].KeyFile
+	}
+	state.Certificates[certName] = certState
+	return saveState(storagePath, state)
+}
+
+// recordOutputFiles replaces the output files and the private key file of a
+// certificate in the state and returns the output files recorded before
+func recordOutputFiles(storagePath, certName string, files []string, keyFile string) ([]string, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	state, err := LoadState(storagePath)
+	if err != nil {
+		return nil, err
+	}
+	certState, ok := state.Certificates[certName]
+	if !ok && len(files) == 0 && keyFile == "" {
+		return nil, nil
+	}
+	previous := certState.OutputFiles
+	certState.OutputFiles = files
+	certState.KeyFile = keyFile
+	state.Certificates[certName] = certState
+	return previous, saveState(storagePath, state)
+}
+
+// RecordDeployment remembers that the certificate with the given fingerprint
+// was deployed to target
+func RecordDeployment(storagePath, certName, target, fingerprint string) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	state, err := LoadState(storagePath)
+	if err != nil {
+		return err
+	}
+	certState := state.Certificates[certName]
+	if certState.Deployments == nil {
+		certState.Deployments = make(map[string]string)
+	}
+	certState.Deployments[target] = fingerprint
+	state.Certificates[certName] = certState
+	return saveState(storagePath, state)
+}
+
+// RemoveCertState drops the entry of a certificate from the state file and
+// reports whether there was one
+func RemoveCertState(storagePath, certName string) (bool, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	state, err := LoadState(storagePath)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := state.Certificates[certName]; !ok {
+		return false, nil
+	}
+	delete(state.Certificates, certName)
+	return true, saveState(storagePath, state)
+}
+
+// saveState writes the state file, the caller holds stateMu
+func saveState(storagePath string, state *State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshalling state: %w", err)
+	}
+	path := filepath.Join(storagePath, StateFile)
+	if err := os.MkdirAll(storagePath, DirPermissions); err != nil {
+		return fmt.Errorf("creating storage directory: %w", err)
+	}
+	if err := writeFileAtomic(path, data, PrivateKeyPermissions); err != nil {
+		return fmt.Errorf("writing state file %s: %w", path, err)
+	}
+	recordManifest(storagePath, path)
+	return nil
+}
+
+// EffectiveKeyType returns the key type a certificate is issued with, the
+// requested one if valid, otherwise DefaultKeyType
+func EffectiveKeyType(keyType string) string {
+	if keyType != "" && isValidKeyType(keyType) {
+		return keyType
+	}
+	return DefaultKeyType
+}
+
+// Kinds of drift reported by Diff
+const (
+	DriftNew        = "new"         // Configured, never requested and not stored
+	DriftRemoved    = "removed"     // Requested before or stored, no longer configured
+	DriftDomains    = "domains"     // Domains added to or removed from the configuration
+	DriftKeyType    = "key-type"    // Configured key type differs
+	DriftAcmeServer = "acme-server" // Configured ACME server differs from the last request or the issuing one
+	DriftStaging    = "staging"     // Stored certificate is from a staging CA, the configured server is production
+)
+
+// Drift is one difference between the configuration and the last requested
+// or the stored state of a certificate
+type Drift struct {
+	CertName string   `json:"cert_name"`
+	Kind     string   `json:"kind"`
+	Source   string   `json:"source,omitempty"`  // "state" or "certificate", what the configuration was compared with
+	Added    []string `json:"added,omitempty"`   // Domains configured but missing from the source
+	Removed  []string `json:"removed,omitempty"` // Domains in the source no longer configured
+	From     string   `json:"from,omitempty"`    // Old and new value for key type and ACME server changes, issuer and server for staging
+	To       string   `json:"to,omitempty"`
+}
+
+// String describes the drift in one line
+func (d Drift) String() string {
+	switch d.Kind {
+	case DriftNew:
+		return fmt.Sprintf("%s: not issued yet", d.CertName)
+	case DriftRemoved:
+		return fmt.Sprintf("%s: no longer configured", d.CertName)
+	case DriftDomains:
+		msg := fmt.Sprintf("%s: domains differ from %s:", d.CertName, d.Source)
+		for _, domain := range d.Added {
+			msg += " +" + domain
+		}
+		for _, domain := range d.Removed {
+			msg += " -" + domain
+		}
+		return msg
+	case DriftStaging:
+		return fmt.Sprintf("%s: issued by staging CA %q, acme_server is %s", d.CertName, d.From, d.To)
+	}
+	return fmt.Sprintf("%s: %s changes from %s to %s (%s)", d.CertName, d.Kind, d.From, d.To, d.Source)
+}
+
+// Diff compares the auto_domains certificates of the configuration with the
+// state file and the stored certificates. It reports domains that were added
+// or removed, key type and ACME server changes, staging certificates kept
+// for a production server, and certificates that were added to or removed
+// from the configuration.
+func Diff(cfg *Config) ([]Drift, error) {
+	state, err := LoadState(cfg.CertStoragePath)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := certinfo.List(cfg.CertStoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var configured map[string]CertConfig
+	if cfg.AutoDomains != nil {
+		configured = cfg.AutoDomains.Certs
+	}
+	names := make(map[string]bool)
+	for name := range configured {
+		names[name] = true
+	}
+	for name := range state.Certificates {
+		names[name] = true
+	}
+	for _, name := range stored {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var drifts []Drift
+	for _, name := range sorted {
+		certCfg, isConfigured := configured[name]
+		last, hasState := state.Certificates[name]
+		info, loadErr := certinfo.Load(certinfo.PathsFor(cfg.CertStoragePath, name).Certificate)
+		hasCert := loadErr == nil
+
+		if !isConfigured {
+			drifts = append(drifts, Drift{CertName: name, Kind: DriftRemoved})
+			continue
+		}
+		applied := hasState && len(last.Domains) > 0
+		if !applied && !hasCert {
+			drifts = append(drifts, Drift{CertName: name, Kind: DriftNew})
+			continue
+		}
+
+		keyType := EffectiveKeyType(certCfg.KeyType)
+		if applied {
+			if added, removed := certinfo.CompareDomains(last.Domains, certCfg.Domains); len(added) > 0 || len(removed) > 0 {
+				drifts = append(drifts, Drift{CertName: name, Kind: DriftDomains, Source: "state", Added: added, Removed: removed})
+			}
+			if last.KeyType != keyType {
+				drifts = append(drifts, Drift{CertName: name, Kind: DriftKeyType, Source: "state", From: last.KeyType, To: keyType})
+			}
+			if server := cfg.ForCert(name).AcmeServer; last.AcmeServer != server {
+				drifts = append(drifts, Drift{CertName: name, Kind: DriftAcmeServer, Source: "state", From: last.AcmeServer, To: server})
+			}
+		}
+		if hasCert {
+			if added, removed := info.CompareDomains(certCfg.Domains); len(added) > 0 || len(removed) > 0 {
+				drifts = append(drifts, Drift{CertName: name, Kind: DriftDomains, Source: "certificate", Added: added, Removed: removed})
+			}
+			if info.KeyAlgorithm != keyType {
+				drifts = append(drifts, Drift{CertName: name, Kind: DriftKeyType, Source: "certificate", From: info.KeyAlgorithm, To: keyType})
+			}
+			if metadata, err := LoadCertMetadata(cfg, name); err == nil && metadata.AcmeServer != "" {
+				if server := cfg.ForCert(name).AcmeServer; !sameAcmeServer(metadata.AcmeServer, server) {
+					drifts = append(drifts, Drift{CertName: name, Kind: DriftAcmeServer, Source: "certificate", From: metadata.AcmeServer, To: server})
+				}
+			}
+			if stagingReason(cfg, name, info) != "" {
+				drifts = append(drifts, Drift{CertName: name, Kind: DriftStaging, Source: "certificate", From: info.Issuer, To: cfg.ForCert(name).AcmeServer})
+			}
+		}
+	}
+	return drifts, nil
+}
@@ -0,0 +1,139 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+func TestLoadState_Missing(t *testing.T) {
+	state, err := LoadState(t.TempDir())
+	if err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+	if len(state.Certificates) != 0 {
+		t.Errorf("Expected empty state, got %+v", state.Certificates)
+	}
+}
+
+func TestUpdateCertState(t *testing.T) {
+	dir := t.TempDir()
+	web := CertState{Domains: []string{"example.com"}, KeyType: "ec256", AcmeServer: "https://acme.invalid", LastResult: "issued", LastRun: time.Now().UTC().Truncate(time.Second)}
+	if err := UpdateCertState(dir, "web", web); err != nil {
+		t.Fatalf("UpdateCertState failed: %v", err)
+	}
+	if err := UpdateCertState(dir, "mail", CertState{Domains: []string{"mail.example.com"}, LastResult: "failed", LastError: "boom"}); err != nil {
+		t.Fatalf("UpdateCertState failed: %v", err)
+	}
+
+	state, err := LoadState(dir)
+	if err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+	if len(state.Certificates) != 2 {
+		t.Fatalf("Expected 2 certificates, got %d", len(state.Certificates))
+	}
+	if got := state.Certificates["web"]; !reflect.DeepEqual(got, web) {
+		t.Errorf("Expected %+v, got %+v", web, got)
+	}
+	if state.Certificates["mail"].LastError != "boom" {
+		t.Errorf("Expected last error boom, got %q", state.Certificates["mail"].LastError)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, StateFile))
+	if err != nil {
+		t.Fatalf("State file missing: %v", err)
+	}
+	if info.Mode().Perm() != PrivateKeyPermissions {
+		t.Errorf("Expected permissions %o, got %o", PrivateKeyPermissions, info.Mode().Perm())
+	}
+
+	report, err := Fsck(dir, false)
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	if len(report.Issues) != 0 {
+		t.Errorf("Expected state file in manifest, got %+v", report.Issues)
+	}
+}
+
+func TestLoadState_Corrupt(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, StateFile), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadState(dir); err == nil {
+		t.Error("Expected error for corrupt state file")
+	}
+}
+
+func TestEffectiveKeyType(t *testing.T) {
+	for keyType, want := range map[string]string{"": DefaultKeyType, "ec384": "ec384", "bogus": DefaultKeyType} {
+		if got := EffectiveKeyType(keyType); got != want {
+			t.Errorf("EffectiveKeyType(%q): expected %s, got %s", keyType, want, got)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{
+		CertStoragePath: dir,
+		AcmeServer:      "https://acme.invalid/new",
+		AutoDomains: &AutoDomainsConfig{Certs: map[string]CertConfig{
+			"web":   {Domains: []string{"example.com", "www.example.com"}, KeyType: "rsa2048"},
+			"fresh": {Domains: []string{"fresh.example.com"}},
+			"same":  {Domains: []string{"same.example.com"}, KeyType: "rsa2048"},
+			"stuck": {Domains: []string{"stuck.example.com"}},
+		}},
+	}
+
+	// The stored certificate has an RSA 2048 key and lacks www.example.com
+	paths := certinfo.PathsFor(dir, "web")
+	if err := os.MkdirAll(filepath.Dir(paths.Certificate), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := createTestCertificateWithDomains(paths.Certificate, paths.PrivateKey, []string{"example.com", "old.example.com"}); err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	samePaths := certinfo.PathsFor(dir, "same")
+	if err := createTestCertificateWithDomains(samePaths.Certificate, samePaths.PrivateKey, []string{"same.example.com"}); err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	if err := UpdateCertState(dir, "web", CertState{Domains: []string{"example.com"}, KeyType: "ec256", AcmeServer: "https://acme.invalid/old"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateCertState(dir, "gone", CertState{Domains: []string{"gone.example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	// Failed so far, nothing was applied
+	if err := UpdateCertState(dir, "stuck", CertState{LastResult: "failed", LastError: "boom"}); err != nil {
+		t.Fatal(err)
+	}
+
+	drifts, err := Diff(cfg)
+	if err != nil {
+		t.Fatalf("Diff failed: %v", err)
+	}
+	var got []string
+	for _, d := range drifts {
+		got = append(got, d.String())
+	}
+	want := []string{
+		"fresh: not issued yet",
+		"gone: no longer configured",
+		"stuck: not issued yet",
+		"web: domains differ from state: +www.example.com",
+		"web: key-type changes from ec256 to rsa2048 (state)",
+		"web: acme-server changes from https://acme.invalid/old to https://acme.invalid/new (state)",
+		"web: domains differ from certificate: +www.example.com -old.example.com",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected drift:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
@@ -0,0 +1,69 @@
+package manager
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// StatsSink receives counters and timings of issuance attempts, DNS
+// pre-checks and ACME requests, e.g. to forward them to statsd
+type StatsSink interface {
+	Count(name string, value int64, tags ...string)
+	Timing(name string, d time.Duration, tags ...string)
+}
+
+// nopStats is the sink of a configuration without one
+type nopStats struct{}
+
+func (nopStats) Count(string, int64, ...string)          {}
+func (nopStats) Timing(string, time.Duration, ...string) {}
+
+// stats returns the sink of cfg, one discarding everything if none is set
+func (cfg *Config) stats() StatsSink {
+	if cfg.Stats == nil {
+		return nopStats{}
+	}
+	return cfg.Stats
+}
+
+// countIssuance records an order attempt of a certificate and its duration
+func countIssuance(cfg *Config, action, certName string, started time.Time, err error) {
+	tags := []string{"cert:" + certName, "action:" + action, "result:success"}
+	if err != nil {
+		tags[2] = "result:failure"
+	}
+	cfg.stats().Count("issuance.attempts", 1, tags...)
+	cfg.stats().Timing("issuance.duration", time.Since(started), tags...)
+}
+
+// statsTransport times the requests to the ACME server
+type statsTransport struct {
+	next  http.RoundTripper
+	stats StatsSink
+}
+
+// statsTransport returns next wrapped to time every request if cfg has a
+// stats sink, otherwise next itself. A nil next stands for
+// http.DefaultTransport.
+func (cfg *Config) statsTransport(next http.RoundTripper) http.RoundTripper {
+	if cfg.Stats == nil {
+		return next
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &statsTransport{next: next, stats: cfg.Stats}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *statsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	t.stats.Timing("acme.request", time.Since(start), "acme_server:"+req.URL.Host, "method:"+req.Method, "status:"+status)
+	return resp, err
+}
@@ -0,0 +1,104 @@
+package manager
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingStats keeps the samples sent to it as "name value tags"
+type recordingStats struct {
+	mu      sync.Mutex
+	samples []string
+}
+
+func (r *recordingStats) Count(name string, value int64, tags ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples = append(r.samples, name+" "+strings.Join(tags, ","))
+}
+
+func (r *recordingStats) Timing(name string, d time.Duration, tags ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples = append(r.samples, name+" "+strings.Join(tags, ","))
+}
+
+func TestCountIssuance(t *testing.T) {
+	stats := &recordingStats{}
+	cfg := &Config{Stats: stats}
+	countIssuance(cfg, "renew", "web", time.Now(), errors.New("order rejected"))
+	want := []string{
+		"issuance.attempts cert:web,action:renew,result:failure",
+		"issuance.duration cert:web,action:renew,result:failure",
+	}
+	if strings.Join(stats.samples, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected samples %v", stats.samples)
+	}
+
+	// Without a sink nothing happens
+	countIssuance(&Config{}, "init", "web", time.Now(), nil)
+}
+
+func TestStatsTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	if transport := (&Config{}).statsTransport(nil); transport != nil {
+		t.Errorf("Expected no wrapper without a sink, got %T", transport)
+	}
+
+	stats := &recordingStats{}
+	cfg := &Config{Stats: stats}
+	client := &http.Client{Transport: cfg.statsTransport(nil)}
+	resp, err := client.Post(server.URL+"/acme/new-order", "application/jose+json", nil)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	server.Close()
+	if _, err := client.Get(server.URL + "/directory"); err == nil {
+		t.Fatal("Expected the request to a closed server to fail")
+	}
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	want := []string{
+		"acme.request acme_server:" + host + ",method:POST,status:201",
+		"acme.request acme_server:" + host + ",method:GET,status:error",
+	}
+	if strings.Join(stats.samples, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unexpected samples %v", stats.samples)
+	}
+}
+
+func TestPreCheckAcmeDNS_CountsFailures(t *testing.T) {
+	store, err := NewAccountStore(filepath.Join(t.TempDir(), "acme-dns-accounts.json"))
+	if err != nil {
+		t.Fatalf("Failed to create account store: %v", err)
+	}
+	store.SetAccount("example.com", AcmeDnsAccount{FullDomain: "abc.auth.example.org"})
+	stats := &recordingStats{}
+	cfg := &Config{Stats: stats}
+
+	if _, err := PreCheckAcmeDNSWithResolver(cfg, store, []string{"example.com"},
+		staticResolver{"_acme-challenge.example.com": "abc.auth.example.org"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(stats.samples) != 0 {
+		t.Errorf("Expected no samples for a passing check, got %v", stats.samples)
+	}
+
+	if _, err := PreCheckAcmeDNSWithResolver(cfg, store, []string{"example.com"}, staticResolver{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(stats.samples) != 1 || stats.samples[0] != "dns_precheck.failures reason:missing_record" {
+		t.Errorf("Expected a missing record failure, got %v", stats.samples)
+	}
+}
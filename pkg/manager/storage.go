@@ -0,0 +1,375 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Storage backends selectable with 'storage.backend'
+const (
+	StorageBackendFile       = "file"
+	StorageBackendVault      = "vault"
+	StorageBackendS3         = "s3"
+	StorageBackendKubernetes = "kubernetes"
+	StorageBackendSQLite     = "sqlite"
+)
+
+// DefaultStoragePrefix prefixes the keys in remote storage backends
+const DefaultStoragePrefix = "go-acme-dns-manager"
+
+// syncedStoragePrefixes are the storage-relative locations kept in a remote
+// backend, directories end in a slash. Manifest, state, archive and
+// quarantine stay local.
+var syncedStoragePrefixes = []string{
+	"certificates/",
+	"accounts/",
+	AcmeDNSAccountsFile,
+	AcmeDNSAccountsDir + "/",
+	AcmeDNSPendingAccountsFile,
+}
+
+// storageBackends creates the remote backends by name
+var storageBackends = map[string]func(s *StorageConfig, timeout time.Duration) (CertificateStore, error){
+	StorageBackendVault: func(s *StorageConfig, timeout time.Duration) (CertificateStore, error) {
+		return newVaultStore(s, timeout)
+	},
+	StorageBackendS3: func(s *StorageConfig, timeout time.Duration) (CertificateStore, error) {
+		return newS3Store(s, timeout)
+	},
+	StorageBackendKubernetes: func(s *StorageConfig, timeout time.Duration) (CertificateStore, error) {
+		return newKubernetesStore(s, timeout)
+	},
+	StorageBackendSQLite: func(s *StorageConfig, timeout time.Duration) (CertificateStore, error) {
+		return newSQLiteStore(s, timeout)
+	},
+}
+
+// CertificateStore keeps certificates, keys and accounts. Keys are
+// storage-relative paths with forward slashes, like 'certificates/web.crt'.
+// Get returns an error matching fs.ErrNotExist for missing keys.
+type CertificateStore interface {
+	// Name identifies the backend in log messages
+	Name() string
+	Get(key string) ([]byte, error)
+	// Put stores data, private data is only readable by the owner where the
+	// backend supports it
+	Put(key string, data []byte, private bool) error
+	Delete(key string) error
+	// List returns all keys starting with prefix, sorted
+	List(prefix string) ([]string, error)
+}
+
+// notFound returns the error of CertificateStore.Get for missing keys
+func notFound(key string) error {
+	return &fs.PathError{Op: "get", Path: key, Err: fs.ErrNotExist}
+}
+
+// Store returns the certificate store of the configuration. Remote backends
+// are combined with the local storage directory, which remains the working
+// copy for checks and bookkeeping.
+func (cfg *Config) Store() (CertificateStore, error) {
+	policy, err := cfg.filePolicy()
+	if err != nil {
+		return nil, err
+	}
+	local := &fileStore{root: cfg.CertStoragePath, policy: policy}
+	if cfg.Storage == nil {
+		return local, nil
+	}
+
+	if !cfg.IsRemoteStorage() {
+		return local, nil
+	}
+	newBackend, ok := storageBackends[cfg.Storage.Backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
+	storage := cfg.Storage
+	if storage.Backend == StorageBackendSQLite && storage.Path == "" {
+		withPath := *storage
+		withPath.Path = filepath.Join(cfg.CertStoragePath, SQLiteStoreFile)
+		storage = &withPath
+	}
+	remote, err := newBackend(storage, cfg.storageHTTPTimeout())
+	if err != nil {
+		return nil, fmt.Errorf("storage backend %s: %w", cfg.Storage.Backend, err)
+	}
+	return &syncedStore{remote: remote, local: local}, nil
+}
+
+// IsRemoteStorage reports whether a backend other than the local directory is configured
+func (cfg *Config) IsRemoteStorage() bool {
+	return cfg.Storage != nil && cfg.Storage.Backend != "" && cfg.Storage.Backend != StorageBackendFile
+}
+
+// storageHTTPTimeout is the timeout of requests to remote storage backends
+func (cfg *Config) storageHTTPTimeout() time.Duration {
+	if cfg.HTTPTimeout > 0 {
+		return cfg.HTTPTimeout
+	}
+	return DefaultHTTPTimeout
+}
+
+// storageKeyPrefix returns the configured key prefix or the default
+func (s *StorageConfig) storageKeyPrefix() string {
+	if s.Prefix != "" {
+		return strings.Trim(s.Prefix, "/")
+	}
+	return DefaultStoragePrefix
+}
+
+// writeStorageFile writes a file below the storage path through the
+// configured store
+func writeStorageFile(cfg *Config, path string, data []byte, private bool) error {
+	store, err := cfg.Store()
+	if err != nil {
+		return err
+	}
+	key, err := manifestKey(cfg.CertStoragePath, path)
+	if err != nil {
+		return err
+	}
+	return store.Put(key, data, private)
+}
+
+// deleteStorageFile removes a file below the storage path through the
+// configured store, a missing file is not an error
+func deleteStorageFile(cfg *Config, path string) error {
+	store, err := cfg.Store()
+	if err != nil {
+		return err
+	}
+	key, err := manifestKey(cfg.CertStoragePath, path)
+	if err != nil {
+		return err
+	}
+	return store.Delete(key)
+}
+
+// publishStorageFiles uploads local files to a remote backend after they were
+// changed directly in the storage directory. It does nothing for the file backend.
+func publishStorageFiles(cfg *Config, paths ...string) error {
+	if !cfg.IsRemoteStorage() {
+		return nil
+	}
+	store, err := cfg.Store()
+	if err != nil {
+		return err
+	}
+	synced := store.(*syncedStore)
+	for _, path := range paths {
+		key, err := manifestKey(cfg.CertStoragePath, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			if err := synced.remote.Delete(key); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		if err := synced.remote.Put(key, data, !isPublicStorageFile(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileStore keeps files in the storage directory, like the manager always did
+type fileStore struct {
+	root   string
+	policy *filePolicy // file_permissions, nil for the built-in modes
+}
+
+// Name implements CertificateStore
+func (f *fileStore) Name() string { return StorageBackendFile }
+
+func (f *fileStore) path(key string) string {
+	return filepath.Join(f.root, filepath.FromSlash(key))
+}
+
+// Get implements CertificateStore
+func (f *fileStore) Get(key string) ([]byte, error) {
+	return os.ReadFile(f.path(key))
+}
+
+// Put implements CertificateStore with an atomic write
+func (f *fileStore) Put(key string, data []byte, private bool) error {
+	path := f.path(key)
+	if err := f.policy.mkdirAll(f.root, filepath.Dir(path)); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", path, err)
+	}
+	uid, gid := f.policy.owner()
+	return writeFileAtomicOwned(path, data, f.policy.fileMode(private), uid, gid)
+}
+
+// Delete implements CertificateStore, deleting a missing file is not an error
+func (f *fileStore) Delete(key string) error {
+	if err := os.Remove(f.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List implements CertificateStore
+func (f *fileStore) List(prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(f.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == f.root {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		key, err := manifestKey(f.root, path)
+		if err != nil {
+			return err
+		}
+		// Temporary files of interrupted atomic writes are not content
+		if strings.HasPrefix(filepath.Base(path), ".") {
+			return nil
+		}
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// syncedStore writes through to a remote backend and keeps the local
+// storage directory as working copy. The remote backend is authoritative.
+type syncedStore struct {
+	remote CertificateStore
+	local  *fileStore
+}
+
+// Name implements CertificateStore
+func (s *syncedStore) Name() string { return s.remote.Name() }
+
+// Get implements CertificateStore, reading from the remote backend
+func (s *syncedStore) Get(key string) ([]byte, error) {
+	return s.remote.Get(key)
+}
+
+// Put implements CertificateStore. The remote backend is written first, so
+// a failure leaves the working copy in step with it.
+func (s *syncedStore) Put(key string, data []byte, private bool) error {
+	if err := s.remote.Put(key, data, private); err != nil {
+		return fmt.Errorf("storing %s in %s: %w", key, s.remote.Name(), err)
+	}
+	return s.local.Put(key, data, private)
+}
+
+// Delete implements CertificateStore
+func (s *syncedStore) Delete(key string) error {
+	if err := s.remote.Delete(key); err != nil {
+		return fmt.Errorf("deleting %s from %s: %w", key, s.remote.Name(), err)
+	}
+	return s.local.Delete(key)
+}
+
+// List implements CertificateStore
+func (s *syncedStore) List(prefix string) ([]string, error) {
+	return s.remote.List(prefix)
+}
+
+// StorageSyncReport is the result of SyncStorage
+type StorageSyncReport struct {
+	Backend    string
+	Downloaded []string // Keys copied from the backend into the storage directory
+	Uploaded   []string // Keys only present locally, copied to the backend
+}
+
+// SyncStorage brings the storage directory in line with a remote backend
+// before a run. Files in the backend replace differing local ones, files only
+// present locally are uploaded, so an existing storage directory moves into a
+// new backend on the first run. It does nothing for the file backend.
+func SyncStorage(cfg *Config) (*StorageSyncReport, error) {
+	if !cfg.IsRemoteStorage() {
+		return nil, nil
+	}
+	store, err := cfg.Store()
+	if err != nil {
+		return nil, err
+	}
+	synced := store.(*syncedStore)
+	report := &StorageSyncReport{Backend: synced.remote.Name()}
+
+	for _, prefix := range syncedStoragePrefixes {
+		remoteKeys, err := synced.remote.List(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("listing %s in %s: %w", prefix, synced.remote.Name(), err)
+		}
+		localKeys, err := synced.local.List(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("listing local %s: %w", prefix, err)
+		}
+		remoteKeys, localKeys = syncedKeys(prefix, remoteKeys), syncedKeys(prefix, localKeys)
+
+		inRemote := make(map[string]bool, len(remoteKeys))
+		for _, key := range remoteKeys {
+			inRemote[key] = true
+			data, err := synced.remote.Get(key)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("reading %s from %s: %w", key, synced.remote.Name(), err)
+			}
+			if local, err := synced.local.Get(key); err == nil && string(local) == string(data) {
+				continue
+			}
+			if err := synced.local.Put(key, data, !isPublicStorageFile(key)); err != nil {
+				return nil, err
+			}
+			recordManifest(cfg.CertStoragePath, synced.local.path(key))
+			report.Downloaded = append(report.Downloaded, key)
+		}
+
+		for _, key := range localKeys {
+			if inRemote[key] {
+				continue
+			}
+			data, err := synced.local.Get(key)
+			if err != nil {
+				return nil, err
+			}
+			if err := synced.remote.Put(key, data, !isPublicStorageFile(key)); err != nil {
+				return nil, fmt.Errorf("storing %s in %s: %w", key, synced.remote.Name(), err)
+			}
+			report.Uploaded = append(report.Uploaded, key)
+		}
+	}
+	return report, nil
+}
+
+// syncedKeys drops keys that only share the name prefix of a synced file,
+// like the '.bak' copy of the accounts file
+func syncedKeys(prefix string, keys []string) []string {
+	if strings.HasSuffix(prefix, "/") {
+		return keys
+	}
+	var exact []string
+	for _, key := range keys {
+		if key == prefix {
+			exact = append(exact, key)
+		}
+	}
+	return exact
+}
@@ -0,0 +1,262 @@
+package manager
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Service account files mounted into every Kubernetes pod
+const (
+	kubernetesServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	kubernetesManagedByLabel    = "app.kubernetes.io/managed-by"
+	kubernetesManagedBy         = "go-acme-dns-manager"
+	kubernetesKeyAnnotation     = "go-acme-dns-manager/key"
+)
+
+// kubernetesStore keeps every file as an Opaque Secret with a 'content'
+// entry. Secret names are derived from the key, the key itself is kept in an
+// annotation, so listing does not depend on the name mangling.
+type kubernetesStore struct {
+	apiServer string
+	token     string
+	namespace string
+	prefix    string
+	client    *http.Client
+}
+
+func newKubernetesStore(s *StorageConfig, timeout time.Duration) (*kubernetesStore, error) {
+	apiServer := s.APIServer
+	if apiServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("api_server is required outside of a Kubernetes pod")
+		}
+		apiServer = "https://" + net.JoinHostPort(host, port)
+	}
+
+	tokenFile := s.TokenFile
+	if tokenFile == "" {
+		tokenFile = kubernetesServiceAccountDir + "/token"
+	}
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading token: %w", err)
+	}
+
+	namespace := s.Namespace
+	if namespace == "" {
+		data, err := os.ReadFile(kubernetesServiceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("namespace is required outside of a Kubernetes pod")
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	caFile := s.CAFile
+	if caFile == "" && s.APIServer == "" {
+		caFile = kubernetesServiceAccountDir + "/ca.crt"
+	}
+	if caFile != "" {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	return &kubernetesStore{
+		apiServer: strings.TrimSuffix(apiServer, "/"),
+		token:     strings.TrimSpace(string(token)),
+		namespace: namespace,
+		prefix:    kubernetesSecretName(s.storageKeyPrefix()),
+		client:    &http.Client{Timeout: timeout, Transport: transport},
+	}, nil
+}
+
+// Name implements CertificateStore
+func (k *kubernetesStore) Name() string { return StorageBackendKubernetes }
+
+// kubernetesSecretName turns a string into a valid Secret name: lower case
+// letters, digits, '-' and '.'
+func kubernetesSecretName(s string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(s) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '.' || c == '-' {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte('-')
+		}
+	}
+	return strings.Trim(b.String(), "-.")
+}
+
+// secretName returns the Secret name of a key. A hash of the key keeps keys
+// apart that map to the same readable part.
+func (k *kubernetesStore) secretName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := k.prefix + "-" + kubernetesSecretName(key)
+	// Names are limited to 253 characters
+	if len(name) > 240 {
+		name = strings.TrimRight(name[:240], "-.")
+	}
+	return name + "-" + hex.EncodeToString(sum[:4])
+}
+
+func (k *kubernetesStore) secretsURL() string {
+	return k.apiServer + "/api/v1/namespaces/" + url.PathEscape(k.namespace) + "/secrets"
+}
+
+// do sends a request and returns the status code and the response body
+func (k *kubernetesStore) do(method, url string, body []byte) (int, []byte, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+k.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("kubernetes request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024*1024))
+	if err != nil {
+		return 0, nil, fmt.Errorf("reading kubernetes response: %w", err)
+	}
+	return resp.StatusCode, data, nil
+}
+
+// kubernetesError turns an unsuccessful response into an error
+func kubernetesError(status int, data []byte) error {
+	var apiErr struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+		return fmt.Errorf("kubernetes API error (HTTP %d): %s", status, apiErr.Message)
+	}
+	return fmt.Errorf("kubernetes API error (HTTP %d): %s", status, strings.TrimSpace(string(data)))
+}
+
+type kubernetesSecret struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name        string            `json:"name"`
+		Labels      map[string]string `json:"labels,omitempty"`
+		Annotations map[string]string `json:"annotations,omitempty"`
+	} `json:"metadata"`
+	Type string            `json:"type,omitempty"`
+	Data map[string][]byte `json:"data"` // encoding/json base64 encodes []byte like the API expects
+}
+
+// Get implements CertificateStore
+func (k *kubernetesStore) Get(key string) ([]byte, error) {
+	status, data, err := k.do(http.MethodGet, k.secretsURL()+"/"+k.secretName(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, notFound(key)
+	}
+	if status != http.StatusOK {
+		return nil, kubernetesError(status, data)
+	}
+	var secret kubernetesSecret
+	if err := json.Unmarshal(data, &secret); err != nil {
+		return nil, fmt.Errorf("parsing secret for %s: %w", key, err)
+	}
+	return secret.Data["content"], nil
+}
+
+// Put implements CertificateStore, creating the Secret or replacing it.
+// Access to Secrets is controlled by RBAC, so private makes no difference.
+func (k *kubernetesStore) Put(key string, data []byte, private bool) error {
+	secret := kubernetesSecret{APIVersion: "v1", Kind: "Secret", Type: "Opaque"}
+	secret.Metadata.Name = k.secretName(key)
+	secret.Metadata.Labels = map[string]string{kubernetesManagedByLabel: kubernetesManagedBy}
+	secret.Metadata.Annotations = map[string]string{kubernetesKeyAnnotation: key}
+	secret.Data = map[string][]byte{"content": data}
+	body, err := json.Marshal(secret)
+	if err != nil {
+		return err
+	}
+
+	status, resp, err := k.do(http.MethodPost, k.secretsURL(), body)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusConflict {
+		status, resp, err = k.do(http.MethodPut, k.secretsURL()+"/"+secret.Metadata.Name, body)
+		if err != nil {
+			return err
+		}
+	}
+	if status != http.StatusOK && status != http.StatusCreated {
+		return kubernetesError(status, resp)
+	}
+	return nil
+}
+
+// Delete implements CertificateStore
+func (k *kubernetesStore) Delete(key string) error {
+	status, data, err := k.do(http.MethodDelete, k.secretsURL()+"/"+k.secretName(key), nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK && status != http.StatusAccepted && status != http.StatusNotFound {
+		return kubernetesError(status, data)
+	}
+	return nil
+}
+
+// List implements CertificateStore using the managed-by label
+func (k *kubernetesStore) List(prefix string) ([]string, error) {
+	query := url.Values{"labelSelector": {kubernetesManagedByLabel + "=" + kubernetesManagedBy}}
+	status, data, err := k.do(http.MethodGet, k.secretsURL()+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, kubernetesError(status, data)
+	}
+	var list struct {
+		Items []kubernetesSecret `json:"items"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parsing secret list: %w", err)
+	}
+	var keys []string
+	for _, item := range list.Items {
+		key := item.Metadata.Annotations[kubernetesKeyAnnotation]
+		// Other instances in the namespace use another prefix
+		if key == "" || item.Metadata.Name != k.secretName(key) {
+			continue
+		}
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,166 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeKubernetes serves the Secret endpoints of one namespace
+func fakeKubernetes(t *testing.T, namespace, token string) (*httptest.Server, map[string]kubernetesSecret) {
+	t.Helper()
+	secrets := make(map[string]kubernetesSecret)
+	base := "/api/v1/namespaces/" + namespace + "/secrets"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"kind":"Status","message":"Unauthorized"}`))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var secret kubernetesSecret
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &secret); err != nil {
+				t.Errorf("Invalid secret: %v", err)
+			}
+		}
+
+		switch {
+		case r.URL.Path == base && r.Method == http.MethodGet:
+			if r.URL.Query().Get("labelSelector") != kubernetesManagedByLabel+"="+kubernetesManagedBy {
+				t.Errorf("Unexpected label selector %q", r.URL.Query().Get("labelSelector"))
+			}
+			var list struct {
+				Items []kubernetesSecret `json:"items"`
+			}
+			for _, s := range secrets {
+				list.Items = append(list.Items, s)
+			}
+			_ = json.NewEncoder(w).Encode(list)
+		case r.URL.Path == base && r.Method == http.MethodPost:
+			if _, ok := secrets[secret.Metadata.Name]; ok {
+				w.WriteHeader(http.StatusConflict)
+				_, _ = w.Write([]byte(`{"kind":"Status","message":"already exists"}`))
+				return
+			}
+			secrets[secret.Metadata.Name] = secret
+			w.WriteHeader(http.StatusCreated)
+		case strings.HasPrefix(r.URL.Path, base+"/"):
+			name := strings.TrimPrefix(r.URL.Path, base+"/")
+			existing, ok := secrets[name]
+			switch r.Method {
+			case http.MethodGet:
+				if !ok {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				_ = json.NewEncoder(w).Encode(existing)
+			case http.MethodPut:
+				secrets[name] = secret
+			case http.MethodDelete:
+				if !ok {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				delete(secrets, name)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, secrets
+}
+
+func newTestKubernetesStore(t *testing.T, apiServer, token, prefix string) *kubernetesStore {
+	t.Helper()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte(token+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := newKubernetesStore(&StorageConfig{APIServer: apiServer, TokenFile: tokenFile, Namespace: "certs", Prefix: prefix}, time.Second)
+	if err != nil {
+		t.Fatalf("newKubernetesStore failed: %v", err)
+	}
+	return store
+}
+
+func TestKubernetesStore(t *testing.T) {
+	server, secrets := fakeKubernetes(t, "certs", "sa-token")
+	store := newTestKubernetesStore(t, server.URL, "sa-token", "")
+
+	if _, err := store.Get("certificates/web.crt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a not-exist error for a missing key, got %v", err)
+	}
+	for _, key := range []string{"certificates/web.crt", "certificates/web.key", AcmeDNSAccountsFile} {
+		if err := store.Put(key, []byte("first "+key), true); err != nil {
+			t.Fatalf("Put %s failed: %v", key, err)
+		}
+	}
+	// Replacing an existing Secret
+	if err := store.Put("certificates/web.crt", []byte("second"), false); err != nil {
+		t.Fatalf("Put (replace) failed: %v", err)
+	}
+	data, err := store.Get("certificates/web.crt")
+	if err != nil || string(data) != "second" {
+		t.Errorf("Get = %q, %v; want %q", data, err, "second")
+	}
+	for name := range secrets {
+		if !strings.HasPrefix(name, "go-acme-dns-manager-") || len(name) > 253 {
+			t.Errorf("Unexpected secret name %s", name)
+		}
+	}
+
+	// Secrets of an instance with another prefix are not listed
+	other := newTestKubernetesStore(t, server.URL, "sa-token", "staging")
+	if err := other.Put("certificates/other.crt", []byte("other"), false); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	keys, err := store.List("certificates/")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if want := []string{"certificates/web.crt", "certificates/web.key"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("List = %v, want %v", keys, want)
+	}
+
+	if err := store.Delete("certificates/web.crt"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := store.Delete("certificates/web.crt"); err != nil {
+		t.Errorf("Deleting a missing key should succeed, got %v", err)
+	}
+}
+
+func TestKubernetesStore_Unauthorized(t *testing.T) {
+	server, _ := fakeKubernetes(t, "certs", "sa-token")
+	store := newTestKubernetesStore(t, server.URL, "wrong", "")
+	if _, err := store.List(""); err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Errorf("Expected the API error message, got %v", err)
+	}
+}
+
+func TestKubernetesSecretName(t *testing.T) {
+	store := &kubernetesStore{prefix: "acme"}
+	a := store.secretName("certificates/web_1.crt")
+	b := store.secretName("certificates/web-1.crt")
+	if a == b {
+		t.Errorf("Expected different names for different keys, both are %s", a)
+	}
+	if !strings.HasPrefix(a, "acme-certificates-web-1.crt-") {
+		t.Errorf("Unexpected secret name %s", a)
+	}
+	long := store.secretName(strings.Repeat("x", 300))
+	if len(long) > 253 {
+		t.Errorf("Secret name too long: %d characters", len(long))
+	}
+}
@@ -0,0 +1,217 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Store keeps every file as an object below '<prefix>/' in an S3 compatible
+// bucket. Requests use path-style URLs, which AWS and S3 compatible servers
+// like MinIO accept alike, and are signed with signV4.
+type s3Store struct {
+	endpoint     string
+	bucket       string
+	prefix       string
+	region       string
+	accessKeyID  string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+	now          func() time.Time
+}
+
+func newS3Store(s *StorageConfig, timeout time.Duration) (*s3Store, error) {
+	if s.Bucket == "" {
+		return nil, fmt.Errorf("bucket is required")
+	}
+
+	// Fall back to the standard AWS environment variables for credentials
+	accessKey, secretKey, sessionToken := s.AccessKeyID, s.SecretAccessKey, ""
+	if accessKey == "" && secretKey == "" {
+		accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("access_key_id and secret_access_key are required (or AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY)")
+	}
+
+	region := s.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+
+	return &s3Store{
+		endpoint:     strings.TrimSuffix(endpoint, "/"),
+		bucket:       s.Bucket,
+		prefix:       s.storageKeyPrefix(),
+		region:       region,
+		accessKeyID:  accessKey,
+		secretKey:    secretKey,
+		sessionToken: sessionToken,
+		client:       &http.Client{Timeout: timeout},
+		now:          time.Now,
+	}, nil
+}
+
+// Name implements CertificateStore
+func (s *s3Store) Name() string { return StorageBackendS3 }
+
+// s3EscapePath escapes an object path the way Signature Version 4 expects:
+// everything but unreserved characters and the slashes between segments
+func s3EscapePath(path string) string {
+	var b strings.Builder
+	for _, c := range []byte(path) {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// request sends a signed request for an object path (empty for the bucket)
+func (s *s3Store) request(method, object string, query url.Values, body []byte) (*http.Response, error) {
+	path := "/" + s.bucket
+	if object != "" {
+		path += "/" + object
+	}
+	u, err := url.Parse(s.endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parsing endpoint %s: %w", s.endpoint, err)
+	}
+	u.Path = path
+	u.RawPath = s3EscapePath(path)
+	// The signature needs '%20' for spaces and sorted parameters
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", sha256Hex(body))
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+	signV4(req, body, s.accessKeyID, s.secretKey, s.region, "s3", s.now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 request: %w", err)
+	}
+	return resp, nil
+}
+
+// s3Error turns an unsuccessful response into an error
+func s3Error(resp *http.Response) error {
+	var apiErr struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if xml.Unmarshal(data, &apiErr) == nil && apiErr.Code != "" {
+		return fmt.Errorf("s3 API error (HTTP %d): %s: %s", resp.StatusCode, apiErr.Code, apiErr.Message)
+	}
+	return fmt.Errorf("s3 API error (HTTP %d): %s", resp.StatusCode, strings.TrimSpace(string(data)))
+}
+
+// Get implements CertificateStore
+func (s *s3Store) Get(key string) ([]byte, error) {
+	resp, err := s.request(http.MethodGet, s.prefix+"/"+key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, notFound(key)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, s3Error(resp)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// Put implements CertificateStore. Objects are only readable with the
+// credentials of the bucket, so private makes no difference.
+func (s *s3Store) Put(key string, data []byte, private bool) error {
+	resp, err := s.request(http.MethodPut, s.prefix+"/"+key, nil, data)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// Delete implements CertificateStore
+func (s *s3Store) Delete(key string) error {
+	resp, err := s.request(http.MethodDelete, s.prefix+"/"+key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// List implements CertificateStore with ListObjectsV2
+func (s *s3Store) List(prefix string) ([]string, error) {
+	objectPrefix := s.prefix + "/"
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {objectPrefix + prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.request(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := s3Error(resp)
+			_ = resp.Body.Close()
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parsing s3 listing: %w", err)
+		}
+		for _, obj := range result.Contents {
+			keys = append(keys, strings.TrimPrefix(obj.Key, objectPrefix))
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
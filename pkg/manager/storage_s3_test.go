@@ -0,0 +1,153 @@
+package manager
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeS3 serves path-style object requests and ListObjectsV2 for one bucket,
+// returning a single object per listing page
+func fakeS3(t *testing.T, bucket string) (*httptest.Server, map[string][]byte) {
+	t.Helper()
+	objects := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/eu-west-1/s3/aws4_request") {
+			t.Errorf("Unexpected Authorization header %q", auth)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get("X-Amz-Content-Sha256"); got != sha256Hex(body) {
+			t.Errorf("X-Amz-Content-Sha256 %s does not match the body", got)
+		}
+
+		if r.URL.Path == "/"+bucket {
+			prefix := r.URL.Query().Get("prefix")
+			var keys []string
+			for key := range objects {
+				if strings.HasPrefix(key, prefix) && key > r.URL.Query().Get("continuation-token") {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			type content struct {
+				Key string `xml:"Key"`
+			}
+			result := struct {
+				XMLName               xml.Name  `xml:"ListBucketResult"`
+				Contents              []content `xml:"Contents"`
+				IsTruncated           bool      `xml:"IsTruncated"`
+				NextContinuationToken string    `xml:"NextContinuationToken,omitempty"`
+			}{}
+			if len(keys) > 0 {
+				result.Contents = []content{{Key: keys[0]}}
+				result.IsTruncated = len(keys) > 1
+				if result.IsTruncated {
+					result.NextContinuationToken = keys[0]
+				}
+			}
+			_ = xml.NewEncoder(w).Encode(result)
+			return
+		}
+
+		key := strings.TrimPrefix(r.URL.Path, "/"+bucket+"/")
+		switch r.Method {
+		case http.MethodGet:
+			data, ok := objects[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`))
+				return
+			}
+			_, _ = w.Write(data)
+		case http.MethodPut:
+			objects[key] = body
+		case http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, objects
+}
+
+func TestS3Store(t *testing.T) {
+	server, objects := fakeS3(t, "certs")
+	store, err := newS3Store(&StorageConfig{
+		Endpoint:        server.URL,
+		Bucket:          "certs",
+		Region:          "eu-west-1",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "SECRET",
+	}, time.Second)
+	if err != nil {
+		t.Fatalf("newS3Store failed: %v", err)
+	}
+
+	if _, err := store.Get("certificates/web.crt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a not-exist error for a missing key, got %v", err)
+	}
+	for _, key := range []string{"certificates/web.crt", "certificates/web.key", "accounts/acme/me@example.com/keys/me@example.com.key"} {
+		if err := store.Put(key, []byte("data of "+key), true); err != nil {
+			t.Fatalf("Put %s failed: %v", key, err)
+		}
+	}
+	if _, ok := objects[DefaultStoragePrefix+"/certificates/web.crt"]; !ok {
+		t.Errorf("Expected the object below the default prefix, got %v", objects)
+	}
+
+	data, err := store.Get("accounts/acme/me@example.com/keys/me@example.com.key")
+	if err != nil || string(data) != "data of accounts/acme/me@example.com/keys/me@example.com.key" {
+		t.Errorf("Get = %q, %v", data, err)
+	}
+
+	keys, err := store.List("certificates/")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if want := []string{"certificates/web.crt", "certificates/web.key"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("List = %v, want %v", keys, want)
+	}
+
+	if err := store.Delete("certificates/web.crt"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, ok := objects[DefaultStoragePrefix+"/certificates/web.crt"]; ok {
+		t.Error("Expected the object to be deleted")
+	}
+}
+
+func TestS3EscapePath(t *testing.T) {
+	got := s3EscapePath("/certs/accounts/me+1@example.com/a b.key")
+	want := "/certs/accounts/me%2B1%40example.com/a%20b.key"
+	if got != want {
+		t.Errorf("s3EscapePath = %s, want %s", got, want)
+	}
+}
+
+func TestNewS3Store_Validation(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	if _, err := newS3Store(&StorageConfig{}, time.Second); err == nil || !strings.Contains(err.Error(), "bucket") {
+		t.Errorf("Expected an error about the missing bucket, got %v", err)
+	}
+	if _, err := newS3Store(&StorageConfig{Bucket: "certs"}, time.Second); err == nil || !strings.Contains(err.Error(), "access_key_id") {
+		t.Errorf("Expected an error about missing credentials, got %v", err)
+	}
+
+	store, err := newS3Store(&StorageConfig{Bucket: "certs", AccessKeyID: "a", SecretAccessKey: "b"}, time.Second)
+	if err != nil {
+		t.Fatalf("newS3Store failed: %v", err)
+	}
+	if store.endpoint != "https://s3.us-east-1.amazonaws.com" {
+		t.Errorf("Expected the default AWS endpoint, got %s", store.endpoint)
+	}
+}
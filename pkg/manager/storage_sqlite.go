@@ -0,0 +1,313 @@
+package manager
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+	_ "modernc.org/sqlite" // Pure Go driver, registers "sqlite"
+)
+
+// SQLiteStoreFile is the database of the sqlite backend below the storage
+// path unless storage.path says otherwise
+const SQLiteStoreFile = "store.db"
+
+// sqliteSchema creates the tables of the sqlite backend. files holds the
+// content of every key, the other tables index it for queries: certificates
+// from the .crt and .json files, acmedns_accounts from unencrypted accounts
+// files (without passwords) and history from the audit records.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS files (
+	key        TEXT PRIMARY KEY,
+	data       BLOB NOT NULL,
+	private    INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS certificates (
+	name        TEXT PRIMARY KEY,
+	common_name TEXT NOT NULL DEFAULT '',
+	domains     TEXT NOT NULL DEFAULT '',
+	issuer      TEXT NOT NULL DEFAULT '',
+	acme_server TEXT NOT NULL DEFAULT '',
+	not_before  INTEGER NOT NULL DEFAULT 0,
+	not_after   INTEGER NOT NULL DEFAULT 0,
+	updated_at  INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS certificates_not_after ON certificates (not_after);
+CREATE TABLE IF NOT EXISTS acmedns_accounts (
+	domain      TEXT PRIMARY KEY,
+	file        TEXT NOT NULL,
+	full_domain TEXT NOT NULL,
+	sub_domain  TEXT NOT NULL,
+	username    TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS history (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	time        INTEGER NOT NULL,
+	action      TEXT NOT NULL,
+	cert_name   TEXT NOT NULL DEFAULT '',
+	domains     TEXT NOT NULL DEFAULT '',
+	result      TEXT NOT NULL,
+	error       TEXT NOT NULL DEFAULT '',
+	user        TEXT NOT NULL DEFAULT '',
+	host        TEXT NOT NULL DEFAULT '',
+	acme_server TEXT NOT NULL DEFAULT '',
+	order_url   TEXT NOT NULL DEFAULT '',
+	account     TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS history_cert_name ON history (cert_name, time);
+`
+
+// sqliteDBs holds one database handle per file, so the stores of a run share
+// the connection pool instead of opening the file for every write
+var sqliteDBs sync.Map
+
+// sqliteStore keeps every file in one SQLite database, each write in a
+// transaction with the tables indexing it
+type sqliteStore struct {
+	db   *sql.DB
+	path string
+}
+
+func newSQLiteStore(s *StorageConfig, timeout time.Duration) (*sqliteStore, error) {
+	if s.Path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+	if db, ok := sqliteDBs.Load(s.Path); ok {
+		return &sqliteStore{db: db.(*sql.DB), path: s.Path}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), DirPermissions); err != nil {
+		return nil, fmt.Errorf("creating directory for %s: %w", s.Path, err)
+	}
+	// The database holds private keys, create it readable by the owner only
+	f, err := os.OpenFile(s.Path, os.O_RDWR|os.O_CREATE, PrivateKeyPermissions)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", s.Path, err)
+	}
+	_ = f.Close()
+
+	// Concurrent runs wait for each other's writes up to the HTTP timeout
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)&_txlock=immediate", s.Path, timeout.Milliseconds())
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", s.Path, err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("creating tables in %s: %w", s.Path, err)
+	}
+	actual, loaded := sqliteDBs.LoadOrStore(s.Path, db)
+	if loaded {
+		_ = db.Close()
+	}
+	return &sqliteStore{db: actual.(*sql.DB), path: s.Path}, nil
+}
+
+// Name implements CertificateStore
+func (q *sqliteStore) Name() string { return StorageBackendSQLite }
+
+// Get implements CertificateStore
+func (q *sqliteStore) Get(key string) ([]byte, error) {
+	var data []byte
+	err := q.db.QueryRow(`SELECT data FROM files WHERE key = ?`, key).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound(key)
+	} else if err != nil {
+		return nil, fmt.Errorf("sqlite %s: reading %s: %w", q.path, key, err)
+	}
+	return data, nil
+}
+
+// Put implements CertificateStore
+func (q *sqliteStore) Put(key string, data []byte, private bool) error {
+	return q.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO files (key, data, private, updated_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT (key) DO UPDATE SET data = excluded.data, private = excluded.private, updated_at = excluded.updated_at`,
+			key, data, private, time.Now().Unix())
+		if err != nil {
+			return err
+		}
+		return indexSQLiteFile(tx, key, data)
+	})
+}
+
+// Delete implements CertificateStore, deleting a missing key is not an error
+func (q *sqliteStore) Delete(key string) error {
+	return q.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM files WHERE key = ?`, key); err != nil {
+			return err
+		}
+		return indexSQLiteFile(tx, key, nil)
+	})
+}
+
+// List implements CertificateStore
+func (q *sqliteStore) List(prefix string) ([]string, error) {
+	// Compared with substr, LIKE would treat _ and % in the prefix as wildcards
+	rows, err := q.db.Query(`SELECT key FROM files WHERE substr(key, 1, ?) = ? ORDER BY key`, len(prefix), prefix)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite %s: listing %s: %w", q.path, prefix, err)
+	}
+	defer func() { _ = rows.Close() }()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// AppendHistory adds an audit record to the history table
+func (q *sqliteStore) AppendHistory(r AuditRecord) error {
+	_, err := q.db.Exec(`INSERT INTO history (time, action, cert_name, domains, result, error, user, host, acme_server, order_url, account)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.Time.Unix(), r.Action, r.CertName, strings.Join(r.Domains, ","), r.Result, r.Error, r.User, r.Host, r.AcmeServer, r.OrderURL, r.Account)
+	if err != nil {
+		return fmt.Errorf("sqlite %s: recording history: %w", q.path, err)
+	}
+	return nil
+}
+
+// IndexedCertificate is a stored certificate as the sqlite backend indexes it
+type IndexedCertificate struct {
+	Name       string
+	Domains    []string
+	Issuer     string
+	AcmeServer string
+	NotBefore  time.Time
+	NotAfter   time.Time
+}
+
+// IndexedCertificates returns the certificates from the index of the sqlite
+// backend, sorted by name, without reading and parsing every certificate
+// file. ok is false if another backend is configured.
+func IndexedCertificates(cfg *Config) (certs []IndexedCertificate, ok bool, err error) {
+	q, err := cfg.sqliteIndex()
+	if err != nil || q == nil {
+		return nil, false, err
+	}
+	// Rows of metadata files without certificate have no expiry
+	rows, err := q.db.Query(`SELECT name, domains, issuer, acme_server, not_before, not_after FROM certificates WHERE not_after > 0 ORDER BY name`)
+	if err != nil {
+		return nil, false, fmt.Errorf("sqlite %s: listing certificates: %w", q.path, err)
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var cert IndexedCertificate
+		var domains string
+		var notBefore, notAfter int64
+		if err := rows.Scan(&cert.Name, &domains, &cert.Issuer, &cert.AcmeServer, &notBefore, &notAfter); err != nil {
+			return nil, false, fmt.Errorf("sqlite %s: listing certificates: %w", q.path, err)
+		}
+		if domains != "" {
+			cert.Domains = strings.Split(domains, ",")
+		}
+		cert.NotBefore, cert.NotAfter = time.Unix(notBefore, 0).UTC(), time.Unix(notAfter, 0).UTC()
+		certs = append(certs, cert)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("sqlite %s: listing certificates: %w", q.path, err)
+	}
+	return certs, true, nil
+}
+
+// sqliteIndex returns the sqlite backend of cfg, nil without error if another
+// backend is configured. Other backends are not opened, for Vault, S3 and
+// Kubernetes that would mean a new client.
+func (cfg *Config) sqliteIndex() (*sqliteStore, error) {
+	if cfg.Storage == nil || cfg.Storage.Backend != StorageBackendSQLite {
+		return nil, nil
+	}
+	store, err := cfg.Store()
+	if err != nil {
+		return nil, err
+	}
+	synced, ok := store.(*syncedStore)
+	if !ok {
+		return nil, nil
+	}
+	q, _ := synced.remote.(*sqliteStore)
+	return q, nil
+}
+
+func (q *sqliteStore) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := q.db.Begin()
+	if err != nil {
+		return fmt.Errorf("sqlite %s: %w", q.path, err)
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("sqlite %s: %w", q.path, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("sqlite %s: %w", q.path, err)
+	}
+	return nil
+}
+
+// indexSQLiteFile updates the tables indexing the file of key, data is nil
+// for deleted files. Content that does not parse is only kept in files.
+func indexSQLiteFile(tx *sql.Tx, key string, data []byte) error {
+	now := time.Now().Unix()
+	switch {
+	case strings.HasPrefix(key, "certificates/") && strings.HasSuffix(key, ".issuer.crt"):
+		return nil
+
+	case strings.HasPrefix(key, "certificates/") && strings.HasSuffix(key, ".crt"):
+		name := strings.TrimSuffix(strings.TrimPrefix(key, "certificates/"), ".crt")
+		if data == nil {
+			_, err := tx.Exec(`DELETE FROM certificates WHERE name = ?`, name)
+			return err
+		}
+		info, err := certinfo.Parse(data)
+		if err != nil {
+			return nil
+		}
+		_, err = tx.Exec(`INSERT INTO certificates (name, common_name, domains, issuer, not_before, not_after, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (name) DO UPDATE SET common_name = excluded.common_name, domains = excluded.domains, issuer = excluded.issuer,
+				not_before = excluded.not_before, not_after = excluded.not_after, updated_at = excluded.updated_at`,
+			name, info.CommonName, strings.Join(info.DNSNames, ","), info.Issuer, info.NotBefore.Unix(), info.NotAfter.Unix(), now)
+		return err
+
+	case strings.HasPrefix(key, "certificates/") && strings.HasSuffix(key, ".json"):
+		name := strings.TrimSuffix(strings.TrimPrefix(key, "certificates/"), ".json")
+		var meta CertMetadata
+		if data == nil || json.Unmarshal(data, &meta) != nil {
+			return nil
+		}
+		_, err := tx.Exec(`INSERT INTO certificates (name, acme_server, updated_at) VALUES (?, ?, ?)
+			ON CONFLICT (name) DO UPDATE SET acme_server = excluded.acme_server, updated_at = excluded.updated_at`,
+			name, meta.AcmeServer, now)
+		return err
+
+	case key == AcmeDNSAccountsFile || strings.HasPrefix(key, AcmeDNSAccountsDir+"/") && strings.HasSuffix(key, ".json"):
+		if _, err := tx.Exec(`DELETE FROM acmedns_accounts WHERE file = ?`, key); err != nil {
+			return err
+		}
+		// Encrypted accounts stay opaque, indexing them would defeat the encryption
+		var accounts map[string]AcmeDnsAccount
+		if data == nil || isEncryptedAccounts(data) || json.Unmarshal(data, &accounts) != nil {
+			return nil
+		}
+		for domain, account := range accounts {
+			_, err := tx.Exec(`INSERT INTO acmedns_accounts (domain, file, full_domain, sub_domain, username) VALUES (?, ?, ?, ?, ?)
+				ON CONFLICT (domain) DO UPDATE SET file = excluded.file, full_domain = excluded.full_domain,
+					sub_domain = excluded.sub_domain, username = excluded.username`,
+				domain, key, account.FullDomain, account.SubDomain, account.Username)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
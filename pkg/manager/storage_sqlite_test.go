@@ -0,0 +1,150 @@
+package manager
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestSQLiteStore(t *testing.T) *sqliteStore {
+	t.Helper()
+	store, err := newSQLiteStore(&StorageConfig{Backend: StorageBackendSQLite, Path: filepath.Join(t.TempDir(), SQLiteStoreFile)}, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to open sqlite store: %v", err)
+	}
+	return store
+}
+
+func TestSQLiteStore(t *testing.T) {
+	store := newTestSQLiteStore(t)
+
+	if _, err := store.Get("certificates/web.crt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected not found for a missing key, got %v", err)
+	}
+	for _, key := range []string{"certificates/web.crt", "certificates/web_2.crt", "certificatesXweb.crt", "accounts/a/account.json"} {
+		if err := store.Put(key, []byte(key), true); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+	if err := store.Put("certificates/web.crt", []byte("new"), false); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := store.Get("certificates/web.crt"); err != nil || string(data) != "new" {
+		t.Errorf("Expected the replaced content, got %q, %v", data, err)
+	}
+
+	keys, err := store.List("certificates/web_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"certificates/web_2.crt"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Expected the underscore in the prefix to match literally, got %v", keys)
+	}
+
+	if err := store.Delete("certificates/web.crt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("certificates/web.crt"); err != nil {
+		t.Errorf("Deleting a missing key should not fail: %v", err)
+	}
+	keys, _ = store.List("certificates/")
+	if want := []string{"certificates/web_2.crt"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Expected %v after delete, got %v", want, keys)
+	}
+}
+
+func TestSQLiteStore_IndexesCertificatesAndAccounts(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	certPEM, _, _ := newTestChain(t, []string{"example.com", "www.example.com"})
+	if err := store.Put("certificates/web.crt", certPEM, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("certificates/web.json", []byte(`{"acme_server": "https://acme.example.org/directory"}`), false); err != nil {
+		t.Fatal(err)
+	}
+
+	var domains, acmeServer string
+	var notAfter int64
+	err := store.db.QueryRow(`SELECT domains, acme_server, not_after FROM certificates WHERE name = 'web'`).Scan(&domains, &acmeServer, &notAfter)
+	if err != nil {
+		t.Fatalf("Expected a certificates row: %v", err)
+	}
+	if domains != "example.com,www.example.com" || acmeServer != "https://acme.example.org/directory" || notAfter <= time.Now().Unix() {
+		t.Errorf("Unexpected certificates row: %q %q %d", domains, acmeServer, notAfter)
+	}
+
+	accounts := []byte(`{"example.com": {"username": "user", "password": "secret", "fulldomain": "abc.auth.example.org", "subdomain": "abc"}}`)
+	if err := store.Put(AcmeDNSAccountsFile, accounts, true); err != nil {
+		t.Fatal(err)
+	}
+	var fullDomain string
+	if err := store.db.QueryRow(`SELECT full_domain FROM acmedns_accounts WHERE domain = 'example.com'`).Scan(&fullDomain); err != nil || fullDomain != "abc.auth.example.org" {
+		t.Errorf("Expected an acmedns_accounts row, got %q, %v", fullDomain, err)
+	}
+
+	// Removing the files removes their rows
+	if err := store.Delete("certificates/web.crt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(AcmeDNSAccountsFile, []byte("age-encryption.org/v1\n..."), true); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	_ = store.db.QueryRow(`SELECT (SELECT COUNT(*) FROM certificates) + (SELECT COUNT(*) FROM acmedns_accounts)`).Scan(&count)
+	if count != 0 {
+		t.Errorf("Expected no rows for deleted and encrypted files, got %d", count)
+	}
+}
+
+func TestRecordAudit_SQLiteHistory(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{CertStoragePath: dir, Storage: &StorageConfig{Backend: StorageBackendSQLite}}
+	recordAudit(cfg, AuditRecord{Action: AuditIssue, CertName: "web", Domains: []string{"example.com"}}, nil)
+
+	store, err := cfg.Store()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := store.(*syncedStore).remote.(*sqliteStore).db
+	var action, result, domains string
+	if err := db.QueryRow(`SELECT action, result, domains FROM history WHERE cert_name = 'web'`).Scan(&action, &result, &domains); err != nil {
+		t.Fatalf("Expected a history row: %v", err)
+	}
+	if action != AuditIssue || result != AuditSuccess || domains != "example.com" {
+		t.Errorf("Unexpected history row: %s %s %s", action, result, domains)
+	}
+	if log, err := ReadAuditLog(dir); err != nil || len(log.Records) != 1 {
+		t.Errorf("Expected the record in the audit log as well, got %v", err)
+	}
+}
+
+func TestIndexedCertificates(t *testing.T) {
+	if _, ok, err := IndexedCertificates(&Config{CertStoragePath: t.TempDir()}); ok || err != nil {
+		t.Errorf("Expected no index without the sqlite backend, got %v, %v", ok, err)
+	}
+
+	cfg := &Config{CertStoragePath: t.TempDir(), Storage: &StorageConfig{Backend: StorageBackendSQLite}}
+	store, err := cfg.Store()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, _, _ := newTestChain(t, []string{"example.com", "www.example.com"})
+	if err := store.Put("certificates/web.crt", certPEM, false); err != nil {
+		t.Fatal(err)
+	}
+	// Metadata without certificate is not listed
+	if err := store.Put("certificates/mail.json", []byte(`{"acme_server": "https://acme.example.org/directory"}`), false); err != nil {
+		t.Fatal(err)
+	}
+
+	certs, ok, err := IndexedCertificates(cfg)
+	if err != nil || !ok {
+		t.Fatalf("Expected the sqlite index, got %v, %v", ok, err)
+	}
+	if len(certs) != 1 || certs[0].Name != "web" || !reflect.DeepEqual(certs[0].Domains, []string{"example.com", "www.example.com"}) || !certs[0].NotAfter.After(time.Now()) {
+		t.Errorf("Unexpected indexed certificates %+v", certs)
+	}
+}
@@ -0,0 +1,313 @@
+package manager
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+// memStore is an in-memory CertificateStore standing in for a remote backend
+type memStore struct {
+	files map[string][]byte
+}
+
+func newMemStore() *memStore { return &memStore{files: make(map[string][]byte)} }
+
+func (m *memStore) Name() string { return "memory" }
+
+func (m *memStore) Get(key string) ([]byte, error) {
+	data, ok := m.files[key]
+	if !ok {
+		return nil, notFound(key)
+	}
+	return data, nil
+}
+
+func (m *memStore) Put(key string, data []byte, private bool) error {
+	m.files[key] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *memStore) Delete(key string) error {
+	delete(m.files, key)
+	return nil
+}
+
+func (m *memStore) List(prefix string) ([]string, error) {
+	var keys []string
+	for key := range m.files {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func TestFileStore(t *testing.T) {
+	store := &fileStore{root: t.TempDir()}
+
+	if _, err := store.Get("certificates/web.crt"); !os.IsNotExist(err) {
+		t.Errorf("Expected a not-exist error for a missing key, got %v", err)
+	}
+	if err := store.Put("certificates/web.crt", []byte("cert"), false); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := store.Put("certificates/web.key", []byte("key"), true); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := store.Put(AcmeDNSAccountsFile, []byte("{}"), true); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	data, err := store.Get("certificates/web.crt")
+	if err != nil || string(data) != "cert" {
+		t.Errorf("Get = %q, %v; want %q", data, err, "cert")
+	}
+	info, err := os.Stat(filepath.Join(store.root, "certificates", "web.key"))
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Mode().Perm() != PrivateKeyPermissions {
+		t.Errorf("Expected private file mode %04o, got %04o", PrivateKeyPermissions, info.Mode().Perm())
+	}
+
+	keys, err := store.List("certificates/")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if want := []string{"certificates/web.crt", "certificates/web.key"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("List = %v, want %v", keys, want)
+	}
+
+	if err := store.Delete("certificates/web.crt"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := store.Delete("certificates/web.crt"); err != nil {
+		t.Errorf("Deleting a missing key should succeed, got %v", err)
+	}
+}
+
+func TestConfigStore(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir()}
+	store, err := cfg.Store()
+	if err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if store.Name() != StorageBackendFile {
+		t.Errorf("Expected the file backend without storage section, got %s", store.Name())
+	}
+
+	cfg.Storage = &StorageConfig{Backend: "ftp"}
+	if _, err := cfg.Store(); err == nil {
+		t.Error("Expected an error for an unknown backend")
+	}
+
+	cfg.Storage = &StorageConfig{Backend: StorageBackendVault}
+	t.Setenv("VAULT_ADDR", "")
+	t.Setenv("VAULT_TOKEN", "")
+	if _, err := cfg.Store(); err == nil || !strings.Contains(err.Error(), "vault_address") {
+		t.Errorf("Expected an error about the missing vault_address, got %v", err)
+	}
+}
+
+func TestSyncedStore_SaveAndLoadCertificate(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir(), KeepGenerations: 0}
+	remote := newMemStore()
+	withStore(t, cfg, remote)
+
+	certPath := filepath.Join(t.TempDir(), "web.crt")
+	keyPath := filepath.Join(t.TempDir(), "web.key")
+	if err := createTestCertificateWithDomains(certPath, keyPath, []string{"web.example.com"}); err != nil {
+		t.Fatalf("Failed to create test certificate: %v", err)
+	}
+	certPEM, _ := os.ReadFile(certPath)
+	keyPEM, _ := os.ReadFile(keyPath)
+
+	resource := &certificate.Resource{Domain: "web.example.com", Certificate: certPEM, PrivateKey: keyPEM}
+	if err := saveCertificates(cfg, "web", resource, ""); err != nil {
+		t.Fatalf("saveCertificates failed: %v", err)
+	}
+
+	// Written to the backend and the working copy
+	for _, key := range []string{"certificates/web.crt", "certificates/web.key", "certificates/web.json"} {
+		if _, ok := remote.files[key]; !ok {
+			t.Errorf("Expected %s in the backend", key)
+		}
+		if _, err := os.Stat(filepath.Join(cfg.CertStoragePath, filepath.FromSlash(key))); err != nil {
+			t.Errorf("Expected %s in the storage directory: %v", key, err)
+		}
+	}
+
+	// The backend has the last word
+	remote.files["certificates/web.key"] = []byte("remote key")
+	loaded, err := LoadCertificateResource(cfg, "web")
+	if err != nil {
+		t.Fatalf("LoadCertificateResource failed: %v", err)
+	}
+	if string(loaded.PrivateKey) != "remote key" {
+		t.Errorf("Expected the key from the backend, got %q", loaded.PrivateKey)
+	}
+
+	if _, err := LoadCertificateResource(cfg, "missing"); !os.IsNotExist(err) {
+		t.Errorf("Expected a not-exist error for a missing certificate, got %v", err)
+	}
+}
+
+func TestSyncStorage(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir()}
+	remote := newMemStore()
+	withStore(t, cfg, remote)
+	local := &fileStore{root: cfg.CertStoragePath}
+
+	// Only in the backend, only local, and different in both
+	remote.files["certificates/api.crt"] = []byte("api")
+	if err := local.Put("certificates/web.crt", []byte("web"), false); err != nil {
+		t.Fatal(err)
+	}
+	remote.files[AcmeDNSAccountsFile] = []byte("remote accounts")
+	if err := local.Put(AcmeDNSAccountsFile, []byte("local accounts"), true); err != nil {
+		t.Fatal(err)
+	}
+	// Neither the accounts backup nor the state are kept in the backend
+	if err := local.Put(AcmeDNSAccountsFile+BackupSuffix, []byte("backup"), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Put(StateFile, []byte("{}"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := SyncStorage(cfg)
+	if err != nil {
+		t.Fatalf("SyncStorage failed: %v", err)
+	}
+	if want := []string{"certificates/api.crt", AcmeDNSAccountsFile}; !reflect.DeepEqual(report.Downloaded, want) {
+		t.Errorf("Downloaded = %v, want %v", report.Downloaded, want)
+	}
+	if want := []string{"certificates/web.crt"}; !reflect.DeepEqual(report.Uploaded, want) {
+		t.Errorf("Uploaded = %v, want %v", report.Uploaded, want)
+	}
+	if data, _ := local.Get(AcmeDNSAccountsFile); string(data) != "remote accounts" {
+		t.Errorf("Expected the backend to win, got %q", data)
+	}
+	if _, ok := remote.files[StateFile]; ok {
+		t.Error("state.json must stay local")
+	}
+	manifest, _, err := LoadManifest(cfg.CertStoragePath)
+	if err != nil {
+		t.Fatalf("LoadManifest failed: %v", err)
+	}
+	if _, ok := manifest.Files["certificates/api.crt"]; !ok {
+		t.Error("Expected fetched files in the manifest")
+	}
+
+	// A second run has nothing to do
+	report, err = SyncStorage(cfg)
+	if err != nil {
+		t.Fatalf("SyncStorage (second) failed: %v", err)
+	}
+	if len(report.Downloaded) != 0 || len(report.Uploaded) != 0 {
+		t.Errorf("Expected nothing to sync, got %+v", report)
+	}
+}
+
+func TestSyncStorage_FileBackend(t *testing.T) {
+	report, err := SyncStorage(&Config{CertStoragePath: t.TempDir()})
+	if err != nil || report != nil {
+		t.Errorf("Expected no sync for the file backend, got %+v, %v", report, err)
+	}
+}
+
+func TestQuarantineArtifacts_RemovesFromBackend(t *testing.T) {
+	cfg := &Config{CertStoragePath: t.TempDir()}
+	remote := newMemStore()
+	withStore(t, cfg, remote)
+
+	paths := certinfo.PathsFor(cfg.CertStoragePath, "web")
+	if err := writeStorageFile(cfg, paths.PrivateKey, []byte("key"), true); err != nil {
+		t.Fatalf("writeStorageFile failed: %v", err)
+	}
+	if _, err := QuarantineArtifacts(cfg, "web"); err != nil {
+		t.Fatalf("QuarantineArtifacts failed: %v", err)
+	}
+	if _, err := remote.Get("certificates/web.key"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected the quarantined key to be gone from the backend, got %v", err)
+	}
+}
+
+// withStore makes cfg use remote as storage backend for the duration of the test
+func withStore(t *testing.T, cfg *Config, remote CertificateStore) {
+	t.Helper()
+	cfg.Storage = &StorageConfig{Backend: "test"}
+	storageBackends["test"] = func(*StorageConfig, time.Duration) (CertificateStore, error) { return remote, nil }
+	t.Cleanup(func() { delete(storageBackends, "test") })
+}
+
+func TestLoadConfig_Storage(t *testing.T) {
+	tempDir := t.TempDir()
+	configPath := filepath.Join(tempDir, "config.yaml")
+	configContent := []byte(`
+email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+storage:
+  backend: s3
+  bucket: "certs"
+  region: "eu-west-1"
+  prefix: "prod"
+`)
+	if err := os.WriteFile(configPath, configContent, PrivateKeyPermissions); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if !cfg.IsRemoteStorage() || cfg.Storage.Bucket != "certs" || cfg.Storage.storageKeyPrefix() != "prod" {
+		t.Errorf("Unexpected storage config: %+v", cfg.Storage)
+	}
+
+	// The sqlite database is relative to the config file
+	sqliteConfig := []byte(`
+email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+storage:
+  backend: sqlite
+  path: "state/store.db"
+`)
+	if err := os.WriteFile(configPath, sqliteConfig, PrivateKeyPermissions); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+	cfg, err = LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if want := filepath.Join(tempDir, "state", "store.db"); cfg.Storage.Path != want {
+		t.Errorf("Expected sqlite path %s, got %s", want, cfg.Storage.Path)
+	}
+
+	// Unknown backends are rejected by the schema
+	bad := []byte(`
+email: "test@example.com"
+acme_server: "https://acme-staging-v02.api.letsencrypt.org/directory"
+acme_dns_server: "https://acme-dns.example.com"
+storage:
+  backend: ftp
+`)
+	if err := os.WriteFile(configPath, bad, PrivateKeyPermissions); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected an error for an unknown storage backend")
+	}
+}
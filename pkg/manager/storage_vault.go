@@ -0,0 +1,183 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// vaultStore keeps every file as a secret with a single base64 'content'
+// field in a Vault KV version 2 engine, below '<mount>/<prefix>/'
+type vaultStore struct {
+	address string
+	token   string
+	mount   string
+	prefix  string
+	client  *http.Client
+}
+
+func newVaultStore(s *StorageConfig, timeout time.Duration) (*vaultStore, error) {
+	address, token := s.VaultAddress, s.VaultToken
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if address == "" {
+		return nil, fmt.Errorf("vault_address is required (or VAULT_ADDR)")
+	}
+	if token == "" {
+		return nil, fmt.Errorf("vault_token is required (or VAULT_TOKEN)")
+	}
+	mount := strings.Trim(s.VaultMount, "/")
+	if mount == "" {
+		mount = "secret"
+	}
+	return &vaultStore{
+		address: strings.TrimSuffix(address, "/"),
+		token:   token,
+		mount:   mount,
+		prefix:  s.storageKeyPrefix(),
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Name implements CertificateStore
+func (v *vaultStore) Name() string { return StorageBackendVault }
+
+// url returns the API URL of a key below the data or metadata endpoint
+func (v *vaultStore) url(endpoint, key string) string {
+	return v.address + "/v1/" + v.mount + "/" + endpoint + "/" + v.prefix + "/" + key
+}
+
+// do sends a request and returns the response body of successful requests.
+// A 404 is reported as nil body with found set to false.
+func (v *vaultStore) do(method, url string, body []byte) (data []byte, found bool, err error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("vault request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, _ = io.ReadAll(io.LimitReader(resp.Body, 16*1024*1024))
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && len(apiErr.Errors) > 0 {
+			return nil, false, fmt.Errorf("vault API error (HTTP %d): %s", resp.StatusCode, strings.Join(apiErr.Errors, "; "))
+		}
+		return nil, false, fmt.Errorf("vault API error (HTTP %d): %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return data, true, nil
+}
+
+// Get implements CertificateStore
+func (v *vaultStore) Get(key string) ([]byte, error) {
+	data, found, err := v.do(http.MethodGet, v.url("data", key), nil)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, notFound(key)
+	}
+	var secret struct {
+		Data struct {
+			Data struct {
+				Content string `json:"content"`
+			} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &secret); err != nil {
+		return nil, fmt.Errorf("parsing vault secret %s: %w", key, err)
+	}
+	content, err := base64.StdEncoding.DecodeString(secret.Data.Data.Content)
+	if err != nil {
+		return nil, fmt.Errorf("decoding vault secret %s: %w", key, err)
+	}
+	return content, nil
+}
+
+// Put implements CertificateStore. Vault protects all secrets alike, so
+// private makes no difference.
+func (v *vaultStore) Put(key string, data []byte, private bool) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"data": map[string]string{"content": base64.StdEncoding.EncodeToString(data)},
+	})
+	if err != nil {
+		return err
+	}
+	_, _, err = v.do(http.MethodPost, v.url("data", key), body)
+	return err
+}
+
+// Delete implements CertificateStore, removing all versions of the secret
+func (v *vaultStore) Delete(key string) error {
+	_, _, err := v.do(http.MethodDelete, v.url("metadata", key), nil)
+	return err
+}
+
+// List implements CertificateStore by walking the metadata tree
+func (v *vaultStore) List(prefix string) ([]string, error) {
+	// Vault lists directories, start at the one holding the prefix
+	dir := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = prefix[:i+1]
+	}
+	var keys []string
+	if err := v.list(dir, prefix, &keys); err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (v *vaultStore) list(dir, prefix string, keys *[]string) error {
+	data, found, err := v.do(http.MethodGet, v.url("metadata", dir)+"?list=true", nil)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+	var listing struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &listing); err != nil {
+		return fmt.Errorf("parsing vault listing of %s: %w", dir, err)
+	}
+	for _, name := range listing.Data.Keys {
+		key := dir + name
+		if strings.HasSuffix(name, "/") {
+			if strings.HasPrefix(key, prefix) || strings.HasPrefix(prefix, key) {
+				if err := v.list(key, prefix, keys); err != nil {
+					return err
+				}
+			}
+		} else if strings.HasPrefix(key, prefix) {
+			*keys = append(*keys, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeVault serves the parts of the KV version 2 API the store uses
+func fakeVault(t *testing.T, token string) (*httptest.Server, map[string]string) {
+	t.Helper()
+	secrets := make(map[string]string) // path below the mount -> base64 content
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v1/secret/data/"):
+			path := strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")
+			switch r.Method {
+			case http.MethodGet:
+				content, ok := secrets[path]
+				if !ok {
+					w.WriteHeader(http.StatusNotFound)
+					_, _ = w.Write([]byte(`{"errors":[]}`))
+					return
+				}
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"data": map[string]interface{}{"data": map[string]string{"content": content}},
+				})
+			case http.MethodPost:
+				var body struct {
+					Data map[string]string `json:"data"`
+				}
+				data, _ := io.ReadAll(r.Body)
+				if err := json.Unmarshal(data, &body); err != nil {
+					t.Errorf("Invalid write body: %v", err)
+				}
+				secrets[path] = body.Data["content"]
+				_, _ = w.Write([]byte(`{"data":{"version":1}}`))
+			}
+		case strings.HasPrefix(r.URL.Path, "/v1/secret/metadata/"):
+			path := strings.TrimPrefix(r.URL.Path, "/v1/secret/metadata/")
+			switch {
+			case r.Method == http.MethodDelete:
+				delete(secrets, path)
+				w.WriteHeader(http.StatusNoContent)
+			case r.URL.Query().Get("list") == "true":
+				seen := make(map[string]bool)
+				var keys []string
+				for p := range secrets {
+					if !strings.HasPrefix(p, path) {
+						continue
+					}
+					name := strings.TrimPrefix(p, path)
+					if i := strings.Index(name, "/"); i >= 0 {
+						name = name[:i+1]
+					}
+					if !seen[name] {
+						seen[name] = true
+						keys = append(keys, name)
+					}
+				}
+				if len(keys) == 0 {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, secrets
+}
+
+func TestVaultStore(t *testing.T) {
+	server, secrets := fakeVault(t, "s3cret")
+	store, err := newVaultStore(&StorageConfig{VaultAddress: server.URL, VaultToken: "s3cret", Prefix: "/acme/"}, time.Second)
+	if err != nil {
+		t.Fatalf("newVaultStore failed: %v", err)
+	}
+
+	if _, err := store.Get("certificates/web.crt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a not-exist error for a missing key, got %v", err)
+	}
+	for key, content := range map[string]string{
+		"certificates/web.crt":                    "cert",
+		"certificates/web.key":                    "key",
+		"accounts/acme/me@example.com/keys/x.key": "account",
+		AcmeDNSAccountsFile:                       "{}",
+	} {
+		if err := store.Put(key, []byte(content), true); err != nil {
+			t.Fatalf("Put %s failed: %v", key, err)
+		}
+	}
+	if _, ok := secrets["acme/certificates/web.crt"]; !ok {
+		t.Errorf("Expected the secret below the prefix, got %v", secrets)
+	}
+
+	data, err := store.Get("certificates/web.key")
+	if err != nil || string(data) != "key" {
+		t.Errorf("Get = %q, %v; want %q", data, err, "key")
+	}
+
+	keys, err := store.List("certificates/")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if want := []string{"certificates/web.crt", "certificates/web.key"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("List = %v, want %v", keys, want)
+	}
+	keys, err = store.List("accounts/")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if want := []string{"accounts/acme/me@example.com/keys/x.key"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("List = %v, want %v", keys, want)
+	}
+
+	if err := store.Delete("certificates/web.crt"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Get("certificates/web.crt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected the deleted key to be gone, got %v", err)
+	}
+}
+
+func TestVaultStore_PermissionDenied(t *testing.T) {
+	server, _ := fakeVault(t, "s3cret")
+	store, err := newVaultStore(&StorageConfig{VaultAddress: server.URL, VaultToken: "wrong"}, time.Second)
+	if err != nil {
+		t.Fatalf("newVaultStore failed: %v", err)
+	}
+	err = store.Put("certificates/web.crt", []byte("cert"), false)
+	if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("Expected the Vault error message, got %v", err)
+	}
+}
+
+func TestNewVaultStore_Environment(t *testing.T) {
+	t.Setenv("VAULT_ADDR", "https://vault.example.com/")
+	t.Setenv("VAULT_TOKEN", "env-token")
+	store, err := newVaultStore(&StorageConfig{}, time.Second)
+	if err != nil {
+		t.Fatalf("newVaultStore failed: %v", err)
+	}
+	if store.address != "https://vault.example.com" || store.token != "env-token" {
+		t.Errorf("Expected address and token from the environment, got %s %s", store.address, store.token)
+	}
+	if store.mount != "secret" || store.prefix != DefaultStoragePrefix {
+		t.Errorf("Expected default mount and prefix, got %s %s", store.mount, store.prefix)
+	}
+}
@@ -4,13 +4,11 @@
 package test_helpers
 
 import (
-	"crypto/x509"
-	"encoding/pem"
-	"errors"
-	"os"
 	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
 )
 
 // CertificateInfo contains metadata about a certificate
@@ -24,31 +22,18 @@ type CertificateInfo struct {
 
 // ValidateCertificateFile reads a certificate file and validates its contents
 func ValidateCertificateFile(t *testing.T, certPath string) (*CertificateInfo, error) {
-	// Read the certificate file
-	certPEM, err := os.ReadFile(certPath)
-	if err != nil {
-		return nil, err
-	}
-
-	// Parse the PEM data
-	block, _ := pem.Decode(certPEM)
-	if block == nil {
-		return nil, errors.New("failed to parse certificate PEM")
-	}
-
-	// Parse the certificate
-	cert, err := x509.ParseCertificate(block.Bytes)
+	parsed, err := certinfo.Load(certPath)
 	if err != nil {
 		return nil, err
 	}
 
 	// Extract certificate information
 	info := &CertificateInfo{
-		CommonName: cert.Subject.CommonName,
-		DNSNames:   cert.DNSNames,
-		NotBefore:  cert.NotBefore,
-		NotAfter:   cert.NotAfter,
-		Issuer:     cert.Issuer.CommonName,
+		CommonName: parsed.CommonName,
+		DNSNames:   parsed.DNSNames,
+		NotBefore:  parsed.NotBefore,
+		NotAfter:   parsed.NotAfter,
+		Issuer:     parsed.Issuer,
 	}
 
 	return info, nil
@@ -4,6 +4,7 @@
 package test_helpers
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -24,6 +25,15 @@ const (
 	PrivateKeyPermissions  = 0600
 )
 
+// MockLegoRunContext is MockLegoRun with the signature of the application's
+// Lego runner, it fails like the real one if ctx is already canceled
+func MockLegoRunContext(ctx context.Context, cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return MockLegoRun(cfg, store, action, certName, domains, keyType)
+}
+
 // MockLegoRun is a mock implementation of RunLego
 // It simulates the creation of certificates but creates real X.509 certificates with all requested domains
 func MockLegoRun(cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
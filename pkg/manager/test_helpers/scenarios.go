@@ -0,0 +1,122 @@
+//go:build testutils
+// +build testutils
+
+package test_helpers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/oetiker/go-acme-dns-manager/pkg/common"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+// Fault injection scenarios of the mock binary
+const (
+	ScenarioRateLimit       = "rate-limit"       // Every order is rate limited
+	ScenarioDNSTimeout      = "dns-timeout"      // The TXT records never propagate
+	ScenarioACME500         = "acme-500"         // The ACME server fails with 500
+	ScenarioPartialFailure  = "partial-failure"  // Every second certificate fails
+	ScenarioSlowPropagation = "slow-propagation" // The TXT records take a while, then succeed
+)
+
+// Scenarios lists the scenarios ScenarioLegoRunner accepts
+var Scenarios = []string{ScenarioRateLimit, ScenarioDNSTimeout, ScenarioACME500, ScenarioPartialFailure, ScenarioSlowPropagation}
+
+// ScenarioPropagationDelay is how long slow-propagation waits for the TXT
+// records of every certificate
+var ScenarioPropagationDelay = 5 * time.Second
+
+// LegoRunFunc has the signature of the application's Lego runner
+type LegoRunFunc func(ctx context.Context, cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error
+
+// ScenarioLegoRunner wraps next, usually MockLegoRunContext, so certificate
+// requests fail the way the scenario describes. The errors look like those
+// of the real runner, so the run summary, the exit code and the
+// notifications behave as they would against a real ACME server. The faults
+// are deterministic: partial-failure fails the second, fourth, ...
+// certificate of auto_domains in name order and issues the others.
+func ScenarioLegoRunner(scenario string, next LegoRunFunc) (LegoRunFunc, error) {
+	switch scenario {
+	case ScenarioRateLimit:
+		return func(ctx context.Context, cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
+			problem := &acme.ProblemDetails{
+				Type:       "urn:ietf:params:acme:error:rateLimited",
+				Detail:     fmt.Sprintf("too many certificates (5) already issued for this exact set of identifiers in the last 168h0m0s, retry after %s", time.Now().Add(time.Hour).UTC().Format(time.RFC3339)),
+				HTTPStatus: http.StatusTooManyRequests,
+			}
+			return common.WrapError(problem, common.ErrorTypeRateLimit, "obtain certificate",
+				"The server is rate limiting requests").
+				AddContext("attempts", 1).
+				AddContext("retry_after", time.Hour.String()).
+				AddSuggestion("Run again later, the affected certificates are retried on the next run")
+		}, nil
+	case ScenarioDNSTimeout:
+		return func(ctx context.Context, cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
+			var errs []string
+			for _, domain := range domains {
+				errs = append(errs, fmt.Sprintf("[%s] propagation: time limit exceeded: last error: NS ns1.%s. did not return the expected TXT record [fqdn: _acme-challenge.%s.]",
+					domain, manager.GetBaseDomain(domain), manager.GetBaseDomain(domain)))
+			}
+			return common.NewDNSError("obtain certificate", "error: one or more domains had a problem:\n"+strings.Join(errs, "\n")).
+				AddContext("certificate", certName)
+		}, nil
+	case ScenarioACME500:
+		return func(ctx context.Context, cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
+			return serverInternalProblem()
+		}, nil
+	case ScenarioPartialFailure:
+		return func(ctx context.Context, cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
+			if failsInPartialScenario(cfg, certName) {
+				return serverInternalProblem()
+			}
+			return next(ctx, cfg, store, action, certName, domains, keyType)
+		}, nil
+	case ScenarioSlowPropagation:
+		return func(ctx context.Context, cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
+			manager.DefaultLogger.Infof("Waiting %s for the TXT records of certificate %s to propagate...", ScenarioPropagationDelay, certName)
+			timer := time.NewTimer(ScenarioPropagationDelay)
+			defer timer.Stop()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+			}
+			return next(ctx, cfg, store, action, certName, domains, keyType)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown scenario %q, use one of %s", scenario, strings.Join(Scenarios, ", "))
+}
+
+// serverInternalProblem is the error lego returns for an HTTP 500 of the ACME server
+func serverInternalProblem() error {
+	return &acme.ProblemDetails{
+		Type:       "urn:ietf:params:acme:error:serverInternal",
+		Detail:     "Error creating new order",
+		HTTPStatus: http.StatusInternalServerError,
+	}
+}
+
+// failsInPartialScenario reports whether certName is at an odd position of
+// the auto_domains certificates sorted by name
+func failsInPartialScenario(cfg *manager.Config, certName string) bool {
+	if cfg.AutoDomains == nil {
+		return false
+	}
+	names := make([]string, 0, len(cfg.AutoDomains.Certs))
+	for name := range cfg.AutoDomains.Certs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if name == certName {
+			return i%2 == 1
+		}
+	}
+	return false
+}
@@ -190,7 +190,7 @@ func TestRenewalDomainChangeWithApp(t *testing.T) {
 	}
 
 	// Replace with mock runner
-	app.DefaultLegoRunner = test_helpers.MockLegoRun
+	app.DefaultLegoRunner = test_helpers.MockLegoRunContext
 
 	// Process in auto mode (should detect domain change and attempt renewal)
 	ctx := context.Background()
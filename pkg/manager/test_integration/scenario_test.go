@@ -0,0 +1,73 @@
+//go:build testutils
+// +build testutils
+
+package test_integration
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/app"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager/test_helpers"
+)
+
+// TestScenarioExitCodes runs auto mode with every mock scenario and checks
+// the outcomes and exit codes operators rehearse against
+func TestScenarioExitCodes(t *testing.T) {
+	saved := test_helpers.ScenarioPropagationDelay
+	test_helpers.ScenarioPropagationDelay = 10 * time.Millisecond
+	defer func() { test_helpers.ScenarioPropagationDelay = saved }()
+
+	tests := []struct {
+		scenario string
+		exitCode int
+		outcomes map[string]string
+	}{
+		{test_helpers.ScenarioRateLimit, app.ExitACMEError, map[string]string{"alpha": app.OutcomeDeferred, "beta": app.OutcomeDeferred, "gamma": app.OutcomeDeferred}},
+		{test_helpers.ScenarioDNSTimeout, app.ExitTotalFailure, map[string]string{"alpha": app.OutcomeFailed, "beta": app.OutcomeFailed, "gamma": app.OutcomeFailed}},
+		{test_helpers.ScenarioACME500, app.ExitACMEError, map[string]string{"alpha": app.OutcomeFailed, "beta": app.OutcomeFailed, "gamma": app.OutcomeFailed}},
+		{test_helpers.ScenarioPartialFailure, app.ExitPartialFailure, map[string]string{"alpha": app.OutcomeIssued, "beta": app.OutcomeFailed, "gamma": app.OutcomeIssued}},
+		{test_helpers.ScenarioSlowPropagation, app.ExitOK, map[string]string{"alpha": app.OutcomeIssued, "beta": app.OutcomeIssued, "gamma": app.OutcomeIssued}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scenario, func(t *testing.T) {
+			cfg := &manager.Config{
+				Email:           "test@example.com",
+				AcmeServer:      "https://acme.example.com/directory",
+				AcmeDnsServer:   "https://acme-dns.example.com",
+				CertStoragePath: t.TempDir(),
+				AutoDomains: &manager.AutoDomainsConfig{Certs: map[string]manager.CertConfig{
+					"alpha": {Domains: []string{"alpha.example.com"}},
+					"beta":  {Domains: []string{"beta.example.com"}},
+					"gamma": {Domains: []string{"gamma.example.com"}},
+				}},
+			}
+			certManager, err := app.NewCertificateManager(cfg, manager.NewColorfulLogger(io.Discard, manager.LogLevelError, false, false))
+			if err != nil {
+				t.Fatalf("Failed to create certificate manager: %v", err)
+			}
+			runner, err := test_helpers.ScenarioLegoRunner(tt.scenario, test_helpers.MockLegoRunContext)
+			if err != nil {
+				t.Fatalf("ScenarioLegoRunner failed: %v", err)
+			}
+			certManager.SetLegoRunner(app.LegoRunnerFunc(runner))
+
+			err = certManager.ProcessAutoMode(context.Background())
+			if code := app.ExitCode(err); code != tt.exitCode {
+				t.Errorf("Expected exit code %d, got %d (%v)", tt.exitCode, code, err)
+			}
+			for _, result := range certManager.Results() {
+				if result.Outcome != tt.outcomes[result.Name] {
+					t.Errorf("Expected %s for %s, got %s (%v)", tt.outcomes[result.Name], result.Name, result.Outcome, result.Err)
+				}
+			}
+		})
+	}
+
+	if _, err := test_helpers.ScenarioLegoRunner("meteor-strike", test_helpers.MockLegoRunContext); err == nil {
+		t.Error("Expected an unknown scenario to be rejected")
+	}
+}
@@ -67,7 +67,7 @@ func TestUserWorkflow_BasicCommandLine(t *testing.T) {
 	certManager.SetDNSResolver(mockResolver)
 
 	testRuns := 0
-	certManager.SetLegoRunner(func(cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
+	certManager.SetLegoRunner(func(ctx context.Context, cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
 		testRuns++
 		t.Logf("Mock Lego called: run=%d, action=%s, cert=%s, domains=%v", testRuns, action, certName, domains)
 
@@ -117,7 +117,7 @@ func TestUserWorkflow_BasicCommandLine(t *testing.T) {
 	mockResolver2.AddCNAMERecord("_acme-challenge.example.com", "test-uuid.acme-dns.example.com")
 	certManager2.SetDNSResolver(mockResolver2)
 
-	certManager2.SetLegoRunner(func(cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
+	certManager2.SetLegoRunner(func(ctx context.Context, cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
 		// Second run - DNS is configured, should proceed normally
 		if action != "init" {
 			t.Errorf("Expected action 'init', got: %s", action)
@@ -212,7 +212,7 @@ func TestUserWorkflow_BasicAutoMode(t *testing.T) {
 	// Don't add any CNAME records, so verification will fail
 	certManager.SetDNSResolver(mockResolver)
 
-	certManager.SetLegoRunner(func(cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
+	certManager.SetLegoRunner(func(ctx context.Context, cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
 		t.Logf("Mock Lego called: action=%s, cert=%s", action, certName)
 
 		if action != "init" {
@@ -245,7 +245,7 @@ func TestUserWorkflow_BasicAutoMode(t *testing.T) {
 	mockResolver2.AddCNAMERecord("_acme-challenge.www.example.com", "test-uuid-www.acme-dns.example.com")
 	certManager2.SetDNSResolver(mockResolver2)
 
-	certManager2.SetLegoRunner(func(cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
+	certManager2.SetLegoRunner(func(ctx context.Context, cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
 		// Simulate successful certificate creation
 		certPath := filepath.Join(cfg.CertStoragePath, "certificates", certName+".crt")
 		os.MkdirAll(filepath.Dir(certPath), 0755)
@@ -306,7 +306,7 @@ func TestUserWorkflow_Renewal_CommandLine(t *testing.T) {
 	}
 
 	renewalCalled := false
-	certManager.SetLegoRunner(func(cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
+	certManager.SetLegoRunner(func(ctx context.Context, cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
 		t.Logf("Mock Lego called: action=%s", action)
 
 		if action == "renew" {
@@ -387,7 +387,7 @@ func TestUserWorkflow_Renewal_AutoMode(t *testing.T) {
 	}
 
 	renewals := make(map[string]bool)
-	certManager.SetLegoRunner(func(cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
+	certManager.SetLegoRunner(func(ctx context.Context, cfg *manager.Config, store interface{}, action string, certName string, domains []string, keyType string) error {
 		t.Logf("Mock Lego called: action=%s, cert=%s", action, certName)
 
 		if action == "renew" {
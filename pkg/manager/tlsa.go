@@ -0,0 +1,155 @@
+package manager
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+// TLSA usages that can be requested via tlsa.usages. Both use the SHA-256
+// hash of the SubjectPublicKeyInfo (selector 1, matching type 1).
+const (
+	TLSAUsageDANEEE = "3 1 1" // The key of the certificate itself
+	TLSAUsageDANETA = "2 1 1" // The key of the issuing CA
+)
+
+// TLSA output formats selectable via tlsa.format
+const (
+	TLSAFormatZone = "zone" // <name>.tlsa, one zone file line per record
+	TLSAFormatJSON = "json" // <name>.tlsa.json
+)
+
+// TLSAConfig requests DANE TLSA records for a certificate
+type TLSAConfig struct {
+	Ports  []string `yaml:"ports"`            // e.g. "443", "25/tcp", protocol defaults to tcp
+	Usages []string `yaml:"usages,omitempty"` // "3 1 1" and/or "2 1 1" (default: both)
+	Format string   `yaml:"format,omitempty"` // zone (default) or json
+}
+
+// TLSARecord is one DANE TLSA record
+type TLSARecord struct {
+	Name         string `json:"name"` // e.g. _443._tcp.example.com.
+	Usage        int    `json:"usage"`
+	Selector     int    `json:"selector"`
+	MatchingType int    `json:"matching_type"`
+	Data         string `json:"data"` // Hex encoded SHA-256 of the SubjectPublicKeyInfo
+}
+
+// String returns the record in zone file format
+func (r TLSARecord) String() string {
+	return fmt.Sprintf("%s IN TLSA %d %d %d %s", r.Name, r.Usage, r.Selector, r.MatchingType, r.Data)
+}
+
+// TLSARecords computes the TLSA records of a certificate for the configured
+// ports and usages. The names are taken from the certificate, wildcard names
+// are skipped. The 2 1 1 records need the issuer certificate.
+func TLSARecords(resource *certificate.Resource, tlsa *TLSAConfig) ([]TLSARecord, error) {
+	chain, err := certcrypto.ParsePEMBundle(resource.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("parsing certificate: %w", err)
+	}
+	leaf := chain[0]
+	var issuer *x509.Certificate
+	if len(resource.IssuerCertificate) > 0 {
+		if issuers, err := certcrypto.ParsePEMBundle(resource.IssuerCertificate); err == nil {
+			issuer = issuers[0]
+		}
+	}
+	if issuer == nil && len(chain) > 1 {
+		issuer = chain[1]
+	}
+
+	usages := tlsa.Usages
+	if len(usages) == 0 {
+		usages = []string{TLSAUsageDANEEE, TLSAUsageDANETA}
+	}
+
+	var records []TLSARecord
+	for _, name := range leaf.DNSNames {
+		if strings.HasPrefix(name, "*.") {
+			continue
+		}
+		for _, port := range tlsa.Ports {
+			portNumber, proto, _ := strings.Cut(port, "/")
+			if proto == "" {
+				proto = "tcp"
+			}
+			owner := fmt.Sprintf("_%s._%s.%s.", portNumber, proto, name)
+			for _, usage := range usages {
+				cert := leaf
+				if usage == TLSAUsageDANETA {
+					if issuer == nil {
+						return nil, fmt.Errorf("TLSA %s needs the issuer certificate, which is missing", usage)
+					}
+					cert = issuer
+				}
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				records = append(records, TLSARecord{
+					Name:         owner,
+					Usage:        int(usage[0] - '0'),
+					Selector:     1,
+					MatchingType: 1,
+					Data:         hex.EncodeToString(sum[:]),
+				})
+			}
+		}
+	}
+	return records, nil
+}
+
+// saveTLSARecords writes the TLSA records of a certificate with tlsa
+// configuration and shows them. A change of the records is shown as a
+// warning, DNS has to be updated before the new certificate is deployed.
+func saveTLSARecords(cfg *Config, certName string, resource *certificate.Resource) error {
+	certCfg, ok := cfg.CertConfigFor(certName)
+	if !ok || certCfg.TLSA == nil {
+		return nil
+	}
+	records, err := TLSARecords(resource, certCfg.TLSA)
+	if err != nil {
+		return fmt.Errorf("computing TLSA records for %s: %w", certName, err)
+	}
+
+	paths := certinfo.PathsFor(cfg.CertStoragePath, certName)
+	path := paths.TLSA
+	var data []byte
+	if certCfg.TLSA.Format == TLSAFormatJSON {
+		path = paths.TLSAJSON
+		if data, err = json.MarshalIndent(records, "", "  "); err != nil {
+			return fmt.Errorf("encoding TLSA records for %s: %w", certName, err)
+		}
+		data = append(data, '\n')
+	} else {
+		var buf bytes.Buffer
+		for _, r := range records {
+			buf.WriteString(r.String() + "\n")
+		}
+		data = buf.Bytes()
+	}
+
+	previous, readErr := os.ReadFile(path)
+	changed := readErr == nil && !bytes.Equal(previous, data)
+	if err := writeStorageFile(cfg, path, data, false); err != nil {
+		return fmt.Errorf("writing TLSA file %s: %w", path, err)
+	}
+	DefaultLogger.Infof("Saved TLSA records to %s", path)
+
+	show := DefaultLogger.Infof
+	if changed {
+		DefaultLogger.Warnf("TLSA records of %s changed, update them in DNS:", certName)
+		show = DefaultLogger.Warnf
+	}
+	for _, r := range records {
+		show("    %s", r)
+	}
+	return nil
+}
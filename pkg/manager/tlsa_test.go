@@ -0,0 +1,72 @@
+package manager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+func TestTLSARecords(t *testing.T) {
+	resource := createSignedResource(t)
+	records, err := TLSARecords(resource, &TLSAConfig{Ports: []string{"443", "25/tcp"}})
+	if err != nil {
+		t.Fatalf("TLSARecords failed: %v", err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("Expected 2 ports x 2 usages, got %v", records)
+	}
+
+	chain, _ := certcrypto.ParsePEMBundle(resource.Certificate)
+	leafSum := sha256.Sum256(chain[0].RawSubjectPublicKeyInfo)
+	issuerSum := sha256.Sum256(chain[1].RawSubjectPublicKeyInfo)
+	if want := "_443._tcp.example.com. IN TLSA 3 1 1 " + hex.EncodeToString(leafSum[:]); records[0].String() != want {
+		t.Errorf("Unexpected record %q, want %q", records[0], want)
+	}
+	if records[1].Usage != 2 || records[1].Data != hex.EncodeToString(issuerSum[:]) {
+		t.Errorf("Expected the 2 1 1 record of the issuer, got %v", records[1])
+	}
+	if records[2].Name != "_25._tcp.example.com." {
+		t.Errorf("Unexpected owner name %s", records[2].Name)
+	}
+
+	// Without an issuer the 2 1 1 record cannot be computed
+	resource.IssuerCertificate = nil
+	resource.Certificate = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain[0].Raw})
+	if _, err := TLSARecords(resource, &TLSAConfig{Ports: []string{"443"}, Usages: []string{TLSAUsageDANETA}}); err == nil {
+		t.Error("Expected an error for 2 1 1 without issuer")
+	}
+}
+
+func TestSaveCertificates_TLSA(t *testing.T) {
+	cfg := outputFormatsConfig(t, CertConfig{Domains: []string{"example.com"}, TLSA: &TLSAConfig{Ports: []string{"443"}, Usages: []string{TLSAUsageDANEEE}}})
+	if err := saveCertificates(cfg, "web", createSignedResource(t), ""); err != nil {
+		t.Fatalf("saveCertificates failed: %v", err)
+	}
+	data, err := os.ReadFile(certinfo.PathsFor(cfg.CertStoragePath, "web").TLSA)
+	if err != nil {
+		t.Fatalf("TLSA file not written: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "_443._tcp.example.com. IN TLSA 3 1 1 ") {
+		t.Errorf("Unexpected TLSA file %q", data)
+	}
+
+	cfg = outputFormatsConfig(t, CertConfig{Domains: []string{"example.com"}, TLSA: &TLSAConfig{Ports: []string{"443"}, Format: TLSAFormatJSON}})
+	if err := saveCertificates(cfg, "web", createSignedResource(t), ""); err != nil {
+		t.Fatalf("saveCertificates failed: %v", err)
+	}
+	data, err = os.ReadFile(certinfo.PathsFor(cfg.CertStoragePath, "web").TLSAJSON)
+	if err != nil {
+		t.Fatalf("TLSA JSON file not written: %v", err)
+	}
+	var records []TLSARecord
+	if err := json.Unmarshal(data, &records); err != nil || len(records) != 2 {
+		t.Errorf("Unexpected TLSA JSON %s: %v", data, err)
+	}
+}
@@ -0,0 +1,219 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+// Kinds of problems reported by VerifyStorage
+const (
+	StorageMissingCert     = "missing-cert"     // Key or metadata without a certificate
+	StorageMissingKey      = "missing-key"      // Certificate without a private key
+	StorageMissingMetadata = "missing-metadata" // Certificate without '<name>.json'
+	StorageUnparsable      = "unparsable"       // PEM or JSON content cannot be parsed
+	StorageKeyMismatch     = "key-mismatch"     // The private key does not belong to the certificate
+	StoragePermissions     = "permissions"      // A file or directory is more open than the manager creates it
+)
+
+// StorageIssue is one problem found by VerifyStorage
+type StorageIssue struct {
+	CertName string `json:"cert_name,omitempty"` // Empty for files not belonging to a certificate
+	Path     string `json:"path"`                // Storage-relative path
+	Kind     string `json:"kind"`                // One of the Storage* kinds
+	Detail   string `json:"detail"`
+	Repaired string `json:"repaired,omitempty"` // What the repair did about it, empty if nothing
+}
+
+// StorageReport is the result of VerifyStorage
+type StorageReport struct {
+	Checked int            `json:"checked"` // Certificates checked
+	Issues  []StorageIssue `json:"issues"`
+}
+
+// Unresolved returns the number of issues the repair did not fix
+func (r *StorageReport) Unresolved() int {
+	count := 0
+	for _, issue := range r.Issues {
+		if issue.Repaired == "" {
+			count++
+		}
+	}
+	return count
+}
+
+// VerifyStorage checks that every certificate in the storage directory has a
+// parsable certificate, the matching private key and metadata, and that no
+// file is more accessible than the manager creates it. Unlike Fsck it checks
+// the content, not the checksums.
+//
+// With repair set, missing or broken metadata is regenerated from the
+// certificate and file modes are tightened. Certificates and keys are never
+// touched, the next renewal replaces broken ones.
+func VerifyStorage(storagePath string, repair bool) (*StorageReport, error) {
+	return verifyStorage(storagePath, nil, repair)
+}
+
+// VerifyConfigStorage is VerifyStorage for the storage directory of cfg,
+// accepting the modes file_permissions allows
+func VerifyConfigStorage(cfg *Config, repair bool) (*StorageReport, error) {
+	policy, err := cfg.filePolicy()
+	if err != nil {
+		return nil, err
+	}
+	return verifyStorage(cfg.CertStoragePath, policy, repair)
+}
+
+func verifyStorage(storagePath string, policy *filePolicy, repair bool) (*StorageReport, error) {
+	names, err := certinfo.List(storagePath)
+	if err != nil {
+		return nil, err
+	}
+	report := &StorageReport{}
+	for _, name := range names {
+		report.Checked++
+		report.Issues = append(report.Issues, verifyCertificateFiles(storagePath, name, repair)...)
+	}
+	permIssues, err := verifyPermissions(storagePath, policy, repair)
+	if err != nil {
+		return nil, err
+	}
+	report.Issues = append(report.Issues, permIssues...)
+	sort.SliceStable(report.Issues, func(i, j int) bool { return report.Issues[i].Path < report.Issues[j].Path })
+	return report, nil
+}
+
+// verifyCertificateFiles checks the files of one certificate
+func verifyCertificateFiles(storagePath, certName string, repair bool) []StorageIssue {
+	paths := certinfo.PathsFor(storagePath, certName)
+	rel := func(path string) string {
+		r, err := manifestKey(storagePath, path)
+		if err != nil || strings.HasPrefix(r, "../") {
+			// A private key in key_output_dir is outside the storage directory
+			return path
+		}
+		return r
+	}
+	var issues []StorageIssue
+	add := func(path, kind, detail string) int {
+		issues = append(issues, StorageIssue{CertName: certName, Path: rel(path), Kind: kind, Detail: detail})
+		return len(issues) - 1
+	}
+
+	artifact, err := LoadArtifact(storagePath, certName)
+	paths.PrivateKey = artifact.Paths.PrivateKey
+	switch {
+	case err != nil && artifact.Info == nil:
+		add(paths.Certificate, StorageUnparsable, err.Error())
+	case err != nil:
+		add(paths.PrivateKey, StorageUnparsable, err.Error())
+	case artifact.Info == nil:
+		add(paths.Certificate, StorageMissingCert, "no certificate for the stored files")
+	case !artifact.HasKey:
+		add(paths.PrivateKey, StorageMissingKey, "certificate has no private key")
+	case !artifact.KeyMatches:
+		add(paths.PrivateKey, StorageKeyMismatch, "private key does not belong to the certificate")
+	}
+
+	if artifact.HasIssuer {
+		if _, err := certinfo.Load(paths.Issuer); err != nil {
+			add(paths.Issuer, StorageUnparsable, err.Error())
+		}
+	}
+
+	metadataIssue := -1
+	if !artifact.HasMetadata {
+		if artifact.Info != nil {
+			metadataIssue = add(paths.Metadata, StorageMissingMetadata, "certificate has no metadata")
+		}
+	} else if data, err := os.ReadFile(paths.Metadata); err != nil {
+		metadataIssue = add(paths.Metadata, StorageUnparsable, err.Error())
+	} else if err := json.Unmarshal(data, &struct{}{}); err != nil {
+		metadataIssue = add(paths.Metadata, StorageUnparsable, err.Error())
+	}
+	if metadataIssue >= 0 && repair && artifact.Info != nil {
+		if path, err := regenerateCertMetadata(storagePath, certName); err == nil {
+			recordManifest(storagePath, path)
+			issues[metadataIssue].Repaired = "regenerated from certificate"
+		}
+	}
+	return issues
+}
+
+// verifyPermissions checks that private files are only accessible by the
+// owner and that nothing in the storage directory is writable by others.
+// Bits granted by the modes of file_permissions are accepted. File modes are
+// not meaningful on Windows, so nothing is checked there.
+func verifyPermissions(storagePath string, policy *filePolicy, repair bool) ([]StorageIssue, error) {
+	if runtime.GOOS == "windows" {
+		return nil, nil
+	}
+	var issues []StorageIssue
+	err := filepath.WalkDir(storagePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == storagePath {
+				return fs.SkipDir
+			}
+			return err
+		}
+		// The storage directory itself is created by the user, only its content is checked
+		if path == storagePath || d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		// Only the excess bits are removed, like the modes the files are created with
+		mode := info.Mode().Perm()
+		var excess fs.FileMode
+		switch {
+		case d.IsDir():
+			excess = mode & 0007 &^ policy.directoryMode()
+		case isPublicStorageFile(path):
+			excess = mode & 0022 &^ policy.fileMode(false)
+		default:
+			excess = mode & 0077 &^ policy.fileMode(true)
+		}
+		if excess == 0 {
+			return nil
+		}
+		want := mode &^ excess
+		rel, err := manifestKey(storagePath, path)
+		if err != nil {
+			return err
+		}
+		issue := StorageIssue{Path: rel, Kind: StoragePermissions, Detail: fmt.Sprintf("mode %04o, should be %04o", mode, want)}
+		if repair {
+			if err := os.Chmod(path, want); err == nil {
+				issue.Repaired = fmt.Sprintf("changed to %04o", want)
+			}
+		}
+		issues = append(issues, issue)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scanning storage directory: %w", err)
+	}
+	return issues, nil
+}
+
+// isPublicStorageFile reports whether a file holds only public data, like
+// certificates and chains, and may be readable by everyone. This includes
+// the copies in archived generations and live directories.
+func isPublicStorageFile(path string) bool {
+	name := filepath.Base(path)
+	switch name {
+	case LiveCert, LiveChain, LiveFullChain:
+		return true
+	}
+	return strings.HasSuffix(name, ".crt") || strings.HasSuffix(name, ".fullchain.pem") ||
+		strings.HasSuffix(name, ".tlsa") || strings.HasSuffix(name, ".tlsa.json")
+}
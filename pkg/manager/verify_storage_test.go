@@ -0,0 +1,140 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+)
+
+// adoptTestCertificate stores a valid certificate with key, chain and metadata
+func adoptTestCertificate(t *testing.T, storagePath, certName string) certinfo.Paths {
+	t.Helper()
+	certPEM, keyPEM, chainPEM := newTestChain(t, []string{certName + ".example.com"})
+	if _, err := AdoptCertificate(&Config{CertStoragePath: storagePath}, certName, certPEM, keyPEM, chainPEM); err != nil {
+		t.Fatalf("Failed to store certificate: %v", err)
+	}
+	return certinfo.PathsFor(storagePath, certName)
+}
+
+// issueKinds maps the storage-relative paths of a report to the issue kinds
+func issueKinds(report *StorageReport) map[string]string {
+	kinds := make(map[string]string)
+	for _, issue := range report.Issues {
+		kinds[issue.Path] = issue.Kind
+	}
+	return kinds
+}
+
+func TestVerifyStorage_Clean(t *testing.T) {
+	dir := t.TempDir()
+	adoptTestCertificate(t, dir, "web")
+
+	report, err := VerifyStorage(dir, false)
+	if err != nil {
+		t.Fatalf("VerifyStorage failed: %v", err)
+	}
+	if report.Checked != 1 || len(report.Issues) != 0 {
+		t.Errorf("Expected one clean certificate, got %d checked with %+v", report.Checked, report.Issues)
+	}
+}
+
+func TestVerifyStorage_Problems(t *testing.T) {
+	dir := t.TempDir()
+	web := adoptTestCertificate(t, dir, "web")
+	mail := adoptTestCertificate(t, dir, "mail")
+	orphan := adoptTestCertificate(t, dir, "orphan")
+	broken := adoptTestCertificate(t, dir, "broken")
+
+	// web: metadata lost, mail: key from another certificate, orphan: certificate lost, broken: garbage certificate
+	if err := os.Remove(web.Metadata); err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := os.ReadFile(web.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mail.PrivateKey, otherKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(orphan.Certificate); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(broken.Certificate, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := VerifyStorage(dir, false)
+	if err != nil {
+		t.Fatalf("VerifyStorage failed: %v", err)
+	}
+	want := map[string]string{
+		"certificates/web.json":   StorageMissingMetadata,
+		"certificates/mail.key":   StorageKeyMismatch,
+		"certificates/orphan.crt": StorageMissingCert,
+		"certificates/broken.crt": StorageUnparsable,
+	}
+	got := issueKinds(report)
+	for path, kind := range want {
+		if got[path] != kind {
+			t.Errorf("Expected %s for %s, got %q", kind, path, got[path])
+		}
+	}
+	if len(report.Issues) != len(want) {
+		t.Errorf("Expected %d issues, got %+v", len(want), report.Issues)
+	}
+
+	report, err = VerifyStorage(dir, true)
+	if err != nil {
+		t.Fatalf("VerifyStorage with repair failed: %v", err)
+	}
+	if report.Unresolved() != 3 {
+		t.Errorf("Expected only the metadata to be repaired, got %+v", report.Issues)
+	}
+	if _, err := os.Stat(web.Metadata); err != nil {
+		t.Errorf("Expected regenerated metadata: %v", err)
+	}
+}
+
+func TestVerifyStorage_Permissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not checked on Windows")
+	}
+	dir := t.TempDir()
+	web := adoptTestCertificate(t, dir, "web")
+	if err := os.Chmod(web.PrivateKey, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(web.Certificate, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(dir, "certificates"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := VerifyStorage(dir, true)
+	if err != nil {
+		t.Fatalf("VerifyStorage failed: %v", err)
+	}
+	got := issueKinds(report)
+	for _, path := range []string{"certificates", "certificates/web.key", "certificates/web.crt"} {
+		if got[path] != StoragePermissions {
+			t.Errorf("Expected permission issue for %s, got %+v", path, report.Issues)
+		}
+	}
+	if report.Unresolved() != 0 {
+		t.Errorf("Expected all permissions repaired, got %+v", report.Issues)
+	}
+
+	for path, want := range map[string]os.FileMode{web.PrivateKey: 0600, web.Certificate: 0644, filepath.Join(dir, "certificates"): 0770} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("Expected mode %04o for %s, got %04o", want, path, info.Mode().Perm())
+		}
+	}
+}
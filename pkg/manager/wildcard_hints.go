@@ -0,0 +1,87 @@
+package manager
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// WildcardHint is a certificate listing names that a wildcard of the same
+// certificate already covers
+type WildcardHint struct {
+	CertName  string   `json:"cert_name"`
+	Wildcards []string `json:"wildcards"` // Wildcards that cover the redundant names
+	Redundant []string `json:"redundant"` // Names a wildcard covers, in configuration order
+	Optimized []string `json:"optimized"` // The domains without the redundant names
+}
+
+// String describes the hint in one line
+func (h WildcardHint) String() string {
+	return fmt.Sprintf("certificate %s: %s already covers %s, listing them adds %d SAN(s) and authorization(s) without need",
+		h.CertName, strings.Join(h.Wildcards, ", "), strings.Join(h.Redundant, ", "), len(h.Redundant))
+}
+
+// coveringWildcard returns the wildcard of wildcards that covers domain, ""
+// if there is none. A wildcard covers the names one label below it, so
+// *.example.com covers www.example.com but neither example.com nor
+// a.b.example.com.
+func coveringWildcard(domain string, wildcards map[string]string) string {
+	domain = strings.ToLower(domain)
+	if strings.HasPrefix(domain, "*.") {
+		return ""
+	}
+	_, parent, ok := strings.Cut(domain, ".")
+	if !ok {
+		return ""
+	}
+	return wildcards[parent]
+}
+
+// OptimizeWildcardSANs returns the hint for one certificate, nil if no name
+// is covered by a wildcard of the same list
+func OptimizeWildcardSANs(certName string, domains []string) *WildcardHint {
+	wildcards := make(map[string]string)
+	for _, domain := range domains {
+		if base, ok := strings.CutPrefix(strings.ToLower(domain), "*."); ok {
+			wildcards[base] = domain
+		}
+	}
+	if len(wildcards) == 0 {
+		return nil
+	}
+	hint := &WildcardHint{CertName: certName}
+	used := make(map[string]bool)
+	for _, domain := range domains {
+		if wildcard := coveringWildcard(domain, wildcards); wildcard != "" {
+			hint.Redundant = append(hint.Redundant, domain)
+			if !used[wildcard] {
+				used[wildcard] = true
+				hint.Wildcards = append(hint.Wildcards, wildcard)
+			}
+			continue
+		}
+		hint.Optimized = append(hint.Optimized, domain)
+	}
+	if len(hint.Redundant) == 0 {
+		return nil
+	}
+	return hint
+}
+
+// WildcardHints returns the auto_domains certificates that list names their
+// own wildcards cover, sorted by certificate name. Dropping those names
+// makes the certificate smaller and every order needs fewer DNS challenges
+// and authorizations, which count against the limits of the CA.
+func WildcardHints(cfg *Config) []WildcardHint {
+	if cfg.AutoDomains == nil {
+		return nil
+	}
+	var hints []WildcardHint
+	for name, certCfg := range cfg.AutoDomains.Certs {
+		if hint := OptimizeWildcardSANs(name, certCfg.Domains); hint != nil {
+			hints = append(hints, *hint)
+		}
+	}
+	sort.Slice(hints, func(i, j int) bool { return hints[i].CertName < hints[j].CertName })
+	return hints
+}
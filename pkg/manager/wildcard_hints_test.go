@@ -0,0 +1,43 @@
+package manager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOptimizeWildcardSANs(t *testing.T) {
+	hint := OptimizeWildcardSANs("web", []string{"example.com", "*.example.com", "www.example.com", "API.example.com", "a.b.example.com", "*.example.org", "mail.example.org"})
+	if hint == nil {
+		t.Fatal("Expected a hint")
+	}
+	if want := []string{"www.example.com", "API.example.com", "mail.example.org"}; !reflect.DeepEqual(hint.Redundant, want) {
+		t.Errorf("Expected redundant %v, got %v", want, hint.Redundant)
+	}
+	if want := []string{"*.example.com", "*.example.org"}; !reflect.DeepEqual(hint.Wildcards, want) {
+		t.Errorf("Expected wildcards %v, got %v", want, hint.Wildcards)
+	}
+	if want := []string{"example.com", "*.example.com", "a.b.example.com", "*.example.org"}; !reflect.DeepEqual(hint.Optimized, want) {
+		t.Errorf("Expected optimized %v, got %v", want, hint.Optimized)
+	}
+
+	for _, domains := range [][]string{
+		{"example.com", "www.example.com"},
+		{"*.example.com", "example.com", "a.b.example.com"},
+	} {
+		if hint := OptimizeWildcardSANs("web", domains); hint != nil {
+			t.Errorf("%v: expected no hint, got %+v", domains, hint)
+		}
+	}
+}
+
+func TestWildcardHints(t *testing.T) {
+	cfg := &Config{AutoDomains: &AutoDomainsConfig{Certs: map[string]CertConfig{
+		"web":   {Domains: []string{"*.example.com", "www.example.com"}},
+		"api":   {Domains: []string{"*.example.net", "api.example.net"}},
+		"plain": {Domains: []string{"example.org", "www.example.org"}},
+	}}}
+	hints := WildcardHints(cfg)
+	if len(hints) != 2 || hints[0].CertName != "api" || hints[1].CertName != "web" {
+		t.Errorf("Expected hints for api and web, got %+v", hints)
+	}
+}
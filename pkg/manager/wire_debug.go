@@ -0,0 +1,111 @@
+package manager
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// wireBodyLimit is the longest body shown by the -debug-acme wire log
+const wireBodyLimit = 2048
+
+// wireRedactedHeaders carry credentials: ACME bearer tokens and the acme-dns
+// API user and key
+var wireRedactedHeaders = map[string]bool{
+	"Authorization": true,
+	"X-Api-User":    true,
+	"X-Api-Key":     true,
+}
+
+// wirePasswordPattern matches the password of an acme-dns registration response
+var wirePasswordPattern = regexp.MustCompile(`("password"\s*:\s*)"[^"]*"`)
+
+// wireLogTransport logs every request and response at debug level, with
+// credentials and signed JWS bodies redacted
+type wireLogTransport struct {
+	next http.RoundTripper
+}
+
+// debugTransport returns next wrapped in the wire log if -debug-acme is set,
+// otherwise next itself. A nil next stands for http.DefaultTransport, so the
+// result can be used as http.Client.Transport either way.
+func (cfg *Config) debugTransport(next http.RoundTripper) http.RoundTripper {
+	if !cfg.DebugACME {
+		return next
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &wireLogTransport{next: next}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *wireLogTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	DefaultLogger.Debugf("ACME wire > %s %s%s%s", req.Method, req.URL, wireHeaders(req.Header, ">"),
+		wireBody(req.Header.Get("Content-Type"), body, ">"))
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		DefaultLogger.Debugf("ACME wire < %s %s failed after %s: %v", req.Method, req.URL, time.Since(start).Round(time.Millisecond), err)
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	if err != nil {
+		return nil, err
+	}
+	DefaultLogger.Debugf("ACME wire < %s %s: %s (%s)%s%s", req.Method, req.URL, resp.Status, time.Since(start).Round(time.Millisecond),
+		wireHeaders(resp.Header, "<"), wireBody(resp.Header.Get("Content-Type"), respBody, "<"))
+	return resp, nil
+}
+
+// wireHeaders formats headers one per line in a stable order
+func wireHeaders(header http.Header, direction string) string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		value := strings.Join(header[name], ", ")
+		if wireRedactedHeaders[http.CanonicalHeaderKey(name)] {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(&b, "\n  %s %s: %s", direction, name, value)
+	}
+	return b.String()
+}
+
+// wireBody formats a body for the wire log. JWS bodies are signed with the
+// account key and are not shown, passwords in JSON are replaced.
+func wireBody(contentType string, body []byte, direction string) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if strings.HasPrefix(contentType, "application/jose+json") {
+		return fmt.Sprintf("\n  %s [JWS body redacted, %d bytes]", direction, len(body))
+	}
+	// Redacted before truncating, so no password is cut in half
+	text := wirePasswordPattern.ReplaceAllString(string(body), `$1"[redacted]"`)
+	if len(text) > wireBodyLimit {
+		text = text[:wireBodyLimit] + fmt.Sprintf(" ... (%d bytes)", len(body))
+	}
+	return fmt.Sprintf("\n  %s %s", direction, text)
+}
@@ -0,0 +1,80 @@
+package manager
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugTransport(t *testing.T) {
+	oldLogger := DefaultLogger
+	defer func() { DefaultLogger = oldLogger }()
+	var buf bytes.Buffer
+	DefaultLogger = NewColorfulLogger(&buf, LogLevelDebug, false, false)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/register" {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"username": "u", "password": "s3cret", "fulldomain": "x.acme-dns.example.com"}`)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "received %d bytes", len(body))
+	}))
+	defer server.Close()
+
+	cfg := &Config{}
+	if transport := cfg.debugTransport(nil); transport != nil {
+		t.Fatalf("Expected no wire log without -debug-acme, got %T", transport)
+	}
+	cfg.DebugACME = true
+	client := &http.Client{Transport: cfg.debugTransport(nil)}
+
+	// Signed ACME requests are redacted, the body still reaches the server
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/acme/new-order", strings.NewReader(`{"protected":"p","payload":"x","signature":"s"}`))
+	req.Header.Set("Content-Type", "application/jose+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	received, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(received) != "received 47 bytes" {
+		t.Errorf("Expected the request body to be forwarded, got %q", received)
+	}
+
+	// acme-dns credentials are redacted in headers and responses
+	req, _ = http.NewRequest(http.MethodPost, server.URL+"/register", nil)
+	req.Header.Set("X-Api-Key", "apikey")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	registered, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if !strings.Contains(string(registered), "s3cret") {
+		t.Errorf("Expected the caller to get the unredacted response, got %q", registered)
+	}
+
+	log := buf.String()
+	for _, want := range []string{
+		"ACME wire > POST " + server.URL + "/acme/new-order",
+		"> [JWS body redacted, 47 bytes]",
+		"ACME wire < POST " + server.URL + "/register: 200 OK",
+		"> X-Api-Key: [redacted]",
+		`"password": "[redacted]"`,
+	} {
+		if !strings.Contains(log, want) {
+			t.Errorf("Expected %q in wire log:\n%s", want, log)
+		}
+	}
+	for _, secret := range []string{`"signature":"s"`, "apikey", "s3cret"} {
+		if strings.Contains(log, secret) {
+			t.Errorf("Expected %q to be redacted:\n%s", secret, log)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+// Output formats accepted by Write
+const (
+	FormatJSON        = "json"
+	FormatOpenMetrics = "openmetrics"
+)
+
+// metricPrefix is prepended to every OpenMetrics metric name
+const metricPrefix = "acme_dns_manager_"
+
+// Write renders the snapshot in the given format
+func Write(w io.Writer, snap *Snapshot, format string) error {
+	switch format {
+	case FormatJSON:
+		return WriteJSON(w, snap)
+	case FormatOpenMetrics:
+		return WriteOpenMetrics(w, snap)
+	default:
+		return fmt.Errorf("unknown metrics format %q (use %s or %s)", format, FormatJSON, FormatOpenMetrics)
+	}
+}
+
+// WriteJSON renders the snapshot as indented JSON
+func WriteJSON(w io.Writer, snap *Snapshot) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(snap)
+}
+
+// WriteOpenMetrics renders the snapshot in the OpenMetrics text format
+func WriteOpenMetrics(w io.Writer, snap *Snapshot) error {
+	om := &openMetricsWriter{w: w}
+	om.snapshot(snap)
+	om.printf("# EOF\n")
+	return om.err
+}
+
+// snapshot writes the metric families of snap
+func (o *openMetricsWriter) snapshot(snap *Snapshot) {
+	o.family("certificates", "gauge", "Number of stored or configured certificates")
+	o.sample("certificates", "", float64(len(snap.Certificates)))
+
+	perCert := []struct {
+		name, help string
+		value      func(CertificateMetrics) (float64, bool)
+	}{
+		{"certificate_expiry_timestamp_seconds", "Expiry of the certificate as Unix timestamp",
+			func(c CertificateMetrics) (float64, bool) {
+				if c.NotAfter == nil {
+					return 0, false
+				}
+				return float64(c.NotAfter.Unix()), true
+			}},
+		{"certificate_expiry_seconds", "Seconds until the certificate expires, negative when expired",
+			func(c CertificateMetrics) (float64, bool) { return c.ExpirySeconds, c.Present }},
+		{"certificate_domains", "Number of domains in the certificate",
+			func(c CertificateMetrics) (float64, bool) { return float64(c.Domains), true }},
+		{"certificate_present", "1 if the certificate file exists and is readable",
+			func(c CertificateMetrics) (float64, bool) { return boolValue(c.Present), true }},
+		{"certificate_complete", "1 if certificate, private key and metadata are all present",
+			func(c CertificateMetrics) (float64, bool) { return boolValue(c.Complete), true }},
+		{"certificate_configured", "1 if the certificate is listed in auto_domains",
+			func(c CertificateMetrics) (float64, bool) { return boolValue(c.Configured), true }},
+		{"certificate_failure_streak", "Failed issuance attempts since the current certificate was issued",
+			func(c CertificateMetrics) (float64, bool) { return float64(c.FailureStreak), true }},
+		{"certificate_last_failure_timestamp_seconds", "Time of the last failed issuance attempt as Unix timestamp",
+			func(c CertificateMetrics) (float64, bool) {
+				if c.LastFailure == nil {
+					return 0, false
+				}
+				return float64(c.LastFailure.Unix()), true
+			}},
+		{"certificate_revoked", "1 if the last OCSP check found the certificate revoked, 0 if good",
+			func(c CertificateMetrics) (float64, bool) {
+				switch c.OCSPStatus {
+				case manager.OCSPStatusRevoked:
+					return 1, true
+				case manager.OCSPStatusGood:
+					return 0, true
+				}
+				return 0, false
+			}},
+	}
+	for _, metric := range perCert {
+		o.family(metric.name, "gauge", metric.help)
+		for _, c := range snap.Certificates {
+			if v, ok := metric.value(c); ok {
+				o.sample(metric.name, `cert="`+escapeLabel(c.Name)+`"`, v)
+			}
+		}
+	}
+
+	o.family("acme_dns_accounts", "gauge", "Number of registered acme-dns accounts")
+	o.sample("acme_dns_accounts", "", float64(snap.Accounts.AcmeDNS))
+	o.family("acme_accounts", "gauge", "Number of ACME server registrations")
+	o.sample("acme_accounts", "", float64(snap.Accounts.ACME))
+
+	o.family("storage_bytes", "gauge", "Total size of files in the storage directory")
+	o.sample("storage_bytes", "", float64(snap.Storage.Bytes))
+	o.family("storage_files", "gauge", "Number of files in the storage directory")
+	o.sample("storage_files", "", float64(snap.Storage.Files))
+	o.family("quarantined_artifacts", "gauge", "Number of quarantined failed issuance attempts")
+	o.sample("quarantined_artifacts", "", float64(snap.Storage.Quarantined))
+
+	o.family("snapshot_timestamp_seconds", "gauge", "Time the metrics were collected as Unix timestamp")
+	o.sample("snapshot_timestamp_seconds", "", float64(snap.GeneratedAt.Unix()))
+}
+
+// openMetricsWriter remembers the first write error so rendering code stays linear
+type openMetricsWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (o *openMetricsWriter) printf(format string, args ...interface{}) {
+	if o.err == nil {
+		_, o.err = fmt.Fprintf(o.w, format, args...)
+	}
+}
+
+func (o *openMetricsWriter) family(name, metricType, help string) {
+	o.printf("# TYPE %s%s %s\n", metricPrefix, name, metricType)
+	o.printf("# HELP %s%s %s\n", metricPrefix, name, help)
+}
+
+func (o *openMetricsWriter) sample(name, labels string, value float64) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	o.printf("%s%s%s %s\n", metricPrefix, name, labels, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// escapeLabel escapes a label value as required by the text format
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
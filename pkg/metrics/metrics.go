@@ -0,0 +1,200 @@
+// Package metrics computes a point-in-time snapshot of everything a monitoring
+// system may want to know about a go-acme-dns-manager installation: certificate
+// expiry, failure streaks, account counts and storage usage. The snapshot is
+// derived from the storage directory alone, so it can be produced without
+// contacting the ACME or acme-dns servers.
+package metrics
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+// Snapshot holds all metrics collected in one run
+type Snapshot struct {
+	GeneratedAt  time.Time            `json:"generated_at"`
+	Certificates []CertificateMetrics `json:"certificates"`
+	Accounts     AccountMetrics       `json:"accounts"`
+	Storage      StorageMetrics       `json:"storage"`
+}
+
+// CertificateMetrics describes one stored or configured certificate
+type CertificateMetrics struct {
+	Name       string `json:"name"`
+	Configured bool   `json:"configured"` // Listed in auto_domains
+	Present    bool   `json:"present"`    // Certificate file exists and parses
+	Complete   bool   `json:"complete"`   // Certificate, key and metadata present
+	Domains    int    `json:"domains"`
+
+	NotAfter      *time.Time `json:"not_after,omitempty"`
+	ExpirySeconds float64    `json:"expiry_seconds"` // Seconds until expiry, negative when expired
+	Expired       bool       `json:"expired"`
+
+	// FailureStreak counts failed issuance attempts quarantined since the
+	// current certificate was issued (or ever, if there is none)
+	FailureStreak int        `json:"failure_streak"`
+	LastFailure   *time.Time `json:"last_failure,omitempty"`
+
+	// OCSPStatus is the result of the last OCSP check of the current
+	// certificate (good, revoked, unknown or unsupported), empty if unchecked
+	OCSPStatus    string     `json:"ocsp_status,omitempty"`
+	OCSPCheckedAt *time.Time `json:"ocsp_checked_at,omitempty"`
+}
+
+// AccountMetrics counts the accounts stored below the storage path
+type AccountMetrics struct {
+	AcmeDNS int `json:"acme_dns"` // Registered acme-dns accounts
+	ACME    int `json:"acme"`     // ACME server registrations
+}
+
+// StorageMetrics describes the storage directory
+type StorageMetrics struct {
+	Bytes       int64 `json:"bytes"`
+	Files       int   `json:"files"`
+	Quarantined int   `json:"quarantined"` // Directories below failed/
+}
+
+// Collect builds a snapshot from the configured storage path
+func Collect(cfg *manager.Config, now time.Time) (*Snapshot, error) {
+	snap := &Snapshot{GeneratedAt: now.UTC()}
+
+	stored, err := certinfo.List(cfg.CertStoragePath)
+	if err != nil {
+		return nil, err
+	}
+	quarantined, err := manager.ListQuarantined(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	certs := make(map[string]*CertificateMetrics)
+	get := func(name string) *CertificateMetrics {
+		if m, ok := certs[name]; ok {
+			return m
+		}
+		m := &CertificateMetrics{Name: name}
+		certs[name] = m
+		return m
+	}
+
+	if cfg.AutoDomains != nil {
+		for name, certCfg := range cfg.AutoDomains.Certs {
+			m := get(name)
+			m.Configured = true
+			m.Domains = len(certCfg.Domains)
+		}
+	}
+
+	issued := make(map[string]time.Time)
+	serials := make(map[string]string)
+	for _, name := range stored {
+		m := get(name)
+		artifact, err := manager.LoadArtifact(cfg.CertStoragePath, name)
+		if err != nil || artifact.Info == nil {
+			continue
+		}
+		notAfter := artifact.Info.NotAfter
+		m.Present = true
+		m.Complete = artifact.Complete()
+		m.Domains = len(artifact.Info.DNSNames)
+		m.NotAfter = &notAfter
+		m.ExpirySeconds = artifact.Info.TimeLeft(now).Seconds()
+		m.Expired = artifact.Info.IsExpired(now)
+		issued[name] = artifact.Info.NotBefore
+		serials[name] = artifact.Info.SerialNumber
+	}
+
+	// OCSP results of an earlier certificate of the same name are outdated
+	state, err := manager.LoadState(cfg.CertStoragePath)
+	if err != nil {
+		return nil, err
+	}
+	for name, certState := range state.Certificates {
+		m, ok := certs[name]
+		if !ok || !m.Present || certState.OCSP == nil || certState.OCSP.Serial != serials[name] {
+			continue
+		}
+		checkedAt := certState.OCSP.CheckedAt
+		m.OCSPStatus = certState.OCSP.Status
+		m.OCSPCheckedAt = &checkedAt
+	}
+
+	// Quarantined attempts are sorted oldest first
+	for _, q := range quarantined {
+		m := get(q.CertName)
+		failed := q.Time
+		m.LastFailure = &failed
+		// Only failures after the current certificate was issued count towards the streak
+		if notBefore, ok := issued[q.CertName]; !ok || q.Time.After(notBefore) {
+			m.FailureStreak++
+		}
+	}
+
+	snap.Storage.Quarantined = len(quarantined)
+	snap.Certificates = make([]CertificateMetrics, 0, len(certs))
+	for _, m := range certs {
+		snap.Certificates = append(snap.Certificates, *m)
+	}
+	sort.Slice(snap.Certificates, func(i, j int) bool {
+		return snap.Certificates[i].Name < snap.Certificates[j].Name
+	})
+
+	if snap.Accounts, err = collectAccounts(cfg); err != nil {
+		return nil, err
+	}
+	if err := collectStorage(cfg.CertStoragePath, &snap.Storage); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// collectAccounts counts acme-dns credentials and ACME registrations
+func collectAccounts(cfg *manager.Config) (AccountMetrics, error) {
+	var m AccountMetrics
+
+	store, err := manager.NewLocalAccountStore(cfg)
+	if err != nil {
+		return m, fmt.Errorf("loading acme-dns accounts: %w", err)
+	}
+	m.AcmeDNS = len(store.GetAllAccounts())
+
+	registrations, err := filepath.Glob(filepath.Join(cfg.CertStoragePath, "accounts", "*", "account.json"))
+	if err != nil {
+		return m, err
+	}
+	m.ACME = len(registrations)
+	return m, nil
+}
+
+// collectStorage sums up size and number of regular files below the storage path
+func collectStorage(root string, m *StorageMetrics) error {
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		m.Files++
+		m.Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("scanning storage directory: %w", err)
+	}
+	return nil
+}
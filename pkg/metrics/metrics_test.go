@@ -0,0 +1,265 @@
+package metrics
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oetiker/go-acme-dns-manager/pkg/certinfo"
+	"github.com/oetiker/go-acme-dns-manager/pkg/manager"
+)
+
+// writeCert stores a self-signed certificate, key and metadata under the given name
+func writeCert(t *testing.T, storage, name string, notBefore, notAfter time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name + ".example.com"},
+		DNSNames:     []string{name + ".example.com", "www." + name + ".example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	paths := certinfo.PathsFor(storage, name)
+	if err := os.MkdirAll(certinfo.CertificatesDir(storage), 0750); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		paths.Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		paths.PrivateKey:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		paths.Metadata:    []byte(`{}`),
+	}
+	for path, data := range files {
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// quarantineAt creates a quarantine directory for name at the given time
+func quarantineAt(t *testing.T, storage, name string, at time.Time) {
+	t.Helper()
+	dir := filepath.Join(storage, manager.FailedDirName, name+"-"+at.UTC().Format("20060102T150405Z"))
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), []byte("key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setupStorage(t *testing.T) (*manager.Config, time.Time) {
+	t.Helper()
+	storage := t.TempDir()
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// "web" was issued 10 days ago, one failure before and two after
+	writeCert(t, storage, "web", now.Add(-240*time.Hour), now.Add(80*24*time.Hour))
+	quarantineAt(t, storage, "web", now.Add(-300*time.Hour))
+	quarantineAt(t, storage, "web", now.Add(-48*time.Hour))
+	quarantineAt(t, storage, "web", now.Add(-24*time.Hour))
+
+	// "old" is expired
+	writeCert(t, storage, "old", now.Add(-100*24*time.Hour), now.Add(-time.Hour))
+
+	// "new" is configured but was never issued and failed once
+	quarantineAt(t, storage, "new", now.Add(-time.Hour))
+
+	accounts := `{"web.example.com":{"username":"u","password":"p","fulldomain":"x.auth.example.org","subdomain":"x","allowfrom":[]}}`
+	if err := os.WriteFile(filepath.Join(storage, manager.AcmeDNSAccountsFile), []byte(accounts), 0600); err != nil {
+		t.Fatal(err)
+	}
+	regDir := filepath.Join(storage, "accounts", "acme-v02.api.letsencrypt.org")
+	if err := os.MkdirAll(regDir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(regDir, "account.json"), []byte(`{}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &manager.Config{
+		CertStoragePath: storage,
+		AutoDomains: &manager.AutoDomainsConfig{Certs: map[string]manager.CertConfig{
+			"web": {Domains: []string{"web.example.com", "www.web.example.com"}},
+			"new": {Domains: []string{"new.example.com"}},
+		}},
+	}
+	return cfg, now
+}
+
+func TestCollect(t *testing.T) {
+	cfg, now := setupStorage(t)
+
+	snap, err := Collect(cfg, now)
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	if len(snap.Certificates) != 3 {
+		t.Fatalf("Expected 3 certificates, got %d: %+v", len(snap.Certificates), snap.Certificates)
+	}
+	byName := make(map[string]CertificateMetrics)
+	for _, c := range snap.Certificates {
+		byName[c.Name] = c
+	}
+
+	web := byName["web"]
+	if !web.Configured || !web.Present || !web.Complete || web.Expired {
+		t.Errorf("Unexpected state for web: %+v", web)
+	}
+	if web.FailureStreak != 2 {
+		t.Errorf("Expected web failure streak 2 (failures after issuance), got %d", web.FailureStreak)
+	}
+	if web.ExpirySeconds != (80 * 24 * time.Hour).Seconds() {
+		t.Errorf("Expected web expiry in 80 days, got %v seconds", web.ExpirySeconds)
+	}
+	if web.LastFailure == nil || !web.LastFailure.Equal(now.Add(-24*time.Hour)) {
+		t.Errorf("Unexpected last failure for web: %v", web.LastFailure)
+	}
+
+	old := byName["old"]
+	if old.Configured || !old.Expired || old.ExpirySeconds >= 0 {
+		t.Errorf("Unexpected state for old: %+v", old)
+	}
+
+	newCert := byName["new"]
+	if !newCert.Configured || newCert.Present || newCert.FailureStreak != 1 || newCert.Domains != 1 {
+		t.Errorf("Unexpected state for new: %+v", newCert)
+	}
+
+	if snap.Accounts.AcmeDNS != 1 || snap.Accounts.ACME != 1 {
+		t.Errorf("Unexpected account counts: %+v", snap.Accounts)
+	}
+	if snap.Storage.Quarantined != 4 {
+		t.Errorf("Expected 4 quarantined artifacts, got %d", snap.Storage.Quarantined)
+	}
+	// 3 files per certificate, 4 quarantined keys, accounts file and registration
+	if snap.Storage.Files != 12 || snap.Storage.Bytes == 0 {
+		t.Errorf("Unexpected storage metrics: %+v", snap.Storage)
+	}
+}
+
+func TestCollect_EmptyStorage(t *testing.T) {
+	cfg := &manager.Config{CertStoragePath: filepath.Join(t.TempDir(), "missing")}
+	snap, err := Collect(cfg, time.Now())
+	if err != nil {
+		t.Fatalf("Expected empty snapshot for missing storage, got %v", err)
+	}
+	if len(snap.Certificates) != 0 || snap.Storage.Files != 0 {
+		t.Errorf("Expected empty snapshot, got %+v", snap)
+	}
+}
+
+func TestCollect_OCSP(t *testing.T) {
+	cfg, now := setupStorage(t)
+	checked := now.Add(-time.Hour)
+	if err := manager.RecordOCSP(cfg.CertStoragePath, "web", &manager.OCSPState{Status: manager.OCSPStatusRevoked, Serial: "1", CheckedAt: checked}); err != nil {
+		t.Fatal(err)
+	}
+	// A result for an earlier certificate is not reported
+	if err := manager.RecordOCSP(cfg.CertStoragePath, "old", &manager.OCSPState{Status: manager.OCSPStatusRevoked, Serial: "2", CheckedAt: checked}); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := Collect(cfg, now)
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	for _, c := range snap.Certificates {
+		switch c.Name {
+		case "web":
+			if c.OCSPStatus != manager.OCSPStatusRevoked || c.OCSPCheckedAt == nil || !c.OCSPCheckedAt.Equal(checked) {
+				t.Errorf("Expected revoked OCSP status for web, got %+v", c)
+			}
+		case "old":
+			if c.OCSPStatus != "" {
+				t.Errorf("Expected no OCSP status for old, got %q", c.OCSPStatus)
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	if err := WriteOpenMetrics(&out, snap); err != nil {
+		t.Fatalf("OpenMetrics output failed: %v", err)
+	}
+	if !strings.Contains(out.String(), `acme_dns_manager_certificate_revoked{cert="web"} 1`+"\n") {
+		t.Errorf("Expected revoked sample for web in:\n%s", out.String())
+	}
+	if strings.Contains(out.String(), `certificate_revoked{cert="old"}`) {
+		t.Error("Expected no revoked sample for an unchecked certificate")
+	}
+}
+
+func TestWrite_Formats(t *testing.T) {
+	cfg, now := setupStorage(t)
+	snap, err := Collect(cfg, now)
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	var jsonOut bytes.Buffer
+	if err := Write(&jsonOut, snap, FormatJSON); err != nil {
+		t.Fatalf("JSON output failed: %v", err)
+	}
+	var decoded Snapshot
+	if err := json.Unmarshal(jsonOut.Bytes(), &decoded); err != nil {
+		t.Fatalf("JSON output is not valid: %v", err)
+	}
+	if len(decoded.Certificates) != 3 {
+		t.Errorf("Expected 3 certificates in JSON, got %d", len(decoded.Certificates))
+	}
+
+	var omOut bytes.Buffer
+	if err := Write(&omOut, snap, FormatOpenMetrics); err != nil {
+		t.Fatalf("OpenMetrics output failed: %v", err)
+	}
+	text := omOut.String()
+	for _, want := range []string{
+		"# TYPE acme_dns_manager_certificate_expiry_seconds gauge\n",
+		`acme_dns_manager_certificate_expiry_seconds{cert="web"} 6912000` + "\n",
+		`acme_dns_manager_certificate_failure_streak{cert="new"} 1` + "\n",
+		`acme_dns_manager_certificate_present{cert="new"} 0` + "\n",
+		"acme_dns_manager_acme_dns_accounts 1\n",
+		"acme_dns_manager_quarantined_artifacts 4\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected OpenMetrics output to contain %q", want)
+		}
+	}
+	if strings.Contains(text, `certificate_expiry_seconds{cert="new"}`) {
+		t.Error("Expected no expiry sample for a certificate that was never issued")
+	}
+	if !strings.HasSuffix(text, "# EOF\n") {
+		t.Error("Expected OpenMetrics output to end with # EOF")
+	}
+
+	if err := Write(&omOut, snap, "xml"); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	if got := escapeLabel(`a"b\c` + "\n"); got != `a\"b\\c\n` {
+		t.Errorf("Unexpected escaped label: %s", got)
+	}
+}
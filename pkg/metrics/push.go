@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultPushJob is the job label of pushed metrics unless configured otherwise
+const DefaultPushJob = "go-acme-dns-manager"
+
+// pushTimeout limits the request to the Pushgateway
+const pushTimeout = 30 * time.Second
+
+// Run describes one certificate run for the Pushgateway
+type Run struct {
+	Started  time.Time
+	Duration time.Duration
+	Success  bool           // The run ended without error
+	Outcomes map[string]int // Number of certificates per outcome, e.g. renewed or failed
+	// CertDurations is the processing time of every certificate of the run
+	CertDurations map[string]time.Duration
+}
+
+// PushOptions selects the Pushgateway and the group the metrics replace
+type PushOptions struct {
+	URL       string // Base URL, e.g. http://pushgateway:9091
+	Job       string // Default DefaultPushJob
+	Instance  string // Instance label, omitted if empty
+	Username  string // Basic authentication, optional
+	Password  string
+	Client    *http.Client      // Default: client with a 30 second timeout
+	Transport http.RoundTripper // Transport of the default client, default http.DefaultTransport
+}
+
+// Push replaces the metrics of the job and instance group on the Pushgateway
+// with those of run and snap. A nil snap only pushes the run metrics.
+func Push(ctx context.Context, opts PushOptions, run Run, snap *Snapshot) error {
+	var body bytes.Buffer
+	if err := WritePush(&body, run, snap); err != nil {
+		return err
+	}
+
+	endpoint, err := pushURL(opts)
+	if err != nil {
+		return err
+	}
+	// PUT replaces the whole group, so certificates removed from the
+	// configuration do not linger
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if opts.Username != "" {
+		req.SetBasicAuth(opts.Username, opts.Password)
+	}
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: pushTimeout, Transport: opts.Transport}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("pushing metrics: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pushing metrics: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// WritePush renders the run metrics followed by the snapshot in the text
+// format the Pushgateway accepts
+func WritePush(w io.Writer, run Run, snap *Snapshot) error {
+	o := &openMetricsWriter{w: w}
+
+	o.family("last_run_timestamp_seconds", "gauge", "Start of the last run as Unix timestamp")
+	o.sample("last_run_timestamp_seconds", "", float64(run.Started.Unix()))
+	o.family("last_run_duration_seconds", "gauge", "Duration of the last run")
+	o.sample("last_run_duration_seconds", "", run.Duration.Seconds())
+	o.family("last_run_success", "gauge", "1 if the last run ended without error")
+	o.sample("last_run_success", "", boolValue(run.Success))
+
+	o.family("last_run_certificates", "gauge", "Certificates of the last run per outcome")
+	for _, outcome := range sortedKeys(run.Outcomes) {
+		o.sample("last_run_certificates", `outcome="`+escapeLabel(outcome)+`"`, float64(run.Outcomes[outcome]))
+	}
+	o.family("last_run_certificate_duration_seconds", "gauge", "Processing time of the certificate in the last run")
+	for _, name := range sortedKeys(run.CertDurations) {
+		o.sample("last_run_certificate_duration_seconds", `cert="`+escapeLabel(name)+`"`, run.CertDurations[name].Seconds())
+	}
+
+	if snap != nil {
+		var soonest *time.Time
+		for _, c := range snap.Certificates {
+			if c.NotAfter != nil && (soonest == nil || c.NotAfter.Before(*soonest)) {
+				soonest = c.NotAfter
+			}
+		}
+		if soonest != nil {
+			o.family("soonest_expiry_timestamp_seconds", "gauge", "Expiry of the certificate expiring first as Unix timestamp")
+			o.sample("soonest_expiry_timestamp_seconds", "", float64(soonest.Unix()))
+		}
+		o.snapshot(snap)
+	}
+	return o.err
+}
+
+// pushURL returns the URL of the group of opts, label values with a slash
+// are base64 encoded as the Pushgateway requires
+func pushURL(opts PushOptions) (string, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("pushgateway url must be an http(s) URL")
+	}
+	job := opts.Job
+	if job == "" {
+		job = DefaultPushJob
+	}
+	path := strings.TrimSuffix(u.Path, "/") + "/metrics" + groupingPath("job", job)
+	if opts.Instance != "" {
+		path += groupingPath("instance", opts.Instance)
+	}
+	return u.Scheme + "://" + u.Host + path, nil
+}
+
+func groupingPath(name, value string) string {
+	if strings.Contains(value, "/") {
+		return "/" + name + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+	return "/" + name + "/" + url.PathEscape(value)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
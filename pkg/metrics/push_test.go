@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPush(t *testing.T) {
+	cfg, now := setupStorage(t)
+	snap, err := Collect(cfg, now)
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	var method, path, body, user, password string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.EscapedPath()
+		user, password, _ = r.BasicAuth()
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer server.Close()
+
+	run := Run{
+		Started:       now.Add(-90 * time.Second),
+		Duration:      90 * time.Second,
+		Success:       false,
+		Outcomes:      map[string]int{"renewed": 1, "failed": 1, "skipped": 0},
+		CertDurations: map[string]time.Duration{"web": 40 * time.Second, "api": 1500 * time.Millisecond},
+	}
+	opts := PushOptions{URL: server.URL + "/", Instance: "web1", Username: "push", Password: "secret"}
+	if err := Push(context.Background(), opts, run, snap); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if method != http.MethodPut || path != "/metrics/job/go-acme-dns-manager/instance/web1" {
+		t.Errorf("Unexpected request %s %s", method, path)
+	}
+	if user != "push" || password != "secret" {
+		t.Errorf("Expected basic authentication, got %q/%q", user, password)
+	}
+	for _, want := range []string{
+		"acme_dns_manager_last_run_duration_seconds 90\n",
+		"acme_dns_manager_last_run_success 0\n",
+		`acme_dns_manager_last_run_certificates{outcome="renewed"} 1` + "\n",
+		`acme_dns_manager_last_run_certificates{outcome="skipped"} 0` + "\n",
+		`acme_dns_manager_last_run_certificate_duration_seconds{cert="api"} 1.5` + "\n",
+		"# TYPE acme_dns_manager_soonest_expiry_timestamp_seconds gauge\n",
+		`acme_dns_manager_certificate_expiry_seconds{cert="web"} 6912000` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected pushed metrics to contain %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "# EOF") {
+		t.Error("Expected no OpenMetrics EOF marker in the text format")
+	}
+}
+
+func TestPush_Errors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "pushed metrics are invalid", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	err := Push(context.Background(), PushOptions{URL: server.URL}, Run{Started: time.Now()}, nil)
+	if err == nil || !strings.Contains(err.Error(), "HTTP 400: pushed metrics are invalid") {
+		t.Errorf("Expected the Pushgateway error, got %v", err)
+	}
+	if err := Push(context.Background(), PushOptions{URL: "pushgateway:9091"}, Run{}, nil); err == nil {
+		t.Error("Expected error for a URL without scheme")
+	}
+}
+
+func TestPush_Transport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	proxyURL, _ := url.Parse(server.URL)
+
+	// The Pushgateway is only reachable through the proxy
+	transport := &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+	if err := Push(context.Background(), PushOptions{URL: "http://pushgateway.invalid:9091", Transport: transport}, Run{Started: time.Now()}, nil); err != nil {
+		t.Errorf("Expected the push to go through the transport, got %v", err)
+	}
+}
+
+func TestPushURL(t *testing.T) {
+	got, err := pushURL(PushOptions{URL: "https://push.example.com/prefix/", Job: "acme/prod", Instance: "web 1"})
+	if err != nil {
+		t.Fatalf("pushURL failed: %v", err)
+	}
+	if want := "https://push.example.com/prefix/metrics/job@base64/YWNtZS9wcm9k/instance/web%201"; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
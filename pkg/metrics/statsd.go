@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultStatsdPrefix is prepended to every statsd metric name unless
+// configured otherwise
+const DefaultStatsdPrefix = "acme_dns_manager."
+
+// StatsdClient sends counters and timings to a statsd or DogStatsD agent over
+// UDP. Sending never blocks and never fails, a lost sample is preferred over
+// a certificate run waiting for its monitoring.
+type StatsdClient struct {
+	conn   net.Conn
+	prefix string
+	tags   []string
+}
+
+// NewStatsdClient creates a client sending to addr (host:port). prefix is
+// prepended to metric names, tags ("key:value") are added to every sample in
+// the DogStatsD format.
+func NewStatsdClient(addr, prefix string, tags []string) (*StatsdClient, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %w", err)
+	}
+	return &StatsdClient{conn: conn, prefix: prefix, tags: tags}, nil
+}
+
+// Count adds value to a counter
+func (c *StatsdClient) Count(name string, value int64, tags ...string) {
+	c.send(name, strconv.FormatInt(value, 10), "c", tags)
+}
+
+// Timing records a duration in milliseconds
+func (c *StatsdClient) Timing(name string, d time.Duration, tags ...string) {
+	c.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64), "ms", tags)
+}
+
+// Close releases the socket
+func (c *StatsdClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *StatsdClient) send(name, value, metricType string, tags []string) {
+	var b strings.Builder
+	b.WriteString(c.prefix)
+	b.WriteString(name)
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(metricType)
+	all := append(append([]string{}, c.tags...), tags...)
+	if len(all) > 0 {
+		b.WriteString("|#")
+		for i, tag := range all {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(sanitizeStatsdTag(tag))
+		}
+	}
+	_, _ = c.conn.Write([]byte(b.String()))
+}
+
+// sanitizeStatsdTag replaces the characters that separate fields and tags in
+// the DogStatsD format
+func sanitizeStatsdTag(tag string) string {
+	return strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_").Replace(tag)
+}
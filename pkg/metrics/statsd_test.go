@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStatsdClient(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	client, err := NewStatsdClient(conn.LocalAddr().String(), DefaultStatsdPrefix, []string{"env:prod"})
+	if err != nil {
+		t.Fatalf("NewStatsdClient failed: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	client.Count("issuance.attempts", 1, "cert:web", "result:success")
+	client.Timing("acme.request", 1500*time.Microsecond, "status:a|b,c")
+	client.Count("dns_precheck.failures", 2)
+
+	buf := make([]byte, 512)
+	for _, want := range []string{
+		"acme_dns_manager.issuance.attempts:1|c|#env:prod,cert:web,result:success",
+		"acme_dns_manager.acme.request:1.5|ms|#env:prod,status:a_b_c",
+		"acme_dns_manager.dns_precheck.failures:2|c|#env:prod",
+	} {
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Failed to read sample: %v", err)
+		}
+		if got := string(buf[:n]); got != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestStatsdClient_NoTags(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	client, err := NewStatsdClient(conn.LocalAddr().String(), "", nil)
+	if err != nil {
+		t.Fatalf("NewStatsdClient failed: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+	client.Count("runs", 1)
+
+	buf := make([]byte, 512)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Failed to read sample: %v", err)
+	}
+	if got := string(buf[:n]); got != "runs:1|c" {
+		t.Errorf("Unexpected sample %q", got)
+	}
+}
@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxErrorBody limits how much of a failed response ends up in the error
+const maxErrorBody = 512
+
+// newHTTPClient returns the client of the webhook notifiers, a nil
+// transport means http.DefaultTransport
+func newHTTPClient(timeout time.Duration, transport http.RoundTripper) *http.Client {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// postJSON sends payload as JSON to endpoint and expects a 2xx answer
+func postJSON(ctx context.Context, client *http.Client, endpoint string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return post(ctx, client, endpoint, "application/json", bytes.NewReader(data))
+}
+
+// postForm sends values URL-encoded to endpoint and expects a 2xx answer
+func postForm(ctx context.Context, client *http.Client, endpoint string, values url.Values) error {
+	return post(ctx, client, endpoint, "application/x-www-form-urlencoded", strings.NewReader(values.Encode()))
+}
+
+func post(ctx context.Context, client *http.Client, endpoint, contentType string, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+// truncate shortens s to at most n characters for services limiting the
+// message length, marking the cut
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,25 @@
+// Package notify delivers messages meant for people, such as failure alerts
+// and the DNS records to create, by email, to phones through Pushover and to
+// Telegram or Microsoft Teams chats. Unlike the events package it does not
+// serve programs but the admins and DNS teams watching an unattended
+// installation.
+package notify
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultTimeout limits the delivery of one message
+const DefaultTimeout = 30 * time.Second
+
+// Message is one notification, a plain text body with a subject line
+type Message struct {
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages to people
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
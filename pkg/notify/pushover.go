@@ -0,0 +1,64 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// PushoverAPIURL is the message endpoint of the Pushover API
+const PushoverAPIURL = "https://api.pushover.net/1/messages.json"
+
+// Length limits of the Pushover API
+const (
+	pushoverMaxTitle   = 250
+	pushoverMaxMessage = 1024
+)
+
+// PushoverOptions configures a Pushover notifier
+type PushoverOptions struct {
+	Token     string // Application API token
+	User      string // User or group key
+	Priority  int    // -2 to 1, 0 is normal; 2 needs acknowledgement and is not supported
+	APIURL    string // Default PushoverAPIURL, for tests
+	Timeout   time.Duration
+	Transport http.RoundTripper // Default http.DefaultTransport
+}
+
+// PushoverNotifier sends messages to phones through Pushover
+type PushoverNotifier struct {
+	opts   PushoverOptions
+	client *http.Client
+}
+
+// NewPushoverNotifier validates the options and creates a Pushover notifier
+func NewPushoverNotifier(opts PushoverOptions) (*PushoverNotifier, error) {
+	if opts.Token == "" || opts.User == "" {
+		return nil, fmt.Errorf("pushover: token and user must not be empty")
+	}
+	if opts.Priority < -2 || opts.Priority > 1 {
+		return nil, fmt.Errorf("pushover: priority %d out of range -2 to 1", opts.Priority)
+	}
+	if opts.APIURL == "" {
+		opts.APIURL = PushoverAPIURL
+	}
+	return &PushoverNotifier{opts: opts, client: newHTTPClient(opts.Timeout, opts.Transport)}, nil
+}
+
+// Notify sends msg, shortened to the 1024 characters Pushover accepts
+func (n *PushoverNotifier) Notify(ctx context.Context, msg Message) error {
+	values := url.Values{
+		"token":    {n.opts.Token},
+		"user":     {n.opts.User},
+		"title":    {truncate(msg.Subject, pushoverMaxTitle)},
+		"message":  {truncate(msg.Body, pushoverMaxMessage)},
+		"priority": {strconv.Itoa(n.opts.Priority)},
+	}
+	if err := postForm(ctx, n.client, n.opts.APIURL, values); err != nil {
+		return fmt.Errorf("pushover: sending message: %w", err)
+	}
+	return nil
+}
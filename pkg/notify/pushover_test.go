@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNewPushoverNotifier(t *testing.T) {
+	if _, err := NewPushoverNotifier(PushoverOptions{Token: "app"}); err == nil {
+		t.Error("Expected an error without user key")
+	}
+	if _, err := NewPushoverNotifier(PushoverOptions{Token: "app", User: "user", Priority: 2}); err == nil {
+		t.Error("Expected an error for emergency priority")
+	}
+	n, err := NewPushoverNotifier(PushoverOptions{Token: "app", User: "user"})
+	if err != nil || n.opts.APIURL != PushoverAPIURL {
+		t.Errorf("Expected the public API by default, got %v", err)
+	}
+}
+
+func TestPushoverNotifier_Notify(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("Failed to parse form: %v", err)
+		}
+		form = r.PostForm
+		_, _ = w.Write([]byte(`{"status":1}`))
+	}))
+	defer server.Close()
+
+	n, err := NewPushoverNotifier(PushoverOptions{Token: "app", User: "user", Priority: 1, APIURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("x", 2000)
+	if err := n.Notify(context.Background(), Message{Subject: "1 certificate(s) failed", Body: long}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if form.Get("token") != "app" || form.Get("user") != "user" || form.Get("priority") != "1" || form.Get("title") != "1 certificate(s) failed" {
+		t.Errorf("Unexpected form %v", form)
+	}
+	if msg := []rune(form.Get("message")); len(msg) != pushoverMaxMessage || msg[len(msg)-1] != '…' {
+		t.Errorf("Expected the message cut to %d characters, got %d", pushoverMaxMessage, len(msg))
+	}
+}
+
+func TestPushoverNotifier_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"user":"invalid","errors":["user identifier is invalid"],"status":0}`))
+	}))
+	defer server.Close()
+
+	n, err := NewPushoverNotifier(PushoverOptions{Token: "app", User: "user", APIURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n.Notify(context.Background(), Message{Subject: "test", Body: "test"})
+	if err == nil || !strings.Contains(err.Error(), "HTTP 400") || !strings.Contains(err.Error(), "user identifier is invalid") {
+		t.Errorf("Expected the API error, got %v", err)
+	}
+}
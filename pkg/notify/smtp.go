@@ -0,0 +1,177 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Connection security of SMTPOptions.TLS
+const (
+	SMTPStartTLS = "starttls" // Upgrade a plain connection, the default (port 587)
+	SMTPTLS      = "tls"      // TLS from the first byte (port 465)
+	SMTPNoTLS    = "none"     // Plain text, only sensible for a relay on localhost (port 25)
+)
+
+// SMTPOptions configures an SMTP notifier
+type SMTPOptions struct {
+	Host     string
+	Port     int    // Default depends on TLS: 587, 465 or 25
+	Username string // PLAIN authentication, optional
+	Password string
+	From     string   // Sender address, may include a name
+	To       []string // Recipient addresses
+	TLS      string   // starttls (default), tls or none
+	Timeout  time.Duration
+}
+
+// SMTPNotifier sends messages as plain text email
+type SMTPNotifier struct {
+	opts SMTPOptions
+	from *mail.Address
+	to   []*mail.Address
+}
+
+// NewSMTPNotifier validates the options and creates an SMTP notifier
+func NewSMTPNotifier(opts SMTPOptions) (*SMTPNotifier, error) {
+	if opts.Host == "" {
+		return nil, fmt.Errorf("smtp: host must not be empty")
+	}
+	if opts.TLS == "" {
+		opts.TLS = SMTPStartTLS
+	}
+	if opts.Port == 0 {
+		switch opts.TLS {
+		case SMTPTLS:
+			opts.Port = 465
+		case SMTPNoTLS:
+			opts.Port = 25
+		default:
+			opts.Port = 587
+		}
+	}
+	switch opts.TLS {
+	case SMTPStartTLS, SMTPTLS, SMTPNoTLS:
+	default:
+		return nil, fmt.Errorf("smtp: unknown tls mode %q, use starttls, tls or none", opts.TLS)
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	from, err := mail.ParseAddress(opts.From)
+	if err != nil {
+		return nil, fmt.Errorf("smtp: invalid from address %q: %w", opts.From, err)
+	}
+	if len(opts.To) == 0 {
+		return nil, fmt.Errorf("smtp: at least one recipient is required")
+	}
+	to := make([]*mail.Address, 0, len(opts.To))
+	for _, addr := range opts.To {
+		parsed, err := mail.ParseAddress(addr)
+		if err != nil {
+			return nil, fmt.Errorf("smtp: invalid recipient %q: %w", addr, err)
+		}
+		to = append(to, parsed)
+	}
+
+	return &SMTPNotifier{opts: opts, from: from, to: to}, nil
+}
+
+// Notify sends msg to all recipients in one mail. Authentication is refused
+// on unencrypted connections to anything but localhost.
+func (n *SMTPNotifier) Notify(ctx context.Context, msg Message) error {
+	ctx, cancel := context.WithTimeout(ctx, n.opts.Timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(n.opts.Host, strconv.Itoa(n.opts.Port))
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("smtp: connecting to %s: %w", addr, err)
+	}
+	defer func() { _ = conn.Close() }()
+	// net/smtp knows no contexts, closing the connection aborts it
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	tlsConfig := &tls.Config{ServerName: n.opts.Host, MinVersion: tls.VersionTLS12}
+	if n.opts.TLS == SMTPTLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	client, err := smtp.NewClient(conn, n.opts.Host)
+	if err != nil {
+		return fmt.Errorf("smtp: greeting from %s: %w", addr, err)
+	}
+	defer func() { _ = client.Close() }()
+
+	if n.opts.TLS == SMTPStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp: %s does not offer STARTTLS, set tls to \"tls\" or \"none\"", addr)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("smtp: STARTTLS with %s: %w", addr, err)
+		}
+	}
+	if n.opts.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.opts.Username, n.opts.Password, n.opts.Host)); err != nil {
+			return fmt.Errorf("smtp: authenticating as %s: %w", n.opts.Username, err)
+		}
+	}
+
+	if err := client.Mail(n.from.Address); err != nil {
+		return fmt.Errorf("smtp: sender %s: %w", n.from.Address, err)
+	}
+	for _, rcpt := range n.to {
+		if err := client.Rcpt(rcpt.Address); err != nil {
+			return fmt.Errorf("smtp: recipient %s: %w", rcpt.Address, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp: sending message: %w", err)
+	}
+	if _, err := w.Write(n.compose(msg, time.Now())); err != nil {
+		return fmt.Errorf("smtp: sending message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp: sending message: %w", err)
+	}
+	return client.Quit()
+}
+
+// compose returns msg as RFC 5322 message with CRLF line endings
+func (n *SMTPNotifier) compose(msg Message, date time.Time) []byte {
+	to := make([]string, 0, len(n.to))
+	for _, addr := range n.to {
+		to = append(to, addr.String())
+	}
+
+	var buf bytes.Buffer
+	// Q-encoding also turns line breaks into encoded words, so the subject
+	// cannot add header lines
+	fmt.Fprintf(&buf, "From: %s\r\n", n.from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("Auto-Submitted: auto-generated\r\n")
+	buf.WriteString("\r\n")
+
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	for _, line := range strings.Split(strings.TrimRight(body, "\n"), "\n") {
+		buf.WriteString(line)
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes()
+}
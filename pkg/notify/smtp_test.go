@@ -0,0 +1,225 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTPServer accepts a single connection and records the mail it receives
+type fakeSMTPServer struct {
+	listener net.Listener
+	auth     chan string
+	from     chan string
+	rcpt     chan []string
+	data     chan string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &fakeSMTPServer{
+		listener: l,
+		auth:     make(chan string, 1),
+		from:     make(chan string, 1),
+		rcpt:     make(chan []string, 1),
+		data:     make(chan string, 1),
+	}
+	go s.serve()
+	t.Cleanup(func() { _ = l.Close() })
+	return s
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) serve() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+	reader := bufio.NewReader(conn)
+
+	reply("220 mail.example.com ESMTP")
+	var rcpts []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO":
+			reply("250-mail.example.com")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			// AUTH PLAIN <base64 of \x00user\x00password>
+			decoded, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "AUTH PLAIN "))
+			s.auth <- string(decoded)
+			reply("235 Authentication successful")
+		case "MAIL":
+			s.from <- line
+			reply("250 OK")
+		case "RCPT":
+			rcpts = append(rcpts, line)
+			reply("250 OK")
+		case "DATA":
+			s.rcpt <- rcpts
+			reply("354 Go ahead")
+			var data strings.Builder
+			for {
+				l, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.data <- data.String()
+			reply("250 Queued")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Not implemented")
+		}
+	}
+}
+
+func TestNewSMTPNotifier(t *testing.T) {
+	valid := SMTPOptions{Host: "mail.example.com", From: "acme@example.com", To: []string{"dns@example.com"}}
+	n, err := NewSMTPNotifier(valid)
+	if err != nil {
+		t.Fatalf("Expected valid options, got %v", err)
+	}
+	if n.opts.TLS != SMTPStartTLS || n.opts.Port != 587 || n.opts.Timeout != DefaultTimeout {
+		t.Errorf("Expected STARTTLS on port 587 by default, got %+v", n.opts)
+	}
+
+	implicit := valid
+	implicit.TLS = SMTPTLS
+	if n, err := NewSMTPNotifier(implicit); err != nil || n.opts.Port != 465 {
+		t.Errorf("Expected port 465 for tls, got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*SMTPOptions)
+	}{
+		{"no host", func(o *SMTPOptions) { o.Host = "" }},
+		{"bad from", func(o *SMTPOptions) { o.From = "not an address" }},
+		{"no recipients", func(o *SMTPOptions) { o.To = nil }},
+		{"bad recipient", func(o *SMTPOptions) { o.To = []string{"dns@"} }},
+		{"bad tls", func(o *SMTPOptions) { o.TLS = "ssl" }},
+	}
+	for _, tt := range tests {
+		opts := valid
+		opts.To = append([]string(nil), valid.To...)
+		tt.modify(&opts)
+		if _, err := NewSMTPNotifier(opts); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestSMTPNotifier_Notify(t *testing.T) {
+	s := newFakeSMTPServer(t)
+	n, err := NewSMTPNotifier(SMTPOptions{
+		// Go's PLAIN auth allows unencrypted connections to localhost only
+		Host:     "127.0.0.1",
+		Port:     s.port(),
+		TLS:      SMTPNoTLS,
+		Username: "acme",
+		Password: "secret",
+		From:     "ACME Manager <acme@example.com>",
+		To:       []string{"dns@example.com", "ops@example.com"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create notifier: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg := Message{
+		Subject: "2 DNS records to create\r\nBcc: evil@example.com",
+		Body:    "_acme-challenge.example.com. IN CNAME abc.auth.example.org.\n.\nend\n",
+	}
+	if err := n.Notify(ctx, msg); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	if auth := <-s.auth; auth != "\x00acme\x00secret" {
+		t.Errorf("Unexpected credentials %q", auth)
+	}
+	if from := <-s.from; from != "MAIL FROM:<acme@example.com>" && !strings.HasPrefix(from, "MAIL FROM:<acme@example.com> ") {
+		t.Errorf("Unexpected sender %q", from)
+	}
+	if rcpt := <-s.rcpt; len(rcpt) != 2 || rcpt[1] != "RCPT TO:<ops@example.com>" {
+		t.Errorf("Unexpected recipients %v", rcpt)
+	}
+	data := <-s.data
+	headers, body, _ := strings.Cut(data, "\r\n\r\n")
+	for _, want := range []string{`From: "ACME Manager" <acme@example.com>`, "To: <dns@example.com>, <ops@example.com>", "Content-Type: text/plain; charset=utf-8"} {
+		if !strings.Contains(headers, want) {
+			t.Errorf("Expected header %q in\n%s", want, headers)
+		}
+	}
+	for _, line := range strings.Split(headers, "\r\n") {
+		if strings.HasPrefix(line, "Bcc:") {
+			t.Errorf("The subject must not add headers: %q", line)
+		}
+	}
+	// The lone dot is escaped on the wire
+	if body != "_acme-challenge.example.com. IN CNAME abc.auth.example.org.\r\n..\r\nend\r\n" {
+		t.Errorf("Unexpected body %q", body)
+	}
+}
+
+func TestSMTPNotifier_StartTLSRequired(t *testing.T) {
+	s := newFakeSMTPServer(t)
+	n, err := NewSMTPNotifier(SMTPOptions{
+		Host: "127.0.0.1",
+		Port: s.port(),
+		From: "acme@example.com",
+		To:   []string{"dns@example.com"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create notifier: %v", err)
+	}
+	err = n.Notify(context.Background(), Message{Subject: "test", Body: "test"})
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("Expected an error for a server without STARTTLS, got %v", err)
+	}
+}
+
+func TestSMTPNotifier_ConnectionRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	_ = l.Close()
+
+	n, err := NewSMTPNotifier(SMTPOptions{Host: "127.0.0.1", Port: port, TLS: SMTPNoTLS, From: "acme@example.com", To: []string{"dns@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n.Notify(context.Background(), Message{Subject: "test", Body: "test"})
+	if err == nil || !strings.Contains(err.Error(), "127.0.0.1:"+strconv.Itoa(port)) {
+		t.Errorf("Expected a connection error naming the server, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TeamsOptions configures a Microsoft Teams notifier
+type TeamsOptions struct {
+	WebhookURL string // Incoming webhook of a Teams workflow or connector
+	Timeout    time.Duration
+	Transport  http.RoundTripper // Default http.DefaultTransport
+}
+
+// TeamsNotifier posts messages to a Microsoft Teams channel as Adaptive Card
+type TeamsNotifier struct {
+	opts   TeamsOptions
+	client *http.Client
+}
+
+// NewTeamsNotifier validates the options and creates a Teams notifier
+func NewTeamsNotifier(opts TeamsOptions) (*TeamsNotifier, error) {
+	u, err := url.Parse(opts.WebhookURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("teams: webhook_url must be an http(s) URL")
+	}
+	return &TeamsNotifier{opts: opts, client: newHTTPClient(opts.Timeout, opts.Transport)}, nil
+}
+
+// Notify posts msg with one monospaced line per body line, so the DNS
+// records keep their layout
+func (n *TeamsNotifier) Notify(ctx context.Context, msg Message) error {
+	blocks := []map[string]interface{}{
+		{"type": "TextBlock", "text": msg.Subject, "weight": "Bolder", "size": "Medium", "wrap": true},
+	}
+	spacing := "Medium"
+	for _, line := range strings.Split(strings.TrimRight(msg.Body, "\n"), "\n") {
+		// Empty text blocks are invalid, a blank line widens the gap instead
+		if strings.TrimSpace(line) == "" {
+			spacing = "Medium"
+			continue
+		}
+		blocks = append(blocks, map[string]interface{}{
+			"type": "TextBlock", "text": line, "wrap": true, "fontType": "Monospace", "spacing": spacing,
+		})
+		spacing = "None"
+	}
+	payload := map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]interface{}{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body":    blocks,
+			},
+		}},
+	}
+	if err := postJSON(ctx, n.client, n.opts.WebhookURL, payload); err != nil {
+		// The webhook URL is a secret, keep it out of the log
+		return fmt.Errorf("teams: sending message: %s", strings.ReplaceAll(err.Error(), n.opts.WebhookURL, "webhook_url"))
+	}
+	return nil
+}
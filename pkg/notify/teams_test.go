@@ -0,0 +1,94 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTeamsNotifier_Notify(t *testing.T) {
+	var card struct {
+		Type        string `json:"type"`
+		Attachments []struct {
+			ContentType string `json:"contentType"`
+			Content     struct {
+				Type string `json:"type"`
+				Body []struct {
+					Text    string `json:"text"`
+					Spacing string `json:"spacing"`
+				} `json:"body"`
+			} `json:"content"`
+		} `json:"attachments"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&card); err != nil {
+			t.Errorf("Failed to decode card: %v", err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	if _, err := NewTeamsNotifier(TeamsOptions{WebhookURL: "teams.example.com/hook"}); err == nil {
+		t.Error("Expected an error for a webhook URL without scheme")
+	}
+	n, err := NewTeamsNotifier(TeamsOptions{WebhookURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), Message{Subject: "1 certificate(s) failed", Body: "Failed certificates:\n\n  web (failed): boom\n"}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if card.Type != "message" || len(card.Attachments) != 1 || card.Attachments[0].Content.Type != "AdaptiveCard" {
+		t.Fatalf("Unexpected card %+v", card)
+	}
+	body := card.Attachments[0].Content.Body
+	if len(body) != 3 || body[0].Text != "1 certificate(s) failed" || body[2].Text != "  web (failed): boom" || body[2].Spacing != "Medium" {
+		t.Errorf("Expected the subject and one block per non-empty line, got %+v", body)
+	}
+}
+
+func TestTeamsNotifier_ErrorHidesURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	webhook := server.URL + "/webhookb2/secret-id"
+	n, err := NewTeamsNotifier(TeamsOptions{WebhookURL: webhook})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n.Notify(context.Background(), Message{Subject: "test", Body: "test"})
+	if err == nil || !strings.Contains(err.Error(), "HTTP 404") {
+		t.Fatalf("Expected the HTTP error, got %v", err)
+	}
+	if strings.Contains(err.Error(), "secret-id") {
+		t.Errorf("The webhook URL must not be part of the error: %v", err)
+	}
+}
+
+// roundTripFunc is a transport answering from a function
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestTeamsNotifier_Transport(t *testing.T) {
+	var host string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		host = req.URL.Host
+		return &http.Response{StatusCode: http.StatusAccepted, Body: http.NoBody, Request: req}, nil
+	})
+	n, err := NewTeamsNotifier(TeamsOptions{WebhookURL: "https://teams.invalid/hook", Transport: transport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), Message{Subject: "test", Body: "test"}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if host != "teams.invalid" {
+		t.Errorf("Expected the message to go through the transport, got host %q", host)
+	}
+}
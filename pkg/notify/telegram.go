@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TelegramAPIURL is the base URL of the Telegram Bot API
+const TelegramAPIURL = "https://api.telegram.org"
+
+// telegramMaxText is the length limit of a Telegram message
+const telegramMaxText = 4096
+
+// TelegramOptions configures a Telegram notifier
+type TelegramOptions struct {
+	BotToken  string // Token from @BotFather
+	ChatID    string // Numeric chat ID or @channelname
+	APIURL    string // Default TelegramAPIURL, for tests
+	Timeout   time.Duration
+	Transport http.RoundTripper // Default http.DefaultTransport
+}
+
+// TelegramNotifier sends messages to a Telegram chat through a bot
+type TelegramNotifier struct {
+	opts   TelegramOptions
+	client *http.Client
+}
+
+// NewTelegramNotifier validates the options and creates a Telegram notifier
+func NewTelegramNotifier(opts TelegramOptions) (*TelegramNotifier, error) {
+	if opts.BotToken == "" || opts.ChatID == "" {
+		return nil, fmt.Errorf("telegram: bot_token and chat_id must not be empty")
+	}
+	if opts.APIURL == "" {
+		opts.APIURL = TelegramAPIURL
+	}
+	return &TelegramNotifier{opts: opts, client: newHTTPClient(opts.Timeout, opts.Transport)}, nil
+}
+
+// Notify sends msg as plain text, shortened to the 4096 characters Telegram accepts
+func (n *TelegramNotifier) Notify(ctx context.Context, msg Message) error {
+	endpoint := strings.TrimSuffix(n.opts.APIURL, "/") + "/bot" + n.opts.BotToken + "/sendMessage"
+	payload := map[string]interface{}{
+		"chat_id": n.opts.ChatID,
+		"text":    truncate(msg.Subject+"\n\n"+msg.Body, telegramMaxText),
+	}
+	if err := postJSON(ctx, n.client, endpoint, payload); err != nil {
+		// The URL holds the bot token, keep it out of the log
+		return fmt.Errorf("telegram: sending message: %s", strings.ReplaceAll(err.Error(), n.opts.BotToken, "xxxxx"))
+	}
+	return nil
+}
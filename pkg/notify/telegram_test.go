@@ -0,0 +1,61 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTelegramNotifier_Notify(t *testing.T) {
+	var path string
+	var payload map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("Failed to decode payload: %v", err)
+		}
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	if _, err := NewTelegramNotifier(TelegramOptions{BotToken: "123:abc"}); err == nil {
+		t.Error("Expected an error without chat ID")
+	}
+	n, err := NewTelegramNotifier(TelegramOptions{BotToken: "123:abc", ChatID: "-10042", APIURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), Message{Subject: "1 DNS record(s) to create", Body: "_acme-challenge.example.com. IN CNAME abc.auth.example.org.\n"}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if path != "/bot123:abc/sendMessage" {
+		t.Errorf("Unexpected path %s", path)
+	}
+	if payload["chat_id"] != "-10042" {
+		t.Errorf("Unexpected chat %q", payload["chat_id"])
+	}
+	if !strings.HasPrefix(payload["text"], "1 DNS record(s) to create\n\n_acme-challenge.example.com.") {
+		t.Errorf("Unexpected text %q", payload["text"])
+	}
+}
+
+func TestTelegramNotifier_ErrorHidesToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	n, err := NewTelegramNotifier(TelegramOptions{BotToken: "123:secret", ChatID: "42", APIURL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = n.Notify(context.Background(), Message{Subject: "test", Body: "test"})
+	if err == nil {
+		t.Fatal("Expected an error for an unreachable API")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("The bot token must not be part of the error: %v", err)
+	}
+}